@@ -5,9 +5,14 @@
 package v2
 
 import (
+	"crypto/ed25519"
 	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"regexp"
+	"time"
 )
 
 type ResultT int
@@ -57,6 +62,15 @@ var (
 	// VersionRoute defines a top-level API route for retrieving latest version
 	VersionRoute = "/version"
 
+	// HealthRoute defines a top-level API route for liveness probes. It
+	// answers as long as the process is alive.
+	HealthRoute = "/healthz"
+
+	// ReadyRoute defines a top-level API route for readiness probes. It
+	// answers with 503 Service Unavailable while the server is unable to
+	// timestamp digests.
+	ReadyRoute = "/readyz"
+
 	// StatusRoute defines the API route for retrieving
 	// the server status.
 	StatusRoute = RoutePrefix + "/status"
@@ -73,10 +87,42 @@ var (
 	// a batch of timestamps or digests.
 	TimestampBatchRoute = RoutePrefix + "/timestamp/batch" // Multi digest timestamping
 
+	// TimestampAggregateRoute defines the API route for timestamping a
+	// batch of digests as a single aggregate digest.
+	TimestampAggregateRoute = RoutePrefix + "/timestamp/aggregate"
+
+	// TimestampAsyncRoute defines the API route for queueing a batch of
+	// digests to be timestamped asynchronously.
+	TimestampAsyncRoute = RoutePrefix + "/timestamp/async"
+
+	// TicketRoute defines the API route for retrieving the status of an
+	// asynchronous submission.
+	TicketRoute = RoutePrefix + "/timestamp/ticket"
+
+	// SessionOpenRoute defines the API route for opening a submission
+	// session that digests are appended to across many requests.
+	SessionOpenRoute = RoutePrefix + "/sessions/open"
+
+	// SessionAppendRoute defines the API route for appending digests to
+	// an open submission session.
+	SessionAppendRoute = RoutePrefix + "/sessions/append"
+
+	// SessionCloseRoute defines the API route for closing a submission
+	// session, which timestamps all of its digests in one collection.
+	SessionCloseRoute = RoutePrefix + "/sessions/close"
+
+	// SessionStatusRoute defines the API route for retrieving the status
+	// of a submission session, e.g. to resume it after a reconnect.
+	SessionStatusRoute = RoutePrefix + "/sessions/status"
+
 	// VerifyBatchRoute defines the API route for both timestamp
 	// and digest batch verification.
 	VerifyBatchRoute = RoutePrefix + "/verify/batch" // Multi verify digests
 
+	// VerifyStreamRoute defines the API route for verifying an unbounded
+	// stream of newline delimited digests.
+	VerifyStreamRoute = RoutePrefix + "/verify/stream"
+
 	// WalletBalanceRoute defines the API route for retrieving
 	// the account balance from dcrtimed's wallet instance
 	WalletBalanceRoute = RoutePrefix + "/balance"
@@ -91,6 +137,129 @@ var (
 	// via the maxdigests config option
 	LastDigestsRoute = RoutePrefix + "/last-digests"
 
+	// LabelRoute defines the API route for retrieving all digests that
+	// were timestamped under a group label.
+	LabelRoute = RoutePrefix + "/label"
+
+	// WindowRoute defines the API route for previewing the merkle root of
+	// the collection that is currently accepting digests.
+	WindowRoute = RoutePrefix + "/window"
+
+	// AnchorsRoute defines the API route for retrieving the collections
+	// that were anchored between two block heights. Digests are only
+	// returned to clients with an api token with the verify scope.
+	AnchorsRoute = RoutePrefix + "/anchors"
+
+	// IdentityRoute defines the API route for retrieving the public
+	// identity keys of the server.
+	IdentityRoute = RoutePrefix + "/identity"
+
+	// BloomRoute defines the API route for retrieving the Bloom filter of
+	// the digests of an anchored collection. It requires collections to
+	// be enabled.
+	BloomRoute = RoutePrefix + "/bloom"
+
+	// AnchorChainRoute defines the API route for retrieving the chain that
+	// links the anchor of a collection to the nearest published Decred
+	// checkpoint, which lets proofs be verified offline.
+	AnchorChainRoute = RoutePrefix + "/anchorchain"
+
+	// OpenAPIRoute defines the API route for retrieving the OpenAPI 3
+	// description of the enabled API versions and routes.
+	OpenAPIRoute = RoutePrefix + "/openapi.json"
+
+	// CollectionsRoute defines the API route for listing the collections
+	// that the api token of the request timestamped digests in. It
+	// requires collections to be enabled and an api token with the
+	// timestamp scope.
+	CollectionsRoute = RoutePrefix + "/collections"
+
+	// CollectionRenameRoute defines the API route for naming a collection
+	// of the api token of the request.
+	CollectionRenameRoute = RoutePrefix + "/collections/rename"
+
+	// CollectionDeleteRoute defines the API route for deleting a collection
+	// that was not anchored yet and only holds digests of the api token of
+	// the request.
+	CollectionDeleteRoute = RoutePrefix + "/collections/delete"
+
+	// SearchRoute defines the API route for searching the digests that
+	// the api token of the request timestamped by collection, time,
+	// anchor status and metadata. It requires collections to be enabled
+	// and an api token with the timestamp scope.
+	SearchRoute = RoutePrefix + "/search"
+
+	// CollectionReceiptRoute defines the API route for retrieving a
+	// single receipt that proves the digests of all collections of a
+	// collection subtree of the api token of the request.
+	CollectionReceiptRoute = RoutePrefix + "/collections/receipt"
+
+	// UsageRoute defines the API route for retrieving the digest quotas
+	// and usage counters of the api token of the request.
+	UsageRoute = RoutePrefix + "/usage"
+
+	// ProxyStatsRoute defines the API route for retrieving the latency
+	// statistics of the upstream storehosts of a proxy mode dcrtimed.
+	ProxyStatsRoute = RoutePrefix + "/proxy/stats"
+
+	// AdminStatusRoute defines the API route for retrieving the runtime
+	// state of dcrtimed. It requires an api token with the admin scope.
+	AdminStatusRoute = RoutePrefix + "/admin/status"
+
+	// TokensRoute defines the API route for listing the api tokens. It
+	// requires an api token with the admin scope.
+	TokensRoute = RoutePrefix + "/admin/tokens"
+
+	// TokenCreateRoute defines the API route for creating an api token.
+	// It requires an api token with the admin scope.
+	TokenCreateRoute = RoutePrefix + "/admin/tokens/create"
+
+	// TokenRevokeRoute defines the API route for revoking an api token.
+	// It requires an api token with the admin scope.
+	TokenRevokeRoute = RoutePrefix + "/admin/tokens/revoke"
+
+	// WebhooksRoute defines the API route for listing the pending and dead
+	// webhook deliveries. It requires an api token with the admin scope.
+	WebhooksRoute = RoutePrefix + "/admin/webhooks"
+
+	// WebhookRetryRoute defines the API route for retrying a webhook
+	// delivery, e.g. one from the dead-letter queue. It requires an api
+	// token with the admin scope.
+	WebhookRetryRoute = RoutePrefix + "/admin/webhooks/retry"
+
+	// WebhookDeleteRoute defines the API route for discarding a webhook
+	// delivery. It requires an api token with the admin scope.
+	WebhookDeleteRoute = RoutePrefix + "/admin/webhooks/delete"
+
+	// WebhookSubscribeRoute defines the API route for registering a
+	// webhook URL for the anchor of a collection. It requires an api
+	// token.
+	WebhookSubscribeRoute = RoutePrefix + "/webhooks/subscribe"
+
+	// ProofAuditRoute defines the API route for retrieving the audit trail
+	// of the proofs that were served for a digest. It requires an api
+	// token with the admin scope.
+	ProofAuditRoute = RoutePrefix + "/admin/proofaudit"
+
+	// CollectionStatsRoute defines the API route for retrieving the
+	// submission and anchor statistics of flushed collections. It requires
+	// an api token with the admin scope.
+	CollectionStatsRoute = RoutePrefix + "/admin/collectionstats"
+
+	// ExportRoute defines the API route for exporting the digests and
+	// anchors of flushed collections. It requires an api token with the
+	// admin scope.
+	ExportRoute = RoutePrefix + "/admin/export"
+
+	// ReplicationRoute defines the API route that standby servers
+	// replicate the collections of a primary server through. It requires
+	// an api token with the admin scope.
+	ReplicationRoute = RoutePrefix + "/admin/replication"
+
+	// MaintenanceRoute defines the API route for starting and ending
+	// maintenance mode. It requires an api token with the admin scope.
+	MaintenanceRoute = RoutePrefix + "/admin/maintenance"
+
 	// Result defines legible string messages to a timestamping/query
 	// result code.
 	Result = map[ResultT]string{
@@ -106,8 +275,122 @@ var (
 
 	// RegexpTimestamp is the valid text representation of a timestamp.
 	RegexpTimestamp = regexp.MustCompile("^[0-9]{10}$")
+
+	// RegexpTokenID is the valid text representation of an api token ID.
+	RegexpTokenID = regexp.MustCompile("^[a-f0-9]{16}$")
+
+	// RegexpCollectionName is the valid text representation of a
+	// collection name. Names are paths of up to 8 slash separated
+	// segments, e.g. project/2020/04, which nests collections. An empty
+	// name removes the name of a collection.
+	RegexpCollectionName = regexp.MustCompile("^([^/\\x00-\\x1f\\x7f]{1,64}(/[^/\\x00-\\x1f\\x7f]{1,64}){0,7})?$")
+
+	// RegexpDeliveryID is the valid text representation of a webhook
+	// delivery ID.
+	RegexpDeliveryID = regexp.MustCompile("^[a-f0-9]{32}$")
+
+	// RegexpSessionID is the valid text representation of a submission
+	// session ID.
+	RegexpSessionID = regexp.MustCompile("^[a-f0-9]{32}$")
+
+	// RegexpTicket is the valid text representation of an asynchronous
+	// submission ticket.
+	RegexpTicket = regexp.MustCompile("^[a-f0-9]{32}$")
+
+	// RegexpLabel is the valid text representation of a group label.
+	RegexpLabel = regexp.MustCompile("^[A-Za-z0-9_.:/-]{1,64}$")
+
+	// RegexpMetadataKey is the valid text representation of a digest
+	// metadata key.
+	RegexpMetadataKey = regexp.MustCompile("^[a-z0-9_.-]{1,32}$")
+)
+
+// Digest metadata. Submitters may annotate every digest with a small map of
+// keys to values that is stored with the digest and returned when it is
+// verified. The well known keys below are suggestions, any key that matches
+// RegexpMetadataKey is allowed. The combined length of all keys and values of
+// a digest may not exceed MaxMetadataSize bytes.
+const (
+	MetadataFilename   = "filename"   // Name of the file that was hashed
+	MetadataMIMEType   = "mimetype"   // MIME type of the file
+	MetadataExternalID = "externalid" // Identifier in an external system
+
+	MaxMetadataSize = 1024
 )
 
+// Digest algorithms. All algorithms produce 32 byte digests that are anchored
+// the same way, the algorithm is only recorded alongside the digest so that
+// clients don't have to re-hash their data with SHA-256. An empty algorithm
+// means SHA-256.
+const (
+	AlgorithmSHA256     = "sha256"
+	AlgorithmSHA512_256 = "sha512/256"
+	AlgorithmBLAKE2b256 = "blake2b-256"
+	AlgorithmSHA3_256   = "sha3-256"
+)
+
+// Algorithms contains all supported digest algorithms.
+var Algorithms = []string{
+	AlgorithmSHA256,
+	AlgorithmSHA512_256,
+	AlgorithmBLAKE2b256,
+	AlgorithmSHA3_256,
+}
+
+// IsAlgorithm returns true if the provided digest algorithm is supported. An
+// empty algorithm means SHA-256.
+func IsAlgorithm(algorithm string) bool {
+	if algorithm == "" {
+		return true
+	}
+	for _, a := range Algorithms {
+		if algorithm == a {
+			return true
+		}
+	}
+	return false
+}
+
+// Proof formats that anchored digests can be returned in by verify requests.
+// ProofFormatJSON is a self-contained JSON proof, ProofFormatOTS an
+// OpenTimestamps proof, ProofFormatChainpoint a Chainpoint v3 proof and
+// ProofFormatFull a JSON proof with the SPV data of the anchor block.
+const (
+	ProofFormatJSON       = "json"
+	ProofFormatOTS        = "ots"
+	ProofFormatChainpoint = "chainpoint"
+	ProofFormatFull       = "full"
+)
+
+// ProofFormats contains all supported proof formats.
+var ProofFormats = []string{
+	ProofFormatJSON,
+	ProofFormatOTS,
+	ProofFormatChainpoint,
+	ProofFormatFull,
+}
+
+// IsProofFormat returns true if the provided proof format is supported.
+func IsProofFormat(format string) bool {
+	for _, f := range ProofFormats {
+		if format == f {
+			return true
+		}
+	}
+	return false
+}
+
+// FormatTime returns the ISO 8601 UTC representation of the provided unix
+// timestamp. It is used to fill the *Time fields that accompany every unix
+// timestamp in the replies. A zero timestamp indicates an unset time and
+// yields an empty string.
+func FormatTime(ts int64) string {
+	if ts == 0 {
+		return ""
+	}
+	return time.Unix(ts, 0).UTC().Format(time.RFC3339)
+}
+
 // Status is used to ask the server if everything is running properly.
 // ID is user settable and can be used as a unique identifier by the client.
 type Status struct {
@@ -119,36 +402,113 @@ type StatusReply struct {
 	ID string `json:"id"`
 }
 
-// VersionReply returns the version the server is currently running.
+// VersionReply returns the version the server is currently running, the
+// digest algorithms it accepts and the proof formats it returns. MaxDigests is
+// the number of digests that may be queried at once and ScopeMaxDigests
+// overrides it for api tokens that grant a scope, the anonymous scope applies
+// to requests without an api token.
 type VersionReply struct {
-	Versions      []uint   `json:"versions"` // dcrtime API supported versions.
-	RoutePrefixes []string `json:"routeprefixes"`
+	Versions        []uint           `json:"versions"` // dcrtime API supported versions.
+	RoutePrefixes   []string         `json:"routeprefixes"`
+	Algorithms      []string         `json:"algorithms,omitempty"`
+	ProofFormats    []string         `json:"proofformats,omitempty"`
+	MaxDigests      int32            `json:"maxdigests,omitempty"`
+	ScopeMaxDigests map[string]int32 `json:"scopemaxdigests,omitempty"`
+}
+
+// HealthReply is returned by the server while the process is alive.
+type HealthReply struct {
+	Status string `json:"status"`
+}
+
+// ReadyCheck is the result of one of the checks of a readiness probe. Error is
+// only set if the check failed.
+type ReadyCheck struct {
+	Name  string `json:"name"`
+	Ready bool   `json:"ready"`
+	Error string `json:"error,omitempty"`
+}
+
+// ReadyReply is returned by the server on a readiness probe. Ready is only set
+// if all checks passed.
+type ReadyReply struct {
+	Ready  bool         `json:"ready"`
+	Checks []ReadyCheck `json:"checks"`
 }
 
 // Timestamp is used to ask the timestamp server to store a single digest.
 // ID is user settable and can be used as a unique identifier by the client.
 type Timestamp struct {
-	ID     string `form:"id"`
-	Digest string `form:"digest"`
+	ID             string `form:"id"`
+	Digest         string `form:"digest"`
+	Algorithm      string `form:"algorithm"`      // Optional, defaults to sha256
+	PendingReceipt bool   `form:"pendingreceipt"` // Optional
+	WebhookURL     string `form:"webhookurl"`     // Optional, api token only
 }
 
 // TimestampReply is returned by the timestamp server after storing a single
 // digest. ID is copied from the originating Timestamp call and can be
 // used by the client as a unique identifier. ServerTimestamp indicates what
 // collection the Digest belongs to. Result holds the result code for the digest.
+// Maintenance is set if the server is in maintenance mode, the digest is then
+// queued and not anchored until maintenance ends. Duplicate describes the
+// original submission of a digest that was rejected with ResultExistsError.
+// PendingReceipt is the signed pending receipt of an accepted digest if it was
+// requested. SubscriptionID identifies the webhook subscription of the
+// submission if a webhook URL was provided and the digest was accepted.
 type TimestampReply struct {
-	ID              string  `json:"id"`
-	ServerTimestamp int64   `json:"servertimestamp"`
-	Digest          string  `json:"digest"`
-	Result          ResultT `json:"result"`
+	ID              string          `json:"id"`
+	ServerTimestamp int64           `json:"servertimestamp"`
+	ServerTime      string          `json:"servertime,omitempty"`
+	Digest          string          `json:"digest"`
+	Algorithm       string          `json:"algorithm,omitempty"`
+	Result          ResultT         `json:"result"`
+	AccessKey       string          `json:"accesskey,omitempty"` // Private digests only
+	Maintenance     bool            `json:"maintenance,omitempty"`
+	Duplicate       *Duplicate      `json:"duplicate,omitempty"`
+	PendingReceipt  *PendingReceipt `json:"pendingreceipt,omitempty"`
+	SubscriptionID  string          `json:"subscriptionid,omitempty"`
+}
+
+// Duplicate describes the original submission of a digest that was rejected
+// with ResultExistsError because it was timestamped before. ServerTimestamp is
+// the collection the digest was originally timestamped in. Status is one of
+// the DuplicateStatus constants. Transaction is set once the collection was
+// anchored, ChainTimestamp once the anchor has enough confirmations.
+type Duplicate struct {
+	Digest          string `json:"digest"`
+	ServerTimestamp int64  `json:"servertimestamp"`
+	ServerTime      string `json:"servertime,omitempty"`
+	Status          string `json:"status"`
+	Transaction     string `json:"transaction,omitempty"`
+	Confirmations   *int32 `json:"confirmations,omitempty"`
+	ChainTimestamp  int64  `json:"chaintimestamp,omitempty"`
+	ChainTime       string `json:"chaintime,omitempty"`
 }
 
+// Anchor statuses of a Duplicate.
+const (
+	// DuplicateStatusPending indicates the collection of the digest was
+	// not anchored yet.
+	DuplicateStatusPending = "pending"
+
+	// DuplicateStatusAnchored indicates the collection of the digest was
+	// anchored but the anchor does not have enough confirmations yet.
+	DuplicateStatusAnchored = "anchored"
+
+	// DuplicateStatusConfirmed indicates the anchor of the collection of
+	// the digest has enough confirmations.
+	DuplicateStatusConfirmed = "confirmed"
+)
+
 // Verify is used to ask the server about the status of a single digest and/or
 // timestamp.
 type Verify struct {
-	ID        string `form:"id"`
-	Digest    string `form:"digest"`
-	Timestamp int64  `form:"timestamp"`
+	ID           string   `form:"id"`
+	Digest       string   `form:"digest"`
+	Timestamp    int64    `form:"timestamp"`
+	ProofFormats []string `form:"proofformats"` // Optional, comma separated
+	AccessKeys   []string `form:"accesskey"`    // Optional, private digests only
 }
 
 // VerifyReply is returned by the server with the status results for the requested
@@ -162,47 +522,249 @@ type VerifyReply struct {
 // VerifyDigest is returned by the server after verifying the status of a
 // digest.
 type VerifyDigest struct {
-	Digest           string           `json:"digest"`
-	ServerTimestamp  int64            `json:"servertimestamp"`
-	FlushTimestamp   int64            `json:"flushtimestamp"`
-	Result           ResultT          `json:"result"`
-	ChainInformation ChainInformation `json:"chaininformation"`
+	Digest           string            `json:"digest"`
+	ServerTimestamp  int64             `json:"servertimestamp"`
+	ServerTime       string            `json:"servertime,omitempty"`
+	FlushTimestamp   int64             `json:"flushtimestamp"`
+	FlushTime        string            `json:"flushtime,omitempty"`
+	Label            string            `json:"label,omitempty"`
+	Algorithm        string            `json:"algorithm,omitempty"`
+	Metadata         map[string]string `json:"metadata,omitempty"`
+	Result           ResultT           `json:"result"`
+	ChainInformation ChainInformation  `json:"chaininformation"`
+	Proofs           *Proofs           `json:"proofs,omitempty"`
 }
 
 // VerifyTimestamp is zero if this digest collection is not anchored in the
 // blockchain; it is however set to the block timestamp it was anchored in.
 type VerifyTimestamp struct {
 	ServerTimestamp       int64                 `json:"servertimestamp"`
+	ServerTime            string                `json:"servertime,omitempty"`
 	FlushTimestamp        int64                 `json:"flushtimestamp"`
+	FlushTime             string                `json:"flushtime,omitempty"`
 	Result                ResultT               `json:"result"`
 	CollectionInformation CollectionInformation `json:"collectioninformation"`
 }
 
 // TimestampBatch is used to ask the timestamp server to store a batch of digests.
 // ID is user settable and can be used as a unique identifier by the client.
+// Label is an optional group label that is stored with every accepted digest
+// and that can be used to look up all digests of a job later. Algorithm is the
+// digest algorithm of all digests. Metadata optionally annotates the digests,
+// in the order of Digests, and is only stored with digests that are accepted.
+// PrivateMetadata requires an api token and restricts the metadata to verify
+// requests with the same token. PendingReceipts asks for a signed pending
+// receipt of every accepted digest. WebhookURL requires an api token and
+// subscribes the URL to the anchor of the accepted digests, see Webhook.
 type TimestampBatch struct {
-	ID      string   `json:"id"`
-	Digests []string `json:"digests"`
+	ID              string              `json:"id"`
+	Label           string              `json:"label,omitempty"`
+	Algorithm       string              `json:"algorithm,omitempty"` // Defaults to sha256
+	Digests         []string            `json:"digests"`
+	Metadata        []map[string]string `json:"metadata,omitempty"`
+	PrivateMetadata bool                `json:"privatemetadata,omitempty"`
+	PendingReceipts bool                `json:"pendingreceipts,omitempty"`
+	WebhookURL      string              `json:"webhookurl,omitempty"`
 }
 
 // TimestampBatchReply is returned by the timestamp server after storing the batch
 // of digests. ID is copied from the originating Timestamp call and can be
 // used by the client as a unique identifier. The ServerTimestamp indicates
 // what collection the Digests belong to. Results contains individual result
-// codes for each digest.
+// codes for each digest. Maintenance is set if the server is in maintenance
+// mode, the digests are then queued and not anchored until maintenance ends.
+// Duplicates describes the original submission of the digests that were
+// rejected with ResultExistsError, in the order of the digests.
+// PendingReceipts contains the signed pending receipts of the accepted digests,
+// in the order of the digests, if they were requested. SubscriptionID
+// identifies the webhook subscription of the submission if a webhook URL was
+// provided and digests were accepted.
 type TimestampBatchReply struct {
-	ID              string    `json:"id"`
-	ServerTimestamp int64     `json:"servertimestamp"`
-	Digests         []string  `json:"digests"`
-	Results         []ResultT `json:"results"`
+	ID              string           `json:"id"`
+	ServerTimestamp int64            `json:"servertimestamp"`
+	ServerTime      string           `json:"servertime,omitempty"`
+	Label           string           `json:"label,omitempty"`
+	Algorithm       string           `json:"algorithm,omitempty"`
+	Digests         []string         `json:"digests"`
+	Results         []ResultT        `json:"results"`
+	AccessKeys      []string         `json:"accesskeys,omitempty"` // Private digests only
+	Maintenance     bool             `json:"maintenance,omitempty"`
+	Duplicates      []Duplicate      `json:"duplicates,omitempty"`
+	PendingReceipts []PendingReceipt `json:"pendingreceipts,omitempty"`
+	SubscriptionID  string           `json:"subscriptionid,omitempty"`
+}
+
+// MaxAggregateDigests is the maximum number of digests in a TimestampAggregate
+// request.
+const MaxAggregateDigests = 65536
+
+// TimestampAggregate is used to ask the timestamp server to store a batch of
+// digests as a single digest. The server builds a merkle tree of the digests
+// and only timestamps its root, the aggregate root. ID is user settable and
+// Label is an optional group label that is stored with the aggregate root.
+type TimestampAggregate struct {
+	ID      string   `json:"id"`
+	Label   string   `json:"label,omitempty"`
+	Digests []string `json:"digests"`
+}
+
+// TimestampAggregateReply is returned by the timestamp server after storing
+// the aggregate root of a batch of digests. Result applies to the aggregate
+// root, it is ResultExistsError if the same set of digests was aggregated
+// before. Proofs contains the merkle path from every digest to the aggregate
+// root, in the order of Digests. Clients keep these proofs and verify the
+// aggregate root like any other digest. Maintenance is set if the server is in
+// maintenance mode, the aggregate root is then queued and not anchored until
+// maintenance ends.
+type TimestampAggregateReply struct {
+	ID              string         `json:"id"`
+	ServerTimestamp int64          `json:"servertimestamp"`
+	ServerTime      string         `json:"servertime,omitempty"`
+	Label           string         `json:"label,omitempty"`
+	AggregateRoot   string         `json:"aggregateroot"`
+	Result          ResultT        `json:"result"`
+	Digests         []string       `json:"digests"`
+	Proofs          []MerkleBranch `json:"proofs"`
+	AccessKey       string         `json:"accesskey,omitempty"` // Private digests only
+	Maintenance     bool           `json:"maintenance,omitempty"`
+}
+
+// Statuses of an asynchronous submission.
+const (
+	// TicketStatusQueued indicates the digests of the submission are
+	// queued and not timestamped yet.
+	TicketStatusQueued = "queued"
+
+	// TicketStatusDone indicates the digests of the submission were
+	// timestamped.
+	TicketStatusDone = "done"
+)
+
+// TimestampAsyncReply is returned by the server with 202 Accepted after it
+// durably queued the digests of a TimestampBatch sent to TimestampAsyncRoute.
+// Ticket identifies the submission, its outcome is retrieved with a
+// TimestampTicket request once the digests were timestamped.
+type TimestampAsyncReply struct {
+	ID          string `json:"id"`
+	Ticket      string `json:"ticket"`
+	Status      string `json:"status"`
+	DigestCount int    `json:"digestcount"`
+}
+
+// TimestampTicket is used to retrieve the status of an asynchronous
+// submission.
+type TimestampTicket struct {
+	ID     string `json:"id"`
+	Ticket string `json:"ticket"`
+}
+
+// TimestampTicketReply is returned by the server for a TimestampTicket
+// request. Status is one of the TicketStatus constants. Once the status is
+// TicketStatusDone the remaining fields describe the outcome like a
+// TimestampBatchReply does, and the ticket remains available until
+// ExpiresTimestamp.
+type TimestampTicketReply struct {
+	ID               string    `json:"id"`
+	Ticket           string    `json:"ticket"`
+	Status           string    `json:"status"`
+	ServerTimestamp  int64     `json:"servertimestamp,omitempty"`
+	ServerTime       string    `json:"servertime,omitempty"`
+	Label            string    `json:"label,omitempty"`
+	Algorithm        string    `json:"algorithm,omitempty"`
+	Digests          []string  `json:"digests"`
+	Results          []ResultT `json:"results,omitempty"`
+	AccessKeys       []string  `json:"accesskeys,omitempty"` // Private digests only
+	SubscriptionID   string    `json:"subscriptionid,omitempty"`
+	ExpiresTimestamp int64     `json:"expirestimestamp,omitempty"`
+	ExpiresTime      string    `json:"expirestime,omitempty"`
+	Maintenance      bool      `json:"maintenance,omitempty"`
+}
+
+// MaxSessionAppendDigests is the maximum number of digests in a SessionAppend
+// request.
+const MaxSessionAppendDigests = 65536
+
+// SessionOpen is used to open a submission session for a batch of digests that
+// is too large to be sent in one request. Label and Algorithm apply to all
+// digests of the session like in a TimestampBatch.
+type SessionOpen struct {
+	ID        string `json:"id"`
+	Label     string `json:"label,omitempty"`
+	Algorithm string `json:"algorithm,omitempty"` // Defaults to sha256
+}
+
+// SessionAppend is used to append digests to an open submission session.
+// Offset is the number of digests of the session that were sent before these
+// digests. Digests below the number of digests the server already staged are
+// skipped, so a request whose reply was lost can simply be resent.
+type SessionAppend struct {
+	ID        string   `json:"id"`
+	SessionID string   `json:"sessionid"`
+	Offset    int64    `json:"offset"`
+	Digests   []string `json:"digests"`
+}
+
+// SessionClose is used to close a submission session. All digests of the
+// session are then timestamped in the same collection.
+type SessionClose struct {
+	ID        string `json:"id"`
+	SessionID string `json:"sessionid"`
+}
+
+// SessionStatus is used to retrieve the status of a submission session.
+type SessionStatus struct {
+	ID        string `json:"id"`
+	SessionID string `json:"sessionid"`
+}
+
+// SessionReply is returned by the server for all session requests. DigestCount
+// is the number of digests the server staged, which is the offset of the next
+// SessionAppend. Once the session is closed ServerTimestamp identifies the
+// collection of its digests, AcceptedCount the number of digests that were
+// timestamped and ExistingCount the number of digests that already existed.
+// The session expires unless it is appended to or closed before
+// ExpiresTimestamp; closed sessions remain available until then.
+type SessionReply struct {
+	ID               string `json:"id"`
+	SessionID        string `json:"sessionid"`
+	Label            string `json:"label,omitempty"`
+	Algorithm        string `json:"algorithm,omitempty"`
+	DigestCount      int64  `json:"digestcount"`
+	MaxDigests       int64  `json:"maxdigests"`
+	Closed           bool   `json:"closed"`
+	ServerTimestamp  int64  `json:"servertimestamp,omitempty"`
+	ServerTime       string `json:"servertime,omitempty"`
+	AcceptedCount    int64  `json:"acceptedcount"`
+	ExistingCount    int64  `json:"existingcount"`
+	ExpiresTimestamp int64  `json:"expirestimestamp"`
+	ExpiresTime      string `json:"expirestime,omitempty"`
+	Maintenance      bool   `json:"maintenance,omitempty"`
 }
 
 // VerifyBatch is used to ask the server about the status of a batch of digests or
 // timestamps
 type VerifyBatch struct {
-	ID         string   `json:"id"`
-	Digests    []string `json:"digests"`
-	Timestamps []int64  `json:"timestamps"`
+	ID           string   `json:"id"`
+	Digests      []string `json:"digests"`
+	Timestamps   []int64  `json:"timestamps"`
+	ProofFormats []string `json:"proofformats,omitempty"`
+	AccessKeys   []string `json:"accesskeys,omitempty"`
+}
+
+// VerifyStreamDigest is a single line of a VerifyStreamRoute request body.  The
+// request body is a stream of newline delimited JSON objects, one per digest.
+// The server replies with a stream of newline delimited VerifyDigest objects in
+// the same order.  Results are sent back while the request is still being read
+// so clients must read the reply concurrently with writing the request.
+type VerifyStreamDigest struct {
+	Digest    string `json:"digest"`
+	AccessKey string `json:"accesskey,omitempty"`
+}
+
+// VerifyStreamError is sent as the last line of a VerifyStreamRoute reply when
+// the server aborts the stream.
+type VerifyStreamError struct {
+	Error string `json:"error"`
 }
 
 // LastDigests is used to ask the server the info about the N last digests
@@ -226,27 +788,102 @@ type MerkleBranch struct {
 	Flags     []byte              // Bitmap of merkle tree
 }
 
+// Attestation is the proof of a secondary anchorer, such as an
+// OpenTimestamps calendar, that it attested the merkle root of a collection in
+// addition to the Decred transaction.  Proof is hex encoded, its format
+// depends on the anchorer.
+type Attestation struct {
+	Anchorer string `json:"anchorer"`
+	Proof    string `json:"proof"`
+}
+
 // ChainInformation is returned by the server on a verify digest request.
-// It contains the merkle path of that digest.
+// It contains the merkle path of that digest.  AnchorPrefix is the prefix the
+// operator stored in front of the merkle root in the transaction, if any.
+// ReorgTimestamp is the last time the transaction was reorganized out of the
+// chain before it had enough confirmations, in which case it was broadcast
+// again or replaced.
 type ChainInformation struct {
-	ChainTimestamp   int64        `json:"chaintimestamp"`
-	Confirmations    *int32       `json:"confirmations,omitempty"` // Using a pointer because we don't want to omit 0
-	MinConfirmations int32        `json:"minconfirmations,omitempty"`
-	Transaction      string       `json:"transaction"`
-	MerkleRoot       string       `json:"merkleroot"`
-	MerklePath       MerkleBranch `json:"merklepath"`
+	ChainTimestamp   int64         `json:"chaintimestamp"`
+	ChainTime        string        `json:"chaintime,omitempty"`
+	Confirmations    *int32        `json:"confirmations,omitempty"` // Using a pointer because we don't want to omit 0
+	MinConfirmations int32         `json:"minconfirmations,omitempty"`
+	ReorgTimestamp   int64         `json:"reorgtimestamp,omitempty"`
+	ReorgTime        string        `json:"reorgtime,omitempty"`
+	Transaction      string        `json:"transaction"`
+	AnchorPrefix     string        `json:"anchorprefix,omitempty"`
+	Attestations     []Attestation `json:"attestations,omitempty"`
+	MerkleRoot       string        `json:"merkleroot"`
+	MerklePath       MerkleBranch  `json:"merklepath"`
+}
+
+// ProofJSON is a self-contained JSON proof that a digest was anchored.  The
+// merkle branch leads from the digest to the merkle root, which is stored in
+// the transaction.  Hashes and flags of the branch are hex encoded.  The
+// checkpoint is the nearest published Decred checkpoint at or above the block
+// of the transaction, if any.  The chain that links the transaction to it is
+// returned by the anchor chain route and lets the proof be verified offline.
+type ProofJSON struct {
+	Digest           string     `json:"digest"`
+	Algorithm        string     `json:"algorithm,omitempty"`
+	MerkleRoot       string     `json:"merkleroot"`
+	MerkleBranch     BranchJSON `json:"merklebranch"`
+	Transaction      string     `json:"transaction"`
+	ChainTimestamp   int64      `json:"chaintimestamp"`
+	BlockHeight      int32      `json:"blockheight,omitempty"`
+	CheckpointHeight int32      `json:"checkpointheight,omitempty"`
+	CheckpointHash   string     `json:"checkpointhash,omitempty"`
+}
+
+// ProofFull is a JSON proof together with the SPV data that lets it be
+// verified against the header of the anchor block alone.  RawTransaction is
+// the serialized anchor transaction, TxIndex and TxBranch are its merkle
+// branch in the regular transaction tree of its block as in AnchorChainReply
+// and BlockHeader is the serialized header of the block.  The transaction and
+// the header are hex encoded.
+type ProofFull struct {
+	ProofJSON
+	RawTransaction string   `json:"rawtransaction"`
+	TxIndex        uint32   `json:"txindex"`
+	TxBranch       []string `json:"txbranch"`
+	BlockHash      string   `json:"blockhash"`
+	BlockHeader    string   `json:"blockheader"`
+}
+
+// BranchJSON shares the same struct definition as merkle.BranchJSON.
+type BranchJSON struct {
+	NumLeaves uint32   `json:"numleaves"`
+	Hashes    []string `json:"hashes"`
+	Flags     string   `json:"flags"`
+}
+
+// Proofs holds the proofs of an anchored digest in the formats that were
+// requested.  OTS is the binary OpenTimestamps proof; it is only available
+// for SHA-256 digests.  Chainpoint is the JSON Chainpoint proof.  Full is
+// only available if the server can retrieve blocks.
+type Proofs struct {
+	JSON       *ProofJSON      `json:"json,omitempty"`
+	OTS        []byte          `json:"ots,omitempty"`
+	Chainpoint json.RawMessage `json:"chainpoint,omitempty"`
+	Full       *ProofFull      `json:"full,omitempty"`
 }
 
 // CollectionInformation is returned by the server on a verify timestamp
 // request. It contains all digests grouped on the collection of the
-// requested block timestamp.
+// requested block timestamp.  ReorgTimestamp is the same as in
+// ChainInformation.
 type CollectionInformation struct {
-	ChainTimestamp   int64    `json:"chaintimestamp"`
-	Confirmations    *int32   `json:"confirmations,omitempty"` // Using a pointer because we don't want to omit 0
-	MinConfirmations int32    `json:"minconfirmations,omitempty"`
-	Transaction      string   `json:"transaction"`
-	MerkleRoot       string   `json:"merkleroot"`
-	Digests          []string `json:"digests"`
+	ChainTimestamp   int64         `json:"chaintimestamp"`
+	ChainTime        string        `json:"chaintime,omitempty"`
+	Confirmations    *int32        `json:"confirmations,omitempty"` // Using a pointer because we don't want to omit 0
+	MinConfirmations int32         `json:"minconfirmations,omitempty"`
+	ReorgTimestamp   int64         `json:"reorgtimestamp,omitempty"`
+	ReorgTime        string        `json:"reorgtime,omitempty"`
+	Transaction      string        `json:"transaction"`
+	AnchorPrefix     string        `json:"anchorprefix,omitempty"`
+	Attestations     []Attestation `json:"attestations,omitempty"`
+	MerkleRoot       string        `json:"merkleroot"`
+	Digests          []string      `json:"digests"`
 }
 
 // WalletBalanceReply is returned by server on a balance information of the
@@ -264,6 +901,7 @@ type WalletBalanceReply struct {
 // confirmations informed in the config file.
 type LastAnchorReply struct {
 	ChainTimestamp int64  `json:"chaintimestamp"`
+	ChainTime      string `json:"chaintime,omitempty"`
 	Transaction    string `json:"transaction"`
 	BlockHash      string `json:"blockhash"`
 	BlockHeight    int32  `json:"blockheight"`
@@ -274,3 +912,1027 @@ type LastAnchorReply struct {
 type LastDigestsReply struct {
 	Digests []VerifyDigest `json:"digests"`
 }
+
+// Label is used to ask the server about the status of all digests that were
+// timestamped under the provided group label.
+type Label struct {
+	ID    string `json:"id"`
+	Label string `json:"label"`
+}
+
+// LabelReply is returned by the server with the status results of all
+// digests that were timestamped under the requested group label.
+type LabelReply struct {
+	ID      string         `json:"id"`
+	Label   string         `json:"label"`
+	Digests []VerifyDigest `json:"digests"`
+}
+
+// Window is used to ask the server for the would-be merkle root of the
+// collection that digests are currently stored in.  Label selects the
+// collection of a group label and may be empty.
+type Window struct {
+	ID    string `json:"id"`
+	Label string `json:"label,omitempty"`
+}
+
+// WindowReply is returned by the server with a preview of the collection that
+// is currently accepting digests.  The merkle root changes with every digest
+// that is added before the collection is flushed, Final is therefore always
+// false.  Integrators should confirm the merkle root once the collection was
+// anchored.  Collections that end at a block height instead of a time return
+// EndHeight and a zero EndTimestamp.
+type WindowReply struct {
+	ID              string `json:"id"`
+	Label           string `json:"label,omitempty"`
+	ServerTimestamp int64  `json:"servertimestamp"`
+	ServerTime      string `json:"servertime"`
+	EndTimestamp    int64  `json:"endtimestamp"`
+	EndTime         string `json:"endtime"`
+	EndHeight       int32  `json:"endheight,omitempty"`
+	DigestCount     int    `json:"digestcount"`
+	MerkleRoot      string `json:"merkleroot"`
+	Final           bool   `json:"final"`
+}
+
+// UpstreamRouteStats contains the latency statistics of a single route
+// forwarded by a proxy mode dcrtimed to one of its storehosts. All latencies
+// are expressed in milliseconds.
+type UpstreamRouteStats struct {
+	Route       string `json:"route"`
+	Requests    uint64 `json:"requests"`
+	Errors      uint64 `json:"errors"`
+	Timeouts    uint64 `json:"timeouts"`
+	AvgLatency  int64  `json:"avglatency"`
+	MaxLatency  int64  `json:"maxlatency"`
+	LastLatency int64  `json:"lastlatency"`
+}
+
+// UpstreamStats contains the state and per route latency statistics of a
+// storehost. DownUntil is the unix timestamp until which the storehost is
+// skipped in favor of the failover storehost, it is zero when the storehost
+// is considered healthy. OpenUntil is the unix timestamp until which the
+// circuit breaker of the storehost is open, no requests are forwarded to it
+// in the meantime. Failures counts the consecutive failed requests and health
+// checks. Healthy is the result of the last health check at LastCheck. A
+// fan-out storehost is an independent storehost that only receives timestamp
+// requests.
+type UpstreamStats struct {
+	Host          string               `json:"host"`
+	Failover      bool                 `json:"failover"`
+	Fanout        bool                 `json:"fanout"`
+	DownUntil     int64                `json:"downuntil"`
+	DownUntilTime string               `json:"downuntiltime,omitempty"`
+	OpenUntil     int64                `json:"openuntil"`
+	OpenUntilTime string               `json:"openuntiltime,omitempty"`
+	Failures      int                  `json:"failures"`
+	Healthy       bool                 `json:"healthy"`
+	LastCheck     int64                `json:"lastcheck"`
+	LastCheckTime string               `json:"lastchecktime,omitempty"`
+	Routes        []UpstreamRouteStats `json:"routes"`
+}
+
+// ProxyStatsReply is returned by a proxy mode server on a proxy stats
+// request. Quorum is the number of storehosts that must accept a timestamp
+// request.
+type ProxyStatsReply struct {
+	Timeout   int64           `json:"timeout"` // In milliseconds
+	Quorum    int             `json:"quorum"`
+	Upstreams []UpstreamStats `json:"upstreams"`
+}
+
+// Announcement is periodically posted by instances that opted in to a public
+// instance directory. It only describes the instance itself, no information
+// about clients or digests is included. LastAnchor is omitted when the
+// instance was unable to retrieve it. MaxDigests is the number of digests that
+// may be queried at once and ScopeMaxDigests overrides it for api tokens that
+// grant a scope, the anonymous scope applies to requests without an api token.
+type Announcement struct {
+	URL             string           `json:"url"`
+	Version         string           `json:"version"`
+	Network         string           `json:"network"`
+	APIVersions     []uint           `json:"apiversions"`
+	Proxy           bool             `json:"proxy"`
+	MaxDigests      int32            `json:"maxdigests"`
+	ScopeMaxDigests map[string]int32 `json:"scopemaxdigests,omitempty"`
+	ServerTimestamp int64            `json:"servertimestamp"`
+	ServerTime      string           `json:"servertime,omitempty"`
+	LastAnchor      *LastAnchorReply `json:"lastanchor,omitempty"`
+}
+
+// Notification events.
+const (
+	NotificationSelfTestFailed     = "selftestfailed"     // Self test failed
+	NotificationSelfTestRecovered  = "selftestrecovered"  // Self test passed after a failure
+	NotificationSelfAuditFailed    = "selfauditfailed"    // Self audit found a mismatch
+	NotificationSelfAuditRecovered = "selfauditrecovered" // Self audit passed after a failure
+	NotificationWalletDown         = "walletdown"         // Wallet is unreachable
+	NotificationWalletRecovered    = "walletrecovered"    // Wallet is reachable again
+	NotificationFlushFailed        = "flushfailed"        // Anchor of a flush failed
+	NotificationFlushRecovered     = "flushrecovered"     // Anchor succeeded after a failure
+	NotificationLowBalance         = "lowbalance"         // Spendable balance below the threshold
+	NotificationBalanceRecovered   = "balancerecovered"   // Spendable balance above the threshold again
+	NotificationReorg              = "reorg"              // Anchor reorganized out of the chain
+)
+
+// Notification is posted to the notification sinks of an instance when the
+// self test of its timestamping pipeline or the self audit of its anchored
+// digests fails or passes again and when an alert condition of a storehost
+// starts or ends. Instance is the URL that was self tested and Digest the
+// canary digest, if one was submitted, or the digest that failed the self
+// audit.
+type Notification struct {
+	Event           string `json:"event"`
+	Instance        string `json:"instance"`
+	Network         string `json:"network"`
+	Digest          string `json:"digest,omitempty"`
+	Message         string `json:"message"`
+	ServerTimestamp int64  `json:"servertimestamp"`
+	ServerTime      string `json:"servertime,omitempty"`
+}
+
+// Api token scopes. A token with the admin scope is granted every scope.
+const (
+	TokenScopeTimestamp = "timestamp" // Timestamp digests
+	TokenScopeVerify    = "verify"    // Verify digests and query the server
+	TokenScopeAdmin     = "admin"     // Wallet balance and token management
+)
+
+// TokenScopes contains all valid api token scopes.
+var TokenScopes = []string{
+	TokenScopeTimestamp,
+	TokenScopeVerify,
+	TokenScopeAdmin,
+}
+
+// TokenUsage contains the digests that were charged to an api token. Daily
+// and monthly counters start over at UTC day and month boundaries,
+// DayTimestamp and MonthTimestamp are the start of the current day and month.
+type TokenUsage struct {
+	DayTimestamp     int64  `json:"daytimestamp"`
+	DayTime          string `json:"daytime,omitempty"`
+	DayDigests       int64  `json:"daydigests"`
+	MonthTimestamp   int64  `json:"monthtimestamp"`
+	MonthTime        string `json:"monthtime,omitempty"`
+	MonthDigests     int64  `json:"monthdigests"`
+	LastMonthDigests int64  `json:"lastmonthdigests"`
+	TotalDigests     uint64 `json:"totaldigests"`
+}
+
+// Token describes an api token. The token itself is only returned once, when
+// it is created. ExpiresTimestamp is zero when the token does not expire.
+// Uses counts the requests that were authorized with the token. Collection is
+// the default collection name of the token, if any. DailyQuota and
+// MonthlyQuota limit the digests the token may timestamp, zero if unlimited.
+type Token struct {
+	ID                string     `json:"id"`
+	Description       string     `json:"description"`
+	Scopes            []string   `json:"scopes"`
+	CreatedTimestamp  int64      `json:"createdtimestamp"`
+	CreatedTime       string     `json:"createdtime,omitempty"`
+	ExpiresTimestamp  int64      `json:"expirestimestamp"`
+	ExpiresTime       string     `json:"expirestime,omitempty"`
+	Uses              uint64     `json:"uses"`
+	LastUsedTimestamp int64      `json:"lastusedtimestamp"`
+	LastUsedTime      string     `json:"lastusedtime,omitempty"`
+	Collection        string     `json:"collection,omitempty"`
+	DailyQuota        int64      `json:"dailyquota,omitempty"`
+	MonthlyQuota      int64      `json:"monthlyquota,omitempty"`
+	Usage             TokenUsage `json:"usage"`
+}
+
+// TokensReply is returned by the server with all api tokens that were
+// created through the admin API. Tokens that were provided in the server
+// configuration are not included.
+type TokensReply struct {
+	Tokens []Token `json:"tokens"`
+}
+
+// TokenCreate is used to create an api token with the provided scopes. A
+// zero ExpiresTimestamp creates a token that does not expire. Collection is the
+// default collection name of the token, collections the token timestamps
+// digests in are given this name unless they were already named. DailyQuota and
+// MonthlyQuota limit the digests the token may timestamp per UTC day and
+// month, zero if unlimited.
+type TokenCreate struct {
+	Description      string   `json:"description"`
+	Scopes           []string `json:"scopes"`
+	ExpiresTimestamp int64    `json:"expirestimestamp"`
+	Collection       string   `json:"collection,omitempty"`
+	DailyQuota       int64    `json:"dailyquota,omitempty"`
+	MonthlyQuota     int64    `json:"monthlyquota,omitempty"`
+}
+
+// TokenCreateReply is returned by the server with the newly created api
+// token. The token can not be retrieved afterwards.
+type TokenCreateReply struct {
+	Token     string `json:"token"`
+	TokenInfo Token  `json:"tokeninfo"`
+}
+
+// Usage is used to retrieve the digest quotas and usage counters of the api
+// token of the request. Tokens with the admin scope may retrieve those of the
+// token with the provided TokenID instead.
+type Usage struct {
+	ID      string `json:"id"`
+	TokenID string `json:"tokenid,omitempty"`
+}
+
+// UsageReply is returned by the server with the quotas and usage counters of
+// an api token.
+type UsageReply struct {
+	ID           string     `json:"id"`
+	TokenID      string     `json:"tokenid"`
+	DailyQuota   int64      `json:"dailyquota"`
+	MonthlyQuota int64      `json:"monthlyquota"`
+	Usage        TokenUsage `json:"usage"`
+}
+
+// TokenRevoke is used to revoke the api token with the provided ID.
+type TokenRevoke struct {
+	ID string `json:"id"`
+}
+
+// TokenRevokeReply is returned by the server once the api token was revoked.
+type TokenRevokeReply struct {
+	ID string `json:"id"`
+}
+
+// Webhook events.
+const (
+	WebhookEventAnchored  = "anchored"  // Collection anchored with enough confirmations
+	WebhookEventIncluded  = "included"  // Subscribed anchor included in a block
+	WebhookEventConfirmed = "confirmed" // Subscribed anchor has enough confirmations
+)
+
+// Webhook is posted to the webhook URLs of an instance once a collection was
+// anchored with enough confirmations. ID identifies the delivery and stays the
+// same when the webhook is redelivered, receivers use it to detect duplicates.
+// Webhooks of a receiver are delivered in the order they were created unless
+// a delivery was retried out of the dead-letter queue.
+//
+// Webhooks of subscriptions are posted when the anchor of the collection is
+// included in a block and again when it has enough confirmations. They carry
+// the ID of the subscription and only the digests of the submission, if any.
+// The body of every webhook is signed with the identity key of the server in
+// the SignatureHeader, see VerifyReplySignature.
+type Webhook struct {
+	ID             string `json:"id"`
+	Event          string `json:"event"`
+	Network        string `json:"network"`
+	Anchor         Anchor `json:"anchor"`
+	SubscriptionID string `json:"subscriptionid,omitempty"`
+	Confirmations  *int32 `json:"confirmations,omitempty"`
+}
+
+// WebhookSubscribe is used to register a webhook URL for the anchor of the
+// collection with ServerTimestamp.
+type WebhookSubscribe struct {
+	ID              string `json:"id"`
+	ServerTimestamp int64  `json:"servertimestamp"`
+	URL             string `json:"url"`
+}
+
+// WebhookSubscribeReply is returned by the server once the webhook URL was
+// registered. SubscriptionID is carried by the webhooks of the subscription.
+type WebhookSubscribeReply struct {
+	ID             string `json:"id"`
+	SubscriptionID string `json:"subscriptionid"`
+}
+
+// WebhookDelivery describes a webhook that was not accepted by its receiver
+// yet. Dead deliveries exhausted their attempts and are only retried on
+// request. Payload is the Webhook that is delivered.
+type WebhookDelivery struct {
+	ID                   string          `json:"id"`
+	URL                  string          `json:"url"`
+	CreatedTimestamp     int64           `json:"createdtimestamp"`
+	CreatedTime          string          `json:"createdtime,omitempty"`
+	Attempts             int             `json:"attempts"`
+	NextAttemptTimestamp int64           `json:"nextattempttimestamp"`
+	NextAttemptTime      string          `json:"nextattempttime,omitempty"`
+	LastError            string          `json:"lasterror,omitempty"`
+	Dead                 bool            `json:"dead"`
+	Payload              json.RawMessage `json:"payload"`
+}
+
+// WebhooksReply is returned by the server with all pending and dead webhook
+// deliveries.
+type WebhooksReply struct {
+	Deliveries []WebhookDelivery `json:"deliveries"`
+}
+
+// WebhookRetry is used to retry the webhook delivery with the provided ID
+// right away. The attempts of the delivery are reset.
+type WebhookRetry struct {
+	ID string `json:"id"`
+}
+
+// WebhookRetryReply is returned by the server once the webhook delivery was
+// rescheduled.
+type WebhookRetryReply struct {
+	ID string `json:"id"`
+}
+
+// WebhookDelete is used to discard the webhook delivery with the provided ID.
+type WebhookDelete struct {
+	ID string `json:"id"`
+}
+
+// WebhookDeleteReply is returned by the server once the webhook delivery was
+// discarded.
+type WebhookDeleteReply struct {
+	ID string `json:"id"`
+}
+
+// ProofAudit is used to retrieve the audit trail of the proofs that were
+// served for a digest.
+type ProofAudit struct {
+	Digest string `json:"digest"`
+}
+
+// ProofRecord records that the proof of a digest was served. Token is the
+// public ID of the api token of the request or - if there was none.
+// RemoteAddr is the address of the client, followed by the proxies the
+// request passed through. Transaction, MerkleRoot and ChainTimestamp identify
+// the version of the proof that was served; ChainTimestamp is zero if the
+// anchor was not confirmed yet. Formats lists the encoded proofs that were
+// included, if any.
+type ProofRecord struct {
+	Timestamp      int64    `json:"timestamp"`
+	Time           string   `json:"time,omitempty"`
+	Token          string   `json:"token"`
+	RemoteAddr     string   `json:"remoteaddr"`
+	RequestID      string   `json:"requestid,omitempty"`
+	Route          string   `json:"route"`
+	Transaction    string   `json:"transaction"`
+	MerkleRoot     string   `json:"merkleroot"`
+	ChainTimestamp int64    `json:"chaintimestamp"`
+	ChainTime      string   `json:"chaintime,omitempty"`
+	Formats        []string `json:"formats,omitempty"`
+}
+
+// ProofAuditReply is returned by the server with the audit trail of a digest,
+// ordered by the time the proofs were served. Records is empty if the proof
+// of the digest was never served while proof auditing was enabled.
+type ProofAuditReply struct {
+	Digest  string        `json:"digest"`
+	Records []ProofRecord `json:"records"`
+}
+
+// CollectionStats is used to retrieve the statistics of all flushed
+// collections with server timestamps between FromTimestamp and ToTimestamp,
+// inclusive.
+type CollectionStats struct {
+	FromTimestamp int64 `json:"fromtimestamp"`
+	ToTimestamp   int64 `json:"totimestamp"`
+}
+
+// MaxCollectionStatsRange is the maximum number of seconds a CollectionStats
+// request may span.
+const MaxCollectionStatsRange = 31 * 24 * 60 * 60
+
+// CollectionStat describes what was submitted to a flushed collection and how
+// it was anchored. Submitted counts all submitted digests, including the
+// Duplicates that already existed and were rejected. Clients counts the
+// distinct api tokens that submitted digests; all submissions without a valid
+// api token count as a single client. Confirmed is set once the anchor has
+// enough confirmations, BlockHeight and ChainTimestamp are zero until then.
+type CollectionStat struct {
+	ServerTimestamp int64  `json:"servertimestamp"`
+	ServerTime      string `json:"servertime,omitempty"`
+	DigestCount     int    `json:"digestcount"`
+	Submitted       int64  `json:"submitted"`
+	Duplicates      int64  `json:"duplicates"`
+	Clients         int    `json:"clients"`
+	MerkleRoot      string `json:"merkleroot"`
+	Transaction     string `json:"transaction"`
+	Confirmed       bool   `json:"confirmed"`
+	BlockHeight     int32  `json:"blockheight"`
+	ChainTimestamp  int64  `json:"chaintimestamp"`
+	ChainTime       string `json:"chaintime,omitempty"`
+}
+
+// CollectionStatsReply is returned by the server with the statistics of all
+// flushed collections in the requested range, ordered by server timestamp.
+type CollectionStatsReply struct {
+	FromTimestamp int64            `json:"fromtimestamp"`
+	ToTimestamp   int64            `json:"totimestamp"`
+	Collections   []CollectionStat `json:"collections"`
+}
+
+// Export is used to export every digest of all flushed collections with server
+// timestamps between FromTimestamp and ToTimestamp, inclusive. Format defaults
+// to ExportFormatCSV, which is the only supported format.
+type Export struct {
+	FromTimestamp int64  `json:"fromtimestamp"`
+	ToTimestamp   int64  `json:"totimestamp"`
+	Format        string `json:"format,omitempty"`
+}
+
+// Export formats.
+const (
+	// ExportFormatCSV exports one digest per line, ordered by server
+	// timestamp, after a header line with the ExportColumns.
+	ExportFormatCSV = "csv"
+
+	// ExportCSVMIMEType is the content type of a CSV export.
+	ExportCSVMIMEType = "text/csv"
+)
+
+// ExportColumns are the columns of a CSV export. Digests, merkle roots and
+// transactions are hex encoded, timestamps are unix seconds. Blockheight and
+// chaintimestamp are zero until the anchor has enough confirmations.
+var ExportColumns = []string{"digest", "servertimestamp", "flushtimestamp",
+	"merkleroot", "transaction", "blockheight", "chaintimestamp"}
+
+// MaxExportRange is the maximum number of seconds an Export request may span.
+const MaxExportRange = 366 * 24 * 60 * 60
+
+// Replication is used by a standby server to replicate every collection with a
+// server timestamp at or after Since. The reply is a stream of newline
+// delimited collections, flushed or not, ordered by server timestamp. The
+// format of the collections is internal to dcrtimed and may change between
+// releases.
+type Replication struct {
+	Since int64 `json:"since"`
+}
+
+// ReplicationMIMEType is the content type of a replication stream.
+const ReplicationMIMEType = "application/x-ndjson"
+
+// Maintenance is used to start or end maintenance mode. Digests are accepted
+// and queued, but neither flushed nor anchored, while the server is in
+// maintenance mode. The queued collections are flushed when it ends.
+type Maintenance struct {
+	Enable bool `json:"enable"`
+}
+
+// MaintenanceReply is returned by the server once maintenance mode was started
+// or ended.
+type MaintenanceReply struct {
+	Maintenance bool `json:"maintenance"`
+}
+
+// AdminConfig contains the configuration of a dcrtimed instance. Passwords,
+// keys and api tokens are never included. StoreTimeout and AnchorRetry are
+// expressed in milliseconds.
+type AdminConfig struct {
+	Listeners         []string         `json:"listeners"`
+	PublicListeners   []string         `json:"publiclisteners,omitempty"`
+	SubmitListeners   []string         `json:"submitlisteners,omitempty"`
+	AdminListeners    []string         `json:"adminlisteners,omitempty"`
+	APIVersions       string           `json:"apiversions"`
+	DataDir           string           `json:"datadir"`
+	LogDir            string           `json:"logdir"`
+	DebugLevel        string           `json:"debuglevel"`
+	WalletHost        string           `json:"wallethost,omitempty"`
+	WalletAccount     string           `json:"walletaccount,omitempty"`
+	DcrdHost          string           `json:"dcrdhost,omitempty"`
+	AnchorFeeMode     string           `json:"anchorfeemode,omitempty"`
+	AnchorFeeRate     int64            `json:"anchorfeerate,omitempty"`
+	AnchorMaxFee      int64            `json:"anchormaxfee,omitempty"`
+	LowBalance        int64            `json:"lowbalance,omitempty"`
+	LowBalancePause   bool             `json:"lowbalancepause,omitempty"`
+	AnchorPrefix      string           `json:"anchorprefix,omitempty"`
+	AnchorRetry       int64            `json:"anchorretry,omitempty"`
+	AnchorBlocks      int32            `json:"anchorblocks,omitempty"`
+	Anchorers         []string         `json:"anchorers,omitempty"`
+	StoreHost         string           `json:"storehost,omitempty"`
+	StoreFailoverHost string           `json:"storefailoverhost,omitempty"`
+	StoreTimeout      int64            `json:"storetimeout,omitempty"`
+	StoreFanoutHosts  []string         `json:"storefanouthosts,omitempty"`
+	StoreQuorum       int              `json:"storequorum,omitempty"`
+	EnableCollections bool             `json:"enablecollections"`
+	Confirmations     int32            `json:"confirmations"`
+	MaxDigests        int32            `json:"maxdigests"`
+	ScopeMaxDigests   map[string]int32 `json:"scopemaxdigests,omitempty"`
+	VerifyCacheSize   int              `json:"verifycachesize"`
+	RestrictAPI       bool             `json:"restrictapi"`
+	PrivateDigests    bool             `json:"privatedigests"`
+	ProofAudit        bool             `json:"proofaudit"`
+	ReadOnly          bool             `json:"readonly"`
+	MaintenanceQueue  int64            `json:"maintenancequeue,omitempty"`
+	AnnounceURL       string           `json:"announceurl,omitempty"`
+	PublicURL         string           `json:"publicurl,omitempty"`
+	SelfAuditInterval int64            `json:"selfauditinterval,omitempty"`
+	SelfAuditSamples  int              `json:"selfauditsamples,omitempty"`
+}
+
+// AdminStatusReply is returned by the server on an admin status request. It
+// describes the runtime state of the daemon. Errors are only set when the
+// wallet or the backend are unhealthy. AnchorError is set while the last
+// anchor transaction could not be published, e.g. because its fee exceeded
+// the maximum fee. LastFlushTimestamp is zero if no
+// collection was flushed yet. Maintenance is set while digests are queued but
+// not flushed, PendingDigests is then the depth of the queue. WalletBalance is
+// the balance of the anchoring wallet in atoms. LowBalance is set while it is
+// below the configured low balance and FlushPaused while digests are queued
+// because of it. SelfAudit is only set when self audits are enabled.
+type AdminStatusReply struct {
+	Version                 string              `json:"version"`
+	Network                 string              `json:"network"`
+	StartTimestamp          int64               `json:"starttimestamp"`
+	StartTime               string              `json:"starttime,omitempty"`
+	Config                  AdminConfig         `json:"config"`
+	LastFlushTimestamp      int64               `json:"lastflushtimestamp"`
+	LastFlushTime           string              `json:"lastflushtime,omitempty"`
+	LastFlushTransaction    string              `json:"lastflushtransaction,omitempty"`
+	LastFlushChainTimestamp int64               `json:"lastflushchaintimestamp"`
+	LastFlushChainTime      string              `json:"lastflushchaintime,omitempty"`
+	PendingDigests          int64               `json:"pendingdigests"`
+	Maintenance             bool                `json:"maintenance"`
+	WalletConnected         bool                `json:"walletconnected"`
+	WalletError             string              `json:"walleterror,omitempty"`
+	WalletBalance           *WalletBalanceReply `json:"walletbalance,omitempty"`
+	LowBalance              bool                `json:"lowbalance"`
+	FlushPaused             bool                `json:"flushpaused"`
+	AnchorError             string              `json:"anchorerror,omitempty"`
+	BackendHealthy          bool                `json:"backendhealthy"`
+	BackendError            string              `json:"backenderror,omitempty"`
+	VerifyCache             *VerifyCacheStats   `json:"verifycache,omitempty"`
+	SelfAudit               *SelfAuditStats     `json:"selfaudit,omitempty"`
+}
+
+// SelfAuditStats describes the self audits of anchored digests since the start
+// of day. Failures counts the sampled digests whose merkle path or anchor did
+// not verify and the audits that could not be completed. LastError describes
+// the last failure, if any.
+type SelfAuditStats struct {
+	Audits        uint64 `json:"audits"`
+	Samples       uint64 `json:"samples"`
+	Failures      uint64 `json:"failures"`
+	LastTimestamp int64  `json:"lasttimestamp"`
+	LastTime      string `json:"lasttime,omitempty"`
+	LastError     string `json:"lasterror,omitempty"`
+}
+
+// VerifyCacheStats describes the cache of anchored timestamp proofs that is
+// used to answer verify requests. HitRate is the fraction of digest lookups
+// that were answered from the cache since the start of day.
+type VerifyCacheStats struct {
+	Size    int     `json:"size"`
+	Entries int     `json:"entries"`
+	Hits    uint64  `json:"hits"`
+	Misses  uint64  `json:"misses"`
+	HitRate float64 `json:"hitrate"`
+}
+
+// Collections is used to list the collections that the api token of the
+// request timestamped digests in. If Name is set only the collections of
+// that subtree are listed, which are the collection with that name and all
+// collections nested below it.
+type Collections struct {
+	ID   string `json:"id"`
+	Name string `json:"name,omitempty"`
+}
+
+// Collection describes a collection that the api token of the request
+// timestamped digests in. DigestCount only counts the digests of the token,
+// collections are shared with other clients. A collection that was not
+// anchored yet can be deleted if it only holds digests of the token.
+type Collection struct {
+	ServerTimestamp int64  `json:"servertimestamp"`
+	ServerTime      string `json:"servertime,omitempty"`
+	Name            string `json:"name,omitempty"`
+	DigestCount     int    `json:"digestcount"`
+	Anchored        bool   `json:"anchored"`
+}
+
+// CollectionsReply is returned by the server with the collections of the api
+// token, ordered by timestamp.
+type CollectionsReply struct {
+	ID          string       `json:"id"`
+	Collections []Collection `json:"collections"`
+}
+
+// CollectionRename is used to give a collection a human-readable name. An
+// empty name removes the name.
+type CollectionRename struct {
+	ID              string `json:"id"`
+	ServerTimestamp int64  `json:"servertimestamp"`
+	Name            string `json:"name"`
+}
+
+// CollectionRenameReply is returned by the server once the collection was
+// renamed.
+type CollectionRenameReply struct {
+	ID              string `json:"id"`
+	ServerTimestamp int64  `json:"servertimestamp"`
+	Name            string `json:"name"`
+}
+
+// CollectionDelete is used to delete a collection together with its digests.
+type CollectionDelete struct {
+	ID              string `json:"id"`
+	ServerTimestamp int64  `json:"servertimestamp"`
+}
+
+// CollectionDeleteReply is returned by the server once the collection was
+// deleted.
+type CollectionDeleteReply struct {
+	ID              string `json:"id"`
+	ServerTimestamp int64  `json:"servertimestamp"`
+}
+
+// Search statuses.
+const (
+	SearchStatusAnchored = "anchored"
+	SearchStatusPending  = "pending"
+)
+
+// MaxSearchResults is the maximum, and default, number of digests in a
+// SearchReply.
+const MaxSearchResults = 1000
+
+// Search is used to find the digests that the api token of the request
+// timestamped. All filters are optional and combined. Name selects a
+// collection subtree like in Collections. From and To bound the server
+// timestamps of the collections, inclusive. Status selects the digests of
+// anchored or pending collections. MetadataKey selects the digests with that
+// metadata key and MetadataValue, if set, with that value. Up to Limit digests
+// are returned per page, Cursor is the NextCursor of the previous page.
+type Search struct {
+	ID            string `json:"id"`
+	Name          string `json:"name,omitempty"`
+	From          int64  `json:"from,omitempty"`
+	To            int64  `json:"to,omitempty"`
+	Status        string `json:"status,omitempty"`
+	MetadataKey   string `json:"metadatakey,omitempty"`
+	MetadataValue string `json:"metadatavalue,omitempty"`
+	Limit         int    `json:"limit,omitempty"`
+	Cursor        string `json:"cursor,omitempty"`
+}
+
+// SearchDigest is a digest that matched a Search. Name is the name of its
+// collection, if any.
+type SearchDigest struct {
+	Digest          string            `json:"digest"`
+	ServerTimestamp int64             `json:"servertimestamp"`
+	ServerTime      string            `json:"servertime,omitempty"`
+	Name            string            `json:"name,omitempty"`
+	Anchored        bool              `json:"anchored"`
+	Metadata        map[string]string `json:"metadata,omitempty"`
+}
+
+// SearchReply is returned by the server with the digests that matched a
+// Search, ordered by server timestamp and digest. NextCursor continues the
+// search on the next page, it is empty on the last page.
+type SearchReply struct {
+	ID         string         `json:"id"`
+	Digests    []SearchDigest `json:"digests"`
+	NextCursor string         `json:"nextcursor,omitempty"`
+}
+
+// CollectionReceipt is used to retrieve a receipt for the digests of all
+// collections of a collection subtree. An empty name covers all collections
+// of the api token.
+type CollectionReceipt struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// CollectionProof proves the digests that the api token timestamped in a
+// collection. Once the collection is anchored MerkleBranch authenticates
+// all Digests, in tree order, against MerkleRoot, which is stored in
+// Transaction.
+type CollectionProof struct {
+	ServerTimestamp int64       `json:"servertimestamp"`
+	ServerTime      string      `json:"servertime,omitempty"`
+	Name            string      `json:"name,omitempty"`
+	Anchored        bool        `json:"anchored"`
+	ChainTimestamp  int64       `json:"chaintimestamp,omitempty"`
+	ChainTime       string      `json:"chaintime,omitempty"`
+	Transaction     string      `json:"transaction,omitempty"`
+	MerkleRoot      string      `json:"merkleroot,omitempty"`
+	MerkleBranch    *BranchJSON `json:"merklebranch,omitempty"`
+	Digests         []string    `json:"digests"`
+}
+
+// CollectionReceiptReply is the receipt of a collection subtree. It holds a
+// proof per collection, ordered by timestamp. DigestCount is the number of
+// digests of all collections and AnchoredCount the number of those that are
+// anchored.
+type CollectionReceiptReply struct {
+	ID            string            `json:"id"`
+	Name          string            `json:"name"`
+	DigestCount   int               `json:"digestcount"`
+	AnchoredCount int               `json:"anchoredcount"`
+	Collections   []CollectionProof `json:"collections"`
+}
+
+// Anchors is used to ask the server for all collections that were anchored in
+// blocks between FromHeight and ToHeight, inclusive. The range may not exceed
+// MaxAnchorsHeightRange blocks.
+type Anchors struct {
+	ID         string `json:"id"`
+	FromHeight int32  `json:"fromheight"`
+	ToHeight   int32  `json:"toheight"`
+}
+
+// MaxAnchorsHeightRange is the maximum number of blocks an Anchors request
+// may span.
+const MaxAnchorsHeightRange = 10000
+
+// Anchor describes a collection that was anchored in a block. Digests is only
+// set for clients with an api token with the verify scope, everybody else only
+// receives DigestCount.
+type Anchor struct {
+	ServerTimestamp int64    `json:"servertimestamp"`
+	ServerTime      string   `json:"servertime,omitempty"`
+	Transaction     string   `json:"transaction"`
+	MerkleRoot      string   `json:"merkleroot"`
+	BlockHeight     int32    `json:"blockheight"`
+	ChainTimestamp  int64    `json:"chaintimestamp"`
+	ChainTime       string   `json:"chaintime,omitempty"`
+	DigestCount     int      `json:"digestcount"`
+	Digests         []string `json:"digests,omitempty"`
+}
+
+// AnchorsReply is returned by the server with all collections that were
+// anchored in the requested block height range, ordered by block height.
+// Anchors without enough confirmations are not included.
+type AnchorsReply struct {
+	ID         string   `json:"id"`
+	FromHeight int32    `json:"fromheight"`
+	ToHeight   int32    `json:"toheight"`
+	Anchors    []Anchor `json:"anchors"`
+}
+
+// IdentityKey is a hex encoded Ed25519 public identity key of the server.  The
+// server signs with the key from NotBefore on and retires it at NotAfter, when
+// the next key of a key rotation takes over.  NotAfter is zero while the key
+// is not retired.  Signatures that were made before a key was retired remain
+// valid.
+type IdentityKey struct {
+	PublicKey string `json:"publickey"`
+	NotBefore int64  `json:"notbefore"`
+	NotAfter  int64  `json:"notafter,omitempty"`
+}
+
+// Trusted returns whether the key is trusted at the provided unix time, i.e.
+// whether it was not retired yet.  Keys of a rotation that did not take over
+// yet are trusted so that replies signed around the rotation verify despite
+// clock differences.
+func (k IdentityKey) Trusted(t int64) bool {
+	return k.NotAfter == 0 || t < k.NotAfter
+}
+
+// IdentityReply is returned by the server with the hex encoded Ed25519 public
+// key that verifies the signatures of its timestamp and verify replies.  Keys
+// lists all identity keys of the server, including retired keys and the next
+// key of a key rotation that did not take over yet.
+type IdentityReply struct {
+	PublicKey string        `json:"publickey"`
+	Keys      []IdentityKey `json:"keys,omitempty"`
+}
+
+// TrustBundleVersion is the version of the trust bundle format.
+const TrustBundleVersion = 1
+
+// TrustBundle is a set of identity keys of a server that a client pins
+// instead of trusting the key the server publishes.  It is exported by the
+// server operator and replaced by a new export during the overlap period of a
+// key rotation.
+type TrustBundle struct {
+	Version int           `json:"version"`
+	Keys    []IdentityKey `json:"keys"`
+}
+
+// DecodeTrustBundle decodes and validates a JSON encoded trust bundle.
+func DecodeTrustBundle(b []byte) (*TrustBundle, error) {
+	var tb TrustBundle
+	if err := json.Unmarshal(b, &tb); err != nil {
+		return nil, fmt.Errorf("invalid trust bundle: %v", err)
+	}
+	if tb.Version != TrustBundleVersion {
+		return nil, fmt.Errorf("unsupported trust bundle version %v",
+			tb.Version)
+	}
+	if len(tb.Keys) == 0 {
+		return nil, errors.New("trust bundle without keys")
+	}
+	for _, k := range tb.Keys {
+		pk, err := hex.DecodeString(k.PublicKey)
+		if err != nil || len(pk) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid public key: %v",
+				k.PublicKey)
+		}
+		if k.NotAfter != 0 && k.NotAfter < k.NotBefore {
+			return nil, fmt.Errorf("key %v retired before it "+
+				"took over", k.PublicKey)
+		}
+	}
+	return &tb, nil
+}
+
+// VerifyIdentity returns an error if the current identity key of the provided
+// identity reply is not a key of the bundle that is trusted at the unix time t
+// the reply was received.
+func (tb *TrustBundle) VerifyIdentity(ir IdentityReply, t int64) error {
+	for _, k := range tb.Keys {
+		if k.PublicKey != ir.PublicKey {
+			continue
+		}
+		if !k.Trusted(t) {
+			return fmt.Errorf("identity key %v is retired",
+				ir.PublicKey)
+		}
+		return nil
+	}
+	return fmt.Errorf("untrusted identity key %v", ir.PublicKey)
+}
+
+// VerifyReply returns an error if the provided signature, as found in the
+// SignatureHeader, is not a valid signature of the reply body by a key of the
+// bundle that was trusted at the unix time t the reply was received.
+func (tb *TrustBundle) VerifyReply(signature string, body []byte, t int64) error {
+	for _, k := range tb.Keys {
+		if !k.Trusted(t) {
+			continue
+		}
+		ok, err := verifySignature(k.PublicKey, signature, body)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+	}
+	return errors.New("reply not signed by a trusted key")
+}
+
+// VerifyPendingReceipt returns an error if the provided pending receipt was not
+// signed by a key of the bundle that was trusted when the collection of the
+// receipt started.
+func (tb *TrustBundle) VerifyPendingReceipt(pr PendingReceipt) error {
+	for _, k := range tb.Keys {
+		if k.PublicKey == pr.PublicKey {
+			if !k.Trusted(pr.ServerTimestamp) {
+				return fmt.Errorf("pending receipt of %v signed "+
+					"by retired key %v", pr.Digest,
+					pr.PublicKey)
+			}
+			return VerifyPendingReceipt(pr.PublicKey, pr)
+		}
+	}
+	return fmt.Errorf("pending receipt of %v signed by untrusted key %v",
+		pr.Digest, pr.PublicKey)
+}
+
+// Bloom is used to ask the server for the Bloom filter of the digests of the
+// anchored collection with the provided server timestamp.
+type Bloom struct {
+	ID              string `json:"id"`
+	ServerTimestamp int64  `json:"servertimestamp"`
+}
+
+// BloomReply is returned by the server with the Bloom filter of the digests of
+// an anchored collection. Filter is base64 encoded and uses Hashes hash
+// functions, see the bloom package for the positions of a digest. A digest
+// that is not in the filter was definitely not anchored in the collection, a
+// digest that is has to be verified. Result is ResultDoesntExistError if the
+// collection does not exist or was not anchored with enough confirmations
+// yet.
+type BloomReply struct {
+	ID              string  `json:"id"`
+	ServerTimestamp int64   `json:"servertimestamp"`
+	ServerTime      string  `json:"servertime,omitempty"`
+	MerkleRoot      string  `json:"merkleroot,omitempty"`
+	Transaction     string  `json:"transaction,omitempty"`
+	ChainTimestamp  int64   `json:"chaintimestamp,omitempty"`
+	ChainTime       string  `json:"chaintime,omitempty"`
+	DigestCount     int     `json:"digestcount"`
+	Hashes          uint32  `json:"hashes,omitempty"`
+	Filter          string  `json:"filter,omitempty"`
+	Result          ResultT `json:"result"`
+}
+
+// AnchorChain is used to ask the server for the chain that links the anchor
+// of the collection with the provided server timestamp to the nearest
+// published Decred checkpoint.
+type AnchorChain struct {
+	ID              string `json:"id"`
+	ServerTimestamp int64  `json:"servertimestamp"`
+}
+
+// AnchorChainReply is returned by the server with the chain that links the
+// anchor of a collection to a checkpoint. RawTransaction is the serialized
+// anchor transaction. TxBranch is its merkle branch in the regular
+// transaction tree of its block, from the full hash of the transaction up to
+// the root, and bit n of TxIndex is set if the node at level n is a right
+// child. Headers are the serialized block headers from the anchor block up to
+// and including the checkpoint block. Hashes, the transaction and the headers
+// are hex encoded.
+//
+// Result is ResultDoesntExistError if the collection does not exist, was not
+// anchored with enough confirmations yet or no checkpoint was published at or
+// above its block yet. It is ResultDisabled if the server can not retrieve
+// blocks.
+type AnchorChainReply struct {
+	ID               string   `json:"id"`
+	ServerTimestamp  int64    `json:"servertimestamp"`
+	ServerTime       string   `json:"servertime,omitempty"`
+	MerkleRoot       string   `json:"merkleroot,omitempty"`
+	Transaction      string   `json:"transaction,omitempty"`
+	BlockHeight      int32    `json:"blockheight,omitempty"`
+	CheckpointHeight int32    `json:"checkpointheight,omitempty"`
+	CheckpointHash   string   `json:"checkpointhash,omitempty"`
+	RawTransaction   string   `json:"rawtransaction,omitempty"`
+	TxIndex          uint32   `json:"txindex"`
+	TxBranch         []string `json:"txbranch,omitempty"`
+	Headers          []string `json:"headers,omitempty"`
+	Result           ResultT  `json:"result"`
+}
+
+// SignatureHeader carries the hex encoded Ed25519 signature of the identity
+// key of the server over the body of a timestamp or verify reply.  Clients
+// that keep the body and the signature can prove that the server accepted a
+// digest even if it was never anchored.
+const SignatureHeader = "X-Dcrtime-Signature"
+
+// IdempotencyKeyHeader carries a key chosen by the client that makes a
+// timestamp request safe to retry.  The server replays the successful reply of
+// the first request with the same key, api token and body instead of
+// submitting the digests again, which would report them as existing.  Keys may
+// be up to 255 characters long and are remembered for up to a day.
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// PendingReceipt is a statement, signed with the identity key of the server,
+// that a digest was accepted into the collection with ServerTimestamp and that
+// the collection stops accepting digests and is flushed at EndTimestamp or, if
+// collections are anchored every n blocks, at block height EndHeight.  Both
+// are zero if the server did not know when the collection is flushed.  It lets
+// clients prove that the server accepted a digest before it was anchored.
+// Once the collection was anchored the receipt is upgraded to a full proof by
+// verifying the digest.
+type PendingReceipt struct {
+	Digest          string `json:"digest"`
+	Algorithm       string `json:"algorithm,omitempty"`
+	ServerTimestamp int64  `json:"servertimestamp"`
+	ServerTime      string `json:"servertime,omitempty"`
+	EndTimestamp    int64  `json:"endtimestamp,omitempty"`
+	EndTime         string `json:"endtime,omitempty"`
+	EndHeight       int32  `json:"endheight,omitempty"`
+	PublicKey       string `json:"publickey"`
+	Signature       string `json:"signature"`
+}
+
+// pendingReceiptVersion prefixes the signed message of a pending receipt.  It
+// separates receipt signatures from reply signatures, which are made with the
+// same key.
+const pendingReceiptVersion = "dcrtime pending receipt v1"
+
+// Message returns the message the server signs to issue the receipt.  The
+// formatted times and the public key are not signed.
+func (pr PendingReceipt) Message() []byte {
+	return []byte(fmt.Sprintf("%v\n%v\n%v\n%v\n%v\n%v\n",
+		pendingReceiptVersion, pr.Digest, pr.Algorithm,
+		pr.ServerTimestamp, pr.EndTimestamp, pr.EndHeight))
+}
+
+// VerifyPendingReceipt returns an error if the provided pending receipt was not
+// signed by the provided public key, as returned by the identity route.
+func VerifyPendingReceipt(publicKey string, pr PendingReceipt) error {
+	if pr.PublicKey != publicKey {
+		return fmt.Errorf("pending receipt of %v signed by %v",
+			pr.Digest, pr.PublicKey)
+	}
+	ok, err := verifySignature(publicKey, pr.Signature, pr.Message())
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("signature does not match the pending "+
+			"receipt of %v", pr.Digest)
+	}
+	return nil
+}
+
+// VerifyReplySignature returns an error if the provided signature, as found in
+// the SignatureHeader, is not a valid signature of the reply body by the
+// provided public key, as returned by the identity route.
+func VerifyReplySignature(publicKey, signature string, body []byte) error {
+	ok, err := verifySignature(publicKey, signature, body)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("signature does not match the reply")
+	}
+	return nil
+}
+
+// verifySignature returns whether the provided hex encoded signature is a
+// valid signature of the message by the hex encoded public key.
+func verifySignature(publicKey, signature string, message []byte) (bool, error) {
+	pk, err := hex.DecodeString(publicKey)
+	if err != nil || len(pk) != ed25519.PublicKeySize {
+		return false, fmt.Errorf("invalid public key: %v", publicKey)
+	}
+	sig, err := hex.DecodeString(signature)
+	if err != nil || len(sig) != ed25519.SignatureSize {
+		return false, fmt.Errorf("invalid signature: %v", signature)
+	}
+	return ed25519.Verify(ed25519.PublicKey(pk), message, sig), nil
+}
@@ -0,0 +1,117 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package bloom
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+const (
+	// DefaultFalsePositiveRate is the false positive rate of the filters
+	// dcrtimed publishes.
+	DefaultFalsePositiveRate = 0.01
+
+	// MaxHashes is the maximum number of hash functions of a filter.
+	MaxHashes = 32
+
+	// MaxFilterSize is the maximum size of a filter in bytes.
+	MaxFilterSize = 32 * 1024 * 1024
+)
+
+var (
+	// ErrInvalidFilter is returned when a filter can not be loaded.
+	ErrInvalidFilter = errors.New("invalid bloom filter")
+)
+
+// Filter is a Bloom filter of digests.  Digests are uniformly distributed
+// already, so the positions of a digest are derived from the digest itself
+// by double hashing: with h1 and h2 the first two little endian uint64 of the
+// digest, and h2 made odd, position i is (h1 + i*h2) modulo 2^64 modulo the
+// number of bits.  Bit n is bit n%8 of byte n/8.
+type Filter struct {
+	bits   []byte
+	hashes uint32
+}
+
+// New returns an empty filter for n digests with the provided false positive
+// rate.
+func New(n int, p float64) *Filter {
+	if n < 1 {
+		n = 1
+	}
+	if p <= 0 || p >= 1 {
+		p = DefaultFalsePositiveRate
+	}
+
+	m := math.Ceil(-float64(n) * math.Log(p) / (math.Ln2 * math.Ln2))
+	size := int(math.Min(math.Ceil(m/8), MaxFilterSize))
+	k := math.Round(float64(size*8) / float64(n) * math.Ln2)
+	k = math.Max(1, math.Min(k, MaxHashes))
+
+	return &Filter{
+		bits:   make([]byte, size),
+		hashes: uint32(k),
+	}
+}
+
+// Load returns the filter with the provided bits and number of hash
+// functions, as published by dcrtimed.
+func Load(bits []byte, hashes uint32) (*Filter, error) {
+	if len(bits) == 0 || len(bits) > MaxFilterSize {
+		return nil, ErrInvalidFilter
+	}
+	if hashes == 0 || hashes > MaxHashes {
+		return nil, ErrInvalidFilter
+	}
+	return &Filter{
+		bits:   bits,
+		hashes: hashes,
+	}, nil
+}
+
+// positions calls fn with every bit position of the digest.  It stops early
+// if fn returns false.
+func (f *Filter) positions(digest [sha256.Size]byte, fn func(uint64) bool) {
+	m := uint64(len(f.bits)) * 8
+	h1 := binary.LittleEndian.Uint64(digest[0:8])
+	h2 := binary.LittleEndian.Uint64(digest[8:16]) | 1
+	for i := uint64(0); i < uint64(f.hashes); i++ {
+		if !fn((h1 + i*h2) % m) {
+			return
+		}
+	}
+}
+
+// Add adds the digest to the filter.
+func (f *Filter) Add(digest [sha256.Size]byte) {
+	f.positions(digest, func(n uint64) bool {
+		f.bits[n/8] |= 1 << (n % 8)
+		return true
+	})
+}
+
+// Contains returns false if the digest was definitely not added to the
+// filter.  True means it possibly was.
+func (f *Filter) Contains(digest [sha256.Size]byte) bool {
+	found := true
+	f.positions(digest, func(n uint64) bool {
+		found = f.bits[n/8]&(1<<(n%8)) != 0
+		return found
+	})
+	return found
+}
+
+// Bytes returns the bits of the filter.
+func (f *Filter) Bytes() []byte {
+	return f.bits
+}
+
+// Hashes returns the number of hash functions of the filter.
+func (f *Filter) Hashes() uint32 {
+	return f.hashes
+}
@@ -0,0 +1,84 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package bloom
+
+import (
+	"crypto/sha256"
+	"errors"
+	"testing"
+)
+
+// testDigest returns a distinct digest for every seed and index.
+func testDigest(seed byte, i int) [sha256.Size]byte {
+	return sha256.Sum256([]byte{seed, byte(i), byte(i >> 8), byte(i >> 16)})
+}
+
+func TestFilter(t *testing.T) {
+	const n = 10000
+	f := New(n, DefaultFalsePositiveRate)
+	for i := 0; i < n; i++ {
+		f.Add(testDigest(0, i))
+	}
+
+	// Added digests are always found.
+	for i := 0; i < n; i++ {
+		if !f.Contains(testDigest(0, i)) {
+			t.Fatalf("digest %v not found", i)
+		}
+	}
+
+	// Other digests are found at about the false positive rate.
+	var fp int
+	for i := 0; i < n; i++ {
+		if f.Contains(testDigest(1, i)) {
+			fp++
+		}
+	}
+	if fp > n*2*DefaultFalsePositiveRate {
+		t.Fatalf("got %v false positives out of %v", fp, n)
+	}
+
+	// A loaded filter answers the same.
+	l, err := Load(f.Bytes(), f.Hashes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < n; i++ {
+		if l.Contains(testDigest(0, i)) != f.Contains(testDigest(0, i)) ||
+			l.Contains(testDigest(1, i)) != f.Contains(testDigest(1, i)) {
+			t.Fatalf("loaded filter differs at %v", i)
+		}
+	}
+}
+
+func TestEmptyFilter(t *testing.T) {
+	f := New(0, DefaultFalsePositiveRate)
+	if len(f.Bytes()) == 0 || f.Hashes() == 0 {
+		t.Fatalf("got %v bytes and %v hashes", len(f.Bytes()),
+			f.Hashes())
+	}
+	if f.Contains(testDigest(0, 0)) {
+		t.Fatal("empty filter contains digest")
+	}
+}
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name   string
+		bits   []byte
+		hashes uint32
+	}{
+		{"no bits", nil, 1},
+		{"no hashes", []byte{0}, 0},
+		{"too many hashes", []byte{0}, MaxHashes + 1},
+	}
+	for _, test := range tests {
+		_, err := Load(test.bits, test.hashes)
+		if !errors.Is(err, ErrInvalidFilter) {
+			t.Fatalf("%v: got %v, want %v", test.name, err,
+				ErrInvalidFilter)
+		}
+	}
+}
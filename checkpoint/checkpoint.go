@@ -0,0 +1,231 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+// Package checkpoint verifies that an anchor transaction is part of the
+// Decred main chain without the full header history.  A chain links the
+// anchor to a checkpoint, a block that was published as part of the main chain
+// by a dcrd release: the anchor transaction, its merkle branch in the regular
+// transaction tree of its block and the headers from its block up to and
+// including the checkpoint.
+package checkpoint
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+
+	"github.com/decred/dcrd/chaincfg/chainhash"
+	"github.com/decred/dcrd/wire"
+)
+
+var (
+	// ErrNoCheckpoint is returned when no checkpoint was published at or
+	// above the height of an anchor yet.
+	ErrNoCheckpoint = errors.New("no checkpoint")
+
+	// ErrInvalidChain is returned when a chain does not link an anchor to
+	// its checkpoint.
+	ErrInvalidChain = errors.New("invalid checkpoint chain")
+)
+
+// Checkpoint is a block that was published as part of the main chain.
+type Checkpoint struct {
+	Height int32
+	Hash   chainhash.Hash
+}
+
+// newHashFromStr converts the passed big-endian hex string into a
+// chainhash.Hash.  It only differs from the one available in chainhash in that
+// it panics on an error since it will only be called with hard-coded values.
+func newHashFromStr(hexStr string) chainhash.Hash {
+	hash, err := chainhash.NewHashFromStr(hexStr)
+	if err != nil {
+		panic(err)
+	}
+	return *hash
+}
+
+var (
+	// MainNet contains the checkpoints of the main network ordered from
+	// oldest to newest.  These are the manual checkpoints of dcrd followed
+	// by its assumed valid blocks.
+	MainNet = []Checkpoint{
+		{440, newHashFromStr("0000000000002203eb2c95ee96906730bb56b2985e174518f90eb4db29232d93")},
+		{24480, newHashFromStr("0000000000000c9d4239c4ef7ef3fb5aaeed940244bc69c57c8c5e1f071b28a6")},
+		{48590, newHashFromStr("0000000000000d5e0de21a96d3c965f5f2db2c82612acd7389c140c9afe92ba7")},
+		{54770, newHashFromStr("00000000000009293d067b1126b7de07fc9b2b94ee50dfe0d48c239a7adb072c")},
+		{60720, newHashFromStr("0000000000000a64475d68ffb9ad89a3d147c0f5138db26b40da9d19d0004117")},
+		{65270, newHashFromStr("0000000000000021f107601962789b201f0a0cbb98ac5f8c12b93d94e795b441")},
+		{75380, newHashFromStr("0000000000000e7d13cfc85806aa720fe3670980f5b7d33253e4f41985558372")},
+		{85410, newHashFromStr("00000000000013ec928074bea6eac9754aa614c7acb20edf300f18b0cd122692")},
+		{99880, newHashFromStr("0000000000000cb2a9a9ded647b9f78aae51ace32dd8913701d420ead272913c")},
+		{123080, newHashFromStr("000000000000009ea6e02d0f0424f445ed50686f9ae4aecdf3b268e981114477")},
+		{135960, newHashFromStr("00000000000001d2f9bbca9177972c0ba45acb40836b72945a75d73b99079498")},
+		{139740, newHashFromStr("00000000000001397179ae1aff156fb1aea228938d06b83e43b78b1c44527b5b")},
+		{155900, newHashFromStr("000000000000008557e37fb05177fc5a54e693de20689753639135f85a2dcb2e")},
+		{164300, newHashFromStr("000000000000009ed067ff51cd5e15f3c786222a5183b20a991a80ce535907a9")},
+		{181020, newHashFromStr("00000000000000b77d832cb2cbed02908d69323862a53e56345400ad81a6fb8f")},
+		{189950, newHashFromStr("000000000000007341d8ae2ea7e41f25cee00e1a70a4a3dc1cb055d14ecb2e11")},
+		{214672, newHashFromStr("0000000000000021d5cbeead55cb7fd659f07e8127358929ffc34cd362209758")},
+		{259810, newHashFromStr("0000000000000000ee0fbf469a9f32477ffbb46ebd7a280a53c842ab4243f97c")},
+		{295940, newHashFromStr("0000000000000000148852c8a919addf4043f9f267b13c08df051d359f1622ca")},
+		{384170, newHashFromStr("00000000000000001704bbc6bda8c4864a71cd0febcc0b44d753c69d83840f04")},
+		{766600, newHashFromStr("00000000000000008e50a3c18725d7272ec0057999a64aa62c15f398ffb7a0d7")},
+	}
+
+	// TestNet3 contains the checkpoints of the test network (version 3)
+	// ordered from oldest to newest.
+	TestNet3 = []Checkpoint{
+		{83520, newHashFromStr("0000000001e6244d95feae8b598e854905158c7bc781daf874afff88675ef0c8")},
+		{282340, newHashFromStr("0000001f538d6343316fe50709fa544b680a1be38141d003e755da8ad30f67a8")},
+		{1138320, newHashFromStr("00000000787986d95cef3b42beee6cc3c8065f6e5338e17854b1ff4d16b6a396")},
+	}
+)
+
+// Network returns the checkpoints of the network with the provided name.
+// Networks without published checkpoints, such as simnet, return nil.
+func Network(name string) []Checkpoint {
+	switch name {
+	case "mainnet":
+		return MainNet
+	case "testnet3":
+		return TestNet3
+	}
+	return nil
+}
+
+// Nearest returns the oldest of the provided checkpoints at or above the
+// provided height.  ErrNoCheckpoint is returned if there is none.
+func Nearest(checkpoints []Checkpoint, height int32) (*Checkpoint, error) {
+	for k := range checkpoints {
+		if checkpoints[k].Height >= height {
+			cp := checkpoints[k]
+			return &cp, nil
+		}
+	}
+	return nil, ErrNoCheckpoint
+}
+
+// hashPair returns the BLAKE-256 hash of the concatenation of two hashes.
+func hashPair(left, right *chainhash.Hash) chainhash.Hash {
+	var b [chainhash.HashSize * 2]byte
+	copy(b[:], left[:])
+	copy(b[chainhash.HashSize:], right[:])
+	return chainhash.HashH(b[:])
+}
+
+// TxBranch is the merkle branch of a transaction in a transaction tree.
+// Hashes are the siblings from the leaf up to the root.  Bit n of Index is set
+// if the node at level n is a right child.
+type TxBranch struct {
+	Index  uint32
+	Hashes []chainhash.Hash
+}
+
+// NewTxBranch returns the merkle branch of the leaf at the provided index.
+// The leaves are the full hashes of the transactions of a tree.  The last
+// node of a level with an odd number of nodes is paired with itself, like
+// dcrd does.
+func NewTxBranch(leaves []chainhash.Hash, index int) (*TxBranch, error) {
+	if index < 0 || index >= len(leaves) {
+		return nil, fmt.Errorf("invalid leaf index: %v", index)
+	}
+
+	branch := TxBranch{
+		Index: uint32(index),
+	}
+	level := append([]chainhash.Hash(nil), leaves...)
+	for len(level) > 1 {
+		if len(level)%2 != 0 {
+			level = append(level, level[len(level)-1])
+		}
+		branch.Hashes = append(branch.Hashes, level[index^1])
+		for i := 0; i < len(level)/2; i++ {
+			level[i] = hashPair(&level[2*i], &level[2*i+1])
+		}
+		level = level[:len(level)/2]
+		index /= 2
+	}
+
+	return &branch, nil
+}
+
+// Root returns the merkle root the branch leads to from the provided leaf.
+func (b *TxBranch) Root(leaf chainhash.Hash) chainhash.Hash {
+	root := leaf
+	for k := range b.Hashes {
+		if b.Index&(1<<uint(k)) != 0 {
+			root = hashPair(&b.Hashes[k], &root)
+		} else {
+			root = hashPair(&root, &b.Hashes[k])
+		}
+	}
+	return root
+}
+
+// Chain links an anchor transaction to a checkpoint.  Headers start with the
+// header of the block the anchor was mined in and end with the header of the
+// checkpoint.
+type Chain struct {
+	Tx      wire.MsgTx
+	Branch  TxBranch
+	Headers []wire.BlockHeader
+}
+
+// anchors returns true if an output of the transaction is a null data script
+// that ends in the provided merkle root.  See dcrtimewallet.AnchorScript.
+func anchors(tx *wire.MsgTx, merkleRoot [sha256.Size]byte) bool {
+	for _, out := range tx.TxOut {
+		script := out.PkScript
+		if len(script) < 2+sha256.Size || script[0] != 0x6a {
+			continue
+		}
+		if bytes.HasSuffix(script, merkleRoot[:]) {
+			return true
+		}
+	}
+	return false
+}
+
+// Verify verifies that the chain anchors the provided merkle root in a block
+// that is an ancestor of the provided checkpoint.  The transaction must store
+// the merkle root, its branch must lead to the merkle root of the first
+// header and every header must be the parent of the next one.  The last header
+// must be the checkpoint.  The header merkle root commits to the regular tree
+// either directly or, since DCP0005, combined with the stake tree.
+func (c *Chain) Verify(merkleRoot [sha256.Size]byte, cp Checkpoint) error {
+	if !anchors(&c.Tx, merkleRoot) {
+		return fmt.Errorf("%w: transaction does not anchor %x",
+			ErrInvalidChain, merkleRoot)
+	}
+	if len(c.Headers) == 0 {
+		return fmt.Errorf("%w: no headers", ErrInvalidChain)
+	}
+
+	anchor := &c.Headers[0]
+	treeRoot := c.Branch.Root(c.Tx.TxHashFull())
+	if treeRoot != anchor.MerkleRoot &&
+		hashPair(&treeRoot, &anchor.StakeRoot) != anchor.MerkleRoot {
+		return fmt.Errorf("%w: transaction not in block %v",
+			ErrInvalidChain, anchor.BlockHash())
+	}
+
+	for k := 1; k < len(c.Headers); k++ {
+		parent := c.Headers[k-1].BlockHash()
+		if c.Headers[k].PrevBlock != parent ||
+			c.Headers[k].Height != c.Headers[k-1].Height+1 {
+			return fmt.Errorf("%w: block %v does not follow %v",
+				ErrInvalidChain, c.Headers[k].BlockHash(), parent)
+		}
+	}
+
+	last := &c.Headers[len(c.Headers)-1]
+	if int32(last.Height) != cp.Height || last.BlockHash() != cp.Hash {
+		return fmt.Errorf("%w: chain ends at %v, not checkpoint %v",
+			ErrInvalidChain, last.BlockHash(), cp.Hash)
+	}
+
+	return nil
+}
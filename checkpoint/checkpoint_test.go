@@ -0,0 +1,223 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package checkpoint
+
+import (
+	"crypto/sha256"
+	"errors"
+	"testing"
+
+	"github.com/decred/dcrd/chaincfg/chainhash"
+	"github.com/decred/dcrd/wire"
+)
+
+// merkleRoot returns the merkle root of the provided leaves the way dcrd
+// calculates it.
+func merkleRoot(leaves []chainhash.Hash) chainhash.Hash {
+	if len(leaves) == 0 {
+		return chainhash.Hash{}
+	}
+	level := append([]chainhash.Hash(nil), leaves...)
+	for len(level) > 1 {
+		if len(level)%2 != 0 {
+			level = append(level, level[len(level)-1])
+		}
+		for i := 0; i < len(level)/2; i++ {
+			level[i] = hashPair(&level[2*i], &level[2*i+1])
+		}
+		level = level[:len(level)/2]
+	}
+	return level[0]
+}
+
+// testTx returns a transaction that anchors the provided merkle root.
+func testTx(seed byte, root [sha256.Size]byte) *wire.MsgTx {
+	tx := wire.NewMsgTx()
+	tx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&chainhash.Hash{seed}, 0,
+		wire.TxTreeRegular), 0, nil))
+	script := append([]byte{0x6a, byte(len(root))}, root[:]...)
+	tx.AddTxOut(wire.NewTxOut(0, script))
+	return tx
+}
+
+// testChain returns a chain of n headers that anchors the provided merkle
+// root in the third transaction of the first block, and the checkpoint it
+// ends in.  The first block commits to the combined root of DCP0005 if
+// combined is set.
+func testChain(t *testing.T, root [sha256.Size]byte, n int, combined bool) (*Chain, Checkpoint) {
+	t.Helper()
+
+	leaves := make([]chainhash.Hash, 0, 5)
+	var anchor *wire.MsgTx
+	for i := 0; i < 5; i++ {
+		tx := testTx(byte(i), sha256.Sum256([]byte{byte(i)}))
+		if i == 2 {
+			tx = testTx(byte(i), root)
+			anchor = tx
+		}
+		leaves = append(leaves, tx.TxHashFull())
+	}
+	branch, err := NewTxBranch(leaves, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	headers := make([]wire.BlockHeader, 0, n)
+	first := wire.BlockHeader{
+		Version:    1,
+		MerkleRoot: merkleRoot(leaves),
+		StakeRoot:  chainhash.Hash{0xff},
+		Height:     1000,
+	}
+	if combined {
+		first.MerkleRoot = hashPair(&first.MerkleRoot, &first.StakeRoot)
+	}
+	headers = append(headers, first)
+	for i := 1; i < n; i++ {
+		headers = append(headers, wire.BlockHeader{
+			Version:   1,
+			PrevBlock: headers[i-1].BlockHash(),
+			Height:    headers[i-1].Height + 1,
+		})
+	}
+
+	last := headers[len(headers)-1]
+	return &Chain{
+		Tx:      *anchor,
+		Branch:  *branch,
+		Headers: headers,
+	}, Checkpoint{Height: int32(last.Height), Hash: last.BlockHash()}
+}
+
+func TestTxBranch(t *testing.T) {
+	for n := 1; n <= 9; n++ {
+		leaves := make([]chainhash.Hash, 0, n)
+		for i := 0; i < n; i++ {
+			leaves = append(leaves, chainhash.HashH([]byte{byte(i)}))
+		}
+		want := merkleRoot(leaves)
+		for i := range leaves {
+			branch, err := NewTxBranch(leaves, i)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := branch.Root(leaves[i]); got != want {
+				t.Fatalf("%v leaves, leaf %v: got root %v, want %v",
+					n, i, got, want)
+			}
+		}
+	}
+
+	if _, err := NewTxBranch(nil, 0); err == nil {
+		t.Fatal("expected error for empty tree")
+	}
+}
+
+func TestNearest(t *testing.T) {
+	tests := []struct {
+		height int32
+		want   int32
+		err    error
+	}{
+		{0, 440, nil},
+		{440, 440, nil},
+		{441, 24480, nil},
+		{500000, 766600, nil},
+		{766601, 0, ErrNoCheckpoint},
+	}
+	for _, test := range tests {
+		cp, err := Nearest(Network("mainnet"), test.height)
+		if !errors.Is(err, test.err) {
+			t.Fatalf("height %v: got error %v, want %v", test.height,
+				err, test.err)
+		}
+		if err == nil && cp.Height != test.want {
+			t.Fatalf("height %v: got checkpoint %v, want %v",
+				test.height, cp.Height, test.want)
+		}
+	}
+
+	if Network("simnet") != nil {
+		t.Fatal("expected no simnet checkpoints")
+	}
+}
+
+func TestVerify(t *testing.T) {
+	root := sha256.Sum256([]byte("collection"))
+	for _, combined := range []bool{false, true} {
+		for _, n := range []int{1, 2, 10} {
+			chain, cp := testChain(t, root, n, combined)
+			if err := chain.Verify(root, cp); err != nil {
+				t.Fatalf("%v headers, combined %v: %v", n,
+					combined, err)
+			}
+		}
+	}
+}
+
+func TestVerifyInvalid(t *testing.T) {
+	root := sha256.Sum256([]byte("collection"))
+	tests := []struct {
+		name   string
+		tamper func(*Chain, *Checkpoint) [sha256.Size]byte
+	}{{
+		name: "other merkle root",
+		tamper: func(c *Chain, cp *Checkpoint) [sha256.Size]byte {
+			return sha256.Sum256([]byte("other"))
+		},
+	}, {
+		name: "other transaction",
+		tamper: func(c *Chain, cp *Checkpoint) [sha256.Size]byte {
+			c.Tx = *testTx(0x42, root)
+			return root
+		},
+	}, {
+		name: "other branch",
+		tamper: func(c *Chain, cp *Checkpoint) [sha256.Size]byte {
+			c.Branch.Index ^= 1
+			return root
+		},
+	}, {
+		name: "no headers",
+		tamper: func(c *Chain, cp *Checkpoint) [sha256.Size]byte {
+			c.Headers = nil
+			return root
+		},
+	}, {
+		name: "missing header",
+		tamper: func(c *Chain, cp *Checkpoint) [sha256.Size]byte {
+			c.Headers = append(c.Headers[:3:3], c.Headers[4:]...)
+			return root
+		},
+	}, {
+		name: "other height",
+		tamper: func(c *Chain, cp *Checkpoint) [sha256.Size]byte {
+			c.Headers[0].Height--
+			c.Headers[1].PrevBlock = c.Headers[0].BlockHash()
+			return root
+		},
+	}, {
+		name: "other checkpoint",
+		tamper: func(c *Chain, cp *Checkpoint) [sha256.Size]byte {
+			cp.Hash = chainhash.Hash{0x01}
+			return root
+		},
+	}, {
+		name: "beyond checkpoint",
+		tamper: func(c *Chain, cp *Checkpoint) [sha256.Size]byte {
+			cp.Height--
+			cp.Hash = c.Headers[len(c.Headers)-2].BlockHash()
+			return root
+		},
+	}}
+	for _, test := range tests {
+		chain, cp := testChain(t, root, 10, true)
+		r := test.tamper(chain, &cp)
+		err := chain.Verify(r, cp)
+		if !errors.Is(err, ErrInvalidChain) {
+			t.Fatalf("%v: got error %v", test.name, err)
+		}
+	}
+}
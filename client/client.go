@@ -0,0 +1,544 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+// Package client implements a client of the dcrtime v2 API.  It timestamps
+// and verifies digests, waits for digests to be anchored and verifies the
+// proofs that are returned by the server.
+package client
+
+import (
+	"bytes"
+	"context"
+	crand "crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	v2 "github.com/decred/dcrtime/api/v2"
+)
+
+// DefaultID is the client ID that is sent with requests that do not provide
+// one.
+const DefaultID = "dcrtime client"
+
+// Config is the configuration of a Client.
+type Config struct {
+	// Host is the URL of the server, e.g. https://time.decred.org:49152.
+	// A host without a scheme uses https and the default port of the
+	// mainnet server.  It defaults to the mainnet server.
+	Host string
+
+	// SkipVerify skips the verification of the TLS certificate of the
+	// server.
+	SkipVerify bool
+
+	// APIToken is sent with every request if set.
+	APIToken string
+
+	// Timeout is the timeout of a single request, zero means no timeout.
+	Timeout time.Duration
+
+	// PublicKey is the hex encoded identity of the server as returned by
+	// Identity.  If set, timestamp and verify replies must be signed by
+	// it.
+	PublicKey string
+
+	// Trust is the pinned trust bundle of the server.  If set, timestamp
+	// and verify replies must be signed by a key of the bundle that was
+	// not retired when the reply was received, and pending receipts by a
+	// key that was not retired when their collection started.  It takes
+	// precedence over PublicKey.
+	Trust *v2.TrustBundle
+
+	// HTTPClient is used to send requests if set, SkipVerify and Timeout
+	// are ignored.
+	HTTPClient *http.Client
+
+	// Retries is the number of times a request that failed with a network
+	// error or a transient server error is retried.  Zero disables
+	// retries.
+	Retries int
+
+	// RetryDelay is the delay before the first retry, it defaults to
+	// DefaultRetryDelay.  The delay doubles with every retry, up to
+	// MaxRetryDelay, and up to half of it is random jitter.  A longer delay
+	// requested by the server with Retry-After is honored.
+	RetryDelay time.Duration
+
+	// MaxRetryDelay is the maximum delay between retries, it defaults to
+	// DefaultMaxRetryDelay.
+	MaxRetryDelay time.Duration
+}
+
+const (
+	// DefaultRetryDelay is the delay before the first retry of a request.
+	DefaultRetryDelay = 500 * time.Millisecond
+
+	// DefaultMaxRetryDelay is the maximum delay between retries.
+	DefaultMaxRetryDelay = 30 * time.Second
+)
+
+// Client is a dcrtime v2 API client.  It is safe for concurrent use.
+type Client struct {
+	host      string
+	apiToken  string
+	publicKey string
+	trust     *v2.TrustBundle
+	http      *http.Client
+
+	retries       int
+	retryDelay    time.Duration
+	maxRetryDelay time.Duration
+}
+
+// ServerError is returned when the server refused a request.  Message is the
+// error the server returned, if any.
+type ServerError struct {
+	StatusCode int
+	Message    string
+}
+
+// Error satisfies the error interface.
+func (e ServerError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("%v %v", e.StatusCode,
+			http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("%v %v: %v", e.StatusCode,
+		http.StatusText(e.StatusCode), e.Message)
+}
+
+// newServerError returns the error of a reply with the provided status code
+// and body.
+func newServerError(statusCode int, body []byte) ServerError {
+	var e struct {
+		Error string `json:"error"`
+	}
+	json.Unmarshal(body, &e)
+	return ServerError{
+		StatusCode: statusCode,
+		Message:    e.Error,
+	}
+}
+
+// normalizeHost returns the URL of the server the requests are sent to.
+func normalizeHost(host string) (string, error) {
+	if host == "" {
+		host = v2.DefaultMainnetTimeHost
+	}
+	if !strings.Contains(host, "://") {
+		if _, _, err := net.SplitHostPort(host); err != nil {
+			host = net.JoinHostPort(host, v2.DefaultMainnetTimePort)
+		}
+		host = "https://" + host
+	}
+	u, err := url.Parse(host)
+	if err != nil {
+		return "", err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("invalid host %v", host)
+	}
+	return strings.TrimSuffix(u.String(), "/"), nil
+}
+
+// New returns a new client of the configured server.
+func New(cfg Config) (*Client, error) {
+	host, err := normalizeHost(cfg.Host)
+	if err != nil {
+		return nil, err
+	}
+	c := &Client{
+		host:      host,
+		apiToken:  cfg.APIToken,
+		publicKey: cfg.PublicKey,
+		trust:     cfg.Trust,
+		http:      cfg.HTTPClient,
+
+		retries:       cfg.Retries,
+		retryDelay:    cfg.RetryDelay,
+		maxRetryDelay: cfg.MaxRetryDelay,
+	}
+	if c.retryDelay <= 0 {
+		c.retryDelay = DefaultRetryDelay
+	}
+	if c.maxRetryDelay <= 0 {
+		c.maxRetryDelay = DefaultMaxRetryDelay
+	}
+	if c.maxRetryDelay < c.retryDelay {
+		c.maxRetryDelay = c.retryDelay
+	}
+	if c.http == nil {
+		c.http = &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{
+					InsecureSkipVerify: cfg.SkipVerify,
+				},
+			},
+			Timeout: cfg.Timeout,
+		}
+	}
+	return c, nil
+}
+
+// Host returns the URL of the server.
+func (c *Client) Host() string {
+	return c.host
+}
+
+// isTransient returns true if a request that failed with the provided status
+// code may succeed when it is retried.
+func isTransient(statusCode int) bool {
+	switch statusCode {
+	case http.StatusTooManyRequests, http.StatusInternalServerError,
+		http.StatusBadGateway, http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// backoff returns the delay before the provided retry, counting from
+// zero.  It grows exponentially with random jitter and is at least the delay
+// requested by the server in the provided Retry-After value, if any.
+func (c *Client) backoff(retry int, retryAfter string) time.Duration {
+	delay := c.maxRetryDelay
+	if retry < 32 && c.retryDelay<<uint(retry) < c.maxRetryDelay {
+		delay = c.retryDelay << uint(retry)
+	}
+	delay = delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+
+	if seconds, err := strconv.Atoi(retryAfter); err == nil {
+		if after := time.Duration(seconds) * time.Second; after > delay {
+			delay = after
+		}
+	}
+	return delay
+}
+
+// send sends a single request and returns the reply along with its body.
+func (c *Client) send(ctx context.Context, method, u, key string, body []byte) (*http.Response, []byte, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if key != "" {
+		req.Header.Set(v2.IdempotencyKeyHeader, key)
+	}
+
+	r, err := c.http.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer r.Body.Close()
+
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	return r, b, nil
+}
+
+// do sends a request to the provided route and decodes the reply into reply.
+// A JSON encoded request body is posted if request is not nil.  Requests that
+// fail with a network error or a transient server error are retried as
+// configured.  The idempotency key, if any, is sent with every attempt so that
+// the server handles the request only once.  Replies of signed routes are
+// verified if the client has the public key of the server.
+func (c *Client) do(ctx context.Context, route, key string, request, reply interface{}, signed bool) error {
+	u := c.host + route
+	if c.apiToken != "" {
+		u += "?apitoken=" + url.QueryEscape(c.apiToken)
+	}
+
+	method := http.MethodGet
+	var body []byte
+	if request != nil {
+		var err error
+		body, err = json.Marshal(request)
+		if err != nil {
+			return err
+		}
+		method = http.MethodPost
+	}
+
+	var (
+		r   *http.Response
+		b   []byte
+		err error
+	)
+	for retry := 0; ; retry++ {
+		r, b, err = c.send(ctx, method, u, key, body)
+		var retryAfter string
+		if err == nil && r.StatusCode != http.StatusOK &&
+			r.StatusCode != http.StatusAccepted {
+			serr := newServerError(r.StatusCode, b)
+			if !isTransient(r.StatusCode) {
+				return serr
+			}
+			retryAfter = r.Header.Get("Retry-After")
+			err = serr
+		}
+		if err == nil {
+			break
+		}
+		if retry >= c.retries || ctx.Err() != nil {
+			return err
+		}
+
+		t := time.NewTimer(c.backoff(retry, retryAfter))
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+
+	if signed {
+		var err error
+		signature := r.Header.Get(v2.SignatureHeader)
+		switch {
+		case c.trust != nil:
+			err = c.trust.VerifyReply(signature, b, time.Now().Unix())
+		case c.publicKey != "":
+			err = v2.VerifyReplySignature(c.publicKey, signature, b)
+		}
+		if err != nil {
+			return fmt.Errorf("%v: %v", route, err)
+		}
+	}
+	if err := json.Unmarshal(b, reply); err != nil {
+		return fmt.Errorf("%v: could not decode reply: %v", route,
+			err)
+	}
+
+	return nil
+}
+
+// newIdempotencyKey returns a random idempotency key.
+func newIdempotencyKey() (string, error) {
+	var key [16]byte
+	if _, err := crand.Read(key[:]); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(key[:]), nil
+}
+
+// Timestamp submits the digests of the provided batch to be timestamped.  The
+// request carries a random idempotency key, so retries do not report the
+// digests of the batch as existing if an earlier attempt reached the server.
+// Requested pending receipts are verified if the client has the public key of
+// the server.
+func (c *Client) Timestamp(ctx context.Context, t v2.TimestampBatch) (*v2.TimestampBatchReply, error) {
+	if t.ID == "" {
+		t.ID = DefaultID
+	}
+	key, err := newIdempotencyKey()
+	if err != nil {
+		return nil, err
+	}
+	var reply v2.TimestampBatchReply
+	err = c.do(ctx, v2.TimestampBatchRoute, key, t, &reply, true)
+	if err != nil {
+		return nil, err
+	}
+	if len(reply.Digests) != len(reply.Results) {
+		return nil, fmt.Errorf("invalid reply: %v digests, %v results",
+			len(reply.Digests), len(reply.Results))
+	}
+	for _, pr := range reply.PendingReceipts {
+		var err error
+		switch {
+		case c.trust != nil:
+			err = c.trust.VerifyPendingReceipt(pr)
+		case c.publicKey != "":
+			err = v2.VerifyPendingReceipt(c.publicKey, pr)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &reply, nil
+}
+
+// TimestampAsync queues the digests of the provided batch to be timestamped
+// by the server and returns the ticket of the submission right away.  Like
+// Timestamp it carries a random idempotency key, so retries queue the digests
+// once.  The outcome is retrieved with Ticket or WaitTicket.
+func (c *Client) TimestampAsync(ctx context.Context, t v2.TimestampBatch) (*v2.TimestampAsyncReply, error) {
+	if t.ID == "" {
+		t.ID = DefaultID
+	}
+	key, err := newIdempotencyKey()
+	if err != nil {
+		return nil, err
+	}
+	var reply v2.TimestampAsyncReply
+	err = c.do(ctx, v2.TimestampAsyncRoute, key, t, &reply, false)
+	if err != nil {
+		return nil, err
+	}
+	return &reply, nil
+}
+
+// Ticket returns the status of the asynchronous submission with the provided
+// ticket.
+func (c *Client) Ticket(ctx context.Context, ticket string) (*v2.TimestampTicketReply, error) {
+	var reply v2.TimestampTicketReply
+	err := c.do(ctx, v2.TicketRoute, "", v2.TimestampTicket{
+		ID:     DefaultID,
+		Ticket: ticket,
+	}, &reply, true)
+	if err != nil {
+		return nil, err
+	}
+	if reply.Status == v2.TicketStatusDone &&
+		len(reply.Digests) != len(reply.Results) {
+		return nil, fmt.Errorf("invalid reply: %v digests, %v results",
+			len(reply.Digests), len(reply.Results))
+	}
+	return &reply, nil
+}
+
+// WaitTicket retrieves the status of the asynchronous submission with the
+// provided ticket every interval until its digests were timestamped and
+// returns the outcome.  It returns an error if the ticket does not exist or
+// the context is done first.
+func (c *Client) WaitTicket(ctx context.Context, ticket string, interval time.Duration) (*v2.TimestampTicketReply, error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		reply, err := c.Ticket(ctx, ticket)
+		if err != nil {
+			return nil, err
+		}
+		if reply.Status == v2.TicketStatusDone {
+			return reply, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Verify returns the status of the digests and collection timestamps of the
+// provided batch.
+func (c *Client) Verify(ctx context.Context, v v2.VerifyBatch) (*v2.VerifyBatchReply, error) {
+	if v.ID == "" {
+		v.ID = DefaultID
+	}
+	var reply v2.VerifyBatchReply
+	err := c.do(ctx, v2.VerifyBatchRoute, "", v, &reply, true)
+	if err != nil {
+		return nil, err
+	}
+	return &reply, nil
+}
+
+// Identity returns the hex encoded public key of the server that signs its
+// timestamp and verify replies.
+func (c *Client) Identity(ctx context.Context) (string, error) {
+	var reply v2.IdentityReply
+	err := c.do(ctx, v2.IdentityRoute, "", nil, &reply, false)
+	if err != nil {
+		return "", err
+	}
+	return reply.PublicKey, nil
+}
+
+// AnchorChain returns the chain that links the anchor of the collection with
+// the provided server timestamp to the nearest published checkpoint, see
+// DecodeAnchorChain.
+func (c *Client) AnchorChain(ctx context.Context, serverTimestamp int64) (*v2.AnchorChainReply, error) {
+	var reply v2.AnchorChainReply
+	err := c.do(ctx, v2.AnchorChainRoute, "", v2.AnchorChain{
+		ID:              DefaultID,
+		ServerTimestamp: serverTimestamp,
+	}, &reply, false)
+	if err != nil {
+		return nil, err
+	}
+	return &reply, nil
+}
+
+// WaitAnchored verifies the digests of the provided batch every interval
+// until all of them are anchored and returns their verified results in the
+// order of the digests.  Collection timestamps of the batch are ignored.  It
+// returns an error if a digest does not exist, fails to verify or the context
+// is done first.
+func (c *Client) WaitAnchored(ctx context.Context, v v2.VerifyBatch, interval time.Duration) ([]v2.VerifyDigest, error) {
+	v.Timestamps = nil
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		reply, err := c.Verify(ctx, v)
+		if err != nil {
+			return nil, err
+		}
+		results, anchored, err := anchoredDigests(v.Digests, reply.Digests)
+		if err != nil {
+			return nil, err
+		}
+		if anchored {
+			return results, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// anchoredDigests returns the results of the provided digests in their order
+// and whether all of them were anchored.  The results of anchored digests are
+// verified.
+func anchoredDigests(digests []string, vds []v2.VerifyDigest) ([]v2.VerifyDigest, bool, error) {
+	byDigest := make(map[string]v2.VerifyDigest, len(vds))
+	for _, d := range vds {
+		byDigest[d.Digest] = d
+	}
+
+	results := make([]v2.VerifyDigest, 0, len(digests))
+	anchored := true
+	for _, digest := range digests {
+		d, ok := byDigest[digest]
+		if !ok {
+			return nil, false, fmt.Errorf("no result for digest %v",
+				digest)
+		}
+		err := VerifyDigest(d)
+		switch {
+		case err == ErrNotAnchored:
+			anchored = false
+		case err != nil:
+			return nil, false, fmt.Errorf("digest %v: %w", digest,
+				err)
+		}
+		results = append(results, d)
+	}
+
+	return results, anchored, nil
+}
@@ -0,0 +1,809 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/decred/dcrd/chaincfg/chainhash"
+	"github.com/decred/dcrd/wire"
+	v2 "github.com/decred/dcrtime/api/v2"
+	"github.com/decred/dcrtime/checkpoint"
+	"github.com/decred/dcrtime/merkle"
+)
+
+// testDigests returns n distinct digests.
+func testDigests(n int) []*[sha256.Size]byte {
+	digests := make([]*[sha256.Size]byte, 0, n)
+	for i := 0; i < n; i++ {
+		d := sha256.Sum256([]byte{byte(i)})
+		digests = append(digests, &d)
+	}
+	return digests
+}
+
+// testServer is a dcrtime server that anchors all digests it was asked to
+// verify after a number of verify requests.  Replies are signed with key.
+type testServer struct {
+	sync.Mutex
+	t        *testing.T
+	key      ed25519.PrivateKey
+	pending  int // Verify requests before digests are anchored
+	verifies int
+	tickets  int      // Ticket requests
+	queued   []string // Digests of the asynchronous submission
+	failures int      // Requests that fail before the server recovers
+	requests int      // Requests received
+	keys     []string // Idempotency keys of timestamp requests
+}
+
+func (s *testServer) reply(w http.ResponseWriter, reply interface{}) {
+	b, err := json.Marshal(reply)
+	if err != nil {
+		s.t.Error(err)
+		return
+	}
+	w.Header().Set(v2.SignatureHeader,
+		hex.EncodeToString(ed25519.Sign(s.key, b)))
+	w.Write(b)
+}
+
+func (s *testServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.requests++
+	if r.URL.Path == v2.TimestampBatchRoute ||
+		r.URL.Path == v2.TimestampAsyncRoute {
+		s.keys = append(s.keys, r.Header.Get(v2.IdempotencyKeyHeader))
+	}
+	if s.failures > 0 {
+		s.failures--
+		http.Error(w, `{"error":"busy"}`, http.StatusServiceUnavailable)
+		return
+	}
+
+	switch r.URL.Path {
+	case v2.IdentityRoute:
+		s.reply(w, v2.IdentityReply{
+			PublicKey: hex.EncodeToString(s.key.Public().(ed25519.PublicKey)),
+		})
+
+	case v2.TimestampBatchRoute:
+		var t v2.TimestampBatch
+		if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+			http.Error(w, `{"error":"invalid request"}`,
+				http.StatusBadRequest)
+			return
+		}
+		reply := v2.TimestampBatchReply{
+			ID:              t.ID,
+			ServerTimestamp: 1593590400,
+			Digests:         t.Digests,
+		}
+		pk := hex.EncodeToString(s.key.Public().(ed25519.PublicKey))
+		for _, d := range t.Digests {
+			reply.Results = append(reply.Results, v2.ResultOK)
+			if !t.PendingReceipts {
+				continue
+			}
+			pr := v2.PendingReceipt{
+				Digest:          d,
+				ServerTimestamp: reply.ServerTimestamp,
+				EndTimestamp:    reply.ServerTimestamp + 3600,
+				PublicKey:       pk,
+			}
+			pr.Signature = hex.EncodeToString(ed25519.Sign(s.key,
+				pr.Message()))
+			reply.PendingReceipts = append(reply.PendingReceipts, pr)
+		}
+		s.reply(w, reply)
+
+	case v2.TimestampAsyncRoute:
+		var t v2.TimestampBatch
+		if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+			http.Error(w, `{"error":"invalid request"}`,
+				http.StatusBadRequest)
+			return
+		}
+		s.queued = t.Digests
+		w.WriteHeader(http.StatusAccepted)
+		s.reply(w, v2.TimestampAsyncReply{
+			ID:          t.ID,
+			Ticket:      "0123456789abcdef0123456789abcdef",
+			Status:      v2.TicketStatusQueued,
+			DigestCount: len(t.Digests),
+		})
+
+	case v2.TicketRoute:
+		var tt v2.TimestampTicket
+		if err := json.NewDecoder(r.Body).Decode(&tt); err != nil {
+			http.Error(w, `{"error":"invalid request"}`,
+				http.StatusBadRequest)
+			return
+		}
+		if tt.Ticket != "0123456789abcdef0123456789abcdef" {
+			http.Error(w, `{"error":"Ticket not found"}`,
+				http.StatusNotFound)
+			return
+		}
+		s.tickets++
+		reply := v2.TimestampTicketReply{
+			ID:      tt.ID,
+			Ticket:  tt.Ticket,
+			Status:  v2.TicketStatusQueued,
+			Digests: s.queued,
+		}
+		if s.tickets > s.pending {
+			reply.Status = v2.TicketStatusDone
+			reply.ServerTimestamp = 1593590400
+			for range s.queued {
+				reply.Results = append(reply.Results,
+					v2.ResultOK)
+			}
+		}
+		s.reply(w, reply)
+
+	case v2.VerifyBatchRoute:
+		var v v2.VerifyBatch
+		if err := json.NewDecoder(r.Body).Decode(&v); err != nil {
+			http.Error(w, `{"error":"invalid request"}`,
+				http.StatusBadRequest)
+			return
+		}
+		s.verifies++
+		leaves := make([]*[sha256.Size]byte, 0, len(v.Digests))
+		for _, d := range v.Digests {
+			leaf, err := decodeHash(d)
+			if err != nil {
+				http.Error(w, `{"error":"invalid digest"}`,
+					http.StatusBadRequest)
+				return
+			}
+			leaves = append(leaves, leaf)
+		}
+		root := merkle.Root(leaves)
+		reply := v2.VerifyBatchReply{
+			ID: v.ID,
+		}
+		for _, leaf := range leaves {
+			vd := v2.VerifyDigest{
+				Digest:          hex.EncodeToString(leaf[:]),
+				ServerTimestamp: 1593590400,
+				Result:          v2.ResultOK,
+			}
+			if s.verifies > s.pending {
+				vd.ChainInformation = v2.ChainInformation{
+					ChainTimestamp: 1593594000,
+					MerkleRoot:     hex.EncodeToString(root[:]),
+					MerklePath:     v2.MerkleBranch(*merkle.AuthPath(leaves, leaf)),
+				}
+			}
+			reply.Digests = append(reply.Digests, vd)
+		}
+		s.reply(w, reply)
+
+	default:
+		http.Error(w, `{"error":"not found"}`, http.StatusNotFound)
+	}
+}
+
+// newTestServer returns a test server and a client of it that verifies the
+// signatures of its replies.
+func newTestServer(t *testing.T, pending int) (*testServer, *Client) {
+	t.Helper()
+
+	_, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &testServer{
+		t:       t,
+		key:     key,
+		pending: pending,
+	}
+	srv := httptest.NewServer(s)
+	t.Cleanup(srv.Close)
+
+	c, err := New(Config{
+		Host:      srv.URL,
+		PublicKey: hex.EncodeToString(key.Public().(ed25519.PublicKey)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, c
+}
+
+func TestNormalizeHost(t *testing.T) {
+	tests := []struct {
+		host string
+		want string
+		err  bool
+	}{
+		{"", "https://time.decred.org:49152", false},
+		{"time.example.com", "https://time.example.com:49152", false},
+		{"time.example.com:1234", "https://time.example.com:1234", false},
+		{"http://127.0.0.1:8080/", "http://127.0.0.1:8080", false},
+		{"ftp://time.example.com", "", true},
+	}
+	for _, test := range tests {
+		got, err := normalizeHost(test.host)
+		if (err != nil) != test.err {
+			t.Errorf("%q: unexpected error %v", test.host, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("%q: got %v, want %v", test.host, got, test.want)
+		}
+	}
+}
+
+func TestTimestampVerify(t *testing.T) {
+	_, c := newTestServer(t, 1)
+	ctx := context.Background()
+
+	digests := make([]string, 0, 3)
+	for _, d := range testDigests(3) {
+		digests = append(digests, hex.EncodeToString(d[:]))
+	}
+	tr, err := c.Timestamp(ctx, v2.TimestampBatch{
+		Digests: digests,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tr.ID != DefaultID || len(tr.Results) != len(digests) {
+		t.Fatalf("unexpected reply %+v", tr)
+	}
+
+	// The first verify finds the digests pending.
+	vr, err := c.Verify(ctx, v2.VerifyBatch{
+		Digests: digests,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range vr.Digests {
+		if err := VerifyDigest(d); !errors.Is(err, ErrNotAnchored) {
+			t.Fatalf("got %v, want ErrNotAnchored", err)
+		}
+	}
+
+	// Anchored digests verify, tampered ones do not.
+	vr, err = c.Verify(ctx, v2.VerifyBatch{
+		Digests: digests,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range vr.Digests {
+		if err := VerifyDigest(d); err != nil {
+			t.Fatal(err)
+		}
+	}
+	d := vr.Digests[0]
+	d.Digest = vr.Digests[1].Digest
+	if err := VerifyDigest(d); err == nil {
+		t.Fatal("expected tampered digest to fail")
+	}
+	d = vr.Digests[0]
+	d.Result = v2.ResultDoesntExistError
+	if err := VerifyDigest(d); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("got %v, want ErrNotFound", err)
+	}
+
+	// Replies that are not signed by the configured key are refused.
+	_, other, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.publicKey = hex.EncodeToString(other.Public().(ed25519.PublicKey))
+	_, err = c.Verify(ctx, v2.VerifyBatch{
+		Digests: digests,
+	})
+	if err == nil {
+		t.Fatal("expected invalid signature")
+	}
+
+	// Refused requests return the error of the server.
+	c.publicKey = ""
+	_, err = c.Verify(ctx, v2.VerifyBatch{
+		Digests: []string{"invalid"},
+	})
+	var se ServerError
+	if !errors.As(err, &se) || se.StatusCode != http.StatusBadRequest ||
+		se.Message != "invalid digest" {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestTrustBundle(t *testing.T) {
+	s, c := newTestServer(t, 1)
+	ctx := context.Background()
+
+	_, other, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer := hex.EncodeToString(s.key.Public().(ed25519.PublicKey))
+	retired := hex.EncodeToString(other.Public().(ed25519.PublicKey))
+	now := time.Now().Unix()
+
+	tests := []struct {
+		name  string
+		keys  []v2.IdentityKey
+		valid bool
+	}{
+		{"signer", []v2.IdentityKey{{PublicKey: signer}}, true},
+		{"rotated", []v2.IdentityKey{
+			{PublicKey: retired, NotAfter: now - 60},
+			{PublicKey: signer, NotBefore: now - 60},
+		}, true},
+
+		// The next key of a rotation is trusted before it takes over.
+		{"pending rotation", []v2.IdentityKey{
+			{PublicKey: retired, NotAfter: now + 3600},
+			{PublicKey: signer, NotBefore: now + 3600},
+		}, true},
+		{"retired", []v2.IdentityKey{
+			{PublicKey: signer, NotAfter: now - 60},
+		}, false},
+		{"other key", []v2.IdentityKey{{PublicKey: retired}}, false},
+	}
+	for k, test := range tests {
+		b, err := json.Marshal(v2.TrustBundle{
+			Version: v2.TrustBundleVersion,
+			Keys:    test.keys,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.trust, err = v2.DecodeTrustBundle(b)
+		if err != nil {
+			t.Fatalf("%v: %v", test.name, err)
+		}
+		var digests []string
+		for _, d := range testDigests(k + 1)[k:] {
+			digests = append(digests, hex.EncodeToString(d[:]))
+		}
+		_, err = c.Timestamp(ctx, v2.TimestampBatch{
+			Digests:         digests,
+			PendingReceipts: true,
+		})
+		if (err == nil) != test.valid {
+			t.Errorf("%v: got error %v, want valid %v", test.name,
+				err, test.valid)
+		}
+	}
+
+	// The trust bundle takes precedence over the public key.
+	c.publicKey = signer
+	c.trust = &v2.TrustBundle{
+		Version: v2.TrustBundleVersion,
+		Keys:    []v2.IdentityKey{{PublicKey: retired}},
+	}
+	if _, err := c.Verify(ctx, v2.VerifyBatch{
+		Digests: []string{hex.EncodeToString(testDigests(1)[0][:])},
+	}); err == nil {
+		t.Fatal("expected untrusted reply to fail")
+	}
+
+	// Pending receipts are trusted if the key was not retired when their
+	// collection started.
+	pr := v2.PendingReceipt{
+		Digest:          hex.EncodeToString(testDigests(1)[0][:]),
+		ServerTimestamp: 1593590400,
+		PublicKey:       signer,
+	}
+	pr.Signature = hex.EncodeToString(ed25519.Sign(s.key, pr.Message()))
+	tb := v2.TrustBundle{
+		Version: v2.TrustBundleVersion,
+		Keys: []v2.IdentityKey{{
+			PublicKey: signer,
+			NotAfter:  pr.ServerTimestamp + 1,
+		}},
+	}
+	if err := tb.VerifyPendingReceipt(pr); err != nil {
+		t.Fatal(err)
+	}
+	tb.Keys[0].NotAfter = pr.ServerTimestamp
+	if err := tb.VerifyPendingReceipt(pr); err == nil {
+		t.Fatal("expected receipt of retired key to fail")
+	}
+	tb.Keys[0].PublicKey = retired
+	if err := tb.VerifyPendingReceipt(pr); err == nil {
+		t.Fatal("expected receipt of untrusted key to fail")
+	}
+
+	// Invalid bundles are refused.
+	for _, b := range []string{
+		`{"version":2,"keys":[{"publickey":"` + signer + `"}]}`,
+		`{"version":1,"keys":[]}`,
+		`{"version":1,"keys":[{"publickey":"00"}]}`,
+		`{"version":1,"keys":[{"publickey":"` + signer +
+			`","notbefore":2,"notafter":1}]}`,
+		`not json`,
+	} {
+		if _, err := v2.DecodeTrustBundle([]byte(b)); err == nil {
+			t.Errorf("%v: expected invalid bundle", b)
+		}
+	}
+}
+
+func TestPendingReceipts(t *testing.T) {
+	_, c := newTestServer(t, 1)
+	ctx := context.Background()
+
+	digests := make([]string, 0, 3)
+	for _, d := range testDigests(3) {
+		digests = append(digests, hex.EncodeToString(d[:]))
+	}
+	tr, err := c.Timestamp(ctx, v2.TimestampBatch{
+		Digests:         digests,
+		PendingReceipts: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tr.PendingReceipts) != len(digests) {
+		t.Fatalf("got %v pending receipts, want %v",
+			len(tr.PendingReceipts), len(digests))
+	}
+
+	// Receipts are bound to their digest and collection.
+	pr := tr.PendingReceipts[0]
+	if err := v2.VerifyPendingReceipt(c.publicKey, pr); err != nil {
+		t.Fatal(err)
+	}
+	tampered := pr
+	tampered.ServerTimestamp++
+	if err := v2.VerifyPendingReceipt(c.publicKey, tampered); err == nil {
+		t.Fatal("expected tampered receipt to fail")
+	}
+	tampered = pr
+	tampered.Digest = digests[1]
+	if err := v2.VerifyPendingReceipt(c.publicKey, tampered); err == nil {
+		t.Fatal("expected tampered receipt to fail")
+	}
+
+	// Receipts are upgraded once the digests are anchored.
+	vr, err := c.Verify(ctx, v2.VerifyBatch{
+		Digests: digests,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, _, err := anchoredDigests(digests, vr.Digests)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = UpgradePendingReceipt(pr, results[0])
+	if !errors.Is(err, ErrNotAnchored) {
+		t.Fatalf("got %v, want ErrNotAnchored", err)
+	}
+	vr, err = c.Verify(ctx, v2.VerifyBatch{
+		Digests: digests,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, _, err = anchoredDigests(digests, vr.Digests)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, pr := range tr.PendingReceipts {
+		if err := UpgradePendingReceipt(pr, results[k]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := UpgradePendingReceipt(pr, results[1]); err == nil {
+		t.Fatal("expected proof of another digest to fail")
+	}
+	d := results[0]
+	d.ServerTimestamp++
+	if err := UpgradePendingReceipt(pr, d); err == nil {
+		t.Fatal("expected proof of another collection to fail")
+	}
+}
+
+func TestIdentity(t *testing.T) {
+	s, c := newTestServer(t, 0)
+
+	pk, err := c.Identity(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pk != hex.EncodeToString(s.key.Public().(ed25519.PublicKey)) {
+		t.Fatalf("unexpected identity %v", pk)
+	}
+}
+
+func TestWaitAnchored(t *testing.T) {
+	s, c := newTestServer(t, 2)
+
+	digests := make([]string, 0, 5)
+	for _, d := range testDigests(5) {
+		digests = append(digests, hex.EncodeToString(d[:]))
+	}
+	results, err := c.WaitAnchored(context.Background(), v2.VerifyBatch{
+		Digests: digests,
+	}, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.verifies != 3 {
+		t.Fatalf("got %v verify requests, want 3", s.verifies)
+	}
+	for k, d := range results {
+		if d.Digest != digests[k] {
+			t.Fatalf("result %v is digest %v, want %v", k, d.Digest,
+				digests[k])
+		}
+	}
+
+	// Waiting ends with the context.
+	_, c = newTestServer(t, 1000)
+	ctx, cancel := context.WithTimeout(context.Background(),
+		10*time.Millisecond)
+	defer cancel()
+	_, err = c.WaitAnchored(ctx, v2.VerifyBatch{
+		Digests: digests,
+	}, time.Millisecond)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v, want context.DeadlineExceeded", err)
+	}
+}
+
+func TestTimestampAsync(t *testing.T) {
+	s, c := newTestServer(t, 2)
+
+	digests := make([]string, 0, 5)
+	for _, d := range testDigests(5) {
+		digests = append(digests, hex.EncodeToString(d[:]))
+	}
+	ar, err := c.TimestampAsync(context.Background(), v2.TimestampBatch{
+		Digests: digests,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ar.Status != v2.TicketStatusQueued || ar.DigestCount != 5 {
+		t.Fatalf("async reply: %+v", ar)
+	}
+	if len(s.keys) != 1 || s.keys[0] == "" {
+		t.Fatalf("idempotency keys: %v", s.keys)
+	}
+
+	tr, err := c.WaitTicket(context.Background(), ar.Ticket,
+		time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.tickets != 3 {
+		t.Fatalf("got %v ticket requests, want 3", s.tickets)
+	}
+	if tr.ServerTimestamp != 1593590400 || len(tr.Results) != 5 {
+		t.Fatalf("ticket reply: %+v", tr)
+	}
+
+	// Unknown tickets are not retried.
+	_, err = c.Ticket(context.Background(),
+		"ffffffffffffffffffffffffffffffff")
+	var serr ServerError
+	if !errors.As(err, &serr) || serr.StatusCode != http.StatusNotFound {
+		t.Fatalf("got %v, want not found", err)
+	}
+}
+
+// testProofFull returns a full proof of the first of the provided digests.
+func testProofFull(t *testing.T, digests []*[sha256.Size]byte) *v2.ProofFull {
+	t.Helper()
+
+	root := merkle.Root(digests)
+	branch, err := merkle.EncodeBranchJSON(merkle.AuthPath(digests,
+		digests[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var bj v2.BranchJSON
+	if err := json.Unmarshal(branch, &bj); err != nil {
+		t.Fatal(err)
+	}
+
+	tx := wire.NewMsgTx()
+	tx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&chainhash.Hash{1}, 0,
+		wire.TxTreeRegular), 0, nil))
+	script := append([]byte{0x6a, sha256.Size}, root[:]...)
+	tx.AddTxOut(wire.NewTxOut(0, script))
+	rawTx, err := tx.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	txBranch, err := checkpoint.NewTxBranch([]chainhash.Hash{
+		tx.TxHashFull()}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := wire.BlockHeader{
+		Version:    1,
+		MerkleRoot: tx.TxHashFull(),
+		Height:     1000,
+	}
+	rawHeader, err := header.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &v2.ProofFull{
+		ProofJSON: v2.ProofJSON{
+			Digest:       hex.EncodeToString(digests[0][:]),
+			MerkleRoot:   hex.EncodeToString(root[:]),
+			MerkleBranch: bj,
+			Transaction:  tx.TxHash().String(),
+		},
+		RawTransaction: hex.EncodeToString(rawTx),
+		TxIndex:        txBranch.Index,
+		TxBranch:       []string{},
+		BlockHash:      header.BlockHash().String(),
+		BlockHeader:    hex.EncodeToString(rawHeader),
+	}
+}
+
+func TestProofs(t *testing.T) {
+	digests := testDigests(7)
+	proof := testProofFull(t, digests)
+
+	b, err := json.Marshal(proof)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseProofFull(b); err != nil {
+		t.Fatal(err)
+	}
+	b, err = json.Marshal(proof.ProofJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseProofJSON(b); err != nil {
+		t.Fatal(err)
+	}
+
+	// A digest that is not the leaf of the branch.
+	p := *proof
+	p.Digest = hex.EncodeToString(digests[1][:])
+	if err := VerifyProofJSON(&p.ProofJSON); err == nil {
+		t.Fatal("expected wrong digest to fail")
+	}
+
+	// A transaction that does not anchor the merkle root.
+	other := testProofFull(t, testDigests(3))
+	p = *proof
+	p.RawTransaction = other.RawTransaction
+	p.Transaction = other.Transaction
+	if err := VerifyProofFull(&p); err == nil {
+		t.Fatal("expected wrong transaction to fail")
+	}
+
+	// A header that is not the named block.
+	p = *proof
+	p.BlockHash = chainhash.Hash{}.String()
+	if err := VerifyProofFull(&p); err == nil {
+		t.Fatal("expected wrong block hash to fail")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	c, err := New(Config{
+		RetryDelay:    time.Second,
+		MaxRetryDelay: 10 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		retry      int
+		retryAfter string
+		min, max   time.Duration
+	}{
+		{0, "", 500 * time.Millisecond, time.Second},
+		{2, "", 2 * time.Second, 4 * time.Second},
+		{4, "", 5 * time.Second, 10 * time.Second},
+		{100, "", 5 * time.Second, 10 * time.Second},
+		{0, "30", 30 * time.Second, 30 * time.Second},
+		{0, "invalid", 500 * time.Millisecond, time.Second},
+	}
+	for _, test := range tests {
+		for i := 0; i < 100; i++ {
+			got := c.backoff(test.retry, test.retryAfter)
+			if got < test.min || got > test.max {
+				t.Fatalf("retry %v: got %v, want %v-%v", test.retry,
+					got, test.min, test.max)
+			}
+		}
+	}
+}
+
+func TestRetry(t *testing.T) {
+	s, c := newTestServer(t, 0)
+	c.retries = 2
+	c.retryDelay = time.Millisecond
+	c.maxRetryDelay = time.Millisecond
+	ctx := context.Background()
+	digests := []string{hex.EncodeToString(testDigests(1)[0][:])}
+
+	// Transient errors are retried with the same idempotency key.
+	s.failures = 2
+	_, err := c.Timestamp(ctx, v2.TimestampBatch{
+		Digests: digests,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.keys) != 3 || s.keys[0] == "" || s.keys[0] != s.keys[1] ||
+		s.keys[0] != s.keys[2] {
+		t.Fatalf("unexpected idempotency keys %v", s.keys)
+	}
+
+	// Every submission has its own key.
+	_, err = c.Timestamp(ctx, v2.TimestampBatch{
+		Digests: digests,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.keys) != 4 || s.keys[3] == s.keys[0] {
+		t.Fatalf("unexpected idempotency keys %v", s.keys)
+	}
+
+	// The last error is returned once retries are exhausted.
+	s.failures = 3
+	s.requests = 0
+	_, err = c.Verify(ctx, v2.VerifyBatch{
+		Digests: digests,
+	})
+	var se ServerError
+	if !errors.As(err, &se) || se.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if s.requests != 3 {
+		t.Fatalf("got %v requests, want 3", s.requests)
+	}
+
+	// Refused requests are not retried.
+	s.requests = 0
+	_, err = c.Verify(ctx, v2.VerifyBatch{
+		Digests: []string{"invalid"},
+	})
+	if !errors.As(err, &se) || se.StatusCode != http.StatusBadRequest {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if s.requests != 1 {
+		t.Fatalf("got %v requests, want 1", s.requests)
+	}
+
+	// Retries stop when the context is done.
+	s.failures = 1
+	c.retryDelay = time.Hour
+	c.maxRetryDelay = time.Hour
+	ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	_, err = c.Verify(ctx, v2.VerifyBatch{
+		Digests: digests,
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v, want context.DeadlineExceeded", err)
+	}
+}
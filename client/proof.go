@@ -0,0 +1,216 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/decred/dcrd/chaincfg/chainhash"
+	"github.com/decred/dcrd/wire"
+	v2 "github.com/decred/dcrtime/api/v2"
+	"github.com/decred/dcrtime/checkpoint"
+	"github.com/decred/dcrtime/merkle"
+)
+
+var (
+	// ErrNotFound is returned when the server does not know a digest.
+	ErrNotFound = errors.New("digest not found")
+
+	// ErrNotAnchored is returned when a digest was not anchored with
+	// enough confirmations yet.
+	ErrNotAnchored = errors.New("digest not anchored yet")
+)
+
+// decodeHash decodes a hex encoded digest or merkle root.
+func decodeHash(s string) (*[sha256.Size]byte, error) {
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) != sha256.Size {
+		return nil, fmt.Errorf("invalid hash %v", s)
+	}
+	var hash [sha256.Size]byte
+	copy(hash[:], b)
+	return &hash, nil
+}
+
+// verifyInclusion verifies that the merkle branch authenticates exactly the
+// provided digest and leads to the provided merkle root.
+func verifyInclusion(mb *merkle.Branch, digest, merkleRoot string) error {
+	leaf, err := decodeHash(digest)
+	if err != nil {
+		return err
+	}
+	root, err := decodeHash(merkleRoot)
+	if err != nil {
+		return err
+	}
+	return merkle.VerifyInclusion(mb, leaf, root)
+}
+
+// VerifyDigest verifies the result of a verified digest.  The merkle path
+// must authenticate exactly the digest and lead to the merkle root of the
+// anchor.  ErrNotFound is returned if the server does not know the digest and
+// ErrNotAnchored if it was not anchored with enough confirmations yet.  The
+// anchor itself is not verified, see VerifyProofFull and DecodeAnchorChain.
+func VerifyDigest(d v2.VerifyDigest) error {
+	switch d.Result {
+	case v2.ResultOK:
+	case v2.ResultDoesntExistError:
+		return ErrNotFound
+	default:
+		return fmt.Errorf("result %v: %v", d.Result, v2.Result[d.Result])
+	}
+	ci := &d.ChainInformation
+	if ci.ChainTimestamp == 0 || len(ci.MerklePath.Hashes) == 0 {
+		return ErrNotAnchored
+	}
+	return verifyInclusion((*merkle.Branch)(&ci.MerklePath), d.Digest,
+		ci.MerkleRoot)
+}
+
+// UpgradePendingReceipt verifies that the provided verified digest is the
+// proof the pending receipt is upgraded to once its collection was anchored.
+// It must be the anchored result of the digest of the receipt in the
+// collection of the receipt.  ErrNotAnchored is returned if the collection was
+// not anchored with enough confirmations yet.  The signature of the receipt is
+// not verified, see v2.VerifyPendingReceipt.
+func UpgradePendingReceipt(pr v2.PendingReceipt, d v2.VerifyDigest) error {
+	if !strings.EqualFold(pr.Digest, d.Digest) {
+		return fmt.Errorf("pending receipt of %v, proof of %v",
+			pr.Digest, d.Digest)
+	}
+	if err := VerifyDigest(d); err != nil {
+		return err
+	}
+	if d.ServerTimestamp != pr.ServerTimestamp {
+		return fmt.Errorf("digest %v anchored in collection %v, "+
+			"pending receipt of collection %v", d.Digest,
+			d.ServerTimestamp, pr.ServerTimestamp)
+	}
+	return nil
+}
+
+// VerifyProofJSON verifies that the merkle branch of a JSON proof
+// authenticates exactly its digest and leads to its merkle root.  The anchor
+// itself is not verified.
+func VerifyProofJSON(p *v2.ProofJSON) error {
+	b, err := json.Marshal(p.MerkleBranch)
+	if err != nil {
+		return err
+	}
+	mb, err := merkle.DecodeBranchJSON(b)
+	if err != nil {
+		return fmt.Errorf("invalid merkle branch: %v", err)
+	}
+	return verifyInclusion(mb, p.Digest, p.MerkleRoot)
+}
+
+// decodeChain decodes an anchor transaction, its branch in the regular
+// transaction tree of its block and the block headers starting with the block
+// it was mined in.
+func decodeChain(rawTx, txid string, index uint32, branch, headers []string) (*checkpoint.Chain, error) {
+	var chain checkpoint.Chain
+	b, err := hex.DecodeString(rawTx)
+	if err != nil {
+		return nil, fmt.Errorf("invalid raw transaction: %v", err)
+	}
+	if err := chain.Tx.FromBytes(b); err != nil {
+		return nil, fmt.Errorf("invalid raw transaction: %v", err)
+	}
+	if chain.Tx.TxHash().String() != txid {
+		return nil, fmt.Errorf("raw transaction is not %v", txid)
+	}
+	chain.Branch.Index = index
+	for _, h := range branch {
+		hash, err := chainhash.NewHashFromStr(h)
+		if err != nil {
+			return nil, fmt.Errorf("invalid branch hash: %v", err)
+		}
+		chain.Branch.Hashes = append(chain.Branch.Hashes, *hash)
+	}
+	for _, h := range headers {
+		b, err := hex.DecodeString(h)
+		if err != nil {
+			return nil, fmt.Errorf("invalid header: %v", err)
+		}
+		var header wire.BlockHeader
+		if err := header.FromBytes(b); err != nil {
+			return nil, fmt.Errorf("invalid header: %v", err)
+		}
+		chain.Headers = append(chain.Headers, header)
+	}
+
+	return &chain, nil
+}
+
+// VerifyProofFull verifies a full proof.  In addition to the JSON proof, the
+// transaction must store the merkle root and its branch must lead to the
+// merkle root of the block header.  The caller must still check that the
+// block, as identified by BlockHash, is part of the main chain, e.g. with an
+// SPV wallet.
+func VerifyProofFull(p *v2.ProofFull) error {
+	if err := VerifyProofJSON(&p.ProofJSON); err != nil {
+		return err
+	}
+	chain, err := decodeChain(p.RawTransaction, p.Transaction, p.TxIndex,
+		p.TxBranch, []string{p.BlockHeader})
+	if err != nil {
+		return err
+	}
+	hash := chain.Headers[0].BlockHash()
+	if hash.String() != p.BlockHash {
+		return fmt.Errorf("header is not block %v", p.BlockHash)
+	}
+	root, err := decodeHash(p.MerkleRoot)
+	if err != nil {
+		return err
+	}
+
+	// The anchor block is its own checkpoint, the chain ends there.
+	return chain.Verify(*root, checkpoint.Checkpoint{
+		Height: int32(chain.Headers[0].Height),
+		Hash:   hash,
+	})
+}
+
+// ParseProofJSON decodes and verifies a JSON proof, see VerifyProofJSON.
+func ParseProofJSON(b []byte) (*v2.ProofJSON, error) {
+	var p v2.ProofJSON
+	if err := json.Unmarshal(b, &p); err != nil {
+		return nil, fmt.Errorf("could not decode proof: %v", err)
+	}
+	if err := VerifyProofJSON(&p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// ParseProofFull decodes and verifies a full proof, see VerifyProofFull.
+func ParseProofFull(b []byte) (*v2.ProofFull, error) {
+	var p v2.ProofFull
+	if err := json.Unmarshal(b, &p); err != nil {
+		return nil, fmt.Errorf("could not decode proof: %v", err)
+	}
+	if err := VerifyProofFull(&p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// DecodeAnchorChain decodes the chain of an anchor chain reply so that it can
+// be verified against a trusted checkpoint with checkpoint.Chain.Verify.
+func DecodeAnchorChain(acr *v2.AnchorChainReply) (*checkpoint.Chain, error) {
+	if acr.Result != v2.ResultOK {
+		return nil, fmt.Errorf("anchor chain result: %v",
+			v2.Result[acr.Result])
+	}
+
+	return decodeChain(acr.RawTransaction, acr.Transaction, acr.TxIndex,
+		acr.TxBranch, acr.Headers)
+}
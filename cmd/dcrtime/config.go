@@ -5,6 +5,7 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"os/user"
@@ -30,6 +31,7 @@ var (
 // See loadConfig for details on the configuration load process.
 type config struct {
 	APIToken string `long:"apitoken" description:"Token for accessing privileged API resources"`
+	Profile  string `long:"profile" description:"Server profile that is used when no -profile is provided"`
 }
 
 // cleanAndExpandPath expands environment variables and leading ~ in the
@@ -86,7 +88,10 @@ func cleanAndExpandPath(path string) string {
 	return filepath.Join(homeDir, path)
 }
 
-// loadConfig initializes and parses the config using a config file
+// loadConfig initializes and parses the config using a config file.  The
+// sections of the config file other than the application options are server
+// profiles, see readProfiles.  A config file that does not exist yields the
+// default config.
 func loadConfig() (*config, error) {
 	// Default config.
 	cfg := config{
@@ -98,8 +103,9 @@ func loadConfig() (*config, error) {
 		return nil, err
 	}
 
-	err = flags.IniParse(defaultConfigFile, &cfg)
-	if err != nil {
+	parser := flags.NewParser(&cfg, flags.IgnoreUnknown)
+	err = flags.NewIniParser(parser).ParseFile(defaultConfigFile)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
 		return nil, err
 	}
 
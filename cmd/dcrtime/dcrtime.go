@@ -18,6 +18,8 @@ import (
 	"net/url"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 
 	v1 "github.com/decred/dcrtime/api/v1"
 	v2 "github.com/decred/dcrtime/api/v2"
@@ -53,8 +55,70 @@ var (
 	skipVerify = flag.Bool("skipverify", false, "Skip TLS certificates"+
 		"verification (not recommended)")
 	digestsNumber = flag.Int("digestsnumber", 0, "The number of digests to get. Sorted from newest to oldest")
+	tz            = flag.String("tz", "UTC", "Time zone used to display"+
+		" timestamps, e.g. UTC, Local or America/New_York")
+	label = flag.String("label", "", "Group label that is stored with the"+
+		" uploaded digests (API v2 only)")
+	algorithm = flag.String("algorithm", "", "Hash algorithm of the -digest,"+
+		" one of sha256, sha512/256, blake2b-256 or sha3-256 (API v2 only)")
+	getLabel = flag.String("getlabel", "", "Display all digests that were"+
+		" timestamped under the provided group label (API v2 only)")
+	trustPath = flag.String("trust", "", "Trust bundle of the server,"+
+		" exported with dcrtimed exportidentity. Servers whose identity"+
+		" key is not one of its keys and replies and pending receipts"+
+		" that are not signed by one of them are refused (API v2 only)")
+	noColor = flag.Bool("nocolor", false, "Do not highlight verify "+
+		"results in color")
+	manifestPath = flag.String("manifest", "", "Only timestamp files, and"+
+		" files in directories, that are new or changed since the"+
+		" previous run recorded in the provided manifest, then timestamp"+
+		" the manifest itself. Use the verify argument to verify the"+
+		" manifest and its files instead (API v2 only)")
+	ledgerPath = flag.String("ledger", "", "Ledger the receipts of"+
+		" watched files are appended to (default <dir>/"+
+		defaultLedgerFilename+")")
+	watchInterval = flag.Duration("watchinterval", 10*time.Second,
+		"Interval changed files are submitted at in watch mode")
+	format = flag.String("format", formatText, "Output format, one of"+
+		" text, json (same as -json) or ndjson for one JSON object per"+
+		" result (API v2 only)")
+	session = flag.Bool("session", false, "Upload the digests through a"+
+		" resumable submission session, for very large batches (API v2"+
+		" only)")
+	sessionID = flag.String("sessionid", "", "Resume the upload of the"+
+		" submission session with the provided ID, implies -session")
+	sessionChunk = flag.Int("sessionchunk", 10000, "Number of digests"+
+		" that are appended to a submission session per request")
+	receiptsPath = flag.String("receipts", "", "Database every submitted"+
+		" digest is recorded in, refreshed by the status argument"+
+		" (default <homedir>/"+defaultReceiptsDirname+")")
+	noReceipts = flag.Bool("noreceipts", false, "Do not record submitted"+
+		" digests in the receipt database")
+	requestReceipts = flag.Bool("pendingreceipts", false, "Request"+
+		" signed pending receipts of accepted digests and record them"+
+		" in the receipt database")
+	duplicateOK = flag.Bool("duplicateok", false, "Treat digests that"+
+		" were timestamped before as timestamped in the collection of"+
+		" their original submission instead of reporting them as"+
+		" existing")
+	profileName = flag.String("profile", "", "Server profile of the"+
+		" config file that provides the host, port, skipverify,"+
+		" apitoken, timeout and trust flags that were not provided")
+	timeout = flag.Duration("timeout", 0, "Timeout of requests to the"+
+		" server, e.g. 30s (default none)")
+
+	// displayLocation is the time zone timestamps are displayed in. It is
+	// set from the tz flag.
+	displayLocation = time.UTC
 )
 
+func init() {
+	// Long form of -v.  Verify prints the details of every digest and
+	// timestamp instead of a summary.
+	flag.BoolVar(verbose, "verbose", false, "Verbose, print the details"+
+		" of every verified digest and timestamp")
+}
+
 // normalizeAddress returns addr with the passed default port appended if
 // there is not already a port specified.
 func normalizeAddress(addr, defaultPort string) string {
@@ -111,6 +175,17 @@ func getError(r io.Reader) (string, error) {
 	return fmt.Sprintf("%v", rError), nil
 }
 
+// formatTime returns the unix timestamp followed by its ISO 8601
+// representation in the selected display time zone. Unset timestamps are
+// returned as is.
+func formatTime(ts int64) string {
+	if ts == 0 {
+		return "0"
+	}
+	return fmt.Sprintf("%v (%v)", ts,
+		time.Unix(ts, 0).In(displayLocation).Format(time.RFC3339))
+}
+
 func convertTimestamp(t string) (int64, bool) {
 	if !isTimestamp(t) {
 		return 0, false
@@ -146,7 +221,10 @@ func newClient(skipVerify bool) *http.Client {
 	tr := &http.Transport{
 		TLSClientConfig: tlsConfig,
 	}
-	return &http.Client{Transport: tr}
+	return &http.Client{
+		Transport: tr,
+		Timeout:   *timeout,
+	}
 }
 
 func downloadV1(questions []string) error {
@@ -267,7 +345,7 @@ func downloadV1(questions []string) error {
 			continue
 		}
 		fmt.Printf("  %-15v: %v\n", "Chain Timestamp",
-			v.CollectionInformation.ChainTimestamp)
+			formatTime(v.CollectionInformation.ChainTimestamp))
 		fmt.Printf("  %-15v: %v\n", "Merkle Root",
 			v.CollectionInformation.MerkleRoot)
 		fmt.Printf("  %-15v: %v\n", "TxID",
@@ -313,7 +391,7 @@ func downloadV1(questions []string) error {
 			continue
 		}
 		fmt.Printf("  %-15v: %v\n", "Chain Timestamp",
-			v.ChainInformation.ChainTimestamp)
+			formatTime(v.ChainInformation.ChainTimestamp))
 		fmt.Printf("  %-15v: %v\n", "Merkle Root",
 			v.ChainInformation.MerkleRoot)
 		fmt.Printf("  %-15v: %v\n", "TxID",
@@ -377,123 +455,238 @@ func downloadV2Batch(questions []string) error {
 		return fmt.Errorf("%v: %v", r.Status, e)
 	}
 
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	if err := checkSignature(r, body); err != nil {
+		return err
+	}
+
 	if *printJSON {
-		io.Copy(os.Stdout, r.Body)
-		fmt.Printf("\n")
+		fmt.Println(string(body))
 		return nil
 	}
 
 	// Decode response.
 	var vbr v2.VerifyBatchReply
-	decoder := json.NewDecoder(r.Body)
-	if err := decoder.Decode(&vbr); err != nil {
+	if err := json.Unmarshal(body, &vbr); err != nil {
 		return fmt.Errorf("could node decode VerifyReply: %v", err)
 	}
 
-	verifyDigests(vbr.Digests)
+	var summary verifySummary
+	verifyDigests(vbr.Digests, &summary)
 
-	err = verifyTimestamps(vbr.Timestamps)
+	err = verifyTimestamps(vbr.Timestamps, &summary)
 	if err != nil {
 		return err
 	}
+	summary.print()
 
 	return nil
 }
 
-func verifyDigests(vd []v2.VerifyDigest) {
-	for _, d := range vd {
-		result, ok := v2.Result[d.Result]
-		if !ok {
-			fmt.Printf("%v invalid error code %v\n", d.Digest,
-				d.Result)
-			continue
+// checkDigest verifies the merkle path of a digest and returns its status
+// together with a description of the result.
+func checkDigest(d v2.VerifyDigest) (string, string) {
+	result, ok := v2.Result[d.Result]
+	if !ok {
+		return statusFailed, fmt.Sprintf("invalid error code %v", d.Result)
+	}
+	if d.Result != v2.ResultOK {
+		if d.Result == v2.ResultDoesntExistError {
+			return statusNotFound, result
 		}
+		return statusFailed, result
+	}
 
-		// Verify merkle path.
-		root, err := merkle.VerifyAuthPath((*merkle.Branch)(&d.ChainInformation.MerklePath))
-		if err != nil {
-			if err != merkle.ErrEmpty {
-				fmt.Printf("%v invalid auth path %v\n",
-					d.Digest, err)
-				continue
+	// Verify merkle path.
+	root, err := merkle.VerifyAuthPath((*merkle.Branch)(&d.ChainInformation.MerklePath))
+	if err != nil {
+		if err != merkle.ErrEmpty {
+			return statusFailed, fmt.Sprintf("invalid auth path %v",
+				err)
+		}
+		return statusPending, "Not anchored"
+	}
+
+	// Verify merkle root.
+	merkleRoot, err := hex.DecodeString(d.ChainInformation.MerkleRoot)
+	if err != nil {
+		return statusFailed, fmt.Sprintf("invalid merkle root: %v", err)
+	}
+	// This is silly since we check against returned root.
+	if !bytes.Equal(root[:], merkleRoot) {
+		return statusFailed, "invalid merkle root"
+	}
+
+	return statusAnchored, result
+}
+
+// verifyDigests prints the results of the provided digests and tallies them
+// in the summary.  Only digests that were not found or failed to verify are
+// printed unless verbose output was requested.  Machine-readable output
+// prints all digests.
+func verifyDigests(vd []v2.VerifyDigest, s *verifySummary) {
+	for _, d := range vd {
+		status, result := checkDigest(d)
+		s.add(status, d.ChainInformation.ChainTimestamp)
+
+		if isNDJSON() {
+			vr := verifyRecord{
+				Digest:          d.Digest,
+				ServerTimestamp: d.ServerTimestamp,
+				Status:          status,
 			}
-			fmt.Printf("%v Not anchored\n", d.Digest)
+			switch status {
+			case statusAnchored:
+				vr.ChainTimestamp = d.ChainInformation.ChainTimestamp
+				vr.MerkleRoot = d.ChainInformation.MerkleRoot
+				vr.Transaction = d.ChainInformation.Transaction
+				vr.AnchorPrefix = d.ChainInformation.AnchorPrefix
+			case statusFailed:
+				vr.Error = result
+			}
+			printRecord(vr)
 			continue
 		}
 
-		// Verify merkle root.
-		merkleRoot, err := hex.DecodeString(d.ChainInformation.MerkleRoot)
-		if err != nil {
-			fmt.Printf("invalid merkle root: %v\n", err)
+		switch status {
+		case statusFailed, statusNotFound:
+			fmt.Printf("%v %v\n", d.Digest, colorize(colorRed, result))
 			continue
-		}
-		// This is silly since we check against returned root.
-		if !bytes.Equal(root[:], merkleRoot) {
-			fmt.Printf("%v invalid merkle root\n", d.Digest)
+		case statusPending:
+			if *verbose {
+				fmt.Printf("%v %v\n", d.Digest,
+					colorize(colorYellow, result))
+			}
 			continue
 		}
 
-		// Print the good news.
-		fmt.Printf("%v %v\n", d.Digest, result)
-
 		if !*verbose {
 			continue
 		}
+
+		// Print the good news.
+		fmt.Printf("%v %v\n", d.Digest, colorize(colorGreen, result))
 		fmt.Printf("  %-16v: %v\n", "Chain Timestamp",
-			d.ChainInformation.ChainTimestamp)
+			formatTime(d.ChainInformation.ChainTimestamp))
 		fmt.Printf("  %-16v: %v\n", "Server Timestamp",
-			d.ServerTimestamp)
+			formatTime(d.ServerTimestamp))
 		fmt.Printf("  %-16v: %v\n", "Flush Timestamp",
-			d.FlushTimestamp)
+			formatTime(d.FlushTimestamp))
+		if d.Label != "" {
+			fmt.Printf("  %-16v: %v\n", "Label", d.Label)
+		}
+		if d.Algorithm != "" {
+			fmt.Printf("  %-16v: %v\n", "Algorithm", d.Algorithm)
+		}
 		fmt.Printf("  %-16v: %v\n", "Merkle Root",
 			d.ChainInformation.MerkleRoot)
 		fmt.Printf("  %-16v: %v\n", "TxID",
 			d.ChainInformation.Transaction)
+		if d.ChainInformation.AnchorPrefix != "" {
+			fmt.Printf("  %-16v: %q\n", "Anchor Prefix",
+				d.ChainInformation.AnchorPrefix)
+		}
+		for _, a := range d.ChainInformation.Attestations {
+			fmt.Printf("  %-16v: %v\n", "Attestation", a.Anchorer)
+		}
 	}
 }
 
-func verifyTimestamps(vt []v2.VerifyTimestamp) error {
-	for _, t := range vt {
-		result, ok := v2.Result[t.Result]
+// checkTimestamp verifies the merkle root of an anchored collection and
+// returns its status together with a description of the result.  An error is
+// returned if the server replied with invalid digests.
+func checkTimestamp(t v2.VerifyTimestamp) (string, string, error) {
+	result, ok := v2.Result[t.Result]
+	if !ok {
+		return statusFailed, fmt.Sprintf("invalid error code %v",
+			t.Result), nil
+	}
+	if t.Result != v2.ResultOK {
+		if t.Result == v2.ResultDoesntExistError {
+			return statusNotFound, result, nil
+		}
+		return statusFailed, result, nil
+	}
+
+	// Verify results if the collection is anchored.
+	if t.CollectionInformation.ChainTimestamp == 0 {
+		return statusPending, "Not anchored", nil
+	}
+
+	// Calculate merkle root of all digests.
+	digests := make([]*[sha256.Size]byte, 0,
+		len(t.CollectionInformation.Digests))
+	for _, digest := range t.CollectionInformation.Digests {
+		d, ok := convertDigest(digest)
 		if !ok {
-			fmt.Printf("%v invalid error code %v\n", t.ServerTimestamp,
-				t.Result)
-			continue
+			return "", "", fmt.Errorf("invalid digest server "+
+				"response for timestamp: %v", t.ServerTimestamp)
 		}
+		digests = append(digests, &d)
+	}
+	root := merkle.Root(digests)
+	if hex.EncodeToString(root[:]) != t.CollectionInformation.MerkleRoot {
+		return statusFailed, "invalid merkle root", nil
+	}
 
-		// Verify results if the collection is anchored.
-		if t.CollectionInformation.ChainTimestamp != 0 {
-			// Calculate merkle root of all digests.
-			digests := make([]*[sha256.Size]byte, 0,
-				len(t.CollectionInformation.Digests))
-			for _, digest := range t.CollectionInformation.Digests {
-				d, ok := convertDigest(digest)
-				if !ok {
-					return fmt.Errorf("invalid digest "+
-						"server response for "+
-						"timestamp: %v",
-						t.ServerTimestamp)
-				}
-				digests = append(digests, &d)
+	return statusAnchored, result, nil
+}
+
+// verifyTimestamps prints the results of the provided collection timestamps
+// and tallies them in the summary.  Only timestamps that were not found or
+// failed to verify are printed unless verbose output was requested.
+// Machine-readable output prints all timestamps.
+func verifyTimestamps(vt []v2.VerifyTimestamp, s *verifySummary) error {
+	for _, t := range vt {
+		status, result, err := checkTimestamp(t)
+		if err != nil {
+			return err
+		}
+		s.add(status, t.CollectionInformation.ChainTimestamp)
+
+		if isNDJSON() {
+			vr := verifyRecord{
+				ServerTimestamp: t.ServerTimestamp,
+				Status:          status,
+				Digests:         t.CollectionInformation.Digests,
 			}
-			root := merkle.Root(digests)
-			if hex.EncodeToString(root[:]) !=
-				t.CollectionInformation.MerkleRoot {
-				fmt.Printf("invalid merkle root\n")
+			switch status {
+			case statusAnchored:
+				vr.ChainTimestamp = t.CollectionInformation.ChainTimestamp
+				vr.MerkleRoot = t.CollectionInformation.MerkleRoot
+				vr.Transaction = t.CollectionInformation.Transaction
+				vr.AnchorPrefix = t.CollectionInformation.AnchorPrefix
+			case statusFailed:
+				vr.Error = result
 			}
+			printRecord(vr)
+			continue
 		}
 
-		// Print the good news.
-		if t.CollectionInformation.ChainTimestamp == 0 &&
-			t.Result == v2.ResultOK {
-			result = "Not anchored"
+		switch status {
+		case statusFailed, statusNotFound:
+			fmt.Printf("%v %v\n", t.ServerTimestamp,
+				colorize(colorRed, result))
+			continue
 		}
-		fmt.Printf("%v %v\n", t.ServerTimestamp, result)
 
 		if !*verbose {
 			continue
 		}
 
+		// Print the good news.
+		anchored := status == statusAnchored
+		if anchored {
+			fmt.Printf("%v %v\n", t.ServerTimestamp,
+				colorize(colorGreen, result))
+		} else {
+			fmt.Printf("%v %v\n", t.ServerTimestamp,
+				colorize(colorYellow, result))
+		}
+
 		prefix := "Digests"
 		for _, digest := range t.CollectionInformation.Digests {
 			fmt.Printf("  %-15v: %v\n", prefix, digest)
@@ -502,18 +695,25 @@ func verifyTimestamps(vt []v2.VerifyTimestamp) error {
 
 		// Print flush time
 		fmt.Printf("  %-15v: %v\n", "Flush Timestamp",
-			t.FlushTimestamp)
+			formatTime(t.FlushTimestamp))
 
 		// Only print additional info if we are anchored
-		if t.CollectionInformation.ChainTimestamp == 0 {
+		if !anchored {
 			continue
 		}
 		fmt.Printf("  %-15v: %v\n", "Chain Timestamp",
-			t.CollectionInformation.ChainTimestamp)
+			formatTime(t.CollectionInformation.ChainTimestamp))
 		fmt.Printf("  %-15v: %v\n", "Merkle Root",
 			t.CollectionInformation.MerkleRoot)
 		fmt.Printf("  %-15v: %v\n", "TxID",
 			t.CollectionInformation.Transaction)
+		if t.CollectionInformation.AnchorPrefix != "" {
+			fmt.Printf("  %-15v: %q\n", "Anchor Prefix",
+				t.CollectionInformation.AnchorPrefix)
+		}
+		for _, a := range t.CollectionInformation.Attestations {
+			fmt.Printf("  %-15v: %v\n", "Attestation", a.Anchorer)
+		}
 	}
 
 	return nil
@@ -558,18 +758,31 @@ func uploadV1(digests []string, exists map[string]string) error {
 		return fmt.Errorf("%v: %v", r.Status, e)
 	}
 
-	if *printJSON {
-		io.Copy(os.Stdout, r.Body)
-		fmt.Printf("\n")
-		return nil
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return err
 	}
 
 	// Decode response.
 	var tsReply v1.TimestampReply
-	decoder := json.NewDecoder(r.Body)
-	if err := decoder.Decode(&tsReply); err != nil {
+	if err := json.Unmarshal(body, &tsReply); err != nil {
 		return fmt.Errorf("could not decode TimestampReply: %v", err)
 	}
+	if len(tsReply.Digests) != len(tsReply.Results) {
+		return fmt.Errorf("invalid TimestampReply: %v digests, "+
+			"%v results", len(tsReply.Digests), len(tsReply.Results))
+	}
+	ok := make([]bool, 0, len(tsReply.Results))
+	for _, v := range tsReply.Results {
+		ok = append(ok, v == v1.ResultOK)
+	}
+	saveReceipts(newReceipts(tsReply.Digests, ok, tsReply.ServerTimestamp,
+		exists, nil))
+
+	if *printJSON {
+		fmt.Println(string(body))
+		return nil
+	}
 
 	// Print human readable results.
 	for k, v := range tsReply.Results {
@@ -583,7 +796,8 @@ func uploadV1(digests []string, exists map[string]string) error {
 
 	if *verbose {
 		// Print server timestamp.
-		fmt.Printf("Collection timestamp: %v\n", tsReply.ServerTimestamp)
+		fmt.Printf("Collection timestamp: %v\n",
+			formatTime(tsReply.ServerTimestamp))
 	}
 
 	return nil
@@ -592,8 +806,11 @@ func uploadV1(digests []string, exists map[string]string) error {
 func uploadV2Batch(digests []string, exists map[string]string) error {
 	// batch uploads
 	ts := v2.TimestampBatch{
-		ID:      dcrtimeClientID,
-		Digests: digests,
+		ID:              dcrtimeClientID,
+		Label:           *label,
+		Algorithm:       *algorithm,
+		Digests:         digests,
+		PendingReceipts: *requestReceipts,
 	}
 	b, err := json.Marshal(ts)
 	if err != nil {
@@ -628,32 +845,67 @@ func uploadV2Batch(digests []string, exists map[string]string) error {
 		return fmt.Errorf("%v: %v", r.Status, e)
 	}
 
-	if *printJSON {
-		io.Copy(os.Stdout, r.Body)
-		fmt.Printf("\n")
-		return nil
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+
+	if err := checkSignature(r, body); err != nil {
+		return err
 	}
 
 	// Decode response.
 	var tsReply v2.TimestampBatchReply
-	decoder := json.NewDecoder(r.Body)
-	if err := decoder.Decode(&tsReply); err != nil {
+	if err := json.Unmarshal(body, &tsReply); err != nil {
 		return fmt.Errorf("could not decode TimestampReply: %v", err)
 	}
-
-	// Print human readable results.
+	if len(tsReply.Digests) != len(tsReply.Results) {
+		return fmt.Errorf("invalid TimestampReply: %v digests, "+
+			"%v results", len(tsReply.Digests), len(tsReply.Results))
+	}
+	if err := checkPendingReceipts(tsReply.PendingReceipts); err != nil {
+		return err
+	}
+	originals := originalSubmissions(tsReply.Duplicates)
+	results := make([]v2.ResultT, 0, len(tsReply.Results))
+	timestamps := make([]int64, 0, len(tsReply.Results))
+	ok := make([]bool, 0, len(tsReply.Results))
 	for k, v := range tsReply.Results {
-		filename := exists[tsReply.Digests[k]]
-		if v == v2.ResultOK {
-			fmt.Printf("%v OK %v\n", tsReply.Digests[k], filename)
-			continue
+		result, ts := uploadResult(tsReply.Digests[k], v,
+			tsReply.ServerTimestamp, originals)
+		results = append(results, result)
+		timestamps = append(timestamps, ts)
+		ok = append(ok, result == v2.ResultOK)
+	}
+	rs := newReceipts(tsReply.Digests, ok, tsReply.ServerTimestamp,
+		exists, tsReply.AccessKeys)
+	for k := range rs {
+		if ok[k] {
+			rs[k].ServerTimestamp = timestamps[k]
 		}
-		fmt.Printf("%v Exists %v\n", tsReply.Digests[k], filename)
+	}
+	addPendingReceipts(rs, tsReply.PendingReceipts)
+	saveReceipts(rs)
+
+	if *printJSON {
+		fmt.Println(string(body))
+		return nil
+	}
+
+	// Print results.
+	for k, v := range results {
+		filename := exists[tsReply.Digests[k]]
+		printUpload(tsReply.Digests[k], filename, v, timestamps[k])
 	}
 
 	if *verbose {
 		// Print server timestamp.
-		fmt.Printf("Collection timestamp: %v\n", tsReply.ServerTimestamp)
+		fmt.Printf("Collection timestamp: %v\n",
+			formatTime(tsReply.ServerTimestamp))
+	}
+	if tsReply.Maintenance {
+		fmt.Println("Server is in maintenance, digests are anchored " +
+			"once it ends")
 	}
 
 	return nil
@@ -661,11 +913,19 @@ func uploadV2Batch(digests []string, exists map[string]string) error {
 
 func uploadV2Single(digest string, exists map[string]string) error {
 	ts := v2.Timestamp{
-		ID:     dcrtimeClientID,
-		Digest: digest,
+		ID:             dcrtimeClientID,
+		Digest:         digest,
+		Algorithm:      *algorithm,
+		PendingReceipt: *requestReceipts,
 	}
 	formParam := url.Values{}
 	formParam.Set("digest", digest)
+	if *algorithm != "" {
+		formParam.Set("algorithm", *algorithm)
+	}
+	if *requestReceipts {
+		formParam.Set("pendingreceipt", "true")
+	}
 
 	// If this is a trial run return.
 	if *trial {
@@ -694,39 +954,96 @@ func uploadV2Single(digest string, exists map[string]string) error {
 		return fmt.Errorf("%v: %v", r.Status, e)
 	}
 
-	if *printJSON {
-		io.Copy(os.Stdout, r.Body)
-		fmt.Printf("\n")
-		return nil
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+
+	if err := checkSignature(r, body); err != nil {
+		return err
 	}
 
 	// Decode response.
 	var tsReply v2.TimestampReply
-	decoder := json.NewDecoder(r.Body)
-	if err := decoder.Decode(&tsReply); err != nil {
+	if err := json.Unmarshal(body, &tsReply); err != nil {
 		return fmt.Errorf("could not decode TimestampReply: %v", err)
 	}
+	if tsReply.PendingReceipt != nil {
+		err := checkPendingReceipts([]v2.PendingReceipt{
+			*tsReply.PendingReceipt,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	var dups []v2.Duplicate
+	if tsReply.Duplicate != nil {
+		dups = append(dups, *tsReply.Duplicate)
+	}
+	result, serverTimestamp := uploadResult(tsReply.Digest,
+		tsReply.Result, tsReply.ServerTimestamp,
+		originalSubmissions(dups))
+	rs := newReceipts([]string{tsReply.Digest},
+		[]bool{result == v2.ResultOK}, serverTimestamp, exists,
+		[]string{tsReply.AccessKey})
+	if tsReply.PendingReceipt != nil {
+		addPendingReceipts(rs,
+			[]v2.PendingReceipt{*tsReply.PendingReceipt})
+	}
+	saveReceipts(rs)
 
-	// Print human readable results.
-	filename := exists[tsReply.Digest]
-	if tsReply.Result == v2.ResultOK {
-		fmt.Printf("%v OK %v\n", tsReply.Digest, filename)
-	} else {
-		fmt.Printf("%v Exists %v\n", tsReply.Digest, filename)
+	if *printJSON {
+		fmt.Println(string(body))
+		return nil
 	}
 
+	// Print results.
+	printUpload(tsReply.Digest, exists[tsReply.Digest], result,
+		serverTimestamp)
+
 	if *verbose {
 		// Print server timestamp.
-		fmt.Printf("Collection timestamp: %v\n", tsReply.ServerTimestamp)
+		fmt.Printf("Collection timestamp: %v\n",
+			formatTime(tsReply.ServerTimestamp))
 	}
 
 	return nil
 }
 
+// originalSubmissions maps the digests that were timestamped before to the
+// collection timestamp of their original submission if the duplicateok flag
+// is set.  It returns nil otherwise.
+func originalSubmissions(dups []v2.Duplicate) map[string]int64 {
+	if !*duplicateOK {
+		return nil
+	}
+	originals := make(map[string]int64, len(dups))
+	for _, dup := range dups {
+		originals[strings.ToLower(dup.Digest)] = dup.ServerTimestamp
+	}
+	return originals
+}
+
+// uploadResult returns the result and collection timestamp of a timestamped
+// digest.  Digests that were timestamped before are reported as timestamped in
+// the collection of their original submission if it is in originals.
+func uploadResult(digest string, result v2.ResultT, serverTimestamp int64, originals map[string]int64) (v2.ResultT, int64) {
+	if result != v2.ResultExistsError {
+		return result, serverTimestamp
+	}
+	if ts, ok := originals[strings.ToLower(digest)]; ok {
+		return v2.ResultOK, ts
+	}
+	return result, serverTimestamp
+}
+
 func uploadV2(digests []string, exists map[string]string) error {
 	var err error
-	switch len(digests) {
-	case 1:
+	switch {
+	case *session || *sessionID != "":
+		err = uploadV2Session(digests, exists)
+	case len(digests) == 1 && *label == "":
+		// Labels can only be provided on batch uploads.
 		err = uploadV2Single(digests[0], exists)
 	default:
 		err = uploadV2Batch(digests, exists)
@@ -910,7 +1227,8 @@ func lastAnchorV1() error {
 			"Transaction:      %v\n"+
 			"Blockhash:        %v\n"+
 			"Blockheight:      %v\n",
-		anchor.ChainTimestamp, anchor.Transaction, anchor.BlockHash, anchor.BlockHeight)
+		formatTime(anchor.ChainTimestamp), anchor.Transaction,
+		anchor.BlockHash, anchor.BlockHeight)
 
 	return nil
 }
@@ -965,7 +1283,8 @@ func lastAnchorV2() error {
 			"Transaction:      %v\n"+
 			"Blockhash:        %v\n"+
 			"Blockheight:      %v\n",
-		anchor.ChainTimestamp, anchor.Transaction, anchor.BlockHash, anchor.BlockHeight)
+		formatTime(anchor.ChainTimestamp), anchor.Transaction,
+		anchor.BlockHash, anchor.BlockHeight)
 
 	return nil
 }
@@ -1039,6 +1358,62 @@ func lastDigestsV2(n int32) error {
 	return nil
 }
 
+// labelDigestsV2 displays all digests that were timestamped under the
+// provided group label.
+func labelDigestsV2(l string) error {
+	c := newClient(*skipVerify)
+	route := *host + v2.LabelRoute
+
+	b, err := json.Marshal(v2.Label{
+		ID:    dcrtimeClientID,
+		Label: l,
+	})
+	if err != nil {
+		return err
+	}
+
+	if *debug {
+		fmt.Println(string(b))
+		fmt.Println(route)
+	}
+
+	r, err := c.Post(route, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+
+	if r.StatusCode != http.StatusOK {
+		e, err := getError(r.Body)
+		if err != nil {
+			return fmt.Errorf("%v", r.Status)
+		}
+		return fmt.Errorf("%v: %v", r.Status, e)
+	}
+
+	if *printJSON {
+		io.Copy(os.Stdout, r.Body)
+		fmt.Printf("\n")
+		return nil
+	}
+
+	var lr v2.LabelReply
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&lr); err != nil {
+		return fmt.Errorf("could not decode LabelReply: %v", err)
+	}
+
+	if len(lr.Digests) == 0 {
+		fmt.Printf("No digests found for label %v\n", lr.Label)
+		return nil
+	}
+	var summary verifySummary
+	verifyDigests(lr.Digests, &summary)
+	summary.print()
+
+	return nil
+}
+
 func hasDigestFlag() bool {
 	return digest != nil && *digest != ""
 }
@@ -1053,6 +1428,106 @@ func ensureFlagCompatibility() error {
 		return fmt.Errorf(
 			"-digest and -file flags cannot be used simultaneously")
 	}
+	if (*label != "" || *getLabel != "") && *apiVersion == v1.APIVersion {
+		return fmt.Errorf("-label and -getlabel require API v2")
+	}
+	if *trustPath != "" && *apiVersion == v1.APIVersion {
+		return fmt.Errorf("-trust requires API v2")
+	}
+	if *algorithm != "" {
+		if *apiVersion == v1.APIVersion {
+			return fmt.Errorf("-algorithm requires API v2")
+		}
+		if !hasDigestFlag() {
+			return fmt.Errorf("-algorithm requires -digest, files " +
+				"are always hashed with sha256")
+		}
+		if !v2.IsAlgorithm(*algorithm) {
+			return fmt.Errorf("unsupported -algorithm %v, supported: %v",
+				*algorithm, strings.Join(v2.Algorithms, ", "))
+		}
+	}
+	if *manifestPath != "" {
+		if *apiVersion == v1.APIVersion {
+			return fmt.Errorf("-manifest requires API v2")
+		}
+		if hasDigestFlag() {
+			return fmt.Errorf(
+				"-digest and -manifest flags cannot be used simultaneously")
+		}
+	}
+	switch *format {
+	case formatText, formatJSON:
+	case formatNDJSON:
+		if *apiVersion == v1.APIVersion {
+			return fmt.Errorf("-format ndjson requires API v2")
+		}
+		if *verbose || *printJSON {
+			return fmt.Errorf("-format ndjson cannot be used with " +
+				"the -v and -json flags")
+		}
+	default:
+		return fmt.Errorf("unsupported -format %v, supported: %v, "+
+			"%v, %v", *format, formatText, formatJSON, formatNDJSON)
+	}
+	if *requestReceipts {
+		if *apiVersion == v1.APIVersion {
+			return fmt.Errorf("-pendingreceipts requires API v2")
+		}
+		if *session || *sessionID != "" {
+			return fmt.Errorf("-pendingreceipts cannot be used " +
+				"with the -session flag")
+		}
+	}
+	if *duplicateOK {
+		if *apiVersion == v1.APIVersion {
+			return fmt.Errorf("-duplicateok requires API v2")
+		}
+		if *session || *sessionID != "" {
+			return fmt.Errorf("-duplicateok cannot be used with " +
+				"the -session flag")
+		}
+	}
+	if *session || *sessionID != "" {
+		if *apiVersion == v1.APIVersion {
+			return fmt.Errorf("-session requires API v2")
+		}
+		if *manifestPath != "" || isWatchCommand() {
+			return fmt.Errorf("-session cannot be used with the " +
+				"-manifest flag and watch")
+		}
+		if *sessionID != "" && !v2.RegexpSessionID.MatchString(*sessionID) {
+			return fmt.Errorf("invalid -sessionid %v", *sessionID)
+		}
+		if *sessionChunk < 1 || *sessionChunk > v2.MaxSessionAppendDigests {
+			return fmt.Errorf("-sessionchunk must be between 1 and %v",
+				v2.MaxSessionAppendDigests)
+		}
+	}
+	if isWatchCommand() {
+		if *apiVersion == v1.APIVersion {
+			return fmt.Errorf("watch requires API v2")
+		}
+		if hasDigestFlag() || *manifestPath != "" {
+			return fmt.Errorf("watch cannot be used with the " +
+				"-digest and -manifest flags")
+		}
+		if flag.NArg() != 2 {
+			return fmt.Errorf("usage: dcrtime [flags] watch <dir>")
+		}
+	}
+	if isStatusCommand() {
+		if *apiVersion == v1.APIVersion {
+			return fmt.Errorf("status requires API v2")
+		}
+		if hasDigestFlag() || *manifestPath != "" || *noReceipts {
+			return fmt.Errorf("status cannot be used with the " +
+				"-digest, -manifest and -noreceipts flags")
+		}
+		if flag.NArg() != 1 {
+			return fmt.Errorf("usage: dcrtime [flags] status")
+		}
+	}
 
 	return nil
 }
@@ -1090,7 +1565,24 @@ func loadCredentialsIfRequired() error {
 
 func _main() error {
 	flag.Parse()
-	err := loadCredentialsIfRequired()
+	if *format == formatJSON {
+		*printJSON = true
+	}
+	loc, err := time.LoadLocation(*tz)
+	if err != nil {
+		return fmt.Errorf("invalid time zone %v: %v", *tz, err)
+	}
+	displayLocation = loc
+	err = loadTrustBundle()
+	if err != nil {
+		return err
+	}
+	useColor = colorEnabled()
+	err = applyProfile()
+	if err != nil {
+		return err
+	}
+	err = loadCredentialsIfRequired()
 	if err != nil {
 		return err
 	}
@@ -1162,12 +1654,37 @@ func _main() error {
 	}
 	*host = u.String()
 
+	// Refuse servers that are not pinned by the trust bundle.
+	err = checkIdentity()
+	if err != nil {
+		return err
+	}
+
 	// Allow submitting a pre-calculated 256 bit digest from the command line,
 	// rather than needing to hash a payload.
 	if hasDigestFlag() {
 		return upload([]string{*digest}, make(map[string]string))
 	}
 
+	// Only timestamp new and changed files of a tree, or verify the
+	// manifest and the files it records.
+	if isManifestVerify() {
+		return verifyManifest(*manifestPath)
+	}
+	if *manifestPath != "" {
+		return timestampManifest(*manifestPath, flag.Args())
+	}
+
+	// Continuously timestamp new and changed files of a directory.
+	if isWatchCommand() {
+		return watchDirectory(flag.Arg(1))
+	}
+
+	// Refresh the anchor status of all pending receipts.
+	if isStatusCommand() {
+		return refreshReceipts()
+	}
+
 	// Print the wallet balance via privileged endpoint.
 	if *balance {
 		err := showWalletBalance()
@@ -1187,6 +1704,16 @@ func _main() error {
 		didRunCommand = true
 	}
 
+	// Print all digests of a group label.
+	if *getLabel != "" {
+		err := labelDigestsV2(*getLabel)
+		if err != nil {
+			return err
+		}
+
+		didRunCommand = true
+	}
+
 	// Print last n digests info
 	if *lastDigests {
 		if hasDigestsNumberFlag() {
@@ -1207,11 +1734,24 @@ func _main() error {
 	// the server for lookup.  Use fileOnly to override this behavior.
 	var uploadArr []string
 	var downloadArr []string
+	var readStdin bool
 	exists := make(map[string]string) // [digest]filename
 	for _, a := range flag.Args() {
-		// Try to see if argument is a valid file.
-		if isFile(a) || *fileOnly {
-			d, err := util.DigestFile(a)
+		// Try to see if argument is a valid file.  Stdin is hashed
+		// like a file.
+		if a == stdinArg || isFile(a) || *fileOnly {
+			var d string
+			var err error
+			if a == stdinArg {
+				if readStdin {
+					return fmt.Errorf("stdin can only be " +
+						"read once")
+				}
+				readStdin = true
+				d, err = util.DigestReader(os.Stdin)
+			} else {
+				d, err = util.DigestFile(a)
+			}
 			if err != nil {
 				return err
 			}
@@ -0,0 +1,415 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	v2 "github.com/decred/dcrtime/api/v2"
+	"github.com/decred/dcrtime/util"
+)
+
+const (
+	// manifestVersion is the version of the manifest file format.
+	manifestVersion = 1
+
+	// verifyCommand is the argument that verifies a manifest instead of
+	// updating it.
+	verifyCommand = "verify"
+)
+
+// manifestLink is a previous digest of a file that was superseded by a newer
+// one.
+type manifestLink struct {
+	Digest          string `json:"digest"`
+	ServerTimestamp int64  `json:"servertimestamp"`
+}
+
+// manifestFile is the last timestamped digest of a file. Supersedes links to
+// all prior digests of the file, newest first, so that the history of a file
+// can be verified from the most recent manifest alone.
+type manifestFile struct {
+	Path            string         `json:"path"`
+	Digest          string         `json:"digest"`
+	ServerTimestamp int64          `json:"servertimestamp"` // Zero if it already existed
+	Supersedes      []manifestLink `json:"supersedes,omitempty"`
+}
+
+// manifest records the digests of a tree of files. It is rewritten after
+// every run and is used to only submit the digests of new and changed files
+// during the next run. The manifest itself is timestamped after it was
+// written and links to the digest of the manifest it replaced, so that the
+// manifests of all runs form a chain.
+type manifest struct {
+	Version   int            `json:"version"`
+	Timestamp int64          `json:"timestamp"`          // Time of the last run
+	Previous  string         `json:"previous,omitempty"` // Digest of the replaced manifest
+	Files     []manifestFile `json:"files"`
+}
+
+// isManifestVerify returns true if dcrtime was invoked as
+// dcrtime -manifest <manifest> verify.
+func isManifestVerify() bool {
+	return *manifestPath != "" && flag.NArg() == 1 &&
+		flag.Arg(0) == verifyCommand && !isFile(verifyCommand)
+}
+
+// loadManifest reads the manifest at the provided path. An empty manifest is
+// returned if the file does not exist yet.
+func loadManifest(filename string) (*manifest, error) {
+	b, err := os.ReadFile(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return &manifest{Version: manifestVersion}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var m manifest
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("invalid manifest %v: %v", filename, err)
+	}
+	if m.Version != manifestVersion {
+		return nil, fmt.Errorf("unsupported manifest version %v: %v",
+			filename, m.Version)
+	}
+
+	return &m, nil
+}
+
+// saveManifest atomically replaces the manifest at the provided path.
+func saveManifest(filename string, m *manifest) error {
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := filename + ".tmp"
+	if err := os.WriteFile(tmp, append(b, '\n'), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filename)
+}
+
+// manifestPaths returns the regular files that are named by, or contained in,
+// the provided arguments. The manifest file itself is skipped.
+func manifestPaths(args []string, filename string) ([]string, error) {
+	skip, err := filepath.Abs(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var paths []string
+	for _, a := range args {
+		err := filepath.Walk(a, func(path string, fi os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !fi.Mode().IsRegular() {
+				return nil
+			}
+			abs, err := filepath.Abs(path)
+			if err != nil {
+				return err
+			}
+			if abs == skip || abs == skip+".tmp" {
+				return nil
+			}
+			paths = append(paths, filepath.ToSlash(filepath.Clean(path)))
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return paths, nil
+}
+
+// submitManifestV2 timestamps the provided digests in a single batch and
+// returns the reply of the server.  Names maps the digests to the file names
+// that are recorded in their receipts.
+func submitManifestV2(digests []string, names map[string]string) (*v2.TimestampBatchReply, error) {
+	ts := v2.TimestampBatch{
+		ID:      dcrtimeClientID,
+		Label:   *label,
+		Digests: digests,
+	}
+	b, err := json.Marshal(ts)
+	if err != nil {
+		return nil, err
+	}
+
+	route := *host + v2.TimestampBatchRoute
+
+	if *debug {
+		fmt.Println(string(b))
+		fmt.Println(route)
+	}
+
+	c := newClient(*skipVerify)
+	r, err := c.Post(route, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Body.Close()
+
+	if r.StatusCode != http.StatusOK {
+		e, err := getError(r.Body)
+		if err != nil {
+			return nil, fmt.Errorf("%v", r.Status)
+		}
+		return nil, fmt.Errorf("%v: %v", r.Status, e)
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkSignature(r, body); err != nil {
+		return nil, err
+	}
+	if *printJSON {
+		fmt.Println(string(body))
+	}
+
+	var tsReply v2.TimestampBatchReply
+	if err := json.Unmarshal(body, &tsReply); err != nil {
+		return nil, fmt.Errorf("could not decode TimestampReply: %v", err)
+	}
+	if len(tsReply.Digests) != len(tsReply.Results) {
+		return nil, fmt.Errorf("invalid TimestampReply: %v digests, "+
+			"%v results", len(tsReply.Digests), len(tsReply.Results))
+	}
+	ok := make([]bool, 0, len(tsReply.Results))
+	for _, v := range tsReply.Results {
+		ok = append(ok, v == v2.ResultOK)
+	}
+	saveReceipts(newReceipts(tsReply.Digests, ok, tsReply.ServerTimestamp,
+		names, tsReply.AccessKeys))
+
+	return &tsReply, nil
+}
+
+// timestampManifest hashes all files in the provided arguments and only
+// timestamps the files that are new or changed since the previous run that
+// was recorded in the manifest. Changed files link to the digests they
+// supersede. The manifest is rewritten unless this is a trial run.
+func timestampManifest(filename string, args []string) error {
+	prev, err := loadManifest(filename)
+	if err != nil {
+		return err
+	}
+	previous := make(map[string]manifestFile, len(prev.Files))
+	for _, f := range prev.Files {
+		previous[f.Path] = f
+	}
+	var prevDigest string
+	if isFile(filename) {
+		prevDigest, err = util.DigestFile(filename)
+		if err != nil {
+			return err
+		}
+	}
+
+	paths, err := manifestPaths(args, filename)
+	if err != nil {
+		return err
+	}
+	if len(paths) == 0 {
+		return fmt.Errorf("nothing to do")
+	}
+
+	var (
+		files    = make([]manifestFile, 0, len(paths))
+		digests  []string
+		names    = make(map[string]string)               // [digest]path
+		seen     = make(map[string]struct{}, len(paths)) // [path]
+		pending  = make(map[string]struct{})             // [digest]
+		created  int
+		changed  int
+		retained int
+	)
+	for _, path := range paths {
+		if _, ok := seen[path]; ok {
+			continue
+		}
+		seen[path] = struct{}{}
+
+		d, err := util.DigestFile(path)
+		if err != nil {
+			return err
+		}
+
+		f, ok := previous[path]
+		switch {
+		case !ok:
+			f = manifestFile{
+				Path:   path,
+				Digest: d,
+			}
+			created++
+		case f.Digest == d:
+			files = append(files, f)
+			retained++
+			if *verbose {
+				fmt.Printf("%v Unchanged %v\n", d, path)
+			}
+			continue
+		default:
+			link := manifestLink{
+				Digest:          f.Digest,
+				ServerTimestamp: f.ServerTimestamp,
+			}
+			f = manifestFile{
+				Path:       path,
+				Digest:     d,
+				Supersedes: append([]manifestLink{link}, f.Supersedes...),
+			}
+			changed++
+		}
+		files = append(files, f)
+
+		if _, ok := pending[d]; !ok {
+			pending[d] = struct{}{}
+			digests = append(digests, d)
+			names[d] = path
+		}
+		if *verbose {
+			fmt.Printf("%v Upload %v\n", d, path)
+		}
+	}
+	removed := len(previous) - retained - changed
+
+	// Submit the digests of new and changed files and record the collection
+	// they were added to.
+	if len(digests) != 0 && !*trial {
+		reply, err := submitManifestV2(digests, names)
+		if err != nil {
+			return err
+		}
+		results := make(map[string]v2.ResultT, len(reply.Digests))
+		for k, d := range reply.Digests {
+			results[d] = reply.Results[k]
+		}
+		for k := range files {
+			f := &files[k]
+			result, ok := results[f.Digest]
+			if !ok || f.ServerTimestamp != 0 {
+				continue
+			}
+			if result == v2.ResultOK {
+				f.ServerTimestamp = reply.ServerTimestamp
+			}
+			printUpload(f.Digest, f.Path, result,
+				reply.ServerTimestamp)
+		}
+		if *verbose {
+			fmt.Printf("Collection timestamp: %v\n",
+				formatTime(reply.ServerTimestamp))
+		}
+	}
+
+	if !isNDJSON() {
+		fmt.Printf("Manifest: %v new, %v changed, %v unchanged, "+
+			"%v removed\n", created, changed, retained, removed)
+	}
+
+	if *trial {
+		return nil
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Path < files[j].Path
+	})
+	err = saveManifest(filename, &manifest{
+		Version:   manifestVersion,
+		Timestamp: time.Now().Unix(),
+		Previous:  prevDigest,
+		Files:     files,
+	})
+	if err != nil {
+		return err
+	}
+	return anchorManifest(filename)
+}
+
+// anchorManifest timestamps the digest of the manifest at the provided path so
+// that the manifest is provably as old as the files it records.
+func anchorManifest(filename string) error {
+	d, err := util.DigestFile(filename)
+	if err != nil {
+		return err
+	}
+	reply, err := submitManifestV2([]string{d},
+		map[string]string{d: filename})
+	if err != nil {
+		return fmt.Errorf("manifest %v was saved but not timestamped: %v",
+			filename, err)
+	}
+	if len(reply.Results) != 1 {
+		return fmt.Errorf("invalid TimestampReply: %v results",
+			len(reply.Results))
+	}
+	printUpload(d, filename, reply.Results[0], reply.ServerTimestamp)
+
+	return nil
+}
+
+// verifyManifest verifies the digest of the manifest at the provided path
+// together with the digest of the manifest it replaced and the digests of all
+// files it records.
+func verifyManifest(filename string) error {
+	if !isFile(filename) {
+		return fmt.Errorf("manifest not found: %v", filename)
+	}
+	m, err := loadManifest(filename)
+	if err != nil {
+		return err
+	}
+	d, err := util.DigestFile(filename)
+	if err != nil {
+		return err
+	}
+
+	questions := make([]string, 0, len(m.Files)+2)
+	seen := make(map[string]struct{}, len(m.Files)+2) // [digest]
+	add := func(digest, name string) {
+		if _, ok := seen[digest]; ok {
+			return
+		}
+		seen[digest] = struct{}{}
+		questions = append(questions, digest)
+		if *verbose {
+			fmt.Printf("%v Verify %v\n", digest, name)
+		}
+	}
+	add(d, filename)
+	if m.Previous != "" {
+		if !isDigest(m.Previous) {
+			return fmt.Errorf("invalid manifest %v: previous digest "+
+				"%v", filename, m.Previous)
+		}
+		add(m.Previous, "previous manifest")
+	}
+	for _, f := range m.Files {
+		if !isDigest(f.Digest) {
+			return fmt.Errorf("invalid manifest %v: digest %v of %v",
+				filename, f.Digest, f.Path)
+		}
+		add(f.Digest, f.Path)
+	}
+
+	return downloadV2Batch(questions)
+}
@@ -0,0 +1,86 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	v2 "github.com/decred/dcrtime/api/v2"
+)
+
+// Output formats of the format flag.
+const (
+	formatText   = "text"   // Human readable
+	formatJSON   = "json"   // JSON replies of the server, same as -json
+	formatNDJSON = "ndjson" // One JSON object per result
+)
+
+// Statuses of machine-readable results.
+const (
+	statusOK       = "ok"
+	statusExists   = "exists"
+	statusAnchored = "anchored"
+	statusPending  = "pending"
+	statusNotFound = "notfound"
+	statusFailed   = "failed"
+)
+
+// stdinArg is the argument that names stdin instead of a file.
+const stdinArg = "-"
+
+// uploadRecord is the machine-readable result of a timestamped digest.
+type uploadRecord struct {
+	Digest          string `json:"digest"`
+	Name            string `json:"name,omitempty"` // File name, if any
+	Status          string `json:"status"`
+	ServerTimestamp int64  `json:"servertimestamp"`
+	ServerTime      string `json:"servertime"`
+}
+
+// verifyRecord is the machine-readable result of a verified digest or
+// collection timestamp.  Digest is empty for timestamps.
+type verifyRecord struct {
+	Digest          string   `json:"digest,omitempty"`
+	ServerTimestamp int64    `json:"servertimestamp"`
+	Status          string   `json:"status"`
+	Error           string   `json:"error,omitempty"`
+	ChainTimestamp  int64    `json:"chaintimestamp,omitempty"`
+	MerkleRoot      string   `json:"merkleroot,omitempty"`
+	Transaction     string   `json:"transaction,omitempty"`
+	AnchorPrefix    string   `json:"anchorprefix,omitempty"`
+	Digests         []string `json:"digests,omitempty"` // Timestamps only
+}
+
+// isNDJSON returns true if results are printed as newline delimited JSON.
+func isNDJSON() bool {
+	return *format == formatNDJSON
+}
+
+// printRecord writes a single machine-readable result to stdout.
+func printRecord(v interface{}) {
+	json.NewEncoder(os.Stdout).Encode(v)
+}
+
+// printUpload prints the result of a timestamped digest.
+func printUpload(digest, name string, result v2.ResultT, serverTimestamp int64) {
+	verb, status := "OK", statusOK
+	if result != v2.ResultOK {
+		verb, status = "Exists", statusExists
+	}
+	if !isNDJSON() {
+		fmt.Printf("%v %v %v\n", digest, verb, name)
+		return
+	}
+
+	printRecord(uploadRecord{
+		Digest:          digest,
+		Name:            name,
+		Status:          status,
+		ServerTimestamp: serverTimestamp,
+		ServerTime:      v2.FormatTime(serverTimestamp),
+	})
+}
@@ -0,0 +1,165 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// applicationOptionsSection is the config file section of the options that
+// apply to all profiles.  Options before the first section apply to all
+// profiles as well.
+const applicationOptionsSection = "Application Options"
+
+// profile is a named set of server options.  Every section of the config file
+// other than the application options is a profile, e.g.
+//
+//	[work]
+//	host=time.example.com
+//	apitoken=...
+type profile struct {
+	host       string
+	port       string
+	skipVerify bool
+	apiToken   string
+	timeout    time.Duration
+	trust      string
+}
+
+// readProfiles returns the profiles of the config file at the provided path by
+// name.  No profiles are returned if the config file does not exist.
+func readProfiles(filename string) (map[string]*profile, error) {
+	profiles := make(map[string]*profile)
+	f, err := os.Open(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return profiles, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var p *profile
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == ';' || line[0] == '#' {
+			continue
+		}
+		if line[0] == '[' && line[len(line)-1] == ']' {
+			name := strings.TrimSpace(line[1 : len(line)-1])
+			if name == applicationOptionsSection {
+				p = nil
+				continue
+			}
+			if _, ok := profiles[name]; ok {
+				return nil, fmt.Errorf("%v:%v: duplicate profile %v",
+					filename, n, name)
+			}
+			p = &profile{}
+			profiles[name] = p
+			continue
+		}
+		if p == nil {
+			// Application options are parsed by loadConfig.
+			continue
+		}
+
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("%v:%v: invalid line %q", filename,
+				n, line)
+		}
+		value := strings.TrimSpace(kv[1])
+		switch key := strings.ToLower(strings.TrimSpace(kv[0])); key {
+		case "host":
+			p.host = value
+		case "port":
+			p.port = value
+		case "skipverify":
+			p.skipVerify, err = strconv.ParseBool(value)
+		case "apitoken":
+			p.apiToken = value
+		case "timeout":
+			p.timeout, err = time.ParseDuration(value)
+		case "trust":
+			p.trust = value
+		default:
+			err = fmt.Errorf("unknown option %v", key)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%v:%v: %v", filename, n, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return profiles, nil
+}
+
+// applyProfile sets the options of the selected profile that were not
+// provided on the command line.  The profile is selected with -profile,
+// otherwise the profile option of the config file selects the default
+// profile, if any.
+func applyProfile() error {
+	name := *profileName
+	if name == "" {
+		cfg, err := loadConfig()
+		if err != nil {
+			return fmt.Errorf("attempt to load default profile "+
+				"from configuration file failed: %v", err)
+		}
+		name = cfg.Profile
+	}
+	if name == "" {
+		return nil
+	}
+
+	profiles, err := readProfiles(defaultConfigFile)
+	if err != nil {
+		return err
+	}
+	p, ok := profiles[name]
+	if !ok {
+		return fmt.Errorf("profile %v not found in %v", name,
+			defaultConfigFile)
+	}
+
+	set := make(map[string]struct{})
+	flag.Visit(func(f *flag.Flag) {
+		set[f.Name] = struct{}{}
+	})
+	isSet := func(name string) bool {
+		_, ok := set[name]
+		return ok
+	}
+	if p.host != "" && !isSet("h") {
+		*host = p.host
+	}
+	if p.port != "" && !isSet("p") {
+		*port = p.port
+	}
+	if p.skipVerify && !isSet("skipverify") {
+		*skipVerify = true
+	}
+	if p.apiToken != "" && !isSet("apitoken") {
+		*apiToken = p.apiToken
+	}
+	if p.timeout != 0 && !isSet("timeout") {
+		*timeout = p.timeout
+	}
+	if p.trust != "" && !isSet("trust") {
+		*trustPath = p.trust
+	}
+
+	return nil
+}
@@ -0,0 +1,378 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	v2 "github.com/decred/dcrtime/api/v2"
+	"github.com/decred/dcrtime/client"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+const (
+	// statusCommand is the argument that refreshes the pending receipts.
+	statusCommand = "status"
+
+	// defaultReceiptsDirname is the name of the receipt database in the
+	// home directory when no -receipts is provided.
+	defaultReceiptsDirname = "receipts"
+
+	// statusChunk is the number of digests that are verified per request
+	// when receipts are refreshed.  It is the default maxdigests of
+	// dcrtimed.
+	statusChunk = 20
+)
+
+// receipt records a digest that was submitted to a server and its anchor
+// status as of the last refresh.  Receipts are keyed by server and digest so
+// that a digest submitted to several servers has a receipt for every one of
+// them.
+type receipt struct {
+	Digest          string `json:"digest"`
+	Name            string `json:"name,omitempty"` // File name, if any
+	Server          string `json:"server"`
+	Label           string `json:"label,omitempty"`
+	AccessKey       string `json:"accesskey,omitempty"` // Private digests only
+	Status          string `json:"status"`
+	ServerTimestamp int64  `json:"servertimestamp"` // Zero if it already existed
+	Submitted       int64  `json:"submitted"`       // Time of submission
+	Refreshed       int64  `json:"refreshed,omitempty"`
+	ChainTimestamp  int64  `json:"chaintimestamp,omitempty"`
+	MerkleRoot      string `json:"merkleroot,omitempty"`
+	Transaction     string `json:"transaction,omitempty"`
+
+	// Signed pending receipt of the server, if requested.
+	PendingReceipt *v2.PendingReceipt `json:"pendingreceipt,omitempty"`
+}
+
+// receiptKey returns the database key of the receipt of the provided digest
+// on the provided server.
+func receiptKey(server, digest string) []byte {
+	return []byte(server + " " + digest)
+}
+
+// isStatusCommand returns true if dcrtime was invoked as dcrtime status.
+func isStatusCommand() bool {
+	return flag.Arg(0) == statusCommand && !isFile(statusCommand)
+}
+
+// receiptsDir returns the directory of the receipt database.
+func receiptsDir() string {
+	if *receiptsPath != "" {
+		return cleanAndExpandPath(*receiptsPath)
+	}
+	return filepath.Join(defaultHomeDir, defaultReceiptsDirname)
+}
+
+// openReceipts opens the receipt database, it is created if it does not
+// exist yet.
+func openReceipts() (*leveldb.DB, error) {
+	dir := receiptsDir()
+	if err := os.MkdirAll(filepath.Dir(dir), 0700); err != nil {
+		return nil, err
+	}
+	db, err := leveldb.OpenFile(dir, nil)
+	if err != nil {
+		return nil, fmt.Errorf("open receipts %v: %v", dir, err)
+	}
+	return db, nil
+}
+
+// newReceipts returns the receipts of digests that were submitted to the
+// selected host in a single request.  Ok is set for the digests the server
+// accepted, names maps digests to file names and access keys are in the order
+// of the digests, if any.
+func newReceipts(digests []string, ok []bool, serverTimestamp int64, names map[string]string, accessKeys []string) []receipt {
+	now := time.Now().Unix()
+	rs := make([]receipt, 0, len(digests))
+	for k, d := range digests {
+		r := receipt{
+			Digest:    d,
+			Name:      names[d],
+			Server:    *host,
+			Label:     *label,
+			Status:    statusPending,
+			Submitted: now,
+		}
+		if ok[k] {
+			r.ServerTimestamp = serverTimestamp
+		}
+		if k < len(accessKeys) {
+			r.AccessKey = accessKeys[k]
+		}
+		rs = append(rs, r)
+	}
+	return rs
+}
+
+// addPendingReceipts adds the signed pending receipts of the server to the
+// receipts of their digests.
+func addPendingReceipts(rs []receipt, prs []v2.PendingReceipt) {
+	byDigest := make(map[string]*v2.PendingReceipt, len(prs))
+	for k := range prs {
+		byDigest[strings.ToLower(prs[k].Digest)] = &prs[k]
+	}
+	for k := range rs {
+		rs[k].PendingReceipt = byDigest[strings.ToLower(rs[k].Digest)]
+	}
+}
+
+// saveReceipts records the provided receipts.  Receipts of digests that
+// already existed do not replace the receipt of their original submission.
+// A receipt that can not be recorded does not undo the submission, so failures
+// are only reported.
+func saveReceipts(rs []receipt) {
+	if *noReceipts || *trial || len(rs) == 0 {
+		return
+	}
+
+	err := func() error {
+		db, err := openReceipts()
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		batch := new(leveldb.Batch)
+		for _, r := range rs {
+			key := receiptKey(r.Server, r.Digest)
+			if r.ServerTimestamp == 0 {
+				ok, err := db.Has(key, nil)
+				if err != nil {
+					return err
+				}
+				if ok {
+					continue
+				}
+			}
+			payload, err := json.Marshal(r)
+			if err != nil {
+				return err
+			}
+			batch.Put(key, payload)
+		}
+		return db.Write(batch, nil)
+	}()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warning: could not record receipts: "+
+			"%v\n", err)
+	}
+}
+
+// pendingReceipts returns the receipts that were not anchored yet grouped by
+// server.
+func pendingReceipts(db *leveldb.DB) (map[string][]receipt, error) {
+	pending := make(map[string][]receipt) // [server]receipts
+	iter := db.NewIterator(nil, nil)
+	defer iter.Release()
+	for iter.Next() {
+		var r receipt
+		if err := json.Unmarshal(iter.Value(), &r); err != nil {
+			return nil, fmt.Errorf("invalid receipt %s: %v",
+				iter.Key(), err)
+		}
+		if r.Status != statusPending {
+			continue
+		}
+		pending[r.Server] = append(pending[r.Server], r)
+	}
+	return pending, iter.Error()
+}
+
+// verifyReceipts verifies the digests of the provided receipts, which were all
+// submitted to the same server, and returns the verify results in the same
+// order.
+func verifyReceipts(ctx context.Context, c *client.Client, rs []receipt) ([]v2.VerifyDigest, error) {
+	ver := v2.VerifyBatch{
+		ID:      dcrtimeClientID,
+		Digests: make([]string, 0, len(rs)),
+	}
+	for _, r := range rs {
+		ver.Digests = append(ver.Digests, r.Digest)
+		if r.AccessKey != "" {
+			ver.AccessKeys = append(ver.AccessKeys, r.AccessKey)
+		}
+	}
+
+	if *debug {
+		fmt.Println(ver)
+		fmt.Println(c.Host() + v2.VerifyBatchRoute)
+	}
+
+	vbr, err := c.Verify(ctx, ver)
+	if err != nil {
+		return nil, err
+	}
+	results := make(map[string]v2.VerifyDigest, len(vbr.Digests))
+	for _, d := range vbr.Digests {
+		results[d.Digest] = d
+	}
+	vds := make([]v2.VerifyDigest, 0, len(rs))
+	for _, r := range rs {
+		d, ok := results[r.Digest]
+		if !ok {
+			return nil, fmt.Errorf("no result for digest %v",
+				r.Digest)
+		}
+		vds = append(vds, d)
+	}
+	return vds, nil
+}
+
+// printReceipt prints a refreshed receipt.  Receipts that are still pending
+// are only printed if verbose output was requested.  Machine-readable output
+// prints all receipts.
+func printReceipt(r receipt, result string) {
+	if isNDJSON() {
+		printRecord(r)
+		return
+	}
+
+	switch r.Status {
+	case statusAnchored:
+		fmt.Printf("%v %v %v\n", r.Digest, colorize(colorGreen, "Anchored"),
+			r.Name)
+		if *verbose {
+			fmt.Printf("  %-16v: %v\n", "Server", r.Server)
+			fmt.Printf("  %-16v: %v\n", "Chain Timestamp",
+				formatTime(r.ChainTimestamp))
+			fmt.Printf("  %-16v: %v\n", "Merkle Root", r.MerkleRoot)
+			fmt.Printf("  %-16v: %v\n", "TxID", r.Transaction)
+		}
+	case statusPending:
+		if *verbose {
+			fmt.Printf("%v %v %v\n", r.Digest,
+				colorize(colorYellow, result), r.Name)
+		}
+	default:
+		fmt.Printf("%v %v %v\n", r.Digest, colorize(colorRed, result),
+			r.Name)
+	}
+}
+
+// refreshReceipts verifies the digests of all pending receipts in bulk and
+// records their anchor status.  Servers that can not be reached are skipped
+// and their receipts stay pending.
+func refreshReceipts() error {
+	db, err := openReceipts()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	pending, err := pendingReceipts(db)
+	if err != nil {
+		return err
+	}
+	servers := make([]string, 0, len(pending))
+	for server := range pending {
+		servers = append(servers, server)
+	}
+	sort.Strings(servers)
+
+	// If this is a trial run return.
+	if *trial {
+		for _, server := range servers {
+			fmt.Printf("%v: %v pending\n", server, len(pending[server]))
+		}
+		return nil
+	}
+
+	var (
+		summary   verifySummary
+		refreshed []receipt
+		failed    int
+	)
+	ctx := context.Background()
+	for _, server := range servers {
+		rs := pending[server]
+		cfg := client.Config{
+			Host:       server,
+			SkipVerify: *skipVerify,
+			Timeout:    *timeout,
+		}
+		if server == *host {
+			// The trust bundle pins the selected server only.
+			cfg.Trust = trustBundle
+		}
+		c, err := client.New(cfg)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v: %v\n", server, err)
+			failed += len(rs)
+			continue
+		}
+		for offset := 0; offset < len(rs); offset += statusChunk {
+			end := offset + statusChunk
+			if end > len(rs) {
+				end = len(rs)
+			}
+			chunk := rs[offset:end]
+			vds, err := verifyReceipts(ctx, c, chunk)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%v: %v\n", server, err)
+				failed += len(rs) - offset
+				break
+			}
+
+			now := time.Now().Unix()
+			batch := new(leveldb.Batch)
+			for k, d := range vds {
+				r := chunk[k]
+				status, result := checkDigest(d)
+				summary.add(status, d.ChainInformation.ChainTimestamp)
+				r.Status = status
+				r.Refreshed = now
+				if r.ServerTimestamp == 0 {
+					r.ServerTimestamp = d.ServerTimestamp
+				}
+				if status == statusAnchored {
+					r.ChainTimestamp = d.ChainInformation.ChainTimestamp
+					r.MerkleRoot = d.ChainInformation.MerkleRoot
+					r.Transaction = d.ChainInformation.Transaction
+				}
+				payload, err := json.Marshal(r)
+				if err != nil {
+					return err
+				}
+				batch.Put(receiptKey(r.Server, r.Digest), payload)
+				refreshed = append(refreshed, r)
+				if !*printJSON {
+					printReceipt(r, result)
+				}
+			}
+			if err := db.Write(batch, nil); err != nil {
+				return err
+			}
+		}
+	}
+
+	switch {
+	case *printJSON:
+		if refreshed == nil {
+			refreshed = []receipt{}
+		}
+		b, err := json.Marshal(refreshed)
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(b))
+	case !isNDJSON():
+		summary.print()
+	}
+	if failed != 0 {
+		return fmt.Errorf("%v receipts could not be refreshed", failed)
+	}
+
+	return nil
+}
@@ -0,0 +1,273 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	v2 "github.com/decred/dcrtime/api/v2"
+)
+
+const (
+	// sessionRetries is the number of times a failed session request is
+	// retried before the upload is abandoned.  The session ID is printed
+	// so that the upload can be resumed with -sessionid.
+	sessionRetries = 5
+
+	// sessionRetryDelay is the delay before the first retry, it doubles
+	// with every retry.
+	sessionRetryDelay = time.Second
+)
+
+// sessionError is returned for session requests the server refused.
+type sessionError struct {
+	status int
+	err    string
+}
+
+func (e sessionError) Error() string {
+	return fmt.Sprintf("%v %v: %v", e.status, http.StatusText(e.status),
+		e.err)
+}
+
+// sessionPost posts a session request to the provided route and decodes the
+// reply.  The signature of session close replies is checked against the trust
+// bundle.
+func sessionPost(c *http.Client, route string, request interface{}) (*v2.SessionReply, error) {
+	b, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	signed := route == v2.SessionCloseRoute
+	route = *host + route
+	if *apiToken != "" {
+		route += "?apitoken=" + url.QueryEscape(*apiToken)
+	}
+
+	if *debug {
+		fmt.Println(string(b))
+		fmt.Println(route)
+	}
+
+	r, err := c.Post(route, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Body.Close()
+
+	if r.StatusCode != http.StatusOK {
+		e, err := getError(r.Body)
+		if err != nil {
+			e = r.Status
+		}
+		return nil, sessionError{status: r.StatusCode, err: e}
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	if signed {
+		if err := checkSignature(r, body); err != nil {
+			return nil, err
+		}
+	}
+
+	var reply v2.SessionReply
+	if err := json.Unmarshal(body, &reply); err != nil {
+		return nil, fmt.Errorf("could not decode SessionReply: %v", err)
+	}
+	return &reply, nil
+}
+
+// retrySession calls f until it succeeds, the server refuses the request, the
+// reply is not trusted or the retries are exhausted.  Only network errors and
+// unavailable servers are retried.
+func retrySession(f func() error) error {
+	delay := sessionRetryDelay
+	for i := 0; ; i++ {
+		err := f()
+		if err == nil {
+			return nil
+		}
+		if e, ok := err.(sessionError); ok &&
+			e.status != http.StatusServiceUnavailable &&
+			e.status != http.StatusTooManyRequests {
+			return err
+		}
+		if _, ok := err.(untrustedError); ok {
+			return err
+		}
+		if i == sessionRetries {
+			return err
+		}
+		if *verbose {
+			fmt.Printf("Retrying in %v: %v\n", delay, err)
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// sessionStatus returns the status of the session with the provided ID.
+func sessionStatus(c *http.Client, id string) (*v2.SessionReply, error) {
+	var reply *v2.SessionReply
+	err := retrySession(func() error {
+		var err error
+		reply, err = sessionPost(c, v2.SessionStatusRoute,
+			v2.SessionStatus{
+				ID:        dcrtimeClientID,
+				SessionID: id,
+			})
+		return err
+	})
+	return reply, err
+}
+
+// uploadV2Session timestamps the digests through a submission session.  The
+// digests are appended in chunks of -sessionchunk digests.  When an append
+// fails the staged digest count is requested from the server and the upload
+// resumes from there, so that a large upload survives dropped connections.
+// Providing -sessionid resumes a session that was opened by a previous run
+// with the same digests.  The session reply does not tell accepted and existing
+// digests apart, their receipts get the collection timestamp when they are
+// refreshed.
+func uploadV2Session(digests []string, exists map[string]string) error {
+	// If this is a trial run return.
+	if *trial {
+		return nil
+	}
+
+	c := newClient(*skipVerify)
+
+	// Open a new session or resume the provided one.
+	var (
+		s   *v2.SessionReply
+		err error
+	)
+	if *sessionID != "" {
+		s, err = sessionStatus(c, *sessionID)
+	} else {
+		err = retrySession(func() error {
+			var err error
+			s, err = sessionPost(c, v2.SessionOpenRoute,
+				v2.SessionOpen{
+					ID:        dcrtimeClientID,
+					Label:     *label,
+					Algorithm: *algorithm,
+				})
+			return err
+		})
+	}
+	if err != nil {
+		return err
+	}
+	if !isNDJSON() {
+		fmt.Printf("Session: %v\n", s.SessionID)
+	}
+	if s.DigestCount > int64(len(digests)) {
+		return fmt.Errorf("session %v staged %v digests, only %v "+
+			"were provided", s.SessionID, s.DigestCount, len(digests))
+	}
+
+	// Append the digests that were not staged yet.
+	id := s.SessionID
+	for offset := s.DigestCount; offset < int64(len(digests)) && !s.Closed; {
+		end := offset + int64(*sessionChunk)
+		if end > int64(len(digests)) {
+			end = int64(len(digests))
+		}
+		var reply *v2.SessionReply
+		err := retrySession(func() error {
+			var err error
+			reply, err = sessionPost(c, v2.SessionAppendRoute,
+				v2.SessionAppend{
+					ID:        dcrtimeClientID,
+					SessionID: id,
+					Offset:    offset,
+					Digests:   digests[offset:end],
+				})
+			return err
+		})
+		if err != nil {
+			// The append may have been applied even though its
+			// reply was lost, resume from the staged digests.
+			reply, serr := sessionStatus(c, id)
+			if serr != nil || reply.DigestCount == offset {
+				return fmt.Errorf("%v, resume with -sessionid %v",
+					err, id)
+			}
+			s = reply
+			offset = s.DigestCount
+			continue
+		}
+		s = reply
+		offset = s.DigestCount
+		if *verbose {
+			fmt.Printf("Staged %v/%v digests\n", offset, len(digests))
+		}
+	}
+
+	// Close the session, a lost reply is recovered from its status.
+	if !s.Closed {
+		var reply *v2.SessionReply
+		err := retrySession(func() error {
+			var err error
+			reply, err = sessionPost(c, v2.SessionCloseRoute,
+				v2.SessionClose{
+					ID:        dcrtimeClientID,
+					SessionID: id,
+				})
+			return err
+		})
+		if _, ok := err.(untrustedError); ok {
+			return err
+		}
+		if err != nil {
+			var serr error
+			reply, serr = sessionStatus(c, id)
+			if serr != nil || !reply.Closed {
+				return fmt.Errorf("%v, resume with -sessionid %v",
+					err, id)
+			}
+		}
+		s = reply
+	}
+	saveReceipts(newReceipts(digests, make([]bool, len(digests)),
+		s.ServerTimestamp, exists, nil))
+
+	if *printJSON {
+		b, err := json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(b))
+		return nil
+	}
+	if isNDJSON() {
+		printRecord(s)
+		return nil
+	}
+
+	fmt.Printf("Accepted %v, existing %v\n", s.AcceptedCount,
+		s.ExistingCount)
+	if s.ServerTimestamp != 0 {
+		fmt.Printf("Collection timestamp: %v\n",
+			formatTime(s.ServerTimestamp))
+	}
+	if s.Maintenance {
+		fmt.Println("Server is in maintenance, digests are anchored " +
+			"once it ends")
+	}
+
+	return nil
+}
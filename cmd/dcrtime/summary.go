@@ -0,0 +1,111 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// ANSI escape sequences of the colors used to highlight verify results.
+const (
+	colorRed    = "\x1b[31m"
+	colorGreen  = "\x1b[32m"
+	colorYellow = "\x1b[33m"
+	colorReset  = "\x1b[0m"
+)
+
+// useColor is set when results are highlighted.  Colors are only used when
+// stdout is a terminal, unless disabled by the nocolor flag or the NO_COLOR
+// environment variable.
+var useColor bool
+
+// colorEnabled returns true if output written to stdout should be colored.
+func colorEnabled() bool {
+	if *noColor || *printJSON || isNDJSON() {
+		return false
+	}
+	if _, ok := os.LookupEnv("NO_COLOR"); ok {
+		return false
+	}
+	fi, err := os.Stdout.Stat()
+	if err != nil {
+		return false
+	}
+	return fi.Mode()&os.ModeCharDevice != 0
+}
+
+// colorize wraps s in the provided color if colors are enabled.
+func colorize(color, s string) string {
+	if !useColor {
+		return s
+	}
+	return color + s + colorReset
+}
+
+// verifySummary tallies the results of verified digests and timestamps.
+type verifySummary struct {
+	anchored int
+	pending  int
+	notFound int
+	failed   int
+
+	// earliest and latest are the chain timestamps of the oldest and the
+	// newest anchor that were seen.
+	earliest int64
+	latest   int64
+}
+
+// addAnchored counts a result that was anchored at the provided chain
+// timestamp.
+func (s *verifySummary) addAnchored(chainTimestamp int64) {
+	s.anchored++
+	if s.earliest == 0 || chainTimestamp < s.earliest {
+		s.earliest = chainTimestamp
+	}
+	if chainTimestamp > s.latest {
+		s.latest = chainTimestamp
+	}
+}
+
+// add counts a result with the provided status.  The chain timestamp is only
+// used for anchored results.
+func (s *verifySummary) add(status string, chainTimestamp int64) {
+	switch status {
+	case statusAnchored:
+		s.addAnchored(chainTimestamp)
+	case statusPending:
+		s.pending++
+	case statusNotFound:
+		s.notFound++
+	default:
+		s.failed++
+	}
+}
+
+// print writes the summary table to stdout.
+func (s *verifySummary) print() {
+	total := s.anchored + s.pending + s.notFound + s.failed
+	if total == 0 || isNDJSON() {
+		return
+	}
+
+	fmt.Printf("%-15v: %v\n", "Verified", total)
+	fmt.Printf("%-15v: %v\n", "Anchored",
+		colorize(colorGreen, fmt.Sprint(s.anchored)))
+	fmt.Printf("%-15v: %v\n", "Pending",
+		colorize(colorYellow, fmt.Sprint(s.pending)))
+	fmt.Printf("%-15v: %v\n", "Not found",
+		colorize(colorRed, fmt.Sprint(s.notFound)))
+	if s.failed != 0 {
+		fmt.Printf("%-15v: %v\n", "Failed",
+			colorize(colorRed, fmt.Sprint(s.failed)))
+	}
+	if s.anchored == 0 {
+		return
+	}
+	fmt.Printf("%-15v: %v\n", "Earliest anchor", formatTime(s.earliest))
+	fmt.Printf("%-15v: %v\n", "Latest anchor", formatTime(s.latest))
+}
@@ -0,0 +1,113 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	v2 "github.com/decred/dcrtime/api/v2"
+)
+
+// trustBundle is the trust bundle of the -trust flag, nil if no bundle was
+// provided.
+var trustBundle *v2.TrustBundle
+
+// untrustedError is returned for replies that are not signed by a key of the
+// trust bundle.
+type untrustedError struct {
+	err error
+}
+
+func (e untrustedError) Error() string {
+	return e.err.Error()
+}
+
+// loadTrustBundle reads the trust bundle of the -trust flag, if any.
+func loadTrustBundle() error {
+	if *trustPath == "" {
+		return nil
+	}
+	b, err := os.ReadFile(cleanAndExpandPath(*trustPath))
+	if err != nil {
+		return err
+	}
+	trustBundle, err = v2.DecodeTrustBundle(b)
+	if err != nil {
+		return fmt.Errorf("%v: %v", *trustPath, err)
+	}
+	return nil
+}
+
+// checkIdentity returns an error if a trust bundle was provided and the
+// identity key of the server is not one of its keys.
+func checkIdentity() error {
+	if trustBundle == nil {
+		return nil
+	}
+
+	c := newClient(*skipVerify)
+	route := *host + v2.IdentityRoute
+	if *debug {
+		fmt.Println(route)
+	}
+	response, err := c.Get(route)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		e, err := getError(response.Body)
+		if err != nil {
+			return fmt.Errorf("retrieve identity failed: %v",
+				response.Status)
+		}
+		return fmt.Errorf("retrieve identity failed - %v: %v",
+			response.Status, e)
+	}
+
+	var ir v2.IdentityReply
+	if err := json.NewDecoder(response.Body).Decode(&ir); err != nil {
+		return fmt.Errorf("could not decode IdentityReply: %v", err)
+	}
+	if err := trustBundle.VerifyIdentity(ir, time.Now().Unix()); err != nil {
+		return fmt.Errorf("%v: %v", *host, err)
+	}
+	return nil
+}
+
+// checkSignature returns an error if a trust bundle was provided and the
+// provided reply body is not signed by one of its keys.
+func checkSignature(r *http.Response, body []byte) error {
+	if trustBundle == nil {
+		return nil
+	}
+	err := trustBundle.VerifyReply(r.Header.Get(v2.SignatureHeader), body,
+		time.Now().Unix())
+	if err != nil {
+		return untrustedError{
+			err: fmt.Errorf("%v: %v", r.Request.URL.Path, err),
+		}
+	}
+	return nil
+}
+
+// checkPendingReceipts returns an error if a trust bundle was provided and
+// any of the pending receipts is not signed by one of its keys.
+func checkPendingReceipts(prs []v2.PendingReceipt) error {
+	if trustBundle == nil {
+		return nil
+	}
+	for _, pr := range prs {
+		if err := trustBundle.VerifyPendingReceipt(pr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,304 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"syscall"
+	"time"
+
+	v2 "github.com/decred/dcrtime/api/v2"
+	"github.com/decred/dcrtime/util"
+	"github.com/fsnotify/fsnotify"
+)
+
+const (
+	// watchCommand is the argument that runs dcrtime in watch mode.
+	watchCommand = "watch"
+
+	// defaultLedgerFilename is the name of the ledger in the watched
+	// directory when no -ledger is provided.
+	defaultLedgerFilename = ".dcrtime-ledger"
+)
+
+// ledgerEntry is the receipt of a single submitted digest. The ledger is a
+// stream of JSON encoded entries that is only ever appended to, the last
+// entry of a path is its current digest.
+type ledgerEntry struct {
+	Path            string `json:"path"`
+	Digest          string `json:"digest"`
+	ServerTimestamp int64  `json:"servertimestamp"` // Zero if it already existed
+	Timestamp       int64  `json:"timestamp"`       // Time of submission
+}
+
+// isWatchCommand returns true if dcrtime was invoked as dcrtime watch <dir>.
+func isWatchCommand() bool {
+	return flag.Arg(0) == watchCommand && !isFile(watchCommand)
+}
+
+// loadLedger returns the last recorded digest of every path in the ledger at
+// the provided path. An empty map is returned if the ledger does not exist
+// yet.
+func loadLedger(filename string) (map[string]string, error) {
+	digests := make(map[string]string) // [path]digest
+	f, err := os.Open(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return digests, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	decoder := json.NewDecoder(f)
+	for {
+		var e ledgerEntry
+		err := decoder.Decode(&e)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("invalid ledger %v: %v", filename,
+				err)
+		}
+		digests[e.Path] = e.Digest
+	}
+
+	return digests, nil
+}
+
+// watchTree watches all directories of the tree rooted at dir and queues all
+// regular files in it. The ledger, skip, is never queued.
+func watchTree(watcher *fsnotify.Watcher, dir, skip string, dirty map[string]struct{}) error {
+	return filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			return watcher.Add(path)
+		}
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return err
+		}
+		if abs != skip {
+			dirty[filepath.ToSlash(filepath.Clean(path))] = struct{}{}
+		}
+		return nil
+	})
+}
+
+// submitWatched hashes the queued files and timestamps the ones whose digest
+// differs from the one last recorded in the ledger. Receipts are appended to
+// the ledger. Files stay queued when the digests could not be submitted so
+// that they are retried.
+func submitWatched(dirty map[string]struct{}, digests map[string]string, ledger *os.File) error {
+	if len(dirty) == 0 {
+		return nil
+	}
+
+	paths := make([]string, 0, len(dirty))
+	for path := range dirty {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	var (
+		changed = make(map[string]string, len(paths)) // [path]digest
+		upload  []string
+		pending = make(map[string]string) // [digest]path
+	)
+	for _, path := range paths {
+		d, err := util.DigestFile(path)
+		if err != nil {
+			// The file was removed or can't be read, it is
+			// queued again on the next change.
+			if !errors.Is(err, os.ErrNotExist) {
+				fmt.Fprintf(os.Stderr, "warning: %v\n", err)
+			}
+			delete(dirty, path)
+			continue
+		}
+		if digests[path] == d {
+			delete(dirty, path)
+			if *verbose {
+				fmt.Printf("%v Unchanged %v\n", d, path)
+			}
+			continue
+		}
+		changed[path] = d
+
+		if _, ok := pending[d]; !ok {
+			pending[d] = path
+			upload = append(upload, d)
+		}
+		if *verbose {
+			fmt.Printf("%v Upload %v\n", d, path)
+		}
+	}
+	if len(upload) == 0 {
+		return nil
+	}
+
+	if *trial {
+		for path, d := range changed {
+			digests[path] = d
+			delete(dirty, path)
+		}
+		return nil
+	}
+
+	reply, err := submitManifestV2(upload, pending)
+	if err != nil {
+		return err
+	}
+	results := make(map[string]v2.ResultT, len(reply.Digests))
+	for k, d := range reply.Digests {
+		results[d] = reply.Results[k]
+	}
+
+	now := time.Now().Unix()
+	encoder := json.NewEncoder(ledger)
+	for _, path := range paths {
+		d, ok := changed[path]
+		if !ok {
+			continue
+		}
+		e := ledgerEntry{
+			Path:      path,
+			Digest:    d,
+			Timestamp: now,
+		}
+		if results[d] == v2.ResultOK {
+			e.ServerTimestamp = reply.ServerTimestamp
+		}
+		printUpload(d, path, results[d], reply.ServerTimestamp)
+		if err := encoder.Encode(e); err != nil {
+			return err
+		}
+		digests[path] = d
+		delete(dirty, path)
+	}
+	if *verbose {
+		fmt.Printf("Collection timestamp: %v\n",
+			formatTime(reply.ServerTimestamp))
+	}
+
+	return ledger.Sync()
+}
+
+// watchDirectory continuously timestamps the files of the tree rooted at dir.
+// All files that are new or changed since the last receipt in the ledger are
+// submitted on startup, afterwards files that are created or written to are
+// submitted in a single batch every -watchinterval. Watching stops on an
+// interrupt after the queued files were submitted.
+func watchDirectory(dir string) error {
+	if !isDir(dir) {
+		return fmt.Errorf("%v is not a directory", dir)
+	}
+	if *watchInterval <= 0 {
+		return fmt.Errorf("-watchinterval must be positive")
+	}
+
+	ledgerFile := *ledgerPath
+	if ledgerFile == "" {
+		ledgerFile = filepath.Join(dir, defaultLedgerFilename)
+	}
+	skip, err := filepath.Abs(ledgerFile)
+	if err != nil {
+		return err
+	}
+	digests, err := loadLedger(ledgerFile)
+	if err != nil {
+		return err
+	}
+
+	var ledger *os.File
+	if !*trial {
+		ledger, err = os.OpenFile(ledgerFile,
+			os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+		defer ledger.Close()
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	dirty := make(map[string]struct{}) // [path]
+	if err := watchTree(watcher, dir, skip, dirty); err != nil {
+		return err
+	}
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(interrupt)
+
+	if !isNDJSON() {
+		fmt.Printf("Watching %v, ledger %v\n", dir, ledgerFile)
+	}
+	if err := submitWatched(dirty, digests, ledger); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+	}
+
+	ticker := time.NewTicker(*watchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case ev, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if ev.Op&(fsnotify.Create|fsnotify.Write) == 0 {
+				continue
+			}
+			if ev.Op&fsnotify.Create != 0 && isDir(ev.Name) {
+				// New directories are not watched yet and
+				// may already contain files.
+				err := watchTree(watcher, ev.Name, skip, dirty)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "warning: %v\n",
+						err)
+				}
+				continue
+			}
+			abs, err := filepath.Abs(ev.Name)
+			if err != nil || abs == skip {
+				continue
+			}
+			dirty[filepath.ToSlash(filepath.Clean(ev.Name))] = struct{}{}
+
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			fmt.Fprintf(os.Stderr, "warning: %v\n", err)
+
+		case <-ticker.C:
+			// Submission errors are most likely transient, the
+			// files stay queued and are retried on the next tick.
+			err := submitWatched(dirty, digests, ledger)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%v\n", err)
+			}
+
+		case <-interrupt:
+			return submitWatched(dirty, digests, ledger)
+		}
+	}
+}
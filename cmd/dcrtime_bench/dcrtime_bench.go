@@ -0,0 +1,474 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+// dcrtime_bench floods a dcrtimed server with timestamp and verify requests
+// and reports the latency percentiles and error rates of both, so operators
+// can size their deployments.
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	mrand "math/rand"
+	"os"
+	"os/signal"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	v2 "github.com/decred/dcrtime/api/v2"
+	"github.com/decred/dcrtime/client"
+)
+
+const (
+	// benchID is the client ID of the requests.
+	benchID = "dcrtime_bench"
+
+	// maxPool is the maximum number of timestamped digests that are kept
+	// to be verified.
+	maxPool = 100000
+
+	// progressInterval is the time between progress reports in verbose
+	// mode.
+	progressInterval = 5 * time.Second
+)
+
+var (
+	host       = flag.String("h", "", "Server URL, the mainnet server by default")
+	skipVerify = flag.Bool("skipverify", false, "Skip verifying the server certificate")
+	apiToken   = flag.String("apitoken", "", "API token sent with every request")
+	concurrent = flag.Int("c", 10, "Number of concurrent clients")
+	requests   = flag.Int("n", 1000, "Number of requests, 0 runs until -duration elapses")
+	duration   = flag.Duration("duration", 0, "Maximum duration of the run, 0 runs until -n requests were sent")
+	digests    = flag.Int("digests", 1, "Number of digests per request")
+	verifyMix  = flag.Float64("verify", 0.5, "Fraction of requests that verify digests, between 0 and 1")
+	rate       = flag.Float64("rate", 0, "Maximum requests per second across all clients, 0 is unlimited")
+	timeout    = flag.Duration("timeout", 30*time.Second, "Timeout of a single request")
+	verbose    = flag.Bool("v", false, "Verbose, report progress every 5 seconds")
+)
+
+// op is a kind of request.
+type op int
+
+const (
+	opTimestamp op = iota
+	opVerify
+)
+
+var opNames = []string{"timestamp", "verify"}
+
+// stats are the outcomes of the requests of one kind.
+type stats struct {
+	latencies []time.Duration // Latencies of successful requests
+	errors    map[string]int  // Failed requests by error class
+	digests   int             // Digests of successful requests
+	rejected  int             // Digests that were not accepted or found
+}
+
+// bench runs the requests and collects their outcomes.
+type bench struct {
+	client *client.Client
+
+	sync.Mutex
+	stats [2]stats
+	pool  []string // Timestamped digests, verify requests pick from it
+}
+
+// randomDigests returns n random digests.
+func randomDigests(n int) ([]string, error) {
+	ds := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		var d [32]byte
+		if _, err := rand.Read(d[:]); err != nil {
+			return nil, err
+		}
+		ds = append(ds, hex.EncodeToString(d[:]))
+	}
+	return ds, nil
+}
+
+// errorClass returns the class the provided error is reported under.  Server
+// errors are reported by status code, so that throttling is told apart from
+// failures.
+func errorClass(err error) string {
+	var se client.ServerError
+	switch {
+	case errors.As(err, &se):
+		return fmt.Sprintf("%v", se.StatusCode)
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	default:
+		return "network"
+	}
+}
+
+// verifyDigests returns the digests of a verify request.  They are picked at
+// random from the timestamped digests.  Random digests, that the server does
+// not know, are verified until digests were timestamped.
+func (b *bench) verifyDigests() ([]string, error) {
+	b.Lock()
+	defer b.Unlock()
+
+	if len(b.pool) == 0 {
+		return randomDigests(*digests)
+	}
+	ds := make([]string, 0, *digests)
+	for i := 0; i < *digests; i++ {
+		ds = append(ds, b.pool[mrand.Intn(len(b.pool))])
+	}
+	return ds, nil
+}
+
+// record records the outcome of a request.
+func (b *bench) record(o op, latency time.Duration, ds []string, rejected int, err error) {
+	b.Lock()
+	defer b.Unlock()
+
+	s := &b.stats[o]
+	if err != nil {
+		if s.errors == nil {
+			s.errors = make(map[string]int)
+		}
+		s.errors[errorClass(err)]++
+		return
+	}
+	s.latencies = append(s.latencies, latency)
+	s.digests += len(ds)
+	s.rejected += rejected
+	if o != opTimestamp {
+		return
+	}
+	for _, d := range ds {
+		if len(b.pool) < maxPool {
+			b.pool = append(b.pool, d)
+			continue
+		}
+		b.pool[mrand.Intn(maxPool)] = d
+	}
+}
+
+// timestamp sends a timestamp request of random digests.
+func (b *bench) timestamp(ctx context.Context) error {
+	ds, err := randomDigests(*digests)
+	if err != nil {
+		return err
+	}
+	rctx, cancel := context.WithTimeout(ctx, *timeout)
+	defer cancel()
+
+	start := time.Now()
+	reply, err := b.client.Timestamp(rctx, v2.TimestampBatch{
+		ID:      benchID,
+		Digests: ds,
+	})
+	latency := time.Since(start)
+	if ctx.Err() != nil {
+		// Interrupted or out of time, not a failure of the server.
+		return nil
+	}
+	var rejected int
+	var accepted []string
+	if err == nil {
+		for k, result := range reply.Results {
+			if result != v2.ResultOK {
+				rejected++
+				continue
+			}
+			accepted = append(accepted, reply.Digests[k])
+		}
+	}
+	b.record(opTimestamp, latency, accepted, rejected, err)
+	return nil
+}
+
+// verify sends a verify request.
+func (b *bench) verify(ctx context.Context) error {
+	ds, err := b.verifyDigests()
+	if err != nil {
+		return err
+	}
+	rctx, cancel := context.WithTimeout(ctx, *timeout)
+	defer cancel()
+
+	start := time.Now()
+	reply, err := b.client.Verify(rctx, v2.VerifyBatch{
+		ID:      benchID,
+		Digests: ds,
+	})
+	latency := time.Since(start)
+	if ctx.Err() != nil {
+		return nil
+	}
+	var rejected int
+	if err == nil {
+		for _, vd := range reply.Digests {
+			if vd.Result != v2.ResultOK {
+				rejected++
+			}
+		}
+	}
+	b.record(opVerify, latency, ds, rejected, err)
+	return nil
+}
+
+// worker sends a request for every token it receives until the channel is
+// closed or the context is done.
+func (b *bench) worker(ctx context.Context, tokens <-chan struct{}) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case _, ok := <-tokens:
+			if !ok {
+				return nil
+			}
+		}
+		var err error
+		if mrand.Float64() < *verifyMix {
+			err = b.verify(ctx)
+		} else {
+			err = b.timestamp(ctx)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// dispatch hands out one token per request, at most rate per second if a rate
+// is set, and closes the channel once all requests were handed out.
+func dispatch(ctx context.Context, tokens chan<- struct{}) {
+	defer close(tokens)
+
+	var tick <-chan time.Time
+	if *rate > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) /
+			*rate))
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for i := 0; *requests == 0 || i < *requests; i++ {
+		if tick != nil {
+			select {
+			case <-ctx.Done():
+				return
+			case <-tick:
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case tokens <- struct{}{}:
+		}
+	}
+}
+
+// percentile returns the pth percentile of the provided sorted latencies
+// using the nearest rank method.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	if rank >= len(sorted) {
+		rank = len(sorted) - 1
+	}
+	return sorted[rank]
+}
+
+// ms formats the provided duration in milliseconds.
+func ms(d time.Duration) string {
+	return fmt.Sprintf("%.1fms", float64(d)/float64(time.Millisecond))
+}
+
+// progress prints the number of completed requests.
+func (b *bench) progress(elapsed time.Duration) {
+	b.Lock()
+	defer b.Unlock()
+
+	var done, failed int
+	for _, s := range b.stats {
+		done += len(s.latencies)
+		for _, n := range s.errors {
+			failed += n
+		}
+	}
+	fmt.Printf("%v: %v requests, %v errors\n", elapsed.Round(time.Second),
+		done+failed, failed)
+}
+
+// report prints the throughput, latency percentiles and errors of the run.
+func (b *bench) report(elapsed time.Duration) {
+	b.Lock()
+	defer b.Unlock()
+
+	seconds := elapsed.Seconds()
+	var total, totalDigests int
+	for _, s := range b.stats {
+		total += len(s.latencies)
+		for _, n := range s.errors {
+			total += n
+		}
+		totalDigests += s.digests
+	}
+	fmt.Printf("%v requests in %v, %.1f requests/s, %.1f digests/s\n\n",
+		total, elapsed.Round(time.Millisecond), float64(total)/seconds,
+		float64(totalDigests)/seconds)
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "\trequests\terrors\terror rate\trequests/s\t"+
+		"rejected\tmean\tp50\tp90\tp95\tp99\tmax\t")
+	for o := range b.stats {
+		s := &b.stats[o]
+		var failed int
+		for _, n := range s.errors {
+			failed += n
+		}
+		requests := len(s.latencies) + failed
+		if requests == 0 {
+			continue
+		}
+		sorted := append([]time.Duration(nil), s.latencies...)
+		sort.Slice(sorted, func(i, j int) bool {
+			return sorted[i] < sorted[j]
+		})
+		var sum time.Duration
+		for _, l := range sorted {
+			sum += l
+		}
+		var mean time.Duration
+		if len(sorted) != 0 {
+			mean = sum / time.Duration(len(sorted))
+		}
+		fmt.Fprintf(tw, "%v\t%v\t%v\t%.2f%%\t%.1f\t%v\t%v\t%v\t%v\t%v\t"+
+			"%v\t%v\t\n", opNames[o], requests, failed,
+			100*float64(failed)/float64(requests),
+			float64(requests)/seconds, s.rejected, ms(mean),
+			ms(percentile(sorted, 50)), ms(percentile(sorted, 90)),
+			ms(percentile(sorted, 95)), ms(percentile(sorted, 99)),
+			ms(percentile(sorted, 100)))
+	}
+	tw.Flush()
+	fmt.Println()
+
+	for o, s := range b.stats {
+		classes := make([]string, 0, len(s.errors))
+		for class := range s.errors {
+			classes = append(classes, class)
+		}
+		sort.Strings(classes)
+		for _, class := range classes {
+			fmt.Printf("%v error %v: %v\n", opNames[o], class,
+				s.errors[class])
+		}
+	}
+}
+
+func _main() error {
+	flag.Parse()
+	if *concurrent < 1 {
+		return fmt.Errorf("-c must be positive")
+	}
+	if *digests < 1 {
+		return fmt.Errorf("-digests must be positive")
+	}
+	if *requests < 0 {
+		return fmt.Errorf("-n must not be negative")
+	}
+	if *requests == 0 && *duration == 0 {
+		return fmt.Errorf("-n or -duration is required")
+	}
+	if *verifyMix < 0 || *verifyMix > 1 {
+		return fmt.Errorf("-verify must be between 0 and 1")
+	}
+	if *rate < 0 {
+		return fmt.Errorf("-rate must not be negative")
+	}
+
+	// Requests are not retried, every failure is reported.
+	c, err := client.New(client.Config{
+		Host:       *host,
+		SkipVerify: *skipVerify,
+		APIToken:   *apiToken,
+	})
+	if err != nil {
+		return err
+	}
+	b := &bench{client: c}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if *duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *duration)
+		defer cancel()
+	}
+
+	fmt.Printf("Benchmarking %v: %v clients, %v digests per request, "+
+		"%.0f%% verify\n", c.Host(), *concurrent, *digests,
+		100**verifyMix)
+
+	start := time.Now()
+	tokens := make(chan struct{})
+	go dispatch(ctx, tokens)
+
+	var wg sync.WaitGroup
+	errC := make(chan error, *concurrent)
+	for i := 0; i < *concurrent; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := b.worker(ctx, tokens); err != nil {
+				errC <- err
+				stop()
+			}
+		}()
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	var tick <-chan time.Time
+	if *verbose {
+		ticker := time.NewTicker(progressInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for waiting := true; waiting; {
+		select {
+		case <-done:
+			waiting = false
+		case <-tick:
+			b.progress(time.Since(start))
+		}
+	}
+	elapsed := time.Since(start)
+
+	select {
+	case err := <-errC:
+		return err
+	default:
+	}
+	b.report(elapsed)
+	return nil
+}
+
+func main() {
+	err := _main()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
 	"flag"
@@ -11,12 +12,16 @@ import (
 
 	v1 "github.com/decred/dcrtime/api/v1"
 	v2 "github.com/decred/dcrtime/api/v2"
+	"github.com/decred/dcrtime/checkpoint"
+	"github.com/decred/dcrtime/client"
 	"github.com/decred/dcrtime/merkle"
 	"github.com/decred/dcrtime/util"
 )
 
 var (
 	proof       = flag.String("p", "", "Proof file")
+	anchorChain = flag.String("c", "", "Anchor chain file, verifies the "+
+		"anchor offline")
 	file        = flag.String("f", "", "Original file")
 	dcrdataHost = flag.String("h", "", "dcrdata host")
 	testnet     = flag.Bool("testnet", false, "Use testnet port")
@@ -25,6 +30,63 @@ var (
 		"Inform the API version to be used by the cli (1 or 2)")
 )
 
+// verifyChain verifies offline that the anchor chain stored in the -c file
+// links the transaction that anchors the merkle root to a checkpoint of the
+// network.
+func verifyChain(txid string, root []byte) error {
+	f, err := os.Open(*anchorChain)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var acr v2.AnchorChainReply
+	decoder := json.NewDecoder(f)
+	if err := decoder.Decode(&acr); err != nil {
+		return fmt.Errorf("could not decode AnchorChainReply: %v", err)
+	}
+	chain, err := client.DecodeAnchorChain(&acr)
+	if err != nil {
+		return err
+	}
+	if acr.Transaction != txid {
+		return fmt.Errorf("anchor chain of transaction %v, not %v",
+			acr.Transaction, txid)
+	}
+
+	// Only trust the checkpoints that are known to this tool.
+	network := "mainnet"
+	if *testnet {
+		network = "testnet3"
+	}
+	var cp *checkpoint.Checkpoint
+	for _, v := range checkpoint.Network(network) {
+		if v.Height == acr.CheckpointHeight &&
+			v.Hash.String() == acr.CheckpointHash {
+			v := v
+			cp = &v
+			break
+		}
+	}
+	if cp == nil {
+		return fmt.Errorf("unknown %v checkpoint %v at height %v",
+			network, acr.CheckpointHash, acr.CheckpointHeight)
+	}
+
+	var merkleRoot [sha256.Size]byte
+	copy(merkleRoot[:], root)
+	return chain.Verify(merkleRoot, *cp)
+}
+
+// verifyAnchor verifies that the transaction anchors the merkle root, offline
+// if an anchor chain was provided and against dcrdata otherwise.
+func verifyAnchor(txid string, root []byte) error {
+	if *anchorChain != "" {
+		return verifyChain(txid, root)
+	}
+	return util.VerifyAnchor(*dcrdataHost, txid, root)
+}
+
 func verifyV2(digest string, fProof *os.File) error {
 	var vr v2.VerifyBatchReply
 	decoder := json.NewDecoder(fProof)
@@ -79,9 +141,9 @@ func verifyV2(digest string, fProof *os.File) error {
 		fmt.Printf("%v  Proof  OK\n", digest)
 	}
 
-	// Verify against dcrdata
-	err = util.VerifyAnchor(*dcrdataHost,
-		vr.Digests[found].ChainInformation.Transaction, root[:])
+	// Verify against dcrdata or the anchor chain
+	err = verifyAnchor(vr.Digests[found].ChainInformation.Transaction,
+		root[:])
 	if err != nil {
 		return err
 	}
@@ -147,9 +209,9 @@ func verifyV1(digest string, fProof *os.File) error {
 		fmt.Printf("%v  Proof  OK\n", digest)
 	}
 
-	// Verify against dcrdata
-	err = util.VerifyAnchor(*dcrdataHost,
-		vr.Digests[found].ChainInformation.Transaction, root[:])
+	// Verify against dcrdata or the anchor chain
+	err = verifyAnchor(vr.Digests[found].ChainInformation.Transaction,
+		root[:])
 	if err != nil {
 		return err
 	}
@@ -164,7 +226,7 @@ func verifyV1(digest string, fProof *os.File) error {
 func _main() error {
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "dcrtime_checker [-h {dcrdatahost}|"+
-			"-testnet|-v] -f {file} -p {proof}\n\n")
+			"-testnet|-v] [-c {anchorchain}] -f {file} -p {proof}\n\n")
 		flag.PrintDefaults()
 	}
 	flag.Parse()
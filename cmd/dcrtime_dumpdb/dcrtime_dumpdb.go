@@ -1,19 +1,24 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 
 	"github.com/decred/dcrd/chaincfg/v3"
 	"github.com/decred/dcrd/dcrutil/v4"
+	"github.com/decred/dcrtime/dcrtimed/backend"
 	"github.com/decred/dcrtime/dcrtimed/backend/filesystem"
+	"github.com/decred/dcrtime/dcrtimed/backend/leveldb"
 )
 
 var (
 	defaultHomeDir = dcrutil.AppDataDir("dcrtimed", false)
 
+	backendName = flag.String("backend", filesystem.DriverName, "Backend of the source or destination, filesystem or leveldb")
 	destination = flag.String("destination", "", "Restore destination")
 	dumpJSON    = flag.Bool("json", false, "Dump JSON")
 	restore     = flag.Bool("restore", false, "Restore backend, -destination is required")
@@ -21,6 +26,28 @@ var (
 	testnet     = flag.Bool("testnet", false, "Use testnet port")
 )
 
+// newRestore creates an empty backend of the selected kind in root.
+func newRestore(root string) (backend.Backend, error) {
+	switch *backendName {
+	case filesystem.DriverName:
+		return filesystem.NewRestore(root)
+	case leveldb.DriverName:
+		return leveldb.NewRestore(root)
+	}
+	return nil, fmt.Errorf("unknown backend: %v", *backendName)
+}
+
+// newDump opens the existing backend of the selected kind in root.
+func newDump(root string) (backend.Backend, error) {
+	switch *backendName {
+	case filesystem.DriverName:
+		return filesystem.NewDump(root)
+	case leveldb.DriverName:
+		return leveldb.NewDump(root)
+	}
+	return nil, fmt.Errorf("unknown backend: %v", *backendName)
+}
+
 func _main() error {
 	flag.Parse()
 
@@ -29,13 +56,18 @@ func _main() error {
 			return fmt.Errorf("-destination must be set")
 		}
 
-		fs, err := filesystem.NewRestore(*destination)
+		fs, err := newRestore(*destination)
 		if err != nil {
 			return err
 		}
 		defer fs.Close()
 
-		return fs.Restore(os.Stdin, true, *destination)
+		// Restore reads records until the end of the dump.
+		err = fs.Restore(os.Stdin, true, *destination)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		return err
 	}
 
 	root := *fsRoot
@@ -50,9 +82,10 @@ func _main() error {
 
 	// Dump
 
-	fmt.Printf("=== Root: %v\n", root)
+	// Keep stdout clean for the dump, it is restored from.
+	fmt.Fprintf(os.Stderr, "=== Root: %v\n", root)
 
-	fs, err := filesystem.NewDump(root)
+	fs, err := newDump(root)
 	if err != nil {
 		return err
 	}
@@ -6,16 +6,22 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/decred/dcrd/chaincfg/v3"
 	"github.com/decred/dcrd/dcrutil/v4"
 	"github.com/decred/dcrtime/dcrtimed/backend"
 	"github.com/decred/dcrtime/dcrtimed/backend/filesystem"
+	"github.com/decred/dcrtime/dcrtimed/backend/leveldb"
 )
 
 var (
 	defaultHomeDir = dcrutil.AppDataDir("dcrtimed", false)
 
+	backendName = flag.String("backend", filesystem.DriverName, "Backend of the source directory, filesystem or leveldb")
+	compact     = flag.Bool("compact", false, "Compact old anchored containers into per epoch archives instead of running fsck (requires -fix to modify)")
+	keep        = flag.Duration("keep", 30*24*time.Hour, "Containers younger than this are not compacted")
+	usage       = flag.Bool("usage", false, "Report disk usage by epoch instead of running fsck")
 	file        = flag.String("file", "", "journal of modifications if used (will be written despite -fix)")
 	fix         = flag.Bool("fix", false, "Try to correct correctable failures")
 	dcrdataHost = flag.String("host", "", "dcrdata block explorer")
@@ -52,19 +58,104 @@ func _main() error {
 
 	fmt.Printf("=== Root: %v\n", root)
 
+	options := &backend.FsckOptions{
+		Verbose:     *verbose,
+		PrintHashes: *printHashes,
+		Fix:         *fix,
+		URL:         *dcrdataHost,
+		File:        *file,
+	}
+	switch *backendName {
+	case filesystem.DriverName:
+	case leveldb.DriverName:
+		// The database is compacted by leveldb itself.
+		if *usage || *compact {
+			return fmt.Errorf("-usage and -compact require the " +
+				"filesystem backend")
+		}
+		l, err := leveldb.NewDump(root)
+		if err != nil {
+			return err
+		}
+		defer l.Close()
+		return l.Fsck(options)
+	default:
+		return fmt.Errorf("unknown backend: %v", *backendName)
+	}
+
 	fs, err := filesystem.NewDump(root)
 	if err != nil {
 		return err
 	}
 	defer fs.Close()
 
-	return fs.Fsck(&backend.FsckOptions{
-		Verbose:     *verbose,
-		PrintHashes: *printHashes,
-		Fix:         *fix,
-		URL:         *dcrdataHost,
-		File:        *file,
+	switch {
+	case *usage:
+		return diskUsage(fs)
+	case *compact:
+		return compactContainers(fs)
+	}
+
+	return fs.Fsck(options)
+}
+
+// diskUsage prints the disk usage of the filesystem backend by epoch.
+func diskUsage(fs *filesystem.FileSystem) error {
+	eus, err := fs.DiskUsage()
+	if err != nil {
+		return err
+	}
+
+	var containers, archived int
+	var size int64
+	fmt.Printf("%-8v %10v %10v %14v\n", "Epoch", "Containers", "Archived",
+		"Bytes")
+	for _, eu := range eus {
+		fmt.Printf("%-8v %10v %10v %14v\n", eu.Epoch, eu.Containers,
+			eu.Archived, eu.Size)
+		containers += eu.Containers
+		archived += eu.Archived
+		size += eu.Size
+	}
+	fmt.Printf("%-8v %10v %10v %14v\n", "Total", containers, archived,
+		size)
+
+	return nil
+}
+
+// compactContainers compacts the containers that are older than -keep and
+// prunes orphaned files.  Without -fix it only reports what it would do.
+func compactContainers(fs *filesystem.FileSystem) error {
+	before := time.Now().Add(-*keep)
+	fmt.Printf("=== Compacting containers before %v\n",
+		before.Format(time.UnixDate))
+
+	cr, err := fs.Compact(&filesystem.CompactOptions{
+		Before:  before,
+		Fix:     *fix,
+		Verbose: *verbose,
 	})
+	if err != nil {
+		return err
+	}
+
+	for _, path := range cr.Pruned {
+		fmt.Printf("Pruned         : %v\n", path)
+	}
+	if *verbose {
+		for _, ts := range cr.Skipped {
+			fmt.Printf("Not anchored   : %v\n",
+				time.Unix(ts, 0).UTC().Format(time.UnixDate))
+		}
+	}
+	action := "would be compacted"
+	if *fix {
+		action = "compacted"
+	}
+	fmt.Printf("=== Containers %v: %v, not anchored: %v, orphans: %v\n",
+		action, len(cr.Compacted), len(cr.Skipped), len(cr.Pruned))
+
+	return nil
 }
 
 func main() {
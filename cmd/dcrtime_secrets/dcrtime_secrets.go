@@ -0,0 +1,135 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/decred/dcrd/dcrutil/v4"
+	"github.com/decred/dcrtime/dcrtimed/secrets"
+)
+
+// passEnv is the environment variable that holds the passphrase when -passfile
+// is not set.  dcrtimed reads the same variable.
+const passEnv = "DCRTIMED_SECRETS_PASSPHRASE"
+
+var (
+	defaultHomeDir = dcrutil.AppDataDir("dcrtimed", false)
+
+	file     = flag.String("file", filepath.Join(defaultHomeDir, "secrets.json"), "Encrypted secrets file")
+	passFile = flag.String("passfile", "", "File containing the passphrase (default: $"+passEnv+")")
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: dcrtime_secrets [flags] <command>\n\n")
+	fmt.Fprintf(os.Stderr, "Manages the encrypted secrets file of dcrtimed.  "+
+		"Options reference an entry\nas file:<name>.\n\n")
+	fmt.Fprintf(os.Stderr, "commands:\n")
+	fmt.Fprintf(os.Stderr, "  list           list the names of the secrets\n")
+	fmt.Fprintf(os.Stderr, "  set <name>     set a secret to the first line "+
+		"of stdin\n")
+	fmt.Fprintf(os.Stderr, "  delete <name>  delete a secret\n\n")
+	fmt.Fprintf(os.Stderr, "flags:\n")
+	flag.PrintDefaults()
+}
+
+// passphrase returns the passphrase of the secrets file.
+func passphrase() ([]byte, error) {
+	if *passFile != "" {
+		b, err := os.ReadFile(*passFile)
+		if err != nil {
+			return nil, err
+		}
+		return bytes.TrimSpace(b), nil
+	}
+	pass := os.Getenv(passEnv)
+	if pass == "" {
+		return nil, fmt.Errorf("-passfile or $%v is required", passEnv)
+	}
+	return []byte(pass), nil
+}
+
+func _main() error {
+	flag.Usage = usage
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	pass, err := passphrase()
+	if err != nil {
+		return err
+	}
+	s, err := secrets.ReadFile(*file, pass)
+	switch {
+	case errors.Is(err, os.ErrNotExist) && args[0] == "set":
+		s = make(map[string]string)
+	case err != nil:
+		return err
+	}
+
+	switch args[0] {
+	case "list":
+		names := make([]string, 0, len(s))
+		for name := range s {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Println(name)
+		}
+		return nil
+
+	case "set":
+		if len(args) != 2 || args[1] == "" {
+			return fmt.Errorf("usage: set <name>")
+		}
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return fmt.Errorf("no secret on stdin: %v", err)
+		}
+		value := strings.TrimRight(line, "\r\n")
+		if value == "" {
+			return fmt.Errorf("empty secret")
+		}
+		s[args[1]] = value
+
+	case "delete":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: delete <name>")
+		}
+		if _, ok := s[args[1]]; !ok {
+			return fmt.Errorf("secret not found: %v", args[1])
+		}
+		delete(s, args[1])
+
+	default:
+		return fmt.Errorf("unknown command: %v", args[0])
+	}
+
+	if err := os.MkdirAll(filepath.Dir(*file), 0700); err != nil {
+		return err
+	}
+	return secrets.WriteFile(*file, pass, s)
+}
+
+func main() {
+	err := _main()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+}
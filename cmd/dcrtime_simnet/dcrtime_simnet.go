@@ -0,0 +1,572 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+// dcrtime_simnet runs dcrd, dcrwallet and dcrtimed on simnet, mines blocks on
+// demand and exercises the submit, flush, anchor and verify cycle end to end.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	pb "decred.org/dcrwallet/v3/rpc/walletrpc"
+	"github.com/decred/dcrd/chaincfg/v3"
+	v2 "github.com/decred/dcrtime/api/v2"
+	"github.com/decred/dcrtime/client"
+	"github.com/decred/dcrtime/util"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+const (
+	// simnetPassphrase is the private passphrase of the wallets that
+	// dcrwallet creates with --createtemp.
+	simnetPassphrase = "password"
+
+	// coinbaseBlocks is the number of blocks that are mined before
+	// dcrtimed is started so that the wallet has mature coinbase outputs
+	// to fund anchors.
+	coinbaseBlocks = 32
+
+	// pollInterval is the time between checks while waiting for a node.
+	pollInterval = 500 * time.Millisecond
+)
+
+var (
+	rootDir       = flag.String("root", "", "Directory of the nodes, a temporary directory by default")
+	keep          = flag.Bool("keep", false, "Keep the directory of the nodes on exit")
+	dcrdBin       = flag.String("dcrd", "dcrd", "dcrd binary")
+	dcrwalletBin  = flag.String("dcrwallet", "dcrwallet", "dcrwallet binary")
+	dcrtimedBin   = flag.String("dcrtimed", "dcrtimed", "dcrtimed binary")
+	rounds        = flag.Int("rounds", 2, "Number of submit, anchor and verify rounds")
+	digests       = flag.Int("digests", 10, "Number of random digests timestamped per round")
+	confirmations = flag.Int("confirmations", 2, "Confirmations dcrtimed requires before digests are anchored")
+	timeout       = flag.Duration("timeout", 2*time.Minute, "Maximum duration of every step")
+	serve         = flag.Bool("serve", false, "Keep the nodes running after the rounds and mine blocks on demand")
+	mineInterval  = flag.Duration("mineinterval", 0, "Mine a block every interval while serving, 0 mines on demand only")
+	verbose       = flag.Bool("v", false, "Verbose")
+)
+
+// maxHeight is the highest block the harness mines.  No tickets are bought,
+// so blocks that require votes can't be mined.
+var maxHeight = chaincfg.SimNetParams().StakeValidationHeight - 1
+
+// harness runs the simnet nodes.
+type harness struct {
+	root     string
+	apiToken string
+	host     string // URL of dcrtimed
+
+	dcrd     *node
+	wallet   *node
+	dcrtimed *node
+
+	rpc    *rpcClient
+	conn   *grpc.ClientConn
+	client *client.Client
+	height int64
+}
+
+// randomHex returns n random bytes encoded as hex.
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// dialWallet connects to the gRPC server of dcrwallet with the client
+// certificate that dcrtimed uses as well.
+func dialWallet(ctx context.Context, host, certFile, clientCert, clientKey string) (*grpc.ClientConn, error) {
+	pem, err := os.ReadFile(certFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %v", certFile)
+	}
+	keypair, err := tls.LoadX509KeyPair(clientCert, clientKey)
+	if err != nil {
+		return nil, err
+	}
+	creds := credentials.NewTLS(&tls.Config{
+		Certificates: []tls.Certificate{keypair},
+		RootCAs:      pool,
+	})
+	return grpc.DialContext(ctx, host, grpc.WithBlock(),
+		grpc.WithTransportCredentials(creds))
+}
+
+// newHarness starts dcrwallet, dcrd and dcrtimed on simnet.  The wallet is
+// created first so that dcrd mines to one of its addresses, enough blocks are
+// mined for the wallet to fund anchors before dcrtimed is started.  dcrtimed
+// anchors every block.
+func newHarness(ctx context.Context) (*harness, error) {
+	h := &harness{root: *rootDir}
+	if h.root == "" {
+		root, err := os.MkdirTemp("", "dcrtime_simnet")
+		if err != nil {
+			return nil, err
+		}
+		h.root = root
+	}
+	var err error
+	h.root, err = filepath.Abs(h.root)
+	if err != nil {
+		return h, err
+	}
+	dcrdDir := filepath.Join(h.root, "dcrd")
+	walletDir := filepath.Join(h.root, "dcrwallet")
+	dcrtimedDir := filepath.Join(h.root, "dcrtimed")
+	for _, dir := range []string{dcrdDir, walletDir, dcrtimedDir} {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return h, err
+		}
+	}
+
+	// Generate all certificates up front, so that every node trusts the
+	// nodes it connects to from the start.
+	dcrdCert := filepath.Join(dcrdDir, "rpc.cert")
+	dcrdKey := filepath.Join(dcrdDir, "rpc.key")
+	walletCert := filepath.Join(walletDir, "rpc.cert")
+	walletKey := filepath.Join(walletDir, "rpc.key")
+	clientCert := filepath.Join(dcrtimedDir, "client.pem")
+	clientKey := filepath.Join(dcrtimedDir, "client-key.pem")
+	for _, v := range [][3]string{
+		{"dcrd", dcrdCert, dcrdKey},
+		{"dcrwallet", walletCert, walletKey},
+		{"dcrtimed", clientCert, clientKey},
+	} {
+		if err := util.GenCertPair(v[0], v[1], v[2]); err != nil {
+			return h, err
+		}
+	}
+
+	rpcUser, err := randomHex(8)
+	if err != nil {
+		return h, err
+	}
+	rpcPass, err := randomHex(16)
+	if err != nil {
+		return h, err
+	}
+	h.apiToken, err = randomHex(16)
+	if err != nil {
+		return h, err
+	}
+	var dcrdHost, walletHost, dcrtimedHost string
+	for _, v := range []*string{&dcrdHost, &walletHost, &dcrtimedHost} {
+		if *v, err = freePort(); err != nil {
+			return h, err
+		}
+	}
+
+	// Create the wallet and ask for the mining address.
+	h.wallet, err = startNode(h.root, "dcrwallet", *dcrwalletBin,
+		"--simnet",
+		"--appdata="+walletDir,
+		"--createtemp",
+		"--nolegacyrpc",
+		"--grpclisten="+walletHost,
+		"--rpccert="+walletCert,
+		"--rpckey="+walletKey,
+		"--clientcafile="+clientCert,
+		"--rpcconnect="+dcrdHost,
+		"--username="+rpcUser,
+		"--password="+rpcPass,
+		"--cafile="+dcrdCert)
+	if err != nil {
+		return h, err
+	}
+	dialCtx, cancel := context.WithTimeout(ctx, *timeout)
+	h.conn, err = dialWallet(dialCtx, walletHost, walletCert, clientCert,
+		clientKey)
+	cancel()
+	if err != nil {
+		return h, fmt.Errorf("dial dcrwallet: %v", err)
+	}
+	wallet := pb.NewWalletServiceClient(h.conn)
+	var miningAddr string
+	err = h.poll(ctx, h.wallet, func() error {
+		r, err := wallet.NextAddress(ctx, &pb.NextAddressRequest{
+			Kind:      pb.NextAddressRequest_BIP0044_EXTERNAL,
+			GapPolicy: pb.NextAddressRequest_GAP_POLICY_WRAP,
+		})
+		if err != nil {
+			return err
+		}
+		miningAddr = r.Address
+		return nil
+	})
+	if err != nil {
+		return h, fmt.Errorf("mining address: %v", err)
+	}
+
+	// Start dcrd and mine the coins that fund anchors.
+	h.dcrd, err = startNode(h.root, "dcrd", *dcrdBin,
+		"--simnet",
+		"--appdata="+dcrdDir,
+		"--nolisten",
+		"--txindex",
+		"--rpclisten="+dcrdHost,
+		"--rpcuser="+rpcUser,
+		"--rpcpass="+rpcPass,
+		"--rpccert="+dcrdCert,
+		"--rpckey="+dcrdKey,
+		"--miningaddr="+miningAddr)
+	if err != nil {
+		return h, err
+	}
+	h.rpc, err = newRPCClient(dcrdHost, rpcUser, rpcPass, dcrdCert)
+	if err != nil {
+		return h, err
+	}
+	err = h.poll(ctx, h.dcrd, func() error {
+		return h.rpc.call(ctx, "getblockcount", &h.height)
+	})
+	if err != nil {
+		return h, err
+	}
+	if err := h.mine(ctx, coinbaseBlocks); err != nil {
+		return h, err
+	}
+
+	// Start dcrtimed and wait until it serves requests.
+	h.dcrtimed, err = startNode(h.root, "dcrtimed", *dcrtimedBin,
+		"--simnet",
+		"--appdata="+dcrtimedDir,
+		"--listen="+dcrtimedHost,
+		"--wallethost="+walletHost,
+		"--walletcert="+walletCert,
+		"--walletpassphrase="+simnetPassphrase,
+		"--cert="+clientCert,
+		"--key="+clientKey,
+		"--apitoken="+h.apiToken,
+		"--anchorblocks=1",
+		"--confirmations="+strconv.Itoa(*confirmations))
+	if err != nil {
+		return h, err
+	}
+	h.host = "https://" + dcrtimedHost
+	h.client, err = client.New(client.Config{
+		Host:       h.host,
+		SkipVerify: true,
+		APIToken:   h.apiToken,
+		Timeout:    time.Minute,
+	})
+	if err != nil {
+		return h, err
+	}
+	hc := &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		},
+		Timeout: time.Minute,
+	}
+	err = h.poll(ctx, h.dcrtimed, func() error {
+		resp, err := hc.Get(h.host + v2.ReadyRoute)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("dcrtimed not ready: %v", resp.Status)
+		}
+		return nil
+	})
+	if err != nil {
+		return h, err
+	}
+
+	fmt.Printf("Nodes running in %v\n", h.root)
+	return h, nil
+}
+
+// poll polls f until it succeeds, but no longer than the step timeout.
+func (h *harness) poll(ctx context.Context, n *node, f func() error) error {
+	ctx, cancel := context.WithTimeout(ctx, *timeout)
+	defer cancel()
+	return poll(ctx, n, pollInterval, f)
+}
+
+// close stops the nodes and removes their directory unless it is kept.
+func (h *harness) close() {
+	if h.conn != nil {
+		h.conn.Close()
+	}
+	h.dcrtimed.stop()
+	h.wallet.stop()
+	h.dcrd.stop()
+	if *keep || *rootDir != "" {
+		fmt.Printf("Logs and data kept in %v\n", h.root)
+		return
+	}
+	os.RemoveAll(h.root)
+}
+
+// mine mines the provided number of blocks and waits until the wallet has
+// seen them, so that dcrtimed is notified of the blocks as well.
+func (h *harness) mine(ctx context.Context, blocks int) error {
+	if h.height+int64(blocks) > maxHeight {
+		return fmt.Errorf("can't mine past simnet height %v without "+
+			"votes", maxHeight)
+	}
+	var hashes []string
+	if err := h.rpc.call(ctx, "generate", &hashes, blocks); err != nil {
+		return err
+	}
+	h.height += int64(len(hashes))
+
+	wallet := pb.NewWalletServiceClient(h.conn)
+	err := h.poll(ctx, h.wallet, func() error {
+		r, err := wallet.BestBlock(ctx, &pb.BestBlockRequest{})
+		if err != nil {
+			return err
+		}
+		if int64(r.Height) < h.height {
+			return fmt.Errorf("wallet at height %v, chain at %v",
+				r.Height, h.height)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if *verbose {
+		fmt.Printf("Mined %v blocks, height %v\n", len(hashes), h.height)
+	}
+	return nil
+}
+
+// waitMempool waits until a transaction enters the mempool of dcrd.
+func (h *harness) waitMempool(ctx context.Context) error {
+	return h.poll(ctx, h.dcrtimed, func() error {
+		var txs []string
+		if err := h.rpc.call(ctx, "getrawmempool", &txs); err != nil {
+			return err
+		}
+		if len(txs) == 0 {
+			return errors.New("no anchor in the mempool")
+		}
+		return nil
+	})
+}
+
+// verifyAnchor verifies that the provided transaction was mined and stores the
+// provided merkle root in a null data output.
+func (h *harness) verifyAnchor(ctx context.Context, txid, merkleRoot string) error {
+	root, err := hex.DecodeString(merkleRoot)
+	if err != nil || len(root) != sha256.Size {
+		return fmt.Errorf("invalid merkle root %v", merkleRoot)
+	}
+	var tx struct {
+		BlockHash string `json:"blockhash"`
+		Vout      []struct {
+			ScriptPubKey struct {
+				Hex string `json:"hex"`
+			} `json:"scriptPubKey"`
+		} `json:"vout"`
+	}
+	if err := h.rpc.call(ctx, "getrawtransaction", &tx, txid, 1); err != nil {
+		return err
+	}
+	if tx.BlockHash == "" {
+		return fmt.Errorf("anchor %v not mined", txid)
+	}
+	for _, out := range tx.Vout {
+		script, err := hex.DecodeString(out.ScriptPubKey.Hex)
+		if err != nil {
+			return err
+		}
+		// OP_RETURN
+		if len(script) > 0 && script[0] == 0x6a &&
+			bytes.HasSuffix(script, root) {
+			return nil
+		}
+	}
+	return fmt.Errorf("anchor %v does not store merkle root %v", txid,
+		merkleRoot)
+}
+
+// round timestamps random digests, mines the block that ends their window so
+// that dcrtimed flushes and anchors them, mines the confirmations of the
+// anchor and verifies the digests and the anchor.
+func (h *harness) round(ctx context.Context, n int) error {
+	fmt.Printf("Round %v: timestamp %v digests\n", n, *digests)
+	ds := make([]string, 0, *digests)
+	for i := 0; i < *digests; i++ {
+		d, err := randomHex(sha256.Size)
+		if err != nil {
+			return err
+		}
+		ds = append(ds, d)
+	}
+	reply, err := h.client.Timestamp(ctx, v2.TimestampBatch{
+		ID:      "dcrtime_simnet",
+		Digests: ds,
+	})
+	if err != nil {
+		return fmt.Errorf("timestamp: %v", err)
+	}
+	for k, r := range reply.Results {
+		if r != v2.ResultOK {
+			return fmt.Errorf("timestamp %v: %v", reply.Digests[k],
+				v2.Result[r])
+		}
+	}
+
+	fmt.Printf("Round %v: flush and anchor\n", n)
+	if err := h.mine(ctx, 1); err != nil {
+		return err
+	}
+	if err := h.waitMempool(ctx); err != nil {
+		return err
+	}
+	if err := h.mine(ctx, *confirmations); err != nil {
+		return err
+	}
+
+	fmt.Printf("Round %v: verify\n", n)
+	wctx, cancel := context.WithTimeout(ctx, *timeout)
+	defer cancel()
+	vds, err := h.client.WaitAnchored(wctx, v2.VerifyBatch{
+		ID:      "dcrtime_simnet",
+		Digests: ds,
+	}, pollInterval)
+	if err != nil {
+		return fmt.Errorf("verify: %v", err)
+	}
+	verified := make(map[string]struct{})
+	for _, vd := range vds {
+		ci := vd.ChainInformation
+		if _, ok := verified[ci.Transaction]; ok {
+			continue
+		}
+		err := h.verifyAnchor(ctx, ci.Transaction, ci.MerkleRoot)
+		if err != nil {
+			return err
+		}
+		verified[ci.Transaction] = struct{}{}
+		fmt.Printf("Round %v: anchor %v OK\n", n, ci.Transaction)
+	}
+	return nil
+}
+
+// serveNodes keeps the nodes running until the context is done.  Every line
+// on stdin mines the number of blocks it contains, one for an empty line.
+// Blocks are also mined every mineinterval if set.
+func (h *harness) serveNodes(ctx context.Context) error {
+	fmt.Printf("dcrtimed: %v\n", h.host)
+	fmt.Printf("apitoken: %v\n", h.apiToken)
+	fmt.Printf("Press enter to mine a block, enter a number to mine " +
+		"several, ctrl-c to quit\n")
+
+	lines := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+	var tick <-chan time.Time
+	if *mineInterval > 0 {
+		ticker := time.NewTicker(*mineInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		blocks := 1
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-h.dcrtimed.done:
+			return h.dcrtimed.exited()
+		case <-tick:
+		case line := <-lines:
+			line = strings.TrimSpace(line)
+			if line != "" {
+				var err error
+				blocks, err = strconv.Atoi(line)
+				if err != nil || blocks < 1 {
+					fmt.Printf("invalid number of blocks: "+
+						"%v\n", line)
+					continue
+				}
+			}
+		}
+		if err := h.mine(ctx, blocks); err != nil {
+			fmt.Printf("mine: %v\n", err)
+			continue
+		}
+		fmt.Printf("Height %v\n", h.height)
+	}
+}
+
+func _main() error {
+	flag.Parse()
+	if *digests < 1 {
+		return fmt.Errorf("-digests must be positive")
+	}
+	if *confirmations < 1 {
+		return fmt.Errorf("-confirmations must be positive")
+	}
+	if *rounds < 0 {
+		return fmt.Errorf("-rounds must not be negative")
+	}
+	need := coinbaseBlocks + int64(*rounds)*int64(1+*confirmations)
+	if need > maxHeight {
+		return fmt.Errorf("%v rounds need %v blocks, simnet allows %v",
+			*rounds, need, maxHeight)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	h, err := newHarness(ctx)
+	if h != nil {
+		defer h.close()
+	}
+	if err != nil {
+		return err
+	}
+	for i := 1; i <= *rounds; i++ {
+		if err := h.round(ctx, i); err != nil {
+			return fmt.Errorf("round %v: %v", i, err)
+		}
+	}
+	if *rounds > 0 {
+		fmt.Printf("All %v rounds passed\n", *rounds)
+	}
+	if *serve {
+		return h.serveNodes(ctx)
+	}
+	return nil
+}
+
+func main() {
+	err := _main()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+}
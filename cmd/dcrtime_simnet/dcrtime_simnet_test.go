@@ -0,0 +1,51 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// TestSimnet runs a round of the harness against a dcrtimed that is built from
+// this tree.  It is skipped unless dcrd and dcrwallet are found in PATH.
+func TestSimnet(t *testing.T) {
+	if testing.Short() {
+		t.Skip("simnet harness skipped in short mode")
+	}
+	for _, bin := range []string{"dcrd", "dcrwallet"} {
+		if _, err := exec.LookPath(bin); err != nil {
+			t.Skipf("simnet harness skipped: %v not found in PATH",
+				bin)
+		}
+	}
+
+	dir := t.TempDir()
+	bin := filepath.Join(dir, "dcrtimed")
+	out, err := exec.Command("go", "build", "-o", bin,
+		"github.com/decred/dcrtime/dcrtimed").CombinedOutput()
+	if err != nil {
+		t.Fatalf("build dcrtimed: %v\n%s", err, out)
+	}
+
+	*rootDir = filepath.Join(dir, "nodes")
+	*dcrtimedBin = bin
+	*rounds = 1
+	*digests = 3
+
+	ctx := context.Background()
+	h, err := newHarness(ctx)
+	if h != nil {
+		defer h.close()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := h.round(ctx, 1); err != nil {
+		t.Fatalf("round 1: %v (logs in %v)", err, h.root)
+	}
+}
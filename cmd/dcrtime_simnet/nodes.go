@@ -0,0 +1,222 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// stopTimeout is the time a node gets to shut down after it was interrupted
+// before it is killed.
+const stopTimeout = 30 * time.Second
+
+// node is a dcrd, dcrwallet or dcrtimed process that is run by the harness.
+// Its output is written to a log file in the root directory of the harness.
+type node struct {
+	name    string
+	logFile string
+	cmd     *exec.Cmd
+	done    chan struct{} // Closed once the process exited
+	err     error         // Exit error, valid once done is closed
+}
+
+// startNode starts the provided binary with the provided arguments.
+func startNode(root, name, bin string, args ...string) (*node, error) {
+	n := &node{
+		name:    name,
+		logFile: filepath.Join(root, name+".log"),
+		done:    make(chan struct{}),
+	}
+	f, err := os.Create(n.logFile)
+	if err != nil {
+		return nil, err
+	}
+	n.cmd = exec.Command(bin, args...)
+	n.cmd.Stdout = f
+	n.cmd.Stderr = f
+	if err := n.cmd.Start(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("start %v: %v", name, err)
+	}
+	if *verbose {
+		fmt.Printf("Started %v (pid %v), log %v\n", name,
+			n.cmd.Process.Pid, n.logFile)
+	}
+
+	go func() {
+		n.err = n.cmd.Wait()
+		f.Close()
+		close(n.done)
+	}()
+	return n, nil
+}
+
+// exited returns an error if the process exited already.  It is used to fail
+// fast rather than to wait for a node that will never become ready.
+func (n *node) exited() error {
+	select {
+	case <-n.done:
+		return fmt.Errorf("%v exited: %v, see %v", n.name, n.err,
+			n.logFile)
+	default:
+		return nil
+	}
+}
+
+// stop interrupts the process and waits for it to exit.  It is killed if it
+// does not shut down in time.
+func (n *node) stop() {
+	if n == nil {
+		return
+	}
+	select {
+	case <-n.done:
+		return
+	default:
+	}
+	n.cmd.Process.Signal(os.Interrupt)
+	select {
+	case <-n.done:
+	case <-time.After(stopTimeout):
+		n.cmd.Process.Kill()
+		<-n.done
+	}
+}
+
+// freePort returns a TCP port on the loopback interface that is not in use.
+func freePort() (string, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	defer l.Close()
+	return l.Addr().String(), nil
+}
+
+// poll calls f every interval until it succeeds, the node exits or the
+// context is done.  The last error of f is returned in the latter case.
+func poll(ctx context.Context, n *node, interval time.Duration, f func() error) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		err := f()
+		if err == nil {
+			return nil
+		}
+		if xerr := n.exited(); xerr != nil {
+			return xerr
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%v: %v", ctx.Err(), err)
+		case <-ticker.C:
+		}
+	}
+}
+
+// rpcError is an error returned by the dcrd JSON-RPC server.
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// Error satisfies the error interface.
+func (e *rpcError) Error() string {
+	return fmt.Sprintf("%v: %v", e.Code, e.Message)
+}
+
+// rpcClient is a minimal dcrd JSON-RPC client.
+type rpcClient struct {
+	url  string
+	user string
+	pass string
+	id   uint64
+	http *http.Client
+}
+
+// newRPCClient returns a client of the dcrd JSON-RPC server at the provided
+// address that trusts the provided certificate.
+func newRPCClient(host, user, pass, certFile string) (*rpcClient, error) {
+	pem, err := os.ReadFile(certFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %v", certFile)
+	}
+	return &rpcClient{
+		url:  "https://" + host,
+		user: user,
+		pass: pass,
+		http: &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{RootCAs: pool},
+			},
+			Timeout: time.Minute,
+		},
+	}, nil
+}
+
+// call executes the JSON-RPC method and decodes its result into result.
+func (c *rpcClient) call(ctx context.Context, method string, result interface{}, params ...interface{}) error {
+	if params == nil {
+		params = []interface{}{}
+	}
+	b, err := json.Marshal(struct {
+		JSONRPC string        `json:"jsonrpc"`
+		ID      uint64        `json:"id"`
+		Method  string        `json:"method"`
+		Params  []interface{} `json:"params"`
+	}{
+		JSONRPC: "1.0",
+		ID:      atomic.AddUint64(&c.id, 1),
+		Method:  method,
+		Params:  params,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url,
+		bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(c.user, c.pass)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var reply struct {
+		Result json.RawMessage `json:"result"`
+		Error  *rpcError       `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&reply); err != nil {
+		return fmt.Errorf("%v: %v %v", method, resp.StatusCode, err)
+	}
+	if reply.Error != nil {
+		return fmt.Errorf("%v: %w", method, reply.Error)
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(reply.Result, result)
+}
@@ -0,0 +1,62 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/tls"
+	"net/http"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// acmeManager returns the manager that obtains and renews the https
+// certificate of the acme domains.  Certificates and the account key are kept
+// in the acme cache directory so that they survive restarts.
+func acmeManager(cfg *config) *autocert.Manager {
+	m := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(cfg.ACMECacheDir),
+		HostPolicy: autocert.HostWhitelist(cfg.ACMEDomains...),
+		Email:      cfg.ACMEEmail,
+	}
+	if cfg.ACMEDirectory != "" {
+		m.Client = &acme.Client{DirectoryURL: cfg.ACMEDirectory}
+	}
+	return m
+}
+
+// enableACME makes the listeners serve certificates obtained through acme.
+// The tls-alpn-01 challenge is answered by the listeners themselves, which
+// requires one of them to be reachable on port 443.  The http-01 challenge is
+// answered on acmehttplisten when it is set.
+func (d *DcrtimeStore) enableACME(tlsCfg *tls.Config) {
+	m := acmeManager(d.cfg)
+	tlsCfg.GetCertificate = m.GetCertificate
+	tlsCfg.NextProtos = []string{"h2", "http/1.1", acme.ALPNProto}
+
+	log.Infof("ACME: certificates for %v from %v", d.cfg.ACMEDomains,
+		acmeDirectory(d.cfg))
+
+	if d.cfg.ACMEHTTPListen == "" {
+		return
+	}
+	go func() {
+		// All other requests are redirected to https.
+		log.Infof("ACME: http-01 challenges on %v",
+			d.cfg.ACMEHTTPListen)
+		err := http.ListenAndServe(d.cfg.ACMEHTTPListen,
+			m.HTTPHandler(nil))
+		log.Errorf("ACME: http-01 listener: %v", err)
+	}()
+}
+
+// acmeDirectory returns the directory URL of the acme CA.
+func acmeDirectory(cfg *config) string {
+	if cfg.ACMEDirectory != "" {
+		return cfg.ACMEDirectory
+	}
+	return autocert.DefaultACMEDirectory
+}
@@ -0,0 +1,236 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	v2 "github.com/decred/dcrtime/api/v2"
+	"github.com/decred/dcrtime/dcrtimed/backend"
+	"github.com/decred/dcrtime/util"
+)
+
+// walletAccount returns the wallet account that funds anchors, if anchors are
+// published through dcrwallet.
+func (d *DcrtimeStore) walletAccount() string {
+	if len(d.cfg.WalletHost) == 0 || len(d.cfg.DcrdHost) != 0 ||
+		len(d.cfg.StoreHost) != 0 {
+		return ""
+	}
+	account, _ := walletAccount(d.cfg.WalletAccounts)
+	return account
+}
+
+// adminConfig returns the configuration of the daemon without secrets.
+func (d *DcrtimeStore) adminConfig() v2.AdminConfig {
+	return v2.AdminConfig{
+		Listeners:         d.cfg.Listeners,
+		PublicListeners:   d.cfg.PublicListeners,
+		SubmitListeners:   d.cfg.SubmitListeners,
+		AdminListeners:    d.cfg.AdminListeners,
+		APIVersions:       d.cfg.APIVersions,
+		DataDir:           d.cfg.DataDir,
+		LogDir:            d.cfg.LogDir,
+		DebugLevel:        d.cfg.DebugLevel,
+		WalletHost:        d.cfg.WalletHost,
+		WalletAccount:     d.walletAccount(),
+		DcrdHost:          d.cfg.DcrdHost,
+		AnchorFeeMode:     d.cfg.AnchorFeeMode,
+		AnchorFeeRate:     d.cfg.AnchorFeeRate,
+		AnchorMaxFee:      d.cfg.AnchorMaxFee,
+		LowBalance:        d.cfg.LowBalance,
+		LowBalancePause:   d.cfg.LowBalancePause,
+		AnchorPrefix:      d.cfg.AnchorPrefix,
+		AnchorRetry:       d.cfg.AnchorRetry.Milliseconds(),
+		AnchorBlocks:      d.cfg.AnchorBlocks,
+		Anchorers:         d.cfg.Anchorers,
+		StoreHost:         d.cfg.StoreHost,
+		StoreFailoverHost: d.cfg.StoreFailoverHost,
+		StoreTimeout:      d.cfg.StoreTimeout.Milliseconds(),
+		StoreFanoutHosts:  d.cfg.StoreFanoutHosts,
+		StoreQuorum:       d.cfg.StoreQuorum,
+		EnableCollections: d.cfg.EnableCollections,
+		Confirmations:     d.cfg.Confirmations,
+		MaxDigests:        d.cfg.MaxDigests,
+		ScopeMaxDigests:   d.scopeMaxDigests,
+		VerifyCacheSize:   d.cfg.VerifyCacheSize,
+		RestrictAPI:       d.cfg.RestrictAPI,
+		PrivateDigests:    d.cfg.PrivateDigests,
+		ProofAudit:        d.cfg.ProofAudit,
+		ReadOnly:          d.cfg.ReadOnly,
+		MaintenanceQueue:  d.cfg.MaintenanceQueue,
+		AnnounceURL:       d.cfg.AnnounceURL,
+		PublicURL:         d.cfg.PublicURL,
+		SelfAuditInterval: d.cfg.SelfAuditInterval.Milliseconds(),
+		SelfAuditSamples:  d.cfg.SelfAuditSamples,
+	}
+}
+
+// adminStatusV2 returns the runtime state of the daemon. A failing backend is
+// reported in the reply rather than as an error so that operators can always
+// retrieve it.
+// Handles /v2/admin/status
+func (d *DcrtimeStore) adminStatusV2(w http.ResponseWriter, r *http.Request) {
+	if !d.isAuthorized(r, v2.TokenScopeAdmin) {
+		d.respondNotAuthorized(w, r)
+		return
+	}
+
+	reply := v2.AdminStatusReply{
+		Version:        d.cfg.Version,
+		Network:        netName(activeNetParams),
+		StartTimestamp: d.started.Unix(),
+		StartTime:      v2.FormatTime(d.started.Unix()),
+		Config:         d.adminConfig(),
+	}
+
+	sr, err := d.backend.Status()
+	if err != nil {
+		log.Errorf("%v AdminStatus: backend: %v", logAddr(r), err)
+		reply.BackendError = err.Error()
+	} else {
+		reply.BackendHealthy = true
+		reply.PendingDigests = sr.PendingDigests
+		reply.Maintenance = sr.Maintenance
+		reply.LastFlushTimestamp = sr.LastFlushTimestamp
+		reply.LastFlushTime = v2.FormatTime(sr.LastFlushTimestamp)
+		reply.LastFlushChainTimestamp = sr.LastFlushChainTimestamp
+		reply.LastFlushChainTime = v2.FormatTime(sr.LastFlushChainTimestamp)
+		if sr.LastFlushTimestamp != 0 {
+			reply.LastFlushTransaction = sr.LastFlushTx.String()
+		}
+		if sr.WalletError != nil {
+			log.Errorf("%v AdminStatus: wallet: %v", logAddr(r),
+				sr.WalletError)
+			reply.WalletError = sr.WalletError.Error()
+		} else {
+			reply.WalletConnected = true
+		}
+		if sr.Balance != nil {
+			reply.WalletBalance = &v2.WalletBalanceReply{
+				Total:       sr.Balance.Total,
+				Spendable:   sr.Balance.Spendable,
+				Unconfirmed: sr.Balance.Unconfirmed,
+			}
+		}
+		reply.LowBalance = sr.LowBalance
+		reply.FlushPaused = sr.FlushPaused
+		if sr.AnchorError != nil {
+			log.Errorf("%v AdminStatus: anchor: %v", logAddr(r),
+				sr.AnchorError)
+			reply.AnchorError = sr.AnchorError.Error()
+		}
+	}
+
+	if d.verifyCache != nil {
+		entries, hits, misses := d.verifyCache.stats()
+		reply.VerifyCache = &v2.VerifyCacheStats{
+			Size:    d.verifyCache.size,
+			Entries: entries,
+			Hits:    hits,
+			Misses:  misses,
+		}
+		if hits+misses != 0 {
+			reply.VerifyCache.HitRate = float64(hits) /
+				float64(hits+misses)
+		}
+	}
+
+	if d.cfg.SelfAuditInterval != 0 {
+		sa := d.selfAuditStatus()
+		reply.SelfAudit = &sa
+	}
+
+	log.Infof("%v AdminStatus %v", r.URL.Path, logAddr(r))
+
+	util.RespondWithJSON(w, http.StatusOK, reply)
+}
+
+func (d *DcrtimeStore) proxyAdminStatusV2(w http.ResponseWriter, r *http.Request) {
+	d.sendToBackend(r.Context(), w, r.Method,
+		withAPIToken(v2.AdminStatusRoute, r), r.Header.Get("Content-Type"),
+		r.RemoteAddr, bytes.NewReader([]byte{}))
+
+	log.Infof("%v AdminStatus %v", r.URL.Path, logAddr(r))
+}
+
+// decodeMaintenance decodes the request body into m.  It responds with an
+// error and returns false if the request is invalid.
+func decodeMaintenance(w http.ResponseWriter, body io.Reader, m *v2.Maintenance) bool {
+	decoder := json.NewDecoder(body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(m); err != nil {
+		util.RespondWithError(w, http.StatusBadRequest,
+			"Invalid request payload")
+		return false
+	}
+	return true
+}
+
+// maintenanceV2 starts or ends maintenance mode.  Ending it flushes the
+// queued collections before the reply is sent.
+// Handles /v2/admin/maintenance
+func (d *DcrtimeStore) maintenanceV2(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	if !d.isAuthorized(r, v2.TokenScopeAdmin) {
+		d.respondNotAuthorized(w, r)
+		return
+	}
+
+	var m v2.Maintenance
+	if !decodeMaintenance(w, r.Body, &m) {
+		return
+	}
+
+	err := d.backend.SetMaintenance(m.Enable)
+	if errors.Is(err, backend.ErrReadOnly) {
+		util.RespondWithError(w, http.StatusConflict,
+			"Server is read-only")
+		return
+	} else if err != nil {
+		errorCode := time.Now().Unix()
+		log.Errorf("%v Maintenance error code %v: %v", logAddr(r),
+			errorCode, err)
+		util.RespondWithError(w, http.StatusInternalServerError,
+			fmt.Sprintf("failed to set maintenance mode, "+
+				"contact administrator and provide "+
+				"the following error code: %v", errorCode))
+		return
+	}
+
+	log.Infof("%v Maintenance %v: %v", r.URL.Path, logAddr(r), m.Enable)
+
+	util.RespondWithJSON(w, http.StatusOK, v2.MaintenanceReply{
+		Maintenance: m.Enable,
+	})
+}
+
+func (d *DcrtimeStore) proxyMaintenanceV2(w http.ResponseWriter, r *http.Request) {
+	b, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		util.RespondWithError(w, http.StatusBadRequest,
+			"Unable to read request")
+		return
+	}
+
+	var m v2.Maintenance
+	if !decodeMaintenance(w, bytes.NewReader(b), &m) {
+		return
+	}
+
+	d.sendToBackend(r.Context(), w, r.Method,
+		withAPIToken(v2.MaintenanceRoute, r), r.Header.Get("Content-Type"),
+		r.RemoteAddr, bytes.NewReader(b))
+
+	log.Infof("%v Maintenance %v: %v", r.URL.Path, logAddr(r), m.Enable)
+}
@@ -0,0 +1,190 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	v2 "github.com/decred/dcrtime/api/v2"
+	"github.com/decred/dcrtime/dcrtimed/backend"
+	"github.com/decred/dcrtime/merkle"
+	"github.com/decred/dcrtime/util"
+)
+
+// aggregateProofs returns the merkle root of the provided digests and the
+// merkle path of every digest to that root, in the order of the digests.
+func aggregateProofs(digests [][sha256.Size]byte) ([sha256.Size]byte, []v2.MerkleBranch) {
+	leaves := make([]*[sha256.Size]byte, 0, len(digests))
+	for k := range digests {
+		leaves = append(leaves, &digests[k])
+	}
+
+	// The tree sorts its leaves so it has to be built from a copy.
+	tree := merkle.Tree(append([]*[sha256.Size]byte{}, leaves...))
+	root := *tree[len(tree)-1]
+	sorted := tree[:len(leaves)]
+
+	proofs := make([]v2.MerkleBranch, 0, len(leaves))
+	for _, leaf := range leaves {
+		proofs = append(proofs, v2.MerkleBranch(*merkle.AuthPath(sorted,
+			leaf)))
+	}
+
+	return root, proofs
+}
+
+// timestampAggregateV2 timestamps the merkle root of a batch of digests as a
+// single digest and returns the merkle path of every digest to that root.
+// Handles /v2/timestamp/aggregate
+func (d *DcrtimeStore) timestampAggregateV2(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	var t v2.TimestampAggregate
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&t); err != nil {
+		util.RespondWithError(w, http.StatusBadRequest,
+			"Invalid request payload")
+		return
+	}
+
+	// Validate all digests.  If one is invalid return failure.
+	if len(t.Digests) == 0 || len(t.Digests) > v2.MaxAggregateDigests {
+		util.RespondWithError(w, http.StatusBadRequest,
+			fmt.Sprintf("Invalid number %d of digests. Max is: %d",
+				len(t.Digests), v2.MaxAggregateDigests))
+		return
+	}
+	digests, err := convertDigests(t.Digests)
+	if err != nil {
+		util.RespondWithError(w, http.StatusBadRequest,
+			"Invalid Digests array")
+		return
+	}
+	seen := make(map[[sha256.Size]byte]struct{}, len(digests))
+	for _, digest := range digests {
+		if _, ok := seen[digest]; ok {
+			util.RespondWithError(w, http.StatusBadRequest,
+				fmt.Sprintf("Duplicate digest %x", digest))
+			return
+		}
+		seen[digest] = struct{}{}
+	}
+
+	// Validate optional group label.
+	if t.Label != "" && !v2.RegexpLabel.MatchString(t.Label) {
+		util.RespondWithError(w, http.StatusBadRequest,
+			"Invalid Label")
+		return
+	}
+
+	root, proofs := aggregateProofs(digests)
+
+	// Charge the aggregated digests to the quota of the api token.
+	refund, ok := d.chargeQuota(w, r, len(digests))
+	if !ok {
+		return
+	}
+
+	// Push aggregate root to backend
+	ts, me, err := d.put(r.Context(), [][sha256.Size]byte{root}, t.Label, "")
+	if err != nil {
+		refund()
+
+		// Tell client there is a transient error.
+		if errors.Is(err, backend.ErrTryAgainLater) {
+			util.RespondWithError(w, http.StatusServiceUnavailable,
+				"Server busy, please try again later.")
+			return
+		}
+		if errors.Is(err, backend.ErrQueueFull) {
+			util.RespondWithError(w, http.StatusServiceUnavailable,
+				"Server is in maintenance and its queue is "+
+					"full, please try again later.")
+			return
+		}
+
+		// Generic internal error.
+		errorCode := time.Now().Unix()
+		log.Errorf("%v timestamp aggregate error code %v: %v",
+			logAddr(r), errorCode, err)
+		util.RespondWithError(w, http.StatusInternalServerError,
+			fmt.Sprintf("Could not store payload, contact "+
+				"administrator and provide the following "+
+				"error code: %v", errorCode))
+		return
+	}
+
+	// Record the api token as owner of the accepted digests.
+	d.recordOwner(r, ts, me)
+	d.recordSubmission(d.submissionClient(r), ts, me)
+
+	// Log for audit trail.
+	via := logAddr(r)
+	xff := r.Header.Get(forward)
+	if xff != "" {
+		via = fmt.Sprintf("%v via %v", xff, logAddr(r))
+	}
+	verb := "accepted"
+	result := v2.ResultOK
+	if len(me) != 1 || me[0].ErrorCode != backend.ErrorOK {
+		verb = "rejected"
+		result = v2.ResultExistsError
+	}
+	log.Infof("%v TimestampAggregate %v: %v %v %x (%v digests)",
+		r.URL.Path, via, verb, time.Unix(ts, 0).UTC().Format(fStr),
+		root, len(digests))
+
+	// We don't set ChainTimestamp until it is included on the chain.
+	reply := v2.TimestampAggregateReply{
+		ID:              t.ID,
+		ServerTimestamp: ts,
+		ServerTime:      v2.FormatTime(ts),
+		Label:           t.Label,
+		AggregateRoot:   hex.EncodeToString(root[:]),
+		Result:          result,
+		Digests:         t.Digests,
+		Proofs:          proofs,
+		Maintenance:     d.backend.Maintenance(),
+	}
+	if keys := d.accessKeys(me); len(keys) != 0 {
+		reply.AccessKey = keys[0]
+	}
+	util.RespondWithJSON(w, http.StatusOK, reply)
+}
+
+func (d *DcrtimeStore) proxyTimestampAggregateV2(w http.ResponseWriter, r *http.Request) {
+	b, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		util.RespondWithError(w, http.StatusBadRequest,
+			"Unable to read request")
+		return
+	}
+
+	var t v2.TimestampAggregate
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&t); err != nil {
+		util.RespondWithError(w, http.StatusBadRequest,
+			"Invalid request payload")
+		return
+	}
+
+	d.submitToBackend(r.Context(), w, r.Method,
+		withAPIToken(v2.TimestampAggregateRoute, r),
+		r.Header.Get("Content-Type"), r.RemoteAddr, b)
+
+	log.Infof("%v TimestampAggregate %v: %v digests", r.URL.Path,
+		logAddr(r), len(t.Digests))
+}
@@ -0,0 +1,413 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/decred/dcrd/dcrutil/v4"
+	v2 "github.com/decred/dcrtime/api/v2"
+)
+
+const (
+	// notifyTimeout is the maximum time a notification may take.
+	notifyTimeout = 30 * time.Second
+
+	// minAlertInterval is the minimum interval between alert checks.
+	minAlertInterval = 10 * time.Second
+)
+
+// notifier delivers notifications to operators.
+type notifier interface {
+	// notify delivers the provided notification.
+	notify(context.Context, v2.Notification) error
+
+	// String returns a description of the notifier that does not reveal
+	// its credentials.
+	String() string
+}
+
+// notificationText returns the provided notification as a single line of
+// text for chat and email notifiers.
+func notificationText(n v2.Notification) string {
+	text := fmt.Sprintf("dcrtimed %v %v: %v: %v", n.Network, n.Instance,
+		n.Event, n.Message)
+	if n.Digest != "" {
+		text += " (digest " + n.Digest + ")"
+	}
+	return text
+}
+
+// postNotification sends the provided body to a notification endpoint.  Any
+// reply other than 2xx is a failure.
+func postNotification(ctx context.Context, method, endpoint, token string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, method, endpoint,
+		bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("sink replied %v", resp.Status)
+	}
+	return nil
+}
+
+// urlNotifier posts notifications as JSON to a notifyurl.
+type urlNotifier struct {
+	url string
+}
+
+func (n *urlNotifier) notify(ctx context.Context, note v2.Notification) error {
+	b, err := json.Marshal(note)
+	if err != nil {
+		return err
+	}
+	return postNotification(ctx, http.MethodPost, n.url, "", b)
+}
+
+func (n *urlNotifier) String() string {
+	return n.url
+}
+
+// slackNotifier posts notifications to a Slack incoming webhook.  The URL of
+// the webhook is its credential.
+type slackNotifier struct {
+	url string
+}
+
+func (n *slackNotifier) notify(ctx context.Context, note v2.Notification) error {
+	b, err := json.Marshal(struct {
+		Text string `json:"text"`
+	}{
+		Text: notificationText(note),
+	})
+	if err != nil {
+		return err
+	}
+	return postNotification(ctx, http.MethodPost, n.url, "", b)
+}
+
+func (n *slackNotifier) String() string {
+	u, err := url.Parse(n.url)
+	if err != nil {
+		return "slack"
+	}
+	return "slack " + u.Host
+}
+
+// matrixNotifier sends notifications as text messages to a Matrix room.
+type matrixNotifier struct {
+	url   string
+	room  string
+	token string
+}
+
+func (n *matrixNotifier) notify(ctx context.Context, note v2.Notification) error {
+	var txnID [8]byte
+	if _, err := rand.Read(txnID[:]); err != nil {
+		return err
+	}
+	b, err := json.Marshal(struct {
+		MsgType string `json:"msgtype"`
+		Body    string `json:"body"`
+	}{
+		MsgType: "m.text",
+		Body:    notificationText(note),
+	})
+	if err != nil {
+		return err
+	}
+	endpoint := fmt.Sprintf("%v/_matrix/client/v3/rooms/%v/send/"+
+		"m.room.message/%v", strings.TrimSuffix(n.url, "/"),
+		url.PathEscape(n.room), hex.EncodeToString(txnID[:]))
+	return postNotification(ctx, http.MethodPut, endpoint, n.token, b)
+}
+
+func (n *matrixNotifier) String() string {
+	return "matrix " + n.room
+}
+
+// emailNotifier mails notifications through an SMTP server.
+type emailNotifier struct {
+	addr     string
+	user     string
+	password string
+	from     string
+	to       []string
+}
+
+func (n *emailNotifier) notify(ctx context.Context, note v2.Notification) error {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", n.addr)
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	host, _, _ := net.SplitHostPort(n.addr)
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		err := c.StartTLS(&tls.Config{
+			ServerName: host,
+			MinVersion: tls.VersionTLS12,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if n.user != "" {
+		// PlainAuth refuses to send the password without TLS unless
+		// the server is localhost.
+		err := c.Auth(smtp.PlainAuth("", n.user, n.password, host))
+		if err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(n.from); err != nil {
+		return err
+	}
+	for _, to := range n.to {
+		if err := c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "From: %v\r\n", n.from)
+	fmt.Fprintf(w, "To: %v\r\n", strings.Join(n.to, ", "))
+	fmt.Fprintf(w, "Subject: dcrtimed %v: %v\r\n", note.Network, note.Event)
+	fmt.Fprintf(w, "Date: %v\r\n", time.Unix(note.ServerTimestamp, 0).
+		UTC().Format(time.RFC1123Z))
+	fmt.Fprintf(w, "Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	fmt.Fprintf(w, "%v\r\n", notificationText(note))
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+func (n *emailNotifier) String() string {
+	return "email " + strings.Join(n.to, ", ")
+}
+
+// newNotifiers returns the notifiers of the provided configuration.
+func newNotifiers(cfg *config) []notifier {
+	var notifiers []notifier
+	for _, notifyURL := range cfg.NotifyURLs {
+		notifiers = append(notifiers, &urlNotifier{url: notifyURL})
+	}
+	for _, slackURL := range cfg.Alerts.SlackURLs {
+		notifiers = append(notifiers, &slackNotifier{url: slackURL})
+	}
+	if cfg.Alerts.MatrixURL != "" {
+		notifiers = append(notifiers, &matrixNotifier{
+			url:   cfg.Alerts.MatrixURL,
+			room:  cfg.Alerts.MatrixRoom,
+			token: cfg.Alerts.MatrixToken,
+		})
+	}
+	if cfg.Alerts.SMTPHost != "" {
+		notifiers = append(notifiers, &emailNotifier{
+			addr:     cfg.Alerts.SMTPHost,
+			user:     cfg.Alerts.SMTPUser,
+			password: cfg.Alerts.SMTPPass,
+			from:     cfg.Alerts.EmailFrom,
+			to:       cfg.Alerts.EmailTo,
+		})
+	}
+	return notifiers
+}
+
+// validateAlerts validates the options of the [alerts] section.
+func validateAlerts(cfg *config) error {
+	ac := &cfg.Alerts
+	if ac.Interval < minAlertInterval {
+		return fmt.Errorf("alerts.interval must be at least %v",
+			minAlertInterval)
+	}
+	for _, slackURL := range ac.SlackURLs {
+		if !validWebhookURL(slackURL) {
+			return fmt.Errorf("invalid alerts.slackurl: %v", slackURL)
+		}
+	}
+	if ac.MatrixURL != "" {
+		if !validWebhookURL(ac.MatrixURL) {
+			return fmt.Errorf("invalid alerts.matrixurl: %v",
+				ac.MatrixURL)
+		}
+		if ac.MatrixRoom == "" || ac.MatrixToken == "" {
+			return errors.New("alerts.matrixurl requires " +
+				"alerts.matrixroom and alerts.matrixtoken")
+		}
+	}
+	if ac.SMTPHost == "" {
+		if len(ac.EmailTo) != 0 {
+			return errors.New("alerts.emailto requires alerts.smtphost")
+		}
+		return nil
+	}
+	if _, _, err := net.SplitHostPort(ac.SMTPHost); err != nil {
+		return fmt.Errorf("invalid alerts.smtphost: %v", err)
+	}
+	if ac.EmailFrom == "" || len(ac.EmailTo) == 0 {
+		return errors.New("alerts.smtphost requires alerts.emailfrom " +
+			"and alerts.emailto")
+	}
+	for _, addr := range append([]string{ac.EmailFrom}, ac.EmailTo...) {
+		if strings.ContainsAny(addr, "\r\n<>,") {
+			return fmt.Errorf("invalid email address: %q", addr)
+		}
+	}
+	return nil
+}
+
+// notify posts the provided event to all notifiers.  Failures are logged since
+// there is nobody else left to tell.
+func (d *DcrtimeStore) notify(event, digest, message string) {
+	now := time.Now().Unix()
+	note := v2.Notification{
+		Event:           event,
+		Instance:        d.cfg.SelfTestURL,
+		Network:         netName(activeNetParams),
+		Digest:          digest,
+		Message:         message,
+		ServerTimestamp: now,
+		ServerTime:      v2.FormatTime(now),
+	}
+	for _, n := range d.notifiers {
+		ctx, cancel := context.WithTimeout(d.ctx, notifyTimeout)
+		err := n.notify(ctx, note)
+		cancel()
+		if err != nil {
+			log.Errorf("Notify %v: %v", n, err)
+		}
+	}
+}
+
+// alertState holds the alert conditions that are active.
+type alertState struct {
+	walletDown  bool
+	flushFailed bool
+	lowBalance  bool
+	lastReorg   int64
+}
+
+// alert notifies the start of a condition when it became active and its end
+// when it no longer is.  It returns whether the condition is active.
+func (d *DcrtimeStore) alert(was, active bool, event, message, recovered, recoveredMessage string) bool {
+	switch {
+	case active && !was:
+		log.Warnf("Alert %v: %v", event, message)
+		d.notify(event, "", message)
+	case !active && was:
+		log.Infof("Alert %v: %v", recovered, recoveredMessage)
+		d.notify(recovered, "", recoveredMessage)
+	}
+	return active
+}
+
+// checkAlerts checks the alert conditions of the backend and notifies their
+// changes since the last check.
+func (d *DcrtimeStore) checkAlerts(s *alertState) {
+	sr, err := d.backend.Status()
+	if err != nil {
+		log.Errorf("Alerts: status: %v", err)
+		return
+	}
+
+	var walletMsg string
+	if sr.WalletError != nil {
+		walletMsg = fmt.Sprintf("wallet unreachable: %v", sr.WalletError)
+	}
+	s.walletDown = d.alert(s.walletDown, sr.WalletError != nil,
+		v2.NotificationWalletDown, walletMsg,
+		v2.NotificationWalletRecovered, "wallet reachable again")
+
+	var anchorMsg string
+	if sr.AnchorError != nil {
+		anchorMsg = fmt.Sprintf("anchor failed: %v", sr.AnchorError)
+	}
+	s.flushFailed = d.alert(s.flushFailed, sr.AnchorError != nil,
+		v2.NotificationFlushFailed, anchorMsg,
+		v2.NotificationFlushRecovered, "anchor succeeded again")
+
+	if sr.LastReorg > s.lastReorg {
+		s.lastReorg = sr.LastReorg
+		message := fmt.Sprintf("anchor reorganized out of the chain "+
+			"at %v", v2.FormatTime(sr.LastReorg))
+		log.Warnf("Alert %v: %v", v2.NotificationReorg, message)
+		d.notify(v2.NotificationReorg, "", message)
+	}
+
+	// The balance is monitored by the backend, which keeps the previous
+	// state while the wallet is down.
+	var available dcrutil.Amount
+	if sr.Balance != nil {
+		available = dcrutil.Amount(sr.Balance.Spendable +
+			sr.Balance.Unconfirmed)
+	}
+	lowMsg := fmt.Sprintf("wallet balance %v below %v", available,
+		dcrutil.Amount(d.cfg.LowBalance))
+	if sr.FlushPaused {
+		lowMsg += ", flushes are paused"
+	}
+	s.lowBalance = d.alert(s.lowBalance, sr.LowBalance,
+		v2.NotificationLowBalance, lowMsg,
+		v2.NotificationBalanceRecovered,
+		fmt.Sprintf("wallet balance %v", available))
+}
+
+// alerter checks the alert conditions of the backend every alert interval and
+// notifies when a condition starts and when it ends.
+func (d *DcrtimeStore) alerter() {
+	log.Infof("Checking alerts every %v, %v notifiers",
+		d.cfg.Alerts.Interval, len(d.notifiers))
+
+	ticker := time.NewTicker(d.cfg.Alerts.Interval)
+	defer ticker.Stop()
+	var s alertState
+	for {
+		d.checkAlerts(&s)
+
+		select {
+		case <-d.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,64 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+// Package anchorer attests merkle roots outside of the Decred blockchain.
+// Anchorers are secondary: the Decred anchor remains the proof of a
+// collection, attestations add defense in depth should it ever be disputed.
+package anchorer
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"strings"
+)
+
+// Anchorer types.
+const (
+	TypeOTS = "ots" // OpenTimestamps calendar
+)
+
+// Anchorer attests merkle roots.
+type Anchorer interface {
+	// Name identifies the anchorer and its target in attestations.
+	Name() string
+
+	// Anchor submits the provided merkle root and returns the
+	// anchorer specific proof that it was attested.
+	Anchor(context.Context, [sha256.Size]byte) ([]byte, error)
+}
+
+// Parse returns the anchorer that is described by spec, which has the form
+// type:target, e.g. ots:https://calendar.example.com.
+func Parse(spec string) (Anchorer, error) {
+	i := strings.Index(spec, ":")
+	if i <= 0 {
+		return nil, fmt.Errorf("invalid anchorer %q: want type:target",
+			spec)
+	}
+	switch spec[:i] {
+	case TypeOTS:
+		return NewCalendar(spec[i+1:])
+	}
+	return nil, fmt.Errorf("invalid anchorer %q: unknown type %v", spec,
+		spec[:i])
+}
+
+// ParseAll returns the anchorers that are described by specs.
+func ParseAll(specs []string) ([]Anchorer, error) {
+	anchorers := make([]Anchorer, 0, len(specs))
+	names := make(map[string]struct{}, len(specs))
+	for _, spec := range specs {
+		a, err := Parse(spec)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := names[a.Name()]; ok {
+			return nil, fmt.Errorf("duplicate anchorer %v", a.Name())
+		}
+		names[a.Name()] = struct{}{}
+		anchorers = append(anchorers, a)
+	}
+	return anchorers, nil
+}
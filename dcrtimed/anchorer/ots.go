@@ -0,0 +1,87 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package anchorer
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	// otsAccept is the media type of OpenTimestamps calendar replies.
+	otsAccept = "application/vnd.opentimestamps.v1"
+
+	// maxOTSReply is the maximum size of a calendar reply.  Pending
+	// timestamps are a few hundred bytes.
+	maxOTSReply = 10000
+)
+
+// Calendar submits merkle roots to an OpenTimestamps calendar, which may be a
+// private one.  The proof is the pending timestamp returned by the calendar.
+// It leads from the merkle root to the calendar commitment and is upgraded
+// with the calendar to a complete proof once the calendar was anchored.
+type Calendar struct {
+	url    string
+	client *http.Client
+}
+
+var _ Anchorer = (*Calendar)(nil)
+
+// NewCalendar returns an anchorer that submits to the calendar at the
+// provided URL.
+func NewCalendar(calendarURL string) (*Calendar, error) {
+	u, err := url.Parse(calendarURL)
+	if err != nil {
+		return nil, err
+	}
+	if (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return nil, fmt.Errorf("invalid calendar url: %v", calendarURL)
+	}
+	return &Calendar{
+		url:    strings.TrimSuffix(calendarURL, "/"),
+		client: &http.Client{},
+	}, nil
+}
+
+// Name returns the anchorer type and the calendar URL.
+func (c *Calendar) Name() string {
+	return TypeOTS + ":" + c.url
+}
+
+// Anchor submits the merkle root to the calendar and returns the pending
+// timestamp.
+func (c *Calendar) Anchor(ctx context.Context, merkleRoot [sha256.Size]byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		c.url+"/digest", bytes.NewReader(merkleRoot[:]))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", otsAccept)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("calendar: %v", resp.Status)
+	}
+	proof, err := io.ReadAll(io.LimitReader(resp.Body, maxOTSReply+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(proof) == 0 || len(proof) > maxOTSReply {
+		return nil, fmt.Errorf("calendar: invalid reply of %v bytes",
+			len(proof))
+	}
+	return proof, nil
+}
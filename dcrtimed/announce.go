@@ -0,0 +1,125 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	v2 "github.com/decred/dcrtime/api/v2"
+)
+
+// announceTimeout is the maximum time an announcement may take.
+const announceTimeout = 30 * time.Second
+
+// lastAnchor returns the last anchor of the backend or, in proxy mode, of the
+// storehost.
+func (d *DcrtimeStore) lastAnchor(ctx context.Context) (*v2.LastAnchorReply, error) {
+	if d.backend == nil {
+		reply, err := d.forward(ctx, http.MethodGet, v2.LastAnchorRoute,
+			"application/json", "", bytes.NewReader(nil))
+		if err != nil {
+			return nil, err
+		}
+		if reply.statusCode != http.StatusOK {
+			return nil, fmt.Errorf("storehost: %v", reply.status)
+		}
+		var lar v2.LastAnchorReply
+		err = json.Unmarshal(reply.body, &lar)
+		if err != nil {
+			return nil, err
+		}
+		return &lar, nil
+	}
+
+	la, err := d.backend.LastAnchor()
+	if err != nil {
+		return nil, err
+	}
+	return &v2.LastAnchorReply{
+		ChainTimestamp: la.ChainTimestamp,
+		ChainTime:      v2.FormatTime(la.ChainTimestamp),
+		Transaction:    la.Tx.String(),
+		BlockHash:      la.BlockHash,
+		BlockHeight:    la.BlockHeight,
+	}, nil
+}
+
+// announce posts the capabilities and anchor statistics of this instance to
+// the instance directory.
+func (d *DcrtimeStore) announce() error {
+	ctx, cancel := context.WithTimeout(d.ctx, announceTimeout)
+	defer cancel()
+
+	versions, _ := parseAndValidateAPIVersions(d.cfg.APIVersions)
+	now := time.Now().Unix()
+	a := v2.Announcement{
+		URL:             d.cfg.PublicURL,
+		Version:         d.cfg.Version,
+		Network:         netName(activeNetParams),
+		APIVersions:     versions,
+		Proxy:           d.backend == nil,
+		MaxDigests:      d.cfg.MaxDigests,
+		ScopeMaxDigests: d.scopeMaxDigests,
+		ServerTimestamp: now,
+		ServerTime:      v2.FormatTime(now),
+	}
+	la, err := d.lastAnchor(ctx)
+	if err != nil {
+		log.Warnf("Announce: last anchor: %v", err)
+	} else {
+		a.LastAnchor = la
+	}
+
+	b, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		d.cfg.AnnounceURL, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("directory replied %v", resp.Status)
+	}
+
+	return nil
+}
+
+// announcer announces this instance to the instance directory right away and
+// then once every announce interval.  Failures are logged and retried at the
+// next interval.
+func (d *DcrtimeStore) announcer() {
+	log.Infof("Announcing %v to %v every %v", d.cfg.PublicURL,
+		d.cfg.AnnounceURL, d.cfg.AnnounceInterval)
+
+	ticker := time.NewTicker(d.cfg.AnnounceInterval)
+	defer ticker.Stop()
+	for {
+		err := d.announce()
+		if err != nil {
+			log.Errorf("Announce: %v", err)
+		} else {
+			log.Debugf("Announced to %v", d.cfg.AnnounceURL)
+		}
+
+		select {
+		case <-d.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
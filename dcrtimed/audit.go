@@ -0,0 +1,160 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/decred/dcrd/chaincfg/chainhash"
+	v2 "github.com/decred/dcrtime/api/v2"
+	"github.com/decred/dcrtime/dcrtimed/backend"
+	"github.com/decred/dcrtime/util"
+)
+
+// auditProofs records that the proofs of the provided digests are served to
+// the client of the request when proofaudit is enabled.  Digests that were
+// not found, are hidden or are not anchored yet carry no proof and are not
+// recorded.  The proofs must not be served if recording them fails.
+func (d *DcrtimeStore) auditProofs(r *http.Request, drs []backend.GetResult, formats []string) error {
+	if !d.cfg.ProofAudit {
+		return nil
+	}
+
+	remoteAddr := r.RemoteAddr
+	if xff := r.Header.Get(forward); xff != "" {
+		remoteAddr = fmt.Sprintf("%v via %v", r.RemoteAddr, xff)
+	}
+	now := time.Now().Unix()
+	records := make([]backend.ProofRecord, 0, len(drs))
+	for _, dr := range drs {
+		if dr.ErrorCode != backend.ErrorOK || dr.Tx == (chainhash.Hash{}) {
+			continue
+		}
+		records = append(records, backend.ProofRecord{
+			Digest:         dr.Digest,
+			Timestamp:      now,
+			Token:          tokenIdentity(r),
+			RemoteAddr:     remoteAddr,
+			RequestID:      requestID(r.Context()),
+			Route:          r.URL.Path,
+			Tx:             dr.Tx,
+			MerkleRoot:     dr.MerkleRoot,
+			ChainTimestamp: dr.AnchoredTimestamp,
+			Formats:        formats,
+		})
+	}
+
+	return d.backend.PutProofRecords(records)
+}
+
+// convertProofRecord converts a backend proof record to its API
+// representation.
+func convertProofRecord(pr backend.ProofRecord) v2.ProofRecord {
+	return v2.ProofRecord{
+		Timestamp:      pr.Timestamp,
+		Time:           v2.FormatTime(pr.Timestamp),
+		Token:          pr.Token,
+		RemoteAddr:     pr.RemoteAddr,
+		RequestID:      pr.RequestID,
+		Route:          pr.Route,
+		Transaction:    pr.Tx.String(),
+		MerkleRoot:     hex.EncodeToString(pr.MerkleRoot[:]),
+		ChainTimestamp: pr.ChainTimestamp,
+		ChainTime:      v2.FormatTime(pr.ChainTimestamp),
+		Formats:        pr.Formats,
+	}
+}
+
+// decodeProofAudit decodes the request body into pa and validates the digest.
+// It responds with an error and returns false if the request is invalid.
+func decodeProofAudit(w http.ResponseWriter, body io.Reader, pa *v2.ProofAudit) bool {
+	decoder := json.NewDecoder(body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(pa); err != nil {
+		util.RespondWithError(w, http.StatusBadRequest,
+			"Invalid request payload")
+		return false
+	}
+	if !v2.RegexpSHA256.MatchString(pa.Digest) {
+		util.RespondWithError(w, http.StatusBadRequest,
+			"Invalid Digest")
+		return false
+	}
+	return true
+}
+
+// proofAuditV2 returns the audit trail of the proofs that were served for a
+// digest.
+// Handles /v2/admin/proofaudit
+func (d *DcrtimeStore) proofAuditV2(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	if !d.isAuthorized(r, v2.TokenScopeAdmin) {
+		d.respondNotAuthorized(w, r)
+		return
+	}
+
+	var pa v2.ProofAudit
+	if !decodeProofAudit(w, r.Body, &pa) {
+		return
+	}
+	digests, err := convertDigests([]string{pa.Digest})
+	if err != nil {
+		util.RespondWithError(w, http.StatusBadRequest,
+			"Invalid Digest")
+		return
+	}
+
+	records, err := d.backend.GetProofRecords(digests[0])
+	if err != nil {
+		errorCode := time.Now().Unix()
+		log.Errorf("%v ProofAudit error code %v: %v", logAddr(r),
+			errorCode, err)
+		util.RespondWithError(w, http.StatusInternalServerError,
+			fmt.Sprintf("failed to retrieve proof audit trail, "+
+				"contact administrator and provide "+
+				"the following error code: %v", errorCode))
+		return
+	}
+
+	reply := v2.ProofAuditReply{
+		Digest:  hex.EncodeToString(digests[0][:]),
+		Records: make([]v2.ProofRecord, 0, len(records)),
+	}
+	for _, pr := range records {
+		reply.Records = append(reply.Records, convertProofRecord(pr))
+	}
+
+	log.Infof("%v ProofAudit %v: %v", r.URL.Path, logAddr(r), reply.Digest)
+
+	util.RespondWithJSON(w, http.StatusOK, reply)
+}
+
+func (d *DcrtimeStore) proxyProofAuditV2(w http.ResponseWriter, r *http.Request) {
+	b, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		util.RespondWithError(w, http.StatusBadRequest,
+			"Unable to read request")
+		return
+	}
+
+	var pa v2.ProofAudit
+	if !decodeProofAudit(w, bytes.NewReader(b), &pa) {
+		return
+	}
+
+	d.sendToBackend(r.Context(), w, r.Method,
+		withAPIToken(v2.ProofAuditRoute, r), r.Header.Get("Content-Type"),
+		r.RemoteAddr, bytes.NewReader(b))
+
+	log.Infof("%v ProofAudit %v: %v", r.URL.Path, logAddr(r), pa.Digest)
+}
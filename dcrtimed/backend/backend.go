@@ -8,8 +8,10 @@ import (
 	"crypto/sha256"
 	"errors"
 	"os"
+	"time"
 
 	"github.com/decred/dcrd/chaincfg/chainhash"
+	"github.com/decred/dcrtime/checkpoint"
 	"github.com/decred/dcrtime/merkle"
 )
 
@@ -30,6 +32,72 @@ const (
 // while anchoring
 var ErrTryAgainLater = errors.New("busy, try again later")
 
+// ErrReadOnly is returned when digests are stored in a read-only backend.
+var ErrReadOnly = errors.New("read-only")
+
+// ErrQueueFull is returned when digests are stored in a backend in maintenance
+// mode that already queued as many digests as it may.
+var ErrQueueFull = errors.New("maintenance queue full")
+
+// ErrTokenNotFound is returned when an api token does not exist.
+var ErrTokenNotFound = errors.New("token not found")
+
+// ErrQuotaExceeded is returned when digests are charged to an api token that
+// would exceed its daily or monthly quota.
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+// ErrDeliveryNotFound is returned when a webhook delivery does not exist.
+var ErrDeliveryNotFound = errors.New("delivery not found")
+
+// ErrSubscriptionNotFound is returned when a webhook subscription does not
+// exist.
+var ErrSubscriptionNotFound = errors.New("subscription not found")
+
+// ErrAnchorNotFound is returned when a collection does not exist or was not
+// anchored with enough confirmations yet.
+var ErrAnchorNotFound = errors.New("anchor not found")
+
+// ErrInvalidCursor is returned when a digest search continues at a cursor that
+// was not returned by an earlier search.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+var (
+	// ErrCollectionNotFound is returned when an owner did not timestamp
+	// any digests in a collection.
+	ErrCollectionNotFound = errors.New("collection not found")
+
+	// ErrCollectionAnchored is returned when a collection that was already
+	// anchored is deleted.
+	ErrCollectionAnchored = errors.New("collection already anchored")
+
+	// ErrCollectionShared is returned when a collection that contains
+	// digests of other owners is deleted.
+	ErrCollectionShared = errors.New("collection contains digests of " +
+		"other owners")
+)
+
+var (
+	// ErrSessionNotFound is returned when a submission session does not
+	// exist or expired.
+	ErrSessionNotFound = errors.New("session not found")
+
+	// ErrSessionClosed is returned when digests are appended to, or when
+	// closing, a submission session that was already closed.
+	ErrSessionClosed = errors.New("session closed")
+
+	// ErrSessionOffset is returned when digests are appended beyond the
+	// digests that were staged in a submission session.
+	ErrSessionOffset = errors.New("offset beyond staged digests")
+
+	// ErrSessionFull is returned when more digests are appended to a
+	// submission session than it may hold.
+	ErrSessionFull = errors.New("session full")
+)
+
+// ErrTicketNotFound is returned when an asynchronous submission ticket does
+// not exist or expired.
+var ErrTicketNotFound = errors.New("ticket not found")
+
 // FlushRecord contains blockchain information.  This information only becomes
 // available once digests are anchored in the blockchain.  The information
 // contained in this record is subject to change due to blockchain realities
@@ -44,6 +112,29 @@ type FlushRecord struct {
 	// As we periodically collect hashes, each collection identified by the
 	// the timestamp when we started the collection
 	ServerTimestamp int64
+	BlockHeight     int32  // Block height of Tx, if available
+	AnchorPrefix    string // Prefix in front of merkle root in Tx, if any
+
+	// Replaced contains the earlier anchors of the merkle root that were
+	// not mined in time and were replaced by Tx.  They may still be mined
+	// instead of Tx.
+	Replaced []chainhash.Hash
+
+	// Attestations of the merkle root by secondary anchorers.
+	Attestations []Attestation
+
+	// MinedBlock is the block Tx was last seen in while it lacked
+	// confirmations.  Reorged is the time Tx was last reorganized out of
+	// the chain, 0 if it never was.
+	MinedBlock chainhash.Hash
+	Reorged    int64
+}
+
+// Attestation is the proof of a secondary anchorer that it attested the
+// merkle root of a flush.
+type Attestation struct {
+	Anchorer string `json:"anchorer"` // Name of the anchorer
+	Proof    []byte `json:"proof"`    // Anchorer specific proof
 }
 
 // PutResult is a cooked error returned by the backend.
@@ -61,8 +152,11 @@ type TimestampResult struct {
 	MinConfirmations  int32               // Mininum number of confirmations to return timestamp proof
 	AnchoredTimestamp int64               // Anchored timestamp
 	Tx                chainhash.Hash      // Anchor Tx
+	AnchorPrefix      string              // Prefix in front of merkle root in Tx
+	Attestations      []Attestation       // Secondary attestations
 	MerkleRoot        [sha256.Size]byte   // Merkle root
 	Digests           [][sha256.Size]byte // All digests
+	ReorgTimestamp    int64               // Time anchor was reorganized out, if ever
 }
 
 // GetResult is a cooked result returned by the backend.
@@ -75,14 +169,24 @@ type GetResult struct {
 	AnchoredTimestamp int64             // Anchored timestamp
 	FlushTimestamp    int64             // Flush timestamp
 	Tx                chainhash.Hash    // Anchor Tx
+	BlockHeight       int32             // Block height of Tx, if known
+	AnchorPrefix      string            // Prefix in front of merkle root in Tx
+	Attestations      []Attestation     // Secondary attestations
 	MerkleRoot        [sha256.Size]byte // Merkle root
 	MerklePath        merkle.Branch     // Auth path
+	Label             string            // Group label, if any
+	Algorithm         string            // Digest algorithm, empty for SHA-256
+	ReorgTimestamp    int64             // Time anchor was reorganized out, if ever
 }
 
 // DigestReceived describes when a digest was received by the server.
 type DigestReceived struct {
-	Digest    string `json:"digest"`    // Digest that was flushed
-	Timestamp int64  `json:"timestamp"` // Server received timestamp
+	Digest    string `json:"digest"`          // Digest that was flushed
+	Timestamp int64  `json:"timestamp"`       // Server received timestamp
+	Label     string `json:"label,omitempty"` // Group label, if any
+
+	// Digest algorithm, empty for SHA-256
+	Algorithm string `json:"algorithm,omitempty"`
 }
 
 // FlushRecordJSON is identical to FlushRecord but with corrected JSON
@@ -96,6 +200,11 @@ type FlushRecordJSON struct {
 	FlushTimestamp int64                `json:"flushtimestamp"`          // Time flush actually happened
 	Timestamp      int64                `json:"timestamp,omitempty"`     // Timestamp received
 	Confirmations  *int32               `json:"confirmations,omitempty"` // Timestamp received
+	BlockHeight    int32                `json:"blockheight,omitempty"`   // Block height of Tx, if available
+	AnchorPrefix   string               `json:"anchorprefix,omitempty"`  // Prefix in front of merkle root in Tx, if any
+	Replaced       []chainhash.Hash     `json:"replaced,omitempty"`      // Earlier anchors replaced by Tx
+	Attestations   []Attestation        `json:"attestations,omitempty"`  // Secondary attestations
+	Reorged        int64                `json:"reorged,omitempty"`       // Time Tx was reorganized out, if ever
 }
 
 // Record types.
@@ -143,6 +252,291 @@ type LastAnchorResult struct {
 	BlockHeight    int32          `json:"blockheight"` // Anchored tx block height
 }
 
+// WindowResult describes the pending collection that digests are currently
+// stored in.  The merkle root changes with every digest until the window
+// ends.
+type WindowResult struct {
+	Timestamp  int64             // Collection timestamp
+	Ends       int64             // Time the window ends, 0 if EndHeight
+	EndHeight  int32             // Block height the window ends at, if any
+	Digests    int               // Number of digests so far
+	MerkleRoot [sha256.Size]byte // Merkle root of the digests so far
+}
+
+// AnchorResult describes a collection that was anchored in a block.
+type AnchorResult struct {
+	Timestamp      int64               // Collection timestamp
+	Tx             chainhash.Hash      // Anchor Tx
+	MerkleRoot     [sha256.Size]byte   // Merkle root
+	BlockHeight    int32               // Block height of Tx
+	ChainTimestamp int64               // Anchored timestamp
+	Digests        [][sha256.Size]byte // All digests
+}
+
+// StatusResult contains runtime information about the backend.
+type StatusResult struct {
+	LastFlushTimestamp      int64          // Time the last flush happened, 0 if never
+	LastFlushTx             chainhash.Hash // Tx that anchored the last flush
+	LastFlushChainTimestamp int64          // Chain timestamp of the last flush, if available
+	PendingDigests          int64          // Digests that were not flushed yet
+	Maintenance             bool           // Set when digests are queued but not flushed
+	WalletError             error          // Set when the wallet is unreachable
+	AnchorError             error          // Set when the last anchor failed
+	LastReorg               int64          // Time an anchor was last reorganized out of the chain, 0 if never
+
+	// Balance is the wallet balance, nil when the wallet is unreachable.
+	// LowBalance is set while it was below the configured low balance at
+	// the last check and FlushPaused while digests are queued because of
+	// it.
+	Balance     *GetBalanceResult
+	LowBalance  bool
+	FlushPaused bool
+}
+
+// HealthResult describes whether a backend is able to timestamp digests.
+type HealthResult struct {
+	LastFlusher     time.Time     // Time the flusher last completed
+	FlusherInterval time.Duration // Time between flusher runs, 0 if it does not run
+	WalletError     error         // Set when the wallet is unreachable
+}
+
+// APIToken describes an api token that grants access to privileged API
+// resources.  Backends store it under the sha256 digest of the token, the
+// token itself is never stored.
+type APIToken struct {
+	ID          string   `json:"id"`          // Public identifier
+	Description string   `json:"description"` // Free form description
+	Scopes      []string `json:"scopes"`      // Granted scopes
+	Created     int64    `json:"created"`     // Creation timestamp
+	Expires     int64    `json:"expires"`     // Expiration timestamp, 0 if never
+	Uses        uint64   `json:"uses"`        // Number of authorized requests
+	LastUsed    int64    `json:"lastused"`    // Timestamp of last authorized request
+	Collection  string   `json:"collection"`  // Default collection name
+
+	// Digest quotas, 0 if unlimited.
+	DailyQuota   int64 `json:"dailyquota"`   // Digests per UTC day
+	MonthlyQuota int64 `json:"monthlyquota"` // Digests per UTC month
+
+	// Digest usage counters, see ChargeToken.
+	Day              int64  `json:"day"`              // Start of the current day
+	DayDigests       int64  `json:"daydigests"`       // Digests this day
+	Month            int64  `json:"month"`            // Start of the current month
+	MonthDigests     int64  `json:"monthdigests"`     // Digests this month
+	LastMonthDigests int64  `json:"lastmonthdigests"` // Digests the previous month
+	Digests          uint64 `json:"digests"`          // Digests ever charged
+}
+
+// RollUsage starts the daily and monthly usage counters of the token over when
+// the provided timestamp is in a later UTC day or month.
+func (t *APIToken) RollUsage(ts int64) {
+	now := time.Unix(ts, 0).UTC()
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0,
+		time.UTC).Unix()
+	month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0,
+		time.UTC).Unix()
+
+	if day > t.Day {
+		t.Day = day
+		t.DayDigests = 0
+	}
+	if month > t.Month {
+		// Only a month that directly precedes this one is kept.
+		prev := time.Date(now.Year(), now.Month()-1, 1, 0, 0, 0, 0,
+			time.UTC).Unix()
+		if t.Month == prev {
+			t.LastMonthDigests = t.MonthDigests
+		} else {
+			t.LastMonthDigests = 0
+		}
+		t.Month = month
+		t.MonthDigests = 0
+	}
+}
+
+// Delivery is a webhook notification that is retried until its receiver
+// accepts it.  A delivery that exhausted its attempts is dead and stays in the
+// dead-letter queue until it is retried or discarded.
+type Delivery struct {
+	ID          string `json:"id"`          // Unique, stable across attempts
+	URL         string `json:"url"`         // Receiver
+	Payload     []byte `json:"payload"`     // JSON request body
+	Created     int64  `json:"created"`     // Creation timestamp
+	Attempts    int    `json:"attempts"`    // Failed attempts
+	NextAttempt int64  `json:"nextattempt"` // Timestamp of the next attempt
+	LastError   string `json:"lasterror"`   // Error of the last attempt
+	Dead        bool   `json:"dead"`        // In the dead-letter queue
+}
+
+// Subscription is a webhook URL that a client registered for the anchor of a
+// collection.  Subscriptions that were made with a submission carry its
+// accepted digests.  Included is set once the webhook of the anchor being
+// included in a block was enqueued.
+type Subscription struct {
+	ID        string   `json:"id"`                // Random identifier
+	Owner     string   `json:"owner"`             // ID of the api token
+	URL       string   `json:"url"`               // Receiver
+	Timestamp int64    `json:"timestamp"`         // Collection timestamp
+	Digests   []string `json:"digests,omitempty"` // Submitted digests, if any
+	Created   int64    `json:"created"`           // Creation timestamp
+	Included  bool     `json:"included"`          // Included webhook enqueued
+}
+
+// Session is a submission session.  Digests are staged in an open session
+// across many requests and timestamped at once when it is closed.  The outcome
+// of a closed session is kept until it expires.
+type Session struct {
+	ID         string `json:"id"`         // Random identifier
+	Owner      string `json:"owner"`      // Public ID of the api token, if any
+	Label      string `json:"label"`      // Group label of the digests
+	Algorithm  string `json:"algorithm"`  // Stored digest algorithm
+	MaxDigests int64  `json:"maxdigests"` // Digests the session may hold
+	Created    int64  `json:"created"`    // Creation timestamp
+	Expires    int64  `json:"expires"`    // Expiration timestamp
+	Digests    int64  `json:"digests"`    // Staged digests
+	Closed     int64  `json:"closed"`     // Close timestamp, 0 while open
+	Timestamp  int64  `json:"timestamp"`  // Collection of the digests once closed
+	Accepted   int64  `json:"accepted"`   // Digests that were timestamped
+	Existing   int64  `json:"existing"`   // Digests that already existed
+}
+
+// Ticket is an asynchronous submission.  Its digests are queued until they
+// are timestamped, the outcome is then kept until the ticket expires.
+type Ticket struct {
+	ID           string              `json:"id"`           // Random identifier
+	Owner        string              `json:"owner"`        // Public ID of the api token, if any
+	Collection   string              `json:"collection"`   // Default collection of the owner
+	Client       string              `json:"client"`       // Client of the submission statistics
+	Label        string              `json:"label"`        // Group label of the digests
+	Algorithm    string              `json:"algorithm"`    // Stored digest algorithm
+	Digests      [][sha256.Size]byte `json:"digests"`      // Submitted digests
+	WebhookURL   string              `json:"webhookurl"`   // Subscribed once processed, if any
+	Created      int64               `json:"created"`      // Creation timestamp
+	Processed    int64               `json:"processed"`    // Processing timestamp, 0 while queued
+	Timestamp    int64               `json:"timestamp"`    // Collection of the digests once processed
+	Results      []uint              `json:"results"`      // ErrorCode of every digest once processed
+	Subscription string              `json:"subscription"` // Webhook subscription, if any
+	Expires      int64               `json:"expires"`      // Expiration timestamp once processed
+}
+
+// Collection describes the digests an owner timestamped in a collection.
+// Owners are identified by the ID of their api token.
+type Collection struct {
+	Timestamp int64  // Collection timestamp
+	Name      string // Name given by the owner, if any
+	Digests   int    // Digests of the owner
+	Anchored  bool   // Flushed and anchored
+}
+
+// ProofRecord records that the proof of a digest was served.  The records of
+// a digest form its audit trail.
+type ProofRecord struct {
+	Digest         [sha256.Size]byte `json:"digest"`         // Digest
+	Timestamp      int64             `json:"timestamp"`      // Time the proof was served
+	Token          string            `json:"token"`          // Public ID of the api token, - if none
+	RemoteAddr     string            `json:"remoteaddr"`     // Client, followed by proxies if any
+	RequestID      string            `json:"requestid"`      // Request ID, if any
+	Route          string            `json:"route"`          // Route the proof was served on
+	Tx             chainhash.Hash    `json:"tx"`             // Anchor Tx of the proof
+	MerkleRoot     [sha256.Size]byte `json:"merkleroot"`     // Merkle root of the proof
+	ChainTimestamp int64             `json:"chaintimestamp"` // Chain timestamp, 0 if unconfirmed
+	Formats        []string          `json:"formats"`        // Proof formats, if any
+}
+
+// Metadata is a small set of annotations, such as a file name or a MIME type,
+// that the submitter of a digest stored with it.  Private metadata is only
+// returned to its owner.
+type Metadata struct {
+	Digest  [sha256.Size]byte `json:"digest"`  // Digest
+	Owner   string            `json:"owner"`   // Public ID of the api token, if any
+	Private bool              `json:"private"` // Only returned to Owner
+	Created int64             `json:"created"` // Time it was stored
+	Values  map[string]string `json:"values"`  // Annotations by key
+}
+
+// DigestQuery selects digests of an owner, see SearchDigests.  All filters are
+// optional and combined.
+type DigestQuery struct {
+	Collection    string // Collection subtree, empty for all
+	From          int64  // First collection timestamp, inclusive
+	To            int64  // Last collection timestamp, inclusive, 0 if unbounded
+	Anchored      bool   // Only digests of anchored collections
+	Pending       bool   // Only digests of collections that were not anchored
+	MetadataKey   string // Only digests with this metadata key
+	MetadataValue string // Only digests with this metadata value, if set
+	Cursor        string // Cursor of the previous page, empty for the first
+	Limit         int    // Maximum number of digests
+}
+
+// DigestMatch is a digest that matched a DigestQuery.
+type DigestMatch struct {
+	Digest     [sha256.Size]byte // Digest
+	Timestamp  int64             // Collection timestamp
+	Collection string            // Name of the collection, if any
+	Anchored   bool              // Collection flushed and anchored
+	Metadata   *Metadata         // Metadata of the digest, if any
+}
+
+// CollectionStats describes what was submitted to a flushed collection and
+// how it was anchored.
+type CollectionStats struct {
+	Timestamp      int64             // Collection timestamp
+	Digests        int               // Digests that were anchored
+	Submitted      int64             // Digests submitted, including duplicates
+	Duplicates     int64             // Submitted digests that already existed
+	Clients        int               // Distinct clients that submitted digests
+	MerkleRoot     [sha256.Size]byte // Merkle root
+	Tx             chainhash.Hash    // Anchor Tx
+	BlockHeight    int32             // Block height of Tx, 0 if not confirmed
+	ChainTimestamp int64             // Anchored timestamp, 0 if not confirmed
+}
+
+// ExportRecord is a flushed collection with all of its digests and how it was
+// anchored.
+type ExportRecord struct {
+	Timestamp      int64               // Collection timestamp
+	FlushTimestamp int64               // Time the collection was flushed
+	MerkleRoot     [sha256.Size]byte   // Merkle root
+	Tx             chainhash.Hash      // Anchor Tx
+	BlockHeight    int32               // Block height of Tx, 0 if not confirmed
+	ChainTimestamp int64               // Anchored timestamp, 0 if not confirmed
+	Digests        [][sha256.Size]byte // All digests
+}
+
+// ReplicationRecord is a collection as it is replicated to a standby server.
+// Digests carry their group label and algorithm.  Flush is nil until the
+// collection was flushed.
+type ReplicationRecord struct {
+	Timestamp int64            `json:"timestamp"`          // Collection timestamp
+	Archived  bool             `json:"archived,omitempty"` // Compacted collection
+	Flush     *FlushRecordJSON `json:"flush,omitempty"`    // Flush record, if flushed
+	Digests   []DigestReceived `json:"digests"`            // All digests
+}
+
+// AnchorChain links the anchor of a collection to a published checkpoint so
+// that it can be verified without the full header history.
+type AnchorChain struct {
+	Timestamp   int64                 // Collection timestamp
+	MerkleRoot  [sha256.Size]byte     // Merkle root
+	Tx          chainhash.Hash        // Anchor Tx
+	BlockHeight int32                 // Block height of Tx
+	Checkpoint  checkpoint.Checkpoint // Nearest checkpoint at or above Tx
+	Chain       checkpoint.Chain      // Tx, its branch and the headers
+}
+
+// AuditResult is a digest that was sampled from a flushed collection by a
+// self audit.  The merkle path is derived again from the digests that are
+// stored in the collection and the anchor is looked up again, only the merkle
+// root and the anchor are taken from the flush record.
+type AuditResult struct {
+	Digest         [sha256.Size]byte // Sampled digest
+	Timestamp      int64             // Collection timestamp
+	MerkleRoot     [sha256.Size]byte // Anchored merkle root
+	MerklePath     merkle.Branch     // Auth path of Digest
+	Tx             chainhash.Hash    // Anchor Tx
+	ChainTimestamp int64             // Anchored timestamp, 0 if not confirmed
+	Confirmations  int32             // Confirmations of Tx, -1 if not found
+}
+
 // Backend interface
 type Backend interface {
 	// Return timestamp information for given digests.
@@ -154,9 +548,14 @@ type Backend interface {
 	// Return last n digests
 	LastDigests(n int32) ([]GetResult, error)
 
-	// Store hashes and return timestamp and associated errors.  Put is
-	// allowed to return transient errors.
-	Put([][sha256.Size]byte) (int64, []PutResult, error)
+	// Return timestamp information for all digests that were stored
+	// under the provided group label.
+	GetLabel(string) ([]GetResult, error)
+
+	// Store hashes under an optional group label and digest algorithm and
+	// return timestamp and associated errors.  An empty algorithm means
+	// SHA-256.  Put is allowed to return transient errors.
+	Put([][sha256.Size]byte, string, string) (int64, []PutResult, error)
 
 	// Close performs cleanup of the backend.
 	Close()
@@ -182,4 +581,237 @@ type Backend interface {
 
 	// LastAnchor retrieves last successful anchor details
 	LastAnchor() (*LastAnchorResult, error)
+
+	// PreviewWindow returns the would-be merkle root of the pending
+	// collection that digests with the provided group label are
+	// currently stored in.  The merkle root is zero if the collection has
+	// no digests yet.
+	PreviewWindow(string) (*WindowResult, error)
+
+	// WindowEnd returns the time or, when collections are anchored every
+	// n blocks, the block height at which the collection with the
+	// provided timestamp stops accepting digests and is flushed.  Both
+	// are zero if it is not known when the collection is flushed.
+	WindowEnd(int64) (int64, int32, error)
+
+	// GetAnchors returns all collections that were anchored in blocks
+	// between the provided block heights, inclusive, ordered by block
+	// height.  Anchors without enough confirmations are not returned.
+	GetAnchors(int32, int32) ([]AnchorResult, error)
+
+	// Status retrieves runtime information about the backend.  An error
+	// indicates that the backend is unhealthy.  An unreachable wallet is
+	// reported in the result instead.
+	Status() (*StatusResult, error)
+
+	// PutToken stores an api token under the digest of the token.  An
+	// existing token with the same digest is overwritten.
+	PutToken([sha256.Size]byte, APIToken) error
+
+	// GetToken returns the api token that is stored under the provided
+	// digest.  ErrTokenNotFound is returned if it does not exist.
+	GetToken([sha256.Size]byte) (*APIToken, error)
+
+	// GetTokens returns all api tokens.
+	GetTokens() ([]APIToken, error)
+
+	// UseToken increments the usage counter of the api token that is
+	// stored under the provided digest and records the provided
+	// timestamp as its last use.
+	UseToken([sha256.Size]byte, int64) error
+
+	// ChargeToken charges the provided number of digests to the usage
+	// counters of the api token that is stored under the provided digest
+	// at the provided timestamp, and returns the updated token.  Daily and
+	// monthly counters start over at UTC day and month boundaries.
+	// ErrQuotaExceeded is returned, and nothing is charged, if a quota
+	// would be exceeded.  A negative number of digests refunds digests
+	// that were charged before.
+	ChargeToken([sha256.Size]byte, int64, int64) (*APIToken, error)
+
+	// DeleteToken revokes the api token with the provided ID.
+	// ErrTokenNotFound is returned if it does not exist.
+	DeleteToken(string) error
+
+	// PutDeliveries atomically stores new webhook deliveries together with
+	// the block height up to which anchors were notified.  Existing
+	// deliveries with the same ID are overwritten.
+	PutDeliveries([]Delivery, int32) error
+
+	// GetDeliveryHeight returns the block height up to which anchors were
+	// notified or -1 if no deliveries were ever stored.
+	GetDeliveryHeight() (int32, error)
+
+	// GetDelivery returns the webhook delivery with the provided ID.
+	// ErrDeliveryNotFound is returned if it does not exist.
+	GetDelivery(string) (*Delivery, error)
+
+	// GetDeliveries returns all pending and dead webhook deliveries
+	// ordered by ID.
+	GetDeliveries() ([]Delivery, error)
+
+	// UpdateDelivery overwrites an existing webhook delivery.
+	// ErrDeliveryNotFound is returned if it does not exist.
+	UpdateDelivery(Delivery) error
+
+	// DeleteDelivery removes the webhook delivery with the provided ID.
+	// ErrDeliveryNotFound is returned if it does not exist.
+	DeleteDelivery(string) error
+
+	// PutSubscription atomically stores or replaces a webhook
+	// subscription together with the deliveries that were enqueued for
+	// it.
+	PutSubscription(Subscription, []Delivery) error
+
+	// GetSubscriptions returns all webhook subscriptions ordered by ID.
+	GetSubscriptions() ([]Subscription, error)
+
+	// DeleteSubscription atomically removes the webhook subscription with
+	// the provided ID together with storing the deliveries that were
+	// enqueued for it last.  ErrSubscriptionNotFound is returned if it
+	// does not exist.
+	DeleteSubscription(string, []Delivery) error
+
+	// PutOwner records the owner of digests that were stored in the
+	// collection with the provided timestamp.  A non-empty name becomes
+	// the name of the collection unless the owner already named it.
+	PutOwner(string, int64, string, [][sha256.Size]byte) error
+
+	// GetCollections returns all collections the owner timestamped
+	// digests in, ordered by timestamp.
+	GetCollections(string) ([]Collection, error)
+
+	// RenameCollection gives a collection of the owner a name.  An empty
+	// name removes it.  ErrCollectionNotFound is returned if the owner did
+	// not timestamp digests in the collection.
+	RenameCollection(string, int64, string) error
+
+	// GetCollectionDigests returns the digests the owner timestamped in
+	// the collection, ordered by digest.  ErrCollectionNotFound is
+	// returned if there are none.
+	GetCollectionDigests(string, int64) ([][sha256.Size]byte, error)
+
+	// SearchDigests returns up to the query limit of the digests the
+	// owner timestamped that match the provided query, ordered by
+	// collection timestamp and digest, and the cursor of the next page.
+	// The cursor is empty if no more digests match.  ErrInvalidCursor is
+	// returned if the query cursor was not returned by an earlier search.
+	SearchDigests(string, DigestQuery) ([]DigestMatch, string, error)
+
+	// DeleteCollection deletes a collection that was not anchored yet
+	// together with its digests.  All digests must belong to the owner.
+	// ErrCollectionNotFound, ErrCollectionAnchored or ErrCollectionShared
+	// are returned if this is not the case.
+	DeleteCollection(string, int64) error
+
+	// PutProofRecords appends records of served proofs to the audit
+	// trails of their digests.  Records are never modified or removed.
+	PutProofRecords([]ProofRecord) error
+
+	// GetProofRecords returns the audit trail of a digest ordered by the
+	// time the proofs were served.
+	GetProofRecords([sha256.Size]byte) ([]ProofRecord, error)
+
+	// PutMetadata stores the metadata of digests.  The metadata of a
+	// digest that already has metadata is not replaced.
+	PutMetadata([]Metadata) error
+
+	// GetMetadata returns the metadata of the provided digests in their
+	// order, nil for digests without metadata.
+	GetMetadata([][sha256.Size]byte) ([]*Metadata, error)
+
+	// PutSubmission records that the client with the provided identity
+	// submitted digests to the collection with the provided timestamp,
+	// of which the provided number of duplicates already existed.
+	PutSubmission(int64, string, int, int) error
+
+	// GetCollectionStats returns the statistics of all flushed
+	// collections with timestamps between the provided timestamps,
+	// inclusive, ordered by timestamp.
+	GetCollectionStats(int64, int64) ([]CollectionStats, error)
+
+	// ExportCollections calls the provided function with every flushed
+	// collection with a timestamp between the provided timestamps,
+	// inclusive, ordered by timestamp.  The export stops at the first
+	// error the function returns, which is returned as is.
+	ExportCollections(int64, int64, func(ExportRecord) error) error
+
+	// ReplicateCollections calls the provided function with every
+	// collection, flushed or not, with a timestamp at or after the
+	// provided timestamp, ordered by timestamp.  It stops at the first
+	// error the function returns, which is returned as is.
+	ReplicateCollections(int64, func(ReplicationRecord) error) error
+
+	// ApplyReplication stores a collection that was replicated from a
+	// primary server, replacing what was stored of it before.  Only a
+	// read-only backend accepts replicated collections.
+	ApplyReplication(ReplicationRecord) error
+
+	// GetAnchorChain links the anchor of the collection with the provided
+	// timestamp to the nearest of the provided checkpoints.
+	// ErrAnchorNotFound is returned if the collection was not anchored
+	// with enough confirmations yet and checkpoint.ErrNoCheckpoint if no
+	// checkpoint was published at or above its block yet.
+	GetAnchorChain(int64, []checkpoint.Checkpoint) (*AnchorChain, error)
+
+	// GetAnchorBlock returns the anchor of the collection with the
+	// provided timestamp, linked to the header of the block it was mined
+	// in only.  The checkpoint is not set.  ErrAnchorNotFound is returned
+	// if the collection was not anchored with enough confirmations yet.
+	GetAnchorBlock(int64) (*AnchorChain, error)
+
+	// SampleAnchored returns up to the provided number of digests that
+	// are sampled at random from flushed collections, together with the
+	// merkle paths that are derived from the stored digests and the
+	// current confirmations of their anchors.
+	SampleAnchored(int) ([]AuditResult, error)
+
+	// SetMaintenance enables or disables maintenance mode.  Digests are
+	// stored but neither flushed nor anchored while it is enabled and
+	// ErrQueueFull is returned once too many digests are pending.  The
+	// pending collections are flushed when it is disabled.
+	SetMaintenance(bool) error
+
+	// Maintenance returns true if maintenance mode is enabled.
+	Maintenance() bool
+
+	// CreateSession stores a new submission session.  Expired sessions
+	// are removed.
+	CreateSession(Session) error
+
+	// GetSession returns the submission session with the provided ID.
+	// ErrSessionNotFound is returned if it does not exist or expired.
+	GetSession(string) (*Session, error)
+
+	// AppendSession stages digests in the open submission session with
+	// the provided ID, starting at the provided offset, and extends it
+	// until the provided expiration.  Digests below the number of staged
+	// digests are skipped so that digests can be resent.
+	// ErrSessionOffset is returned if the offset is beyond the staged
+	// digests and ErrSessionFull if the session would hold too many.
+	AppendSession(string, int64, [][sha256.Size]byte, int64) (*Session, error)
+
+	// CloseSession timestamps the digests of the open submission session
+	// with the provided ID in the current collection and keeps the
+	// outcome until the provided expiration.  ErrSessionClosed is
+	// returned if it was already closed.
+	CloseSession(string, int64) (*Session, []PutResult, error)
+
+	// PutTicket stores an asynchronous submission ticket and replaces the
+	// ticket with the same ID, if any.  The ticket is on disk when
+	// PutTicket returns.  Expired tickets are removed.
+	PutTicket(Ticket) error
+
+	// GetTicket returns the ticket with the provided ID.
+	// ErrTicketNotFound is returned if it does not exist or expired.
+	GetTicket(string) (*Ticket, error)
+
+	// QueuedTickets returns up to the provided number of tickets that
+	// were not processed yet, oldest first.
+	QueuedTickets(int) ([]Ticket, error)
+
+	// Health checks that the backend storage is usable.  An error
+	// indicates that it is not.  It must not wait for a flush in progress,
+	// an unreachable wallet is reported in the result instead.
+	Health() (*HealthResult, error)
 }
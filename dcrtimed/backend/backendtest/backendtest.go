@@ -0,0 +1,968 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+// Package backendtest provides an in-memory backend.Backend for tests of code
+// that uses a backend, such as the http handlers of dcrtimed.  It needs no
+// storage and anchors through any dcrtimewallet.Wallet, testsuite.Wallet is
+// an in-memory wallet that needs no blockchain either:
+//
+//	w := testsuite.NewWallet()
+//	w.SetConfirmations(6)
+//	b := backendtest.New(w, 6)
+//	b.Put(digests, "", "")
+//	b.Flush() // Anchors the digests through w
+//
+// Digests are collected in hourly collections like the filesystem backend does,
+// but the clock only moves forward when the test calls Advance.  The backend
+// does not flush by itself either, closed collections are anchored when the
+// test calls Flush.
+//
+// Timestamping, verification, api tokens, digest metadata, collection
+// ownership, asynchronous submission tickets and maintenance mode are
+// implemented.  The other methods return ErrNotSupported.
+package backendtest
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/decred/dcrd/chaincfg/chainhash"
+	"github.com/decred/dcrtime/checkpoint"
+	"github.com/decred/dcrtime/dcrtimed/backend"
+	"github.com/decred/dcrtime/dcrtimed/dcrtimewallet"
+	"github.com/decred/dcrtime/merkle"
+)
+
+// Window is the duration of a collection.
+const Window = time.Hour
+
+// ErrNotSupported is returned by the methods that are not implemented by the
+// in-memory backend.
+var ErrNotSupported = errors.New("not supported by the in-memory backend")
+
+var _ backend.Backend = (*Backend)(nil)
+
+// digest is a stored digest.
+type digest struct {
+	timestamp int64  // Collection timestamp
+	label     string // Group label, if any
+	algorithm string // Digest algorithm, empty for SHA-256
+}
+
+// owned is the part of a collection that was timestamped by an owner.
+type owned struct {
+	name    string
+	digests map[[sha256.Size]byte]struct{}
+}
+
+// Backend is an in-memory backend.Backend.  It is safe for concurrent use.
+type Backend struct {
+	sync.Mutex
+
+	wallet        dcrtimewallet.Wallet
+	confirmations int32
+
+	now         int64                          // Current collection timestamp
+	digests     map[[sha256.Size]byte]digest   // Stored digests
+	order       [][sha256.Size]byte            // Digests in order of arrival
+	collections map[int64][][sha256.Size]byte  // Digests by collection
+	flushes     map[int64]*backend.FlushRecord // Anchored collections
+	lastFlush   int64                          // Timestamp of the last flush
+	anchorError error                          // Error of the last flush
+	tokens      map[[sha256.Size]byte]backend.APIToken
+	tickets     map[string]backend.Ticket
+	metadata    map[[sha256.Size]byte]backend.Metadata
+	owners      map[string]map[int64]*owned // Collections by owner
+	maintenance bool
+	closed      bool
+}
+
+// New returns an empty backend that anchors through the provided wallet.
+// Anchors require the provided number of confirmations before digests are
+// reported as anchored.  The first collection starts at the current hour.
+func New(wallet dcrtimewallet.Wallet, confirmations int32) *Backend {
+	return &Backend{
+		wallet:        wallet,
+		confirmations: confirmations,
+		now:           time.Now().Truncate(Window).Unix(),
+		digests:       make(map[[sha256.Size]byte]digest),
+		collections:   make(map[int64][][sha256.Size]byte),
+		flushes:       make(map[int64]*backend.FlushRecord),
+		tokens:        make(map[[sha256.Size]byte]backend.APIToken),
+		tickets:       make(map[string]backend.Ticket),
+		metadata:      make(map[[sha256.Size]byte]backend.Metadata),
+		owners:        make(map[string]map[int64]*owned),
+	}
+}
+
+// Now returns the timestamp of the collection digests are currently stored in.
+func (b *Backend) Now() int64 {
+	b.Lock()
+	defer b.Unlock()
+
+	return b.now
+}
+
+// Advance closes the current collection and starts the next one.
+func (b *Backend) Advance() {
+	b.Lock()
+	defer b.Unlock()
+
+	b.now += int64(Window / time.Second)
+}
+
+// Flush advances into the next collection and anchors all closed collections
+// that were not anchored yet, oldest first.  Nothing is anchored in
+// maintenance mode.  The error of the wallet is returned, and reported by
+// Status, if an anchor could not be constructed.
+func (b *Backend) Flush() error {
+	b.Lock()
+	defer b.Unlock()
+
+	b.now += int64(Window / time.Second)
+	return b.anchor()
+}
+
+// anchor anchors all closed collections that were not anchored yet, oldest
+// first.  Nothing is anchored in maintenance mode.
+//
+// This function must be called with the lock held.
+func (b *Backend) anchor() error {
+	if b.maintenance {
+		return nil
+	}
+
+	var pending []int64
+	for ts := range b.collections {
+		if _, ok := b.flushes[ts]; !ok && ts < b.now {
+			pending = append(pending, ts)
+		}
+	}
+	sort.Slice(pending, func(i, j int) bool {
+		return pending[i] < pending[j]
+	})
+	for _, ts := range pending {
+		hashes := make([]*[sha256.Size]byte, 0, len(b.collections[ts]))
+		for k := range b.collections[ts] {
+			hashes = append(hashes, &b.collections[ts][k])
+		}
+		root := merkle.Root(hashes)
+		tx, err := b.wallet.Construct(*root, nil)
+		b.anchorError = err
+		if err != nil {
+			return err
+		}
+		b.lastFlush = time.Now().Unix()
+		b.flushes[ts] = &backend.FlushRecord{
+			Root:            *root,
+			Hashes:          hashes,
+			Tx:              *tx,
+			FlushTimestamp:  b.lastFlush,
+			ServerTimestamp: ts,
+		}
+	}
+	return nil
+}
+
+// confirm looks up the anchor of the provided flush record until it has
+// enough confirmations.  The chain timestamp and block height of the record
+// are set once it does, otherwise the confirmations are returned.
+//
+// This function must be called with the lock held.
+func (b *Backend) confirm(fr *backend.FlushRecord) (*int32, error) {
+	if fr.ChainTimestamp != 0 {
+		return nil, nil
+	}
+	r, err := b.wallet.Lookup(fr.Tx)
+	if err != nil {
+		return nil, err
+	}
+	if r.Confirmations < b.confirmations {
+		confirmations := r.Confirmations
+		return &confirmations, nil
+	}
+	fr.ChainTimestamp = r.Timestamp
+	fr.BlockHeight = r.BlockHeight
+	return nil, nil
+}
+
+// get returns the timestamp information of a digest.
+//
+// This function must be called with the lock held.
+func (b *Backend) get(d [sha256.Size]byte) (backend.GetResult, error) {
+	gr := backend.GetResult{
+		Digest:    d,
+		ErrorCode: backend.ErrorNotFound,
+	}
+	v, ok := b.digests[d]
+	if !ok {
+		return gr, nil
+	}
+	gr.ErrorCode = backend.ErrorOK
+	gr.Timestamp = v.timestamp
+	gr.Label = v.label
+	gr.Algorithm = v.algorithm
+
+	fr, ok := b.flushes[v.timestamp]
+	if !ok {
+		return gr, nil
+	}
+	confirmations, err := b.confirm(fr)
+	if err != nil {
+		return gr, err
+	}
+	if confirmations != nil {
+		gr.Confirmations = confirmations
+		gr.MinConfirmations = b.confirmations
+	}
+	gr.Tx = fr.Tx
+	gr.MerkleRoot = fr.Root
+	gr.MerklePath = *merkle.AuthPath(fr.Hashes, &d)
+	gr.FlushTimestamp = fr.FlushTimestamp
+	gr.AnchoredTimestamp = fr.ChainTimestamp
+	gr.BlockHeight = fr.BlockHeight
+	return gr, nil
+}
+
+// Get satisfies the backend.Backend interface.
+func (b *Backend) Get(digests [][sha256.Size]byte) ([]backend.GetResult, error) {
+	b.Lock()
+	defer b.Unlock()
+
+	grs := make([]backend.GetResult, 0, len(digests))
+	for _, d := range digests {
+		gr, err := b.get(d)
+		if err != nil {
+			return nil, err
+		}
+		grs = append(grs, gr)
+	}
+	return grs, nil
+}
+
+// GetTimestamps satisfies the backend.Backend interface.
+func (b *Backend) GetTimestamps(timestamps []int64) ([]backend.TimestampResult, error) {
+	b.Lock()
+	defer b.Unlock()
+
+	trs := make([]backend.TimestampResult, 0, len(timestamps))
+	for _, ts := range timestamps {
+		tr := backend.TimestampResult{
+			Timestamp: ts,
+			ErrorCode: backend.ErrorNotFound,
+		}
+		digests, ok := b.collections[ts]
+		if !ok {
+			trs = append(trs, tr)
+			continue
+		}
+		tr.ErrorCode = backend.ErrorOK
+		tr.Digests = append([][sha256.Size]byte(nil), digests...)
+		if fr, ok := b.flushes[ts]; ok {
+			confirmations, err := b.confirm(fr)
+			if err != nil {
+				return nil, err
+			}
+			if confirmations != nil {
+				tr.Confirmations = confirmations
+				tr.MinConfirmations = b.confirmations
+			}
+			tr.Tx = fr.Tx
+			tr.MerkleRoot = fr.Root
+			tr.FlushTimestamp = fr.FlushTimestamp
+			tr.AnchoredTimestamp = fr.ChainTimestamp
+		}
+		trs = append(trs, tr)
+	}
+	return trs, nil
+}
+
+// LastDigests satisfies the backend.Backend interface.  The newest digest is
+// returned first.
+func (b *Backend) LastDigests(n int32) ([]backend.GetResult, error) {
+	b.Lock()
+	defer b.Unlock()
+
+	var grs []backend.GetResult
+	for i := len(b.order) - 1; i >= 0 && len(grs) < int(n); i-- {
+		gr, err := b.get(b.order[i])
+		if err != nil {
+			return nil, err
+		}
+		grs = append(grs, gr)
+	}
+	return grs, nil
+}
+
+// GetLabel satisfies the backend.Backend interface.
+func (b *Backend) GetLabel(label string) ([]backend.GetResult, error) {
+	b.Lock()
+	defer b.Unlock()
+
+	var grs []backend.GetResult
+	for _, d := range b.order {
+		if b.digests[d].label != label {
+			continue
+		}
+		gr, err := b.get(d)
+		if err != nil {
+			return nil, err
+		}
+		grs = append(grs, gr)
+	}
+	return grs, nil
+}
+
+// Put satisfies the backend.Backend interface.  Digests are stored in the
+// current collection.
+func (b *Backend) Put(hashes [][sha256.Size]byte, label, algorithm string) (int64, []backend.PutResult, error) {
+	b.Lock()
+	defer b.Unlock()
+
+	prs := make([]backend.PutResult, 0, len(hashes))
+	for _, d := range hashes {
+		pr := backend.PutResult{
+			Digest:    d,
+			ErrorCode: backend.ErrorOK,
+		}
+		if _, ok := b.digests[d]; ok {
+			pr.ErrorCode = backend.ErrorExists
+			prs = append(prs, pr)
+			continue
+		}
+		b.digests[d] = digest{
+			timestamp: b.now,
+			label:     label,
+			algorithm: algorithm,
+		}
+		b.order = append(b.order, d)
+		b.collections[b.now] = append(b.collections[b.now], d)
+		prs = append(prs, pr)
+	}
+	return b.now, prs, nil
+}
+
+// Close satisfies the backend.Backend interface.
+func (b *Backend) Close() {
+	b.Lock()
+	defer b.Unlock()
+
+	b.closed = true
+}
+
+// Closed returns whether the backend was closed.
+func (b *Backend) Closed() bool {
+	b.Lock()
+	defer b.Unlock()
+
+	return b.closed
+}
+
+// Dump satisfies the backend.Backend interface.  It is not supported.
+func (b *Backend) Dump(*os.File, bool) error {
+	return ErrNotSupported
+}
+
+// Restore satisfies the backend.Backend interface.  It is not supported.
+func (b *Backend) Restore(*os.File, bool, string) error {
+	return ErrNotSupported
+}
+
+// Fsck satisfies the backend.Backend interface.  It is not supported.
+func (b *Backend) Fsck(*backend.FsckOptions) error {
+	return ErrNotSupported
+}
+
+// GetBalance satisfies the backend.Backend interface.
+func (b *Backend) GetBalance() (*backend.GetBalanceResult, error) {
+	br, err := b.wallet.GetWalletBalance()
+	if err != nil {
+		return nil, err
+	}
+	return &backend.GetBalanceResult{
+		Total:       br.Total,
+		Spendable:   br.Spendable,
+		Unconfirmed: br.Unconfirmed,
+	}, nil
+}
+
+// LastAnchor satisfies the backend.Backend interface.  Like the filesystem
+// backend, the newest anchor is returned whether or not it has enough
+// confirmations, the chain timestamp is only set once it does.
+func (b *Backend) LastAnchor() (*backend.LastAnchorResult, error) {
+	b.Lock()
+	defer b.Unlock()
+
+	var fr *backend.FlushRecord
+	for _, v := range b.flushes {
+		if fr == nil || v.ServerTimestamp > fr.ServerTimestamp {
+			fr = v
+		}
+	}
+	if fr == nil {
+		return &backend.LastAnchorResult{}, nil
+	}
+	if _, err := b.confirm(fr); err != nil {
+		return nil, err
+	}
+	r, err := b.wallet.Lookup(fr.Tx)
+	if err != nil {
+		return nil, err
+	}
+	return &backend.LastAnchorResult{
+		ChainTimestamp: fr.ChainTimestamp,
+		Tx:             fr.Tx,
+		BlockHash:      r.BlockHash.String(),
+		BlockHeight:    r.BlockHeight,
+	}, nil
+}
+
+// PreviewWindow satisfies the backend.Backend interface.  All digests share
+// the current collection regardless of their group label.
+func (b *Backend) PreviewWindow(label string) (*backend.WindowResult, error) {
+	b.Lock()
+	defer b.Unlock()
+
+	wr := &backend.WindowResult{
+		Timestamp: b.now,
+		Ends:      b.now + int64(Window/time.Second),
+		Digests:   len(b.collections[b.now]),
+	}
+	if wr.Digests != 0 {
+		hashes := make([]*[sha256.Size]byte, 0, wr.Digests)
+		for k := range b.collections[b.now] {
+			hashes = append(hashes, &b.collections[b.now][k])
+		}
+		wr.MerkleRoot = *merkle.Root(hashes)
+	}
+	return wr, nil
+}
+
+// WindowEnd satisfies the backend.Backend interface.
+func (b *Backend) WindowEnd(timestamp int64) (int64, int32, error) {
+	return timestamp + int64(Window/time.Second), 0, nil
+}
+
+// GetAnchors satisfies the backend.Backend interface.  It is not supported.
+func (b *Backend) GetAnchors(int32, int32) ([]backend.AnchorResult, error) {
+	return nil, ErrNotSupported
+}
+
+// Status satisfies the backend.Backend interface.
+func (b *Backend) Status() (*backend.StatusResult, error) {
+	b.Lock()
+	defer b.Unlock()
+
+	sr := &backend.StatusResult{
+		LastFlushTimestamp: b.lastFlush,
+		Maintenance:        b.maintenance,
+		AnchorError:        b.anchorError,
+	}
+	for ts, digests := range b.collections {
+		if _, ok := b.flushes[ts]; !ok {
+			sr.PendingDigests += int64(len(digests))
+		}
+	}
+	var last *backend.FlushRecord
+	for _, fr := range b.flushes {
+		if last == nil || fr.ServerTimestamp > last.ServerTimestamp {
+			last = fr
+		}
+	}
+	if last != nil {
+		sr.LastFlushTx = last.Tx
+		sr.LastFlushChainTimestamp = last.ChainTimestamp
+	}
+	br, err := b.wallet.GetWalletBalance()
+	if err != nil {
+		sr.WalletError = err
+	} else {
+		sr.Balance = &backend.GetBalanceResult{
+			Total:       br.Total,
+			Spendable:   br.Spendable,
+			Unconfirmed: br.Unconfirmed,
+		}
+	}
+	return sr, nil
+}
+
+// PutToken satisfies the backend.Backend interface.
+func (b *Backend) PutToken(hash [sha256.Size]byte, token backend.APIToken) error {
+	b.Lock()
+	defer b.Unlock()
+
+	b.tokens[hash] = token
+	return nil
+}
+
+// GetToken satisfies the backend.Backend interface.
+func (b *Backend) GetToken(hash [sha256.Size]byte) (*backend.APIToken, error) {
+	b.Lock()
+	defer b.Unlock()
+
+	token, ok := b.tokens[hash]
+	if !ok {
+		return nil, backend.ErrTokenNotFound
+	}
+	return &token, nil
+}
+
+// GetTokens satisfies the backend.Backend interface.  Tokens are sorted by
+// creation time.
+func (b *Backend) GetTokens() ([]backend.APIToken, error) {
+	b.Lock()
+	defer b.Unlock()
+
+	tokens := make([]backend.APIToken, 0, len(b.tokens))
+	for _, token := range b.tokens {
+		tokens = append(tokens, token)
+	}
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokens[i].Created < tokens[j].Created
+	})
+	return tokens, nil
+}
+
+// UseToken satisfies the backend.Backend interface.
+func (b *Backend) UseToken(hash [sha256.Size]byte, ts int64) error {
+	b.Lock()
+	defer b.Unlock()
+
+	token, ok := b.tokens[hash]
+	if !ok {
+		return backend.ErrTokenNotFound
+	}
+	token.Uses++
+	token.LastUsed = ts
+	b.tokens[hash] = token
+	return nil
+}
+
+// ChargeToken satisfies the backend.Backend interface.
+func (b *Backend) ChargeToken(hash [sha256.Size]byte, digests, ts int64) (*backend.APIToken, error) {
+	b.Lock()
+	defer b.Unlock()
+
+	token, ok := b.tokens[hash]
+	if !ok {
+		return nil, backend.ErrTokenNotFound
+	}
+	token.RollUsage(ts)
+	if digests > 0 {
+		if token.DailyQuota != 0 &&
+			token.DayDigests+digests > token.DailyQuota {
+			return nil, backend.ErrQuotaExceeded
+		}
+		if token.MonthlyQuota != 0 &&
+			token.MonthDigests+digests > token.MonthlyQuota {
+			return nil, backend.ErrQuotaExceeded
+		}
+	}
+	token.DayDigests += digests
+	if token.DayDigests < 0 {
+		token.DayDigests = 0
+	}
+	token.MonthDigests += digests
+	if token.MonthDigests < 0 {
+		token.MonthDigests = 0
+	}
+	switch {
+	case digests >= 0:
+		token.Digests += uint64(digests)
+	case uint64(-digests) < token.Digests:
+		token.Digests -= uint64(-digests)
+	default:
+		token.Digests = 0
+	}
+	b.tokens[hash] = token
+	return &token, nil
+}
+
+// DeleteToken satisfies the backend.Backend interface.
+func (b *Backend) DeleteToken(id string) error {
+	b.Lock()
+	defer b.Unlock()
+
+	for hash, token := range b.tokens {
+		if token.ID == id {
+			delete(b.tokens, hash)
+			return nil
+		}
+	}
+	return backend.ErrTokenNotFound
+}
+
+// PutDeliveries satisfies the backend.Backend interface.  It is not
+// supported.
+func (b *Backend) PutDeliveries([]backend.Delivery, int32) error {
+	return ErrNotSupported
+}
+
+// GetDeliveryHeight satisfies the backend.Backend interface.  It is not
+// supported.
+func (b *Backend) GetDeliveryHeight() (int32, error) {
+	return 0, ErrNotSupported
+}
+
+// GetDelivery satisfies the backend.Backend interface.  It is not supported.
+func (b *Backend) GetDelivery(string) (*backend.Delivery, error) {
+	return nil, ErrNotSupported
+}
+
+// GetDeliveries satisfies the backend.Backend interface.  It is not
+// supported.
+func (b *Backend) GetDeliveries() ([]backend.Delivery, error) {
+	return nil, ErrNotSupported
+}
+
+// UpdateDelivery satisfies the backend.Backend interface.  It is not
+// supported.
+func (b *Backend) UpdateDelivery(backend.Delivery) error {
+	return ErrNotSupported
+}
+
+// DeleteDelivery satisfies the backend.Backend interface.  It is not
+// supported.
+func (b *Backend) DeleteDelivery(string) error {
+	return ErrNotSupported
+}
+
+// PutSubscription satisfies the backend.Backend interface.  It is not
+// supported.
+func (b *Backend) PutSubscription(backend.Subscription, []backend.Delivery) error {
+	return ErrNotSupported
+}
+
+// GetSubscriptions satisfies the backend.Backend interface.  It is not
+// supported.
+func (b *Backend) GetSubscriptions() ([]backend.Subscription, error) {
+	return nil, ErrNotSupported
+}
+
+// DeleteSubscription satisfies the backend.Backend interface.  It is not
+// supported.
+func (b *Backend) DeleteSubscription(string, []backend.Delivery) error {
+	return ErrNotSupported
+}
+
+// PutOwner satisfies the backend.Backend interface.
+func (b *Backend) PutOwner(owner string, ts int64, name string, digests [][sha256.Size]byte) error {
+	b.Lock()
+	defer b.Unlock()
+
+	collections, ok := b.owners[owner]
+	if !ok {
+		collections = make(map[int64]*owned)
+		b.owners[owner] = collections
+	}
+	o, ok := collections[ts]
+	if !ok {
+		o = &owned{
+			digests: make(map[[sha256.Size]byte]struct{}),
+		}
+		collections[ts] = o
+	}
+	for _, d := range digests {
+		o.digests[d] = struct{}{}
+	}
+	if o.name == "" {
+		o.name = name
+	}
+	return nil
+}
+
+// GetCollections satisfies the backend.Backend interface.
+func (b *Backend) GetCollections(owner string) ([]backend.Collection, error) {
+	b.Lock()
+	defer b.Unlock()
+
+	collections := make([]backend.Collection, 0, len(b.owners[owner]))
+	for ts, o := range b.owners[owner] {
+		_, anchored := b.flushes[ts]
+		collections = append(collections, backend.Collection{
+			Timestamp: ts,
+			Name:      o.name,
+			Digests:   len(o.digests),
+			Anchored:  anchored,
+		})
+	}
+	sort.Slice(collections, func(i, j int) bool {
+		return collections[i].Timestamp < collections[j].Timestamp
+	})
+	return collections, nil
+}
+
+// RenameCollection satisfies the backend.Backend interface.
+func (b *Backend) RenameCollection(owner string, ts int64, name string) error {
+	b.Lock()
+	defer b.Unlock()
+
+	o, ok := b.owners[owner][ts]
+	if !ok {
+		return backend.ErrCollectionNotFound
+	}
+	o.name = name
+	return nil
+}
+
+// GetCollectionDigests satisfies the backend.Backend interface.
+func (b *Backend) GetCollectionDigests(owner string, ts int64) ([][sha256.Size]byte, error) {
+	b.Lock()
+	defer b.Unlock()
+
+	o, ok := b.owners[owner][ts]
+	if !ok {
+		return nil, backend.ErrCollectionNotFound
+	}
+	digests := make([][sha256.Size]byte, 0, len(o.digests))
+	for d := range o.digests {
+		digests = append(digests, d)
+	}
+	sort.Slice(digests, func(i, j int) bool {
+		return bytes.Compare(digests[i][:], digests[j][:]) < 0
+	})
+	return digests, nil
+}
+
+// SearchDigests satisfies the backend.Backend interface.  It is not
+// supported.
+func (b *Backend) SearchDigests(string, backend.DigestQuery) ([]backend.DigestMatch, string, error) {
+	return nil, "", ErrNotSupported
+}
+
+// DeleteCollection satisfies the backend.Backend interface.
+func (b *Backend) DeleteCollection(owner string, ts int64) error {
+	b.Lock()
+	defer b.Unlock()
+
+	o, ok := b.owners[owner][ts]
+	if !ok {
+		return backend.ErrCollectionNotFound
+	}
+	if _, ok := b.flushes[ts]; ok {
+		return backend.ErrCollectionAnchored
+	}
+	for _, d := range b.collections[ts] {
+		if _, ok := o.digests[d]; !ok {
+			return backend.ErrCollectionShared
+		}
+	}
+
+	for _, d := range b.collections[ts] {
+		delete(b.digests, d)
+		delete(b.metadata, d)
+	}
+	order := b.order[:0]
+	for _, d := range b.order {
+		if _, ok := b.digests[d]; ok {
+			order = append(order, d)
+		}
+	}
+	b.order = order
+	delete(b.collections, ts)
+	delete(b.owners[owner], ts)
+	return nil
+}
+
+// PutProofRecords satisfies the backend.Backend interface.  It is not
+// supported.
+func (b *Backend) PutProofRecords([]backend.ProofRecord) error {
+	return ErrNotSupported
+}
+
+// GetProofRecords satisfies the backend.Backend interface.  It is not
+// supported.
+func (b *Backend) GetProofRecords([sha256.Size]byte) ([]backend.ProofRecord, error) {
+	return nil, ErrNotSupported
+}
+
+// PutMetadata satisfies the backend.Backend interface.
+func (b *Backend) PutMetadata(mds []backend.Metadata) error {
+	b.Lock()
+	defer b.Unlock()
+
+	for _, md := range mds {
+		if _, ok := b.metadata[md.Digest]; !ok {
+			b.metadata[md.Digest] = md
+		}
+	}
+	return nil
+}
+
+// GetMetadata satisfies the backend.Backend interface.
+func (b *Backend) GetMetadata(digests [][sha256.Size]byte) ([]*backend.Metadata, error) {
+	b.Lock()
+	defer b.Unlock()
+
+	mds := make([]*backend.Metadata, 0, len(digests))
+	for _, d := range digests {
+		md, ok := b.metadata[d]
+		if !ok {
+			mds = append(mds, nil)
+			continue
+		}
+		mds = append(mds, &md)
+	}
+	return mds, nil
+}
+
+// PutSubmission satisfies the backend.Backend interface.  Submissions are not
+// recorded.
+func (b *Backend) PutSubmission(int64, string, int, int) error {
+	return nil
+}
+
+// GetCollectionStats satisfies the backend.Backend interface.  It is not
+// supported.
+func (b *Backend) GetCollectionStats(int64, int64) ([]backend.CollectionStats, error) {
+	return nil, ErrNotSupported
+}
+
+// ExportCollections satisfies the backend.Backend interface.  It is not
+// supported.
+func (b *Backend) ExportCollections(int64, int64, func(backend.ExportRecord) error) error {
+	return ErrNotSupported
+}
+
+// ReplicateCollections satisfies the backend.Backend interface.  It is not
+// supported.
+func (b *Backend) ReplicateCollections(int64, func(backend.ReplicationRecord) error) error {
+	return ErrNotSupported
+}
+
+// ApplyReplication satisfies the backend.Backend interface.  It is not
+// supported.
+func (b *Backend) ApplyReplication(backend.ReplicationRecord) error {
+	return ErrNotSupported
+}
+
+// GetAnchorChain satisfies the backend.Backend interface.  It is not
+// supported.
+func (b *Backend) GetAnchorChain(int64, []checkpoint.Checkpoint) (*backend.AnchorChain, error) {
+	return nil, ErrNotSupported
+}
+
+// GetAnchorBlock satisfies the backend.Backend interface.  It is not
+// supported.
+func (b *Backend) GetAnchorBlock(int64) (*backend.AnchorChain, error) {
+	return nil, ErrNotSupported
+}
+
+// SampleAnchored satisfies the backend.Backend interface.  It is not
+// supported.
+func (b *Backend) SampleAnchored(int) ([]backend.AuditResult, error) {
+	return nil, ErrNotSupported
+}
+
+// SetMaintenance satisfies the backend.Backend interface.
+func (b *Backend) SetMaintenance(maintenance bool) error {
+	b.Lock()
+	defer b.Unlock()
+
+	b.maintenance = maintenance
+	return nil
+}
+
+// Maintenance satisfies the backend.Backend interface.
+func (b *Backend) Maintenance() bool {
+	b.Lock()
+	defer b.Unlock()
+
+	return b.maintenance
+}
+
+// CreateSession satisfies the backend.Backend interface.  It is not
+// supported.
+func (b *Backend) CreateSession(backend.Session) error {
+	return ErrNotSupported
+}
+
+// GetSession satisfies the backend.Backend interface.  It is not supported.
+func (b *Backend) GetSession(string) (*backend.Session, error) {
+	return nil, ErrNotSupported
+}
+
+// AppendSession satisfies the backend.Backend interface.  It is not
+// supported.
+func (b *Backend) AppendSession(string, int64, [][sha256.Size]byte, int64) (*backend.Session, error) {
+	return nil, ErrNotSupported
+}
+
+// CloseSession satisfies the backend.Backend interface.  It is not
+// supported.
+func (b *Backend) CloseSession(string, int64) (*backend.Session, []backend.PutResult, error) {
+	return nil, nil, ErrNotSupported
+}
+
+// PutTicket satisfies the backend.Backend interface.  Expired tickets are
+// kept until they are replaced.
+func (b *Backend) PutTicket(t backend.Ticket) error {
+	b.Lock()
+	defer b.Unlock()
+
+	b.tickets[t.ID] = t
+	return nil
+}
+
+// GetTicket satisfies the backend.Backend interface.
+func (b *Backend) GetTicket(id string) (*backend.Ticket, error) {
+	b.Lock()
+	defer b.Unlock()
+
+	t, ok := b.tickets[id]
+	if !ok || (t.Expires != 0 && time.Now().Unix() >= t.Expires) {
+		return nil, backend.ErrTicketNotFound
+	}
+	return &t, nil
+}
+
+// QueuedTickets satisfies the backend.Backend interface.
+func (b *Backend) QueuedTickets(n int) ([]backend.Ticket, error) {
+	b.Lock()
+	defer b.Unlock()
+
+	var tickets []backend.Ticket
+	for _, t := range b.tickets {
+		if t.Processed == 0 {
+			tickets = append(tickets, t)
+		}
+	}
+	sort.Slice(tickets, func(i, j int) bool {
+		if tickets[i].Created != tickets[j].Created {
+			return tickets[i].Created < tickets[j].Created
+		}
+		return tickets[i].ID < tickets[j].ID
+	})
+	if len(tickets) > n {
+		tickets = tickets[:n]
+	}
+	return tickets, nil
+}
+
+// Health satisfies the backend.Backend interface.  The wallet is reported
+// unreachable if it fails to return the best block.
+func (b *Backend) Health() (*backend.HealthResult, error) {
+	hr := &backend.HealthResult{}
+	if _, err := b.wallet.BestHeight(); err != nil {
+		hr.WalletError = err
+	}
+	return hr, nil
+}
+
+// Tx returns the anchor of the collection with the provided timestamp, it is
+// zero if the collection was not anchored.
+func (b *Backend) Tx(timestamp int64) chainhash.Hash {
+	b.Lock()
+	defer b.Unlock()
+
+	if fr, ok := b.flushes[timestamp]; ok {
+		return fr.Tx
+	}
+	return chainhash.Hash{}
+}
@@ -0,0 +1,166 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package backendtest
+
+import (
+	"crypto/sha256"
+	"errors"
+	"testing"
+
+	"github.com/decred/dcrtime/dcrtimed/backend"
+	"github.com/decred/dcrtime/dcrtimed/backend/testsuite"
+	"github.com/decred/dcrtime/merkle"
+)
+
+func TestAnchor(t *testing.T) {
+	w := testsuite.NewWallet()
+	b := New(w, 2)
+
+	var digests [][sha256.Size]byte
+	for i := 0; i < 5; i++ {
+		digests = append(digests, [sha256.Size]byte{byte(i + 1)})
+	}
+	ts, prs, err := b.Put(digests, "label", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, pr := range prs {
+		if pr.ErrorCode != backend.ErrorOK {
+			t.Fatalf("put %x: %v", pr.Digest, pr.ErrorCode)
+		}
+	}
+	_, prs, err = b.Put(digests[:1], "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prs[0].ErrorCode != backend.ErrorExists {
+		t.Fatalf("duplicate: got %v", prs[0].ErrorCode)
+	}
+
+	// Pending digests are known but not anchored.
+	grs, err := b.Get(append(digests[:1:1], [sha256.Size]byte{0xff}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if grs[0].ErrorCode != backend.ErrorOK || grs[0].Timestamp != ts ||
+		grs[0].MerkleRoot != ([sha256.Size]byte{}) {
+		t.Fatalf("pending: %+v", grs[0])
+	}
+	if grs[1].ErrorCode != backend.ErrorNotFound {
+		t.Fatalf("unknown: %+v", grs[1])
+	}
+
+	if err := b.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if w.Anchors() != 1 {
+		t.Fatalf("anchors: got %v, want 1", w.Anchors())
+	}
+
+	// Anchored digests report their confirmations until they have enough.
+	w.SetConfirmations(1)
+	grs, err = b.Get(digests)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, gr := range grs {
+		if gr.Confirmations == nil || *gr.Confirmations != 1 ||
+			gr.MinConfirmations != 2 || gr.AnchoredTimestamp != 0 {
+			t.Fatalf("unconfirmed: %+v", gr)
+		}
+	}
+
+	w.SetConfirmations(2)
+	grs, err = b.Get(digests)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, gr := range grs {
+		if gr.Confirmations != nil || gr.AnchoredTimestamp == 0 {
+			t.Fatalf("confirmed: %+v", gr)
+		}
+		if gr.Tx != b.Tx(ts) {
+			t.Fatalf("tx: got %v, want %v", gr.Tx, b.Tx(ts))
+		}
+		root, err := merkle.VerifyAuthPath(&gr.MerklePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if *root != gr.MerkleRoot {
+			t.Fatalf("root: got %x, want %x", *root, gr.MerkleRoot)
+		}
+	}
+
+	grs, err = b.GetLabel("label")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(grs) != len(digests) {
+		t.Fatalf("label: got %v digests, want %v", len(grs),
+			len(digests))
+	}
+}
+
+func TestMaintenance(t *testing.T) {
+	w := testsuite.NewWallet()
+	b := New(w, 1)
+
+	if _, _, err := b.Put([][sha256.Size]byte{{1}}, "", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.SetMaintenance(true); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if w.Anchors() != 0 {
+		t.Fatalf("maintenance: got %v anchors", w.Anchors())
+	}
+	if err := b.SetMaintenance(false); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if w.Anchors() != 1 {
+		t.Fatalf("got %v anchors, want 1", w.Anchors())
+	}
+}
+
+func TestTokens(t *testing.T) {
+	b := New(testsuite.NewWallet(), 1)
+
+	hash := sha256.Sum256([]byte("token"))
+	err := b.PutToken(hash, backend.APIToken{
+		ID:         "id",
+		DailyQuota: 10,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := b.ChargeToken(hash, 8, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.DayDigests != 8 || token.Digests != 8 {
+		t.Fatalf("charge: %+v", token)
+	}
+	_, err = b.ChargeToken(hash, 3, 1)
+	if !errors.Is(err, backend.ErrQuotaExceeded) {
+		t.Fatalf("quota: got %v", err)
+	}
+	if err := b.DeleteToken("id"); err != nil {
+		t.Fatal(err)
+	}
+	_, err = b.GetToken(hash)
+	if !errors.Is(err, backend.ErrTokenNotFound) {
+		t.Fatalf("deleted: got %v", err)
+	}
+
+	if err := b.Dump(nil, false); !errors.Is(err, ErrNotSupported) {
+		t.Fatalf("dump: got %v", err)
+	}
+}
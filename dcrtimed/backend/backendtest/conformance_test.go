@@ -0,0 +1,78 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package backendtest
+
+import (
+	"testing"
+
+	"github.com/decred/dcrtime/dcrtimed/backend"
+	"github.com/decred/dcrtime/dcrtimed/backend/testsuite"
+)
+
+// harness keeps the in-memory backend across restarts, which is what the
+// storage of a persistent backend does.
+type harness struct {
+	wallet *testsuite.Wallet
+	b      *Backend
+}
+
+// Supports returns whether the in-memory backend implements the features of
+// the conformance test.  Everything else returns ErrNotSupported.
+func (h *harness) Supports(test string) bool {
+	switch test {
+	case "PutGet", "DuplicateDigests", "WindowRollover", "Flush",
+		"FlushDuringReads", "GetTimestamps", "LastDigests", "GetLabel",
+		"Algorithm", "PreviewWindow", "LastAnchor", "GetBalance",
+		"Status", "Tokens", "Quota", "Metadata", "Collections",
+		"Tickets", "CrashRecovery":
+		return true
+	}
+	return false
+}
+
+func (h *harness) Open(t *testing.T) backend.Backend {
+	if h.b == nil {
+		h.b = New(h.wallet, 6)
+	}
+	h.b.Lock()
+	h.b.closed = false
+	err := h.b.anchor()
+	h.b.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h.b
+}
+
+// OpenRestore is not called, DumpRestore is not supported.
+func (h *harness) OpenRestore(t *testing.T) backend.Backend {
+	t.Fatal("restore is not supported")
+	return nil
+}
+
+// OpenStandby is not called, Replication is not supported.
+func (h *harness) OpenStandby(t *testing.T) backend.Backend {
+	t.Fatal("replication is not supported")
+	return nil
+}
+
+func (h *harness) Advance(t *testing.T) {
+	h.b.Advance()
+}
+
+func (h *harness) Flush(t *testing.T) {
+	h.b.Lock()
+	defer h.b.Unlock()
+
+	if err := h.b.anchor(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConformance(t *testing.T) {
+	testsuite.Run(t, func(t *testing.T, w *testsuite.Wallet) testsuite.Harness {
+		return &harness{wallet: w}
+	})
+}
@@ -0,0 +1,153 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package filesystem
+
+import (
+	"crypto/sha256"
+	"errors"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/decred/dcrtime/dcrtimed/backend"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// anchorHeight returns the block height of the anchor of the provided flush
+// record.  Flush records that predate the block height, or whose anchor was
+// not confirmed yet, are updated through the wallet.  Compacted containers are
+// looked up but not updated since their flush records are immutable.  Zero is
+// returned for anchors without enough confirmations.
+//
+// Must be called with the READ lock held.
+func (fs *FileSystem) anchorHeight(ts int64, fr *backend.FlushRecord, archived bool) (int32, error) {
+	if fr.ChainTimestamp != 0 && fr.BlockHeight != 0 {
+		return fr.BlockHeight, nil
+	}
+
+	if archived {
+		res, err := fs.wallet.Lookup(fr.Tx)
+		if err != nil {
+			return 0, err
+		}
+		if res.Confirmations < fs.confirmations {
+			return 0, nil
+		}
+		return res.BlockHeight, nil
+	}
+
+	_, err := fs.lazyFlush(ts, fr)
+	switch {
+	case errors.Is(err, errNotEnoughConfirmation):
+		return 0, nil
+	case errors.Is(err, errInvalidConfirmations):
+		// Do not let a single bad anchor fail the entire query.
+		log.Errorf("%v: Confirmations = -1", fr.Tx)
+		return 0, nil
+	case err != nil:
+		return 0, err
+	}
+
+	return fr.BlockHeight, nil
+}
+
+// containers returns the timestamps of all regular and compacted containers.
+// The value of a timestamp is true if its container was compacted.
+//
+// Must be called with the READ lock held.
+func (fs *FileSystem) containers() (map[int64]bool, error) {
+	files, err := os.ReadDir(fs.root)
+	if err != nil {
+		return nil, err
+	}
+	archived := make(map[int64]bool, len(files))
+	for _, file := range files {
+		if !file.IsDir() || isReserved(file.Name()) {
+			continue
+		}
+		t, err := time.Parse(fStr, file.Name())
+		if err != nil {
+			continue
+		}
+		archived[t.Unix()] = false
+	}
+	epochs, err := fs.epochs()
+	if err != nil {
+		return nil, err
+	}
+	for _, ep := range epochs {
+		timestamps, err := fs.archivedTimestamps(ep)
+		if err != nil {
+			return nil, err
+		}
+		for _, ts := range timestamps {
+			archived[ts] = true
+		}
+	}
+
+	return archived, nil
+}
+
+// GetAnchors returns all collections that were anchored in blocks between the
+// provided block heights, inclusive.  Both regular and compacted containers
+// are searched.  This call satisfies the backend interface.
+func (fs *FileSystem) GetAnchors(from, to int32) ([]backend.AnchorResult, error) {
+	fs.RLock()
+	defer fs.RUnlock()
+
+	archived, err := fs.containers()
+	if err != nil {
+		return nil, err
+	}
+
+	anchors := make([]backend.AnchorResult, 0, 64)
+	for ts, isArchived := range archived {
+		var fr *backend.FlushRecord
+		if isArchived {
+			fr, err = fs.archivedFlushRecord(ts)
+		} else {
+			fr, err = fs.flushRecord(ts)
+		}
+		if errors.Is(err, leveldb.ErrNotFound) {
+			// Not flushed yet.
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		height, err := fs.anchorHeight(ts, fr, isArchived)
+		if err != nil {
+			return nil, err
+		}
+		if height == 0 || height < from || height > to {
+			continue
+		}
+
+		ar := backend.AnchorResult{
+			Timestamp:      ts,
+			Tx:             fr.Tx,
+			MerkleRoot:     fr.Root,
+			BlockHeight:    height,
+			ChainTimestamp: fr.ChainTimestamp,
+			Digests:        make([][sha256.Size]byte, 0, len(fr.Hashes)),
+		}
+		for _, ph := range fr.Hashes {
+			if ph == nil {
+				continue
+			}
+			ar.Digests = append(ar.Digests, *ph)
+		}
+		anchors = append(anchors, ar)
+	}
+
+	sort.Slice(anchors, func(i, j int) bool {
+		if anchors[i].BlockHeight != anchors[j].BlockHeight {
+			return anchors[i].BlockHeight < anchors[j].BlockHeight
+		}
+		return anchors[i].Timestamp < anchors[j].Timestamp
+	})
+
+	return anchors, nil
+}
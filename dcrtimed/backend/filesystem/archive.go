@@ -0,0 +1,459 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package filesystem
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/decred/dcrtime/dcrtimed/backend"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+const (
+	// archiveDir is the directory that contains the compacted archives.
+	// There is one archive database per epoch.
+	archiveDir = "archive"
+
+	// epochFormat is the name of an epoch, which is a calendar month in
+	// UTC.
+	epochFormat = "200601"
+
+	// archivePrefix prefixes the flush record keys in an archive.  The
+	// prefix is followed by the container directory name.
+	archivePrefix = flushedKey + "."
+)
+
+// CompactOptions provides options on how to compact the filesystem backend.
+type CompactOptions struct {
+	Before  time.Time // Only compact containers older than this
+	Fix     bool      // Compact and prune, otherwise only report
+	Verbose bool      // Print every compacted container
+}
+
+// CompactResult reports what a compaction did, or would do when it was not
+// run with the Fix option.
+type CompactResult struct {
+	Compacted []int64  // Timestamps of the compacted containers
+	Skipped   []int64  // Timestamps of old containers that are not anchored
+	Pruned    []string // Orphaned paths that were pruned
+}
+
+// EpochUsage describes the disk usage of a single epoch.
+type EpochUsage struct {
+	Epoch      string // Calendar month, YYYYMM
+	Containers int    // Hourly containers
+	Archived   int    // Containers that were compacted into the archive
+	Size       int64  // Bytes used by containers and archive
+}
+
+// epoch returns the name of the epoch the timestamp belongs to.
+func epoch(ts int64) string {
+	return time.Unix(ts, 0).UTC().Format(epochFormat)
+}
+
+// archiveKey returns the key of the flush record of a compacted container.
+func archiveKey(ts int64) []byte {
+	return []byte(archivePrefix + ts2dirname(ts))
+}
+
+// openArchive opens the archive of the provided epoch.  Like openRead it does
+// not create a database for a non existing epoch unless create is set.  The
+// caller is responsible for closing the database.
+func (fs *FileSystem) openArchive(ep string, create bool) (*leveldb.DB, error) {
+	path := filepath.Join(fs.root, archiveDir, ep)
+	if !create {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return nil, os.ErrNotExist
+		}
+		if !fi.Mode().IsDir() {
+			return nil, errInvalidDB
+		}
+	}
+
+	return leveldb.OpenFile(path, &opt.Options{
+		ErrorIfMissing: !create,
+	})
+}
+
+// archivedFlushRecord returns the flush record of a compacted container.  It
+// returns os.ErrNotExist if the container was not compacted.
+func (fs *FileSystem) archivedFlushRecord(ts int64) (*backend.FlushRecord, error) {
+	db, err := fs.openArchive(epoch(ts), false)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	payload, err := db.Get(archiveKey(ts), nil)
+	if err != nil {
+		if errors.Is(err, leveldb.ErrNotFound) {
+			return nil, os.ErrNotExist
+		}
+		return nil, err
+	}
+
+	return DecodeFlushRecord(payload)
+}
+
+// flushRecord returns the flush record of the provided timestamp from either
+// its container or, if the container was compacted, the archive.
+func (fs *FileSystem) flushRecord(ts int64) (*backend.FlushRecord, error) {
+	db, err := fs.openRead(ts)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fs.archivedFlushRecord(ts)
+		}
+		return nil, err
+	}
+	defer db.Close()
+
+	payload, err := db.Get([]byte(flushedKey), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return DecodeFlushRecord(payload)
+}
+
+// archivedTimestamps returns the timestamps of all compacted containers of
+// the provided epoch.
+func (fs *FileSystem) archivedTimestamps(ep string) ([]int64, error) {
+	db, err := fs.openArchive(ep, false)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	timestamps := make([]int64, 0, 24*31)
+	iter := db.NewIterator(util.BytesPrefix([]byte(archivePrefix)), nil)
+	defer iter.Release()
+	for iter.Next() {
+		dir := strings.TrimPrefix(string(iter.Key()), archivePrefix)
+		t, err := time.Parse(fStr, dir)
+		if err != nil {
+			return nil, fmt.Errorf("invalid archive key: %v", dir)
+		}
+		timestamps = append(timestamps, t.Unix())
+	}
+
+	return timestamps, iter.Error()
+}
+
+// epochs returns the names of all epochs that have an archive.
+func (fs *FileSystem) epochs() ([]string, error) {
+	files, err := os.ReadDir(filepath.Join(fs.root, archiveDir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	epochs := make([]string, 0, len(files))
+	for _, file := range files {
+		if !file.IsDir() {
+			continue
+		}
+		if _, err := time.Parse(epochFormat, file.Name()); err != nil {
+			continue
+		}
+		epochs = append(epochs, file.Name())
+	}
+
+	return epochs, nil
+}
+
+// dumpArchives dumps the flush records of all compacted containers followed
+// by their digests.  Restore recreates them as regular containers.
+func (fs *FileSystem) dumpArchives(f *os.File, verbose bool) error {
+	epochs, err := fs.epochs()
+	if err != nil {
+		return err
+	}
+
+	for _, ep := range epochs {
+		timestamps, err := fs.archivedTimestamps(ep)
+		if err != nil {
+			return err
+		}
+		for _, ts := range timestamps {
+			fr, err := fs.archivedFlushRecord(ts)
+			if err != nil {
+				return err
+			}
+
+			if verbose {
+				fmt.Fprintf(f, "--- Archived: %v %v\n",
+					ts2dirname(ts), ts)
+				dumpFlushRecord(f, fr)
+				continue
+			}
+
+			e := json.NewEncoder(f)
+			err = e.Encode(backend.RecordType{
+				Version: backend.RecordTypeVersion,
+				Type:    backend.RecordTypeFlushRecord,
+			})
+			if err != nil {
+				return err
+			}
+			err = e.Encode(backend.FlushRecordJSON{
+				Root:           fr.Root,
+				Hashes:         fr.Hashes,
+				Tx:             fr.Tx,
+				ChainTimestamp: fr.ChainTimestamp,
+				FlushTimestamp: fr.FlushTimestamp,
+				BlockHeight:    fr.BlockHeight,
+				AnchorPrefix:   fr.AnchorPrefix,
+				Replaced:       fr.Replaced,
+				Attestations:   fr.Attestations,
+				Reorged:        fr.Reorged,
+				Timestamp:      ts,
+			})
+			if err != nil {
+				return err
+			}
+			for _, h := range fr.Hashes {
+				err := dumpDigestTimestamp(f, verbose,
+					backend.RecordTypeDigestReceived,
+					backend.DigestReceived{
+						Digest:    hex.EncodeToString(h[:]),
+						Timestamp: ts,
+					})
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// dirSize returns the number of bytes used by the files in path.
+func dirSize(path string) (int64, error) {
+	var size int64
+	err := filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
+// isOrphan returns true if the provided root directory entry is a left over
+// that is not used by the backend: temporary files and container directories
+// that never became a database.
+func (fs *FileSystem) isOrphan(file os.DirEntry) bool {
+	if !file.IsDir() {
+		return strings.HasSuffix(file.Name(), ".tmp")
+	}
+	if _, err := time.Parse(fStr, file.Name()); err != nil {
+		return false
+	}
+	_, err := os.Stat(filepath.Join(fs.root, file.Name(), "CURRENT"))
+	return os.IsNotExist(err)
+}
+
+// Compact merges flushed containers that are older than options.Before into
+// the archive of their epoch and prunes orphaned files.  Only containers whose
+// anchor was confirmed are compacted since the chain timestamp can no longer
+// be updated once the container is gone.  The newest flushed container is
+// never compacted because the flusher uses it to determine where it left
+// off.
+//
+// Compact must not run while dcrtimed is using the backend.
+func (fs *FileSystem) Compact(options *CompactOptions) (*CompactResult, error) {
+	files, err := os.ReadDir(fs.root)
+	if err != nil {
+		return nil, err
+	}
+
+	var result CompactResult
+	timestamps := make([]int64, 0, len(files))
+	for _, file := range files {
+		if isReserved(file.Name()) {
+			continue
+		}
+		if fs.isOrphan(file) {
+			path := filepath.Join(fs.root, file.Name())
+			result.Pruned = append(result.Pruned, path)
+			if options.Fix {
+				err := os.RemoveAll(path)
+				if err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+		if !file.IsDir() {
+			continue
+		}
+		t, err := time.Parse(fStr, file.Name())
+		if err != nil {
+			continue
+		}
+		timestamps = append(timestamps, t.Unix())
+	}
+
+	// Walk containers backwards so that the newest flushed container can
+	// be skipped.
+	sort.Slice(timestamps, func(i, j int) bool {
+		return timestamps[i] > timestamps[j]
+	})
+	newest := true
+	for _, ts := range timestamps {
+		if !fs.isFlushed(ts) {
+			if ts < options.Before.Unix() {
+				result.Skipped = append(result.Skipped, ts)
+			}
+			continue
+		}
+		if newest {
+			newest = false
+			continue
+		}
+		if ts >= options.Before.Unix() {
+			continue
+		}
+
+		fr, err := fs.flushRecord(ts)
+		if err != nil {
+			return nil, err
+		}
+		if fr.ChainTimestamp == 0 {
+			result.Skipped = append(result.Skipped, ts)
+			continue
+		}
+		result.Compacted = append(result.Compacted, ts)
+		if options.Verbose {
+			fmt.Printf("Compact: %v (%v) tx %v\n", ts2dirname(ts), ts,
+				fr.Tx)
+		}
+		if !options.Fix {
+			continue
+		}
+		err = fs.archive(ts, fr)
+		if err != nil {
+			return nil, fmt.Errorf("archive %v: %v", ts2dirname(ts),
+				err)
+		}
+	}
+
+	return &result, nil
+}
+
+// archive moves the flush record of a container into its epoch archive and
+// removes the container.  The flush record is synced and read back before the
+// container is removed so that a crash leaves both copies behind at worst.
+func (fs *FileSystem) archive(ts int64, fr *backend.FlushRecord) error {
+	payload, err := EncodeFlushRecord(*fr)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Join(fs.root, archiveDir), 0700)
+	if err != nil {
+		return err
+	}
+	db, err := fs.openArchive(epoch(ts), true)
+	if err != nil {
+		return err
+	}
+	err = db.Put(archiveKey(ts), payload, &opt.WriteOptions{Sync: true})
+	if err != nil {
+		db.Close()
+		return err
+	}
+	stored, err := db.Get(archiveKey(ts), nil)
+	db.Close()
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(stored, payload) {
+		return fmt.Errorf("archived flush record mismatch")
+	}
+
+	return os.RemoveAll(filepath.Join(fs.root, ts2dirname(ts)))
+}
+
+// DiskUsage reports the disk usage of the containers and archives by epoch.
+// The global database is not included.
+func (fs *FileSystem) DiskUsage() ([]EpochUsage, error) {
+	files, err := os.ReadDir(fs.root)
+	if err != nil {
+		return nil, err
+	}
+
+	usage := make(map[string]*EpochUsage)
+	get := func(ep string) *EpochUsage {
+		eu, ok := usage[ep]
+		if !ok {
+			eu = &EpochUsage{Epoch: ep}
+			usage[ep] = eu
+		}
+		return eu
+	}
+	for _, file := range files {
+		if !file.IsDir() {
+			continue
+		}
+		t, err := time.Parse(fStr, file.Name())
+		if err != nil {
+			continue
+		}
+		size, err := dirSize(filepath.Join(fs.root, file.Name()))
+		if err != nil {
+			return nil, err
+		}
+		eu := get(epoch(t.Unix()))
+		eu.Containers++
+		eu.Size += size
+	}
+
+	epochs, err := fs.epochs()
+	if err != nil {
+		return nil, err
+	}
+	for _, ep := range epochs {
+		timestamps, err := fs.archivedTimestamps(ep)
+		if err != nil {
+			return nil, err
+		}
+		size, err := dirSize(filepath.Join(fs.root, archiveDir, ep))
+		if err != nil {
+			return nil, err
+		}
+		eu := get(ep)
+		eu.Archived += len(timestamps)
+		eu.Size += size
+	}
+
+	eus := make([]EpochUsage, 0, len(usage))
+	for _, eu := range usage {
+		eus = append(eus, *eu)
+	}
+	sort.Slice(eus, func(i, j int) bool {
+		return eus[i].Epoch < eus[j].Epoch
+	})
+
+	return eus, nil
+}
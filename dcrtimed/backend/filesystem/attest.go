@@ -0,0 +1,50 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package filesystem
+
+import (
+	"context"
+	"crypto/sha256"
+	"time"
+
+	"github.com/decred/dcrtime/dcrtimed/backend"
+)
+
+// attestTimeout is the maximum time a secondary anchorer may take to attest a
+// merkle root.
+const attestTimeout = 30 * time.Second
+
+// attest submits the merkle root of the container with the provided timestamp
+// to all secondary anchorers and returns their attestations.  The Decred
+// anchor is the proof of the container, anchorers that fail are logged and
+// skipped so that they never hold up a flush.
+//
+// This function must be called with the WRITE lock held.
+func (fs *FileSystem) attest(ts int64, root [sha256.Size]byte) []backend.Attestation {
+	if len(fs.anchorers) == 0 {
+		return nil
+	}
+
+	attestations := make([]backend.Attestation, 0, len(fs.anchorers))
+	for _, a := range fs.anchorers {
+		ctx, cancel := context.WithTimeout(context.Background(),
+			attestTimeout)
+		proof, err := a.Anchor(ctx, root)
+		cancel()
+		if err != nil {
+			log.Errorf("Attestation of %v by %v: %v", ts2dirname(ts),
+				a.Name(), err)
+			continue
+		}
+		log.Debugf("Attestation of %v by %v: %x", ts2dirname(ts),
+			a.Name(), proof)
+		attestations = append(attestations, backend.Attestation{
+			Anchorer: a.Name(),
+			Proof:    proof,
+		})
+	}
+
+	return attestations
+}
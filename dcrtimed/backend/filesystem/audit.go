@@ -0,0 +1,76 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package filesystem
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"sync/atomic"
+	"time"
+
+	"github.com/decred/dcrtime/dcrtimed/backend"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// auditDBDir is the directory that contains the audit trails of served
+// proofs.
+const auditDBDir = "audit"
+
+// auditKey returns the key of a proof record: digest | time | sequence.  The
+// time is big endian nanoseconds so that the records of a digest sort by the
+// time they were stored, the sequence keeps records that are stored at the
+// same time apart.
+func auditKey(digest [sha256.Size]byte, ns int64, seq uint32) []byte {
+	key := make([]byte, 0, sha256.Size+8+4)
+	key = append(key, digest[:]...)
+	key = binary.BigEndian.AppendUint64(key, uint64(ns))
+	return binary.BigEndian.AppendUint32(key, seq)
+}
+
+// PutProofRecords appends records of served proofs to the audit trails of
+// their digests.  This call satisfies the backend interface.
+func (fs *FileSystem) PutProofRecords(records []backend.ProofRecord) error {
+	if len(records) == 0 {
+		return nil
+	}
+
+	ns := time.Now().UnixNano()
+	batch := new(leveldb.Batch)
+	for _, pr := range records {
+		payload, err := json.Marshal(pr)
+		if err != nil {
+			return err
+		}
+		seq := atomic.AddUint32(&fs.auditSeq, 1)
+		batch.Put(auditKey(pr.Digest, ns, seq), payload)
+	}
+
+	// Sync so that a served proof is never lost in a crash.
+	return fs.audit.Write(batch, &opt.WriteOptions{Sync: true})
+}
+
+// GetProofRecords returns the audit trail of a digest ordered by the time the
+// proofs were served.  This call satisfies the backend interface.
+func (fs *FileSystem) GetProofRecords(digest [sha256.Size]byte) ([]backend.ProofRecord, error) {
+	records := make([]backend.ProofRecord, 0, 16)
+
+	i := fs.audit.NewIterator(util.BytesPrefix(digest[:]), nil)
+	defer i.Release()
+	for i.Next() {
+		var pr backend.ProofRecord
+		if err := json.Unmarshal(i.Value(), &pr); err != nil {
+			return nil, err
+		}
+		records = append(records, pr)
+	}
+	if err := i.Error(); err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
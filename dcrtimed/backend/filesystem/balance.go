@@ -0,0 +1,66 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package filesystem
+
+import (
+	"github.com/decred/dcrtime/dcrtimed/backend"
+)
+
+// balanceSchedule is the schedule of the balance monitor.
+//
+// Seconds Minutes Hours Days Months DayOfWeek
+const balanceSchedule = "20 * * * * *" // Every minute + 20 seconds
+
+// availableBalance returns the balance that can fund anchors, including the
+// unconfirmed change of previous anchors.
+func availableBalance(br *backend.GetBalanceResult) int64 {
+	return br.Spendable + br.Unconfirmed
+}
+
+// checkBalance looks up the wallet balance and records whether it is below
+// the low balance threshold.  A warning is logged when the balance drops below
+// the threshold and once it recovered.  The previous state is kept when the
+// wallet can not be reached.
+func (fs *FileSystem) checkBalance() {
+	br, err := fs.GetBalance()
+	if err != nil {
+		log.Errorf("checkBalance: %v", err)
+		return
+	}
+
+	available := availableBalance(br)
+	low := available < fs.lowBalance
+
+	fs.balanceMtx.Lock()
+	wasLow := fs.balanceLow
+	fs.balanceLow = low
+	fs.balanceMtx.Unlock()
+
+	switch {
+	case low && !wasLow && fs.pauseLowBalance:
+		log.Warnf("Wallet balance %v atoms below %v, flushes are "+
+			"paused", available, fs.lowBalance)
+	case low && !wasLow:
+		log.Warnf("Wallet balance %v atoms below %v", available,
+			fs.lowBalance)
+	case !low && wasLow:
+		log.Infof("Wallet balance %v atoms recovered", available)
+	}
+}
+
+// lowFunds returns whether the wallet balance was below the low balance
+// threshold at the last check.
+func (fs *FileSystem) lowFunds() bool {
+	fs.balanceMtx.Lock()
+	defer fs.balanceMtx.Unlock()
+
+	return fs.balanceLow
+}
+
+// flushPaused returns whether flushes are paused because the wallet balance is
+// too low to fund anchors.  Digests are queued until the balance recovers.
+func (fs *FileSystem) flushPaused() bool {
+	return fs.pauseLowBalance && fs.lowFunds()
+}
@@ -0,0 +1,89 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package filesystem
+
+import (
+	"os"
+	"sort"
+	"time"
+)
+
+// startWindow opens the window that is filled until the chain advanced
+// anchorBlocks blocks.  The newest unflushed container is resumed, otherwise
+// a new window starts now.  Blocks are counted from the current best block
+// either way.  Windows start on the minute, like hourly windows, so they are
+// never mistaken for fast anchor containers.
+//
+// This function must be called with the WRITE lock held.
+func (fs *FileSystem) startWindow() error {
+	height, err := fs.wallet.BestHeight()
+	if err != nil {
+		return err
+	}
+
+	files, err := os.ReadDir(fs.root)
+	if err != nil {
+		return err
+	}
+	dirs := make([]string, 0, len(files))
+	for _, file := range files {
+		if !file.IsDir() || isReserved(file.Name()) {
+			continue
+		}
+		dirs = append(dirs, file.Name())
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(dirs)))
+
+	start := fs.truncate(fs.myNow().UTC(), time.Minute).Unix()
+	for _, dir := range dirs {
+		timestamp, err := time.Parse(fStr, dir)
+		if err != nil {
+			continue
+		}
+		ts := timestamp.Unix()
+		if isFastContainer(ts) {
+			continue
+		}
+		if !fs.isFlushed(ts) {
+			start = ts
+		} else if ts == start {
+			// Flushed within the current minute, never add
+			// digests to a flushed container.
+			start += 60
+		}
+		break
+	}
+
+	fs.window = start
+	fs.windowHeight = height
+
+	return nil
+}
+
+// advanceWindow starts a new window once the chain advanced anchorBlocks
+// blocks since the current window started.  The ended window is flushed by
+// the flusher, which runs every minute, so at most one window starts per
+// minute.
+//
+// This function must be called with the WRITE lock held.
+func (fs *FileSystem) advanceWindow() {
+	height, err := fs.wallet.BestHeight()
+	if err != nil {
+		log.Errorf("advanceWindow: %v", err)
+		return
+	}
+	if height < fs.windowHeight+fs.anchorBlocks {
+		return
+	}
+
+	start := fs.truncate(fs.myNow().UTC(), time.Minute).Unix()
+	if start <= fs.window {
+		return
+	}
+	log.Debugf("Window %v ended at block %v", ts2dirname(fs.window),
+		height)
+	fs.window = start
+	fs.windowHeight = height
+}
@@ -0,0 +1,162 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package filesystem
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/decred/dcrd/chaincfg/chainhash"
+	"github.com/decred/dcrd/wire"
+	"github.com/decred/dcrtime/checkpoint"
+	"github.com/decred/dcrtime/dcrtimed/backend"
+	"github.com/decred/dcrtime/dcrtimed/dcrtimewallet"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// minedAnchor returns the flush record of the collection with the provided
+// timestamp.  ErrAnchorNotFound is returned if the collection was not anchored
+// with enough confirmations yet.
+//
+// Must be called with the READ lock held.
+func (fs *FileSystem) minedAnchor(ts int64) (*backend.FlushRecord, error) {
+	fr, err := fs.archivedFlushRecord(ts)
+	archived := err == nil
+	if os.IsNotExist(err) {
+		fr, err = fs.flushRecord(ts)
+	}
+	if os.IsNotExist(err) || errors.Is(err, leveldb.ErrNotFound) {
+		return nil, backend.ErrAnchorNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	height, err := fs.anchorHeight(ts, fr, archived)
+	if err != nil {
+		return nil, err
+	}
+	if height == 0 {
+		return nil, backend.ErrAnchorNotFound
+	}
+
+	return fr, nil
+}
+
+// minedLookup returns the flush record of the collection with the provided
+// timestamp and the wallet lookup of its anchor.  ErrAnchorNotFound is
+// returned if the collection was not anchored with enough confirmations yet.
+func (fs *FileSystem) minedLookup(ts int64) (*backend.FlushRecord, *dcrtimewallet.TxLookupResult, error) {
+	fs.RLock()
+	fr, err := fs.minedAnchor(ts)
+	fs.RUnlock()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	res, err := fs.wallet.Lookup(fr.Tx)
+	if err != nil {
+		return nil, nil, err
+	}
+	if res.Confirmations < fs.confirmations {
+		return nil, nil, backend.ErrAnchorNotFound
+	}
+	return fr, res, nil
+}
+
+// blockChain returns the anchor of the provided flush record, its merkle
+// branch in the regular transaction tree and the header of the block it was
+// mined in.
+func (fs *FileSystem) blockChain(fr *backend.FlushRecord, res *dcrtimewallet.TxLookupResult) (*checkpoint.Chain, error) {
+	block, err := fs.wallet.Block(res.BlockHash)
+	if err != nil {
+		return nil, err
+	}
+
+	// The anchor is a regular transaction, the merkle tree of the regular
+	// transaction tree commits to the full hashes of its transactions.
+	index := -1
+	leaves := make([]chainhash.Hash, 0, len(block.Transactions))
+	for k, tx := range block.Transactions {
+		if tx.TxHash() == fr.Tx {
+			index = k
+		}
+		leaves = append(leaves, tx.TxHashFull())
+	}
+	if index == -1 {
+		return nil, fmt.Errorf("anchor %v not in block %v", fr.Tx,
+			res.BlockHash)
+	}
+	branch, err := checkpoint.NewTxBranch(leaves, index)
+	if err != nil {
+		return nil, err
+	}
+
+	return &checkpoint.Chain{
+		Tx:      *block.Transactions[index],
+		Branch:  *branch,
+		Headers: []wire.BlockHeader{block.Header},
+	}, nil
+}
+
+// GetAnchorChain links the anchor of the collection with the provided
+// timestamp to the nearest of the provided checkpoints.  The block and the
+// headers are retrieved from the wallet without holding the lock since there
+// may be many headers.
+//
+// GetAnchorChain satisfies the backend interface.
+func (fs *FileSystem) GetAnchorChain(ts int64, checkpoints []checkpoint.Checkpoint) (*backend.AnchorChain, error) {
+	fr, res, err := fs.minedLookup(ts)
+	if err != nil {
+		return nil, err
+	}
+	cp, err := checkpoint.Nearest(checkpoints, res.BlockHeight)
+	if err != nil {
+		return nil, err
+	}
+	chain, err := fs.blockChain(fr, res)
+	if err != nil {
+		return nil, err
+	}
+
+	headers, err := fs.wallet.Headers(res.BlockHash, cp.Height)
+	if err != nil {
+		return nil, err
+	}
+	chain.Headers = append(chain.Headers, headers...)
+
+	return &backend.AnchorChain{
+		Timestamp:   ts,
+		MerkleRoot:  fr.Root,
+		Tx:          fr.Tx,
+		BlockHeight: res.BlockHeight,
+		Checkpoint:  *cp,
+		Chain:       *chain,
+	}, nil
+}
+
+// GetAnchorBlock returns the anchor of the collection with the provided
+// timestamp together with the header of the block it was mined in.  The block
+// is retrieved from the wallet without holding the lock.
+//
+// GetAnchorBlock satisfies the backend interface.
+func (fs *FileSystem) GetAnchorBlock(ts int64) (*backend.AnchorChain, error) {
+	fr, res, err := fs.minedLookup(ts)
+	if err != nil {
+		return nil, err
+	}
+	chain, err := fs.blockChain(fr, res)
+	if err != nil {
+		return nil, err
+	}
+
+	return &backend.AnchorChain{
+		Timestamp:   ts,
+		MerkleRoot:  fr.Root,
+		Tx:          fr.Tx,
+		BlockHeight: res.BlockHeight,
+		Chain:       *chain,
+	}, nil
+}
@@ -0,0 +1,160 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package filesystem
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/decred/dcrd/chaincfg/chainhash"
+	"github.com/decred/dcrtime/dcrtimed/backend"
+	"github.com/decred/dcrtime/dcrtimed/dcrtimewallet"
+)
+
+const (
+	// confirmRefreshAge is the age up to which the confirmations of
+	// collections are refreshed.  Older collections whose anchor still
+	// lacks confirmations are looked up when they are queried.
+	confirmRefreshAge = 24 * time.Hour
+
+	// confirmRefreshStale is the number of refresh intervals after which
+	// refreshed confirmations are no longer used, e.g. because the
+	// wallet was unreachable.
+	confirmRefreshStale = 2
+)
+
+// confirmation is the result of the last refresh of an anchor that lacked
+// confirmations.
+type confirmation struct {
+	tx      chainhash.Hash               // Anchor that was looked up
+	res     dcrtimewallet.TxLookupResult // Wallet lookup result
+	updated time.Time                    // Time of the refresh
+}
+
+// refreshed returns the refreshed confirmations of the anchor of the
+// collection with the provided timestamp, or nil if there are none or they are
+// stale.
+func (fs *FileSystem) refreshed(ts int64, tx chainhash.Hash) *dcrtimewallet.TxLookupResult {
+	if fs.confirmRefresh == 0 {
+		return nil
+	}
+
+	fs.confirmMtx.Lock()
+	defer fs.confirmMtx.Unlock()
+
+	c, ok := fs.confirmed[ts]
+	if !ok || c.tx != tx ||
+		time.Since(c.updated) > confirmRefreshStale*fs.confirmRefresh {
+		return nil
+	}
+	res := c.res
+	return &res
+}
+
+// unconfirmed returns the flush records of the recent collections whose
+// anchor lacks confirmations, indexed by collection timestamp.
+//
+// Must be called with the READ lock held.
+func (fs *FileSystem) unconfirmed() (map[int64]*backend.FlushRecord, error) {
+	files, err := os.ReadDir(fs.root)
+	if err != nil {
+		return nil, err
+	}
+
+	oldest := fs.now().Add(-confirmRefreshAge)
+	pending := make(map[int64]*backend.FlushRecord)
+	for _, file := range files {
+		if !file.IsDir() || isReserved(file.Name()) {
+			continue
+		}
+		t, err := time.Parse(fStr, file.Name())
+		if err != nil || t.Before(oldest) {
+			continue
+		}
+		ts := t.Unix()
+		if !fs.isFlushed(ts) {
+			continue
+		}
+		fr, err := fs.flushRecord(ts)
+		if err != nil {
+			return nil, err
+		}
+		if fr.ChainTimestamp == 0 {
+			pending[ts] = fr
+		}
+	}
+
+	return pending, nil
+}
+
+// refreshConfirmations looks up the anchors of recent collections that lack
+// confirmations with up to confirmWorkers concurrent wallet lookups.  Flush
+// records whose anchor gained enough confirmations are written back, the
+// confirmations of the others are kept so that queries do not have to look
+// them up.
+func (fs *FileSystem) refreshConfirmations() {
+	start := time.Now()
+
+	fs.RLock()
+	defer fs.RUnlock()
+
+	pending, err := fs.unconfirmed()
+	if err != nil {
+		log.Errorf("refreshConfirmations: %v", err)
+		return
+	}
+
+	var (
+		wg        sync.WaitGroup
+		mtx       sync.Mutex
+		confirmed = make(map[int64]confirmation, len(pending))
+		anchored  int
+	)
+	jobs := make(chan int64)
+	for i := 0; i < fs.confirmWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ts := range jobs {
+				fr := pending[ts]
+				tx := fr.Tx
+				res, err := fs.confirmAnchor(ts, fr)
+				switch {
+				case errors.Is(err, errNotEnoughConfirmation):
+					mtx.Lock()
+					confirmed[ts] = confirmation{
+						tx:      tx,
+						res:     *res,
+						updated: time.Now(),
+					}
+					mtx.Unlock()
+				case errors.Is(err, errInvalidConfirmations):
+					log.Errorf("%v: Confirmations = -1", tx)
+				case err != nil:
+					log.Errorf("refreshConfirmations %v: %v",
+						ts2dirname(ts), err)
+				default:
+					mtx.Lock()
+					anchored++
+					mtx.Unlock()
+				}
+			}
+		}()
+	}
+	for ts := range pending {
+		jobs <- ts
+	}
+	close(jobs)
+	wg.Wait()
+
+	fs.confirmMtx.Lock()
+	fs.confirmed = confirmed
+	fs.confirmMtx.Unlock()
+
+	log.Debugf("refreshConfirmations: anchors %v confirmed %v in %v",
+		len(pending), anchored, time.Since(start))
+}
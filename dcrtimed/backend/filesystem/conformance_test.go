@@ -0,0 +1,81 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package filesystem
+
+import (
+	"testing"
+	"time"
+
+	"github.com/decred/dcrtime/dcrtimed/backend"
+	"github.com/decred/dcrtime/dcrtimed/backend/testsuite"
+)
+
+// harness runs the FileSystem backend on an artificial clock.
+type harness struct {
+	root      string
+	wallet    *testsuite.Wallet
+	timestamp int64
+	fs        *FileSystem
+}
+
+// open mirrors New without launching the flusher.
+func (h *harness) open(t *testing.T) *FileSystem {
+	fs, err := internalNew(h.root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs.wallet = h.wallet
+	fs.enableCollections = true
+	fs.confirmations = 6
+	fs.maxDigests = 20
+	fs.myNow = func() time.Time {
+		return time.Unix(h.timestamp, 0)
+	}
+	h.fs = fs
+	return fs
+}
+
+func (h *harness) Open(t *testing.T) backend.Backend {
+	fs := h.open(t)
+	_, err := fs.doFlush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fs
+}
+
+func (h *harness) OpenRestore(t *testing.T) backend.Backend {
+	h.root = t.TempDir()
+	fs, err := NewRestore(h.root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fs
+}
+
+func (h *harness) OpenStandby(t *testing.T) backend.Backend {
+	h.root = t.TempDir()
+	fs := h.open(t)
+	fs.readOnly = true
+	return fs
+}
+
+func (h *harness) Advance(t *testing.T) {
+	h.timestamp = time.Unix(h.timestamp, 0).Add(duration).Unix()
+}
+
+func (h *harness) Flush(t *testing.T) {
+	h.fs.flusher()
+}
+
+func TestConformance(t *testing.T) {
+	testsuite.Run(t, func(t *testing.T, w *testsuite.Wallet) testsuite.Harness {
+		return &harness{
+			root:      t.TempDir(),
+			wallet:    w,
+			timestamp: time.Now().Unix(),
+		}
+	})
+}
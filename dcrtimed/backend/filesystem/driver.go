@@ -0,0 +1,26 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package filesystem
+
+import (
+	"github.com/decred/dcrtime/dcrtimed/backend"
+)
+
+// DriverName is the name the filesystem backend is registered under.
+const DriverName = "filesystem"
+
+func init() {
+	backend.Register(DriverName, open)
+}
+
+// open opens a filesystem backend with the provided configuration.  It is the
+// registered backend driver.
+func open(cfg backend.Config) (backend.Backend, error) {
+	fs, err := New(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return fs, nil
+}
@@ -30,11 +30,16 @@ func NewDump(root string) (*FileSystem, error) {
 	if !fi.Mode().IsDir() {
 		return nil, errInvalidDB
 	}
+	lock, err := lockRoot(root)
+	if err != nil {
+		return nil, err
+	}
 	db, err := leveldb.OpenFile(path, &opt.Options{ErrorIfMissing: true})
 	if err != nil {
+		lock.Close()
 		return nil, err
 	}
-	return &FileSystem{root: root, db: db}, nil
+	return &FileSystem{root: root, lock: lock, db: db}, nil
 }
 
 func NewRestore(root string) (*FileSystem, error) {
@@ -49,12 +54,18 @@ func NewRestore(root string) (*FileSystem, error) {
 		return nil, err
 	}
 
+	lock, err := lockRoot(root)
+	if err != nil {
+		return nil, err
+	}
+
 	// Open/create global database
 	db, err := leveldb.OpenFile(path, &opt.Options{ErrorIfExist: true})
 	if err != nil {
+		lock.Close()
 		return nil, err
 	}
-	return &FileSystem{root: root, db: db}, nil
+	return &FileSystem{root: root, lock: lock, db: db}, nil
 }
 
 func dumpDigestTimestamp(f *os.File, verbose bool, recordType string, dr backend.DigestReceived) error {
@@ -62,6 +73,12 @@ func dumpDigestTimestamp(f *os.File, verbose bool, recordType string, dr backend
 		ts := ts2dirname(dr.Timestamp)
 		fmt.Fprintf(f, "Digest     : %v\n", dr.Digest)
 		fmt.Fprintf(f, "Timestamp  : %v -> %v\n", dr.Timestamp, ts)
+		if dr.Label != "" {
+			fmt.Fprintf(f, "Label      : %v\n", dr.Label)
+		}
+		if dr.Algorithm != "" {
+			fmt.Fprintf(f, "Algorithm  : %v\n", dr.Algorithm)
+		}
 	} else {
 		e := json.NewEncoder(f)
 		rt := backend.RecordType{
@@ -75,6 +92,8 @@ func dumpDigestTimestamp(f *os.File, verbose bool, recordType string, dr backend
 		r := backend.DigestReceived{
 			Digest:    dr.Digest,
 			Timestamp: dr.Timestamp,
+			Label:     dr.Label,
+			Algorithm: dr.Algorithm,
 		}
 		err = e.Encode(r)
 		if err != nil {
@@ -95,6 +114,8 @@ func (fs *FileSystem) dumpGlobal(f *os.File, verbose bool) error {
 			backend.DigestReceived{
 				Digest:    key,
 				Timestamp: value,
+				Label:     digestLabel(i.Value()),
+				Algorithm: digestAlgorithm(i.Value()),
 			})
 		if err != nil {
 			return err
@@ -107,10 +128,22 @@ func dumpFlushRecord(f *os.File, flushRecord *backend.FlushRecord) {
 	fmt.Fprintf(f, "Merkle root    : %x\n",
 		flushRecord.Root)
 	fmt.Fprintf(f, "Tx             : %v\n", flushRecord.Tx)
+	if flushRecord.AnchorPrefix != "" {
+		fmt.Fprintf(f, "Anchor prefix  : %q\n",
+			flushRecord.AnchorPrefix)
+	}
+	for _, tx := range flushRecord.Replaced {
+		fmt.Fprintf(f, "Replaced tx    : %v\n", tx)
+	}
+	for _, a := range flushRecord.Attestations {
+		fmt.Fprintf(f, "Attestation    : %v %x\n", a.Anchorer, a.Proof)
+	}
 	fmt.Fprintf(f, "Chain timestamp: %v\n",
 		flushRecord.ChainTimestamp)
 	fmt.Fprintf(f, "Flush timestamp: %v\n",
 		flushRecord.FlushTimestamp)
+	fmt.Fprintf(f, "Block height   : %v\n",
+		flushRecord.BlockHeight)
 	for _, v := range flushRecord.Hashes {
 		fmt.Fprintf(f, "  Flushed      : %x\n", *v)
 	}
@@ -141,6 +174,8 @@ func (fs *FileSystem) dumpTimestamp(f *os.File, verbose bool, ts int64) error {
 		digests = append(digests, backend.DigestReceived{
 			Digest:    hex.EncodeToString(key),
 			Timestamp: value,
+			Label:     digestLabel(i.Value()),
+			Algorithm: digestAlgorithm(i.Value()),
 		})
 	}
 
@@ -163,6 +198,11 @@ func (fs *FileSystem) dumpTimestamp(f *os.File, verbose bool, ts int64) error {
 				Tx:             flushRecord.Tx,
 				ChainTimestamp: flushRecord.ChainTimestamp,
 				FlushTimestamp: flushRecord.FlushTimestamp,
+				BlockHeight:    flushRecord.BlockHeight,
+				AnchorPrefix:   flushRecord.AnchorPrefix,
+				Replaced:       flushRecord.Replaced,
+				Attestations:   flushRecord.Attestations,
+				Reorged:        flushRecord.Reorged,
 				Timestamp:      ts,
 			}
 			err = e.Encode(fr)
@@ -193,7 +233,7 @@ func (fs *FileSystem) dumpTimestamps(f *os.File, verbose bool) error {
 		if !fi.IsDir() {
 			continue
 		}
-		if fi.Name() == globalDBDir {
+		if isReserved(fi.Name()) {
 			continue
 		}
 
@@ -223,6 +263,11 @@ func (fs *FileSystem) Dump(f *os.File, verbose bool) error {
 	if err != nil {
 		return err
 	}
+	// Dump compacted containers
+	err = fs.dumpArchives(f, verbose)
+	if err != nil {
+		return err
+	}
 	// Dump global
 	return fs.dumpGlobal(f, verbose)
 }
@@ -258,6 +303,13 @@ func (fs *FileSystem) restoreFlushRecord(verbose bool, fr backend.FlushRecordJSO
 		Tx:             fr.Tx,
 		ChainTimestamp: fr.ChainTimestamp,
 		FlushTimestamp: fr.FlushTimestamp,
+
+		ServerTimestamp: fr.Timestamp,
+		BlockHeight:     fr.BlockHeight,
+		AnchorPrefix:    fr.AnchorPrefix,
+		Replaced:        fr.Replaced,
+		Attestations:    fr.Attestations,
+		Reorged:         fr.Reorged,
 	}
 	payload, err := EncodeFlushRecord(frOld)
 	if err != nil {
@@ -276,25 +328,23 @@ func (fs *FileSystem) restoreDigestReceived(dr backend.DigestReceived) error {
 	}
 	defer db.Close()
 
-	timestamp := make([]byte, 8)
-	binary.LittleEndian.PutUint64(timestamp, uint64(dr.Timestamp))
 	hash, err := hex.DecodeString(dr.Digest)
 	if err != nil {
 		return err
 	}
 
-	return db.Put(hash, timestamp, nil)
+	return db.Put(hash, encodeDigestValue(dr.Timestamp, dr.Label,
+		dr.Algorithm), nil)
 }
 
 func (fs *FileSystem) restoreDigestReceivedGlobal(dr backend.DigestReceived) error {
-	timestamp := make([]byte, 8)
-	binary.LittleEndian.PutUint64(timestamp, uint64(dr.Timestamp))
 	hash, err := hex.DecodeString(dr.Digest)
 	if err != nil {
 		return err
 	}
 
-	return fs.db.Put(hash, timestamp, nil)
+	return fs.db.Put(hash, encodeDigestValue(dr.Timestamp, dr.Label,
+		dr.Algorithm), nil)
 }
 
 // Restore reads JSON encoded database contents and recreates the leveldb
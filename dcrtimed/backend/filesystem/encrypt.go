@@ -0,0 +1,100 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package filesystem
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+)
+
+const (
+	// EncryptionKeySize is the size of the AES-256 key that encrypts
+	// digest metadata at rest.
+	EncryptionKeySize = 32
+
+	// encryptedVersion prefixes encrypted payloads.  Plaintext payloads
+	// are JSON objects and start with '{', so payloads that were stored
+	// before encryption was enabled remain readable.
+	encryptedVersion = 1
+)
+
+var (
+	// errEncrypted is returned when an encrypted payload is read without
+	// an encryption key.
+	errEncrypted = errors.New("payload is encrypted and no encryption " +
+		"key is configured")
+)
+
+// LoadEncryptionKey reads the hex encoded encryption key from the provided
+// file.  A key can be created with e.g. openssl rand -hex 32.
+func LoadEncryptionKey(filename string) ([]byte, error) {
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	key, err := hex.DecodeString(string(bytes.TrimSpace(b)))
+	if err != nil || len(key) != EncryptionKeySize {
+		return nil, fmt.Errorf("invalid encryption key %v: want %v hex "+
+			"encoded bytes", filename, EncryptionKeySize)
+	}
+	return key, nil
+}
+
+// newAEAD returns the AES-GCM cipher of the provided key, nil if the key is
+// nil.
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if key == nil {
+		return nil, nil
+	}
+	if len(key) != EncryptionKeySize {
+		return nil, fmt.Errorf("invalid encryption key size: %v",
+			len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts the payload that is stored under the provided database key
+// when encryption is enabled and returns it unmodified otherwise.  The
+// database key is authenticated so that encrypted payloads can not be moved
+// to another key.
+func (fs *FileSystem) seal(key, payload []byte) ([]byte, error) {
+	if fs.aead == nil {
+		return payload, nil
+	}
+	ns := fs.aead.NonceSize()
+	sealed := make([]byte, 1+ns, 1+ns+len(payload)+fs.aead.Overhead())
+	sealed[0] = encryptedVersion
+	if _, err := rand.Read(sealed[1:]); err != nil {
+		return nil, err
+	}
+	return fs.aead.Seal(sealed, sealed[1:], payload, key), nil
+}
+
+// unseal decrypts a payload that seal stored under the provided database key.
+// Payloads that were stored without encryption are returned unmodified.
+func (fs *FileSystem) unseal(key, payload []byte) ([]byte, error) {
+	if len(payload) == 0 || payload[0] != encryptedVersion {
+		return payload, nil
+	}
+	if fs.aead == nil {
+		return nil, errEncrypted
+	}
+	ns := fs.aead.NonceSize()
+	if len(payload) < 1+ns {
+		return nil, fmt.Errorf("encrypted payload too short: %v",
+			len(payload))
+	}
+	return fs.aead.Open(nil, payload[1:1+ns], payload[1+ns:], key)
+}
@@ -0,0 +1,99 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package filesystem
+
+import (
+	"crypto/sha256"
+	"errors"
+	"sort"
+
+	"github.com/decred/dcrtime/dcrtimed/backend"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// exportRecord returns the export record of the flushed collection with the
+// provided timestamp or nil if it was not flushed yet.
+//
+// Must be called with the READ lock held.
+func (fs *FileSystem) exportRecord(ts int64, archived bool) (*backend.ExportRecord, error) {
+	var (
+		fr  *backend.FlushRecord
+		err error
+	)
+	if archived {
+		fr, err = fs.archivedFlushRecord(ts)
+	} else {
+		fr, err = fs.flushRecord(ts)
+	}
+	if errors.Is(err, leveldb.ErrNotFound) {
+		// Not flushed yet.
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	height, err := fs.anchorHeight(ts, fr, archived)
+	if err != nil {
+		return nil, err
+	}
+
+	er := &backend.ExportRecord{
+		Timestamp:      ts,
+		FlushTimestamp: fr.FlushTimestamp,
+		MerkleRoot:     fr.Root,
+		Tx:             fr.Tx,
+		BlockHeight:    height,
+		Digests:        make([][sha256.Size]byte, 0, len(fr.Hashes)),
+	}
+	if height != 0 {
+		er.ChainTimestamp = fr.ChainTimestamp
+	}
+	for _, ph := range fr.Hashes {
+		if ph != nil {
+			er.Digests = append(er.Digests, *ph)
+		}
+	}
+	return er, nil
+}
+
+// ExportCollections calls f with every flushed collection with a timestamp
+// between from and to, inclusive.  The read lock is only held while a
+// collection is looked up so that a slow consumer does not hold up flushes.
+// This call satisfies the backend interface.
+func (fs *FileSystem) ExportCollections(from, to int64, f func(backend.ExportRecord) error) error {
+	fs.RLock()
+	archived, err := fs.containers()
+	fs.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	timestamps := make([]int64, 0, len(archived))
+	for ts := range archived {
+		if ts < from || ts > to {
+			continue
+		}
+		timestamps = append(timestamps, ts)
+	}
+	sort.Slice(timestamps, func(i, j int) bool {
+		return timestamps[i] < timestamps[j]
+	})
+
+	for _, ts := range timestamps {
+		fs.RLock()
+		er, err := fs.exportRecord(ts, archived[ts])
+		fs.RUnlock()
+		if err != nil {
+			return err
+		}
+		if er == nil {
+			continue
+		}
+		if err := f(*er); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package filesystem
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// MaxFastAnchors is the maximum number of fast anchor overrides.  The
+	// container of a fast anchor is offset from the start of its window by
+	// the one based index of the override in seconds so that it never
+	// collides with an hourly container or the container of another
+	// override.
+	MaxFastAnchors = 59
+
+	// fastSchedule is the flush schedule when fast anchors are configured.
+	// Hourly containers are still only flushed once their hour is over.
+	//
+	// Seconds Minutes Hours Days Months DayOfWeek
+	fastSchedule = "10 * * * * *" // Every minute + 10 seconds
+)
+
+// FastAnchor flushes the digests that are timestamped under a group label
+// that starts with Prefix into dedicated containers that are anchored every
+// Interval instead of every hour.
+type FastAnchor struct {
+	Prefix   string
+	Interval time.Duration
+}
+
+// ParseFastAnchors parses fast anchor overrides of the form prefix:interval,
+// e.g. acme-:10m.  The interval must be a whole number of minutes that evenly
+// divides an hour.
+func ParseFastAnchors(values []string) ([]FastAnchor, error) {
+	if len(values) > MaxFastAnchors {
+		return nil, fmt.Errorf("too many fast anchors: %v > %v",
+			len(values), MaxFastAnchors)
+	}
+
+	fas := make([]FastAnchor, 0, len(values))
+	for _, v := range values {
+		i := strings.LastIndex(v, ":")
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid fast anchor %q: want "+
+				"prefix:interval", v)
+		}
+		interval, err := time.ParseDuration(v[i+1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid fast anchor %q: %v", v, err)
+		}
+		if interval < time.Minute || interval >= duration ||
+			interval%time.Minute != 0 || duration%interval != 0 {
+			return nil, fmt.Errorf("invalid fast anchor %q: interval "+
+				"must be whole minutes that evenly divide %v", v,
+				duration)
+		}
+		fas = append(fas, FastAnchor{
+			Prefix:   v[:i],
+			Interval: interval,
+		})
+	}
+
+	return fas, nil
+}
+
+// isFastContainer returns true if the container with the provided timestamp
+// belongs to a fast anchor.  Hourly containers always start on the minute.
+func isFastContainer(ts int64) bool {
+	return ts%60 != 0
+}
+
+// containerTimestamp returns the timestamp of the container that digests with
+// the provided group label are currently stored in.
+func (fs *FileSystem) containerTimestamp(label string) int64 {
+	if label != "" {
+		for k, fa := range fs.fastAnchors {
+			if !strings.HasPrefix(label, fa.Prefix) {
+				continue
+			}
+			start := fs.truncate(fs.myNow().UTC(), fa.Interval)
+			return start.Unix() + int64(k+1)
+		}
+	}
+	return fs.now().Unix()
+}
+
+// containerEnd returns the time the window of the current container with the
+// provided timestamp ends.
+func (fs *FileSystem) containerEnd(ts int64) time.Time {
+	if !isFastContainer(ts) {
+		return time.Unix(ts, 0).Add(fs.duration)
+	}
+	k := int(ts%60) - 1
+	start := time.Unix(ts-int64(k+1), 0)
+	return start.Add(fs.fastAnchors[k].Interval)
+}
+
+// isCurrent returns true if the window of the container with the provided
+// timestamp has not ended yet and it may therefore not be flushed.  Fast
+// containers of overrides that are no longer configured are never current.
+func (fs *FileSystem) isCurrent(ts int64) bool {
+	if !isFastContainer(ts) {
+		return ts == fs.now().Unix()
+	}
+
+	k := int(ts%60) - 1
+	if k >= len(fs.fastAnchors) {
+		return false
+	}
+	start := time.Unix(ts-int64(k+1), 0)
+	return fs.myNow().Before(start.Add(fs.fastAnchors[k].Interval))
+}
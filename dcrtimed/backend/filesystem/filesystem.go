@@ -6,6 +6,8 @@ package filesystem
 
 import (
 	"bytes"
+	"context"
+	"crypto/cipher"
 	"crypto/sha256"
 	"encoding/binary"
 	"encoding/json"
@@ -17,7 +19,9 @@ import (
 	"sync"
 	"time"
 
+	"github.com/decred/dcrtime/dcrtimed/anchorer"
 	"github.com/decred/dcrtime/dcrtimed/backend"
+	"github.com/decred/dcrtime/dcrtimed/backend/sidestore"
 	"github.com/decred/dcrtime/dcrtimed/dcrtimewallet"
 	"github.com/decred/dcrtime/merkle"
 	"github.com/robfig/cron"
@@ -30,6 +34,17 @@ const (
 	globalDBDir = "global"
 	flushedKey  = "flushed"
 
+	// tokensDBDir is the directory that contains the api token database.
+	tokensDBDir = "tokens"
+
+	// webhooksDBDir is the directory that contains the webhook delivery
+	// database.
+	webhooksDBDir = "webhooks"
+
+	// sessionsDBDir is the directory that contains the submission session
+	// database.
+	sessionsDBDir = "sessions"
+
 	// error codes that are overridden during tests only.
 	// foundGlobal is thrown if digest was found in global db
 	foundGlobal = 1000
@@ -64,8 +79,16 @@ var (
 type FileSystem struct {
 	sync.RWMutex
 
+	// Side stores that satisfy the api token, webhook, collection
+	// ownership and submission session parts of the backend interface.
+	*sidestore.Tokens
+	*sidestore.Webhooks
+	*sidestore.Owners
+	*sidestore.Sessions
+
 	cron     *cron.Cron    // Scheduler for periodic tasks
 	root     string        // Root directory
+	lock     *os.File      // Advisory lock of the root directory
 	db       *leveldb.DB   // Global database [hash]timestamp
 	duration time.Duration // How often we combine digests
 	commit   uint          // Current version, incremented during flush
@@ -74,18 +97,136 @@ type FileSystem struct {
 	confirmations     int32 // Number of confirmations to return timestamp proof
 	maxDigests        int32 // Number of confirmations to return timestamp proof
 
-	wallet *dcrtimewallet.DcrtimeWallet // Wallet context.
+	fastAnchors  []FastAnchor  // Label prefixes that are anchored more often
+	anchorPrefix string        // Prefix in front of merkle root in anchors
+	anchorRetry  time.Duration // Time before unmined anchors are replaced
+	anchorBlocks int32         // Blocks per window, 0 for hourly windows
+	window       int64         // Current window if anchorBlocks is set
+	windowHeight int32         // Block height the current window started at
+	reorgHeight  int32         // Best block height at the last reorg check
+	lastReorg    int64         // Time an anchor was last reorganized out
+	readOnly     bool          // Refuse digests and never flush
+	maintenance  bool          // Queue digests and do not flush
+	maxQueued    int64         // Pending digests allowed in maintenance
+	queued       int64         // Pending digests while in maintenance
+
+	wallet    dcrtimewallet.Wallet // Wallet context.
+	anchorers []anchorer.Anchorer  // Secondary anchorers
+
+	// flushMtx serializes flushes and anchor transactions.
+	//
+	// flushConcurrently releases the WRITE lock between reading a
+	// container and committing its flush, so holding the WRITE lock alone
+	// does not keep a container that is not current from being flushed
+	// underneath.  Everything that flushes, anchors, replaces anchors or
+	// removes containers that were not flushed yet therefore holds
+	// flushMtx for the whole operation: the flusher, SetMaintenance,
+	// watchReorgs, DeleteCollection and Close.  Submissions only touch
+	// the current container, which is never flushed, and do not take it.
+	//
+	// flushMtx is always acquired before the WRITE lock and never while
+	// the WRITE lock is held.
+	flushMtx     sync.Mutex
+	flushWorkers int // Goroutines that calculate a merkle root
+
+	tokens   *leveldb.DB // Api token database [hash]APIToken
+	webhooks *leveldb.DB // Webhook delivery database [id]Delivery
+	owners   *leveldb.DB // Collection ownership database
+
+	metadataMtx sync.Mutex  // Serializes digest metadata updates
+	metadata    *leveldb.DB // Digest metadata database [digest]Metadata
+	aead        cipher.AEAD // Encrypts metadata at rest, nil if disabled
+
+	audit    *leveldb.DB // Audit trails of served proofs [digest|time]
+	auditSeq uint32      // Keeps proof records stored at once apart
+
+	statsMtx sync.Mutex  // Serializes submission statistics updates
+	stats    *leveldb.DB // Submission statistics [timestamp]
+
+	sessions *leveldb.DB // Submission sessions, staged digests and tickets
+
+	wal *leveldb.DB // Write-ahead log of flushes in progress
+
+	confirmMtx     sync.Mutex             // Protects the refreshed confirmations
+	confirmRefresh time.Duration          // Time between refreshes, 0 if disabled
+	confirmWorkers int                    // Concurrent wallet lookups of a refresh
+	confirmed      map[int64]confirmation // Refreshed unconfirmed anchors
+
+	blocksCancel context.CancelFunc // Ends the block subscription
+	blocks       int32              // Set while blocks are notified
+
+	healthMtx       sync.Mutex    // Protects the flusher health
+	lastFlusher     time.Time     // Time the flusher last completed
+	flusherInterval time.Duration // Time between flusher runs
+	anchorErr       error         // Last anchor error, nil on success
+
+	balanceMtx      sync.Mutex // Protects the balance state
+	lowBalance      int64      // Available balance below which funds are low
+	pauseLowBalance bool       // Queue digests while funds are low
+	balanceLow      bool       // Set while funds are low
 
 	// testing only entries
 	myNow   func() time.Time // Override time.Now()
 	testing bool             // Enabled during test
 }
 
+// isReserved returns true if the provided name in the root is not a
+// timestamp container.
+func isReserved(name string) bool {
+	return name == globalDBDir || name == archiveDir ||
+		name == tokensDBDir || name == webhooksDBDir ||
+		name == ownersDBDir || name == metadataDBDir ||
+		name == auditDBDir ||
+		name == statsDBDir || name == sessionsDBDir ||
+		name == walDBDir || name == lockFilename
+}
+
 // ts2dirname converts a UNIX timestamp to a human readable timestamp.
 func ts2dirname(ts int64) string {
 	return time.Unix(ts, 0).UTC().Format(fStr)
 }
 
+// encodeDigestValue returns the database value that is stored for a digest.
+// It consists of the little endian collection timestamp optionally followed by
+// the group label of the digest.  The digest algorithm, if it is not SHA-256,
+// is appended after a zero byte, which never occurs in a group label.
+func encodeDigestValue(ts int64, label, algorithm string) []byte {
+	value := make([]byte, 8, 8+len(label)+1+len(algorithm))
+	binary.LittleEndian.PutUint64(value, uint64(ts))
+	value = append(value, label...)
+	if algorithm != "" {
+		value = append(value, 0)
+		value = append(value, algorithm...)
+	}
+	return value
+}
+
+// digestLabel returns the group label that is stored in a digest database
+// value.
+func digestLabel(value []byte) string {
+	if len(value) <= 8 {
+		return ""
+	}
+	label := value[8:]
+	if i := bytes.IndexByte(label, 0); i >= 0 {
+		label = label[:i]
+	}
+	return string(label)
+}
+
+// digestAlgorithm returns the digest algorithm that is stored in a digest
+// database value.  It is empty for SHA-256 digests.
+func digestAlgorithm(value []byte) string {
+	if len(value) <= 8 {
+		return ""
+	}
+	i := bytes.IndexByte(value[8:], 0)
+	if i < 0 {
+		return ""
+	}
+	return string(value[8+i+1:])
+}
+
 // EncodeFlushRecord encodes given backend.FlushRecord to a
 // []byte
 func EncodeFlushRecord(fr backend.FlushRecord) ([]byte, error) {
@@ -110,9 +251,13 @@ func DecodeFlushRecord(payload []byte) (*backend.FlushRecord, error) {
 	return &fr, nil
 }
 
-// now returns current time stamp rounded down to 1 hour.  All timestamps are
-// UTC.
+// now returns current time stamp rounded down to 1 hour.  Windows that are
+// defined by block height return the start of the current window instead.
+// All timestamps are UTC.
 func (fs *FileSystem) now() time.Time {
+	if fs.anchorBlocks != 0 {
+		return time.Unix(fs.window, 0).UTC()
+	}
 	return fs.truncate(fs.myNow().UTC(), fs.duration)
 }
 
@@ -191,86 +336,150 @@ func (fs *FileSystem) isFlushed(ts int64) bool {
 	return isFlushed(db)
 }
 
-// flush moves provided timestamp container into global database,
-// and returns nil iff ts flushed successfully
+// pendingFlush is a timestamp container whose digests were read to be
+// flushed.
+type pendingFlush struct {
+	ts     int64
+	hashes []*[sha256.Size]byte // Digests in ascending order
+	batch  *leveldb.Batch       // Global database entries of the digests
+}
+
+// readFlush reads the digests of the provided timestamp container and
+// prepares their global database entries.
 //
 // This function must be called with the WRITE lock held.
-func (fs *FileSystem) flush(ts int64) error {
+func (fs *FileSystem) readFlush(ts int64) (*pendingFlush, error) {
 	// Open timestamp container.
 	db, err := fs.openWrite(ts, false)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer db.Close()
 
 	// Error if we are already flushed
 	if isFlushed(db) {
-		return errAlreadyFlushed
+		return nil, errAlreadyFlushed
 	}
 
-	hashes := make([]*[sha256.Size]byte, 0, 4096)
-
-	// Iterate over timestamp container and create batch for global
-	// database.
-	timestamp := make([]byte, 8)
-	binary.LittleEndian.PutUint64(timestamp, uint64(ts))
-	files := 0
-	batch := new(leveldb.Batch)
+	// Iterate over timestamp container and collect the digests.  The
+	// iterator returns them sorted.
+	pf := pendingFlush{
+		ts:     ts,
+		hashes: make([]*[sha256.Size]byte, 0, 4096),
+		batch:  new(leveldb.Batch),
+	}
 	iter := db.NewIterator(nil, nil)
 	for iter.Next() {
 		var digest [sha256.Size]byte
-		hash := iter.Key()
-		batch.Put(hash, timestamp)
-		copy(digest[:], hash)
-		hashes = append(hashes, &digest)
-		files++
+		copy(digest[:], iter.Key())
+		pf.hashes = append(pf.hashes, &digest)
+		pf.batch.Put(digest[:], encodeDigestValue(ts,
+			digestLabel(iter.Value()), digestAlgorithm(iter.Value())))
 	}
 	iter.Release()
 	err = iter.Error()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	if len(hashes) == 0 {
+	if len(pf.hashes) == 0 {
 		// this really should not happen.
-		return errEmptySet
+		return nil, errEmptySet
 	}
 
-	// Create merkle root and send to wallet
-	mt := merkle.Tree(hashes)
-	root := *mt[len(mt)-1] // Last element is root
+	return &pf, nil
+}
+
+// anchorFlush calculates the merkle root of a pending flush, sends it to the
+// wallet and returns the encoded flush record.  The flush is logged before the
+// anchor is constructed and once the record is complete.  It does not access
+// the containers, so it does not need the WRITE lock.
+//
+// This function must be called with the flush mutex held.
+func (fs *FileSystem) anchorFlush(pf *pendingFlush) ([]byte, error) {
+	ts := pf.ts
+
+	// Create merkle root and send to wallet.  Large containers are hashed
+	// on several goroutines without building the tree in memory.
+	root, err := merkle.ParallelRoot(pf.hashes, fs.flushWorkers)
+	if err != nil {
+		return nil, err
+	}
 	fr := backend.FlushRecord{
-		Root:            root,
-		Hashes:          mt[:len(hashes)], // Only store hashes
+		Root:            *root,
+		Hashes:          pf.hashes, // Only store hashes
 		FlushTimestamp:  time.Now().Unix(),
 		ServerTimestamp: ts,
 	}
+
+	// Log the flush before the anchor is constructed so that it is
+	// replayed or rolled back when it is interrupted.
+	entry := walEntry{
+		Timestamp: ts,
+		Root:      fr.Root,
+		Started:   fr.FlushTimestamp,
+	}
+	err = fs.walPut(entry)
+	if err != nil {
+		return nil, err
+	}
+
 	if !fs.testing {
-		tx, err := fs.wallet.Construct(root)
+		tx, err := fs.wallet.Construct(fr.Root, []byte(fs.anchorPrefix))
+		fs.setAnchorError(err)
 		if err != nil {
-			// XXX do something with unsufficient funds here.
-			return fmt.Errorf("flush Construct tx: %v", err)
+			// Nothing was written, roll back.
+			if err := fs.walDelete(ts); err != nil {
+				log.Errorf("flush %v: %v", ts2dirname(ts), err)
+			}
+			// The container is flushed again on the next run,
+			// make sure operators notice anchors that are refused.
+			if errors.Is(err, dcrtimewallet.ErrFeeTooHigh) {
+				log.Criticalf("Anchor of %v refused: %v",
+					ts2dirname(ts), err)
+			}
+			// Insufficient funds can pause flushes instead, see
+			// flushPaused.
+			return nil, fmt.Errorf("flush Construct tx: %w", err)
 		}
 		log.Infof("Flush timestamp: %v digests %v merkle: %x tx: %v",
-			ts2dirname(ts), files, root, tx.String())
+			ts2dirname(ts), len(pf.hashes), fr.Root, tx.String())
 		fr.Tx = *tx
+		fr.AnchorPrefix = fs.anchorPrefix
+		fr.Attestations = fs.attest(ts, fr.Root)
 	}
 
 	// Encode flush record.  We use JSON because it handles nil correctly.
 	// Sorry!
 	payload, err := EncodeFlushRecord(fr)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	// Log the flush record, from this point on the flush is completed
+	// when it is interrupted.
+	entry.Record = payload
+	err = fs.walPut(entry)
+	if err != nil {
+		return nil, err
 	}
 
-	// Commit to global database.
-	err = fs.db.Write(batch, nil)
+	return payload, nil
+}
+
+// commitPendingFlush commits an anchored flush to the global database, marks
+// the timestamp container as flushed and removes the flush from the
+// write-ahead log.
+//
+// This function must be called with the WRITE lock held.
+func (fs *FileSystem) commitPendingFlush(pf *pendingFlush, payload []byte) error {
+	db, err := fs.openWrite(pf.ts, false)
 	if err != nil {
 		return err
 	}
+	defer db.Close()
 
-	// Mark timestamp container as flushed.
-	err = db.Put([]byte(flushedKey), payload, nil)
+	err = fs.writeFlush(db, pf.batch, payload)
 	if err != nil {
 		return err
 	}
@@ -278,23 +487,36 @@ func (fs *FileSystem) flush(ts int64) error {
 	// Update commit.
 	fs.commit++
 
-	return nil
+	return fs.walDelete(pf.ts)
 }
 
-// doFlush walks timestamp directories backwards and flushes them to the
-// global database until it finds a flushed timestamp directory.  At that
-// point the flusher exits.  It returns the number of directories that were
-// flushed.
+// flush moves provided timestamp container into global database,
+// and returns nil iff ts flushed successfully
 //
-// This must be called with the WRITE lock held.  We may have to consider
-// errors out of this function terminal.
-func (fs *FileSystem) doFlush() (int, error) {
-	now := fs.now().Format(fStr)
+// This function must be called with the WRITE lock held.
+func (fs *FileSystem) flush(ts int64) error {
+	pf, err := fs.readFlush(ts)
+	if err != nil {
+		return err
+	}
+	payload, err := fs.anchorFlush(pf)
+	if err != nil {
+		return err
+	}
+	return fs.commitPendingFlush(pf, payload)
+}
 
+// unflushed walks timestamp directories backwards and returns the timestamps
+// of the containers that must be flushed until it finds a flushed timestamp
+// directory.  Directories whose window has not ended yet are skipped and
+// flushed fast anchor directories do not stop the walk.
+//
+// This must be called with the WRITE lock held.
+func (fs *FileSystem) unflushed() ([]int64, error) {
 	// Get Dirs.
 	files, err := os.ReadDir(fs.root)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
 
 	// Create work.
@@ -307,10 +529,6 @@ func (fs *FileSystem) doFlush() (int, error) {
 		if !file.IsDir() {
 			continue
 		}
-		// Skip current timestamp.
-		if file.Name() == now {
-			continue
-		}
 
 		dirs = append(dirs, file.Name())
 	}
@@ -319,7 +537,7 @@ func (fs *FileSystem) doFlush() (int, error) {
 	sort.Sort(sort.Reverse(sort.StringSlice(dirs)))
 	// Walk directories backwards until we find a flushed database.  At
 	// this point we know we are caught up.
-	count := 0
+	var tss []int64
 	for _, dir := range dirs {
 		// Skip invalid directories.
 		timestamp, err := time.Parse(fStr, dir)
@@ -328,20 +546,56 @@ func (fs *FileSystem) doFlush() (int, error) {
 		}
 		ts := timestamp.Unix()
 
+		// Skip current timestamps.
+		if fs.isCurrent(ts) {
+			continue
+		}
+
 		// Skip flushed dirs.
 		if fs.isFlushed(ts) {
+			// Fast containers are flushed before the hourly
+			// container they overlap with.
+			if isFastContainer(ts) {
+				continue
+			}
 			// We hit a flushed dir so we should be done.
 			break
 		}
 
+		tss = append(tss, ts)
+	}
+
+	return tss, nil
+}
+
+// flushFailed reports a container that could not be flushed.  It is flushed
+// again by the next flusher run.
+func (fs *FileSystem) flushFailed(ts int64, err error) {
+	e := fmt.Sprintf("flush %v: %v", ts2dirname(ts), err)
+	if fs.testing {
+		panic(e)
+	}
+	log.Error(e)
+}
+
+// doFlush flushes the containers that were not flushed yet to the global
+// database, see unflushed.  It returns the number of directories that were
+// flushed.
+//
+// This must be called with the WRITE lock held.  We may have to consider
+// errors out of this function terminal.
+func (fs *FileSystem) doFlush() (int, error) {
+	tss, err := fs.unflushed()
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, ts := range tss {
 		// Flush timestamp container
 		err = fs.flush(ts)
 		if err != nil {
-			e := fmt.Sprintf("flush %v: %v", ts2dirname(ts), err)
-			if fs.testing {
-				panic(e)
-			}
-			log.Error(e)
+			fs.flushFailed(ts, err)
 		} else {
 			count++
 		}
@@ -350,19 +604,94 @@ func (fs *FileSystem) doFlush() (int, error) {
 	return count, nil
 }
 
+// flushConcurrently flushes the same containers as doFlush but only holds the
+// WRITE lock while a container is read and while it is committed.  Digests are
+// timestamped and verified while the merkle roots are calculated and the
+// anchors are constructed, which takes a while for large containers.
+// Submissions notice the commits through fs.commit.  Containers that are not
+// current do not receive digests, so they do not change in between.
+//
+// This must be called with the flush mutex held and without the WRITE lock.
+func (fs *FileSystem) flushConcurrently() (int, error) {
+	fs.Lock()
+	tss, err := fs.unflushed()
+	fs.Unlock()
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, ts := range tss {
+		fs.Lock()
+		pf, err := fs.readFlush(ts)
+		fs.Unlock()
+		if err != nil {
+			fs.flushFailed(ts, err)
+			continue
+		}
+
+		payload, err := fs.anchorFlush(pf)
+		if err == nil {
+			fs.Lock()
+			err = fs.commitPendingFlush(pf, payload)
+			fs.Unlock()
+		}
+		if err != nil {
+			fs.flushFailed(ts, err)
+			continue
+		}
+		count++
+	}
+
+	return count, nil
+}
+
 // flusher is called periodically to flush the current timestamp to disk.
 func (fs *FileSystem) flusher() {
-	// From this point on the operation must be atomic.
+	// From this point on flushes and anchors must be serialized.
+	fs.flushMtx.Lock()
+	defer fs.flushMtx.Unlock()
+
 	fs.Lock()
-	defer fs.Unlock()
+	if fs.anchorBlocks != 0 {
+		fs.advanceWindow()
+	}
+	if fs.maintenance {
+		log.Infof("Flusher: maintenance, digests queued %v", fs.queued)
+		fs.Unlock()
+		fs.flusherCompleted()
+		return
+	}
+	fs.Unlock()
+	if fs.flushPaused() {
+		log.Warnf("Flusher: wallet balance below %v atoms, digests "+
+			"queued", fs.lowBalance)
+		fs.flusherCompleted()
+		return
+	}
 	start := time.Now()
-	count, err := fs.doFlush()
+	count, err := fs.flushConcurrently()
 	end := time.Since(start)
 	if err != nil {
 		log.Errorf("flusher: %v", err)
+	} else {
+		fs.flusherCompleted()
 	}
 
 	log.Infof("Flusher: directories %v in %v", count, end)
+
+	if fs.anchorRetry == 0 {
+		return
+	}
+	fs.Lock()
+	replaced, err := fs.replaceAnchors()
+	fs.Unlock()
+	if err != nil {
+		log.Errorf("flusher: %v", err)
+	}
+	if replaced != 0 {
+		log.Infof("Flusher: replaced anchors %v", replaced)
+	}
 }
 
 var (
@@ -372,20 +701,35 @@ var (
 
 // lazyFlush takes a pointer to a flush record and updates the chain anchor
 // timestamp of said record and writes it back to the database and returns
-// the result of the wallet's Lookup function
+// the result of the wallet's Lookup function.  The confirmations of the last
+// refresh are returned instead of looking up the anchor when they are fresh.
+//
+// Must be called with the READ lock held.
+func (fs *FileSystem) lazyFlush(dbts int64, fr *backend.FlushRecord) (*dcrtimewallet.TxLookupResult, error) {
+	if res := fs.refreshed(dbts, fr.Tx); res != nil {
+		return res, errNotEnoughConfirmation
+	}
+	return fs.confirmAnchor(dbts, fr)
+}
+
+// confirmAnchor looks up the anchor of the provided flush record and, if it
+// has enough confirmations, updates the chain anchor timestamp of said record
+// and writes it back to the database.  It returns the result of the wallet's
+// Lookup function.  A replaced anchor that was mined instead of its
+// replacement becomes the anchor of the record.
 //
 // IMPORTANT NOTE: We *may* write to a timestamp database in case of a lazy
 // timestamp update to the flush record while holding the READ lock.  This is
 // OK because at worst we are racing multiple atomic writes to the same key
 // with the same information.  This is suboptimal but beats taking a write lock
 // for all get* calls.
-func (fs *FileSystem) lazyFlush(dbts int64, fr *backend.FlushRecord) (*dcrtimewallet.TxLookupResult, error) {
-	res, err := fs.wallet.Lookup(fr.Tx)
+func (fs *FileSystem) confirmAnchor(dbts int64, fr *backend.FlushRecord) (*dcrtimewallet.TxLookupResult, error) {
+	res, err := fs.lookupAnchor(fr)
 	if err != nil {
 		return nil, err
 	}
 
-	log.Debugf("lazyFlush confirmations: %v", res.Confirmations)
+	log.Debugf("confirmAnchor confirmations: %v", res.Confirmations)
 
 	if res.Confirmations == -1 {
 		return nil, errInvalidConfirmations
@@ -397,6 +741,7 @@ func (fs *FileSystem) lazyFlush(dbts int64, fr *backend.FlushRecord) (*dcrtimewa
 
 	// Reassign and write back flush record
 	fr.ChainTimestamp = res.Timestamp
+	fr.BlockHeight = res.BlockHeight
 
 	// Write back
 	payload, err := EncodeFlushRecord(*fr)
@@ -429,26 +774,36 @@ func (fs *FileSystem) getTimestamp(timestamp int64) (backend.TimestampResult, er
 	}
 
 	// Try opening database.
+	var fr *backend.FlushRecord
 	db, err := fs.openRead(timestamp)
+	if os.IsNotExist(err) {
+		// Compacted containers only exist in the archive.
+		fr, err = fs.archivedFlushRecord(timestamp)
+	}
 	if err != nil {
 		return gtme, err
 	}
 
 	// Check for flush record and use cached value instead of iterating
 	// over all digest records.
-	var fr *backend.FlushRecord
-	payload, err := db.Get([]byte(flushedKey), nil)
-	if err == nil {
-		db.Close() // Close db because we may write back to it.
+	if fr == nil {
+		payload, err := db.Get([]byte(flushedKey), nil)
+		if err == nil {
+			db.Close() // Close db because we may write back to it.
 
-		fr, err = DecodeFlushRecord(payload)
-		if err != nil {
-			return gtme, err
+			fr, err = DecodeFlushRecord(payload)
+			if err != nil {
+				return gtme, err
+			}
 		}
-
+	}
+	if fr != nil {
 		gtme.ErrorCode = backend.ErrorOK
 		gtme.Tx = fr.Tx
+		gtme.AnchorPrefix = fr.AnchorPrefix
+		gtme.Attestations = fr.Attestations
 		gtme.MerkleRoot = fr.Root
+		gtme.ReorgTimestamp = fr.Reorged
 
 		// Convert pointers
 		gtme.Digests = make([][sha256.Size]byte, 0, len(fr.Hashes))
@@ -527,30 +882,24 @@ func (fs *FileSystem) getDigest(now time.Time, current *leveldb.DB, digest [sha2
 		gdme.AnchoredTimestamp = 0
 		dbts := int64(binary.LittleEndian.Uint64(gdbts))
 
-		// Decode flushed record
-		db, err := fs.openRead(dbts)
-		if err != nil {
-			return gdme, err
-		}
-		defer db.Close()
-		var fr *backend.FlushRecord
-		payload, err := db.Get([]byte(flushedKey), nil)
-		if err != nil {
-			return gdme, err
-		}
-		db.Close()
-
-		fr, err = DecodeFlushRecord(payload)
+		// Decode flushed record, the container may have been
+		// compacted.
+		fr, err := fs.flushRecord(dbts)
 		if err != nil {
 			return gdme, err
 		}
 		gdme.AnchoredTimestamp = fr.ChainTimestamp
 		gdme.Tx = fr.Tx
+		gdme.AnchorPrefix = fr.AnchorPrefix
+		gdme.Attestations = fr.Attestations
 		gdme.MerkleRoot = fr.Root
+		gdme.ReorgTimestamp = fr.Reorged
 		// That pointer better not be nil!
 		gdme.MerklePath = *merkle.AuthPath(fr.Hashes, &digest)
 		gdme.Timestamp = fr.ServerTimestamp
 		gdme.FlushTimestamp = fr.FlushTimestamp
+		gdme.Label = digestLabel(gdbts)
+		gdme.Algorithm = digestAlgorithm(gdbts)
 
 		switch {
 		// Override error code during testing
@@ -577,19 +926,24 @@ func (fs *FileSystem) getDigest(now time.Time, current *leveldb.DB, digest [sha2
 
 			gdme.AnchoredTimestamp = fr.ChainTimestamp
 		}
+		if gdme.AnchoredTimestamp != 0 {
+			gdme.BlockHeight = fr.BlockHeight
+		}
 
 		return gdme, nil
 	}
 
 	// Lookup in current timestamp database, if it exists
 	if current != nil {
-		found, err := current.Has(digest[:], nil)
-		if err != nil {
+		value, err := current.Get(digest[:], nil)
+		if err != nil && !errors.Is(err, leveldb.ErrNotFound) {
 			return gdme, err
 		}
-		if found {
+		if err == nil {
 			gdme.ErrorCode = backend.ErrorOK
 			gdme.AnchoredTimestamp = 0 // Not anchored if current
+			gdme.Label = digestLabel(value)
+			gdme.Algorithm = digestAlgorithm(value)
 
 			// Override error code during testing
 			if fs.testing {
@@ -637,6 +991,9 @@ func (fs *FileSystem) getDigest(now time.Time, current *leveldb.DB, digest [sha2
 		}
 		dirTs := timestamp.Unix()
 		if fs.isFlushed(dirTs) {
+			if isFastContainer(dirTs) {
+				continue
+			}
 			// We hit a flushed dir so we should be done.
 			break
 		}
@@ -647,14 +1004,17 @@ func (fs *FileSystem) getDigest(now time.Time, current *leveldb.DB, digest [sha2
 			return gdme, err
 		}
 		defer dirDb.Close()
-		foundP, err = dirDb.Has(digest[:], nil)
-		if err != nil {
+		value, err := dirDb.Get(digest[:], nil)
+		if err != nil && !errors.Is(err, leveldb.ErrNotFound) {
 			return gdme, err
 		}
 		dirDb.Close()
+		foundP = err == nil
 		if foundP {
 			gdme.ErrorCode = backend.ErrorOK
 			gdme.AnchoredTimestamp = 0 // Dir not anchored yet
+			gdme.Label = digestLabel(value)
+			gdme.Algorithm = digestAlgorithm(value)
 
 			// Override error code during testing
 			if fs.testing {
@@ -670,18 +1030,12 @@ func (fs *FileSystem) getDigest(now time.Time, current *leveldb.DB, digest [sha2
 	return gdme, nil
 }
 
-// Get returns a GetResult for each provided digest.
+// getDigests returns a GetResult for each provided digest.
 //
-// Get satisfies the backend interface.
-func (fs *FileSystem) Get(digests [][sha256.Size]byte) ([]backend.GetResult, error) {
+// This function must be called with the READ lock held.
+func (fs *FileSystem) getDigests(digests [][sha256.Size]byte) ([]backend.GetResult, error) {
 	gdmes := make([]backend.GetResult, 0, len(digests))
 
-	// We need to be read locked from here on out.  Note that we are not
-	// locking/releasing.  This is by design in order to let all readers
-	// finish before a potential write occurs.
-	fs.RLock()
-	defer fs.RUnlock()
-
 	// Get current time rounded down.
 	ts := fs.now()
 
@@ -716,6 +1070,116 @@ func (fs *FileSystem) Get(digests [][sha256.Size]byte) ([]backend.GetResult, err
 	return gdmes, nil
 }
 
+// Get returns a GetResult for each provided digest.
+//
+// Get satisfies the backend interface.
+func (fs *FileSystem) Get(digests [][sha256.Size]byte) ([]backend.GetResult, error) {
+	// We need to be read locked from here on out.  Note that we are not
+	// locking/releasing.  This is by design in order to let all readers
+	// finish before a potential write occurs.
+	fs.RLock()
+	defer fs.RUnlock()
+
+	return fs.getDigests(digests)
+}
+
+// labelDigests returns all digests that were stored under the provided group
+// label.  Labels are not indexed so this walks the global database and all
+// containers that have not been flushed yet.
+//
+// This function must be called with the READ lock held.
+func (fs *FileSystem) labelDigests(label string) ([][sha256.Size]byte, error) {
+	digests := make([][sha256.Size]byte, 0, 64)
+	collect := func(db *leveldb.DB) error {
+		iter := db.NewIterator(nil, nil)
+		defer iter.Release()
+		for iter.Next() {
+			if bytes.Equal(iter.Key(), []byte(flushedKey)) {
+				continue
+			}
+			if digestLabel(iter.Value()) != label {
+				continue
+			}
+			var digest [sha256.Size]byte
+			copy(digest[:], iter.Key())
+			digests = append(digests, digest)
+		}
+		return iter.Error()
+	}
+
+	// Flushed digests live in the global database.
+	err := collect(fs.db)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get Dirs.
+	files, err := os.ReadDir(fs.root)
+	if err != nil {
+		return nil, err
+	}
+	dirs := make([]string, 0, len(files))
+	for _, file := range files {
+		// Skip global db.
+		if file.Name() == globalDBDir {
+			continue
+		}
+		if !file.IsDir() {
+			continue
+		}
+
+		dirs = append(dirs, file.Name())
+	}
+
+	// Reverse sort work.
+	sort.Sort(sort.Reverse(sort.StringSlice(dirs)))
+
+	// Walk directories backwards, including the current one, until we
+	// find a flushed database.  Everything older is in the global
+	// database.
+	for _, dir := range dirs {
+		timestamp, err := time.Parse(fStr, dir)
+		if err != nil {
+			continue
+		}
+		dirTs := timestamp.Unix()
+		if fs.isFlushed(dirTs) {
+			if isFastContainer(dirTs) {
+				continue
+			}
+			break
+		}
+
+		dirDb, err := fs.openRead(dirTs)
+		if err != nil {
+			return nil, err
+		}
+		err = collect(dirDb)
+		dirDb.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return digests, nil
+}
+
+// GetLabel returns a GetResult for each digest that was stored under the
+// provided group label.
+//
+// GetLabel satisfies the backend interface.
+func (fs *FileSystem) GetLabel(label string) ([]backend.GetResult, error) {
+	fs.RLock()
+	defer fs.RUnlock()
+
+	digests, err := fs.labelDigests(label)
+	if err != nil {
+		return nil, err
+	}
+
+	return fs.getDigests(digests)
+}
+
 // GetTimestamps is a required interface function.  In our case it retrieves
 // the digests for a given timestamp.
 //
@@ -784,13 +1248,14 @@ func (fs *FileSystem) LastDigests(n int32) ([]backend.GetResult, error) {
 			if len(results) >= int(n) {
 				break
 			}
-			if !files[i].IsDir() {
+			if !files[i].IsDir() && !isReserved(files[i].Name()) {
 				return nil, fmt.Errorf("unexpected file %v",
 					filepath.Join(fs.root, files[i].Name()))
 			}
 
-			// We can skip global
-			if files[i].Name() != "global" {
+			// We can skip global, the compacted archives, the
+			// api tokens and the lock
+			if !isReserved(files[i].Name()) {
 				// Ensure it is a valid timestamp
 				t, err := time.Parse(fStr, files[i].Name())
 				if err != nil {
@@ -840,21 +1305,27 @@ func (fs *FileSystem) LastDigests(n int32) ([]backend.GetResult, error) {
 
 // Put is a required interface function.  In our case it stores the provided
 // hashes in a database that lives in a container directory.  The container
-// directory is the current time in UTC rounded down to the last hour.
+// directory is the current time in UTC rounded down to the last hour, or to
+// the interval of the fast anchor whose prefix matches the label.  The
+// optional group label and digest algorithm are stored alongside the
+// collection timestamp of every accepted hash.
 //
 // Put satisfies the backend interface.
-func (fs *FileSystem) Put(hashes [][sha256.Size]byte) (int64, []backend.PutResult, error) {
+func (fs *FileSystem) Put(hashes [][sha256.Size]byte, label, algorithm string) (int64, []backend.PutResult, error) {
+	if fs.readOnly {
+		return 0, nil, backend.ErrReadOnly
+	}
+
 	// Operation must be atomic as we look things up before timestamping
 	// which might be racy when having concurrent timestamp requests.
 	fs.Lock()
 	defer fs.Unlock()
 	commit := fs.commit
 
-	// Get current time rounded down.
-	ts := fs.now().Unix()
-	now := fs.now().Format(fStr)
-	timestamp := make([]byte, 8)
-	binary.LittleEndian.PutUint64(timestamp, uint64(ts))
+	// Get current time rounded down to the container of the label.
+	ts := fs.containerTimestamp(label)
+	now := ts2dirname(ts)
+	value := encodeDigestValue(ts, label, algorithm)
 
 	// Prep return and unwind bits before taking mutex.
 	me := make([]backend.PutResult, 0, len(hashes))
@@ -942,6 +1413,9 @@ func (fs *FileSystem) Put(hashes [][sha256.Size]byte) (int64, []backend.PutResul
 			}
 			dirTs := timestamp.Unix()
 			if fs.isFlushed(dirTs) {
+				if isFastContainer(dirTs) {
+					continue
+				}
 				// We hit a flushed dir so we should be done.
 				break
 			}
@@ -977,8 +1451,7 @@ func (fs *FileSystem) Put(hashes [][sha256.Size]byte) (int64, []backend.PutResul
 
 		// Accept only if doesn't exist
 		if !foundP {
-			// Determine if we want to store some metadata.
-			batch.Put(hash[:], timestamp)
+			batch.Put(hash[:], value)
 
 			// Mark as successful.
 			me = append(me, backend.PutResult{
@@ -993,10 +1466,21 @@ func (fs *FileSystem) Put(hashes [][sha256.Size]byte) (int64, []backend.PutResul
 		return 0, []backend.PutResult{}, backend.ErrTryAgainLater
 	}
 
-	err = current.Write(batch, nil)
+	// Digests are only queued up to a limit in maintenance mode.
+	queued := int64(batch.Len())
+	if fs.maintenance && fs.queued+queued > fs.maxQueued {
+		return 0, []backend.PutResult{}, backend.ErrQueueFull
+	}
+
+	// The batch is written atomically and synced so that accepted
+	// digests survive a crash.
+	err = current.Write(batch, walSync)
 	if err != nil {
 		return 0, []backend.PutResult{}, err
 	}
+	if fs.maintenance {
+		fs.queued += queued
+	}
 
 	return ts, me, nil
 }
@@ -1006,7 +1490,14 @@ func (fs *FileSystem) Put(hashes [][sha256.Size]byte) (int64, []backend.PutResul
 //
 // Close satisfies the backend interface.
 func (fs *FileSystem) Close() {
-	// Block until last command is complete.
+	// Block notifications would wait for the lock.
+	if fs.blocksCancel != nil {
+		fs.blocksCancel()
+	}
+
+	// Block until last command and flush are complete.
+	fs.flushMtx.Lock()
+	defer fs.flushMtx.Unlock()
 	fs.Lock()
 	defer fs.Unlock()
 	defer log.Infof("Exiting")
@@ -1018,7 +1509,109 @@ func (fs *FileSystem) Close() {
 	if fs.wallet != nil {
 		fs.wallet.Close()
 	}
+	if fs.tokens != nil {
+		fs.tokens.Close()
+	}
+	if fs.webhooks != nil {
+		fs.webhooks.Close()
+	}
+	if fs.owners != nil {
+		fs.owners.Close()
+	}
+	if fs.metadata != nil {
+		fs.metadata.Close()
+	}
+	if fs.audit != nil {
+		fs.audit.Close()
+	}
+	if fs.stats != nil {
+		fs.stats.Close()
+	}
+	if fs.sessions != nil {
+		fs.sessions.Close()
+	}
+	if fs.wal != nil {
+		fs.wal.Close()
+	}
 	fs.db.Close()
+
+	// Release the root last.
+	if fs.lock != nil {
+		fs.lock.Close()
+	}
+}
+
+// PreviewWindow returns the would-be merkle root of the pending collection
+// that digests with the provided group label are currently stored in.
+//
+// PreviewWindow satisfies the backend interface.
+func (fs *FileSystem) PreviewWindow(label string) (*backend.WindowResult, error) {
+	fs.RLock()
+	defer fs.RUnlock()
+
+	ts := fs.containerTimestamp(label)
+	wr := &backend.WindowResult{
+		Timestamp: ts,
+	}
+	if fs.anchorBlocks != 0 && !isFastContainer(ts) {
+		wr.EndHeight = fs.windowHeight + fs.anchorBlocks
+	} else {
+		wr.Ends = fs.containerEnd(ts).Unix()
+	}
+
+	// Open current timestamp database.  There is nothing to preview if no
+	// digests were stored yet.
+	db, err := fs.openRead(ts)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return wr, nil
+		}
+		return nil, err
+	}
+	defer db.Close()
+
+	hashes := make([]*[sha256.Size]byte, 0, 4096)
+	iter := db.NewIterator(nil, nil)
+	for iter.Next() {
+		var digest [sha256.Size]byte
+		copy(digest[:], iter.Key())
+		hashes = append(hashes, &digest)
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		return nil, err
+	}
+
+	if len(hashes) != 0 {
+		wr.Digests = len(hashes)
+		wr.MerkleRoot = *merkle.Root(hashes)
+	}
+
+	return wr, nil
+}
+
+// WindowEnd returns the time or block height at which the collection with the
+// provided timestamp is flushed.  Windows that are defined by block height are
+// only known for the current window, fast containers of overrides that are no
+// longer configured have no known end.  This call satisfies the backend
+// interface.
+func (fs *FileSystem) WindowEnd(ts int64) (int64, int32, error) {
+	fs.RLock()
+	defer fs.RUnlock()
+
+	if !isFastContainer(ts) {
+		if fs.anchorBlocks != 0 {
+			if ts != fs.window {
+				return 0, 0, nil
+			}
+			return 0, fs.windowHeight + fs.anchorBlocks, nil
+		}
+		return fs.containerEnd(ts).Unix(), 0, nil
+	}
+	if int(ts%60) > len(fs.fastAnchors) {
+		return 0, 0, nil
+	}
+	return fs.containerEnd(ts).Unix(), 0, nil
 }
 
 // LastAnchor provides the info of last successful anchor
@@ -1128,60 +1721,327 @@ func (fs *FileSystem) GetBalance() (*backend.GetBalanceResult, error) {
 // internalNew creates the FileSystem context but does not launch background
 // bits.  This is used by the test packages.
 func internalNew(root string) (*FileSystem, error) {
+	// Refuse to share the root with another process before any database
+	// is opened.
+	lock, err := lockRoot(root)
+	if err != nil {
+		return nil, err
+	}
+
 	db, err := leveldb.OpenFile(filepath.Join(root, globalDBDir), nil)
 	if err != nil {
+		lock.Close()
+		return nil, err
+	}
+
+	tokens, err := leveldb.OpenFile(filepath.Join(root, tokensDBDir), nil)
+	if err != nil {
+		db.Close()
+		lock.Close()
+		return nil, err
+	}
+
+	webhooks, err := leveldb.OpenFile(filepath.Join(root, webhooksDBDir), nil)
+	if err != nil {
+		tokens.Close()
+		db.Close()
+		lock.Close()
+		return nil, err
+	}
+
+	owners, err := leveldb.OpenFile(filepath.Join(root, ownersDBDir), nil)
+	if err != nil {
+		webhooks.Close()
+		tokens.Close()
+		db.Close()
+		lock.Close()
+		return nil, err
+	}
+
+	metadata, err := leveldb.OpenFile(filepath.Join(root, metadataDBDir), nil)
+	if err != nil {
+		owners.Close()
+		webhooks.Close()
+		tokens.Close()
+		db.Close()
+		lock.Close()
+		return nil, err
+	}
+
+	audit, err := leveldb.OpenFile(filepath.Join(root, auditDBDir), nil)
+	if err != nil {
+		metadata.Close()
+		owners.Close()
+		webhooks.Close()
+		tokens.Close()
+		db.Close()
+		lock.Close()
+		return nil, err
+	}
+
+	stats, err := leveldb.OpenFile(filepath.Join(root, statsDBDir), nil)
+	if err != nil {
+		audit.Close()
+		metadata.Close()
+		owners.Close()
+		webhooks.Close()
+		tokens.Close()
+		db.Close()
+		lock.Close()
+		return nil, err
+	}
+
+	sessions, err := leveldb.OpenFile(filepath.Join(root, sessionsDBDir), nil)
+	if err != nil {
+		stats.Close()
+		audit.Close()
+		metadata.Close()
+		owners.Close()
+		webhooks.Close()
+		tokens.Close()
+		db.Close()
+		lock.Close()
+		return nil, err
+	}
+
+	wal, err := leveldb.OpenFile(filepath.Join(root, walDBDir), nil)
+	if err != nil {
+		sessions.Close()
+		stats.Close()
+		audit.Close()
+		metadata.Close()
+		owners.Close()
+		webhooks.Close()
+		tokens.Close()
+		db.Close()
+		lock.Close()
 		return nil, err
 	}
 
 	fs := &FileSystem{
 		cron:     cron.New(),
 		root:     root,
+		lock:     lock,
 		db:       db,
+		tokens:   tokens,
+		webhooks: webhooks,
+		owners:   owners,
+		metadata: metadata,
+		audit:    audit,
+		stats:    stats,
+		sessions: sessions,
+		wal:      wal,
 		duration: duration,
 		myNow:    time.Now,
 	}
+	fs.Tokens = sidestore.NewTokens(tokens, "")
+	fs.Webhooks = sidestore.NewWebhooks(webhooks, "")
+	fs.Owners = sidestore.NewOwners(owners, "", fs.anchored, fs.GetMetadata)
+	fs.Sessions = sidestore.NewSessions(sessions, "",
+		func() time.Time { return fs.myNow() },
+		func() bool { return fs.readOnly }, fs.Put)
 
 	return fs, nil
 }
 
-// New creates a new backend instance.  The caller should issue a Close once
-// the FileSystem backend is no longer needed.
-func New(root, cert, host, clientCert, clientKey string, enableCollections bool, confirmations int32, maxDigests int32, passphrase []byte) (*FileSystem, error) {
-	fs, err := internalNew(root)
+// New creates a new backend instance in cfg.DataDir that anchors through
+// cfg.Wallet.  Anchors that are not mined within AnchorRetry are replaced with
+// a higher fee, 0 disables replacements.  A window ends every AnchorBlocks
+// blocks instead of every hour if it is not 0.  The merkle root of every
+// flush is also attested by the secondary anchorers.  A read-only backend
+// refuses digests and neither flushes nor replaces anchors, confirmations of
+// existing anchors are still recorded.  A backend in maintenance mode queues
+// up to MaintenanceQueue pending digests instead and flushes them once
+// maintenance ends.  The wallet balance is monitored when LowBalance is set
+// and, if PauseLowBalance is set, digests are queued instead of flushed while
+// it is below LowBalance.  The merkle roots of flushed containers are
+// calculated on up to FlushWorkers goroutines.  Digest metadata is encrypted
+// at rest with EncryptionKey unless it is nil.  The caller should issue a
+// Close once the FileSystem backend is no longer needed.  The wallet is closed
+// by Close.
+func New(cfg backend.Config) (*FileSystem, error) {
+	fastAnchors, err := ParseFastAnchors(cfg.FastAnchors)
 	if err != nil {
 		return nil, err
 	}
-	fs.enableCollections = enableCollections
-	fs.confirmations = confirmations
-	fs.maxDigests = maxDigests
-
-	// Runtime bits
-	fs.wallet, err = dcrtimewallet.New(cert, host, clientCert, clientKey, passphrase)
+	if len(cfg.AnchorPrefix) > dcrtimewallet.MaxAnchorPrefixSize {
+		return nil, fmt.Errorf("anchor prefix too long: %v > %v",
+			len(cfg.AnchorPrefix), dcrtimewallet.MaxAnchorPrefixSize)
+	}
+	if cfg.AnchorBlocks < 0 {
+		return nil, fmt.Errorf("invalid anchor blocks: %v",
+			cfg.AnchorBlocks)
+	}
+	if cfg.ConfirmRefresh != 0 && cfg.ConfirmWorkers < 1 {
+		return nil, fmt.Errorf("invalid confirmation workers: %v",
+			cfg.ConfirmWorkers)
+	}
+	if cfg.LowBalance < 0 {
+		return nil, fmt.Errorf("invalid low balance: %v",
+			cfg.LowBalance)
+	}
+	if cfg.FlushWorkers < 1 {
+		return nil, fmt.Errorf("invalid flush workers: %v",
+			cfg.FlushWorkers)
+	}
+	aead, err := newAEAD(cfg.EncryptionKey)
 	if err != nil {
 		return nil, err
 	}
 
-	// Flushing backend reconciles uncommitted work to the global database.
-	start := time.Now()
-	flushed, err := fs.doFlush()
-	end := time.Since(start)
+	fs, err := internalNew(cfg.DataDir)
 	if err != nil {
 		return nil, err
 	}
+	fs.enableCollections = cfg.EnableCollections
+	fs.confirmations = cfg.Confirmations
+	fs.maxDigests = cfg.MaxDigests
+	fs.fastAnchors = fastAnchors
+	fs.anchorPrefix = cfg.AnchorPrefix
+	fs.anchorRetry = cfg.AnchorRetry
+	fs.anchorBlocks = cfg.AnchorBlocks
+	fs.readOnly = cfg.ReadOnly
+	fs.maxQueued = cfg.MaintenanceQueue
+	fs.confirmRefresh = cfg.ConfirmRefresh
+	fs.confirmWorkers = cfg.ConfirmWorkers
+	fs.lowBalance = cfg.LowBalance
+	fs.pauseLowBalance = cfg.PauseLowBalance
+	fs.flushWorkers = cfg.FlushWorkers
+	fs.aead = aead
+
+	// Runtime bits
+	fs.wallet = cfg.Wallet
+	fs.anchorers = cfg.Anchorers
+
+	// The current window must be known before older windows are flushed.
+	if fs.anchorBlocks != 0 {
+		err = fs.startWindow()
+		if err != nil {
+			return nil, err
+		}
+		log.Infof("Window %v ends at block %v", ts2dirname(fs.window),
+			fs.windowHeight+fs.anchorBlocks)
+	}
+
+	// Flushes that were interrupted are completed or rolled back before
+	// anything else touches the containers.  A read-only backend leaves
+	// them to the next instance that writes.
+	if !fs.readOnly {
+		start := time.Now()
+		replayed, err := fs.replayWAL()
+		if err != nil {
+			return nil, err
+		}
+		if replayed != 0 {
+			log.Infof("WAL: replayed %v flushes in %v", replayed,
+				time.Since(start))
+		}
+	}
+
+	// Nothing is stored, flushed or anchored.
+	if fs.readOnly {
+		log.Infof("Read-only: digests are refused and not flushed")
+		return fs, nil
+	}
+
+	// The startup flush is paused as well when funds are low.
+	if fs.lowBalance != 0 {
+		fs.checkBalance()
+	}
+
+	// Flushing backend reconciles uncommitted work to the global database
+	// unless it is queued until maintenance ends or funds are low.
+	if cfg.Maintenance {
+		err = fs.SetMaintenance(true)
+		if err != nil {
+			return nil, err
+		}
+	} else if fs.flushPaused() {
+		log.Warnf("Startup flusher: wallet balance below %v atoms, "+
+			"digests queued", fs.lowBalance)
+	} else {
+		start := time.Now()
+		flushed, err := fs.doFlush()
+		end := time.Since(start)
+		if err != nil {
+			return nil, err
+		}
 
-	if flushed != 0 {
-		log.Infof("Startup flusher: directories %v in %v", flushed, end)
+		if flushed != 0 {
+			log.Infof("Startup flusher: directories %v in %v",
+				flushed, end)
+		}
 	}
 
-	// Launch cron.
-	err = fs.cron.AddFunc(flushSchedule, func() {
+	// Launch cron.  Fast anchors and windows that are defined by block
+	// height require the flusher to run every minute.
+	schedule, interval := flushSchedule, fs.duration
+	if fs.anchorBlocks != 0 {
+		schedule, interval = fastSchedule, time.Minute
+	}
+	if len(fs.fastAnchors) != 0 {
+		schedule, interval = fastSchedule, time.Minute
+		for _, fa := range fs.fastAnchors {
+			log.Infof("Fast anchor: label prefix %q every %v",
+				fa.Prefix, fa.Interval)
+		}
+	}
+	err = fs.cron.AddFunc(schedule, func() {
 		fs.flusher()
 	})
 	if err != nil {
 		return nil, err
 	}
 
+	// Refresh the confirmations of recent anchors so that queries do not
+	// look them up.
+	if fs.confirmRefresh != 0 {
+		err = fs.cron.AddFunc("@every "+fs.confirmRefresh.String(),
+			func() {
+				if fs.subscribed() {
+					return
+				}
+				fs.refreshConfirmations()
+			})
+		if err != nil {
+			return nil, err
+		}
+		log.Infof("Confirmations: refreshed every %v by %v workers",
+			fs.confirmRefresh, fs.confirmWorkers)
+	}
+
+	// Watch the anchors that lack confirmations for reorganizations.
+	err = fs.cron.AddFunc(reorgSchedule, func() {
+		fs.reorgWatcher()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Monitor the wallet balance.
+	if fs.lowBalance != 0 {
+		err = fs.cron.AddFunc(balanceSchedule, func() {
+			fs.checkBalance()
+		})
+		if err != nil {
+			return nil, err
+		}
+		log.Infof("Wallet balance: warned below %v atoms, flushes "+
+			"paused %v", fs.lowBalance, fs.pauseLowBalance)
+	}
+
 	fs.cron.Start()
 
+	// Block notifications replace the polling above while the wallet
+	// delivers them.
+	ctx, cancel := context.WithCancel(context.Background())
+	fs.blocksCancel = cancel
+	go fs.blockSubscriber(ctx)
+
+	fs.healthMtx.Lock()
+	fs.lastFlusher = time.Now()
+	fs.flusherInterval = interval
+	fs.healthMtx.Unlock()
+
 	return fs, nil
 }
@@ -6,16 +6,28 @@ package filesystem
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha256"
+	"errors"
 	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/davecgh/go-spew/spew"
 	"github.com/decred/dcrd/chaincfg/chainhash"
+	"github.com/decred/dcrtime/dcrtimed/anchorer"
 	"github.com/decred/dcrtime/dcrtimed/backend"
+	"github.com/decred/dcrtime/dcrtimed/backend/testsuite"
+	"github.com/decred/dcrtime/dcrtimed/dcrtimewallet"
 	"github.com/decred/dcrtime/merkle"
 )
 
@@ -100,7 +112,7 @@ func TestGetDigests(t *testing.T) {
 		hashes = append(hashes, hash)
 	}
 
-	_, me, err := fs.Put(hashes)
+	_, me, err := fs.Put(hashes, "", "")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -235,7 +247,7 @@ func TestGetDigestsFoundInPrevious(t *testing.T) {
 		hashes = append(hashes, hash)
 	}
 
-	_, me, err := fs.Put(hashes)
+	_, me, err := fs.Put(hashes, "", "")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -343,7 +355,7 @@ func TestGetTimestamp(t *testing.T) {
 		hashes = append(hashes, hash)
 	}
 
-	timestamp, me, err := fs.Put(hashes)
+	timestamp, me, err := fs.Put(hashes, "", "")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -481,7 +493,7 @@ func TestPut(t *testing.T) {
 		hashes = append(hashes, hash)
 	}
 
-	timestamp, me, err := fs.Put(hashes)
+	timestamp, me, err := fs.Put(hashes, "", "")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -498,7 +510,7 @@ func TestPut(t *testing.T) {
 	}
 
 	// Try again, now we expect count ErrorExists (foundLocal).
-	_, me, err = fs.Put(hashes)
+	_, me, err = fs.Put(hashes, "", "")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -529,7 +541,7 @@ func TestPut(t *testing.T) {
 
 	// Try again, now we expect count ErrorExists from global database
 	// (foundGlobal).
-	_, me, err = fs.Put(hashes)
+	_, me, err = fs.Put(hashes, "", "")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -571,7 +583,7 @@ func TestPutFoundInPrevious(t *testing.T) {
 		hashes = append(hashes, hash)
 	}
 
-	timestamp, me, err := fs.Put(hashes)
+	timestamp, me, err := fs.Put(hashes, "", "")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -595,7 +607,7 @@ func TestPutFoundInPrevious(t *testing.T) {
 
 	// Try again, now we expect count ErrorExists from previous
 	// container(foundPrevious).
-	timestamp, me, err = fs.Put(hashes)
+	timestamp, me, err = fs.Put(hashes, "", "")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -646,7 +658,7 @@ func TestFlusher(t *testing.T) {
 		}
 
 		// Push hashes to database.
-		_, _, err = fs.Put(hashes)
+		_, _, err = fs.Put(hashes, "", "")
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -725,7 +737,7 @@ func TestFlusherSkipNow(t *testing.T) {
 		hashes = append(hashes, hash)
 	}
 
-	timestamp, me, err := fs.Put(hashes)
+	timestamp, me, err := fs.Put(hashes, "", "")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -747,3 +759,1632 @@ func TestFlusherSkipNow(t *testing.T) {
 		t.Fatalf("unexpected now to not be flushed")
 	}
 }
+
+func TestFlushConcurrentPut(t *testing.T) {
+	dir, err := os.MkdirTemp("", "dcrtimed.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	fs, err := internalNew(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Set testing flag.
+	fs.testing = true
+	fs.flushWorkers = 2
+
+	// Return our artificial timestamp
+	timestamp := fs.now().Unix()
+	fs.myNow = func() time.Time {
+		return time.Unix(timestamp, 0)
+	}
+
+	var flushed [][sha256.Size]byte
+	for i := 0; i < 10; i++ {
+		hash := [sha256.Size]byte{}
+		hash[0] = byte(i)
+		flushed = append(flushed, hash)
+	}
+	previous, _, err := fs.Put(flushed, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Move time forward by one duration.
+	timestamp = time.Unix(timestamp, 0).Add(fs.duration).Unix()
+
+	// Read the previous container and store digests in the current one
+	// before the flush is committed, like the flusher lets submissions do.
+	fs.Lock()
+	pf, err := fs.readFlush(previous)
+	fs.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var current [][sha256.Size]byte
+	for i := 0; i < 10; i++ {
+		hash := [sha256.Size]byte{}
+		hash[0] = byte(i + 100)
+		current = append(current, hash)
+	}
+	ts, me, err := fs.Put(current, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ts != timestamp {
+		t.Fatalf("unexpected timestamp got %v want %v", ts, timestamp)
+	}
+	for _, v := range me {
+		if v.ErrorCode != backend.ErrorOK {
+			t.Fatalf("unexpected error code %v", v.ErrorCode)
+		}
+	}
+
+	payload, err := fs.anchorFlush(pf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs.Lock()
+	err = fs.commitPendingFlush(pf, payload)
+	fs.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !fs.isFlushed(previous) {
+		t.Fatalf("expected %v to be flushed", previous)
+	}
+	if fs.isFlushed(timestamp) {
+		t.Fatalf("unexpected current container to be flushed")
+	}
+	grs, err := fs.Get(append(flushed, current...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, gr := range grs {
+		var code uint = foundGlobal
+		if i >= len(flushed) {
+			code = foundLocal
+		}
+		if gr.ErrorCode != code {
+			t.Fatalf("digest %x: got code %v want %v", gr.Digest,
+				gr.ErrorCode, code)
+		}
+		if code == foundGlobal && gr.Timestamp != previous {
+			t.Fatalf("digest %x: got timestamp %v want %v",
+				gr.Digest, gr.Timestamp, previous)
+		}
+	}
+}
+
+func TestMetadataEncryption(t *testing.T) {
+	dir, err := os.MkdirTemp("", "dcrtimed.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	fs, err := internalNew(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Store metadata before encryption is enabled.
+	plain := backend.Metadata{
+		Digest: [sha256.Size]byte{1},
+		Values: map[string]string{"name": "plain"},
+	}
+	if err := fs.PutMetadata([]backend.Metadata{plain}); err != nil {
+		t.Fatal(err)
+	}
+
+	keyFile := filepath.Join(dir, "metadata.key")
+	err = os.WriteFile(keyFile, []byte(strings.Repeat("ab", 32)+"\n"),
+		0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := LoadEncryptionKey(keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs.aead, err = newAEAD(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secret := backend.Metadata{
+		Digest: [sha256.Size]byte{2},
+		Values: map[string]string{"name": "secret"},
+	}
+	if err := fs.PutMetadata([]backend.Metadata{secret}); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := fs.metadata.Get(secret.Digest[:], nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(stored, []byte("secret")) {
+		t.Fatalf("metadata stored in plaintext: %s", stored)
+	}
+
+	digests := [][sha256.Size]byte{plain.Digest, secret.Digest}
+	mds, err := fs.GetMetadata(digests)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []string{"plain", "secret"} {
+		if mds[i] == nil || mds[i].Values["name"] != want {
+			t.Fatalf("metadata %v: got %v want %v", i, mds[i], want)
+		}
+	}
+
+	// Encrypted metadata is bound to its digest.
+	err = fs.metadata.Put(plain.Digest[:], stored, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.GetMetadata(digests[:1]); err == nil {
+		t.Fatalf("expected moved metadata to fail")
+	}
+
+	// It can not be read without the key.
+	fs.aead = nil
+	if _, err := fs.GetMetadata(digests[1:]); err != errEncrypted {
+		t.Fatalf("got %v want %v", err, errEncrypted)
+	}
+
+	err = os.WriteFile(keyFile, []byte("abcd"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadEncryptionKey(keyFile); err == nil {
+		t.Fatalf("expected short key to fail")
+	}
+}
+
+func TestMaintenance(t *testing.T) {
+	dir, err := os.MkdirTemp("", "dcrtimed.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	fs, err := internalNew(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Set testing flag.
+	fs.testing = true
+	fs.maxQueued = 8
+
+	// Return our artificial timestamp
+	timestamp := fs.now().Unix()
+	fs.myNow = func() time.Time {
+		return time.Unix(timestamp, 0)
+	}
+
+	hashes := func(first, count int) [][sha256.Size]byte {
+		var hashes [][sha256.Size]byte
+		for i := first; i < first+count; i++ {
+			hashes = append(hashes, [sha256.Size]byte{byte(i)})
+		}
+		return hashes
+	}
+
+	// Pending digests count towards the queue.
+	ts1, _, err := fs.Put(hashes(0, 5), "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = fs.SetMaintenance(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fs.Maintenance() || fs.queued != 5 {
+		t.Fatalf("got maintenance %v with %v digests queued",
+			fs.Maintenance(), fs.queued)
+	}
+
+	// Digests are queued up to the limit.
+	timestamp = time.Unix(timestamp, 0).Add(fs.duration).Unix()
+	ts2, _, err := fs.Put(hashes(5, 3), "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = fs.Put(hashes(8, 1), "", "")
+	if !errors.Is(err, backend.ErrQueueFull) {
+		t.Fatalf("got error %v, want %v", err, backend.ErrQueueFull)
+	}
+
+	// Nothing is flushed during maintenance.
+	timestamp = time.Unix(timestamp, 0).Add(fs.duration).Unix()
+	fs.flusher()
+	if fs.isFlushed(ts1) || fs.isFlushed(ts2) {
+		t.Fatal("flushed during maintenance")
+	}
+
+	// The queue is flushed once maintenance ends.
+	err = fs.SetMaintenance(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fs.Maintenance() {
+		t.Fatal("maintenance did not end")
+	}
+	if !fs.isFlushed(ts1) || !fs.isFlushed(ts2) {
+		t.Fatal("queue not flushed after maintenance")
+	}
+	_, _, err = fs.Put(hashes(8, 1), "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLowBalance(t *testing.T) {
+	dir, err := os.MkdirTemp("", "dcrtimed.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	fs, err := internalNew(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Set testing flag.
+	fs.testing = true
+	fs.wallet = testsuite.NewWallet()
+	fs.pauseLowBalance = true
+
+	// Return our artificial timestamp
+	timestamp := fs.now().Unix()
+	fs.myNow = func() time.Time {
+		return time.Unix(timestamp, 0)
+	}
+
+	ts, _, err := fs.Put([][sha256.Size]byte{{1}}, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	timestamp = time.Unix(timestamp, 0).Add(fs.duration).Unix()
+
+	// Nothing is flushed while the balance is low.
+	fs.lowBalance = availableBalance(&backend.GetBalanceResult{
+		Spendable:   testsuite.Balance.Spendable,
+		Unconfirmed: testsuite.Balance.Unconfirmed,
+	}) + 1
+	fs.checkBalance()
+	fs.flusher()
+	if fs.isFlushed(ts) {
+		t.Fatal("flushed while the balance is low")
+	}
+	sr, err := fs.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sr.LowBalance || !sr.FlushPaused || sr.PendingDigests != 1 ||
+		sr.Balance == nil ||
+		sr.Balance.Spendable != testsuite.Balance.Spendable {
+		t.Fatalf("unexpected status %v", spew.Sdump(sr))
+	}
+
+	// The queue is flushed once the balance recovered.
+	fs.lowBalance = 1
+	fs.checkBalance()
+	fs.flusher()
+	if !fs.isFlushed(ts) {
+		t.Fatal("queue not flushed after the balance recovered")
+	}
+	sr, err = fs.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sr.LowBalance || sr.FlushPaused {
+		t.Fatalf("unexpected status %v", spew.Sdump(sr))
+	}
+}
+
+func TestHealth(t *testing.T) {
+	dir, err := os.MkdirTemp("", "dcrtimed.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	fs, err := internalNew(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs.wallet = testsuite.NewWallet()
+	fs.flusherInterval = fs.duration
+
+	// A completed flusher run is recorded.
+	start := time.Now()
+	fs.flusher()
+	hr, err := fs.Health()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hr.WalletError != nil || hr.LastFlusher.Before(start) ||
+		hr.FlusherInterval != fs.duration {
+		t.Fatalf("unexpected health %v", spew.Sdump(hr))
+	}
+
+	// A closed backend is unhealthy.
+	fs.Close()
+	_, err = fs.Health()
+	if err == nil {
+		t.Fatal("expected closed backend to be unhealthy")
+	}
+}
+
+func TestGetLabel(t *testing.T) {
+	dir, err := os.MkdirTemp("", "dcrtimed.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	fs, err := internalNew(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Set testing flag.
+	fs.testing = true
+
+	// Return our artificial timestamp
+	timestamp := fs.now().Unix()
+	fs.myNow = func() time.Time {
+		return time.Unix(timestamp, 0)
+	}
+
+	// Put two labeled batches and one unlabeled batch.
+	labels := []string{"job1", "job2", ""}
+	count := 10
+	for i, label := range labels {
+		var hashes [][sha256.Size]byte
+		for j := 0; j < count; j++ {
+			hash := [sha256.Size]byte{}
+			hash[0] = byte(j + i*10)
+			hashes = append(hashes, hash)
+		}
+		_, _, err = fs.Put(hashes, label, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Move time forward, flush previous container and add more digests
+	// to job1 in the current container.
+	timestamp = time.Unix(timestamp, 0).Add(fs.duration).Unix()
+	flushed, err := fs.doFlush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if flushed != 1 {
+		t.Fatalf("unexpected flushed got %v want 1", flushed)
+	}
+	var hashes [][sha256.Size]byte
+	for j := 0; j < count; j++ {
+		hash := [sha256.Size]byte{}
+		hash[0] = byte(j + 100)
+		hashes = append(hashes, hash)
+	}
+	_, _, err = fs.Put(hashes, "job1", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		label  string
+		global int
+		local  int
+	}{
+		{"job1", count, count},
+		{"job2", count, 0},
+		{"job3", 0, 0},
+	}
+	for _, test := range tests {
+		grs, err := fs.GetLabel(test.label)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(grs) != test.global+test.local {
+			t.Fatalf("%v: expected %v GetResult got %v", test.label,
+				test.global+test.local, len(grs))
+		}
+		var global, local int
+		for _, gr := range grs {
+			if gr.Label != test.label {
+				t.Fatalf("%v: unexpected label %v", test.label,
+					gr.Label)
+			}
+			switch gr.ErrorCode {
+			case foundGlobal:
+				global++
+			case foundLocal:
+				local++
+			default:
+				t.Fatalf("%v: unexpected ErrorCode %v", test.label,
+					gr.ErrorCode)
+			}
+		}
+		if global != test.global || local != test.local {
+			t.Fatalf("%v: got global %v local %v want %v %v",
+				test.label, global, local, test.global, test.local)
+		}
+	}
+}
+
+func TestCompact(t *testing.T) {
+	dir, err := os.MkdirTemp("", "dcrtimed.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	fs, err := internalNew(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+
+	// Anchor through a wallet that confirms immediately.
+	wallet := testsuite.NewWallet()
+	wallet.SetConfirmations(1)
+	fs.wallet = wallet
+	fs.confirmations = 1
+	fs.enableCollections = true
+
+	// Return our artificial timestamp
+	timestamp := fs.now().Unix()
+	fs.myNow = func() time.Time {
+		return time.Unix(timestamp, 0)
+	}
+
+	// Fill and flush a bunch of containers.
+	buckets := 5
+	count := 10
+	var hashes [][sha256.Size]byte
+	var timestamps []int64
+	for i := 0; i < buckets; i++ {
+		for j := 0; j < count; j++ {
+			hash := [sha256.Size]byte{}
+			hash[0] = byte(j + i*10)
+			hashes = append(hashes, hash)
+		}
+		ts, _, err := fs.Put(hashes[i*count:], "", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		timestamps = append(timestamps, ts)
+		timestamp = time.Unix(timestamp, 0).Add(fs.duration).Unix()
+	}
+	_, err = fs.doFlush()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only confirmed anchors are compacted, confirm all but the first.
+	_, err = fs.GetTimestamps(timestamps[1:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Leave some orphans behind.
+	tmp := filepath.Join(dir, "restore.tmp")
+	err = os.WriteFile(tmp, []byte{}, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	empty := filepath.Join(dir, ts2dirname(timestamps[0]-3600))
+	err = os.Mkdir(empty, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Dry run.
+	options := &CompactOptions{
+		Before: time.Unix(timestamp, 0),
+	}
+	cr, err := fs.Compact(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cr.Compacted) != buckets-2 || len(cr.Skipped) != 1 ||
+		len(cr.Pruned) != 2 {
+		t.Fatalf("unexpected dry run %v", spew.Sdump(cr))
+	}
+	if _, err := os.Stat(tmp); err != nil {
+		t.Fatalf("dry run pruned %v", tmp)
+	}
+
+	// Compact and prune.
+	options.Fix = true
+	_, err = fs.Compact(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{tmp, empty} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Fatalf("not pruned %v", path)
+		}
+	}
+	for i, ts := range timestamps {
+		compacted := i != 0 && i != buckets-1
+		_, err := os.Stat(filepath.Join(dir, ts2dirname(ts)))
+		if os.IsNotExist(err) != compacted {
+			t.Fatalf("%v: compacted %v", ts2dirname(ts), !compacted)
+		}
+	}
+
+	// Everything is still retrievable.
+	grs, err := fs.Get(hashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, gr := range grs {
+		if gr.ErrorCode != backend.ErrorOK {
+			t.Fatalf("%x: unexpected ErrorCode %v", gr.Digest,
+				gr.ErrorCode)
+		}
+		if gr.Timestamp != timestamps[i/count] {
+			t.Fatalf("%x: got timestamp %v want %v", gr.Digest,
+				gr.Timestamp, timestamps[i/count])
+		}
+		root, err := merkle.VerifyAuthPath(&gr.MerklePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if *root != gr.MerkleRoot {
+			t.Fatalf("%x: invalid merkle path", gr.Digest)
+		}
+	}
+	trs, err := fs.GetTimestamps(timestamps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, tr := range trs {
+		if tr.ErrorCode != backend.ErrorOK || len(tr.Digests) != count {
+			t.Fatalf("%v: unexpected result %v", timestamps[i],
+				spew.Sdump(tr))
+		}
+	}
+
+	// Disk usage accounts for the archive.
+	eus, err := fs.DiskUsage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var containers, archived int
+	for _, eu := range eus {
+		containers += eu.Containers
+		archived += eu.Archived
+	}
+	if containers != 2 || archived != buckets-2 {
+		t.Fatalf("unexpected disk usage %v", spew.Sdump(eus))
+	}
+}
+
+func TestLock(t *testing.T) {
+	dir, err := os.MkdirTemp("", "dcrtimed.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	fs, err := internalNew(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A second instance is refused while the first one runs.
+	_, err = internalNew(dir)
+	if !errors.Is(err, ErrLocked) {
+		t.Fatalf("got %v want %v", err, ErrLocked)
+	}
+	_, err = NewDump(dir)
+	if !errors.Is(err, ErrLocked) {
+		t.Fatalf("dump: got %v want %v", err, ErrLocked)
+	}
+
+	// The lock file that is left behind does not prevent a restart.
+	fs.Close()
+	fs, err = internalNew(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs.Close()
+}
+
+func TestAnchorPrefix(t *testing.T) {
+	dir, err := os.MkdirTemp("", "dcrtimed.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	fs, err := internalNew(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+
+	// Anchor through a wallet that confirms immediately.
+	wallet := testsuite.NewWallet()
+	wallet.SetConfirmations(1)
+	fs.wallet = wallet
+	fs.confirmations = 1
+	fs.enableCollections = true
+	fs.anchorPrefix = "acme"
+
+	// Return our artificial timestamp
+	timestamp := fs.now().Unix()
+	fs.myNow = func() time.Time {
+		return time.Unix(timestamp, 0)
+	}
+
+	digest := [sha256.Size]byte{0x01}
+	ts, _, err := fs.Put([][sha256.Size]byte{digest}, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	timestamp = time.Unix(timestamp, 0).Add(fs.duration).Unix()
+	_, err = fs.doFlush()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The prefix is returned with digests and collections.
+	grs, err := fs.Get([][sha256.Size]byte{digest})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if grs[0].ErrorCode != backend.ErrorOK ||
+		grs[0].AnchorPrefix != fs.anchorPrefix {
+		t.Fatalf("unexpected result %v", spew.Sdump(grs[0]))
+	}
+	trs, err := fs.GetTimestamps([]int64{ts})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if trs[0].ErrorCode != backend.ErrorOK ||
+		trs[0].AnchorPrefix != fs.anchorPrefix {
+		t.Fatalf("unexpected result %v", spew.Sdump(trs[0]))
+	}
+
+	// The merkle root is found behind the prefix of an anchor script.
+	for _, prefix := range []string{"", fs.anchorPrefix} {
+		script, err := dcrtimewallet.AnchorScript(grs[0].MerkleRoot,
+			[]byte(prefix))
+		if err != nil {
+			t.Fatal(err)
+		}
+		root := extractNullDataMerkleRootV0(script)
+		if !bytes.Equal(root, grs[0].MerkleRoot[:]) {
+			t.Fatalf("prefix %q: got root %x want %x", prefix, root,
+				grs[0].MerkleRoot)
+		}
+	}
+}
+
+func TestReplaceAnchor(t *testing.T) {
+	dir, err := os.MkdirTemp("", "dcrtimed.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	fs, err := internalNew(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+
+	// Anchor through a wallet that never mines.
+	wallet := testsuite.NewWallet()
+	fs.wallet = wallet
+	fs.confirmations = 1
+	fs.anchorRetry = time.Hour
+
+	// Return our artificial timestamp
+	timestamp := fs.now().Unix()
+	fs.myNow = func() time.Time {
+		return time.Unix(timestamp, 0)
+	}
+
+	digest := [sha256.Size]byte{0x01}
+	ts, _, err := fs.Put([][sha256.Size]byte{digest}, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	timestamp = time.Unix(timestamp, 0).Add(fs.duration).Unix()
+	_, err = fs.doFlush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fr, err := fs.flushRecord(ts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	anchor := fr.Tx
+
+	// Nothing is replaced within the window.
+	timestamp = fr.FlushTimestamp
+	count, err := fs.replaceAnchors()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Fatalf("replaced %v anchors within the window", count)
+	}
+
+	// The anchor is replaced once the window passed and the replacement
+	// gets a window of its own.
+	timestamp = time.Unix(fr.FlushTimestamp, 0).Add(90 * time.Minute).Unix()
+	for i := 0; i < 2; i++ {
+		count, err = fs.replaceAnchors()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != 1-i {
+			t.Fatalf("run %v: replaced %v anchors", i, count)
+		}
+	}
+	if wallet.Replacements() != 1 {
+		t.Fatalf("got %v replacements", wallet.Replacements())
+	}
+	fr, err = fs.flushRecord(ts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fr.Replaced) != 1 || fr.Replaced[0] != anchor ||
+		fr.Tx == anchor {
+		t.Fatalf("unexpected flush record %v", spew.Sdump(fr))
+	}
+
+	// The digest is proven by the replacement once it is mined.
+	wallet.SetConfirmations(1)
+	grs, err := fs.Get([][sha256.Size]byte{digest})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if grs[0].ErrorCode != backend.ErrorOK || grs[0].Tx != fr.Tx ||
+		grs[0].AnchoredTimestamp == 0 {
+		t.Fatalf("unexpected result %v", spew.Sdump(grs[0]))
+	}
+
+	// Mined anchors are never replaced.
+	timestamp = time.Unix(fr.FlushTimestamp, 0).Add(24 * time.Hour).Unix()
+	count, err = fs.replaceAnchors()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Fatalf("replaced %v mined anchors", count)
+	}
+}
+
+func TestConfirmRefresh(t *testing.T) {
+	dir, err := os.MkdirTemp("", "dcrtimed.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	fs, err := internalNew(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+
+	wallet := testsuite.NewWallet()
+	fs.wallet = wallet
+	fs.confirmations = 2
+	fs.confirmRefresh = time.Minute
+	fs.confirmWorkers = 2
+
+	// Return our artificial timestamp
+	timestamp := fs.now().Unix()
+	fs.myNow = func() time.Time {
+		return time.Unix(timestamp, 0)
+	}
+
+	digests := [][sha256.Size]byte{{0x01}, {0x02}}
+	for _, digest := range digests {
+		_, _, err := fs.Put([][sha256.Size]byte{digest}, "", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		timestamp = time.Unix(timestamp, 0).Add(fs.duration).Unix()
+		_, err = fs.doFlush()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Queries return the refreshed confirmations without looking up the
+	// anchors.
+	wallet.SetConfirmations(1)
+	fs.refreshConfirmations()
+	if len(fs.confirmed) != len(digests) {
+		t.Fatalf("got %v refreshed anchors, want %v", len(fs.confirmed),
+			len(digests))
+	}
+	wallet.SetConfirmations(2)
+	grs, err := fs.Get(digests)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, gr := range grs {
+		if gr.AnchoredTimestamp != 0 || gr.Confirmations == nil ||
+			*gr.Confirmations != 1 {
+			t.Fatalf("unexpected result %v", spew.Sdump(gr))
+		}
+	}
+
+	// Anchors with enough confirmations are written back by the refresh.
+	fs.refreshConfirmations()
+	if len(fs.confirmed) != 0 {
+		t.Fatalf("got %v refreshed anchors, want 0", len(fs.confirmed))
+	}
+	wallet.SetConfirmations(1)
+	grs, err = fs.Get(digests)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, gr := range grs {
+		if gr.AnchoredTimestamp == 0 || gr.Confirmations != nil {
+			t.Fatalf("unexpected result %v", spew.Sdump(gr))
+		}
+	}
+}
+
+func TestReorg(t *testing.T) {
+	dir, err := os.MkdirTemp("", "dcrtimed.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	fs, err := internalNew(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+
+	wallet := testsuite.NewWallet()
+	fs.wallet = wallet
+	fs.confirmations = 2
+
+	// Return our artificial timestamp
+	timestamp := fs.now().Unix()
+	fs.myNow = func() time.Time {
+		return time.Unix(timestamp, 0)
+	}
+
+	digest := [sha256.Size]byte{0x01}
+	_, _, err = fs.Put([][sha256.Size]byte{digest}, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := fs.now().Unix()
+	timestamp = time.Unix(timestamp, 0).Add(fs.duration).Unix()
+	_, err = fs.doFlush()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(want int) *backend.FlushRecord {
+		t.Helper()
+		count, err := fs.checkReorgs()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != want {
+			t.Fatalf("got %v reorganized anchors, want %v", count,
+				want)
+		}
+		fr, err := fs.flushRecord(ts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fr
+	}
+
+	// Mined anchors are not reorganized.
+	wallet.SetConfirmations(1)
+	fr := check(0)
+	if fr.MinedBlock == (chainhash.Hash{}) || fr.Reorged != 0 {
+		t.Fatalf("unexpected flush record %v", spew.Sdump(fr))
+	}
+
+	// An anchor that returned to the mempool is marked reorganized.
+	wallet.SetConfirmations(0)
+	fr = check(1)
+	if fr.MinedBlock != (chainhash.Hash{}) || fr.Reorged != timestamp {
+		t.Fatalf("unexpected flush record %v", spew.Sdump(fr))
+	}
+	grs, err := fs.Get([][sha256.Size]byte{digest})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if grs[0].ReorgTimestamp != timestamp {
+		t.Fatalf("got reorg timestamp %v, want %v",
+			grs[0].ReorgTimestamp, timestamp)
+	}
+
+	// An anchor that was dropped is replaced.
+	wallet.SetConfirmations(1)
+	fr = check(0)
+	tx := fr.Tx
+	wallet.Drop(tx)
+	fr = check(1)
+	if wallet.Replacements() != 1 || fr.Tx == tx ||
+		len(fr.Replaced) != 1 || fr.Replaced[0] != tx {
+		t.Fatalf("unexpected flush record %v", spew.Sdump(fr))
+	}
+
+	// The replacement is mined.
+	fr = check(0)
+	if fr.MinedBlock == (chainhash.Hash{}) {
+		t.Fatalf("unexpected flush record %v", spew.Sdump(fr))
+	}
+}
+
+func TestBlockNotifications(t *testing.T) {
+	dir, err := os.MkdirTemp("", "dcrtimed.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	fs, err := internalNew(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+
+	wallet := testsuite.NewWallet()
+	fs.wallet = wallet
+	fs.confirmations = 2
+	fs.confirmRefresh = time.Minute
+	fs.confirmWorkers = 1
+
+	_, _, err = fs.Put([][sha256.Size]byte{{0x01}}, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	timestamp := fs.now().Add(fs.duration).Unix()
+	fs.myNow = func() time.Time {
+		return time.Unix(timestamp, 0)
+	}
+	_, err = fs.doFlush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wallet.SetConfirmations(1)
+
+	// A notified block refreshes the confirmations.
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		fs.blockSubscriber(ctx)
+		close(done)
+	}()
+	refreshed := func() int {
+		fs.confirmMtx.Lock()
+		defer fs.confirmMtx.Unlock()
+		return len(fs.confirmed)
+	}
+	for height := int32(1); !fs.subscribed() || refreshed() == 0; height++ {
+		if height > 100 {
+			t.Fatal("block notifications not received")
+		}
+		wallet.SetHeight(height)
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cancel()
+	<-done
+	if fs.subscribed() {
+		t.Fatal("still subscribed")
+	}
+}
+
+func TestBlockWindow(t *testing.T) {
+	dir, err := os.MkdirTemp("", "dcrtimed.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	fs, err := internalNew(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+
+	wallet := testsuite.NewWallet()
+	wallet.SetHeight(100)
+	fs.wallet = wallet
+	fs.anchorBlocks = 12
+
+	// Return our artificial timestamp
+	timestamp := time.Now().Truncate(time.Minute).Unix()
+	fs.myNow = func() time.Time {
+		return time.Unix(timestamp, 0)
+	}
+	err = fs.startWindow()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fs.window != timestamp {
+		t.Fatalf("window %v, want %v", fs.window, timestamp)
+	}
+
+	digest := [sha256.Size]byte{0x01}
+	ts, _, err := fs.Put([][sha256.Size]byte{digest}, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ts != timestamp {
+		t.Fatalf("digest in window %v, want %v", ts, timestamp)
+	}
+	wr, err := fs.PreviewWindow("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wr.Timestamp != ts || wr.EndHeight != 112 || wr.Ends != 0 {
+		t.Fatalf("unexpected window %v", spew.Sdump(wr))
+	}
+
+	// The window does not end with the hour.
+	timestamp += int64(2 * time.Hour / time.Second)
+	fs.advanceWindow()
+	count, err := fs.doFlush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 || fs.window != ts {
+		t.Fatalf("window %v ended early, flushed %v", ts, count)
+	}
+
+	// A window is resumed on startup.
+	err = fs.startWindow()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fs.window != ts {
+		t.Fatalf("window %v not resumed: %v", ts, fs.window)
+	}
+
+	// The window ends once the chain advanced.
+	wallet.SetHeight(112)
+	fs.advanceWindow()
+	if fs.window != timestamp || fs.windowHeight != 112 {
+		t.Fatalf("window %v height %v did not start", fs.window,
+			fs.windowHeight)
+	}
+	count, err = fs.doFlush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 || !fs.isFlushed(ts) {
+		t.Fatalf("window %v not flushed: %v", ts, count)
+	}
+
+	// A flushed window is not resumed.
+	err = fs.startWindow()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fs.window != timestamp {
+		t.Fatalf("window %v, want %v", fs.window, timestamp)
+	}
+}
+
+func TestAttestations(t *testing.T) {
+	dir, err := os.MkdirTemp("", "dcrtimed.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	fs, err := internalNew(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+
+	// The calendar replies with the submitted digest as the proof.
+	calendar := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/digest" {
+			http.NotFound(w, r)
+			return
+		}
+		b, _ := io.ReadAll(r.Body)
+		w.Write(append([]byte("pending"), b...))
+	}))
+	defer calendar.Close()
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusServiceUnavailable)
+	}))
+	defer broken.Close()
+
+	fs.anchorers, err = anchorer.ParseAll([]string{
+		anchorer.TypeOTS + ":" + broken.URL,
+		anchorer.TypeOTS + ":" + calendar.URL,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Anchor through a wallet that confirms immediately.
+	wallet := testsuite.NewWallet()
+	wallet.SetConfirmations(1)
+	fs.wallet = wallet
+	fs.confirmations = 1
+
+	// Return our artificial timestamp
+	timestamp := fs.now().Unix()
+	fs.myNow = func() time.Time {
+		return time.Unix(timestamp, 0)
+	}
+
+	digest := [sha256.Size]byte{0x01}
+	_, _, err = fs.Put([][sha256.Size]byte{digest}, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	timestamp = time.Unix(timestamp, 0).Add(fs.duration).Unix()
+	count, err := fs.doFlush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Fatalf("flushed %v containers", count)
+	}
+
+	// The failing anchorer is skipped.
+	grs, err := fs.Get([][sha256.Size]byte{digest})
+	if err != nil {
+		t.Fatal(err)
+	}
+	as := grs[0].Attestations
+	if grs[0].ErrorCode != backend.ErrorOK || len(as) != 1 ||
+		as[0].Anchorer != fs.anchorers[1].Name() ||
+		!bytes.Equal(as[0].Proof, append([]byte("pending"),
+			grs[0].MerkleRoot[:]...)) {
+		t.Fatalf("unexpected result %v", spew.Sdump(grs[0]))
+	}
+}
+
+func TestParseFastAnchors(t *testing.T) {
+	fas, err := ParseFastAnchors([]string{"acme-:10m", "a:b:5m"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []FastAnchor{
+		{Prefix: "acme-", Interval: 10 * time.Minute},
+		{Prefix: "a:b", Interval: 5 * time.Minute},
+	}
+	if !reflect.DeepEqual(fas, want) {
+		t.Fatalf("want %v got %v", want, fas)
+	}
+
+	for _, v := range []string{"acme-", ":10m", "acme-:10", "acme-:30s",
+		"acme-:7m", "acme-:1h", "acme-:90s"} {
+		_, err := ParseFastAnchors([]string{v})
+		if err == nil {
+			t.Fatalf("expected error for %q", v)
+		}
+	}
+}
+
+// TestFastAnchor verifies that digests with a fast anchor label are flushed
+// once their window is over, independently of the hourly container.
+func TestFastAnchor(t *testing.T) {
+	dir, err := os.MkdirTemp("", "dcrtimed.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	fs, err := internalNew(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+
+	// Set testing flag.
+	fs.testing = true
+	fs.fastAnchors = []FastAnchor{{Prefix: "fast-", Interval: 10 * time.Minute}}
+
+	hour := fs.now().Unix()
+	setNow := func(d time.Duration) {
+		fs.myNow = func() time.Time {
+			return time.Unix(hour, 0).Add(d)
+		}
+	}
+	setNow(5 * time.Minute)
+
+	digest := func(i int) [sha256.Size]byte {
+		return [sha256.Size]byte{byte(i)}
+	}
+
+	// Fast and hourly digests end up in different containers.
+	ts, me, err := fs.Put([][sha256.Size]byte{digest(0)}, "fast-1", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ts != hour+1 || me[0].ErrorCode != backend.ErrorOK {
+		t.Fatalf("fast put: got %v %v want %v", ts, me[0].ErrorCode,
+			hour+1)
+	}
+	ts, _, err = fs.Put([][sha256.Size]byte{digest(1)}, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ts != hour {
+		t.Fatalf("hourly put: got %v want %v", ts, hour)
+	}
+
+	// Nothing is flushed while both windows are open.
+	count, err := fs.doFlush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Fatalf("flushed %v containers, want 0", count)
+	}
+
+	// The fast container is flushed once its window is over.
+	setNow(12 * time.Minute)
+	count, err = fs.doFlush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 || !fs.isFlushed(hour+1) || fs.isFlushed(hour) {
+		t.Fatalf("expected only the fast container to be flushed")
+	}
+
+	// Hourly digests are still found before they are flushed.
+	_, me, err = fs.Put([][sha256.Size]byte{digest(1), digest(2)},
+		"fast-2", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if me[0].ErrorCode != foundPrevious || me[1].ErrorCode != backend.ErrorOK {
+		t.Fatalf("unexpected put results: %v", spew.Sdump(me))
+	}
+	grs, err := fs.Get([][sha256.Size]byte{digest(0), digest(1),
+		digest(2)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []uint{foundGlobal, foundLocal, foundPrevious}
+	for k, gr := range grs {
+		if gr.ErrorCode != want[k] {
+			t.Fatalf("digest %v: got %v want %v", k, gr.ErrorCode,
+				want[k])
+		}
+	}
+
+	// Everything is flushed in the next hour.
+	setNow(fs.duration + 10*time.Minute)
+	count, err = fs.doFlush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Fatalf("flushed %v containers, want 2", count)
+	}
+	grs, err = fs.Get([][sha256.Size]byte{digest(0), digest(1),
+		digest(2)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, gr := range grs {
+		if gr.ErrorCode != foundGlobal {
+			t.Fatalf("digest %v: got %v want %v", k, gr.ErrorCode,
+				foundGlobal)
+		}
+	}
+}
+
+func TestReplayWAL(t *testing.T) {
+	dir, err := os.MkdirTemp("", "dcrtimed.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	fs, err := internalNew(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+
+	// Set testing flag.
+	fs.testing = true
+
+	timestamp := fs.now().Unix()
+	fs.myNow = func() time.Time {
+		return time.Unix(timestamp, 0)
+	}
+
+	hashes := make([][sha256.Size]byte, 0, 4)
+	digests := make([]*[sha256.Size]byte, 0, 4)
+	for i := 0; i < 4; i++ {
+		hash := sha256.Sum256([]byte{byte(i)})
+		hashes = append(hashes, hash)
+		digests = append(digests, &hash)
+	}
+	_, _, err = fs.Put(hashes, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mt := merkle.Tree(digests)
+	root := *mt[len(mt)-1]
+
+	// A flush that was interrupted before it was anchored is rolled back.
+	err = fs.walPut(walEntry{Timestamp: timestamp, Root: root})
+	if err != nil {
+		t.Fatal(err)
+	}
+	replayed, err := fs.replayWAL()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replayed != 1 {
+		t.Fatalf("replayed %v entries, want 1", replayed)
+	}
+	if fs.isFlushed(timestamp) {
+		t.Fatal("rolled back container is flushed")
+	}
+
+	// A flush that was interrupted after it was anchored is completed.
+	payload, err := EncodeFlushRecord(backend.FlushRecord{
+		Root:            root,
+		Hashes:          mt[:len(digests)],
+		ServerTimestamp: timestamp,
+		FlushTimestamp:  timestamp,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = fs.walPut(walEntry{
+		Timestamp: timestamp,
+		Root:      root,
+		Record:    payload,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	replayed, err = fs.replayWAL()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replayed != 1 {
+		t.Fatalf("replayed %v entries, want 1", replayed)
+	}
+	if !fs.isFlushed(timestamp) {
+		t.Fatal("completed container is not flushed")
+	}
+	err = fs.flush(timestamp)
+	if !errors.Is(err, errAlreadyFlushed) {
+		t.Fatalf("got %v want %v", err, errAlreadyFlushed)
+	}
+
+	fs.myNow = func() time.Time {
+		return time.Unix(timestamp, 0).Add(fs.duration)
+	}
+	grs, err := fs.Get(hashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, gr := range grs {
+		if gr.ErrorCode != foundGlobal {
+			t.Fatalf("digest %v: got %v want %v", k, gr.ErrorCode,
+				foundGlobal)
+		}
+	}
+
+	// Nothing is left to replay.
+	replayed, err = fs.replayWAL()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replayed != 0 {
+		t.Fatalf("replayed %v entries, want 0", replayed)
+	}
+}
+
+func TestFlushConcurrentMaintenance(t *testing.T) {
+	dir, err := os.MkdirTemp("", "dcrtimed.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	fs, err := internalNew(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+
+	wallet := testsuite.NewWallet()
+	fs.wallet = wallet
+	fs.enableCollections = true
+	fs.confirmations = 2
+	fs.flushWorkers = 2
+	fs.maxQueued = 1 << 20
+
+	// Return our artificial timestamp, which the submitter moves forward
+	// while the other goroutines run.
+	var timestamp atomic.Int64
+	timestamp.Store(fs.now().Unix())
+	fs.myNow = func() time.Time {
+		return time.Unix(timestamp.Load(), 0)
+	}
+
+	const (
+		owner  = "owner"
+		rounds = 12
+	)
+	var (
+		wg       sync.WaitGroup
+		done     = make(chan struct{})
+		previous = make(chan int64, rounds)
+		errs     = make(chan error, 16)
+		deleted  = make(map[int64]struct{})
+		stored   = make(map[int64][][sha256.Size]byte)
+	)
+	fail := func(err error) {
+		select {
+		case errs <- err:
+		default:
+		}
+	}
+
+	// Flushes, reorg checks, maintenance and deletions of collections
+	// that are no longer current all race each other and submissions.
+	loop := func(f func(i int)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; ; i++ {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				f(i)
+			}
+		}()
+	}
+	loop(func(int) {
+		fs.flusher()
+	})
+	loop(func(i int) {
+		wallet.SetConfirmations(int32(i % 3))
+		fs.watchReorgs(int32(i), true)
+	})
+	loop(func(i int) {
+		if err := fs.SetMaintenance(i%2 == 0); err != nil {
+			fail(err)
+		}
+	})
+	var deletedMtx sync.Mutex
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for ts := range previous {
+			err := fs.DeleteCollection(owner, ts)
+			switch {
+			case err == nil:
+				deletedMtx.Lock()
+				deleted[ts] = struct{}{}
+				deletedMtx.Unlock()
+			case errors.Is(err, backend.ErrCollectionAnchored):
+			default:
+				fail(fmt.Errorf("delete %v: %v", ts, err))
+			}
+		}
+	}()
+
+	for i := 0; i < rounds; i++ {
+		var digests [][sha256.Size]byte
+		for j := 0; j < 8; j++ {
+			digests = append(digests, [sha256.Size]byte{byte(i), byte(j), 0xff})
+		}
+		ts, me, err := fs.Put(digests, "", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, v := range me {
+			if v.ErrorCode != backend.ErrorOK {
+				t.Fatalf("digest %x: got code %v", v.Digest,
+					v.ErrorCode)
+			}
+		}
+		err = fs.PutOwner(owner, ts, "", digests)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stored[ts] = append(stored[ts], digests...)
+
+		// Every other round moves to the next container and offers the
+		// previous one for deletion.
+		if i%2 == 1 {
+			timestamp.Add(int64(fs.duration / time.Second))
+			if i%4 == 1 {
+				previous <- ts
+			}
+		}
+	}
+	close(previous)
+	close(done)
+	wg.Wait()
+	select {
+	case err := <-errs:
+		t.Fatal(err)
+	default:
+	}
+
+	// Everything that was not deleted is flushed once maintenance ends.
+	err = fs.SetMaintenance(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	timestamp.Add(int64(fs.duration / time.Second))
+	fs.flusher()
+	for ts, digests := range stored {
+		grs, err := fs.Get(digests)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, gone := deleted[ts]
+		for _, gr := range grs {
+			switch {
+			case gone && gr.ErrorCode != backend.ErrorNotFound:
+				t.Fatalf("deleted digest %x: got code %v",
+					gr.Digest, gr.ErrorCode)
+			case !gone && (gr.ErrorCode != backend.ErrorOK ||
+				gr.Timestamp != ts):
+				t.Fatalf("digest %x of %v: got code %v timestamp %v",
+					gr.Digest, ts, gr.ErrorCode, gr.Timestamp)
+			}
+		}
+		if !gone && !fs.isFlushed(ts) {
+			t.Fatalf("%v not flushed", ts)
+		}
+	}
+}
@@ -88,12 +88,16 @@ func extractNullDataMerkleRootV0(script []byte) []byte {
 	// Thus, it can either be a single OP_RETURN or an OP_RETURN followed by a
 	// canonical data push up to MaxDataCarrierSizeV0 bytes.
 	//
-	// When it houses a Merkle root, there will be a single push of 32 bytes.
-	if len(script) == 34 &&
+	// When it houses a Merkle root, there will be a single push of 32 bytes,
+	// optionally preceded by the anchor prefix of the operator in the same
+	// push.  The Merkle root is always the last 32 bytes.
+	if len(script) >= 34 &&
 		script[0] == txscript.OP_RETURN &&
-		script[1] == txscript.OP_DATA_32 {
+		script[1] >= txscript.OP_DATA_32 &&
+		script[1] <= txscript.OP_DATA_75 &&
+		int(script[1]) == len(script)-2 {
 
-		return script[2:34]
+		return script[len(script)-32:]
 	}
 
 	return nil
@@ -376,11 +380,11 @@ func (fs *FileSystem) fsckTimestamps(options *backend.FsckOptions, empties map[i
 	}
 
 	for _, fi := range files {
-		if !fi.IsDir() {
+		if !fi.IsDir() && !isReserved(fi.Name()) {
 			return fmt.Errorf("unexpected file %v",
 				filepath.Join(fs.root, fi.Name()))
 		}
-		if fi.Name() == globalDBDir {
+		if isReserved(fi.Name()) {
 			continue
 		}
 
@@ -409,6 +413,19 @@ func (fs *FileSystem) fsckTimestamps(options *backend.FsckOptions, empties map[i
 
 func (fs *FileSystem) fsckExists(ts int64, hash []byte) (bool, error) {
 	db, err := fs.openRead(ts)
+	if os.IsNotExist(err) {
+		// Compacted containers only retain the flush record.
+		fr, err := fs.archivedFlushRecord(ts)
+		if err != nil {
+			return false, err
+		}
+		for _, h := range fr.Hashes {
+			if bytes.Equal(h[:], hash) {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
 	if err != nil {
 		return false, err
 	}
@@ -617,11 +634,11 @@ func (fs *FileSystem) fsckDups(options *backend.FsckOptions) error {
 
 	digests := make(map[string]int64)
 	for _, fi := range files {
-		if !fi.IsDir() {
+		if !fi.IsDir() && !isReserved(fi.Name()) {
 			return fmt.Errorf("unexpected file %v",
 				filepath.Join(fs.root, fi.Name()))
 		}
-		if fi.Name() == globalDBDir {
+		if isReserved(fi.Name()) {
 			continue
 		}
 
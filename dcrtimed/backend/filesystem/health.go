@@ -0,0 +1,61 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package filesystem
+
+import (
+	"os"
+	"time"
+
+	"github.com/decred/dcrtime/dcrtimed/backend"
+)
+
+// flusherCompleted records that the flusher completed a run.
+func (fs *FileSystem) flusherCompleted() {
+	fs.healthMtx.Lock()
+	fs.lastFlusher = time.Now()
+	fs.healthMtx.Unlock()
+}
+
+// setAnchorError records the outcome of the last anchor transaction.
+func (fs *FileSystem) setAnchorError(err error) {
+	fs.healthMtx.Lock()
+	fs.anchorErr = err
+	fs.healthMtx.Unlock()
+}
+
+// anchorError returns the error of the last anchor transaction, nil if it
+// succeeded.
+func (fs *FileSystem) anchorError() error {
+	fs.healthMtx.Lock()
+	defer fs.healthMtx.Unlock()
+	return fs.anchorErr
+}
+
+// Health checks that the root directory and the global database are
+// readable.  The backend lock is not taken so that the health of a backend
+// that is flushing, or whose flusher hangs, can be reported.
+//
+// Health satisfies the backend interface.
+func (fs *FileSystem) Health() (*backend.HealthResult, error) {
+	var hr backend.HealthResult
+
+	fs.healthMtx.Lock()
+	hr.LastFlusher = fs.lastFlusher
+	hr.FlusherInterval = fs.flusherInterval
+	fs.healthMtx.Unlock()
+
+	if _, err := fs.wallet.BestHeight(); err != nil {
+		hr.WalletError = err
+	}
+
+	if _, err := os.Stat(fs.root); err != nil {
+		return nil, err
+	}
+	if _, err := fs.db.Has([]byte(flushedKey), nil); err != nil {
+		return nil, err
+	}
+
+	return &hr, nil
+}
@@ -0,0 +1,53 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package filesystem
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// lockFilename is the name of the file in the root that is locked for as long
+// as a process uses the backend.
+const lockFilename = "dcrtimed.lock"
+
+// ErrLocked is returned when the root is in use by another process, most
+// likely a second dcrtimed that was started against the same data directory.
+var ErrLocked = errors.New("data directory is in use by another process")
+
+// lockRoot takes the advisory lock of the root and records the pid of this
+// process in the lock file.  The lock is released when the returned file is
+// closed or the process exits, a stale lock file left behind by a crash does
+// not prevent the next start.
+func lockRoot(root string) (*os.File, error) {
+	filename := filepath.Join(root, lockFilename)
+	f, err := openLocked(filename)
+	if errors.Is(err, errWouldBlock) {
+		// Name the owner if the platform allows reading the file.
+		b, _ := os.ReadFile(filename)
+		pid, err := strconv.Atoi(strings.TrimSpace(string(b)))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrLocked, root)
+		}
+		return nil, fmt.Errorf("%w: %v (pid %v)", ErrLocked, root, pid)
+	} else if err != nil {
+		return nil, err
+	}
+
+	err = f.Truncate(0)
+	if err == nil {
+		_, err = f.WriteString(strconv.Itoa(os.Getpid()) + "\n")
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return f, nil
+}
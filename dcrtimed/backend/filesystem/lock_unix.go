@@ -0,0 +1,32 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+//go:build !windows
+// +build !windows
+
+package filesystem
+
+import (
+	"os"
+	"syscall"
+)
+
+// errWouldBlock is returned by openLocked when another process holds the
+// lock.
+var errWouldBlock error = syscall.EWOULDBLOCK
+
+// openLocked opens or creates the file and takes an exclusive flock on it
+// without waiting.
+func openLocked(filename string) (*os.File, error) {
+	f, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
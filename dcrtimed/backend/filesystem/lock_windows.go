@@ -0,0 +1,31 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package filesystem
+
+import (
+	"os"
+	"syscall"
+)
+
+// errWouldBlock is returned by openLocked when another process holds the
+// lock.  It is ERROR_SHARING_VIOLATION, which the syscall package does not
+// define.
+var errWouldBlock error = syscall.Errno(32)
+
+// openLocked opens or creates the file without sharing it, which fails while
+// another process has it open.
+func openLocked(filename string) (*os.File, error) {
+	name, err := syscall.UTF16PtrFromString(filename)
+	if err != nil {
+		return nil, err
+	}
+	h, err := syscall.CreateFile(name,
+		syscall.GENERIC_READ|syscall.GENERIC_WRITE, 0, nil,
+		syscall.OPEN_ALWAYS, syscall.FILE_ATTRIBUTE_NORMAL, 0)
+	if err != nil {
+		return nil, err
+	}
+	return os.NewFile(uintptr(h), filename), nil
+}
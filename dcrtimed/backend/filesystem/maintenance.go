@@ -0,0 +1,110 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package filesystem
+
+import (
+	"os"
+	"sort"
+	"time"
+
+	"github.com/decred/dcrtime/dcrtimed/backend"
+)
+
+// pendingTotal returns the number of digests in all unflushed containers.
+//
+// This must be called with the WRITE lock held.
+func (fs *FileSystem) pendingTotal() (int64, error) {
+	files, err := os.ReadDir(fs.root)
+	if err != nil {
+		return 0, err
+	}
+	dirs := make([]string, 0, len(files))
+	for _, file := range files {
+		if !file.IsDir() || isReserved(file.Name()) {
+			continue
+		}
+		dirs = append(dirs, file.Name())
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(dirs)))
+
+	var total int64
+	for _, dir := range dirs {
+		timestamp, err := time.Parse(fStr, dir)
+		if err != nil {
+			continue
+		}
+		ts := timestamp.Unix()
+
+		if fs.isFlushed(ts) {
+			if isFastContainer(ts) {
+				continue
+			}
+			break
+		}
+		count, err := fs.pendingDigests(ts)
+		if err != nil {
+			return 0, err
+		}
+		total += count
+	}
+
+	return total, nil
+}
+
+// SetMaintenance enables or disables maintenance mode.  Digests that are
+// already pending count towards the queue when it is enabled.  All queued
+// collections are flushed when it is disabled.
+//
+// SetMaintenance satisfies the backend interface.
+func (fs *FileSystem) SetMaintenance(enable bool) error {
+	if fs.readOnly {
+		return backend.ErrReadOnly
+	}
+
+	fs.flushMtx.Lock()
+	defer fs.flushMtx.Unlock()
+
+	fs.Lock()
+	if enable == fs.maintenance {
+		fs.Unlock()
+		return nil
+	}
+	if enable {
+		defer fs.Unlock()
+		queued, err := fs.pendingTotal()
+		if err != nil {
+			return err
+		}
+		fs.maintenance = true
+		fs.queued = queued
+		log.Infof("Maintenance: started, digests queued %v", queued)
+		return nil
+	}
+
+	fs.maintenance = false
+	fs.queued = 0
+	fs.Unlock()
+
+	// Digests are accepted again while the queued collections are
+	// flushed.
+	start := time.Now()
+	count, err := fs.flushConcurrently()
+	if err != nil {
+		return err
+	}
+	log.Infof("Maintenance: ended, directories %v flushed in %v", count,
+		time.Since(start))
+
+	return nil
+}
+
+// Maintenance returns true if maintenance mode is enabled.
+//
+// Maintenance satisfies the backend interface.
+func (fs *FileSystem) Maintenance() bool {
+	fs.RLock()
+	defer fs.RUnlock()
+	return fs.maintenance
+}
@@ -0,0 +1,75 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package filesystem
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+
+	"github.com/decred/dcrtime/dcrtimed/backend"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// metadataDBDir is the directory that contains the digest metadata database.
+const metadataDBDir = "metadata"
+
+// PutMetadata stores the metadata of digests.  The metadata of a digest that
+// already has metadata is not replaced.  This call satisfies the backend
+// interface.
+func (fs *FileSystem) PutMetadata(mds []backend.Metadata) error {
+	if len(mds) == 0 {
+		return nil
+	}
+
+	fs.metadataMtx.Lock()
+	defer fs.metadataMtx.Unlock()
+
+	batch := new(leveldb.Batch)
+	for _, md := range mds {
+		found, err := fs.metadata.Has(md.Digest[:], nil)
+		if err != nil {
+			return err
+		}
+		if found {
+			continue
+		}
+		payload, err := json.Marshal(md)
+		if err != nil {
+			return err
+		}
+		payload, err = fs.seal(md.Digest[:], payload)
+		if err != nil {
+			return err
+		}
+		batch.Put(md.Digest[:], payload)
+	}
+	return fs.metadata.Write(batch, nil)
+}
+
+// GetMetadata returns the metadata of the provided digests in their order,
+// nil for digests without metadata.  This call satisfies the backend
+// interface.
+func (fs *FileSystem) GetMetadata(digests [][sha256.Size]byte) ([]*backend.Metadata, error) {
+	mds := make([]*backend.Metadata, 0, len(digests))
+	for _, digest := range digests {
+		payload, err := fs.metadata.Get(digest[:], nil)
+		if err == leveldb.ErrNotFound {
+			mds = append(mds, nil)
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		payload, err = fs.unseal(digest[:], payload)
+		if err != nil {
+			return nil, err
+		}
+		var md backend.Metadata
+		if err := json.Unmarshal(payload, &md); err != nil {
+			return nil, err
+		}
+		mds = append(mds, &md)
+	}
+	return mds, nil
+}
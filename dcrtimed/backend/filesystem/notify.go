@@ -0,0 +1,66 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package filesystem
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/decred/dcrtime/dcrtimed/dcrtimewallet"
+)
+
+// blockRetry is the time after which a failed block subscription is retried.
+const blockRetry = 30 * time.Second
+
+// subscribed returns true while the wallet notifies blocks.  The periodic
+// confirmation refresh and reorg watcher are skipped then.
+func (fs *FileSystem) subscribed() bool {
+	return atomic.LoadInt32(&fs.blocks) != 0
+}
+
+// blockConnected is called for every block notification.  Confirmations only
+// change when blocks are connected, so they are refreshed and the anchors are
+// checked for reorganizations right away.  A reorganization may connect a
+// block at the height of the last one, so the check is forced.
+func (fs *FileSystem) blockConnected(height int32) {
+	if atomic.CompareAndSwapInt32(&fs.blocks, 0, 1) {
+		log.Infof("Block notifications: subscribed at height %v",
+			height)
+	}
+	log.Debugf("blockConnected: height %v", height)
+
+	if fs.confirmRefresh != 0 {
+		fs.refreshConfirmations()
+	}
+	fs.watchReorgs(height, true)
+}
+
+// blockSubscriber subscribes to the block notifications of the wallet until
+// the provided context is canceled.  Failed subscriptions are retried, the
+// periodic refresh and reorg watcher take over in the meantime.  Wallets that
+// can not notify blocks are polled.
+func (fs *FileSystem) blockSubscriber(ctx context.Context) {
+	for {
+		err := fs.wallet.NotifyBlocks(ctx, fs.blockConnected)
+		atomic.StoreInt32(&fs.blocks, 0)
+		if ctx.Err() != nil {
+			return
+		}
+		if errors.Is(err, dcrtimewallet.ErrNoNotifications) {
+			log.Infof("Block notifications: %v, polling", err)
+			return
+		}
+		log.Errorf("Block notifications: %v, retry in %v", err,
+			blockRetry)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(blockRetry):
+		}
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package filesystem
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/decred/dcrtime/dcrtimed/backend"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// ownersDBDir is the directory that contains the collection ownership
+// database.
+const ownersDBDir = "owners"
+
+// anchored returns true if the collection with the provided timestamp was
+// anchored.
+func (fs *FileSystem) anchored(ts int64) bool {
+	fs.RLock()
+	defer fs.RUnlock()
+
+	_, err := fs.flushRecord(ts)
+	return err == nil
+}
+
+// DeleteCollection deletes a collection that was not anchored yet together
+// with its digests.  All digests of the collection must belong to the owner.
+// This call satisfies the backend interface.
+func (fs *FileSystem) DeleteCollection(owner string, ts int64) error {
+	// Block timestamping and flushing while the collection is verified and
+	// removed.
+	fs.flushMtx.Lock()
+	defer fs.flushMtx.Unlock()
+	fs.Lock()
+	defer fs.Unlock()
+
+	found, err := fs.OwnsCollection(owner, ts)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return backend.ErrCollectionNotFound
+	}
+
+	db, err := fs.openRead(ts)
+	switch {
+	case os.IsNotExist(err):
+		// Either compacted or nothing left to remove, e.g. after a
+		// restore.
+		if _, err := fs.archivedFlushRecord(ts); err == nil {
+			return backend.ErrCollectionAnchored
+		}
+	case err != nil:
+		return err
+	default:
+		err := func() error {
+			defer db.Close()
+			if isFlushed(db) {
+				return backend.ErrCollectionAnchored
+			}
+			i := db.NewIterator(nil, nil)
+			defer i.Release()
+			for i.Next() {
+				owned, err := fs.OwnsDigest(owner, ts, i.Key())
+				if err != nil {
+					return err
+				}
+				if !owned {
+					return backend.ErrCollectionShared
+				}
+			}
+			return i.Error()
+		}()
+		if err != nil {
+			return err
+		}
+
+		err = os.RemoveAll(filepath.Join(fs.root, ts2dirname(ts)))
+		if err != nil {
+			return err
+		}
+		log.Infof("Deleted collection %v of %v", ts2dirname(ts), owner)
+	}
+
+	// Forget the ownership and metadata of the removed digests.
+	batch := new(leveldb.Batch)
+	digests, err := fs.DeleteOwned(batch, owner, ts)
+	if err != nil {
+		return err
+	}
+	metadata := new(leveldb.Batch)
+	for _, digest := range digests {
+		metadata.Delete(digest)
+	}
+
+	fs.metadataMtx.Lock()
+	err = fs.metadata.Write(metadata, nil)
+	fs.metadataMtx.Unlock()
+	if err != nil {
+		return err
+	}
+	return fs.owners.Write(batch, nil)
+}
@@ -0,0 +1,184 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package filesystem
+
+import (
+	"errors"
+
+	"github.com/decred/dcrd/chaincfg/chainhash"
+	"github.com/decred/dcrtime/dcrtimed/backend"
+	"github.com/decred/dcrtime/dcrtimed/dcrtimewallet"
+)
+
+// reorgSchedule is the schedule of the reorg watcher.  It only looks up
+// anchors when the best block height changed since its last run.
+//
+// Seconds Minutes Hours Days Months DayOfWeek
+const reorgSchedule = "40 * * * * *" // Every minute + 40 seconds
+
+// checkReorg looks up the anchor of the provided flush record and records the
+// block it was mined in.  An anchor that is no longer in the block it was seen
+// in was reorganized out of the chain.  The flush record is marked reorganized
+// and its refreshed confirmations are dropped.  An anchor that returned to the
+// mempool is broadcast again by the wallet, an anchor that was dropped is
+// replaced right away.  It returns whether the anchor was reorganized and
+// whether the flush record was updated.  The flush record is not written back.
+//
+// This function must be called with the WRITE lock held.
+func (fs *FileSystem) checkReorg(ts int64, fr *backend.FlushRecord) (bool, bool, error) {
+	tx := fr.Tx
+	res, err := fs.lookupAnchor(fr)
+	if err != nil {
+		return false, false, err
+	}
+	updated := fr.Tx != tx
+
+	if res.Confirmations > 0 {
+		if res.BlockHash == fr.MinedBlock {
+			return false, updated, nil
+		}
+		if fr.MinedBlock != (chainhash.Hash{}) {
+			log.Warnf("Anchor %v of %v moved from block %v to %v",
+				fr.Tx, ts2dirname(ts), fr.MinedBlock, res.BlockHash)
+		}
+		fr.MinedBlock = res.BlockHash
+		return false, true, nil
+	}
+	if fr.MinedBlock == (chainhash.Hash{}) {
+		// Not mined yet, replaceAnchors takes care of it.
+		return false, updated, nil
+	}
+
+	log.Warnf("Anchor %v of %v was reorganized out of block %v",
+		fr.Tx, ts2dirname(ts), fr.MinedBlock)
+	fr.MinedBlock = chainhash.Hash{}
+	fr.Reorged = fs.myNow().Unix()
+	fs.lastReorg = fr.Reorged
+
+	fs.confirmMtx.Lock()
+	delete(fs.confirmed, ts)
+	fs.confirmMtx.Unlock()
+
+	if res.Confirmations == 0 {
+		// Back in the mempool, the wallet broadcasts it again.
+		return true, true, nil
+	}
+	if len(fr.Replaced) >= backend.MaxReplacements {
+		log.Criticalf("Anchor %v of %v was dropped after %v "+
+			"replacements", fr.Tx, ts2dirname(ts), len(fr.Replaced))
+		return true, true, nil
+	}
+
+	replacement, err := fs.wallet.Replace(fr.Root, []byte(fr.AnchorPrefix),
+		backend.FeeBump(len(fr.Replaced)))
+	fs.setAnchorError(err)
+	if err != nil {
+		// The flush record is still marked reorganized, replaceAnchors
+		// tries again once the anchor retry window passed.
+		if errors.Is(err, dcrtimewallet.ErrFeeTooHigh) {
+			log.Criticalf("Replacement anchor of %v refused: %v",
+				ts2dirname(ts), err)
+		} else {
+			log.Errorf("Replace anchor of %v: %v", ts2dirname(ts),
+				err)
+		}
+		return true, true, nil
+	}
+	log.Infof("Anchored %v again: %v -> %v", ts2dirname(ts), fr.Tx,
+		replacement)
+
+	fr.Replaced = append(fr.Replaced, fr.Tx)
+	fr.Tx = *replacement
+	return true, true, nil
+}
+
+// checkReorgs checks the anchors of the recent collections that lack
+// confirmations for reorganizations and writes back the flush records that
+// were updated.  It returns the number of anchors that were reorganized out
+// of the chain.
+//
+// This function must be called with the WRITE lock held.
+func (fs *FileSystem) checkReorgs() (int, error) {
+	pending, err := fs.unconfirmed()
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for ts, fr := range pending {
+		if fr.Tx == (chainhash.Hash{}) {
+			continue
+		}
+
+		reorged, updated, err := fs.checkReorg(ts, fr)
+		if err != nil {
+			log.Errorf("Check reorg of %v: %v", ts2dirname(ts), err)
+			continue
+		}
+		if reorged {
+			count++
+		}
+		if !updated {
+			continue
+		}
+
+		// Write back
+		payload, err := EncodeFlushRecord(*fr)
+		if err != nil {
+			return count, err
+		}
+		db, err := fs.openWrite(ts, false)
+		if err != nil {
+			return count, err
+		}
+		err = db.Put([]byte(flushedKey), payload, nil)
+		db.Close()
+		if err != nil {
+			return count, err
+		}
+	}
+
+	return count, nil
+}
+
+// reorgWatcher is called periodically and checks the anchors that lack
+// confirmations for reorganizations whenever the best block height changed.
+// A reorganization to a chain of the same height is found once the next block
+// is mined.  Nothing is done while blocks are notified.
+func (fs *FileSystem) reorgWatcher() {
+	if fs.subscribed() {
+		return
+	}
+	height, err := fs.wallet.BestHeight()
+	if err != nil {
+		log.Errorf("reorgWatcher: %v", err)
+		return
+	}
+	fs.watchReorgs(height, false)
+}
+
+// watchReorgs checks the anchors that lack confirmations for reorganizations
+// at the provided best block height.  Unless forced, nothing is checked when
+// the height did not change since the last check.
+func (fs *FileSystem) watchReorgs(height int32, force bool) {
+	// Reorganized anchors are replaced, which must not race a flush.
+	fs.flushMtx.Lock()
+	defer fs.flushMtx.Unlock()
+	fs.Lock()
+	defer fs.Unlock()
+	if height == fs.reorgHeight && !force {
+		return
+	}
+	fs.reorgHeight = height
+
+	reorged, err := fs.checkReorgs()
+	if err != nil {
+		log.Errorf("watchReorgs: %v", err)
+	}
+	if reorged != 0 {
+		log.Infof("Reorg watcher: reorganized anchors %v at height %v",
+			reorged, height)
+	}
+}
@@ -0,0 +1,153 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package filesystem
+
+import (
+	"errors"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/decred/dcrd/chaincfg/chainhash"
+	"github.com/decred/dcrtime/dcrtimed/backend"
+	"github.com/decred/dcrtime/dcrtimed/dcrtimewallet"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// lookupAnchor looks up the anchor of the provided flush record, see
+// backend.LookupAnchor.  The flush record is not written back.
+func (fs *FileSystem) lookupAnchor(fr *backend.FlushRecord) (*dcrtimewallet.TxLookupResult, error) {
+	tx := fr.Tx
+	res, err := backend.LookupAnchor(fs.wallet, fr)
+	if err == nil && fr.Tx != tx {
+		log.Infof("Replaced anchor %v was mined instead of %v", fr.Tx,
+			tx)
+	}
+	return res, err
+}
+
+// replaceAnchor replaces the anchor of the provided flush record when it was
+// not mined within the anchor retry window.  Every replacement, and every
+// reorganization that took the anchor out of the chain, gets another window.
+// It returns true if the flush record was updated.
+func (fs *FileSystem) replaceAnchor(ts int64, fr *backend.FlushRecord) (bool, error) {
+	deadline := time.Unix(fr.FlushTimestamp, 0).
+		Add(fs.anchorRetry * time.Duration(len(fr.Replaced)+1))
+	reorged := time.Unix(fr.Reorged, 0).Add(fs.anchorRetry)
+	if reorged.After(deadline) {
+		deadline = reorged
+	}
+	if fs.myNow().Before(deadline) {
+		return false, nil
+	}
+
+	res, err := fs.lookupAnchor(fr)
+	if err != nil {
+		return false, err
+	}
+	if res.Confirmations > 0 {
+		// Mined, the chain timestamp is recorded once there are
+		// enough confirmations.
+		return false, nil
+	}
+	if len(fr.Replaced) >= backend.MaxReplacements {
+		log.Debugf("Anchor of %v not mined after %v replacements: %v",
+			ts2dirname(ts), len(fr.Replaced), fr.Tx)
+		return false, nil
+	}
+
+	tx, err := fs.wallet.Replace(fr.Root, []byte(fr.AnchorPrefix),
+		backend.FeeBump(len(fr.Replaced)))
+	fs.setAnchorError(err)
+	if err != nil {
+		if errors.Is(err, dcrtimewallet.ErrFeeTooHigh) {
+			log.Criticalf("Replacement anchor of %v refused: %v",
+				ts2dirname(ts), err)
+		}
+		return false, err
+	}
+	log.Infof("Replaced anchor of %v: %v -> %v", ts2dirname(ts), fr.Tx,
+		tx)
+
+	fr.Replaced = append(fr.Replaced, fr.Tx)
+	fr.Tx = *tx
+	return true, nil
+}
+
+// replaceAnchors walks the flushed timestamp directories backwards and
+// replaces the anchors that were not mined in time.  The walk ends at the
+// first hourly container whose anchor has a chain timestamp.  It returns the
+// number of anchors that were replaced.
+//
+// This function must be called with the WRITE lock held.
+func (fs *FileSystem) replaceAnchors() (int, error) {
+	files, err := os.ReadDir(fs.root)
+	if err != nil {
+		return 0, err
+	}
+	dirs := make([]string, 0, len(files))
+	for _, file := range files {
+		if !file.IsDir() || isReserved(file.Name()) {
+			continue
+		}
+		dirs = append(dirs, file.Name())
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(dirs)))
+
+	count := 0
+	for _, dir := range dirs {
+		timestamp, err := time.Parse(fStr, dir)
+		if err != nil {
+			continue
+		}
+		ts := timestamp.Unix()
+
+		fr, err := fs.flushRecord(ts)
+		if errors.Is(err, leveldb.ErrNotFound) {
+			// Not flushed yet.
+			continue
+		} else if err != nil {
+			return count, err
+		}
+		if fr.ChainTimestamp != 0 {
+			if isFastContainer(ts) {
+				continue
+			}
+			// Older anchors were mined already.
+			break
+		}
+		if fr.Tx == (chainhash.Hash{}) {
+			continue
+		}
+
+		replaced, err := fs.replaceAnchor(ts, fr)
+		if err != nil {
+			log.Errorf("Replace anchor of %v: %v", ts2dirname(ts),
+				err)
+			continue
+		}
+		if !replaced {
+			continue
+		}
+
+		// Write back
+		payload, err := EncodeFlushRecord(*fr)
+		if err != nil {
+			return count, err
+		}
+		db, err := fs.openWrite(ts, false)
+		if err != nil {
+			return count, err
+		}
+		err = db.Put([]byte(flushedKey), payload, nil)
+		db.Close()
+		if err != nil {
+			return count, err
+		}
+		count++
+	}
+
+	return count, nil
+}
@@ -0,0 +1,222 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package filesystem
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/decred/dcrtime/dcrtimed/backend"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// errNotStandby is returned when replicated collections are applied to a
+// backend that stores digests itself.
+var errNotStandby = errors.New("replication requires a read-only backend")
+
+// flushRecordJSON returns the flush record of the container with the provided
+// timestamp in its dump and replication format.
+func flushRecordJSON(ts int64, fr *backend.FlushRecord) *backend.FlushRecordJSON {
+	return &backend.FlushRecordJSON{
+		Root:           fr.Root,
+		Hashes:         fr.Hashes,
+		Tx:             fr.Tx,
+		ChainTimestamp: fr.ChainTimestamp,
+		FlushTimestamp: fr.FlushTimestamp,
+		BlockHeight:    fr.BlockHeight,
+		AnchorPrefix:   fr.AnchorPrefix,
+		Replaced:       fr.Replaced,
+		Attestations:   fr.Attestations,
+		Reorged:        fr.Reorged,
+		Timestamp:      ts,
+	}
+}
+
+// replicationRecord returns the replication record of the container with the
+// provided timestamp.  Compacted containers only kept their flush record, the
+// labels and algorithms of their digests are looked up in the global
+// database.  os.ErrNotExist is returned if the container does not exist.
+//
+// Must be called with the READ lock held.
+func (fs *FileSystem) replicationRecord(ts int64, archived bool) (*backend.ReplicationRecord, error) {
+	rr := &backend.ReplicationRecord{
+		Timestamp: ts,
+		Archived:  archived,
+	}
+
+	if archived {
+		fr, err := fs.archivedFlushRecord(ts)
+		if err != nil {
+			return nil, err
+		}
+		rr.Flush = flushRecordJSON(ts, fr)
+		rr.Digests = make([]backend.DigestReceived, 0, len(fr.Hashes))
+		for _, h := range fr.Hashes {
+			if h == nil {
+				continue
+			}
+			dr := backend.DigestReceived{
+				Digest:    hex.EncodeToString(h[:]),
+				Timestamp: ts,
+			}
+			value, err := fs.db.Get(h[:], nil)
+			if err == nil {
+				dr.Label = digestLabel(value)
+				dr.Algorithm = digestAlgorithm(value)
+			} else if !errors.Is(err, leveldb.ErrNotFound) {
+				return nil, err
+			}
+			rr.Digests = append(rr.Digests, dr)
+		}
+		return rr, nil
+	}
+
+	db, err := fs.openRead(ts)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	i := db.NewIterator(nil, nil)
+	defer i.Release()
+	for i.Next() {
+		if string(i.Key()) == flushedKey {
+			fr, err := DecodeFlushRecord(i.Value())
+			if err != nil {
+				return nil, err
+			}
+			rr.Flush = flushRecordJSON(ts, fr)
+			continue
+		}
+		rr.Digests = append(rr.Digests, backend.DigestReceived{
+			Digest:    hex.EncodeToString(i.Key()),
+			Timestamp: int64(binary.LittleEndian.Uint64(i.Value())),
+			Label:     digestLabel(i.Value()),
+			Algorithm: digestAlgorithm(i.Value()),
+		})
+	}
+	return rr, i.Error()
+}
+
+// ReplicateCollections calls f with every container with a timestamp at or
+// after since.  Like ExportCollections the read lock is only held while a
+// container is read.  This call satisfies the backend interface.
+func (fs *FileSystem) ReplicateCollections(since int64, f func(backend.ReplicationRecord) error) error {
+	fs.RLock()
+	archived, err := fs.containers()
+	fs.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	timestamps := make([]int64, 0, len(archived))
+	for ts := range archived {
+		if ts >= since {
+			timestamps = append(timestamps, ts)
+		}
+	}
+	sort.Slice(timestamps, func(i, j int) bool {
+		return timestamps[i] < timestamps[j]
+	})
+
+	for _, ts := range timestamps {
+		fs.RLock()
+		rr, err := fs.replicationRecord(ts, archived[ts])
+		if errors.Is(err, os.ErrNotExist) && !archived[ts] {
+			// Compacted since it was listed.
+			rr, err = fs.replicationRecord(ts, true)
+		}
+		fs.RUnlock()
+		if err != nil {
+			return err
+		}
+		if err := f(*rr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ApplyReplication stores a container that was replicated from the primary.
+// Digests are written to the container and, once it was flushed, to the
+// global database.  Compacted containers are archived.  This call satisfies
+// the backend interface.
+func (fs *FileSystem) ApplyReplication(rr backend.ReplicationRecord) error {
+	fs.Lock()
+	defer fs.Unlock()
+
+	if !fs.readOnly {
+		return errNotStandby
+	}
+	if rr.Timestamp <= 0 {
+		return fmt.Errorf("invalid timestamp: %v", rr.Timestamp)
+	}
+	if rr.Archived && rr.Flush == nil {
+		return fmt.Errorf("archived container %v without flush record",
+			ts2dirname(rr.Timestamp))
+	}
+
+	batch := new(leveldb.Batch)
+	for _, dr := range rr.Digests {
+		digest, err := hex.DecodeString(dr.Digest)
+		if err != nil {
+			return err
+		}
+		batch.Put(digest, encodeDigestValue(dr.Timestamp, dr.Label,
+			dr.Algorithm))
+	}
+
+	var payload []byte
+	if rr.Flush != nil {
+		var err error
+		payload, err = EncodeFlushRecord(backend.FlushRecord{
+			Root:            rr.Flush.Root,
+			Hashes:          rr.Flush.Hashes,
+			Tx:              rr.Flush.Tx,
+			ChainTimestamp:  rr.Flush.ChainTimestamp,
+			FlushTimestamp:  rr.Flush.FlushTimestamp,
+			ServerTimestamp: rr.Timestamp,
+			BlockHeight:     rr.Flush.BlockHeight,
+			AnchorPrefix:    rr.Flush.AnchorPrefix,
+			Replaced:        rr.Flush.Replaced,
+			Attestations:    rr.Flush.Attestations,
+			Reorged:         rr.Flush.Reorged,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if rr.Archived {
+		fr, err := DecodeFlushRecord(payload)
+		if err != nil {
+			return err
+		}
+		err = fs.db.Write(batch, walSync)
+		if err != nil {
+			return err
+		}
+		return fs.archive(rr.Timestamp, fr)
+	}
+
+	db, err := fs.openWrite(rr.Timestamp, true)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	err = db.Write(batch, walSync)
+	if err != nil {
+		return err
+	}
+	if payload == nil {
+		return nil
+	}
+	return fs.commitFlush(db, rr.Timestamp, payload)
+}
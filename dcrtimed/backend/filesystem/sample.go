@@ -0,0 +1,140 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package filesystem
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"time"
+
+	"github.com/decred/dcrtime/dcrtimed/backend"
+	"github.com/decred/dcrtime/merkle"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// sampleAttempts is the number of containers that are tried for every
+// requested sample, which bounds the work when most containers are not
+// flushed.
+const sampleAttempts = 4
+
+// sampleContainer returns a random digest of the flushed container with the
+// provided timestamp and its merkle path, which is derived from all digests
+// in the container.  Nil is returned if the container is not flushed.
+//
+// Must be called with the READ lock held.
+func (fs *FileSystem) sampleContainer(rnd *rand.Rand, ts int64) (*backend.AuditResult, error) {
+	db, err := fs.openRead(ts)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	payload, err := db.Get([]byte(flushedKey), nil)
+	if errors.Is(err, leveldb.ErrNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	fr, err := DecodeFlushRecord(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	hashes := make([]*[sha256.Size]byte, 0, len(fr.Hashes))
+	iter := db.NewIterator(nil, nil)
+	for iter.Next() {
+		if string(iter.Key()) == flushedKey {
+			continue
+		}
+		var digest [sha256.Size]byte
+		copy(digest[:], iter.Key())
+		hashes = append(hashes, &digest)
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		return nil, err
+	}
+	if len(hashes) == 0 {
+		return nil, errEmptySet
+	}
+
+	digest := hashes[rnd.Intn(len(hashes))]
+	return &backend.AuditResult{
+		Digest:         *digest,
+		Timestamp:      ts,
+		MerkleRoot:     fr.Root,
+		MerklePath:     *merkle.AuthPath(hashes, digest),
+		Tx:             fr.Tx,
+		ChainTimestamp: fr.ChainTimestamp,
+	}, nil
+}
+
+// sampleAnchored samples up to n digests from random flushed containers.
+//
+// Must be called with the READ lock held.
+func (fs *FileSystem) sampleAnchored(n int) ([]backend.AuditResult, error) {
+	files, err := os.ReadDir(fs.root)
+	if err != nil {
+		return nil, err
+	}
+	timestamps := make([]int64, 0, len(files))
+	for _, file := range files {
+		if !file.IsDir() || isReserved(file.Name()) {
+			continue
+		}
+		t, err := time.Parse(fStr, file.Name())
+		if err != nil {
+			continue
+		}
+		timestamps = append(timestamps, t.Unix())
+	}
+
+	samples := make([]backend.AuditResult, 0, n)
+	if len(timestamps) == 0 {
+		return samples, nil
+	}
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	for i := 0; i < n*sampleAttempts && len(samples) < n; i++ {
+		ts := timestamps[rnd.Intn(len(timestamps))]
+		ar, err := fs.sampleContainer(rnd, ts)
+		if err != nil {
+			return nil, fmt.Errorf("sample %v: %w", ts2dirname(ts), err)
+		}
+		if ar == nil {
+			continue
+		}
+		samples = append(samples, *ar)
+	}
+
+	return samples, nil
+}
+
+// SampleAnchored returns up to n digests that are sampled at random from
+// flushed collections.  Compacted collections are not sampled.  The anchors
+// are looked up without holding the lock since the wallet may be slow.
+//
+// SampleAnchored satisfies the backend interface.
+func (fs *FileSystem) SampleAnchored(n int) ([]backend.AuditResult, error) {
+	fs.RLock()
+	samples, err := fs.sampleAnchored(n)
+	fs.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+
+	for k := range samples {
+		res, err := fs.wallet.Lookup(samples[k].Tx)
+		if err != nil {
+			return nil, fmt.Errorf("lookup anchor %v of %v: %w",
+				samples[k].Tx, ts2dirname(samples[k].Timestamp), err)
+		}
+		samples[k].Confirmations = res.Confirmations
+	}
+
+	return samples, nil
+}
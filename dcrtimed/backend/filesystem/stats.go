@@ -0,0 +1,146 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package filesystem
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"sort"
+
+	"github.com/decred/dcrtime/dcrtimed/backend"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// statsDBDir is the directory that contains the submission statistics of the
+// collections.
+const statsDBDir = "stats"
+
+// submissionStats counts the digests that were submitted to a collection.
+// Clients is sorted.
+type submissionStats struct {
+	Submitted  int64    `json:"submitted"`
+	Duplicates int64    `json:"duplicates"`
+	Clients    []string `json:"clients"`
+}
+
+// statsKey returns the key of the submission statistics of the collection
+// with the provided timestamp.
+func statsKey(ts int64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(ts))
+	return key
+}
+
+// submissionStats returns the submission statistics of the collection with the
+// provided timestamp.  Collections without submissions, including those that
+// predate the statistics, return empty statistics.
+func (fs *FileSystem) submissionStats(ts int64) (*submissionStats, error) {
+	payload, err := fs.stats.Get(statsKey(ts), nil)
+	if errors.Is(err, leveldb.ErrNotFound) {
+		return &submissionStats{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var ss submissionStats
+	if err := json.Unmarshal(payload, &ss); err != nil {
+		return nil, err
+	}
+	return &ss, nil
+}
+
+// PutSubmission records that the client submitted digests to the collection
+// with the provided timestamp.  This call satisfies the backend interface.
+func (fs *FileSystem) PutSubmission(ts int64, client string, digests, duplicates int) error {
+	fs.statsMtx.Lock()
+	defer fs.statsMtx.Unlock()
+
+	ss, err := fs.submissionStats(ts)
+	if err != nil {
+		return err
+	}
+	ss.Submitted += int64(digests)
+	ss.Duplicates += int64(duplicates)
+	k := sort.SearchStrings(ss.Clients, client)
+	if k == len(ss.Clients) || ss.Clients[k] != client {
+		ss.Clients = append(ss.Clients, "")
+		copy(ss.Clients[k+1:], ss.Clients[k:])
+		ss.Clients[k] = client
+	}
+
+	payload, err := json.Marshal(ss)
+	if err != nil {
+		return err
+	}
+	return fs.stats.Put(statsKey(ts), payload, nil)
+}
+
+// GetCollectionStats returns the statistics of all flushed collections with
+// timestamps between from and to, inclusive.  Both regular and compacted
+// containers are searched.  This call satisfies the backend interface.
+func (fs *FileSystem) GetCollectionStats(from, to int64) ([]backend.CollectionStats, error) {
+	fs.RLock()
+	defer fs.RUnlock()
+
+	archived, err := fs.containers()
+	if err != nil {
+		return nil, err
+	}
+
+	stats := make([]backend.CollectionStats, 0, 64)
+	for ts, isArchived := range archived {
+		if ts < from || ts > to {
+			continue
+		}
+
+		var fr *backend.FlushRecord
+		if isArchived {
+			fr, err = fs.archivedFlushRecord(ts)
+		} else {
+			fr, err = fs.flushRecord(ts)
+		}
+		if errors.Is(err, leveldb.ErrNotFound) {
+			// Not flushed yet.
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		height, err := fs.anchorHeight(ts, fr, isArchived)
+		if err != nil {
+			return nil, err
+		}
+		ss, err := fs.submissionStats(ts)
+		if err != nil {
+			return nil, err
+		}
+
+		cs := backend.CollectionStats{
+			Timestamp:   ts,
+			Submitted:   ss.Submitted,
+			Duplicates:  ss.Duplicates,
+			Clients:     len(ss.Clients),
+			MerkleRoot:  fr.Root,
+			Tx:          fr.Tx,
+			BlockHeight: height,
+		}
+		if height != 0 {
+			cs.ChainTimestamp = fr.ChainTimestamp
+		}
+		for _, ph := range fr.Hashes {
+			if ph != nil {
+				cs.Digests++
+			}
+		}
+		stats = append(stats, cs)
+	}
+
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Timestamp < stats[j].Timestamp
+	})
+
+	return stats, nil
+}
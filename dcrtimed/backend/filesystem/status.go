@@ -0,0 +1,108 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package filesystem
+
+import (
+	"os"
+	"sort"
+	"time"
+
+	"github.com/decred/dcrtime/dcrtimed/backend"
+)
+
+// pendingDigests returns the number of digests in the provided unflushed
+// container.
+func (fs *FileSystem) pendingDigests(ts int64) (int64, error) {
+	db, err := fs.openRead(ts)
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	var count int64
+	i := db.NewIterator(nil, nil)
+	defer i.Release()
+	for i.Next() {
+		if string(i.Key()) == flushedKey {
+			continue
+		}
+		count++
+	}
+
+	return count, i.Error()
+}
+
+// Status walks the containers backwards, counting the digests of the unflushed
+// containers, until it finds the last flushed container.  This call satisfies
+// the backend interface.
+func (fs *FileSystem) Status() (*backend.StatusResult, error) {
+	var sr backend.StatusResult
+
+	// Contact the wallet without holding the lock.
+	br, err := fs.GetBalance()
+	if err != nil {
+		sr.WalletError = err
+	}
+	sr.Balance = br
+	sr.LowBalance = fs.lowFunds()
+	sr.FlushPaused = fs.flushPaused()
+
+	// Block readers and writers since containers can only be opened once.
+	fs.Lock()
+	defer fs.Unlock()
+
+	sr.AnchorError = fs.anchorError()
+	sr.LastReorg = fs.lastReorg
+	sr.Maintenance = fs.maintenance
+
+	files, err := os.ReadDir(fs.root)
+	if err != nil {
+		return nil, err
+	}
+	dirs := make([]string, 0, len(files))
+	for _, file := range files {
+		if !file.IsDir() || isReserved(file.Name()) {
+			continue
+		}
+		dirs = append(dirs, file.Name())
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(dirs)))
+
+	for _, dir := range dirs {
+		timestamp, err := time.Parse(fStr, dir)
+		if err != nil {
+			continue
+		}
+		ts := timestamp.Unix()
+
+		if !fs.isFlushed(ts) {
+			count, err := fs.pendingDigests(ts)
+			if err != nil {
+				return nil, err
+			}
+			sr.PendingDigests += count
+			continue
+		}
+
+		if sr.LastFlushTimestamp == 0 {
+			fr, err := fs.flushRecord(ts)
+			if err != nil {
+				return nil, err
+			}
+			sr.LastFlushTimestamp = fr.FlushTimestamp
+			sr.LastFlushTx = fr.Tx
+			sr.LastFlushChainTimestamp = fr.ChainTimestamp
+		}
+
+		// Fast anchor containers may be flushed before older hourly
+		// ones.  We hit the last flushed hourly container so we are
+		// done.
+		if !isFastContainer(ts) {
+			break
+		}
+	}
+
+	return &sr, nil
+}
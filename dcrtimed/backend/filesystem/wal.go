@@ -0,0 +1,155 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package filesystem
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+)
+
+// walDBDir is the directory that contains the write-ahead log of flushes.
+const walDBDir = "wal"
+
+// walSync makes sure that log entries are on disk before the step they
+// describe is taken.
+var walSync = &opt.WriteOptions{Sync: true}
+
+// walEntry describes a flush that is in progress.  A flush updates the global
+// database and the timestamp container, which can not be done atomically, and
+// anchors the merkle root in between.  The entry is written before the first
+// step and removed after the last one so that a flush that was interrupted by
+// a crash is found on startup.
+type walEntry struct {
+	Timestamp int64             `json:"timestamp"`        // Container being flushed
+	Root      [sha256.Size]byte `json:"root"`             // Merkle root of the container
+	Started   int64             `json:"started"`          // Start of the flush
+	Record    []byte            `json:"record,omitempty"` // Encoded flush record once anchored
+}
+
+// walKey returns the key of the log entry of the provided container.
+func walKey(ts int64) []byte {
+	return []byte(ts2dirname(ts))
+}
+
+// walPut stores the provided log entry on disk.
+func (fs *FileSystem) walPut(e walEntry) error {
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return fs.wal.Put(walKey(e.Timestamp), payload, walSync)
+}
+
+// walDelete removes the log entry of the provided container once its flush
+// completed or was rolled back.
+func (fs *FileSystem) walDelete(ts int64) error {
+	return fs.wal.Delete(walKey(ts), walSync)
+}
+
+// commitFlush writes the digests of the provided container to the global
+// database and marks the container flushed with the provided encoded flush
+// record.  The global database is written first, a container that is marked
+// flushed is never flushed again.
+//
+// This function must be called with the WRITE lock held.
+func (fs *FileSystem) commitFlush(db *leveldb.DB, ts int64, payload []byte) error {
+	batch := new(leveldb.Batch)
+	iter := db.NewIterator(nil, nil)
+	for iter.Next() {
+		batch.Put(iter.Key(), encodeDigestValue(ts,
+			digestLabel(iter.Value()), digestAlgorithm(iter.Value())))
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		return err
+	}
+
+	return fs.writeFlush(db, batch, payload)
+}
+
+// writeFlush writes the provided global database entries of a container and
+// marks the container flushed with the provided encoded flush record.
+//
+// This function must be called with the WRITE lock held.
+func (fs *FileSystem) writeFlush(db *leveldb.DB, batch *leveldb.Batch, payload []byte) error {
+	err := fs.db.Write(batch, walSync)
+	if err != nil {
+		return err
+	}
+	return db.Put([]byte(flushedKey), payload, walSync)
+}
+
+// replayWAL completes or rolls back the flushes that were interrupted.  Flushes
+// that anchored their merkle root are completed with the logged flush record.
+// Flushes that did not are rolled back, nothing was written for them yet and
+// the container is flushed again by the next flusher run.  It returns the
+// number of entries that were replayed.
+//
+// This function must be called with the WRITE lock held.
+func (fs *FileSystem) replayWAL() (int, error) {
+	entries := make([]walEntry, 0, 1)
+	iter := fs.wal.NewIterator(nil, nil)
+	for iter.Next() {
+		var e walEntry
+		err := json.Unmarshal(iter.Value(), &e)
+		if err != nil {
+			iter.Release()
+			return 0, fmt.Errorf("wal %s: %v", iter.Key(), err)
+		}
+		entries = append(entries, e)
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		return 0, err
+	}
+
+	for _, e := range entries {
+		dir := ts2dirname(e.Timestamp)
+		if e.Record == nil {
+			// The wallet may have broadcast the anchor before the
+			// crash, in which case it is anchored twice.
+			log.Warnf("WAL: rolled back flush of %v merkle %x",
+				dir, e.Root)
+			err := fs.walDelete(e.Timestamp)
+			if err != nil {
+				return 0, err
+			}
+			continue
+		}
+
+		fr, err := DecodeFlushRecord(e.Record)
+		if err != nil {
+			return 0, fmt.Errorf("wal %v: %v", dir, err)
+		}
+		db, err := fs.openWrite(e.Timestamp, false)
+		if err != nil {
+			return 0, fmt.Errorf("wal %v: %v", dir, err)
+		}
+		if isFlushed(db) {
+			log.Infof("WAL: flush of %v already completed", dir)
+		} else {
+			err = fs.commitFlush(db, e.Timestamp, e.Record)
+			if err != nil {
+				db.Close()
+				return 0, fmt.Errorf("wal %v: %v", dir, err)
+			}
+			fs.commit++
+			log.Infof("WAL: completed flush of %v merkle %x tx %v",
+				dir, fr.Root, fr.Tx)
+		}
+		db.Close()
+
+		err = fs.walDelete(e.Timestamp)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return len(entries), nil
+}
@@ -0,0 +1,159 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package leveldb
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"sort"
+
+	"github.com/decred/dcrtime/dcrtimed/backend"
+	"github.com/decred/dcrtime/dcrtimed/dcrtimewallet"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+var (
+	errInvalidConfirmations  = errors.New("invalid confirmations")
+	errNotEnoughConfirmation = errors.New("not enough confirmations")
+)
+
+// confirm looks up the anchor of the provided flush record and, if it has
+// enough confirmations, updates the chain anchor timestamp of said record and
+// writes it back to the database.  It returns the result of the wallet's
+// Lookup function.
+//
+// Concurrent readers may write back the same flush record at once.  This is
+// OK because at worst they race atomic writes of the same information to the
+// same key.
+func (l *LevelDB) confirm(ts int64, fr *backend.FlushRecord) (*dcrtimewallet.TxLookupResult, error) {
+	res, err := l.wallet.Lookup(fr.Tx)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Debugf("confirm confirmations: %v", res.Confirmations)
+
+	if res.Confirmations == -1 {
+		return nil, errInvalidConfirmations
+	} else if res.Confirmations < l.confirmations {
+		// Return error & wallet lookup res
+		// for error handling
+		return res, errNotEnoughConfirmation
+	}
+
+	// Reassign and write back flush record
+	fr.ChainTimestamp = res.Timestamp
+	fr.BlockHeight = res.BlockHeight
+	payload, err := json.Marshal(*fr)
+	if err != nil {
+		return nil, err
+	}
+	err = l.db.Put(tsKey(flushPrefix, ts), payload, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Infof("Flushed anchor timestamp: %v %v", fr.Tx.String(),
+		res.Timestamp)
+
+	return res, nil
+}
+
+// anchorHeight returns the block height of the anchor of the provided flush
+// record.  Flush records whose anchor was not confirmed yet are updated
+// through the wallet.  Zero is returned for anchors without enough
+// confirmations.
+func (l *LevelDB) anchorHeight(ts int64, fr *backend.FlushRecord) (int32, error) {
+	if fr.ChainTimestamp != 0 && fr.BlockHeight != 0 {
+		return fr.BlockHeight, nil
+	}
+
+	_, err := l.confirm(ts, fr)
+	switch {
+	case errors.Is(err, errNotEnoughConfirmation):
+		return 0, nil
+	case errors.Is(err, errInvalidConfirmations):
+		// Do not let a single bad anchor fail the entire query.
+		log.Errorf("%v: Confirmations = -1", fr.Tx)
+		return 0, nil
+	case err != nil:
+		return 0, err
+	}
+
+	return fr.BlockHeight, nil
+}
+
+// flushRecords calls f with the flush record of every flushed collection with
+// a timestamp between from and to, inclusive, ordered by timestamp.  It stops
+// at the first error f returns, which is returned as is.
+func (l *LevelDB) flushRecords(from, to int64, f func(int64, *backend.FlushRecord) error) error {
+	if from < 0 {
+		from = 0
+	}
+	r := &util.Range{
+		Start: tsKey(flushPrefix, from),
+		Limit: util.BytesPrefix([]byte(flushPrefix)).Limit,
+	}
+	if to < maxTimestamp {
+		r.Limit = tsKey(flushPrefix, to+1)
+	}
+	i := l.db.NewIterator(r, nil)
+	defer i.Release()
+	for i.Next() {
+		key := i.Key()[len(flushPrefix):]
+		if len(key) != 8 {
+			return errInvalidDB
+		}
+		ts := int64(binary.BigEndian.Uint64(key))
+		var fr backend.FlushRecord
+		if err := json.Unmarshal(i.Value(), &fr); err != nil {
+			return err
+		}
+		if err := f(ts, &fr); err != nil {
+			return err
+		}
+	}
+	return i.Error()
+}
+
+// GetAnchors returns all collections that were anchored in blocks between the
+// provided block heights, inclusive.  This call satisfies the backend
+// interface.
+func (l *LevelDB) GetAnchors(from, to int32) ([]backend.AnchorResult, error) {
+	anchors := make([]backend.AnchorResult, 0, 64)
+	err := l.flushRecords(0, maxTimestamp, func(ts int64, fr *backend.FlushRecord) error {
+		height, err := l.anchorHeight(ts, fr)
+		if err != nil {
+			return err
+		}
+		if height == 0 || height < from || height > to {
+			return nil
+		}
+
+		hashes, err := l.flushedHashes(ts, fr)
+		if err != nil {
+			return err
+		}
+		anchors = append(anchors, backend.AnchorResult{
+			Timestamp:      ts,
+			Tx:             fr.Tx,
+			MerkleRoot:     fr.Root,
+			BlockHeight:    height,
+			ChainTimestamp: fr.ChainTimestamp,
+			Digests:        derefHashes(hashes),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(anchors, func(i, j int) bool {
+		return anchors[i].BlockHeight < anchors[j].BlockHeight
+	})
+
+	return anchors, nil
+}
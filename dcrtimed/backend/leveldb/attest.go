@@ -0,0 +1,48 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package leveldb
+
+import (
+	"context"
+	"crypto/sha256"
+	"time"
+
+	"github.com/decred/dcrtime/dcrtimed/backend"
+)
+
+// attestTimeout is the maximum time a secondary anchorer may take to attest a
+// merkle root.
+const attestTimeout = 30 * time.Second
+
+// attest submits the merkle root of the collection with the provided timestamp
+// to all secondary anchorers and returns their attestations.  The Decred
+// anchor is the proof of the collection, anchorers that fail are logged and
+// skipped so that they never hold up a flush.
+func (l *LevelDB) attest(ts int64, root [sha256.Size]byte) []backend.Attestation {
+	if len(l.anchorers) == 0 {
+		return nil
+	}
+
+	attestations := make([]backend.Attestation, 0, len(l.anchorers))
+	for _, a := range l.anchorers {
+		ctx, cancel := context.WithTimeout(context.Background(),
+			attestTimeout)
+		proof, err := a.Anchor(ctx, root)
+		cancel()
+		if err != nil {
+			log.Errorf("Attestation of %v by %v: %v", ts2dirname(ts),
+				a.Name(), err)
+			continue
+		}
+		log.Debugf("Attestation of %v by %v: %x", ts2dirname(ts),
+			a.Name(), proof)
+		attestations = append(attestations, backend.Attestation{
+			Anchorer: a.Name(),
+			Proof:    proof,
+		})
+	}
+
+	return attestations
+}
@@ -0,0 +1,80 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package leveldb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/decred/dcrtime/dcrtimed/backend"
+	"github.com/decred/dcrtime/dcrtimed/backend/testsuite"
+)
+
+// harness runs the LevelDB backend on an artificial clock.
+type harness struct {
+	root      string
+	wallet    *testsuite.Wallet
+	timestamp int64
+	l         *LevelDB
+}
+
+// open mirrors New without launching the flusher.
+func (h *harness) open(t *testing.T) *LevelDB {
+	l, err := internalNew(h.root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.wallet = h.wallet
+	l.enableCollections = true
+	l.confirmations = 6
+	l.maxDigests = 20
+	l.myNow = func() time.Time {
+		return time.Unix(h.timestamp, 0)
+	}
+	h.l = l
+	return l
+}
+
+func (h *harness) Open(t *testing.T) backend.Backend {
+	l := h.open(t)
+	l.flushMtx.Lock()
+	l.flush()
+	l.flushMtx.Unlock()
+	return l
+}
+
+func (h *harness) OpenRestore(t *testing.T) backend.Backend {
+	h.root = t.TempDir()
+	l, err := NewRestore(h.root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+func (h *harness) OpenStandby(t *testing.T) backend.Backend {
+	h.root = t.TempDir()
+	l := h.open(t)
+	l.readOnly = true
+	return l
+}
+
+func (h *harness) Advance(t *testing.T) {
+	h.timestamp = time.Unix(h.timestamp, 0).Add(duration).Unix()
+}
+
+func (h *harness) Flush(t *testing.T) {
+	h.l.flusher()
+}
+
+func TestConformance(t *testing.T) {
+	testsuite.Run(t, func(t *testing.T, w *testsuite.Wallet) testsuite.Harness {
+		return &harness{
+			root:      t.TempDir(),
+			wallet:    w,
+			timestamp: time.Now().Unix(),
+		}
+	})
+}
@@ -0,0 +1,26 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package leveldb
+
+import (
+	"github.com/decred/dcrtime/dcrtimed/backend"
+)
+
+// DriverName is the name the leveldb backend is registered under.
+const DriverName = "leveldb"
+
+func init() {
+	backend.Register(DriverName, open)
+}
+
+// open opens a leveldb backend with the provided configuration.  It is the
+// registered backend driver.
+func open(cfg backend.Config) (backend.Backend, error) {
+	l, err := New(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return l, nil
+}
@@ -0,0 +1,324 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package leveldb
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/decred/dcrtime/dcrtimed/backend"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/filter"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+)
+
+// currentFile is the file that leveldb keeps in every database directory.
+const currentFile = "CURRENT"
+
+// NewDump opens the existing database in root for Dump.
+func NewDump(root string) (*LevelDB, error) {
+	// Stat path first so that we don't create a database for a non
+	// existing root.
+	_, err := os.Stat(filepath.Join(root, currentFile))
+	if err != nil {
+		return nil, os.ErrNotExist
+	}
+	db, err := openDB(root)
+	if err != nil {
+		return nil, err
+	}
+	return &LevelDB{root: root, db: db}, nil
+}
+
+// NewRestore creates a new database in root for Restore.  It refuses to
+// restore into an existing database.
+func NewRestore(root string) (*LevelDB, error) {
+	_, err := os.Stat(filepath.Join(root, currentFile))
+	if err == nil {
+		return nil, os.ErrExist
+	}
+	err = os.MkdirAll(root, 0700)
+	if err != nil {
+		return nil, err
+	}
+	db, err := leveldb.OpenFile(root, &opt.Options{
+		ErrorIfExist: true,
+		Filter:       filter.NewBloomFilter(10),
+	})
+	if errors.Is(err, errWouldBlock) {
+		return nil, fmt.Errorf("%w: %v", ErrLocked, root)
+	} else if err != nil {
+		return nil, err
+	}
+	return &LevelDB{root: root, db: db}, nil
+}
+
+func dumpDigestTimestamp(f *os.File, verbose bool, recordType string, dr backend.DigestReceived) error {
+	if verbose {
+		ts := ts2dirname(dr.Timestamp)
+		fmt.Fprintf(f, "Digest     : %v\n", dr.Digest)
+		fmt.Fprintf(f, "Timestamp  : %v -> %v\n", dr.Timestamp, ts)
+		if dr.Label != "" {
+			fmt.Fprintf(f, "Label      : %v\n", dr.Label)
+		}
+		if dr.Algorithm != "" {
+			fmt.Fprintf(f, "Algorithm  : %v\n", dr.Algorithm)
+		}
+		return nil
+	}
+
+	e := json.NewEncoder(f)
+	rt := backend.RecordType{
+		Version: backend.RecordTypeVersion,
+		Type:    recordType,
+	}
+	err := e.Encode(rt)
+	if err != nil {
+		return err
+	}
+	return e.Encode(dr)
+}
+
+// flushRecordJSON returns the flush record of the collection with the
+// provided timestamp and digests in its dump and replication format.
+func flushRecordJSON(ts int64, fr *backend.FlushRecord, hashes []*[sha256.Size]byte) *backend.FlushRecordJSON {
+	return &backend.FlushRecordJSON{
+		Root:           fr.Root,
+		Hashes:         hashes,
+		Tx:             fr.Tx,
+		ChainTimestamp: fr.ChainTimestamp,
+		FlushTimestamp: fr.FlushTimestamp,
+		BlockHeight:    fr.BlockHeight,
+		AnchorPrefix:   fr.AnchorPrefix,
+		Replaced:       fr.Replaced,
+		Attestations:   fr.Attestations,
+		Reorged:        fr.Reorged,
+		Timestamp:      ts,
+	}
+}
+
+func dumpFlushRecord(f *os.File, fr *backend.FlushRecordJSON) {
+	fmt.Fprintf(f, "Merkle root    : %x\n", fr.Root)
+	fmt.Fprintf(f, "Tx             : %v\n", fr.Tx)
+	if fr.AnchorPrefix != "" {
+		fmt.Fprintf(f, "Anchor prefix  : %q\n", fr.AnchorPrefix)
+	}
+	for _, tx := range fr.Replaced {
+		fmt.Fprintf(f, "Replaced tx    : %v\n", tx)
+	}
+	for _, a := range fr.Attestations {
+		fmt.Fprintf(f, "Attestation    : %v %x\n", a.Anchorer, a.Proof)
+	}
+	fmt.Fprintf(f, "Chain timestamp: %v\n", fr.ChainTimestamp)
+	fmt.Fprintf(f, "Flush timestamp: %v\n", fr.FlushTimestamp)
+	fmt.Fprintf(f, "Block height   : %v\n", fr.BlockHeight)
+	for _, v := range fr.Hashes {
+		fmt.Fprintf(f, "  Flushed      : %x\n", *v)
+	}
+}
+
+// dumpCollection dumps the flush record, if any, followed by the digests of
+// the collection with the provided timestamp.  It returns whether the
+// collection was flushed.
+func (l *LevelDB) dumpCollection(f *os.File, verbose bool, ts int64, digests []backend.DigestReceived) (bool, error) {
+	if verbose {
+		fmt.Fprintf(f, "--- Timestamp: %v %v\n", ts2dirname(ts), ts)
+	}
+
+	fr, err := l.flushRecord(ts)
+	switch {
+	case errors.Is(err, leveldb.ErrNotFound):
+	case err != nil:
+		return false, err
+	default:
+		hashes, err := l.flushedHashes(ts, fr)
+		if err != nil {
+			return false, err
+		}
+		frj := flushRecordJSON(ts, fr, hashes)
+		if verbose {
+			dumpFlushRecord(f, frj)
+		} else {
+			e := json.NewEncoder(f)
+			rt := backend.RecordType{
+				Version: backend.RecordTypeVersion,
+				Type:    backend.RecordTypeFlushRecord,
+			}
+			err := e.Encode(rt)
+			if err != nil {
+				return false, err
+			}
+			err = e.Encode(frj)
+			if err != nil {
+				return false, err
+			}
+		}
+	}
+
+	for _, dr := range digests {
+		err := dumpDigestTimestamp(f, verbose,
+			backend.RecordTypeDigestReceived, dr)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	return fr != nil, nil
+}
+
+// Dump dumps all collections to either verbose readable or JSON format.  The
+// digests of flushed collections are dumped again as global digests
+// afterwards.  The format is the one of the filesystem backend so that dumps
+// can be restored by either backend.
+//
+// Dump satisfies the backend interface.
+func (l *LevelDB) Dump(f *os.File, verbose bool) error {
+	var global []backend.DigestReceived
+	err := l.walkCollections(0, func(ts int64, hashes []*[sha256.Size]byte, values [][]byte) error {
+		digests := make([]backend.DigestReceived, 0, len(hashes))
+		for k, h := range hashes {
+			digests = append(digests, backend.DigestReceived{
+				Digest:    hex.EncodeToString(h[:]),
+				Timestamp: ts,
+				Label:     digestLabel(values[k]),
+				Algorithm: digestAlgorithm(values[k]),
+			})
+		}
+		flushed, err := l.dumpCollection(f, verbose, ts, digests)
+		if err != nil {
+			return err
+		}
+		if flushed {
+			global = append(global, digests...)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, dr := range global {
+		err := dumpDigestTimestamp(f, verbose,
+			backend.RecordTypeDigestReceivedGlobal, dr)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// restoreFlushRecord restores the passed flush record.  The collection is no
+// longer pending.
+func (l *LevelDB) restoreFlushRecord(verbose bool, fr backend.FlushRecordJSON) error {
+	if fr.Timestamp <= 0 {
+		return fmt.Errorf("invalid timestamp: %v", fr.Timestamp)
+	}
+	if verbose {
+		fmt.Printf("%v\n", ts2dirname(fr.Timestamp))
+	}
+
+	payload, err := json.Marshal(backend.FlushRecord{
+		Root:            fr.Root,
+		Hashes:          fr.Hashes,
+		Tx:              fr.Tx,
+		ChainTimestamp:  fr.ChainTimestamp,
+		FlushTimestamp:  fr.FlushTimestamp,
+		ServerTimestamp: fr.Timestamp,
+		BlockHeight:     fr.BlockHeight,
+		AnchorPrefix:    fr.AnchorPrefix,
+		Replaced:        fr.Replaced,
+		Attestations:    fr.Attestations,
+		Reorged:         fr.Reorged,
+	})
+	if err != nil {
+		return err
+	}
+
+	batch := new(leveldb.Batch)
+	batch.Put(tsKey(flushPrefix, fr.Timestamp), payload)
+	batch.Delete(tsKey(pendingPrefix, fr.Timestamp))
+	return l.db.Write(batch, nil)
+}
+
+// restoreDigestReceived restores the passed digest into its collection.  The
+// collection is pending unless its flush record was restored.
+func (l *LevelDB) restoreDigestReceived(dr backend.DigestReceived, flushed map[int64]struct{}) error {
+	if dr.Timestamp <= 0 {
+		return fmt.Errorf("invalid timestamp: %v", dr.Timestamp)
+	}
+	digest, err := hex.DecodeString(dr.Digest)
+	if err != nil {
+		return err
+	}
+	if len(digest) != sha256.Size {
+		return fmt.Errorf("invalid digest: %v", dr.Digest)
+	}
+
+	batch := new(leveldb.Batch)
+	putDigest(batch, dr.Timestamp, digest, dr.Label, dr.Algorithm)
+	if _, ok := flushed[dr.Timestamp]; !ok {
+		batch.Put(tsKey(pendingPrefix, dr.Timestamp), nil)
+	}
+	return l.db.Write(batch, nil)
+}
+
+// Restore reads JSON encoded database contents and recreates the database.
+// Dumps of the filesystem backend are accepted as well, which is how a
+// filesystem backend is migrated.
+//
+// Restore satisfies the backend interface.
+func (l *LevelDB) Restore(f *os.File, verbose bool, location string) error {
+	// Collections whose flush record was restored.
+	flushed := make(map[int64]struct{})
+
+	d := json.NewDecoder(f)
+	for {
+		// Type
+		var t backend.RecordType
+		err := d.Decode(&t)
+		if err != nil {
+			return err
+		}
+
+		// Check version we understand
+		if t.Version != backend.RecordTypeVersion {
+			return fmt.Errorf("unknown version %v", t.Version)
+		}
+
+		switch t.Type {
+		case backend.RecordTypeDigestReceived,
+			backend.RecordTypeDigestReceivedGlobal:
+			// Global digests were restored with their collection
+			// already, restoring them again is harmless.
+			var dr backend.DigestReceived
+			err := d.Decode(&dr)
+			if err != nil {
+				return err
+			}
+			err = l.restoreDigestReceived(dr, flushed)
+			if err != nil {
+				return err
+			}
+		case backend.RecordTypeFlushRecord:
+			var fr backend.FlushRecordJSON
+			err := d.Decode(&fr)
+			if err != nil {
+				return err
+			}
+			err = l.restoreFlushRecord(verbose, fr)
+			if err != nil {
+				return err
+			}
+			flushed[fr.Timestamp] = struct{}{}
+		default:
+			return fmt.Errorf("invalid record type: %v", t.Type)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package leveldb
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+)
+
+const (
+	// encryptionKeySize is the size of the AES-256 key that encrypts
+	// digest metadata at rest.
+	encryptionKeySize = 32
+
+	// encryptedVersion prefixes encrypted payloads.  Plaintext payloads
+	// are JSON objects and start with '{'.  Payloads are encrypted the
+	// same way as by the filesystem backend.
+	encryptedVersion = 1
+)
+
+var (
+	// errEncrypted is returned when an encrypted payload is read without
+	// an encryption key.
+	errEncrypted = errors.New("payload is encrypted and no encryption " +
+		"key is configured")
+)
+
+// newAEAD returns the AES-GCM cipher of the provided key, nil if the key is
+// nil.
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if key == nil {
+		return nil, nil
+	}
+	if len(key) != encryptionKeySize {
+		return nil, fmt.Errorf("invalid encryption key size: %v",
+			len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts the payload of the provided digest when encryption is enabled
+// and returns it unmodified otherwise.  The digest is authenticated so that
+// encrypted payloads can not be moved to another digest.
+func (l *LevelDB) seal(digest, payload []byte) ([]byte, error) {
+	if l.aead == nil {
+		return payload, nil
+	}
+	ns := l.aead.NonceSize()
+	sealed := make([]byte, 1+ns, 1+ns+len(payload)+l.aead.Overhead())
+	sealed[0] = encryptedVersion
+	if _, err := rand.Read(sealed[1:]); err != nil {
+		return nil, err
+	}
+	return l.aead.Seal(sealed, sealed[1:], payload, digest), nil
+}
+
+// unseal decrypts a payload that seal stored for the provided digest.
+// Payloads that were stored without encryption are returned unmodified.
+func (l *LevelDB) unseal(digest, payload []byte) ([]byte, error) {
+	if len(payload) == 0 || payload[0] != encryptedVersion {
+		return payload, nil
+	}
+	if l.aead == nil {
+		return nil, errEncrypted
+	}
+	ns := l.aead.NonceSize()
+	if len(payload) < 1+ns {
+		return nil, fmt.Errorf("encrypted payload too short: %v",
+			len(payload))
+	}
+	return l.aead.Open(nil, payload[1:1+ns], payload[1+ns:], digest)
+}
@@ -0,0 +1,39 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package leveldb
+
+import (
+	"github.com/decred/dcrtime/dcrtimed/backend"
+)
+
+// ExportCollections calls f with every flushed collection with a timestamp
+// between from and to, inclusive.  The iterator reads a snapshot of the
+// database, so a slow consumer does not hold up flushes.  This call satisfies
+// the backend interface.
+func (l *LevelDB) ExportCollections(from, to int64, f func(backend.ExportRecord) error) error {
+	return l.flushRecords(from, to, func(ts int64, fr *backend.FlushRecord) error {
+		height, err := l.anchorHeight(ts, fr)
+		if err != nil {
+			return err
+		}
+		hashes, err := l.flushedHashes(ts, fr)
+		if err != nil {
+			return err
+		}
+
+		er := backend.ExportRecord{
+			Timestamp:      ts,
+			FlushTimestamp: fr.FlushTimestamp,
+			MerkleRoot:     fr.Root,
+			Tx:             fr.Tx,
+			BlockHeight:    height,
+			Digests:        derefHashes(hashes),
+		}
+		if height != 0 {
+			er.ChainTimestamp = fr.ChainTimestamp
+		}
+		return f(er)
+	})
+}
@@ -0,0 +1,220 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package leveldb
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/decred/dcrtime/dcrtimed/backend"
+	"github.com/decred/dcrtime/dcrtimed/dcrtimewallet"
+	"github.com/decred/dcrtime/merkle"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// flushingPrefix prefixes the entries of the flushes that are in progress:
+// prefix | timestamp.
+const flushingPrefix = "flushing/"
+
+// flushingEntry describes a flush that is in progress.  It is written before
+// the anchor is constructed and removed in the batch that commits the flush
+// record, so that a flush that was interrupted by a crash is found on
+// startup.
+type flushingEntry struct {
+	Timestamp int64             `json:"timestamp"` // Collection being flushed
+	Root      [sha256.Size]byte `json:"root"`      // Merkle root of the collection
+	Started   int64             `json:"started"`   // Start of the flush
+}
+
+// rollbackFlushes removes the entries of the flushes that were interrupted.
+// Nothing was committed for them, the collections are still pending and are
+// flushed again.
+func (l *LevelDB) rollbackFlushes() error {
+	batch := new(leveldb.Batch)
+	i := l.db.NewIterator(util.BytesPrefix([]byte(flushingPrefix)), nil)
+	defer i.Release()
+	for i.Next() {
+		var e flushingEntry
+		err := json.Unmarshal(i.Value(), &e)
+		if err != nil {
+			return fmt.Errorf("flushing %x: %v", i.Key(), err)
+		}
+
+		// The wallet may have broadcast the anchor before the crash,
+		// in which case it is anchored twice.
+		log.Warnf("Rolled back flush of %v merkle %x",
+			ts2dirname(e.Timestamp), e.Root)
+		batch.Delete(append([]byte(nil), i.Key()...))
+	}
+	if err := i.Error(); err != nil {
+		return err
+	}
+	if batch.Len() == 0 {
+		return nil
+	}
+
+	return l.db.Write(batch, syncWrite)
+}
+
+// flushCollection calculates the merkle root of the collection with the
+// provided timestamp, sends it to the wallet and commits the flush record.
+// The flush record, the removal of the pending marker and the removal of the
+// flushing entry are written atomically.
+//
+// This function must be called with the flush mutex held.
+func (l *LevelDB) flushCollection(ts int64) error {
+	hashes, err := l.collectionHashes(ts)
+	if err != nil {
+		return err
+	}
+	if len(hashes) == 0 {
+		// this really should not happen.
+		return errEmptySet
+	}
+
+	// Create merkle root and send to wallet.  Large collections are
+	// hashed on several goroutines without building the tree in memory.
+	root, err := merkle.ParallelRoot(hashes, l.flushWorkers)
+	if err != nil {
+		return err
+	}
+	fr := backend.FlushRecord{
+		Root:            *root,
+		FlushTimestamp:  time.Now().Unix(),
+		ServerTimestamp: ts,
+	}
+
+	// Record the flush before the anchor is constructed so that it is
+	// rolled back when it is interrupted.
+	entry, err := json.Marshal(flushingEntry{
+		Timestamp: ts,
+		Root:      fr.Root,
+		Started:   fr.FlushTimestamp,
+	})
+	if err != nil {
+		return err
+	}
+	key := tsKey(flushingPrefix, ts)
+	err = l.db.Put(key, entry, syncWrite)
+	if err != nil {
+		return err
+	}
+
+	tx, err := l.wallet.Construct(fr.Root, []byte(l.anchorPrefix))
+	l.setAnchorError(err)
+	if err != nil {
+		// Nothing was written, roll back.
+		if err := l.db.Delete(key, syncWrite); err != nil {
+			log.Errorf("flush %v: %v", ts2dirname(ts), err)
+		}
+		// The collection is flushed again on the next run, make sure
+		// operators notice anchors that are refused.
+		if errors.Is(err, dcrtimewallet.ErrFeeTooHigh) {
+			log.Criticalf("Anchor of %v refused: %v",
+				ts2dirname(ts), err)
+		}
+		return fmt.Errorf("flush Construct tx: %w", err)
+	}
+	log.Infof("Flush timestamp: %v digests %v merkle: %x tx: %v",
+		ts2dirname(ts), len(hashes), fr.Root, tx.String())
+	fr.Tx = *tx
+	fr.AnchorPrefix = l.anchorPrefix
+	fr.Attestations = l.attest(ts, fr.Root)
+
+	// The digests are not recorded, the collection does not change once
+	// it was flushed.
+	payload, err := json.Marshal(fr)
+	if err != nil {
+		return err
+	}
+	batch := new(leveldb.Batch)
+	batch.Put(tsKey(flushPrefix, ts), payload)
+	batch.Delete(tsKey(pendingPrefix, ts))
+	batch.Delete(key)
+	err = l.db.Write(batch, syncWrite)
+	if err != nil {
+		return err
+	}
+
+	l.Lock()
+	delete(l.pending, ts)
+	l.Unlock()
+
+	return nil
+}
+
+// closed returns the timestamps of the pending collections whose window ended,
+// oldest first.  Submissions to those collections that are in progress are
+// waited for.
+//
+// This function must be called with the flush mutex held.
+func (l *LevelDB) closed() []int64 {
+	current := l.now().Unix()
+
+	// Submissions compute their collection while holding putMtx, so once
+	// it was acquired no submission stores digests in a closed collection
+	// anymore.
+	l.putMtx.Lock()
+	l.putMtx.Unlock()
+
+	l.RLock()
+	defer l.RUnlock()
+	tss := make([]int64, 0, len(l.pending))
+	for ts := range l.pending {
+		if ts < current {
+			tss = append(tss, ts)
+		}
+	}
+	sort.Slice(tss, func(i, j int) bool { return tss[i] < tss[j] })
+
+	return tss
+}
+
+// flush flushes the pending collections whose window ended and returns the
+// number of collections that were flushed.  Collections that fail to flush
+// are flushed again by the next flusher run.
+//
+// This function must be called with the flush mutex held.
+func (l *LevelDB) flush() int {
+	count := 0
+	for _, ts := range l.closed() {
+		err := l.flushCollection(ts)
+		if err != nil {
+			log.Errorf("flush %v: %v", ts2dirname(ts), err)
+			continue
+		}
+		count++
+	}
+
+	return count
+}
+
+// flusher is called periodically to flush the closed collections.
+func (l *LevelDB) flusher() {
+	// From this point on flushes and anchors must be serialized.
+	l.flushMtx.Lock()
+	defer l.flushMtx.Unlock()
+
+	l.RLock()
+	maintenance, queued := l.maintenance, l.queued
+	l.RUnlock()
+	if maintenance {
+		log.Infof("Flusher: maintenance, digests queued %v", queued)
+		l.flusherCompleted()
+		return
+	}
+
+	start := time.Now()
+	count := l.flush()
+	end := time.Since(start)
+	l.flusherCompleted()
+
+	log.Infof("Flusher: collections %v in %v", count, end)
+}
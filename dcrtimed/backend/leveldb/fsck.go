@@ -0,0 +1,320 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package leveldb
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/decred/dcrd/txscript/v4"
+	"github.com/decred/dcrdata/api/types/v5"
+	"github.com/decred/dcrtime/dcrtimed/backend"
+	"github.com/decred/dcrtime/merkle"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// errJournal is returned by Fsck when a results file is requested.  Fixes are
+// applied in a single batch, there is nothing to journal.
+var errJournal = errors.New("fsck results file is not supported")
+
+func extractNullDataMerkleRootV0(script []byte) []byte {
+	// A null script is of the form:
+	//  OP_RETURN <optional data>
+	//
+	// Thus, it can either be a single OP_RETURN or an OP_RETURN followed by a
+	// canonical data push up to MaxDataCarrierSizeV0 bytes.
+	//
+	// When it houses a Merkle root, there will be a single push of 32 bytes,
+	// optionally preceded by the anchor prefix of the operator in the same
+	// push.  The Merkle root is always the last 32 bytes.
+	if len(script) >= 34 &&
+		script[0] == txscript.OP_RETURN &&
+		script[1] >= txscript.OP_DATA_32 &&
+		script[1] <= txscript.OP_DATA_75 &&
+		int(script[1]) == len(script)-2 {
+
+		return script[len(script)-32:]
+	}
+
+	return nil
+}
+
+// fsckAnchor verifies that the merkle root of the flush record exists on the
+// blockchain according to the dcrdata instance at url.
+func fsckAnchor(url string, fr *backend.FlushRecord) error {
+	u := url + fr.Tx.String() + "/out"
+	r, err := http.Get(u)
+	if err != nil {
+		return fmt.Errorf("   *** ERROR HTTP Get: %v", err)
+	}
+	defer r.Body.Close()
+
+	if r.StatusCode != http.StatusOK {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			return fmt.Errorf("   *** ERROR invalid "+
+				"body: %v %v", r.StatusCode, body)
+		}
+		return fmt.Errorf("   *** ERROR invalid dcrdata "+
+			"answer: %v %s", r.StatusCode, body)
+	}
+
+	var txOuts []types.TxOut
+	d := json.NewDecoder(r.Body)
+	if err := d.Decode(&txOuts); err != nil {
+		return err
+	}
+
+	for _, v := range txOuts {
+		if !types.IsNullDataScript(v.ScriptPubKeyDecoded.Type) {
+			continue
+		}
+		script, err := hex.DecodeString(v.ScriptPubKeyDecoded.Hex)
+		if err != nil {
+			return fmt.Errorf("   *** ERROR invalid "+
+				"dcrdata script: %v", err)
+		}
+		data := extractNullDataMerkleRootV0(script)
+		if data == nil {
+			return fmt.Errorf("invalid script")
+		}
+		if bytes.Equal(data, fr.Root[:]) {
+			return nil
+		}
+	}
+	return fmt.Errorf("   *** ERROR merkle root not found: tx %v "+
+		"merkle %x", fr.Tx, fr.Root)
+}
+
+// fsckCollection verifies that a collection is coherent by doing the
+// following:
+//  1. Ensure that every digest of the collection is indexed with the
+//     collection timestamp.
+//  2. If the collection was not flushed ensure that it is pending.
+//  3. If it was flushed verify the merkle root of the flush record and that
+//     it exists on the blockchain.
+func (l *LevelDB) fsckCollection(options *backend.FsckOptions, ts int64, hashes []*[sha256.Size]byte, values [][]byte, batch *leveldb.Batch) error {
+	// 1. Make sure the index points to the collection
+	for k, h := range hashes {
+		if options.PrintHashes {
+			fmt.Printf("Hash           : %x\n", *h)
+		}
+		value, err := l.db.Get(digestKey(h[:]), nil)
+		if err != nil {
+			return fmt.Errorf("   *** ERROR not found in index: %x",
+				*h)
+		}
+		if !bytes.Equal(value, values[k]) {
+			return fmt.Errorf("   *** ERROR timestamp mismatch: "+
+				"%x %v %v", *h, digestTimestamp(value), ts)
+		}
+	}
+
+	fr, err := l.flushRecord(ts)
+	if errors.Is(err, leveldb.ErrNotFound) {
+		// 2. Make sure the collection is flushed eventually
+		ok, err := l.db.Has(tsKey(pendingPrefix, ts), nil)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+		fmt.Printf("   *** ERROR collection not pending: %v (%v)\n",
+			ts2dirname(ts), ts)
+		if options.Fix {
+			fmt.Printf("   *** FIXING collection not pending: "+
+				"%v (%v)\n", ts2dirname(ts), ts)
+			batch.Put(tsKey(pendingPrefix, ts), nil)
+		}
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	flushed, err := l.flushedHashes(ts, fr)
+	if err != nil {
+		return err
+	}
+	if options.Verbose {
+		dumpFlushRecord(os.Stdout, flushRecordJSON(ts, fr, flushed))
+	}
+
+	// 3. Recreate merkle and verify it
+	root := merkle.Root(flushed)
+	if !bytes.Equal(root[:], fr.Root[:]) {
+		return fmt.Errorf("   *** ERROR mismatched merkle root: %x %x",
+			*root, fr.Root)
+	}
+	return fsckAnchor(options.URL, fr)
+}
+
+// fsckCollections verifies all collections.
+func (l *LevelDB) fsckCollections(options *backend.FsckOptions, batch *leveldb.Batch) error {
+	return l.walkCollections(0, func(ts int64, hashes []*[sha256.Size]byte, values [][]byte) error {
+		if options.Verbose || options.PrintHashes {
+			fmt.Printf("--- Checking: %v (%v)\n", ts2dirname(ts), ts)
+		}
+		err := l.fsckCollection(options, ts, hashes, values, batch)
+		if err != nil {
+			return err
+		}
+		if options.Verbose || options.PrintHashes {
+			fmt.Printf("=== Verified: %v (%v)\n", ts2dirname(ts), ts)
+		}
+		return nil
+	})
+}
+
+// fsckIndex walks the digest and label indexes and verifies that every entry
+// points to a digest of a collection.  Dangling entries are deleted.
+func (l *LevelDB) fsckIndex(options *backend.FsckOptions, batch *leveldb.Batch) error {
+	i := l.db.NewIterator(util.BytesPrefix([]byte(digestPrefix)), nil)
+	defer i.Release()
+	for i.Next() {
+		digest := i.Key()[len(digestPrefix):]
+		ts := digestTimestamp(i.Value())
+		if options.PrintHashes {
+			fmt.Printf("Indexed        : %x\n", digest)
+		}
+		ok, err := l.db.Has(collectionKey(ts, digest), nil)
+		if err != nil {
+			return err
+		}
+		if ok {
+			continue
+		}
+		fmt.Printf("   *** ERROR dangling digest: %x %v\n", digest, ts)
+		if options.Fix {
+			fmt.Printf("   *** FIXING dangling digest: delete %x\n",
+				digest)
+			batch.Delete(append([]byte(nil), i.Key()...))
+		}
+	}
+	if err := i.Error(); err != nil {
+		return err
+	}
+
+	j := l.db.NewIterator(util.BytesPrefix([]byte(labelPrefix)), nil)
+	defer j.Release()
+	for j.Next() {
+		key := j.Key()[len(labelPrefix):]
+		n := bytes.IndexByte(key, 0)
+		if n < 0 || len(key)-n-1 != 8+sha256.Size {
+			return errInvalidDB
+		}
+		ts := int64(binary.BigEndian.Uint64(key[n+1:]))
+		digest := key[n+1+8:]
+		ok, err := l.db.Has(collectionKey(ts, digest), nil)
+		if err != nil {
+			return err
+		}
+		if ok {
+			continue
+		}
+		fmt.Printf("   *** ERROR dangling label: %q %x %v\n",
+			key[:n], digest, ts)
+		if options.Fix {
+			fmt.Printf("   *** FIXING dangling label: delete "+
+				"%q %x\n", key[:n], digest)
+			batch.Delete(append([]byte(nil), j.Key()...))
+		}
+	}
+	return j.Error()
+}
+
+// fsckMarkers verifies that the flush records or pending markers with the
+// provided prefix belong to a collection.  Pending markers of collections that
+// no longer exist are deleted.  Flush records without a collection can't be
+// repaired.
+func (l *LevelDB) fsckMarkers(options *backend.FsckOptions, prefix string, batch *leveldb.Batch) error {
+	i := l.db.NewIterator(util.BytesPrefix([]byte(prefix)), nil)
+	defer i.Release()
+	for i.Next() {
+		key := i.Key()[len(prefix):]
+		if len(key) != 8 {
+			return errInvalidDB
+		}
+		ts := int64(binary.BigEndian.Uint64(key))
+		count, err := l.countCollection(ts)
+		if err != nil {
+			return err
+		}
+		if count != 0 {
+			continue
+		}
+		if prefix == flushPrefix {
+			return fmt.Errorf("   *** ERROR flush record of empty "+
+				"collection: %v (%v)", ts2dirname(ts), ts)
+		}
+		fmt.Printf("   *** ERROR empty collection: %v (%v)\n",
+			ts2dirname(ts), ts)
+		if options.Fix {
+			fmt.Printf("   *** FIXING removing empty collection: "+
+				"%v (%v)\n", ts2dirname(ts), ts)
+			batch.Delete(tsKey(prefix, ts))
+		}
+	}
+	return i.Error()
+}
+
+// Fsck walks all collections and verifies that there is no apparent data
+// corruption and that the flush records indeed exist on the blockchain.
+// Fixes are written once all checks passed.
+//
+// Fsck satisfies the backend interface.
+func (l *LevelDB) Fsck(options *backend.FsckOptions) error {
+	if options == nil {
+		options = &backend.FsckOptions{}
+	}
+	if options.File != "" {
+		return errJournal
+	}
+
+	t := time.Now()
+	fmt.Printf("=== FSCK started %v\n", t.Format(time.UnixDate))
+	defer func() {
+		fmt.Printf("=== FSCK completed %v\n",
+			time.Now().Format(time.UnixDate))
+	}()
+
+	batch := new(leveldb.Batch)
+	fmt.Printf("--- Phase 1: checking collections\n")
+	err := l.fsckCollections(options, batch)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("--- Phase 2: checking digest index\n")
+	err = l.fsckIndex(options, batch)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("--- Phase 3: checking flush records\n")
+	err = l.fsckMarkers(options, flushPrefix, batch)
+	if err != nil {
+		return err
+	}
+	err = l.fsckMarkers(options, pendingPrefix, batch)
+	if err != nil {
+		return err
+	}
+
+	if batch.Len() == 0 {
+		return nil
+	}
+	return l.db.Write(batch, syncWrite)
+}
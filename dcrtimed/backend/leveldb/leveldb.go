@@ -0,0 +1,1086 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+// Package leveldb implements a backend that keeps all digests, collections
+// and auxiliary records in a single embedded leveldb.  It is meant for single
+// node deployments with very high submission rates: every submission is one
+// atomic, synced batch, concurrent submissions share their fsyncs through the
+// leveldb journal and no directory or database is created per collection.
+package leveldb
+
+import (
+	"bytes"
+	"context"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/decred/dcrtime/dcrtimed/anchorer"
+	"github.com/decred/dcrtime/dcrtimed/backend"
+	"github.com/decred/dcrtime/dcrtimed/backend/sidestore"
+	"github.com/decred/dcrtime/dcrtimed/dcrtimewallet"
+	"github.com/decred/dcrtime/merkle"
+	"github.com/robfig/cron"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/filter"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+const (
+	fStr = "20060102.150405"
+
+	// digestPrefix prefixes the index of all digests: prefix | digest.
+	// The value is the digest value, see encodeDigestValue.
+	digestPrefix = "digest/"
+
+	// collectionPrefix prefixes the digests of the collections:
+	// prefix | timestamp | digest.  The value is the digest value.
+	// Digests sort in the order of the leaves of the merkle tree.
+	collectionPrefix = "collection/"
+
+	// labelPrefix prefixes the index of group labels:
+	// prefix | label | 0 | timestamp | digest.
+	labelPrefix = "label/"
+
+	// flushPrefix prefixes the flush records of the flushed collections:
+	// prefix | timestamp.
+	flushPrefix = "flush/"
+
+	// pendingPrefix prefixes the markers of the collections that were not
+	// flushed yet: prefix | timestamp.
+	pendingPrefix = "pending/"
+
+	// tokenPrefix, webhookPrefix, ownerPrefix and sessionPrefix prefix the
+	// keys of the side stores, see package sidestore.
+	tokenPrefix   = "token/"
+	webhookPrefix = "webhook/"
+	ownerPrefix   = "owner/"
+	sessionPrefix = "session/"
+
+	// maxTimestamp is the largest collection timestamp.
+	maxTimestamp = math.MaxInt64
+)
+
+var (
+	_ backend.Backend = (*LevelDB)(nil)
+
+	// duration and flushSchedule must match or bad things will happen.  By
+	// matching we mean both are hourly or every so many minutes.
+	//
+	// Seconds Minutes Hours Days Months DayOfWeek
+	flushSchedule = "10 0 * * * *" // On the hour + 10 seconds
+	duration      = time.Hour      // Default how often we combine digests
+
+	// syncWrite makes sure that a write is on disk before it returns.
+	// Concurrent synced writes are merged into one journal write and
+	// share its fsync.
+	syncWrite = &opt.WriteOptions{Sync: true}
+
+	// Errors
+	errInvalidDB = errors.New("not a database") // Should not happen
+	errEmptySet  = errors.New("empty set")
+)
+
+// LevelDB is a backend that stores everything in a single leveldb in the data
+// directory.  Digests are indexed when they are stored, so a digest is looked
+// up with a single read regardless of the collection it is in.
+type LevelDB struct {
+	sync.RWMutex // Protects the pending state below
+
+	pending     map[int64]int64                // Digests of unflushed collections
+	inflight    map[[sha256.Size]byte]struct{} // Digests that are being stored
+	maintenance bool                           // Queue digests and do not flush
+	maxQueued   int64                          // Pending digests allowed in maintenance
+	queued      int64                          // Pending digests while in maintenance
+	lastReorg   int64                          // Time an anchor was last reorganized out
+
+	// Side stores that satisfy the api token, webhook, collection
+	// ownership and submission session parts of the backend interface.
+	*sidestore.Tokens
+	*sidestore.Webhooks
+	*sidestore.Owners
+	*sidestore.Sessions
+
+	cron     *cron.Cron    // Scheduler for periodic tasks
+	root     string        // Data directory
+	db       *leveldb.DB   // All records
+	duration time.Duration // How often we combine digests
+
+	enableCollections bool   // Set to true to enable collection query
+	confirmations     int32  // Number of confirmations to return timestamp proof
+	maxDigests        int32  // Number of digests LastDigests may return
+	anchorPrefix      string // Prefix in front of merkle root in anchors
+	readOnly          bool   // Refuse digests and never flush
+
+	wallet    dcrtimewallet.Wallet // Wallet context.
+	anchorers []anchorer.Anchorer  // Secondary anchorers
+
+	// flushMtx serializes flushes and anchor transactions.  Everything
+	// that flushes, replaces anchors or removes collections that were not
+	// flushed yet holds it for the whole operation: the flusher,
+	// SetMaintenance, watchReorgs, DeleteCollection and Close.
+	//
+	// putMtx is held for reading by submissions while they store digests
+	// and for writing by the flusher, once, before it lists the closed
+	// collections.  Submissions that started before a window ended have
+	// then completed, so closed collections no longer change.
+	//
+	// flushMtx is acquired before putMtx, which is acquired before the
+	// embedded lock.
+	flushMtx     sync.Mutex
+	putMtx       sync.RWMutex
+	flushWorkers int   // Goroutines that calculate a merkle root
+	reorgHeight  int32 // Best block height at the last reorg check
+
+	blocksCancel context.CancelFunc // Ends the block subscription
+	blocks       int32              // Set while blocks are notified
+
+	metadataMtx sync.Mutex  // Serializes digest metadata updates
+	aead        cipher.AEAD // Encrypts metadata at rest, nil if disabled
+
+	auditSeq uint32 // Keeps proof records stored at once apart
+
+	statsMtx sync.Mutex // Serializes submission statistics updates
+
+	healthMtx       sync.Mutex    // Protects the flusher health
+	lastFlusher     time.Time     // Time the flusher last completed
+	flusherInterval time.Duration // Time between flusher runs
+	anchorErr       error         // Last anchor error, nil on success
+
+	// testing only entries
+	myNow func() time.Time // Override time.Now()
+}
+
+// ts2dirname converts a UNIX timestamp to a human readable timestamp.  It is
+// the name of the container directory of the collection in the filesystem
+// backend.
+func ts2dirname(ts int64) string {
+	return time.Unix(ts, 0).UTC().Format(fStr)
+}
+
+// tsKey returns the key with the provided prefix of the collection with the
+// provided timestamp.  Timestamps are big endian so that keys sort by
+// timestamp.
+func tsKey(prefix string, ts int64) []byte {
+	key := make([]byte, len(prefix)+8, len(prefix)+8+sha256.Size)
+	copy(key, prefix)
+	binary.BigEndian.PutUint64(key[len(prefix):], uint64(ts))
+	return key
+}
+
+// digestKey returns the index key of the provided digest.
+func digestKey(digest []byte) []byte {
+	return append([]byte(digestPrefix), digest...)
+}
+
+// collectionKey returns the key of the provided digest in the collection with
+// the provided timestamp.
+func collectionKey(ts int64, digest []byte) []byte {
+	return append(tsKey(collectionPrefix, ts), digest...)
+}
+
+// labelKey returns the label index key of the provided digest in the
+// collection with the provided timestamp.
+func labelKey(label string, ts int64, digest []byte) []byte {
+	key := make([]byte, 0, len(labelPrefix)+len(label)+1+8+sha256.Size)
+	key = append(key, labelPrefix...)
+	key = append(key, label...)
+	key = append(key, 0)
+	key = binary.BigEndian.AppendUint64(key, uint64(ts))
+	return append(key, digest...)
+}
+
+// encodeDigestValue returns the database value that is stored for a digest.
+// It consists of the little endian collection timestamp optionally followed by
+// the group label of the digest.  The digest algorithm, if it is not SHA-256,
+// is appended after a zero byte, which never occurs in a group label.  This is
+// the digest value of the filesystem backend.
+func encodeDigestValue(ts int64, label, algorithm string) []byte {
+	value := make([]byte, 8, 8+len(label)+1+len(algorithm))
+	binary.LittleEndian.PutUint64(value, uint64(ts))
+	value = append(value, label...)
+	if algorithm != "" {
+		value = append(value, 0)
+		value = append(value, algorithm...)
+	}
+	return value
+}
+
+// digestTimestamp returns the collection timestamp that is stored in a digest
+// database value.
+func digestTimestamp(value []byte) int64 {
+	if len(value) < 8 {
+		return 0
+	}
+	return int64(binary.LittleEndian.Uint64(value))
+}
+
+// digestLabel returns the group label that is stored in a digest database
+// value.
+func digestLabel(value []byte) string {
+	if len(value) <= 8 {
+		return ""
+	}
+	label := value[8:]
+	if i := bytes.IndexByte(label, 0); i >= 0 {
+		label = label[:i]
+	}
+	return string(label)
+}
+
+// digestAlgorithm returns the digest algorithm that is stored in a digest
+// database value.  It is empty for SHA-256 digests.
+func digestAlgorithm(value []byte) string {
+	if len(value) <= 8 {
+		return ""
+	}
+	i := bytes.IndexByte(value[8:], 0)
+	if i < 0 {
+		return ""
+	}
+	return string(value[8+i+1:])
+}
+
+// putDigest adds the provided digest of the collection with the provided
+// timestamp to the batch together with its index entries.
+func putDigest(batch *leveldb.Batch, ts int64, digest []byte, label, algorithm string) {
+	value := encodeDigestValue(ts, label, algorithm)
+	batch.Put(digestKey(digest), value)
+	batch.Put(collectionKey(ts, digest), value)
+	if label != "" {
+		batch.Put(labelKey(label, ts, digest), nil)
+	}
+}
+
+// derefHashes converts digest pointers to digests.
+func derefHashes(hashes []*[sha256.Size]byte) [][sha256.Size]byte {
+	digests := make([][sha256.Size]byte, 0, len(hashes))
+	for _, ph := range hashes {
+		if ph != nil {
+			digests = append(digests, *ph)
+		}
+	}
+	return digests
+}
+
+// now returns current time stamp rounded down to 1 hour.  All timestamps are
+// UTC.
+func (l *LevelDB) now() time.Time {
+	return l.myNow().UTC().Truncate(l.duration)
+}
+
+// flushRecord returns the flush record of the collection with the provided
+// timestamp.  leveldb.ErrNotFound is returned if it was not flushed.
+func (l *LevelDB) flushRecord(ts int64) (*backend.FlushRecord, error) {
+	payload, err := l.db.Get(tsKey(flushPrefix, ts), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var fr backend.FlushRecord
+	err = json.Unmarshal(payload, &fr)
+	if err != nil {
+		return nil, err
+	}
+	return &fr, nil
+}
+
+// walkCollections calls f with the digests, in ascending order, and their
+// digest values of every collection with a timestamp at or after since,
+// ordered by timestamp.  It stops at the first error f returns, which is
+// returned as is.
+func (l *LevelDB) walkCollections(since int64, f func(int64, []*[sha256.Size]byte, [][]byte) error) error {
+	if since < 0 {
+		since = 0
+	}
+	r := util.BytesPrefix([]byte(collectionPrefix))
+	r.Start = tsKey(collectionPrefix, since)
+
+	var (
+		ts     int64
+		hashes []*[sha256.Size]byte
+		values [][]byte
+	)
+	i := l.db.NewIterator(r, nil)
+	defer i.Release()
+	for i.Next() {
+		key := i.Key()[len(collectionPrefix):]
+		if len(key) != 8+sha256.Size {
+			return errInvalidDB
+		}
+		kts := int64(binary.BigEndian.Uint64(key))
+		if kts != ts && len(hashes) != 0 {
+			if err := f(ts, hashes, values); err != nil {
+				return err
+			}
+			hashes, values = nil, nil
+		}
+		ts = kts
+
+		var digest [sha256.Size]byte
+		copy(digest[:], key[8:])
+		hashes = append(hashes, &digest)
+		values = append(values, append([]byte(nil), i.Value()...))
+	}
+	if err := i.Error(); err != nil {
+		return err
+	}
+	if len(hashes) != 0 {
+		return f(ts, hashes, values)
+	}
+	return nil
+}
+
+// collectionHashes returns the digests of the collection with the provided
+// timestamp in ascending order.
+func (l *LevelDB) collectionHashes(ts int64) ([]*[sha256.Size]byte, error) {
+	prefix := tsKey(collectionPrefix, ts)
+	hashes := make([]*[sha256.Size]byte, 0, 4096)
+	i := l.db.NewIterator(util.BytesPrefix(prefix), nil)
+	defer i.Release()
+	for i.Next() {
+		key := i.Key()[len(prefix):]
+		if len(key) != sha256.Size {
+			return nil, errInvalidDB
+		}
+		var digest [sha256.Size]byte
+		copy(digest[:], key)
+		hashes = append(hashes, &digest)
+	}
+	return hashes, i.Error()
+}
+
+// countCollection returns the number of digests in the collection with the
+// provided timestamp.
+func (l *LevelDB) countCollection(ts int64) (int64, error) {
+	var count int64
+	i := l.db.NewIterator(util.BytesPrefix(tsKey(collectionPrefix, ts)),
+		nil)
+	defer i.Release()
+	for i.Next() {
+		count++
+	}
+	return count, i.Error()
+}
+
+// flushedHashes returns the digests that the merkle root of the provided flush
+// record was calculated from.  Flushes only record them when they were
+// restored or replicated, the digests of the collection are used otherwise.
+func (l *LevelDB) flushedHashes(ts int64, fr *backend.FlushRecord) ([]*[sha256.Size]byte, error) {
+	if fr.Hashes != nil {
+		return fr.Hashes, nil
+	}
+	return l.collectionHashes(ts)
+}
+
+// flushedDigests returns the number of digests that the merkle root of the
+// provided flush record was calculated from.
+func (l *LevelDB) flushedDigests(ts int64, fr *backend.FlushRecord) (int, error) {
+	if fr.Hashes != nil {
+		return len(derefHashes(fr.Hashes)), nil
+	}
+	count, err := l.countCollection(ts)
+	return int(count), err
+}
+
+// anchor is the anchor of a flushed collection as it is returned with the
+// digests of the collection.
+type anchor struct {
+	fr            *backend.FlushRecord
+	hashes        []*[sha256.Size]byte // Merkle tree leaves
+	confirmations *int32               // Set while it lacks confirmations
+}
+
+// loadAnchor returns the anchor of the collection with the provided timestamp
+// or nil if it was not flushed yet.  Anchors without a chain timestamp are
+// looked up, see confirm.
+func (l *LevelDB) loadAnchor(ts int64) (*anchor, error) {
+	fr, err := l.flushRecord(ts)
+	if errors.Is(err, leveldb.ErrNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	hashes, err := l.flushedHashes(ts, fr)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &anchor{
+		fr:     fr,
+		hashes: hashes,
+	}
+	if fr.ChainTimestamp == 0 {
+		res, err := l.confirm(ts, fr)
+		switch {
+		case errors.Is(err, errNotEnoughConfirmation):
+			a.confirmations = &res.Confirmations
+
+		case errors.Is(err, errInvalidConfirmations):
+			log.Errorf("%v: Confirmations = -1", fr.Tx.String())
+			return nil, err
+
+		case err != nil:
+			return nil, err
+		}
+	}
+
+	return a, nil
+}
+
+// getDigests returns a GetResult for each provided digest.  The provided
+// digest values are used instead of looking the digests up, nil values
+// are looked up.
+func (l *LevelDB) getDigests(digests [][sha256.Size]byte, values [][]byte) ([]backend.GetResult, error) {
+	gdmes := make([]backend.GetResult, 0, len(digests))
+	anchors := make(map[int64]*anchor)
+	for k, digest := range digests {
+		gdme := backend.GetResult{
+			Digest:    digest,
+			ErrorCode: backend.ErrorNotFound,
+		}
+
+		var value []byte
+		if values != nil {
+			value = values[k]
+		}
+		if value == nil {
+			var err error
+			value, err = l.db.Get(digestKey(digest[:]), nil)
+			if errors.Is(err, leveldb.ErrNotFound) {
+				gdmes = append(gdmes, gdme)
+				continue
+			} else if err != nil {
+				return nil, err
+			}
+		}
+
+		ts := digestTimestamp(value)
+		gdme.ErrorCode = backend.ErrorOK
+		gdme.Timestamp = ts
+		gdme.Label = digestLabel(value)
+		gdme.Algorithm = digestAlgorithm(value)
+
+		a, ok := anchors[ts]
+		if !ok {
+			var err error
+			a, err = l.loadAnchor(ts)
+			if err != nil {
+				return nil, err
+			}
+			anchors[ts] = a
+		}
+		if a == nil {
+			// Not anchored yet.
+			gdmes = append(gdmes, gdme)
+			continue
+		}
+
+		gdme.AnchoredTimestamp = a.fr.ChainTimestamp
+		gdme.Tx = a.fr.Tx
+		gdme.AnchorPrefix = a.fr.AnchorPrefix
+		gdme.Attestations = a.fr.Attestations
+		gdme.MerkleRoot = a.fr.Root
+		gdme.ReorgTimestamp = a.fr.Reorged
+		gdme.FlushTimestamp = a.fr.FlushTimestamp
+		if mb := merkle.AuthPath(a.hashes, &digest); mb != nil {
+			gdme.MerklePath = *mb
+		}
+		if a.confirmations != nil {
+			gdme.Confirmations = a.confirmations
+			gdme.MinConfirmations = l.confirmations
+		}
+		if gdme.AnchoredTimestamp != 0 {
+			gdme.BlockHeight = a.fr.BlockHeight
+		}
+		gdmes = append(gdmes, gdme)
+	}
+
+	return gdmes, nil
+}
+
+// Get returns a GetResult for each provided digest.  Reads do not take a lock,
+// leveldb provides consistent reads and a digest is never moved once it was
+// stored.
+//
+// Get satisfies the backend interface.
+func (l *LevelDB) Get(digests [][sha256.Size]byte) ([]backend.GetResult, error) {
+	return l.getDigests(digests, nil)
+}
+
+// GetLabel returns a GetResult for each digest that was stored under the
+// provided group label.  Labels are indexed, so only the digests of the label
+// are visited.
+//
+// GetLabel satisfies the backend interface.
+func (l *LevelDB) GetLabel(label string) ([]backend.GetResult, error) {
+	digests := make([][sha256.Size]byte, 0, 64)
+
+	prefix := append([]byte(labelPrefix+label), 0)
+	i := l.db.NewIterator(util.BytesPrefix(prefix), nil)
+	defer i.Release()
+	for i.Next() {
+		key := i.Key()[len(prefix):]
+		if len(key) != 8+sha256.Size {
+			return nil, errInvalidDB
+		}
+		var digest [sha256.Size]byte
+		copy(digest[:], key[8:])
+		digests = append(digests, digest)
+	}
+	if err := i.Error(); err != nil {
+		return nil, err
+	}
+
+	return l.getDigests(digests, nil)
+}
+
+// getTimestamp returns all digests of the collection with the provided
+// timestamp.
+func (l *LevelDB) getTimestamp(ts int64) (backend.TimestampResult, error) {
+	gtme := backend.TimestampResult{
+		Timestamp: ts,
+		ErrorCode: backend.ErrorNotFound,
+	}
+
+	a, err := l.loadAnchor(ts)
+	if err != nil {
+		return gtme, err
+	}
+	if a != nil {
+		gtme.ErrorCode = backend.ErrorOK
+		gtme.Tx = a.fr.Tx
+		gtme.AnchorPrefix = a.fr.AnchorPrefix
+		gtme.Attestations = a.fr.Attestations
+		gtme.MerkleRoot = a.fr.Root
+		gtme.ReorgTimestamp = a.fr.Reorged
+		gtme.Digests = derefHashes(a.hashes)
+		if a.confirmations != nil {
+			gtme.Confirmations = a.confirmations
+			gtme.MinConfirmations = l.confirmations
+		}
+		gtme.AnchoredTimestamp = a.fr.ChainTimestamp
+		gtme.FlushTimestamp = a.fr.FlushTimestamp
+		return gtme, nil
+	}
+
+	hashes, err := l.collectionHashes(ts)
+	if err != nil {
+		return gtme, err
+	}
+	if len(hashes) != 0 {
+		gtme.ErrorCode = backend.ErrorOK
+		gtme.Digests = derefHashes(hashes)
+	}
+
+	return gtme, nil
+}
+
+// GetTimestamps is a required interface function.  In our case it retrieves
+// the digests for a given timestamp.
+//
+// GetTimestamps satisfies the backend interface.
+func (l *LevelDB) GetTimestamps(timestamps []int64) ([]backend.TimestampResult, error) {
+	gtmes := make([]backend.TimestampResult, 0, len(timestamps))
+	for _, ts := range timestamps {
+		if !l.enableCollections {
+			gtmes = append(gtmes, backend.TimestampResult{
+				Timestamp: ts,
+				ErrorCode: backend.ErrorNotAllowed,
+			})
+			continue
+		}
+		gtme, err := l.getTimestamp(ts)
+		if err != nil {
+			return nil, err
+		}
+		gtmes = append(gtmes, gtme)
+	}
+
+	return gtmes, nil
+}
+
+// LastDigests returns the last n digests that were stored, newest collection
+// first.
+//
+// LastDigests satisfies the backend interface.
+func (l *LevelDB) LastDigests(n int32) ([]backend.GetResult, error) {
+	if n > l.maxDigests {
+		return nil, fmt.Errorf("invalid number %d of digests requested. Max is: %d", n, l.maxDigests)
+	}
+	if !l.enableCollections || n <= 0 {
+		return []backend.GetResult{}, nil
+	}
+
+	digests := make([][sha256.Size]byte, 0, n)
+	values := make([][]byte, 0, n)
+	i := l.db.NewIterator(util.BytesPrefix([]byte(collectionPrefix)), nil)
+	defer i.Release()
+	for ok := i.Last(); ok && len(digests) < int(n); ok = i.Prev() {
+		key := i.Key()[len(collectionPrefix):]
+		if len(key) != 8+sha256.Size {
+			return nil, errInvalidDB
+		}
+		var digest [sha256.Size]byte
+		copy(digest[:], key[8:])
+		digests = append(digests, digest)
+		values = append(values, append([]byte(nil), i.Value()...))
+	}
+	if err := i.Error(); err != nil {
+		return nil, err
+	}
+
+	return l.getDigests(digests, values)
+}
+
+// Put is a required interface function.  In our case it stores the provided
+// hashes in the collection of the current time in UTC rounded down to the last
+// hour.  The digests and their index entries are written in a single synced
+// batch.  The optional group label and digest algorithm are stored alongside
+// the collection timestamp of every accepted hash.
+//
+// Put satisfies the backend interface.
+func (l *LevelDB) Put(hashes [][sha256.Size]byte, label, algorithm string) (int64, []backend.PutResult, error) {
+	if l.readOnly {
+		return 0, nil, backend.ErrReadOnly
+	}
+
+	// Keep the flusher from listing closed collections until the digests
+	// are written.
+	l.putMtx.RLock()
+	defer l.putMtx.RUnlock()
+
+	me := make([]backend.PutResult, 0, len(hashes))
+	batch := new(leveldb.Batch)
+	accepted := make(map[[sha256.Size]byte]struct{}, len(hashes))
+
+	// Digests are looked up and reserved atomically so that concurrent
+	// submissions of the same digest are only accepted once.  The batch
+	// is written without the lock so that concurrent submissions share
+	// the fsync of the journal.
+	l.Lock()
+	ts := l.now().Unix()
+	for _, hash := range hashes {
+		// Duplicates in the same batch are ignored.
+		if _, ok := accepted[hash]; ok {
+			me = append(me, backend.PutResult{
+				Digest:    hash,
+				ErrorCode: backend.ErrorOK,
+			})
+			continue
+		}
+
+		_, found := l.inflight[hash]
+		if !found {
+			var err error
+			found, err = l.db.Has(digestKey(hash[:]), nil)
+			if err != nil {
+				l.release(accepted)
+				l.Unlock()
+				return 0, []backend.PutResult{}, err
+			}
+		}
+		if found {
+			me = append(me, backend.PutResult{
+				Digest:    hash,
+				ErrorCode: backend.ErrorExists,
+			})
+			continue
+		}
+
+		putDigest(batch, ts, hash[:], label, algorithm)
+		accepted[hash] = struct{}{}
+		l.inflight[hash] = struct{}{}
+		me = append(me, backend.PutResult{
+			Digest:    hash,
+			ErrorCode: backend.ErrorOK,
+		})
+	}
+
+	// Digests are only queued up to a limit in maintenance mode.
+	queued := int64(len(accepted))
+	if l.maintenance && l.queued+queued > l.maxQueued {
+		l.release(accepted)
+		l.Unlock()
+		return 0, []backend.PutResult{}, backend.ErrQueueFull
+	}
+	maintenance := l.maintenance
+	if maintenance {
+		l.queued += queued
+	}
+	l.Unlock()
+
+	if queued == 0 {
+		return ts, me, nil
+	}
+
+	// The batch is written atomically and synced so that accepted
+	// digests survive a crash.
+	batch.Put(tsKey(pendingPrefix, ts), nil)
+	err := l.db.Write(batch, syncWrite)
+
+	l.Lock()
+	defer l.Unlock()
+	l.release(accepted)
+	if err != nil {
+		if maintenance && l.maintenance {
+			l.queued -= queued
+		}
+		return 0, []backend.PutResult{}, err
+	}
+	l.pending[ts] += queued
+
+	return ts, me, nil
+}
+
+// release removes the provided digests from the digests that are being
+// stored.
+//
+// This function must be called with the WRITE lock held.
+func (l *LevelDB) release(digests map[[sha256.Size]byte]struct{}) {
+	for digest := range digests {
+		delete(l.inflight, digest)
+	}
+}
+
+// Close is a required interface function.  In our case we close the database.
+//
+// Close satisfies the backend interface.
+func (l *LevelDB) Close() {
+	// Block notifications would wait for the lock.
+	if l.blocksCancel != nil {
+		l.blocksCancel()
+	}
+
+	// Block until last command and flush are complete.
+	l.flushMtx.Lock()
+	defer l.flushMtx.Unlock()
+	l.putMtx.Lock()
+	defer l.putMtx.Unlock()
+	defer log.Infof("Exiting")
+
+	// We need nil tests when in dump/restore mode.
+	if l.cron != nil {
+		l.cron.Stop()
+	}
+	if l.wallet != nil {
+		l.wallet.Close()
+	}
+	l.db.Close()
+}
+
+// PreviewWindow returns the would-be merkle root of the pending collection
+// that digests are currently stored in.  Group labels do not change the
+// collection.
+//
+// PreviewWindow satisfies the backend interface.
+func (l *LevelDB) PreviewWindow(label string) (*backend.WindowResult, error) {
+	ts := l.now()
+	wr := &backend.WindowResult{
+		Timestamp: ts.Unix(),
+		Ends:      ts.Add(l.duration).Unix(),
+	}
+
+	hashes, err := l.collectionHashes(wr.Timestamp)
+	if err != nil {
+		return nil, err
+	}
+	if len(hashes) != 0 {
+		wr.Digests = len(hashes)
+		wr.MerkleRoot = *merkle.Root(hashes)
+	}
+
+	return wr, nil
+}
+
+// WindowEnd returns the time at which the collection with the provided
+// timestamp is flushed.  This call satisfies the backend interface.
+func (l *LevelDB) WindowEnd(ts int64) (int64, int32, error) {
+	return time.Unix(ts, 0).Add(l.duration).Unix(), 0, nil
+}
+
+// lastFlush returns the timestamp and the flush record of the last flushed
+// collection.  The flush record is nil if nothing was flushed yet.
+func (l *LevelDB) lastFlush() (int64, *backend.FlushRecord, error) {
+	i := l.db.NewIterator(util.BytesPrefix([]byte(flushPrefix)), nil)
+	defer i.Release()
+	if !i.Last() {
+		return 0, nil, i.Error()
+	}
+	key := i.Key()[len(flushPrefix):]
+	if len(key) != 8 {
+		return 0, nil, errInvalidDB
+	}
+	var fr backend.FlushRecord
+	if err := json.Unmarshal(i.Value(), &fr); err != nil {
+		return 0, nil, err
+	}
+	return int64(binary.BigEndian.Uint64(key)), &fr, nil
+}
+
+// LastAnchor provides the info of last successful anchor such as timestamp,
+// tx id and block hash.
+//
+// LastAnchor satisfies the backend interface.
+func (l *LevelDB) LastAnchor() (*backend.LastAnchorResult, error) {
+	ts, fr, err := l.lastFlush()
+	if err != nil {
+		return nil, err
+	}
+	if fr == nil {
+		return &backend.LastAnchorResult{}, nil
+	}
+	me := backend.LastAnchorResult{
+		Tx: fr.Tx,
+	}
+
+	// Lookup anchored tx info, and update db if info changed.
+	txWalletInfo, err := l.confirm(ts, fr)
+
+	// If no error, or no enough confirmations
+	// err continue, else return err.
+	if err != nil && !errors.Is(err, errNotEnoughConfirmation) {
+		return &backend.LastAnchorResult{}, err
+	}
+	me.ChainTimestamp = fr.ChainTimestamp
+	me.BlockHash = txWalletInfo.BlockHash.String()
+	me.BlockHeight = txWalletInfo.BlockHeight
+	return &me, nil
+}
+
+// GetBalance provides the balance of the wallet and satisfies the
+// backend interface.
+func (l *LevelDB) GetBalance() (*backend.GetBalanceResult, error) {
+	result, err := l.wallet.GetWalletBalance()
+	if err != nil {
+		return nil, err
+	}
+	return &backend.GetBalanceResult{
+		Total:       result.Total,
+		Spendable:   result.Spendable,
+		Unconfirmed: result.Unconfirmed,
+	}, nil
+}
+
+// openDB opens the database in the provided directory.  Digests are looked up
+// by key on every submission, the bloom filter saves the disk reads of digests
+// that do not exist.  A filesystem backend in the same directory is refused so
+// that it is not mistaken for an empty database.
+func openDB(root string) (*leveldb.DB, error) {
+	if _, err := os.Stat(filepath.Join(root, "global")); err == nil {
+		return nil, fmt.Errorf("%v contains a filesystem backend, "+
+			"migrate it with dcrtime_dumpdb", root)
+	}
+
+	db, err := leveldb.OpenFile(root, &opt.Options{
+		Filter: filter.NewBloomFilter(10),
+	})
+	if errors.Is(err, errWouldBlock) {
+		return nil, fmt.Errorf("%w: %v", ErrLocked, root)
+	}
+	return db, err
+}
+
+// loadPending rolls back the flushes that were interrupted and returns the
+// number of digests of every collection that was not flushed yet.
+func (l *LevelDB) loadPending() (map[int64]int64, error) {
+	err := l.rollbackFlushes()
+	if err != nil {
+		return nil, err
+	}
+
+	pending := make(map[int64]int64)
+	i := l.db.NewIterator(util.BytesPrefix([]byte(pendingPrefix)), nil)
+	defer i.Release()
+	for i.Next() {
+		key := i.Key()[len(pendingPrefix):]
+		if len(key) != 8 {
+			return nil, errInvalidDB
+		}
+		ts := int64(binary.BigEndian.Uint64(key))
+		count, err := l.countCollection(ts)
+		if err != nil {
+			return nil, err
+		}
+		pending[ts] = count
+	}
+
+	return pending, i.Error()
+}
+
+// internalNew creates the LevelDB context but does not launch background
+// bits.  This is used by the test packages.
+func internalNew(root string) (*LevelDB, error) {
+	db, err := openDB(root)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &LevelDB{
+		inflight: make(map[[sha256.Size]byte]struct{}),
+		root:     root,
+		db:       db,
+		duration: duration,
+		myNow:    time.Now,
+	}
+	l.Tokens = sidestore.NewTokens(db, tokenPrefix)
+	l.Webhooks = sidestore.NewWebhooks(db, webhookPrefix)
+	l.Owners = sidestore.NewOwners(db, ownerPrefix, l.anchored,
+		l.GetMetadata)
+	l.Sessions = sidestore.NewSessions(db, sessionPrefix,
+		func() time.Time { return l.myNow() },
+		func() bool { return l.readOnly }, l.Put)
+	l.pending, err = l.loadPending()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// unsupported returns an error if the provided configuration sets options
+// that only the filesystem backend supports.
+func unsupported(cfg backend.Config) error {
+	var options []string
+	if len(cfg.FastAnchors) != 0 {
+		options = append(options, "fastanchors")
+	}
+	if cfg.AnchorRetry != 0 {
+		options = append(options, "anchorretry")
+	}
+	if cfg.AnchorBlocks != 0 {
+		options = append(options, "anchorblocks")
+	}
+	if cfg.ConfirmRefresh != 0 {
+		options = append(options, "confirmrefresh")
+	}
+	if cfg.LowBalance != 0 || cfg.PauseLowBalance {
+		options = append(options, "lowbalance")
+	}
+	if len(options) == 0 {
+		return nil
+	}
+	sort.Strings(options)
+	return fmt.Errorf("not supported by the %v backend: %v", DriverName,
+		options)
+}
+
+// New creates a new backend instance in cfg.DataDir that anchors through
+// cfg.Wallet.  The merkle root of every flush is also attested by the
+// secondary anchorers.  A read-only backend refuses digests and never
+// flushes, confirmations of existing anchors are still recorded.  A backend
+// in maintenance mode queues up to MaintenanceQueue pending digests instead
+// and flushes them once maintenance ends.  The merkle roots of flushed
+// collections are calculated on up to FlushWorkers goroutines.  Digest
+// metadata is encrypted at rest with EncryptionKey unless it is nil.  Anchors
+// that lack confirmations are watched for reorganizations and replaced when
+// they were dropped from the chain.  Fast anchors, replacements of anchors
+// that were not mined in time, block windows, confirmation refreshes and
+// balance monitoring are not supported.  The caller should issue a Close once
+// the LevelDB backend is no longer needed.  The wallet is closed by Close.
+func New(cfg backend.Config) (*LevelDB, error) {
+	if err := unsupported(cfg); err != nil {
+		return nil, err
+	}
+	if len(cfg.AnchorPrefix) > dcrtimewallet.MaxAnchorPrefixSize {
+		return nil, fmt.Errorf("anchor prefix too long: %v > %v",
+			len(cfg.AnchorPrefix), dcrtimewallet.MaxAnchorPrefixSize)
+	}
+	if cfg.FlushWorkers < 1 {
+		return nil, fmt.Errorf("invalid flush workers: %v",
+			cfg.FlushWorkers)
+	}
+	aead, err := newAEAD(cfg.EncryptionKey)
+	if err != nil {
+		return nil, err
+	}
+
+	l, err := internalNew(cfg.DataDir)
+	if err != nil {
+		return nil, err
+	}
+	l.cron = cron.New()
+	l.enableCollections = cfg.EnableCollections
+	l.confirmations = cfg.Confirmations
+	l.maxDigests = cfg.MaxDigests
+	l.anchorPrefix = cfg.AnchorPrefix
+	l.readOnly = cfg.ReadOnly
+	l.maxQueued = cfg.MaintenanceQueue
+	l.flushWorkers = cfg.FlushWorkers
+	l.aead = aead
+
+	// Runtime bits
+	l.wallet = cfg.Wallet
+	l.anchorers = cfg.Anchorers
+
+	// Nothing is stored, flushed or anchored.
+	if l.readOnly {
+		log.Infof("Read-only: digests are refused and not flushed")
+		return l, nil
+	}
+
+	// Flushing backend reconciles uncommitted work unless it is queued
+	// until maintenance ends.
+	if cfg.Maintenance {
+		err = l.SetMaintenance(true)
+		if err != nil {
+			l.db.Close()
+			return nil, err
+		}
+	} else {
+		l.flushMtx.Lock()
+		start := time.Now()
+		flushed := l.flush()
+		end := time.Since(start)
+		l.flushMtx.Unlock()
+
+		if flushed != 0 {
+			log.Infof("Startup flusher: collections %v in %v",
+				flushed, end)
+		}
+	}
+
+	// Launch cron.
+	err = l.cron.AddFunc(flushSchedule, func() {
+		l.flusher()
+	})
+	if err != nil {
+		l.db.Close()
+		return nil, err
+	}
+
+	// Watch the anchors that lack confirmations for reorganizations.
+	err = l.cron.AddFunc(reorgSchedule, func() {
+		l.reorgWatcher()
+	})
+	if err != nil {
+		l.db.Close()
+		return nil, err
+	}
+	l.cron.Start()
+
+	// Block notifications replace the polling above while the wallet
+	// delivers them.
+	ctx, cancel := context.WithCancel(context.Background())
+	l.blocksCancel = cancel
+	go l.blockSubscriber(ctx)
+
+	l.healthMtx.Lock()
+	l.lastFlusher = time.Now()
+	l.flusherInterval = l.duration
+	l.healthMtx.Unlock()
+
+	return l, nil
+}
@@ -0,0 +1,271 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package leveldb
+
+import (
+	"crypto/sha256"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/decred/dcrd/chaincfg/chainhash"
+	"github.com/decred/dcrtime/dcrtimed/backend"
+	"github.com/decred/dcrtime/dcrtimed/backend/filesystem"
+	"github.com/decred/dcrtime/dcrtimed/backend/testsuite"
+)
+
+func TestLock(t *testing.T) {
+	dir := t.TempDir()
+
+	l, err := internalNew(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A second instance is refused while the first one runs.
+	_, err = internalNew(dir)
+	if !errors.Is(err, ErrLocked) {
+		t.Fatalf("got %v want %v", err, ErrLocked)
+	}
+	_, err = NewDump(dir)
+	if !errors.Is(err, ErrLocked) {
+		t.Fatalf("dump: got %v want %v", err, ErrLocked)
+	}
+
+	l.Close()
+	l, err = internalNew(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+}
+
+func TestUnsupported(t *testing.T) {
+	_, err := New(backend.Config{
+		DataDir:      t.TempDir(),
+		AnchorBlocks: 6,
+	})
+	if err == nil {
+		t.Fatal("expected unsupported option to be refused")
+	}
+}
+
+func TestRollbackFlush(t *testing.T) {
+	dir := t.TempDir()
+
+	l, err := internalNew(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.wallet = testsuite.NewWallet()
+	timestamp := l.now().Unix()
+	l.myNow = func() time.Time {
+		return time.Unix(timestamp, 0)
+	}
+
+	hashes := make([][sha256.Size]byte, 0, 4)
+	for i := 0; i < 4; i++ {
+		hashes = append(hashes, sha256.Sum256([]byte{byte(i)}))
+	}
+	_, _, err = l.Put(hashes, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A flush that was interrupted before it was recorded is rolled back
+	// and the collection remains pending.
+	err = l.db.Put(tsKey(flushingPrefix, timestamp), []byte("{}"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+
+	l, err = internalNew(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if l.pending[timestamp] != int64(len(hashes)) {
+		t.Fatalf("got %v pending digests want %v",
+			l.pending[timestamp], len(hashes))
+	}
+	ok, err := l.db.Has(tsKey(flushingPrefix, timestamp), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Fatal("interrupted flush was not rolled back")
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	fsDir := t.TempDir()
+	fs, err := filesystem.New(backend.Config{
+		DataDir:      fsDir,
+		Wallet:       testsuite.NewWallet(),
+		FlushWorkers: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hashes := make([][sha256.Size]byte, 0, 4)
+	for i := 0; i < 4; i++ {
+		hashes = append(hashes, sha256.Sum256([]byte{byte(i)}))
+	}
+	ts, _, err := fs.Put(hashes, "migrate", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dump, err := os.Create(filepath.Join(t.TempDir(), "dump.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dump.Close()
+	err = fs.Dump(dump, false)
+	fs.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A filesystem backend is refused, it must be restored.
+	_, err = internalNew(fsDir)
+	if err == nil {
+		t.Fatal("expected filesystem backend to be refused")
+	}
+
+	dir := t.TempDir()
+	l, err := NewRestore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = dump.Seek(0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = l.Restore(dump, false, "")
+	l.Close()
+	if err != nil && !errors.Is(err, io.EOF) {
+		t.Fatal(err)
+	}
+
+	l, err = internalNew(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if l.pending[ts] != int64(len(hashes)) {
+		t.Fatalf("got %v pending digests want %v", l.pending[ts],
+			len(hashes))
+	}
+	grs, err := l.GetLabel("migrate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(grs) != len(hashes) {
+		t.Fatalf("got %v labeled digests want %v", len(grs),
+			len(hashes))
+	}
+	for _, gr := range grs {
+		if gr.Timestamp != ts {
+			t.Fatalf("got timestamp %v want %v", gr.Timestamp, ts)
+		}
+	}
+}
+
+func TestReorg(t *testing.T) {
+	l, err := internalNew(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	wallet := testsuite.NewWallet()
+	l.wallet = wallet
+	l.confirmations = 2
+
+	// Return our artificial timestamp
+	timestamp := l.now().Unix()
+	l.myNow = func() time.Time {
+		return time.Unix(timestamp, 0)
+	}
+
+	digest := [sha256.Size]byte{0x01}
+	_, _, err = l.Put([][sha256.Size]byte{digest}, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := l.now().Unix()
+	timestamp = time.Unix(timestamp, 0).Add(l.duration).Unix()
+	l.flushMtx.Lock()
+	l.flush()
+	l.flushMtx.Unlock()
+
+	check := func(want int) *backend.FlushRecord {
+		t.Helper()
+		count, err := l.checkReorgs()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != want {
+			t.Fatalf("got %v reorganized anchors, want %v", count,
+				want)
+		}
+		fr, err := l.flushRecord(ts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fr
+	}
+
+	// Mined anchors are not reorganized.
+	wallet.SetConfirmations(1)
+	fr := check(0)
+	if fr.MinedBlock == (chainhash.Hash{}) || fr.Reorged != 0 {
+		t.Fatalf("unexpected flush record %+v", fr)
+	}
+
+	// An anchor that returned to the mempool is marked reorganized.
+	wallet.SetConfirmations(0)
+	fr = check(1)
+	if fr.MinedBlock != (chainhash.Hash{}) || fr.Reorged != timestamp {
+		t.Fatalf("unexpected flush record %+v", fr)
+	}
+	grs, err := l.Get([][sha256.Size]byte{digest})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if grs[0].ReorgTimestamp != timestamp {
+		t.Fatalf("got reorg timestamp %v, want %v",
+			grs[0].ReorgTimestamp, timestamp)
+	}
+	sr, err := l.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sr.LastReorg != timestamp {
+		t.Fatalf("got last reorg %v, want %v", sr.LastReorg, timestamp)
+	}
+
+	// An anchor that was dropped is replaced.
+	wallet.SetConfirmations(1)
+	fr = check(0)
+	tx := fr.Tx
+	wallet.Drop(tx)
+	fr = check(1)
+	if wallet.Replacements() != 1 || fr.Tx == tx ||
+		len(fr.Replaced) != 1 || fr.Replaced[0] != tx {
+		t.Fatalf("unexpected flush record %+v", fr)
+	}
+
+	// The replacement is mined.
+	fr = check(0)
+	if fr.MinedBlock == (chainhash.Hash{}) {
+		t.Fatalf("unexpected flush record %+v", fr)
+	}
+}
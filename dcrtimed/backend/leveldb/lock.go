@@ -0,0 +1,13 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package leveldb
+
+import "errors"
+
+// ErrLocked is returned when the data directory is in use by another process,
+// most likely a second dcrtimed that was started against the same data
+// directory.  leveldb locks the database itself, there is no separate lock
+// file.
+var ErrLocked = errors.New("data directory is in use by another process")
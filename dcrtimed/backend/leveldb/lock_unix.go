@@ -0,0 +1,14 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+//go:build !windows
+// +build !windows
+
+package leveldb
+
+import "syscall"
+
+// errWouldBlock is returned by leveldb when another process holds the flock of
+// the database.
+var errWouldBlock error = syscall.EWOULDBLOCK
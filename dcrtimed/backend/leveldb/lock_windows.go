@@ -0,0 +1,12 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package leveldb
+
+import "syscall"
+
+// errWouldBlock is returned by leveldb when another process has the lock file
+// of the database open.  It is ERROR_SHARING_VIOLATION, which the syscall
+// package does not define.
+var errWouldBlock error = syscall.Errno(32)
@@ -0,0 +1,82 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package leveldb
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+
+	"github.com/decred/dcrtime/dcrtimed/backend"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// metadataPrefix prefixes the keys of digest metadata, which are followed by
+// the digest.
+const metadataPrefix = "metadata/"
+
+// metadataKey returns the key of the metadata of the provided digest.
+func metadataKey(digest []byte) []byte {
+	return append([]byte(metadataPrefix), digest...)
+}
+
+// PutMetadata stores the metadata of digests.  The metadata of a digest that
+// already has metadata is not replaced.  This call satisfies the backend
+// interface.
+func (l *LevelDB) PutMetadata(mds []backend.Metadata) error {
+	if len(mds) == 0 {
+		return nil
+	}
+
+	l.metadataMtx.Lock()
+	defer l.metadataMtx.Unlock()
+
+	batch := new(leveldb.Batch)
+	for _, md := range mds {
+		key := metadataKey(md.Digest[:])
+		found, err := l.db.Has(key, nil)
+		if err != nil {
+			return err
+		}
+		if found {
+			continue
+		}
+		payload, err := json.Marshal(md)
+		if err != nil {
+			return err
+		}
+		payload, err = l.seal(md.Digest[:], payload)
+		if err != nil {
+			return err
+		}
+		batch.Put(key, payload)
+	}
+	return l.db.Write(batch, nil)
+}
+
+// GetMetadata returns the metadata of the provided digests in their order,
+// nil for digests without metadata.  This call satisfies the backend
+// interface.
+func (l *LevelDB) GetMetadata(digests [][sha256.Size]byte) ([]*backend.Metadata, error) {
+	mds := make([]*backend.Metadata, 0, len(digests))
+	for _, digest := range digests {
+		payload, err := l.db.Get(metadataKey(digest[:]), nil)
+		if err == leveldb.ErrNotFound {
+			mds = append(mds, nil)
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		payload, err = l.unseal(digest[:], payload)
+		if err != nil {
+			return nil, err
+		}
+		var md backend.Metadata
+		if err := json.Unmarshal(payload, &md); err != nil {
+			return nil, err
+		}
+		mds = append(mds, &md)
+	}
+	return mds, nil
+}
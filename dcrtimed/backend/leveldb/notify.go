@@ -0,0 +1,62 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package leveldb
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/decred/dcrtime/dcrtimed/dcrtimewallet"
+)
+
+// blockRetry is the time after which a failed block subscription is retried.
+const blockRetry = 30 * time.Second
+
+// subscribed returns true while the wallet notifies blocks.  The periodic
+// reorg watcher is skipped then.
+func (l *LevelDB) subscribed() bool {
+	return atomic.LoadInt32(&l.blocks) != 0
+}
+
+// blockConnected is called for every block notification.  The anchors are
+// checked for reorganizations right away.  A reorganization may connect a
+// block at the height of the last one, so the check is forced.
+func (l *LevelDB) blockConnected(height int32) {
+	if atomic.CompareAndSwapInt32(&l.blocks, 0, 1) {
+		log.Infof("Block notifications: subscribed at height %v",
+			height)
+	}
+	log.Debugf("blockConnected: height %v", height)
+
+	l.watchReorgs(height, true)
+}
+
+// blockSubscriber subscribes to the block notifications of the wallet until
+// the provided context is canceled.  Failed subscriptions are retried, the
+// periodic reorg watcher takes over in the meantime.  Wallets that
+// can not notify blocks are polled.
+func (l *LevelDB) blockSubscriber(ctx context.Context) {
+	for {
+		err := l.wallet.NotifyBlocks(ctx, l.blockConnected)
+		atomic.StoreInt32(&l.blocks, 0)
+		if ctx.Err() != nil {
+			return
+		}
+		if errors.Is(err, dcrtimewallet.ErrNoNotifications) {
+			log.Infof("Block notifications: %v, polling", err)
+			return
+		}
+		log.Errorf("Block notifications: %v, retry in %v", err,
+			blockRetry)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(blockRetry):
+		}
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package leveldb
+
+import (
+	"github.com/decred/dcrtime/dcrtimed/backend"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// anchored returns true if the collection with the provided timestamp was
+// anchored.
+func (l *LevelDB) anchored(ts int64) bool {
+	_, err := l.flushRecord(ts)
+	return err == nil
+}
+
+// DeleteCollection deletes a collection that was not anchored yet together
+// with its digests.  All digests of the collection must belong to the owner.
+// This call satisfies the backend interface.
+func (l *LevelDB) DeleteCollection(owner string, ts int64) error {
+	// Block timestamping and flushing while the collection is verified and
+	// removed.
+	l.flushMtx.Lock()
+	defer l.flushMtx.Unlock()
+	l.putMtx.Lock()
+	defer l.putMtx.Unlock()
+
+	found, err := l.OwnsCollection(owner, ts)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return backend.ErrCollectionNotFound
+	}
+
+	_, err = l.flushRecord(ts)
+	if err == nil {
+		return backend.ErrCollectionAnchored
+	} else if err != leveldb.ErrNotFound {
+		return err
+	}
+
+	// Remove the digests of the collection together with their index
+	// entries.  Nothing is left to remove if it was restored without
+	// them.
+	batch := new(leveldb.Batch)
+	prefix := tsKey(collectionPrefix, ts)
+	i := l.db.NewIterator(util.BytesPrefix(prefix), nil)
+	defer i.Release()
+	removed := 0
+	for i.Next() {
+		digest := i.Key()[len(prefix):]
+		owned, err := l.OwnsDigest(owner, ts, digest)
+		if err != nil {
+			return err
+		}
+		if !owned {
+			return backend.ErrCollectionShared
+		}
+		batch.Delete(append([]byte{}, i.Key()...))
+		batch.Delete(digestKey(digest))
+		if label := digestLabel(i.Value()); label != "" {
+			batch.Delete(labelKey(label, ts, digest))
+		}
+		removed++
+	}
+	if err := i.Error(); err != nil {
+		return err
+	}
+	if removed != 0 {
+		batch.Delete(tsKey(pendingPrefix, ts))
+	}
+
+	// Forget the ownership and metadata of the removed digests.
+	digests, err := l.DeleteOwned(batch, owner, ts)
+	if err != nil {
+		return err
+	}
+	for _, digest := range digests {
+		batch.Delete(metadataKey(digest))
+	}
+
+	l.metadataMtx.Lock()
+	err = l.db.Write(batch, syncWrite)
+	l.metadataMtx.Unlock()
+	if err != nil {
+		return err
+	}
+
+	l.Lock()
+	delete(l.pending, ts)
+	l.Unlock()
+	if removed != 0 {
+		log.Infof("Deleted collection %v of %v", ts2dirname(ts), owner)
+	}
+
+	return nil
+}
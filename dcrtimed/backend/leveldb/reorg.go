@@ -0,0 +1,210 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package leveldb
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/decred/dcrd/chaincfg/chainhash"
+	"github.com/decred/dcrtime/dcrtimed/backend"
+	"github.com/decred/dcrtime/dcrtimed/dcrtimewallet"
+)
+
+const (
+	// reorgSchedule is the schedule of the reorg watcher.  It only looks
+	// up anchors when the best block height changed since its last run.
+	//
+	// Seconds Minutes Hours Days Months DayOfWeek
+	reorgSchedule = "40 * * * * *" // Every minute + 40 seconds
+
+	// reorgAge is the age up to which the anchors of collections that
+	// lack confirmations are watched for reorganizations.
+	reorgAge = 24 * time.Hour
+)
+
+// lookupAnchor looks up the anchor of the provided flush record, see
+// backend.LookupAnchor.  The flush record is not written back.
+func (l *LevelDB) lookupAnchor(fr *backend.FlushRecord) (*dcrtimewallet.TxLookupResult, error) {
+	tx := fr.Tx
+	res, err := backend.LookupAnchor(l.wallet, fr)
+	if err == nil && fr.Tx != tx {
+		log.Infof("Replaced anchor %v was mined instead of %v", fr.Tx,
+			tx)
+	}
+	return res, err
+}
+
+// replaceAnchor replaces the anchor of the provided flush record that was
+// dropped from the chain.  It returns true if the flush record was updated.
+//
+// This function must be called with flushMtx held.
+func (l *LevelDB) replaceAnchor(ts int64, fr *backend.FlushRecord) bool {
+	if len(fr.Replaced) >= backend.MaxReplacements {
+		log.Criticalf("Anchor %v of %v was dropped after %v "+
+			"replacements", fr.Tx, ts2dirname(ts), len(fr.Replaced))
+		return false
+	}
+
+	replacement, err := l.wallet.Replace(fr.Root, []byte(fr.AnchorPrefix),
+		backend.FeeBump(len(fr.Replaced)))
+	l.setAnchorError(err)
+	if err != nil {
+		// The flush record stays marked reorganized, so the next
+		// check tries again.
+		if errors.Is(err, dcrtimewallet.ErrFeeTooHigh) {
+			log.Criticalf("Replacement anchor of %v refused: %v",
+				ts2dirname(ts), err)
+		} else {
+			log.Errorf("Replace anchor of %v: %v", ts2dirname(ts),
+				err)
+		}
+		return false
+	}
+	log.Infof("Anchored %v again: %v -> %v", ts2dirname(ts), fr.Tx,
+		replacement)
+
+	fr.Replaced = append(fr.Replaced, fr.Tx)
+	fr.Tx = *replacement
+	return true
+}
+
+// checkReorg looks up the anchor of the provided flush record and records the
+// block it was mined in.  An anchor that is no longer in the block it was seen
+// in was reorganized out of the chain and the flush record is marked
+// reorganized.  An anchor that returned to the mempool is broadcast again by
+// the wallet, an anchor that was dropped is replaced.  It returns whether the
+// anchor was reorganized and whether the flush record was updated.  The flush
+// record is not written back.
+//
+// This function must be called with flushMtx held.
+func (l *LevelDB) checkReorg(ts int64, fr *backend.FlushRecord) (bool, bool, error) {
+	tx := fr.Tx
+	res, err := l.lookupAnchor(fr)
+	if err != nil {
+		return false, false, err
+	}
+	updated := fr.Tx != tx
+
+	if res.Confirmations > 0 {
+		if res.BlockHash == fr.MinedBlock {
+			return false, updated, nil
+		}
+		if fr.MinedBlock != (chainhash.Hash{}) {
+			log.Warnf("Anchor %v of %v moved from block %v to %v",
+				fr.Tx, ts2dirname(ts), fr.MinedBlock, res.BlockHash)
+		}
+		fr.MinedBlock = res.BlockHash
+		return false, true, nil
+	}
+	if fr.MinedBlock == (chainhash.Hash{}) {
+		// Not mined yet, the wallet keeps broadcasting it.  The
+		// replacement of a reorganized anchor that was dropped is
+		// retried.
+		if res.Confirmations == 0 || fr.Reorged == 0 {
+			return false, updated, nil
+		}
+		return false, l.replaceAnchor(ts, fr) || updated, nil
+	}
+
+	log.Warnf("Anchor %v of %v was reorganized out of block %v",
+		fr.Tx, ts2dirname(ts), fr.MinedBlock)
+	fr.MinedBlock = chainhash.Hash{}
+	fr.Reorged = l.myNow().Unix()
+	l.Lock()
+	l.lastReorg = fr.Reorged
+	l.Unlock()
+
+	if res.Confirmations < 0 {
+		l.replaceAnchor(ts, fr)
+	}
+	return true, true, nil
+}
+
+// checkReorgs checks the anchors of the recent collections that lack
+// confirmations for reorganizations and writes back the flush records that
+// were updated.  It returns the number of anchors that were reorganized out
+// of the chain.
+//
+// This function must be called with flushMtx held.
+func (l *LevelDB) checkReorgs() (int, error) {
+	pending := make(map[int64]*backend.FlushRecord)
+	from := l.myNow().Add(-reorgAge).Unix()
+	err := l.flushRecords(from, maxTimestamp, func(ts int64, fr *backend.FlushRecord) error {
+		if fr.ChainTimestamp == 0 && fr.Tx != (chainhash.Hash{}) {
+			pending[ts] = fr
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for ts, fr := range pending {
+		reorged, updated, err := l.checkReorg(ts, fr)
+		if err != nil {
+			log.Errorf("Check reorg of %v: %v", ts2dirname(ts), err)
+			continue
+		}
+		if reorged {
+			count++
+		}
+		if !updated {
+			continue
+		}
+
+		// Write back
+		payload, err := json.Marshal(*fr)
+		if err != nil {
+			return count, err
+		}
+		err = l.db.Put(tsKey(flushPrefix, ts), payload, syncWrite)
+		if err != nil {
+			return count, err
+		}
+	}
+
+	return count, nil
+}
+
+// reorgWatcher is called periodically and checks the anchors that lack
+// confirmations for reorganizations whenever the best block height changed.
+// A reorganization to a chain of the same height is found once the next block
+// is mined.  Nothing is done while blocks are notified.
+func (l *LevelDB) reorgWatcher() {
+	if l.subscribed() {
+		return
+	}
+	height, err := l.wallet.BestHeight()
+	if err != nil {
+		log.Errorf("reorgWatcher: %v", err)
+		return
+	}
+	l.watchReorgs(height, false)
+}
+
+// watchReorgs checks the anchors that lack confirmations for reorganizations
+// at the provided best block height.  Unless forced, nothing is checked when
+// the height did not change since the last check.
+func (l *LevelDB) watchReorgs(height int32, force bool) {
+	// Reorganized anchors are replaced, which must not race a flush.
+	l.flushMtx.Lock()
+	defer l.flushMtx.Unlock()
+	if height == l.reorgHeight && !force {
+		return
+	}
+	l.reorgHeight = height
+
+	reorged, err := l.checkReorgs()
+	if err != nil {
+		log.Errorf("watchReorgs: %v", err)
+	}
+	if reorged != 0 {
+		log.Infof("Reorg watcher: reorganized anchors %v at height %v",
+			reorged, height)
+	}
+}
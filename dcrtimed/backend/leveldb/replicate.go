@@ -0,0 +1,126 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package leveldb
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/decred/dcrtime/dcrtimed/backend"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// errNotStandby is returned when replicated collections are applied to a
+// backend that stores digests itself.
+var errNotStandby = errors.New("replication requires a read-only backend")
+
+// ReplicateCollections calls f with every collection with a timestamp at or
+// after since.  Flushed collections carry all of their digests in the flush
+// record, like they do in the filesystem backend.  This call satisfies the
+// backend interface.
+func (l *LevelDB) ReplicateCollections(since int64, f func(backend.ReplicationRecord) error) error {
+	return l.walkCollections(since, func(ts int64, hashes []*[sha256.Size]byte, values [][]byte) error {
+		rr := backend.ReplicationRecord{
+			Timestamp: ts,
+			Digests:   make([]backend.DigestReceived, 0, len(hashes)),
+		}
+		for k, h := range hashes {
+			rr.Digests = append(rr.Digests, backend.DigestReceived{
+				Digest:    hex.EncodeToString(h[:]),
+				Timestamp: ts,
+				Label:     digestLabel(values[k]),
+				Algorithm: digestAlgorithm(values[k]),
+			})
+		}
+
+		fr, err := l.flushRecord(ts)
+		if err == nil {
+			if fr.Hashes != nil {
+				hashes = fr.Hashes
+			}
+			rr.Flush = flushRecordJSON(ts, fr, hashes)
+		} else if !errors.Is(err, leveldb.ErrNotFound) {
+			return err
+		}
+
+		return f(rr)
+	})
+}
+
+// ApplyReplication stores a collection that was replicated from the primary.
+// The digests are indexed right away, the collection remains pending until it
+// was flushed by the primary.  This call satisfies the backend interface.
+func (l *LevelDB) ApplyReplication(rr backend.ReplicationRecord) error {
+	if !l.readOnly {
+		return errNotStandby
+	}
+	if rr.Timestamp <= 0 {
+		return fmt.Errorf("invalid timestamp: %v", rr.Timestamp)
+	}
+	if rr.Archived && rr.Flush == nil {
+		return fmt.Errorf("archived collection %v without flush record",
+			ts2dirname(rr.Timestamp))
+	}
+
+	batch := new(leveldb.Batch)
+	for _, dr := range rr.Digests {
+		digest, err := hex.DecodeString(dr.Digest)
+		if err != nil {
+			return err
+		}
+		if len(digest) != sha256.Size {
+			return fmt.Errorf("invalid digest: %v", dr.Digest)
+		}
+		putDigest(batch, rr.Timestamp, digest, dr.Label, dr.Algorithm)
+	}
+
+	// The digests of replicated flush records are kept since they may
+	// differ from the digests that were replicated.
+	if rr.Flush != nil {
+		payload, err := json.Marshal(backend.FlushRecord{
+			Root:            rr.Flush.Root,
+			Hashes:          rr.Flush.Hashes,
+			Tx:              rr.Flush.Tx,
+			ChainTimestamp:  rr.Flush.ChainTimestamp,
+			FlushTimestamp:  rr.Flush.FlushTimestamp,
+			ServerTimestamp: rr.Timestamp,
+			BlockHeight:     rr.Flush.BlockHeight,
+			AnchorPrefix:    rr.Flush.AnchorPrefix,
+			Replaced:        rr.Flush.Replaced,
+			Attestations:    rr.Flush.Attestations,
+			Reorged:         rr.Flush.Reorged,
+		})
+		if err != nil {
+			return err
+		}
+		batch.Put(tsKey(flushPrefix, rr.Timestamp), payload)
+		batch.Delete(tsKey(pendingPrefix, rr.Timestamp))
+	} else if len(rr.Digests) != 0 {
+		batch.Put(tsKey(pendingPrefix, rr.Timestamp), nil)
+	}
+
+	l.Lock()
+	defer l.Unlock()
+
+	err := l.db.Write(batch, syncWrite)
+	if err != nil {
+		return err
+	}
+	if rr.Flush != nil {
+		delete(l.pending, rr.Timestamp)
+		return nil
+	}
+	n, err := l.countCollection(rr.Timestamp)
+	if err != nil {
+		return err
+	}
+	if n != 0 {
+		l.pending[rr.Timestamp] = n
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package leveldb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/decred/dcrtime/dcrtimed/backend"
+	"github.com/decred/dcrtime/merkle"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// sampleAttempts is the number of collections that are tried for every
+// requested sample, which bounds the work when collections fail to sample.
+const sampleAttempts = 4
+
+// sampleCollection returns a random digest of the flushed collection with the
+// provided timestamp and its merkle path, which is derived from all digests
+// that are stored in the collection.
+func (l *LevelDB) sampleCollection(rnd *rand.Rand, ts int64) (*backend.AuditResult, error) {
+	fr, err := l.flushRecord(ts)
+	if err != nil {
+		return nil, err
+	}
+	hashes, err := l.collectionHashes(ts)
+	if err != nil {
+		return nil, err
+	}
+	if len(hashes) == 0 {
+		return nil, errEmptySet
+	}
+
+	digest := hashes[rnd.Intn(len(hashes))]
+	return &backend.AuditResult{
+		Digest:         *digest,
+		Timestamp:      ts,
+		MerkleRoot:     fr.Root,
+		MerklePath:     *merkle.AuthPath(hashes, digest),
+		Tx:             fr.Tx,
+		ChainTimestamp: fr.ChainTimestamp,
+	}, nil
+}
+
+// flushedTimestamps returns the timestamps of all flushed collections.
+func (l *LevelDB) flushedTimestamps() ([]int64, error) {
+	timestamps := make([]int64, 0, 1024)
+	i := l.db.NewIterator(util.BytesPrefix([]byte(flushPrefix)), nil)
+	defer i.Release()
+	for i.Next() {
+		key := i.Key()[len(flushPrefix):]
+		if len(key) != 8 {
+			return nil, errInvalidDB
+		}
+		timestamps = append(timestamps,
+			int64(binary.BigEndian.Uint64(key)))
+	}
+	return timestamps, i.Error()
+}
+
+// SampleAnchored returns up to n digests that are sampled at random from
+// flushed collections.
+//
+// SampleAnchored satisfies the backend interface.
+func (l *LevelDB) SampleAnchored(n int) ([]backend.AuditResult, error) {
+	timestamps, err := l.flushedTimestamps()
+	if err != nil {
+		return nil, err
+	}
+
+	samples := make([]backend.AuditResult, 0, n)
+	if len(timestamps) == 0 {
+		return samples, nil
+	}
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	for i := 0; i < n*sampleAttempts && len(samples) < n; i++ {
+		ts := timestamps[rnd.Intn(len(timestamps))]
+		ar, err := l.sampleCollection(rnd, ts)
+		if err != nil {
+			return nil, fmt.Errorf("sample %v: %w", ts2dirname(ts), err)
+		}
+		samples = append(samples, *ar)
+	}
+
+	for k := range samples {
+		res, err := l.wallet.Lookup(samples[k].Tx)
+		if err != nil {
+			return nil, fmt.Errorf("lookup anchor %v of %v: %w",
+				samples[k].Tx, ts2dirname(samples[k].Timestamp), err)
+		}
+		samples[k].Confirmations = res.Confirmations
+	}
+
+	return samples, nil
+}
@@ -0,0 +1,112 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package leveldb
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+
+	"github.com/decred/dcrtime/dcrtimed/backend"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// statsPrefix prefixes the keys of the submission statistics of the
+// collections.
+const statsPrefix = "stats/"
+
+// submissionStats counts the digests that were submitted to a collection.
+// Clients is sorted.
+type submissionStats struct {
+	Submitted  int64    `json:"submitted"`
+	Duplicates int64    `json:"duplicates"`
+	Clients    []string `json:"clients"`
+}
+
+// submissionStats returns the submission statistics of the collection with the
+// provided timestamp.  Collections without submissions return empty
+// statistics.
+func (l *LevelDB) submissionStats(ts int64) (*submissionStats, error) {
+	payload, err := l.db.Get(tsKey(statsPrefix, ts), nil)
+	if errors.Is(err, leveldb.ErrNotFound) {
+		return &submissionStats{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var ss submissionStats
+	if err := json.Unmarshal(payload, &ss); err != nil {
+		return nil, err
+	}
+	return &ss, nil
+}
+
+// PutSubmission records that the client submitted digests to the collection
+// with the provided timestamp.  This call satisfies the backend interface.
+func (l *LevelDB) PutSubmission(ts int64, client string, digests, duplicates int) error {
+	l.statsMtx.Lock()
+	defer l.statsMtx.Unlock()
+
+	ss, err := l.submissionStats(ts)
+	if err != nil {
+		return err
+	}
+	ss.Submitted += int64(digests)
+	ss.Duplicates += int64(duplicates)
+	k := sort.SearchStrings(ss.Clients, client)
+	if k == len(ss.Clients) || ss.Clients[k] != client {
+		ss.Clients = append(ss.Clients, "")
+		copy(ss.Clients[k+1:], ss.Clients[k:])
+		ss.Clients[k] = client
+	}
+
+	payload, err := json.Marshal(ss)
+	if err != nil {
+		return err
+	}
+	return l.db.Put(tsKey(statsPrefix, ts), payload, nil)
+}
+
+// GetCollectionStats returns the statistics of all flushed collections with
+// timestamps between from and to, inclusive.  This call satisfies the backend
+// interface.
+func (l *LevelDB) GetCollectionStats(from, to int64) ([]backend.CollectionStats, error) {
+	stats := make([]backend.CollectionStats, 0, 64)
+	err := l.flushRecords(from, to, func(ts int64, fr *backend.FlushRecord) error {
+		height, err := l.anchorHeight(ts, fr)
+		if err != nil {
+			return err
+		}
+		ss, err := l.submissionStats(ts)
+		if err != nil {
+			return err
+		}
+		digests, err := l.flushedDigests(ts, fr)
+		if err != nil {
+			return err
+		}
+
+		cs := backend.CollectionStats{
+			Timestamp:   ts,
+			Digests:     digests,
+			Submitted:   ss.Submitted,
+			Duplicates:  ss.Duplicates,
+			Clients:     len(ss.Clients),
+			MerkleRoot:  fr.Root,
+			Tx:          fr.Tx,
+			BlockHeight: height,
+		}
+		if height != 0 {
+			cs.ChainTimestamp = fr.ChainTimestamp
+		}
+		stats = append(stats, cs)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
@@ -0,0 +1,150 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package leveldb
+
+import (
+	"time"
+
+	"github.com/decred/dcrtime/dcrtimed/backend"
+)
+
+// pendingTotal returns the number of digests in all unflushed collections.
+//
+// This must be called with the READ lock held.
+func (l *LevelDB) pendingTotal() int64 {
+	var total int64
+	for _, count := range l.pending {
+		total += count
+	}
+	return total
+}
+
+// Status reports the digests of the unflushed collections and the last flush.
+// This call satisfies the backend interface.
+func (l *LevelDB) Status() (*backend.StatusResult, error) {
+	var sr backend.StatusResult
+
+	// Contact the wallet without holding the lock.
+	br, err := l.GetBalance()
+	if err != nil {
+		sr.WalletError = err
+	}
+	sr.Balance = br
+	sr.AnchorError = l.anchorError()
+
+	l.RLock()
+	sr.Maintenance = l.maintenance
+	sr.PendingDigests = l.pendingTotal()
+	sr.LastReorg = l.lastReorg
+	l.RUnlock()
+
+	_, fr, err := l.lastFlush()
+	if err != nil {
+		return nil, err
+	}
+	if fr == nil {
+		return &sr, nil
+	}
+	sr.LastFlushTimestamp = fr.FlushTimestamp
+	sr.LastFlushTx = fr.Tx
+	sr.LastFlushChainTimestamp = fr.ChainTimestamp
+
+	return &sr, nil
+}
+
+// SetMaintenance enables or disables maintenance mode.  Digests that are
+// already pending count towards the queue when it is enabled.  All queued
+// collections are flushed when it is disabled.
+//
+// SetMaintenance satisfies the backend interface.
+func (l *LevelDB) SetMaintenance(enable bool) error {
+	if l.readOnly {
+		return backend.ErrReadOnly
+	}
+
+	l.flushMtx.Lock()
+	defer l.flushMtx.Unlock()
+
+	l.Lock()
+	if enable == l.maintenance {
+		l.Unlock()
+		return nil
+	}
+	if enable {
+		defer l.Unlock()
+		l.maintenance = true
+		l.queued = l.pendingTotal()
+		log.Infof("Maintenance: started, digests queued %v", l.queued)
+		return nil
+	}
+
+	l.maintenance = false
+	l.queued = 0
+	l.Unlock()
+
+	// Digests are accepted again while the queued collections are
+	// flushed.
+	start := time.Now()
+	count := l.flush()
+	log.Infof("Maintenance: ended, collections %v flushed in %v", count,
+		time.Since(start))
+
+	return nil
+}
+
+// Maintenance returns true if maintenance mode is enabled.
+//
+// Maintenance satisfies the backend interface.
+func (l *LevelDB) Maintenance() bool {
+	l.RLock()
+	defer l.RUnlock()
+	return l.maintenance
+}
+
+// flusherCompleted records that the flusher completed a run.
+func (l *LevelDB) flusherCompleted() {
+	l.healthMtx.Lock()
+	l.lastFlusher = time.Now()
+	l.healthMtx.Unlock()
+}
+
+// setAnchorError records the outcome of the last anchor transaction.
+func (l *LevelDB) setAnchorError(err error) {
+	l.healthMtx.Lock()
+	l.anchorErr = err
+	l.healthMtx.Unlock()
+}
+
+// anchorError returns the error of the last anchor transaction, nil if it
+// succeeded.
+func (l *LevelDB) anchorError() error {
+	l.healthMtx.Lock()
+	defer l.healthMtx.Unlock()
+	return l.anchorErr
+}
+
+// Health checks that the database is readable.  No lock is taken so that the
+// health of a backend that is flushing, or whose flusher hangs, can be
+// reported.
+//
+// Health satisfies the backend interface.
+func (l *LevelDB) Health() (*backend.HealthResult, error) {
+	var hr backend.HealthResult
+
+	l.healthMtx.Lock()
+	hr.LastFlusher = l.lastFlusher
+	hr.FlusherInterval = l.flusherInterval
+	l.healthMtx.Unlock()
+
+	if _, err := l.wallet.BestHeight(); err != nil {
+		hr.WalletError = err
+	}
+
+	if _, err := l.db.Has(tsKey(flushPrefix, 0), nil); err != nil {
+		return nil, err
+	}
+
+	return &hr, nil
+}
@@ -0,0 +1,92 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package backend
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/decred/dcrtime/dcrtimed/anchorer"
+	"github.com/decred/dcrtime/dcrtimed/dcrtimewallet"
+)
+
+// Config contains the settings a backend is opened with.  Backends ignore
+// settings they do not support.
+type Config struct {
+	DataDir           string               // Data directory of the network
+	Wallet            dcrtimewallet.Wallet // Publishes anchors
+	Anchorers         []anchorer.Anchorer  // Secondary anchorers
+	EnableCollections bool                 // Collection timestamps queries
+	Confirmations     int32                // Confirmations of a proof
+	MaxDigests        int32                // Max digests per request
+	FastAnchors       []string             // Fast anchors, prefix:interval
+	AnchorPrefix      string               // Prefix in front of merkle roots
+	AnchorRetry       time.Duration        // Unmined anchor replacement
+	AnchorBlocks      int32                // Anchor every n blocks
+	ReadOnly          bool                 // Refuse digests
+	Maintenance       bool                 // Start in maintenance mode
+	MaintenanceQueue  int64                // Max digests in maintenance
+	ConfirmRefresh    time.Duration        // Confirmation refresh interval
+	ConfirmWorkers    int                  // Concurrent refresh lookups
+	LowBalance        int64                // Warned wallet balance in atoms
+	PauseLowBalance   bool                 // Queue digests at low balance
+	FlushWorkers      int                  // Concurrent merkle root workers
+	EncryptionKey     []byte               // Encrypts data at rest if set
+}
+
+// Driver opens a backend with the provided configuration.
+type Driver func(Config) (Backend, error)
+
+var (
+	driversMtx sync.RWMutex
+	drivers    = make(map[string]Driver)
+)
+
+// Register makes a backend available under the provided name.  Backends
+// register themselves when their package is imported, similar to the drivers
+// of database/sql.  Register panics if the name is empty, the driver is nil or
+// the name was registered before.
+func Register(name string, driver Driver) {
+	driversMtx.Lock()
+	defer driversMtx.Unlock()
+
+	if name == "" {
+		panic("backend: empty driver name")
+	}
+	if driver == nil {
+		panic("backend: nil driver " + name)
+	}
+	if _, ok := drivers[name]; ok {
+		panic("backend: driver registered twice: " + name)
+	}
+	drivers[name] = driver
+}
+
+// Drivers returns the sorted names of the registered backends.
+func Drivers() []string {
+	driversMtx.RLock()
+	defer driversMtx.RUnlock()
+
+	names := make([]string, 0, len(drivers))
+	for name := range drivers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Open opens the backend that was registered under the provided name.
+func Open(name string, cfg Config) (Backend, error) {
+	driversMtx.RLock()
+	driver, ok := drivers[name]
+	driversMtx.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown backend %q, available: %v", name,
+			Drivers())
+	}
+	return driver(cfg)
+}
@@ -0,0 +1,51 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package backend
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	errOpen := errors.New("open")
+	var opened Config
+	Register("test-b", func(cfg Config) (Backend, error) {
+		opened = cfg
+		return nil, errOpen
+	})
+	Register("test-a", func(Config) (Backend, error) {
+		return nil, nil
+	})
+
+	if got, want := Drivers(), []string{"test-a", "test-b"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got drivers %v, want %v", got, want)
+	}
+
+	_, err := Open("test-b", Config{DataDir: "data"})
+	if !errors.Is(err, errOpen) {
+		t.Fatalf("got error %v, want %v", err, errOpen)
+	}
+	if opened.DataDir != "data" {
+		t.Fatalf("driver got config %+v", opened)
+	}
+	if _, err := Open("test-c", Config{}); err == nil {
+		t.Fatal("expected error opening unknown backend")
+	}
+
+	for _, name := range []string{"", "test-a"} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatalf("expected panic registering %q", name)
+				}
+			}()
+			Register(name, func(Config) (Backend, error) {
+				return nil, nil
+			})
+		}()
+	}
+}
@@ -0,0 +1,62 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package backend
+
+import (
+	"github.com/decred/dcrd/chaincfg/chainhash"
+	"github.com/decred/dcrtime/dcrtimed/dcrtimewallet"
+)
+
+const (
+	// MaxReplacements is the number of times an anchor is replaced before
+	// it is left to the operator.
+	MaxReplacements = 4
+
+	// maxFeeBump is the largest factor the fee rate of a replacement is
+	// multiplied by.
+	maxFeeBump = 16
+)
+
+// FeeBump returns the factor the fee rate of the replacement of an anchor
+// that was already replaced the provided number of times is multiplied by.
+// The fee rate doubles with every replacement.
+func FeeBump(replaced int) int64 {
+	bump := int64(2) << uint(replaced)
+	if bump > maxFeeBump {
+		bump = maxFeeBump
+	}
+	return bump
+}
+
+// LookupAnchor looks up the anchor of the provided flush record through the
+// wallet.  Replaced anchors remain valid and may be mined instead of their
+// replacement.  The first one that is found in a block becomes the anchor of
+// the flush record and the replacement is moved to the replaced anchors.  The
+// flush record is not written back.
+func LookupAnchor(wallet dcrtimewallet.Wallet, fr *FlushRecord) (*dcrtimewallet.TxLookupResult, error) {
+	res, err := wallet.Lookup(fr.Tx)
+	if err != nil || res.Confirmations > 0 {
+		return res, err
+	}
+
+	for k, tx := range fr.Replaced {
+		r, err := wallet.Lookup(tx)
+		if err != nil {
+			return nil, err
+		}
+		if r.Confirmations <= 0 {
+			continue
+		}
+
+		replaced := make([]chainhash.Hash, 0, len(fr.Replaced))
+		replaced = append(replaced, fr.Replaced[:k]...)
+		replaced = append(replaced, fr.Replaced[k+1:]...)
+		fr.Replaced = append(replaced, fr.Tx)
+		fr.Tx = tx
+		return r, nil
+	}
+
+	return res, nil
+}
@@ -0,0 +1,343 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package sidestore
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"strings"
+
+	"github.com/decred/dcrtime/dcrtimed/backend"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+const (
+	// ownerDigestPrefix prefixes the keys that record the owner of a
+	// digest: prefix | owner | '/' | timestamp | digest.
+	ownerDigestPrefix = "digest/"
+
+	// ownerNamePrefix prefixes the keys of collection names:
+	// prefix | owner | '/' | timestamp.
+	ownerNamePrefix = "name/"
+)
+
+// Owners stores the owners of timestamped digests and the names they gave
+// their collections.
+type Owners struct {
+	db     *leveldb.DB // Database the owners are kept in
+	prefix string      // Prefix of all keys
+
+	// anchored returns true if the collection with the provided
+	// timestamp was anchored.
+	anchored func(ts int64) bool
+
+	// metadata returns the metadata of the provided digests.
+	metadata func([][sha256.Size]byte) ([]*backend.Metadata, error)
+}
+
+// NewOwners returns the collection ownership store that keeps its keys under
+// the provided prefix of the database.  Collections are reported as anchored
+// if anchored returns true for their timestamp.  Digests are searched by the
+// metadata that is returned by metadata.
+func NewOwners(db *leveldb.DB, prefix string, anchored func(ts int64) bool, metadata func([][sha256.Size]byte) ([]*backend.Metadata, error)) *Owners {
+	return &Owners{
+		db:       db,
+		prefix:   prefix,
+		anchored: anchored,
+		metadata: metadata,
+	}
+}
+
+// ownerKey returns the key with the provided prefix of a collection of the
+// owner.  Timestamps are big endian so that keys sort by timestamp.
+func (o *Owners) ownerKey(prefix, owner string, ts int64) []byte {
+	key := make([]byte, 0, len(o.prefix)+len(prefix)+len(owner)+1+8+
+		sha256.Size)
+	key = append(key, o.prefix...)
+	key = append(key, prefix...)
+	key = append(key, owner...)
+	key = append(key, '/')
+	var t [8]byte
+	binary.BigEndian.PutUint64(t[:], uint64(ts))
+	return append(key, t[:]...)
+}
+
+// ownerDigestKey returns the key that records the owner of a digest.
+func (o *Owners) ownerDigestKey(owner string, ts int64, digest []byte) []byte {
+	return append(o.ownerKey(ownerDigestPrefix, owner, ts), digest...)
+}
+
+// ownerPrefix returns the key prefix of all digests of the owner.
+func (o *Owners) ownerPrefix(owner string) []byte {
+	return []byte(o.prefix + ownerDigestPrefix + owner + "/")
+}
+
+// OwnsCollection returns true if the owner timestamped digests in the
+// collection.
+func (o *Owners) OwnsCollection(owner string, ts int64) (bool, error) {
+	i := o.db.NewIterator(util.BytesPrefix(o.ownerKey(ownerDigestPrefix,
+		owner, ts)), nil)
+	defer i.Release()
+	found := i.Next()
+	return found, i.Error()
+}
+
+// OwnsDigest returns true if the owner timestamped the digest in the
+// collection.
+func (o *Owners) OwnsDigest(owner string, ts int64, digest []byte) (bool, error) {
+	return o.db.Has(o.ownerDigestKey(owner, ts, digest), nil)
+}
+
+// DeleteOwned adds the removal of the name and the ownership records of a
+// collection of the owner to the provided batch.  The digests of the owner in
+// the collection are returned.
+func (o *Owners) DeleteOwned(batch *leveldb.Batch, owner string, ts int64) ([][]byte, error) {
+	var digests [][]byte
+	batch.Delete(o.ownerKey(ownerNamePrefix, owner, ts))
+	prefix := o.ownerKey(ownerDigestPrefix, owner, ts)
+	i := o.db.NewIterator(util.BytesPrefix(prefix), nil)
+	defer i.Release()
+	for i.Next() {
+		batch.Delete(append([]byte{}, i.Key()...))
+		digests = append(digests, append([]byte{},
+			i.Key()[len(prefix):]...))
+	}
+	if err := i.Error(); err != nil {
+		return nil, err
+	}
+	return digests, nil
+}
+
+// PutOwner records the owner of digests that were stored in the collection
+// with the provided timestamp.  A non-empty name becomes the name of the
+// collection unless the owner already named it.  This call satisfies the
+// backend interface.
+func (o *Owners) PutOwner(owner string, ts int64, name string, digests [][sha256.Size]byte) error {
+	batch := new(leveldb.Batch)
+	for _, digest := range digests {
+		batch.Put(o.ownerDigestKey(owner, ts, digest[:]), nil)
+	}
+	if name != "" {
+		key := o.ownerKey(ownerNamePrefix, owner, ts)
+		named, err := o.db.Has(key, nil)
+		if err != nil {
+			return err
+		}
+		if !named {
+			batch.Put(key, []byte(name))
+		}
+	}
+	return o.db.Write(batch, nil)
+}
+
+// GetCollections returns all collections the owner timestamped digests in,
+// ordered by timestamp.  This call satisfies the backend interface.
+func (o *Owners) GetCollections(owner string) ([]backend.Collection, error) {
+	collections := make([]backend.Collection, 0, 16)
+
+	prefix := o.ownerPrefix(owner)
+	i := o.db.NewIterator(util.BytesPrefix(prefix), nil)
+	defer i.Release()
+	for i.Next() {
+		key := i.Key()[len(prefix):]
+		if len(key) != 8+sha256.Size {
+			return nil, errInvalidDB
+		}
+		ts := int64(binary.BigEndian.Uint64(key[:8]))
+		if n := len(collections); n != 0 &&
+			collections[n-1].Timestamp == ts {
+			collections[n-1].Digests++
+			continue
+		}
+		collections = append(collections, backend.Collection{
+			Timestamp: ts,
+			Digests:   1,
+		})
+	}
+	if err := i.Error(); err != nil {
+		return nil, err
+	}
+
+	for k := range collections {
+		c := &collections[k]
+		name, err := o.db.Get(o.ownerKey(ownerNamePrefix, owner,
+			c.Timestamp), nil)
+		if err != nil && err != leveldb.ErrNotFound {
+			return nil, err
+		}
+		c.Name = string(name)
+		c.Anchored = o.anchored(c.Timestamp)
+	}
+
+	return collections, nil
+}
+
+// GetCollectionDigests returns the digests the owner timestamped in the
+// collection, ordered by digest.  This call satisfies the backend interface.
+func (o *Owners) GetCollectionDigests(owner string, ts int64) ([][sha256.Size]byte, error) {
+	digests := make([][sha256.Size]byte, 0, 16)
+
+	prefix := o.ownerKey(ownerDigestPrefix, owner, ts)
+	i := o.db.NewIterator(util.BytesPrefix(prefix), nil)
+	defer i.Release()
+	for i.Next() {
+		key := i.Key()[len(prefix):]
+		if len(key) != sha256.Size {
+			return nil, errInvalidDB
+		}
+		var digest [sha256.Size]byte
+		copy(digest[:], key)
+		digests = append(digests, digest)
+	}
+	if err := i.Error(); err != nil {
+		return nil, err
+	}
+	if len(digests) == 0 {
+		return nil, backend.ErrCollectionNotFound
+	}
+
+	return digests, nil
+}
+
+// inSubtree returns true if the collection name is part of the subtree with
+// the provided root name.  Every collection is part of the subtree with an
+// empty root name.
+func inSubtree(name, root string) bool {
+	return root == "" || name == root || strings.HasPrefix(name, root+"/")
+}
+
+// matchMetadata returns true if the metadata has the provided key and, if it
+// is set, value.
+func matchMetadata(md *backend.Metadata, key, value string) bool {
+	if md == nil {
+		return false
+	}
+	v, ok := md.Values[key]
+	return ok && (value == "" || v == value)
+}
+
+// SearchDigests returns up to the query limit of the digests the owner
+// timestamped that match the provided query, ordered by collection timestamp
+// and digest, and the cursor of the next page.  The cursor is the hex encoded
+// timestamp and digest of the last returned digest.  This call satisfies the
+// backend interface.
+func (o *Owners) SearchDigests(owner string, q backend.DigestQuery) ([]backend.DigestMatch, string, error) {
+	if q.Limit <= 0 {
+		return []backend.DigestMatch{}, "", nil
+	}
+
+	prefix := o.ownerPrefix(owner)
+	r := util.BytesPrefix(prefix)
+	r.Start = o.ownerKey(ownerDigestPrefix, owner, q.From)
+	if q.Cursor != "" {
+		cursor, err := hex.DecodeString(q.Cursor)
+		if err != nil || len(cursor) != 8+sha256.Size {
+			return nil, "", backend.ErrInvalidCursor
+		}
+		start := append(append(prefix[:len(prefix):len(prefix)],
+			cursor...), 0)
+		if bytes.Compare(start, r.Start) > 0 {
+			r.Start = start
+		}
+	}
+	if q.To != 0 {
+		r.Limit = o.ownerKey(ownerDigestPrefix, owner, q.To+1)
+	}
+
+	type collection struct {
+		name     string
+		anchored bool
+	}
+	collections := make(map[int64]collection)
+	lookup := func(ts int64) (collection, error) {
+		if c, ok := collections[ts]; ok {
+			return c, nil
+		}
+		name, err := o.db.Get(o.ownerKey(ownerNamePrefix, owner, ts),
+			nil)
+		if err != nil && err != leveldb.ErrNotFound {
+			return collection{}, err
+		}
+		c := collection{
+			name:     string(name),
+			anchored: o.anchored(ts),
+		}
+		collections[ts] = c
+		return c, nil
+	}
+
+	matches := make([]backend.DigestMatch, 0, q.Limit)
+	var next string
+	i := o.db.NewIterator(r, nil)
+	defer i.Release()
+	for i.Next() {
+		key := i.Key()[len(prefix):]
+		if len(key) != 8+sha256.Size {
+			return nil, "", errInvalidDB
+		}
+		ts := int64(binary.BigEndian.Uint64(key[:8]))
+		c, err := lookup(ts)
+		if err != nil {
+			return nil, "", err
+		}
+		if !inSubtree(c.name, q.Collection) ||
+			(q.Anchored && !c.anchored) || (q.Pending && c.anchored) {
+			continue
+		}
+
+		var digest [sha256.Size]byte
+		copy(digest[:], key[8:])
+		mds, err := o.metadata([][sha256.Size]byte{digest})
+		if err != nil {
+			return nil, "", err
+		}
+		if q.MetadataKey != "" &&
+			!matchMetadata(mds[0], q.MetadataKey, q.MetadataValue) {
+			continue
+		}
+
+		// Only return a cursor if another digest matches.
+		if len(matches) == q.Limit {
+			last := matches[len(matches)-1]
+			cursor := make([]byte, 8, 8+sha256.Size)
+			binary.BigEndian.PutUint64(cursor, uint64(last.Timestamp))
+			next = hex.EncodeToString(append(cursor, last.Digest[:]...))
+			break
+		}
+		matches = append(matches, backend.DigestMatch{
+			Digest:     digest,
+			Timestamp:  ts,
+			Collection: c.name,
+			Anchored:   c.anchored,
+			Metadata:   mds[0],
+		})
+	}
+	if err := i.Error(); err != nil {
+		return nil, "", err
+	}
+
+	return matches, next, nil
+}
+
+// RenameCollection gives a collection of the owner a name.  An empty name
+// removes it.  This call satisfies the backend interface.
+func (o *Owners) RenameCollection(owner string, ts int64, name string) error {
+	found, err := o.OwnsCollection(owner, ts)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return backend.ErrCollectionNotFound
+	}
+
+	key := o.ownerKey(ownerNamePrefix, owner, ts)
+	if name == "" {
+		return o.db.Delete(key, nil)
+	}
+	return o.db.Put(key, []byte(name), nil)
+}
@@ -0,0 +1,316 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package sidestore
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/decred/dcrtime/dcrtimed/backend"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+const (
+	// sessionPrefix prefixes the keys of submission sessions.
+	sessionPrefix = "session/"
+
+	// sessionDigestPrefix prefixes the keys of staged digests:
+	// prefix | id | '/' | index.
+	sessionDigestPrefix = "digest/"
+)
+
+// PutFunc timestamps digests in the current collection of a backend.  It has
+// the signature of the Put call of the backend interface.
+type PutFunc func(digests [][sha256.Size]byte, label, algorithm string) (int64, []backend.PutResult, error)
+
+// Sessions stores submission sessions, their staged digests and asynchronous
+// submission tickets.
+type Sessions struct {
+	mtx           sync.Mutex       // Serializes session and ticket updates
+	db            *leveldb.DB      // Database the sessions are kept in
+	prefix        string           // Prefix of all keys
+	now           func() time.Time // Current time of the backend
+	readOnly      func() bool      // Returns true if digests are refused
+	put           PutFunc          // Timestamps the digests of sessions
+	ticketsPurged int64            // Last removal of expired tickets
+}
+
+// NewSessions returns the submission session store that keeps its keys under
+// the provided prefix of the database.  Closed sessions are timestamped with
+// put.  New sessions and tickets are refused while readOnly returns true.
+func NewSessions(db *leveldb.DB, prefix string, now func() time.Time, readOnly func() bool, put PutFunc) *Sessions {
+	return &Sessions{
+		db:       db,
+		prefix:   prefix,
+		now:      now,
+		readOnly: readOnly,
+		put:      put,
+	}
+}
+
+// sessionKey returns the key of the submission session with the provided ID.
+func (s *Sessions) sessionKey(id string) []byte {
+	return []byte(s.prefix + sessionPrefix + id)
+}
+
+// sessionDigestKey returns the key of the staged digest with the provided
+// index.  Indexes are big endian so that digests sort in the order they were
+// appended.
+func (s *Sessions) sessionDigestKey(id string, index int64) []byte {
+	key := s.sessionDigestsPrefix(id)
+	var i [8]byte
+	binary.BigEndian.PutUint64(i[:], uint64(index))
+	return append(key, i[:]...)
+}
+
+// sessionDigestsPrefix returns the key prefix of all staged digests of a
+// session.
+func (s *Sessions) sessionDigestsPrefix(id string) []byte {
+	return []byte(s.prefix + sessionDigestPrefix + id + "/")
+}
+
+// getSession returns the submission session with the provided ID.
+// ErrSessionNotFound is returned if it does not exist or expired.
+//
+// Must be called with the sessions lock held.
+func (s *Sessions) getSession(id string) (*backend.Session, error) {
+	payload, err := s.db.Get(s.sessionKey(id), nil)
+	if err == leveldb.ErrNotFound {
+		return nil, backend.ErrSessionNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	var session backend.Session
+	err = json.Unmarshal(payload, &session)
+	if err != nil {
+		return nil, err
+	}
+	if s.now().Unix() >= session.Expires {
+		return nil, backend.ErrSessionNotFound
+	}
+
+	return &session, nil
+}
+
+// putSession adds the submission session to the provided batch.
+func (s *Sessions) putSession(batch *leveldb.Batch, session backend.Session) error {
+	payload, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+	batch.Put(s.sessionKey(session.ID), payload)
+	return nil
+}
+
+// deleteStaged adds the removal of all staged digests of the session with the
+// provided ID to the provided batch.
+//
+// Must be called with the sessions lock held.
+func (s *Sessions) deleteStaged(batch *leveldb.Batch, id string) error {
+	i := s.db.NewIterator(util.BytesPrefix(s.sessionDigestsPrefix(id)), nil)
+	defer i.Release()
+	for i.Next() {
+		batch.Delete(append([]byte(nil), i.Key()...))
+	}
+	return i.Error()
+}
+
+// purgeSessions removes expired submission sessions together with their
+// staged digests.
+//
+// Must be called with the sessions lock held.
+func (s *Sessions) purgeSessions() (int, error) {
+	now := s.now().Unix()
+	batch := new(leveldb.Batch)
+	purged := 0
+
+	i := s.db.NewIterator(util.BytesPrefix(s.sessionKey("")), nil)
+	defer i.Release()
+	for i.Next() {
+		var session backend.Session
+		err := json.Unmarshal(i.Value(), &session)
+		if err != nil {
+			return 0, err
+		}
+		if now < session.Expires {
+			continue
+		}
+		batch.Delete(append([]byte(nil), i.Key()...))
+		err = s.deleteStaged(batch, session.ID)
+		if err != nil {
+			return 0, err
+		}
+		purged++
+	}
+	if err := i.Error(); err != nil {
+		return 0, err
+	}
+	if purged == 0 {
+		return 0, nil
+	}
+
+	return purged, s.db.Write(batch, nil)
+}
+
+// CreateSession stores a new submission session.  Expired sessions are
+// removed.  This call satisfies the backend interface.
+func (s *Sessions) CreateSession(session backend.Session) error {
+	if s.readOnly() {
+		return backend.ErrReadOnly
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	purged, err := s.purgeSessions()
+	if err != nil {
+		return err
+	}
+	if purged != 0 {
+		log.Infof("Sessions: purged %v expired sessions", purged)
+	}
+
+	batch := new(leveldb.Batch)
+	err = s.putSession(batch, session)
+	if err != nil {
+		return err
+	}
+	return s.db.Write(batch, nil)
+}
+
+// GetSession returns the submission session with the provided ID.  This call
+// satisfies the backend interface.
+func (s *Sessions) GetSession(id string) (*backend.Session, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	return s.getSession(id)
+}
+
+// AppendSession stages digests in the open submission session with the
+// provided ID, starting at the provided offset.  Digests that were already
+// staged are skipped.  This call satisfies the backend interface.
+func (s *Sessions) AppendSession(id string, offset int64, digests [][sha256.Size]byte, expires int64) (*backend.Session, error) {
+	if s.readOnly() {
+		return nil, backend.ErrReadOnly
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	session, err := s.getSession(id)
+	if err != nil {
+		return nil, err
+	}
+	if session.Closed != 0 {
+		return nil, backend.ErrSessionClosed
+	}
+	if offset < 0 || offset > session.Digests {
+		return nil, backend.ErrSessionOffset
+	}
+	if skip := session.Digests - offset; skip < int64(len(digests)) {
+		digests = digests[skip:]
+	} else {
+		digests = nil
+	}
+	if session.Digests+int64(len(digests)) > session.MaxDigests {
+		return nil, backend.ErrSessionFull
+	}
+
+	batch := new(leveldb.Batch)
+	for _, digest := range digests {
+		batch.Put(s.sessionDigestKey(id, session.Digests), digest[:])
+		session.Digests++
+	}
+	session.Expires = expires
+	err = s.putSession(batch, *session)
+	if err != nil {
+		return nil, err
+	}
+	err = s.db.Write(batch, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return session, nil
+}
+
+// CloseSession timestamps the staged digests of the open submission session
+// with the provided ID in the current collection.  The staged digests are
+// removed and the outcome is kept until the provided expiration.  A session
+// without digests is closed without timestamping anything.  This call
+// satisfies the backend interface.
+func (s *Sessions) CloseSession(id string, expires int64) (*backend.Session, []backend.PutResult, error) {
+	// Closes are serialized with appends so that no digest is staged
+	// while the session is timestamped.
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	session, err := s.getSession(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	if session.Closed != 0 {
+		return nil, nil, backend.ErrSessionClosed
+	}
+
+	digests := make([][sha256.Size]byte, 0, session.Digests)
+	i := s.db.NewIterator(util.BytesPrefix(s.sessionDigestsPrefix(id)), nil)
+	for i.Next() {
+		if len(i.Value()) != sha256.Size {
+			i.Release()
+			return nil, nil, errInvalidDB
+		}
+		var digest [sha256.Size]byte
+		copy(digest[:], i.Value())
+		digests = append(digests, digest)
+	}
+	i.Release()
+	if err := i.Error(); err != nil {
+		return nil, nil, err
+	}
+	if int64(len(digests)) != session.Digests {
+		return nil, nil, errInvalidDB
+	}
+
+	var me []backend.PutResult
+	if len(digests) != 0 {
+		session.Timestamp, me, err = s.put(digests, session.Label,
+			session.Algorithm)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	for _, v := range me {
+		if v.ErrorCode == backend.ErrorOK {
+			session.Accepted++
+		} else {
+			session.Existing++
+		}
+	}
+	session.Closed = s.now().Unix()
+	session.Expires = expires
+
+	batch := new(leveldb.Batch)
+	err = s.deleteStaged(batch, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = s.putSession(batch, *session)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = s.db.Write(batch, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return session, me, nil
+}
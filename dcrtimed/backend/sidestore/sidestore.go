@@ -0,0 +1,26 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+// Package sidestore implements the stores that the backends keep next to
+// their digests: api tokens, submission sessions and tickets, webhook
+// deliveries and subscriptions, and collection ownership.
+//
+// Every store lives in a leveldb database that is owned by the backend.  The
+// backend opens and closes the database and passes a key prefix that keeps
+// the store apart from other data in the same database.  A backend that
+// embeds the stores satisfies the matching parts of the backend interface.
+package sidestore
+
+import (
+	"errors"
+
+	"github.com/syndtr/goleveldb/leveldb/opt"
+)
+
+var (
+	// syncWrite syncs writes that were acknowledged to clients.
+	syncWrite = &opt.WriteOptions{Sync: true}
+
+	errInvalidDB = errors.New("not a database") // Should not happen
+)
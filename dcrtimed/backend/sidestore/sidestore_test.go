@@ -0,0 +1,87 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package sidestore
+
+import (
+	"crypto/sha256"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/decred/dcrtime/dcrtimed/backend"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// TestPrefixes verifies that stores that share a database only see the keys
+// under their own prefix.
+func TestPrefixes(t *testing.T) {
+	db, err := leveldb.OpenFile(filepath.Join(t.TempDir(), "db"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	a := NewTokens(db, "a/")
+	b := NewTokens(db, "b/")
+	webhooks := NewWebhooks(db, "webhook/")
+	sessions := NewSessions(db, "session/", time.Now,
+		func() bool { return false }, nil)
+
+	hash := sha256.Sum256([]byte("token"))
+	err = a.PutToken(hash, backend.APIToken{ID: "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = webhooks.PutDeliveries([]backend.Delivery{{ID: "d"}}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = sessions.CreateSession(backend.Session{
+		ID:      "s",
+		Expires: time.Now().Add(time.Hour).Unix(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tokens, err := a.GetTokens()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tokens) != 1 || tokens[0].ID != "a" {
+		t.Fatalf("got tokens %+v, want token a", tokens)
+	}
+	tokens, err = b.GetTokens()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tokens) != 0 {
+		t.Fatalf("got tokens %+v, want none", tokens)
+	}
+	if _, err := b.GetToken(hash); err != backend.ErrTokenNotFound {
+		t.Fatalf("got error %v, want %v", err, backend.ErrTokenNotFound)
+	}
+	if err := b.DeleteToken("a"); err != backend.ErrTokenNotFound {
+		t.Fatalf("got error %v, want %v", err, backend.ErrTokenNotFound)
+	}
+
+	deliveries, err := webhooks.GetDeliveries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 1 || deliveries[0].ID != "d" {
+		t.Fatalf("got deliveries %+v, want delivery d", deliveries)
+	}
+	height, err := webhooks.GetDeliveryHeight()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if height != 1 {
+		t.Fatalf("got delivery height %v, want 1", height)
+	}
+	if _, err := sessions.GetSession("s"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,178 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package sidestore
+
+import (
+	"encoding/binary"
+	"encoding/json"
+
+	"github.com/decred/dcrtime/dcrtimed/backend"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+const (
+	// ticketPrefix prefixes the keys of asynchronous submission tickets.
+	// Tickets are kept in the sessions store.
+	ticketPrefix = "ticket/"
+
+	// ticketQueuePrefix prefixes the keys of the queue of tickets that
+	// were not processed yet: prefix | created | id.
+	ticketQueuePrefix = "ticketqueue/"
+
+	// ticketPurgeInterval is the minimum time between removals of expired
+	// tickets, in seconds.  Every ticket is visited, so they are not
+	// removed on every put.
+	ticketPurgeInterval = 3600
+)
+
+// ticketKey returns the key of the ticket with the provided ID.
+func (s *Sessions) ticketKey(id string) []byte {
+	return []byte(s.prefix + ticketPrefix + id)
+}
+
+// ticketQueueKey returns the queue key of the provided ticket.  Creation
+// timestamps are big endian so that tickets are queued in the order they were
+// created.
+func (s *Sessions) ticketQueueKey(t backend.Ticket) []byte {
+	key := make([]byte, 0, len(s.prefix)+len(ticketQueuePrefix)+8+len(t.ID))
+	key = append(key, s.prefix...)
+	key = append(key, ticketQueuePrefix...)
+	var created [8]byte
+	binary.BigEndian.PutUint64(created[:], uint64(t.Created))
+	key = append(key, created[:]...)
+	return append(key, t.ID...)
+}
+
+// getTicket returns the ticket with the provided ID.  ErrTicketNotFound is
+// returned if it does not exist or expired.
+//
+// Must be called with the sessions lock held.
+func (s *Sessions) getTicket(id string) (*backend.Ticket, error) {
+	payload, err := s.db.Get(s.ticketKey(id), nil)
+	if err == leveldb.ErrNotFound {
+		return nil, backend.ErrTicketNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	var t backend.Ticket
+	err = json.Unmarshal(payload, &t)
+	if err != nil {
+		return nil, err
+	}
+	if t.Expires != 0 && s.now().Unix() >= t.Expires {
+		return nil, backend.ErrTicketNotFound
+	}
+
+	return &t, nil
+}
+
+// purgeTickets removes expired tickets.
+//
+// Must be called with the sessions lock held.
+func (s *Sessions) purgeTickets() (int, error) {
+	now := s.now().Unix()
+	batch := new(leveldb.Batch)
+	purged := 0
+
+	i := s.db.NewIterator(util.BytesPrefix(s.ticketKey("")), nil)
+	defer i.Release()
+	for i.Next() {
+		var t backend.Ticket
+		err := json.Unmarshal(i.Value(), &t)
+		if err != nil {
+			return 0, err
+		}
+		if t.Expires == 0 || now < t.Expires {
+			continue
+		}
+		batch.Delete(append([]byte(nil), i.Key()...))
+		purged++
+	}
+	if err := i.Error(); err != nil {
+		return 0, err
+	}
+	if purged == 0 {
+		return 0, nil
+	}
+
+	return purged, s.db.Write(batch, nil)
+}
+
+// PutTicket stores an asynchronous submission ticket and replaces the ticket
+// with the same ID, if any.  Tickets that were not processed yet are queued.
+// The write is synced since the digests of a queued ticket were acknowledged
+// to the client.  This call satisfies the backend interface.
+func (s *Sessions) PutTicket(t backend.Ticket) error {
+	if s.readOnly() {
+		return backend.ErrReadOnly
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	now := s.now().Unix()
+	if now-s.ticketsPurged >= ticketPurgeInterval {
+		purged, err := s.purgeTickets()
+		if err != nil {
+			return err
+		}
+		if purged != 0 {
+			log.Infof("Tickets: purged %v expired tickets", purged)
+		}
+		s.ticketsPurged = now
+	}
+
+	payload, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	batch := new(leveldb.Batch)
+	batch.Put(s.ticketKey(t.ID), payload)
+	if t.Processed == 0 {
+		batch.Put(s.ticketQueueKey(t), nil)
+	} else {
+		batch.Delete(s.ticketQueueKey(t))
+	}
+	return s.db.Write(batch, syncWrite)
+}
+
+// GetTicket returns the ticket with the provided ID.  This call satisfies the
+// backend interface.
+func (s *Sessions) GetTicket(id string) (*backend.Ticket, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	return s.getTicket(id)
+}
+
+// QueuedTickets returns up to n tickets that were not processed yet, oldest
+// first.  This call satisfies the backend interface.
+func (s *Sessions) QueuedTickets(n int) ([]backend.Ticket, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	var tickets []backend.Ticket
+	prefix := []byte(s.prefix + ticketQueuePrefix)
+	i := s.db.NewIterator(util.BytesPrefix(prefix), nil)
+	defer i.Release()
+	for len(tickets) < n && i.Next() {
+		key := i.Key()
+		if len(key) < len(prefix)+8 {
+			return nil, errInvalidDB
+		}
+		t, err := s.getTicket(string(key[len(prefix)+8:]))
+		if err != nil {
+			return nil, err
+		}
+		tickets = append(tickets, *t)
+	}
+	if err := i.Error(); err != nil {
+		return nil, err
+	}
+
+	return tickets, nil
+}
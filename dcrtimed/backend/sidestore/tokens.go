@@ -0,0 +1,193 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package sidestore
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"sync"
+
+	"github.com/decred/dcrtime/dcrtimed/backend"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// Tokens stores api tokens under the digest of the token: prefix | digest.
+type Tokens struct {
+	mtx    sync.Mutex  // Serializes api token updates
+	db     *leveldb.DB // Database the tokens are kept in
+	prefix string      // Prefix of all keys
+}
+
+// NewTokens returns the api token store that keeps its keys under the
+// provided prefix of the database.
+func NewTokens(db *leveldb.DB, prefix string) *Tokens {
+	return &Tokens{
+		db:     db,
+		prefix: prefix,
+	}
+}
+
+// key returns the key of the api token with the provided digest.
+func (t *Tokens) key(hash [sha256.Size]byte) []byte {
+	return append([]byte(t.prefix), hash[:]...)
+}
+
+// getToken returns the api token that is stored under the provided digest.
+func (t *Tokens) getToken(hash [sha256.Size]byte) (*backend.APIToken, error) {
+	payload, err := t.db.Get(t.key(hash), nil)
+	if err == leveldb.ErrNotFound {
+		return nil, backend.ErrTokenNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	var token backend.APIToken
+	err = json.Unmarshal(payload, &token)
+	if err != nil {
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+// putToken stores the api token under the provided digest.
+func (t *Tokens) putToken(hash [sha256.Size]byte, token backend.APIToken) error {
+	payload, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+
+	return t.db.Put(t.key(hash), payload, nil)
+}
+
+// PutToken stores an api token under the digest of the token.  This call
+// satisfies the backend interface.
+func (t *Tokens) PutToken(hash [sha256.Size]byte, token backend.APIToken) error {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	return t.putToken(hash, token)
+}
+
+// GetToken returns the api token that is stored under the provided digest.
+// This call satisfies the backend interface.
+func (t *Tokens) GetToken(hash [sha256.Size]byte) (*backend.APIToken, error) {
+	return t.getToken(hash)
+}
+
+// GetTokens returns all api tokens.  This call satisfies the backend
+// interface.
+func (t *Tokens) GetTokens() ([]backend.APIToken, error) {
+	tokens := make([]backend.APIToken, 0, 16)
+
+	i := t.db.NewIterator(util.BytesPrefix([]byte(t.prefix)), nil)
+	defer i.Release()
+	for i.Next() {
+		var token backend.APIToken
+		err := json.Unmarshal(i.Value(), &token)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+
+	return tokens, i.Error()
+}
+
+// UseToken increments the usage counter of the api token that is stored under
+// the provided digest.  This call satisfies the backend interface.
+func (t *Tokens) UseToken(hash [sha256.Size]byte, ts int64) error {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	token, err := t.getToken(hash)
+	if err != nil {
+		return err
+	}
+	token.Uses++
+	token.LastUsed = ts
+
+	return t.putToken(hash, *token)
+}
+
+// ChargeToken charges digests to the usage counters of the api token that is
+// stored under the provided digest.  This call satisfies the backend
+// interface.
+func (t *Tokens) ChargeToken(hash [sha256.Size]byte, digests, ts int64) (*backend.APIToken, error) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	token, err := t.getToken(hash)
+	if err != nil {
+		return nil, err
+	}
+	token.RollUsage(ts)
+
+	if digests > 0 {
+		if token.DailyQuota != 0 &&
+			token.DayDigests+digests > token.DailyQuota {
+			return nil, backend.ErrQuotaExceeded
+		}
+		if token.MonthlyQuota != 0 &&
+			token.MonthDigests+digests > token.MonthlyQuota {
+			return nil, backend.ErrQuotaExceeded
+		}
+	}
+
+	// Refunds never drive the counters below zero, e.g. when the period
+	// of the charge ended in between.
+	token.DayDigests += digests
+	if token.DayDigests < 0 {
+		token.DayDigests = 0
+	}
+	token.MonthDigests += digests
+	if token.MonthDigests < 0 {
+		token.MonthDigests = 0
+	}
+	switch {
+	case digests >= 0:
+		token.Digests += uint64(digests)
+	case uint64(-digests) < token.Digests:
+		token.Digests -= uint64(-digests)
+	default:
+		token.Digests = 0
+	}
+
+	err = t.putToken(hash, *token)
+	if err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// DeleteToken revokes the api token with the provided ID.  This call
+// satisfies the backend interface.
+func (t *Tokens) DeleteToken(id string) error {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	i := t.db.NewIterator(util.BytesPrefix([]byte(t.prefix)), nil)
+	defer i.Release()
+	for i.Next() {
+		var token backend.APIToken
+		err := json.Unmarshal(i.Value(), &token)
+		if err != nil {
+			return err
+		}
+		if token.ID != id {
+			continue
+		}
+
+		// Copy key since it is only valid until the next iteration.
+		key := append([]byte{}, i.Key()...)
+		return t.db.Delete(key, nil)
+	}
+	if err := i.Error(); err != nil {
+		return err
+	}
+
+	return backend.ErrTokenNotFound
+}
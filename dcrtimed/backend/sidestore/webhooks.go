@@ -0,0 +1,239 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package sidestore
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"sync"
+
+	"github.com/decred/dcrtime/dcrtimed/backend"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+const (
+	// deliveryPrefix prefixes the keys of webhook deliveries.
+	deliveryPrefix = "delivery/"
+
+	// subscriptionPrefix prefixes the keys of webhook subscriptions.
+	subscriptionPrefix = "subscription/"
+
+	// deliveryHeightKey is the key of the block height up to which
+	// anchors were notified.
+	deliveryHeightKey = "height"
+)
+
+// Webhooks stores webhook deliveries and subscriptions.
+type Webhooks struct {
+	mtx    sync.Mutex  // Serializes webhook delivery updates
+	db     *leveldb.DB // Database the webhooks are kept in
+	prefix string      // Prefix of all keys
+}
+
+// NewWebhooks returns the webhook store that keeps its keys under the
+// provided prefix of the database.
+func NewWebhooks(db *leveldb.DB, prefix string) *Webhooks {
+	return &Webhooks{
+		db:     db,
+		prefix: prefix,
+	}
+}
+
+// deliveryKey returns the key of the webhook delivery with the provided ID.
+func (w *Webhooks) deliveryKey(id string) []byte {
+	return []byte(w.prefix + deliveryPrefix + id)
+}
+
+// subscriptionKey returns the key of the webhook subscription with the
+// provided ID.
+func (w *Webhooks) subscriptionKey(id string) []byte {
+	return []byte(w.prefix + subscriptionPrefix + id)
+}
+
+// getDelivery returns the webhook delivery with the provided ID.
+func (w *Webhooks) getDelivery(id string) (*backend.Delivery, error) {
+	payload, err := w.db.Get(w.deliveryKey(id), nil)
+	if err == leveldb.ErrNotFound {
+		return nil, backend.ErrDeliveryNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	var delivery backend.Delivery
+	err = json.Unmarshal(payload, &delivery)
+	if err != nil {
+		return nil, err
+	}
+
+	return &delivery, nil
+}
+
+// PutDeliveries atomically stores new webhook deliveries together with the
+// block height up to which anchors were notified.  This call satisfies the
+// backend interface.
+func (w *Webhooks) PutDeliveries(deliveries []backend.Delivery, height int32) error {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	batch := new(leveldb.Batch)
+	if err := w.putDeliveries(batch, deliveries); err != nil {
+		return err
+	}
+	var h [4]byte
+	binary.LittleEndian.PutUint32(h[:], uint32(height))
+	batch.Put([]byte(w.prefix+deliveryHeightKey), h[:])
+
+	return w.db.Write(batch, nil)
+}
+
+// GetDeliveryHeight returns the block height up to which anchors were
+// notified or -1 if no deliveries were ever stored.  This call satisfies the
+// backend interface.
+func (w *Webhooks) GetDeliveryHeight() (int32, error) {
+	h, err := w.db.Get([]byte(w.prefix+deliveryHeightKey), nil)
+	if err == leveldb.ErrNotFound {
+		return -1, nil
+	} else if err != nil {
+		return 0, err
+	}
+	if len(h) != 4 {
+		return 0, errInvalidDB
+	}
+
+	return int32(binary.LittleEndian.Uint32(h)), nil
+}
+
+// GetDelivery returns the webhook delivery with the provided ID.  This call
+// satisfies the backend interface.
+func (w *Webhooks) GetDelivery(id string) (*backend.Delivery, error) {
+	return w.getDelivery(id)
+}
+
+// GetDeliveries returns all pending and dead webhook deliveries ordered by
+// ID.  This call satisfies the backend interface.
+func (w *Webhooks) GetDeliveries() ([]backend.Delivery, error) {
+	deliveries := make([]backend.Delivery, 0, 16)
+
+	i := w.db.NewIterator(util.BytesPrefix(w.deliveryKey("")), nil)
+	defer i.Release()
+	for i.Next() {
+		var delivery backend.Delivery
+		err := json.Unmarshal(i.Value(), &delivery)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+
+	return deliveries, i.Error()
+}
+
+// UpdateDelivery overwrites an existing webhook delivery.  This call
+// satisfies the backend interface.
+func (w *Webhooks) UpdateDelivery(delivery backend.Delivery) error {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	_, err := w.getDelivery(delivery.ID)
+	if err != nil {
+		return err
+	}
+	payload, err := json.Marshal(delivery)
+	if err != nil {
+		return err
+	}
+
+	return w.db.Put(w.deliveryKey(delivery.ID), payload, nil)
+}
+
+// DeleteDelivery removes the webhook delivery with the provided ID.  This
+// call satisfies the backend interface.
+func (w *Webhooks) DeleteDelivery(id string) error {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	_, err := w.getDelivery(id)
+	if err != nil {
+		return err
+	}
+
+	return w.db.Delete(w.deliveryKey(id), nil)
+}
+
+// putDeliveries adds the provided webhook deliveries to the batch.
+func (w *Webhooks) putDeliveries(batch *leveldb.Batch, deliveries []backend.Delivery) error {
+	for _, delivery := range deliveries {
+		payload, err := json.Marshal(delivery)
+		if err != nil {
+			return err
+		}
+		batch.Put(w.deliveryKey(delivery.ID), payload)
+	}
+	return nil
+}
+
+// PutSubscription atomically stores a webhook subscription together with the
+// deliveries that were enqueued for it.  This call satisfies the backend
+// interface.
+func (w *Webhooks) PutSubscription(sub backend.Subscription, deliveries []backend.Delivery) error {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	batch := new(leveldb.Batch)
+	if err := w.putDeliveries(batch, deliveries); err != nil {
+		return err
+	}
+	payload, err := json.Marshal(sub)
+	if err != nil {
+		return err
+	}
+	batch.Put(w.subscriptionKey(sub.ID), payload)
+
+	return w.db.Write(batch, nil)
+}
+
+// GetSubscriptions returns all webhook subscriptions ordered by ID.  This call
+// satisfies the backend interface.
+func (w *Webhooks) GetSubscriptions() ([]backend.Subscription, error) {
+	subs := make([]backend.Subscription, 0, 16)
+
+	i := w.db.NewIterator(util.BytesPrefix(w.subscriptionKey("")), nil)
+	defer i.Release()
+	for i.Next() {
+		var sub backend.Subscription
+		err := json.Unmarshal(i.Value(), &sub)
+		if err != nil {
+			return nil, err
+		}
+		subs = append(subs, sub)
+	}
+
+	return subs, i.Error()
+}
+
+// DeleteSubscription atomically removes a webhook subscription together with
+// storing the deliveries that were enqueued for it last.  This call satisfies
+// the backend interface.
+func (w *Webhooks) DeleteSubscription(id string, deliveries []backend.Delivery) error {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	key := w.subscriptionKey(id)
+	_, err := w.db.Get(key, nil)
+	if err == leveldb.ErrNotFound {
+		return backend.ErrSubscriptionNotFound
+	} else if err != nil {
+		return err
+	}
+
+	batch := new(leveldb.Batch)
+	if err := w.putDeliveries(batch, deliveries); err != nil {
+		return err
+	}
+	batch.Delete(key)
+
+	return w.db.Write(batch, nil)
+}
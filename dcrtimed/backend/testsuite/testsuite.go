@@ -0,0 +1,2283 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+// Package testsuite provides a conformance test suite for implementations of
+// the backend.Backend interface.
+//
+// A backend is exercised through a Harness, which lets the suite control the
+// collection window clock and the anchoring of closed collections.  Backends
+// anchor through the provided Wallet, which is also used as a dcrdata stand
+// in during Fsck.  Call Run from a regular test:
+//
+//	func TestConformance(t *testing.T) {
+//		testsuite.Run(t, func(t *testing.T, w *testsuite.Wallet) testsuite.Harness {
+//			return newHarness(t, w)
+//		})
+//	}
+package testsuite
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"math"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/decred/dcrd/chaincfg/chainhash"
+	"github.com/decred/dcrtime/checkpoint"
+	"github.com/decred/dcrtime/dcrtimed/backend"
+	"github.com/decred/dcrtime/merkle"
+)
+
+// Harness controls a backend under test.  Every subtest uses a new harness
+// with empty storage.  The backend must have collections enabled, must require
+// at least one confirmation and must allow at least 10 digests to be returned
+// by LastDigests.
+type Harness interface {
+	// Open returns a backend that is backed by the harness storage and
+	// anchors through the Wallet that was passed to the harness
+	// constructor.  The suite closes the backend and opens it again to
+	// simulate a restart after a crash.  Open must therefore reconcile
+	// closed collections that were not anchored yet, like it does on
+	// startup.
+	Open(t *testing.T) backend.Backend
+
+	// OpenRestore switches the harness to new, empty storage and returns a
+	// backend that is only used to Restore into it.  Later calls to Open
+	// use the restored storage.
+	OpenRestore(t *testing.T) backend.Backend
+
+	// OpenStandby switches the harness to new, empty storage and returns
+	// a read-only backend that replicated collections are applied to.
+	// Later calls to Open use the replicated storage.
+	OpenStandby(t *testing.T) backend.Backend
+
+	// Advance moves the clock of the backend into the next collection
+	// window.
+	Advance(t *testing.T)
+
+	// Flush anchors all closed collections of the open backend.  It may
+	// run concurrently with readers of the backend.
+	Flush(t *testing.T)
+}
+
+// Partial is implemented by harnesses of backends that only implement part of
+// the backend.Backend interface, such as an in-memory backend for tests.
+type Partial interface {
+	// Supports returns whether the backend implements the features that
+	// are exercised by the named test of Run.
+	Supports(test string) bool
+}
+
+// Run runs all conformance tests against the backends returned by
+// newHarness.  Tests that a Partial harness does not support are skipped.
+func Run(t *testing.T, newHarness func(*testing.T, *Wallet) Harness) {
+	tests := []struct {
+		name string
+		f    func(*testing.T, *Wallet, Harness)
+	}{
+		{"PutGet", testPutGet},
+		{"DuplicateDigests", testDuplicateDigests},
+		{"WindowRollover", testWindowRollover},
+		{"Flush", testFlush},
+		{"FlushDuringReads", testFlushDuringReads},
+		{"GetTimestamps", testGetTimestamps},
+		{"LastDigests", testLastDigests},
+		{"GetLabel", testGetLabel},
+		{"Algorithm", testAlgorithm},
+		{"PreviewWindow", testPreviewWindow},
+		{"LastAnchor", testLastAnchor},
+		{"GetAnchors", testGetAnchors},
+		{"GetBalance", testGetBalance},
+		{"Status", testStatus},
+		{"Tokens", testTokens},
+		{"Quota", testQuota},
+		{"Webhooks", testWebhooks},
+		{"Subscriptions", testSubscriptions},
+		{"ProofRecords", testProofRecords},
+		{"Metadata", testMetadata},
+		{"CollectionStats", testCollectionStats},
+		{"ExportCollections", testExportCollections},
+		{"Replication", testReplication},
+		{"AnchorChain", testAnchorChain},
+		{"AnchorBlock", testAnchorBlock},
+		{"SampleAnchored", testSampleAnchored},
+		{"Collections", testCollections},
+		{"SearchDigests", testSearchDigests},
+		{"Sessions", testSessions},
+		{"Tickets", testTickets},
+		{"CrashRecovery", testCrashRecovery},
+		{"DumpRestore", testDumpRestore},
+		{"Fsck", testFsck},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			w := NewWallet()
+			h := newHarness(t, w)
+			if p, ok := h.(Partial); ok && !p.Supports(test.name) {
+				t.Skip("not supported by the backend")
+			}
+			test.f(t, w, h)
+		})
+	}
+}
+
+// digests returns n distinct digests that are derived from seed.
+func digests(seed string, n int) [][sha256.Size]byte {
+	d := make([][sha256.Size]byte, 0, n)
+	for i := 0; i < n; i++ {
+		d = append(d, sha256.Sum256([]byte(seed+string(rune('a'+i)))))
+	}
+	return d
+}
+
+// put stores the digests and requires every digest to be accepted.  It
+// returns the collection timestamp.
+func put(t *testing.T, b backend.Backend, d [][sha256.Size]byte, label string) int64 {
+	t.Helper()
+
+	ts, prs, err := b.Put(d, label, "")
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if len(prs) != len(d) {
+		t.Fatalf("Put: got %v results, want %v", len(prs), len(d))
+	}
+	for i, pr := range prs {
+		if pr.Digest != d[i] {
+			t.Fatalf("Put: got digest %x, want %x", pr.Digest, d[i])
+		}
+		if pr.ErrorCode != backend.ErrorOK {
+			t.Fatalf("Put %x: got error code %v, want %v", d[i],
+				pr.ErrorCode, backend.ErrorOK)
+		}
+	}
+	if ts == 0 {
+		t.Fatalf("Put: no collection timestamp")
+	}
+	return ts
+}
+
+// get returns the results of the digests and requires every digest to be
+// found.
+func get(t *testing.T, b backend.Backend, d [][sha256.Size]byte) []backend.GetResult {
+	t.Helper()
+
+	grs, err := b.Get(d)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if len(grs) != len(d) {
+		t.Fatalf("Get: got %v results, want %v", len(grs), len(d))
+	}
+	for i, gr := range grs {
+		if gr.Digest != d[i] {
+			t.Fatalf("Get: got digest %x, want %x", gr.Digest, d[i])
+		}
+		if gr.ErrorCode != backend.ErrorOK {
+			t.Fatalf("Get %x: got error code %v, want %v", d[i],
+				gr.ErrorCode, backend.ErrorOK)
+		}
+	}
+	return grs
+}
+
+// requireAnchored verifies that the result carries a valid merkle path to the
+// root that was anchored in tx.
+func requireAnchored(t *testing.T, gr backend.GetResult, tx chainhash.Hash) {
+	t.Helper()
+
+	if gr.Tx != tx {
+		t.Fatalf("%x: got tx %v, want %v", gr.Digest, gr.Tx, tx)
+	}
+	root, err := merkle.VerifyAuthPath(&gr.MerklePath)
+	if err != nil {
+		t.Fatalf("%x: invalid merkle path: %v", gr.Digest, err)
+	}
+	if *root != gr.MerkleRoot {
+		t.Fatalf("%x: got merkle root %x, want %x", gr.Digest,
+			gr.MerkleRoot, *root)
+	}
+	if gr.MerklePath.Hashes == nil || gr.FlushTimestamp == 0 {
+		t.Fatalf("%x: incomplete anchor information", gr.Digest)
+	}
+}
+
+// sorted returns a sorted copy of the digests.
+func sorted(d [][sha256.Size]byte) [][sha256.Size]byte {
+	s := append([][sha256.Size]byte(nil), d...)
+	sort.Slice(s, func(i, j int) bool {
+		return bytes.Compare(s[i][:], s[j][:]) < 0
+	})
+	return s
+}
+
+// requireDigests verifies that got and want contain the same digests in any
+// order.
+func requireDigests(t *testing.T, got, want [][sha256.Size]byte) {
+	t.Helper()
+
+	got, want = sorted(got), sorted(want)
+	if len(got) != len(want) {
+		t.Fatalf("got %v digests, want %v", len(got), len(want))
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Fatalf("got digest %x, want %x", got[i], want[i])
+		}
+	}
+}
+
+func testPutGet(t *testing.T, w *Wallet, h Harness) {
+	b := h.Open(t)
+	defer b.Close()
+
+	d := digests("putget", 10)
+	put(t, b, d, "")
+	for _, gr := range get(t, b, d) {
+		if gr.AnchoredTimestamp != 0 {
+			t.Fatalf("%x: anchored before flush", gr.Digest)
+		}
+	}
+
+	// Unknown digests are reported individually.
+	unknown := digests("unknown", 1)[0]
+	grs, err := b.Get([][sha256.Size]byte{d[0], unknown})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(grs) != 2 {
+		t.Fatalf("got %v results, want 2", len(grs))
+	}
+	if grs[0].ErrorCode != backend.ErrorOK {
+		t.Fatalf("got error code %v, want %v", grs[0].ErrorCode,
+			backend.ErrorOK)
+	}
+	if grs[1].Digest != unknown ||
+		grs[1].ErrorCode != backend.ErrorNotFound {
+		t.Fatalf("got error code %v, want %v", grs[1].ErrorCode,
+			backend.ErrorNotFound)
+	}
+}
+
+func testDuplicateDigests(t *testing.T, w *Wallet, h Harness) {
+	b := h.Open(t)
+	defer b.Close()
+
+	requireExists := func(d [sha256.Size]byte) {
+		t.Helper()
+
+		_, prs, err := b.Put([][sha256.Size]byte{d}, "", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(prs) != 1 || prs[0].ErrorCode != backend.ErrorExists {
+			t.Fatalf("got %v, want error code %v", prs,
+				backend.ErrorExists)
+		}
+	}
+
+	// Duplicates within a batch are only stored once.
+	d := digests("dup", 3)
+	_, prs, err := b.Put(append(d, d[0]), "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(prs) != len(d)+1 {
+		t.Fatalf("got %v results, want %v", len(prs), len(d)+1)
+	}
+
+	// Duplicates in the current collection.
+	requireExists(d[0])
+
+	// Duplicates in a closed collection that was not anchored yet.
+	h.Advance(t)
+	requireExists(d[1])
+
+	// Duplicates in an anchored collection.
+	h.Flush(t)
+	requireExists(d[2])
+
+	grs, err := b.GetTimestamps([]int64{get(t, b, d)[0].Timestamp})
+	if err != nil {
+		t.Fatal(err)
+	}
+	requireDigests(t, grs[0].Digests, d)
+}
+
+func testWindowRollover(t *testing.T, w *Wallet, h Harness) {
+	b := h.Open(t)
+	defer b.Close()
+
+	d1 := digests("window1", 5)
+	ts1 := put(t, b, d1, "")
+	h.Advance(t)
+	d2 := digests("window2", 5)
+	ts2 := put(t, b, d2, "")
+	if ts2 <= ts1 {
+		t.Fatalf("collection timestamp did not advance: %v %v", ts1, ts2)
+	}
+
+	// Both collections are still retrievable.
+	get(t, b, append(d1, d2...))
+
+	// Only the closed collection is anchored.
+	h.Flush(t)
+	if w.Anchors() != 1 {
+		t.Fatalf("got %v anchors, want 1", w.Anchors())
+	}
+	for _, gr := range get(t, b, d1) {
+		if gr.Timestamp != ts1 {
+			t.Fatalf("%x: got timestamp %v, want %v", gr.Digest,
+				gr.Timestamp, ts1)
+		}
+	}
+	for _, gr := range get(t, b, d2) {
+		if gr.Tx != (chainhash.Hash{}) {
+			t.Fatalf("%x: current collection anchored", gr.Digest)
+		}
+	}
+}
+
+func testFlush(t *testing.T, w *Wallet, h Harness) {
+	b := h.Open(t)
+	defer b.Close()
+
+	d := digests("flush", 7)
+	put(t, b, d, "")
+	h.Advance(t)
+	h.Flush(t)
+
+	// Flushing again must not anchor the collection twice.
+	h.Flush(t)
+	if w.Anchors() != 1 {
+		t.Fatalf("got %v anchors, want 1", w.Anchors())
+	}
+
+	// Not enough confirmations yet.
+	grs := get(t, b, d)
+	tx := grs[0].Tx
+	for _, gr := range grs {
+		requireAnchored(t, gr, tx)
+		if gr.AnchoredTimestamp != 0 {
+			t.Fatalf("%x: anchored without confirmations",
+				gr.Digest)
+		}
+		if gr.Confirmations == nil || *gr.Confirmations != 0 {
+			t.Fatalf("%x: got confirmations %v, want 0", gr.Digest,
+				gr.Confirmations)
+		}
+	}
+
+	// Confirmed anchors carry the chain timestamp.
+	w.SetConfirmations(grs[0].MinConfirmations)
+	for _, gr := range get(t, b, d) {
+		requireAnchored(t, gr, tx)
+		if gr.AnchoredTimestamp == 0 {
+			t.Fatalf("%x: not anchored", gr.Digest)
+		}
+	}
+}
+
+func testFlushDuringReads(t *testing.T, w *Wallet, h Harness) {
+	b := h.Open(t)
+	defer b.Close()
+
+	d := digests("reads", 20)
+	put(t, b, d, "")
+	h.Advance(t)
+
+	// A reader must observe every digest while it moves from the closed
+	// collection to the anchored one.
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			grs, err := b.Get(d)
+			if err != nil {
+				t.Errorf("Get: %v", err)
+				return
+			}
+			for _, gr := range grs {
+				if gr.ErrorCode != backend.ErrorOK {
+					t.Errorf("%x: got error code %v",
+						gr.Digest, gr.ErrorCode)
+					return
+				}
+			}
+		}
+	}()
+	h.Flush(t)
+	close(done)
+	wg.Wait()
+}
+
+func testGetTimestamps(t *testing.T, w *Wallet, h Harness) {
+	b := h.Open(t)
+	defer b.Close()
+
+	d1 := digests("collection1", 4)
+	ts1 := put(t, b, d1, "")
+	h.Advance(t)
+	h.Flush(t)
+	d2 := digests("collection2", 3)
+	ts2 := put(t, b, d2, "")
+
+	trs, err := b.GetTimestamps([]int64{ts1, ts2, ts2 + 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trs) != 3 {
+		t.Fatalf("got %v results, want 3", len(trs))
+	}
+	for i, want := range []uint{backend.ErrorOK, backend.ErrorOK,
+		backend.ErrorNotFound} {
+		if trs[i].ErrorCode != want {
+			t.Fatalf("timestamp %v: got error code %v, want %v",
+				trs[i].Timestamp, trs[i].ErrorCode, want)
+		}
+	}
+
+	// Anchored collection.
+	requireDigests(t, trs[0].Digests, d1)
+	hashes := make([]*[sha256.Size]byte, 0, len(trs[0].Digests))
+	for i := range trs[0].Digests {
+		hashes = append(hashes, &trs[0].Digests[i])
+	}
+	if *merkle.Root(hashes) != trs[0].MerkleRoot {
+		t.Fatalf("invalid merkle root %x", trs[0].MerkleRoot)
+	}
+	if trs[0].Tx == (chainhash.Hash{}) {
+		t.Fatalf("collection %v not anchored", ts1)
+	}
+
+	// Current collection.
+	requireDigests(t, trs[1].Digests, d2)
+}
+
+func testPreviewWindow(t *testing.T, w *Wallet, h Harness) {
+	b := h.Open(t)
+	defer b.Close()
+
+	wr, err := b.PreviewWindow("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wr.Digests != 0 || wr.MerkleRoot != ([sha256.Size]byte{}) {
+		t.Fatalf("empty window: got %+v", wr)
+	}
+	if wr.Ends <= wr.Timestamp {
+		t.Fatalf("window ends %v before it starts %v", wr.Ends,
+			wr.Timestamp)
+	}
+
+	d := digests("preview", 5)
+	ts := put(t, b, d[:3], "")
+	put(t, b, d[3:], "")
+	wr, err = b.PreviewWindow("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wr.Timestamp != ts || wr.Digests != len(d) {
+		t.Fatalf("got %+v, want timestamp %v and %v digests", wr, ts,
+			len(d))
+	}
+	ends, endHeight, err := b.WindowEnd(ts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ends != wr.Ends || endHeight != wr.EndHeight {
+		t.Fatalf("window end: got %v and height %v, want %v and "+
+			"height %v", ends, endHeight, wr.Ends, wr.EndHeight)
+	}
+
+	// The preview is the merkle root the collection is anchored with.
+	h.Advance(t)
+	h.Flush(t)
+	trs, err := b.GetTimestamps([]int64{ts})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if trs[0].Tx == (chainhash.Hash{}) {
+		t.Fatalf("collection %v not anchored", ts)
+	}
+	if trs[0].MerkleRoot != wr.MerkleRoot {
+		t.Fatalf("got merkle root %x, previewed %x",
+			trs[0].MerkleRoot, wr.MerkleRoot)
+	}
+
+	// The next window is empty.
+	next, err := b.PreviewWindow("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next.Timestamp <= ts || next.Digests != 0 {
+		t.Fatalf("next window: got %+v", next)
+	}
+}
+
+func testLastDigests(t *testing.T, w *Wallet, h Harness) {
+	b := h.Open(t)
+	defer b.Close()
+
+	grs, err := b.LastDigests(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(grs) != 0 {
+		t.Fatalf("got %v digests, want 0", len(grs))
+	}
+
+	d := digests("last", 6)
+	put(t, b, d[:3], "")
+	h.Advance(t)
+	put(t, b, d[3:], "")
+
+	grs, err = b.LastDigests(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(grs) != 4 {
+		t.Fatalf("got %v digests, want 4", len(grs))
+	}
+	got := make([][sha256.Size]byte, 0, len(grs))
+	for _, gr := range grs {
+		got = append(got, gr.Digest)
+	}
+
+	// The newest collection is returned first.
+	requireDigests(t, got[:3], d[3:])
+}
+
+func testGetLabel(t *testing.T, w *Wallet, h Harness) {
+	b := h.Open(t)
+	defer b.Close()
+
+	labeled := digests("labeled", 3)
+	put(t, b, labeled, "group-1")
+	put(t, b, digests("unlabeled", 3), "")
+	h.Advance(t)
+	more := digests("labeled-later", 2)
+	put(t, b, more, "group-1")
+	put(t, b, digests("other", 2), "group-2")
+
+	requireLabel := func() {
+		t.Helper()
+
+		grs, err := b.GetLabel("group-1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := make([][sha256.Size]byte, 0, len(grs))
+		for _, gr := range grs {
+			if gr.Label != "group-1" {
+				t.Fatalf("%x: got label %q", gr.Digest, gr.Label)
+			}
+			got = append(got, gr.Digest)
+		}
+		requireDigests(t, got, append(labeled, more...))
+	}
+	requireLabel()
+
+	// Labels survive anchoring.
+	h.Flush(t)
+	requireLabel()
+
+	grs, err := b.GetLabel("unknown")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(grs) != 0 {
+		t.Fatalf("got %v digests, want 0", len(grs))
+	}
+}
+
+func testAlgorithm(t *testing.T, w *Wallet, h Harness) {
+	b := h.Open(t)
+	defer b.Close()
+
+	sha := digests("sha256", 2)
+	put(t, b, sha, "")
+	blake := digests("blake2b", 2)
+	_, prs, err := b.Put(blake, "group-1", "blake2b-256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, pr := range prs {
+		if pr.ErrorCode != backend.ErrorOK {
+			t.Fatalf("Put %x: got error code %v", pr.Digest,
+				pr.ErrorCode)
+		}
+	}
+
+	requireAlgorithm := func() {
+		t.Helper()
+
+		for _, gr := range get(t, b, sha) {
+			if gr.Algorithm != "" {
+				t.Fatalf("%x: got algorithm %q, want none",
+					gr.Digest, gr.Algorithm)
+			}
+		}
+		for _, gr := range get(t, b, blake) {
+			if gr.Algorithm != "blake2b-256" {
+				t.Fatalf("%x: got algorithm %q", gr.Digest,
+					gr.Algorithm)
+			}
+			if gr.Label != "group-1" {
+				t.Fatalf("%x: got label %q", gr.Digest, gr.Label)
+			}
+		}
+	}
+	requireAlgorithm()
+
+	// Algorithms survive anchoring.
+	h.Flush(t)
+	requireAlgorithm()
+}
+
+func testLastAnchor(t *testing.T, w *Wallet, h Harness) {
+	b := h.Open(t)
+	defer b.Close()
+
+	la, err := b.LastAnchor()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if la.Tx != (chainhash.Hash{}) {
+		t.Fatalf("got anchor %v before flush", la.Tx)
+	}
+
+	d := digests("anchor", 2)
+	put(t, b, d, "")
+	h.Advance(t)
+	h.Flush(t)
+	tx := get(t, b, d)[0].Tx
+
+	la, err = b.LastAnchor()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if la.Tx != tx {
+		t.Fatalf("got anchor %v, want %v", la.Tx, tx)
+	}
+}
+
+func testGetAnchors(t *testing.T, w *Wallet, h Harness) {
+	b := h.Open(t)
+	defer b.Close()
+
+	// The wallet anchors in consecutive blocks starting at height 1.
+	first := digests("height-1", 3)
+	put(t, b, first, "")
+	h.Advance(t)
+	h.Flush(t)
+	second := digests("height-2", 2)
+	put(t, b, second, "")
+	h.Advance(t)
+	h.Flush(t)
+
+	// Anchors without enough confirmations have no block height yet.
+	ars, err := b.GetAnchors(0, math.MaxInt32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ars) != 0 {
+		t.Fatalf("got %v unconfirmed anchors, want 0", len(ars))
+	}
+
+	grs := get(t, b, first)
+	w.SetConfirmations(grs[0].MinConfirmations)
+	ars, err = b.GetAnchors(0, math.MaxInt32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ars) != 2 {
+		t.Fatalf("got %v anchors, want 2", len(ars))
+	}
+	if ars[0].BlockHeight != 1 || ars[0].Tx != grs[0].Tx ||
+		ars[0].MerkleRoot != grs[0].MerkleRoot ||
+		ars[0].ChainTimestamp == 0 {
+		t.Fatalf("unexpected first anchor %+v", ars[0])
+	}
+	requireDigests(t, ars[0].Digests, first)
+	requireDigests(t, ars[1].Digests, second)
+
+	ars, err = b.GetAnchors(2, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ars) != 1 || ars[0].BlockHeight != 2 {
+		t.Fatalf("got anchors %+v, want height 2", ars)
+	}
+	ars, err = b.GetAnchors(3, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ars) != 0 {
+		t.Fatalf("got %v anchors above the tip, want 0", len(ars))
+	}
+}
+
+func testGetBalance(t *testing.T, w *Wallet, h Harness) {
+	b := h.Open(t)
+	defer b.Close()
+
+	gbr, err := b.GetBalance()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gbr.Total != Balance.Total || gbr.Spendable != Balance.Spendable ||
+		gbr.Unconfirmed != Balance.Unconfirmed {
+		t.Fatalf("got balance %+v, want %+v", *gbr, Balance)
+	}
+}
+
+func testStatus(t *testing.T, w *Wallet, h Harness) {
+	b := h.Open(t)
+	defer b.Close()
+
+	sr, err := b.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sr.LastFlushTimestamp != 0 || sr.PendingDigests != 0 ||
+		sr.WalletError != nil {
+		t.Fatalf("got status %+v before put", *sr)
+	}
+
+	// Digests of the closed and the current collection are pending.
+	put(t, b, digests("status", 3), "")
+	h.Advance(t)
+	current := digests("status-current", 2)
+	put(t, b, current, "")
+	sr, err = b.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sr.PendingDigests != 5 || sr.LastFlushTimestamp != 0 {
+		t.Fatalf("got status %+v, want 5 pending digests", *sr)
+	}
+
+	h.Flush(t)
+	tx := get(t, b, digests("status", 1))[0].Tx
+	sr, err = b.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sr.PendingDigests != int64(len(current)) {
+		t.Fatalf("got %v pending digests, want %v", sr.PendingDigests,
+			len(current))
+	}
+	if sr.LastFlushTx != tx || sr.LastFlushTimestamp == 0 {
+		t.Fatalf("got last flush %v at %v, want %v", sr.LastFlushTx,
+			sr.LastFlushTimestamp, tx)
+	}
+}
+
+func testTokens(t *testing.T, w *Wallet, h Harness) {
+	b := h.Open(t)
+
+	admin := sha256.Sum256([]byte("admin"))
+	verify := sha256.Sum256([]byte("verify"))
+	tokens := map[[sha256.Size]byte]backend.APIToken{
+		admin: {
+			ID:      "0000000000000001",
+			Scopes:  []string{"admin"},
+			Created: 1,
+		},
+		verify: {
+			ID:          "0000000000000002",
+			Description: "verify only",
+			Scopes:      []string{"verify"},
+			Created:     2,
+			Expires:     3,
+		},
+	}
+	for hash, token := range tokens {
+		err := b.PutToken(hash, token)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err := b.GetToken(sha256.Sum256([]byte("nope")))
+	if !errors.Is(err, backend.ErrTokenNotFound) {
+		t.Fatalf("got %v, want %v", err, backend.ErrTokenNotFound)
+	}
+	err = b.UseToken(sha256.Sum256([]byte("nope")), 4)
+	if !errors.Is(err, backend.ErrTokenNotFound) {
+		t.Fatalf("got %v, want %v", err, backend.ErrTokenNotFound)
+	}
+
+	// Usage counters survive a restart.
+	for i := int64(1); i <= 3; i++ {
+		err = b.UseToken(admin, 10+i)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	b.Close()
+	b = h.Open(t)
+	defer b.Close()
+
+	token, err := b.GetToken(admin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.ID != tokens[admin].ID || token.Uses != 3 ||
+		token.LastUsed != 13 {
+		t.Fatalf("got token %+v", *token)
+	}
+	token, err = b.GetToken(verify)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.Description != "verify only" || token.Expires != 3 ||
+		len(token.Scopes) != 1 || token.Uses != 0 {
+		t.Fatalf("got token %+v", *token)
+	}
+
+	all, err := b.GetTokens()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 2 {
+		t.Fatalf("got %v tokens, want 2", len(all))
+	}
+
+	// Revoke.
+	err = b.DeleteToken(tokens[verify].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = b.DeleteToken(tokens[verify].ID)
+	if !errors.Is(err, backend.ErrTokenNotFound) {
+		t.Fatalf("got %v, want %v", err, backend.ErrTokenNotFound)
+	}
+	_, err = b.GetToken(verify)
+	if !errors.Is(err, backend.ErrTokenNotFound) {
+		t.Fatalf("got %v, want %v", err, backend.ErrTokenNotFound)
+	}
+	all, err = b.GetTokens()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 1 || all[0].ID != tokens[admin].ID {
+		t.Fatalf("got tokens %+v", all)
+	}
+}
+
+func testQuota(t *testing.T, w *Wallet, h Harness) {
+	b := h.Open(t)
+
+	hash := sha256.Sum256([]byte("metered"))
+	err := b.PutToken(hash, backend.APIToken{
+		ID:           "0000000000000003",
+		Scopes:       []string{"timestamp"},
+		DailyQuota:   10,
+		MonthlyQuota: 15,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = b.ChargeToken(sha256.Sum256([]byte("nope")), 1, 1)
+	if !errors.Is(err, backend.ErrTokenNotFound) {
+		t.Fatalf("got %v, want %v", err, backend.ErrTokenNotFound)
+	}
+
+	jan30 := time.Date(2020, 1, 30, 12, 0, 0, 0, time.UTC).Unix()
+	jan31 := time.Date(2020, 1, 31, 12, 0, 0, 0, time.UTC).Unix()
+	feb1 := time.Date(2020, 2, 1, 12, 0, 0, 0, time.UTC).Unix()
+	apr1 := time.Date(2020, 4, 1, 12, 0, 0, 0, time.UTC).Unix()
+	charge := func(digests, ts int64, want error) *backend.APIToken {
+		t.Helper()
+		token, err := b.ChargeToken(hash, digests, ts)
+		if !errors.Is(err, want) {
+			t.Fatalf("charge %v at %v: got %v, want %v", digests,
+				ts, err, want)
+		}
+		return token
+	}
+
+	// The daily quota is exceeded first, nothing is charged then.
+	charge(8, jan30, nil)
+	charge(3, jan30, backend.ErrQuotaExceeded)
+	token := charge(2, jan30, nil)
+	if token.DayDigests != 10 || token.MonthDigests != 10 ||
+		token.Digests != 10 {
+		t.Fatalf("got token %+v", *token)
+	}
+
+	// The next day starts over, the monthly quota still applies.
+	charge(6, jan31, backend.ErrQuotaExceeded)
+	token = charge(5, jan31, nil)
+	if token.DayDigests != 5 || token.MonthDigests != 15 {
+		t.Fatalf("got token %+v", *token)
+	}
+
+	// Refunds.
+	token = charge(-2, jan31, nil)
+	if token.DayDigests != 3 || token.MonthDigests != 13 ||
+		token.Digests != 13 {
+		t.Fatalf("got token %+v", *token)
+	}
+
+	// The next month starts over and keeps the previous month.  Counters
+	// survive a restart.
+	charge(4, feb1, nil)
+	b.Close()
+	b = h.Open(t)
+	defer b.Close()
+
+	token, err = b.GetToken(hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.DayDigests != 4 || token.MonthDigests != 4 ||
+		token.LastMonthDigests != 13 || token.Digests != 17 ||
+		token.Month != time.Date(2020, 2, 1, 0, 0, 0, 0,
+			time.UTC).Unix() {
+		t.Fatalf("got token %+v", *token)
+	}
+
+	// A month without digests in between is not kept.
+	token = charge(1, apr1, nil)
+	if token.MonthDigests != 1 || token.LastMonthDigests != 0 {
+		t.Fatalf("got token %+v", *token)
+	}
+}
+
+func testWebhooks(t *testing.T, w *Wallet, h Harness) {
+	b := h.Open(t)
+
+	height, err := b.GetDeliveryHeight()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if height != -1 {
+		t.Fatalf("got height %v, want -1", height)
+	}
+
+	deliveries := []backend.Delivery{
+		{
+			ID:          "0000000000000001",
+			URL:         "https://example.com/a",
+			Payload:     []byte(`{"a":1}`),
+			Created:     1,
+			NextAttempt: 1,
+		},
+		{
+			ID:          "0000000000000002",
+			URL:         "https://example.com/b",
+			Payload:     []byte(`{"b":2}`),
+			Created:     1,
+			NextAttempt: 1,
+		},
+	}
+	err = b.PutDeliveries(deliveries, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = b.GetDelivery("nope")
+	if !errors.Is(err, backend.ErrDeliveryNotFound) {
+		t.Fatalf("got %v, want %v", err, backend.ErrDeliveryNotFound)
+	}
+	err = b.UpdateDelivery(backend.Delivery{ID: "nope"})
+	if !errors.Is(err, backend.ErrDeliveryNotFound) {
+		t.Fatalf("got %v, want %v", err, backend.ErrDeliveryNotFound)
+	}
+
+	// Delivery state survives a restart.
+	dead := deliveries[1]
+	dead.Attempts = 3
+	dead.LastError = "503 Service Unavailable"
+	dead.Dead = true
+	err = b.UpdateDelivery(dead)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Close()
+	b = h.Open(t)
+	defer b.Close()
+
+	height, err = b.GetDeliveryHeight()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if height != 10 {
+		t.Fatalf("got height %v, want 10", height)
+	}
+	delivery, err := b.GetDelivery(dead.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !delivery.Dead || delivery.Attempts != 3 ||
+		delivery.LastError != dead.LastError ||
+		!bytes.Equal(delivery.Payload, dead.Payload) {
+		t.Fatalf("got delivery %+v", *delivery)
+	}
+	all, err := b.GetDeliveries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 2 || all[0].ID != deliveries[0].ID ||
+		all[1].ID != deliveries[1].ID {
+		t.Fatalf("got deliveries %+v", all)
+	}
+
+	// Acknowledge.
+	err = b.DeleteDelivery(deliveries[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = b.DeleteDelivery(deliveries[0].ID)
+	if !errors.Is(err, backend.ErrDeliveryNotFound) {
+		t.Fatalf("got %v, want %v", err, backend.ErrDeliveryNotFound)
+	}
+
+	// Advancing the height without deliveries keeps the dead letters.
+	err = b.PutDeliveries(nil, 12)
+	if err != nil {
+		t.Fatal(err)
+	}
+	height, err = b.GetDeliveryHeight()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if height != 12 {
+		t.Fatalf("got height %v, want 12", height)
+	}
+	all, err = b.GetDeliveries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 1 || all[0].ID != dead.ID {
+		t.Fatalf("got deliveries %+v", all)
+	}
+}
+
+func testSubscriptions(t *testing.T, w *Wallet, h Harness) {
+	b := h.Open(t)
+
+	subs := []backend.Subscription{
+		{
+			ID:        "0000000000000001",
+			Owner:     "owner",
+			URL:       "https://example.com/a",
+			Timestamp: 3600,
+			Digests:   []string{"aa", "bb"},
+			Created:   1,
+		},
+		{
+			ID:        "0000000000000002",
+			Owner:     "owner",
+			URL:       "https://example.com/b",
+			Timestamp: 7200,
+			Created:   2,
+		},
+	}
+	for _, sub := range subs {
+		if err := b.PutSubscription(sub, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Enqueued events are stored with the new state of the subscription.
+	included := subs[0]
+	included.Included = true
+	delivery := backend.Delivery{
+		ID:          "0000000000000003",
+		URL:         included.URL,
+		Payload:     []byte(`{"a":1}`),
+		Created:     3,
+		NextAttempt: 3,
+	}
+	err := b.PutSubscription(included, []backend.Delivery{delivery})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Subscriptions survive a restart.
+	b.Close()
+	b = h.Open(t)
+	defer b.Close()
+
+	all, err := b.GetSubscriptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 2 || !reflect.DeepEqual(all[0], included) ||
+		!reflect.DeepEqual(all[1], subs[1]) {
+		t.Fatalf("got subscriptions %+v", all)
+	}
+	if _, err := b.GetDelivery(delivery.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	// The final event removes the subscription.
+	final := delivery
+	final.ID = "0000000000000004"
+	err = b.DeleteSubscription(included.ID, []backend.Delivery{final})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.GetDelivery(final.ID); err != nil {
+		t.Fatal(err)
+	}
+	err = b.DeleteSubscription(included.ID, nil)
+	if !errors.Is(err, backend.ErrSubscriptionNotFound) {
+		t.Fatalf("got %v, want %v", err,
+			backend.ErrSubscriptionNotFound)
+	}
+	all, err = b.GetSubscriptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 1 || all[0].ID != subs[1].ID {
+		t.Fatalf("got subscriptions %+v", all)
+	}
+
+	// Subscriptions are not webhook deliveries.
+	deliveries, err := b.GetDeliveries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 2 {
+		t.Fatalf("got deliveries %+v", deliveries)
+	}
+}
+
+func testProofRecords(t *testing.T, w *Wallet, h Harness) {
+	b := h.Open(t)
+
+	d := digests("audited", 2)
+	records, err := b.GetProofRecords(d[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 0 {
+		t.Fatalf("got records %+v, want none", records)
+	}
+
+	// Records of one call and of later calls keep their order.
+	served := []backend.ProofRecord{
+		{
+			Digest:     d[0],
+			Timestamp:  1,
+			Token:      "-",
+			RemoteAddr: "127.0.0.1:1",
+			Route:      "/v2/verify",
+		},
+		{
+			Digest:     d[0],
+			Timestamp:  1,
+			Token:      "00000000000000aa",
+			RemoteAddr: "127.0.0.1:2",
+			RequestID:  "req",
+			Route:      "/v2/verify",
+			Formats:    []string{"ots"},
+		},
+	}
+	err = b.PutProofRecords(served)
+	if err != nil {
+		t.Fatal(err)
+	}
+	later := backend.ProofRecord{
+		Digest:         d[0],
+		Timestamp:      2,
+		Token:          "-",
+		RemoteAddr:     "127.0.0.1:3",
+		Route:          "/v2/verify/batch",
+		Tx:             chainhash.Hash{1},
+		MerkleRoot:     d[1],
+		ChainTimestamp: 2,
+	}
+	err = b.PutProofRecords([]backend.ProofRecord{later})
+	if err != nil {
+		t.Fatal(err)
+	}
+	served = append(served, later)
+
+	// The audit trail survives a restart.
+	b.Close()
+	b = h.Open(t)
+	defer b.Close()
+
+	records, err = b.GetProofRecords(d[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(records, served) {
+		t.Fatalf("got records %+v, want %+v", records, served)
+	}
+	records, err = b.GetProofRecords(d[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 0 {
+		t.Fatalf("got records %+v, want none", records)
+	}
+}
+
+func testMetadata(t *testing.T, w *Wallet, h Harness) {
+	b := h.Open(t)
+
+	d := digests("annotated", 3)
+	stored := []backend.Metadata{
+		{
+			Digest:  d[0],
+			Created: 1,
+			Values: map[string]string{
+				"filename": "report.pdf",
+				"mimetype": "application/pdf",
+			},
+		},
+		{
+			Digest:  d[1],
+			Owner:   "00000000000000aa",
+			Private: true,
+			Created: 1,
+			Values: map[string]string{
+				"externalid": "42",
+			},
+		},
+	}
+	if err := b.PutMetadata(stored); err != nil {
+		t.Fatal(err)
+	}
+
+	// Existing metadata is not replaced.
+	err := b.PutMetadata([]backend.Metadata{{
+		Digest:  d[0],
+		Created: 2,
+		Values: map[string]string{
+			"filename": "other.pdf",
+		},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Metadata survives a restart.
+	b.Close()
+	b = h.Open(t)
+	defer b.Close()
+
+	mds, err := b.GetMetadata(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mds) != len(d) {
+		t.Fatalf("got %v metadata, want %v", len(mds), len(d))
+	}
+	for k, want := range stored {
+		if mds[k] == nil || !reflect.DeepEqual(*mds[k], want) {
+			t.Fatalf("got metadata %+v, want %+v", mds[k], want)
+		}
+	}
+	if mds[2] != nil {
+		t.Fatalf("got metadata %+v, want none", mds[2])
+	}
+}
+
+func testCollectionStats(t *testing.T, w *Wallet, h Harness) {
+	b := h.Open(t)
+	defer b.Close()
+
+	first := digests("stats-1", 3)
+	ts := put(t, b, first, "")
+	err := b.PutSubmission(ts, "00000000000000aa", 3, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, prs, err := b.Put(first[:2], "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, pr := range prs {
+		if pr.ErrorCode != backend.ErrorExists {
+			t.Fatalf("got error code %v, want %v", pr.ErrorCode,
+				backend.ErrorExists)
+		}
+	}
+	err = b.PutSubmission(ts, "-", 2, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = b.PutSubmission(ts, "00000000000000aa", 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Collections that were not flushed are not returned.
+	stats, err := b.GetCollectionStats(0, math.MaxInt64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) != 0 {
+		t.Fatalf("got stats %+v, want none", stats)
+	}
+
+	h.Advance(t)
+	h.Flush(t)
+	second := digests("stats-2", 2)
+	ts2 := put(t, b, second, "")
+	h.Advance(t)
+	h.Flush(t)
+
+	grs := get(t, b, first)
+	stats, err = b.GetCollectionStats(0, math.MaxInt64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) != 2 {
+		t.Fatalf("got %v stats, want 2", len(stats))
+	}
+	want := backend.CollectionStats{
+		Timestamp:  ts,
+		Digests:    3,
+		Submitted:  6,
+		Duplicates: 3,
+		Clients:    2,
+		MerkleRoot: grs[0].MerkleRoot,
+		Tx:         grs[0].Tx,
+	}
+	if !reflect.DeepEqual(stats[0], want) {
+		t.Fatalf("got stats %+v, want %+v", stats[0], want)
+	}
+
+	// Collections without recorded submissions still report their anchor,
+	// confirmed anchors report their block.
+	w.SetConfirmations(grs[0].MinConfirmations)
+	stats, err = b.GetCollectionStats(ts2, ts2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) != 1 {
+		t.Fatalf("got %v stats, want 1", len(stats))
+	}
+	if stats[0].Timestamp != ts2 || stats[0].Digests != 2 ||
+		stats[0].Submitted != 0 || stats[0].Clients != 0 ||
+		stats[0].BlockHeight == 0 || stats[0].ChainTimestamp == 0 {
+		t.Fatalf("unexpected stats %+v", stats[0])
+	}
+}
+
+// exportAll returns all collections that are exported between from and to.
+func exportAll(t *testing.T, b backend.Backend, from, to int64) []backend.ExportRecord {
+	t.Helper()
+
+	var ers []backend.ExportRecord
+	err := b.ExportCollections(from, to, func(er backend.ExportRecord) error {
+		ers = append(ers, er)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ExportCollections: %v", err)
+	}
+	return ers
+}
+
+func testExportCollections(t *testing.T, w *Wallet, h Harness) {
+	b := h.Open(t)
+	defer b.Close()
+
+	// Collections that were not flushed are not exported.
+	first := digests("export-1", 3)
+	ts := put(t, b, first, "")
+	if ers := exportAll(t, b, 0, math.MaxInt64); len(ers) != 0 {
+		t.Fatalf("got export %+v, want none", ers)
+	}
+
+	h.Advance(t)
+	h.Flush(t)
+	second := digests("export-2", 2)
+	ts2 := put(t, b, second, "")
+	h.Advance(t)
+	h.Flush(t)
+
+	ers := exportAll(t, b, 0, math.MaxInt64)
+	if len(ers) != 2 {
+		t.Fatalf("got %v collections, want 2", len(ers))
+	}
+	grs := get(t, b, first)
+	if ers[0].Timestamp != ts || ers[1].Timestamp != ts2 {
+		t.Fatalf("got timestamps %v %v, want %v %v", ers[0].Timestamp,
+			ers[1].Timestamp, ts, ts2)
+	}
+	if ers[0].MerkleRoot != grs[0].MerkleRoot || ers[0].Tx != grs[0].Tx ||
+		ers[0].FlushTimestamp == 0 || ers[0].BlockHeight != 0 ||
+		ers[0].ChainTimestamp != 0 {
+		t.Fatalf("unexpected export %+v", ers[0])
+	}
+	got := append([][sha256.Size]byte(nil), ers[0].Digests...)
+	want := append([][sha256.Size]byte(nil), first...)
+	for _, ds := range [][][sha256.Size]byte{got, want} {
+		ds := ds
+		sort.Slice(ds, func(i, j int) bool {
+			return bytes.Compare(ds[i][:], ds[j][:]) < 0
+		})
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got digests %x, want %x", got, want)
+	}
+
+	// The range is inclusive and confirmed anchors report their block.
+	w.SetConfirmations(grs[0].MinConfirmations)
+	ers = exportAll(t, b, ts2, ts2)
+	if len(ers) != 1 {
+		t.Fatalf("got %v collections, want 1", len(ers))
+	}
+	if ers[0].Timestamp != ts2 || len(ers[0].Digests) != 2 ||
+		ers[0].BlockHeight == 0 || ers[0].ChainTimestamp == 0 {
+		t.Fatalf("unexpected export %+v", ers[0])
+	}
+
+	// Errors of the callback stop the export.
+	errStop := errors.New("stop")
+	var calls int
+	err := b.ExportCollections(0, math.MaxInt64,
+		func(backend.ExportRecord) error {
+			calls++
+			return errStop
+		})
+	if !errors.Is(err, errStop) || calls != 1 {
+		t.Fatalf("got error %v after %v calls, want %v after 1", err,
+			calls, errStop)
+	}
+}
+
+func replicateAll(t *testing.T, b backend.Backend, since int64) []backend.ReplicationRecord {
+	t.Helper()
+
+	var rrs []backend.ReplicationRecord
+	err := b.ReplicateCollections(since, func(rr backend.ReplicationRecord) error {
+		rrs = append(rrs, rr)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ReplicateCollections: %v", err)
+	}
+	return rrs
+}
+
+func testReplication(t *testing.T, w *Wallet, h Harness) {
+	b := h.Open(t)
+
+	anchored := digests("replicated", 3)
+	ts := put(t, b, anchored, "group-1")
+	h.Advance(t)
+	h.Flush(t)
+	current := digests("replicated-current", 2)
+	ts2 := put(t, b, current, "")
+	want := get(t, b, append(anchored, current...))
+
+	// Collections are replicated whether they were flushed or not.
+	rrs := replicateAll(t, b, 0)
+	if len(rrs) != 2 || rrs[0].Timestamp != ts || rrs[1].Timestamp != ts2 {
+		t.Fatalf("unexpected replication %+v", rrs)
+	}
+	if rrs[0].Flush == nil || rrs[1].Flush != nil ||
+		len(rrs[0].Digests) != 3 || len(rrs[1].Digests) != 2 {
+		t.Fatalf("unexpected replication %+v", rrs)
+	}
+	if got := replicateAll(t, b, ts2); len(got) != 1 ||
+		got[0].Timestamp != ts2 {
+		t.Fatalf("got %+v, want collection %v only", got, ts2)
+	}
+	b.Close()
+
+	s := h.OpenStandby(t)
+	for _, rr := range rrs {
+		if err := s.ApplyReplication(rr); err != nil {
+			t.Fatalf("ApplyReplication: %v", err)
+		}
+	}
+	// Applying a collection twice is harmless.
+	if err := s.ApplyReplication(rrs[1]); err != nil {
+		t.Fatalf("ApplyReplication: %v", err)
+	}
+	got := get(t, s, append(anchored, current...))
+	for i := range got {
+		if got[i].ErrorCode != want[i].ErrorCode ||
+			got[i].Tx != want[i].Tx ||
+			got[i].Timestamp != want[i].Timestamp ||
+			got[i].MerkleRoot != want[i].MerkleRoot ||
+			got[i].Label != want[i].Label {
+			t.Fatalf("%x: got %+v, want %+v", got[i].Digest, got[i],
+				want[i])
+		}
+	}
+	_, _, err := s.Put(digests("standby", 1), "", "")
+	if !errors.Is(err, backend.ErrReadOnly) {
+		t.Fatalf("got error %v, want %v", err, backend.ErrReadOnly)
+	}
+	s.Close()
+
+	// The standby takes over and anchors the replicated digests that were
+	// not flushed yet.  It no longer accepts replicated collections.
+	b = h.Open(t)
+	defer b.Close()
+	if err := b.ApplyReplication(rrs[1]); err == nil {
+		t.Fatal("expected replication to be refused")
+	}
+	h.Advance(t)
+	h.Flush(t)
+	if w.Anchors() != 2 {
+		t.Fatalf("got %v anchors, want 2", w.Anchors())
+	}
+	grs := get(t, b, current)
+	if grs[0].Tx == want[0].Tx {
+		t.Fatalf("collection %v anchored in %v again", ts, grs[0].Tx)
+	}
+	for _, gr := range grs {
+		requireAnchored(t, gr, grs[0].Tx)
+	}
+}
+
+func testAnchorChain(t *testing.T, w *Wallet, h Harness) {
+	b := h.Open(t)
+	defer b.Close()
+
+	first := digests("chain-1", 3)
+	ts := put(t, b, first, "")
+	checkpoints := []checkpoint.Checkpoint{{Height: 0}}
+
+	// Collections that do not exist or were not anchored yet can not be
+	// linked.
+	_, err := b.GetAnchorChain(ts, checkpoints)
+	if !errors.Is(err, backend.ErrAnchorNotFound) {
+		t.Fatalf("got error %v, want %v", err, backend.ErrAnchorNotFound)
+	}
+	h.Advance(t)
+	h.Flush(t)
+	_, err = b.GetAnchorChain(ts, checkpoints)
+	if !errors.Is(err, backend.ErrAnchorNotFound) {
+		t.Fatalf("got error %v, want %v", err, backend.ErrAnchorNotFound)
+	}
+
+	// The wallet mines the anchor in block 1, the chain is linked to the
+	// nearest checkpoint above it once there are enough confirmations.
+	grs := get(t, b, first)
+	w.SetConfirmations(grs[0].MinConfirmations)
+	w.SetHeight(5)
+	_, err = b.GetAnchorChain(ts, checkpoints)
+	if !errors.Is(err, checkpoint.ErrNoCheckpoint) {
+		t.Fatalf("got error %v, want %v", err, checkpoint.ErrNoCheckpoint)
+	}
+	checkpoints = []checkpoint.Checkpoint{
+		{Height: 0},
+		w.Checkpoint(3),
+		w.Checkpoint(5),
+	}
+	ac, err := b.GetAnchorChain(ts, checkpoints)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ac.Timestamp != ts || ac.Tx != grs[0].Tx ||
+		ac.MerkleRoot != grs[0].MerkleRoot || ac.BlockHeight != 1 ||
+		ac.Checkpoint != checkpoints[1] || len(ac.Chain.Headers) != 3 {
+		t.Fatalf("unexpected anchor chain %+v", ac)
+	}
+	if err := ac.Chain.Verify(ac.MerkleRoot, ac.Checkpoint); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testAnchorBlock(t *testing.T, w *Wallet, h Harness) {
+	b := h.Open(t)
+	defer b.Close()
+
+	d := digests("block", 3)
+	ts := put(t, b, d, "")
+	h.Advance(t)
+	h.Flush(t)
+	_, err := b.GetAnchorBlock(ts)
+	if !errors.Is(err, backend.ErrAnchorNotFound) {
+		t.Fatalf("got error %v, want %v", err, backend.ErrAnchorNotFound)
+	}
+
+	// The anchor is linked to the header of its block, no checkpoint is
+	// needed.
+	grs := get(t, b, d)
+	w.SetConfirmations(grs[0].MinConfirmations)
+	w.SetHeight(5)
+	ab, err := b.GetAnchorBlock(ts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ab.Timestamp != ts || ab.Tx != grs[0].Tx ||
+		ab.MerkleRoot != grs[0].MerkleRoot ||
+		ab.Checkpoint != (checkpoint.Checkpoint{}) ||
+		len(ab.Chain.Headers) != 1 {
+		t.Fatalf("unexpected anchor block %+v", ab)
+	}
+	err = ab.Chain.Verify(ab.MerkleRoot, w.Checkpoint(ab.BlockHeight))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testSampleAnchored(t *testing.T, w *Wallet, h Harness) {
+	b := h.Open(t)
+	defer b.Close()
+
+	// Collections that were not flushed are not sampled.
+	d := digests("sample", 5)
+	put(t, b, d, "")
+	samples, err := b.SampleAnchored(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(samples) != 0 {
+		t.Fatalf("got %v samples, want 0", len(samples))
+	}
+	h.Advance(t)
+	h.Flush(t)
+
+	// Samples of unconfirmed anchors carry the current confirmations.
+	samples, err = b.SampleAnchored(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(samples) != 3 {
+		t.Fatalf("got %v samples, want 3", len(samples))
+	}
+	for _, s := range samples {
+		if s.ChainTimestamp != 0 || s.Confirmations != 0 {
+			t.Fatalf("%x: got chain timestamp %v confirmations %v, "+
+				"want unconfirmed", s.Digest, s.ChainTimestamp,
+				s.Confirmations)
+		}
+	}
+
+	// Confirmed samples prove their digest against the anchored root.
+	grs := get(t, b, d)
+	w.SetConfirmations(grs[0].MinConfirmations)
+	get(t, b, d)
+	samples, err = b.SampleAnchored(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range samples {
+		found := false
+		for _, digest := range d {
+			found = found || digest == s.Digest
+		}
+		if !found {
+			t.Fatalf("sampled unknown digest %x", s.Digest)
+		}
+		if s.Tx != grs[0].Tx || s.MerkleRoot != grs[0].MerkleRoot {
+			t.Fatalf("%x: got anchor %v root %x, want %v %x",
+				s.Digest, s.Tx, s.MerkleRoot, grs[0].Tx,
+				grs[0].MerkleRoot)
+		}
+		err := merkle.VerifyInclusion(&s.MerklePath, &s.Digest,
+			&s.MerkleRoot)
+		if err != nil {
+			t.Fatalf("%x: %v", s.Digest, err)
+		}
+		if s.ChainTimestamp == 0 ||
+			s.Confirmations < grs[0].MinConfirmations {
+			t.Fatalf("%x: got chain timestamp %v confirmations %v, "+
+				"want confirmed", s.Digest, s.ChainTimestamp,
+				s.Confirmations)
+		}
+	}
+}
+
+func testCollections(t *testing.T, w *Wallet, h Harness) {
+	b := h.Open(t)
+	defer b.Close()
+
+	const (
+		owner = "00000000000000aa"
+		other = "00000000000000bb"
+	)
+	putOwned := func(owner string, d [][sha256.Size]byte) int64 {
+		t.Helper()
+		ts := put(t, b, d, "")
+		if err := b.PutOwner(owner, ts, "", d); err != nil {
+			t.Fatal(err)
+		}
+		return ts
+	}
+
+	anchored := digests("owned-anchored", 3)
+	anchoredTs := putOwned(owner, anchored)
+	h.Advance(t)
+	h.Flush(t)
+	pending := digests("owned-pending", 2)
+	pendingTs := putOwned(owner, pending)
+	h.Advance(t)
+	shared := digests("owned-shared", 2)
+	sharedTs := putOwned(owner, shared[:1])
+	putOwned(other, shared[1:])
+
+	collections, err := b.GetCollections(owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []backend.Collection{
+		{Timestamp: anchoredTs, Digests: 3, Anchored: true},
+		{Timestamp: pendingTs, Digests: 2},
+		{Timestamp: sharedTs, Digests: 1},
+	}
+	if !reflect.DeepEqual(collections, want) {
+		t.Fatalf("got collections %+v, want %+v", collections, want)
+	}
+
+	// Only the digests of the owner are returned, ordered by digest.
+	owned, err := b.GetCollectionDigests(owner, sharedTs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(owned, shared[:1]) {
+		t.Fatalf("got digests %x, want %x", owned, shared[:1])
+	}
+	owned, err = b.GetCollectionDigests(owner, anchoredTs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(owned) != len(anchored) || !sort.SliceIsSorted(owned,
+		func(i, j int) bool {
+			return bytes.Compare(owned[i][:], owned[j][:]) < 0
+		}) {
+		t.Fatalf("got digests %x, want %x sorted", owned, anchored)
+	}
+	_, err = b.GetCollectionDigests(other, anchoredTs)
+	if !errors.Is(err, backend.ErrCollectionNotFound) {
+		t.Fatalf("got %v, want %v", err, backend.ErrCollectionNotFound)
+	}
+
+	// Rename.
+	err = b.RenameCollection(owner, pendingTs, "drafts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = b.RenameCollection(other, pendingTs, "mine")
+	if !errors.Is(err, backend.ErrCollectionNotFound) {
+		t.Fatalf("got %v, want %v", err, backend.ErrCollectionNotFound)
+	}
+	collections, err = b.GetCollections(owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if collections[1].Name != "drafts" || collections[0].Name != "" {
+		t.Fatalf("got collections %+v", collections)
+	}
+
+	// A default name only names collections that have no name yet.
+	err = b.PutOwner(owner, pendingTs, "inbox", pending)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = b.PutOwner(other, sharedTs, "inbox", shared[1:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	collections, err = b.GetCollections(owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if collections[1].Name != "drafts" || collections[2].Name != "" {
+		t.Fatalf("got collections %+v", collections)
+	}
+	collections, err = b.GetCollections(other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(collections) != 1 || collections[0].Name != "inbox" {
+		t.Fatalf("got collections %+v", collections)
+	}
+
+	// Only unanchored collections without digests of others can be
+	// deleted.
+	err = b.DeleteCollection(owner, anchoredTs)
+	if !errors.Is(err, backend.ErrCollectionAnchored) {
+		t.Fatalf("got %v, want %v", err, backend.ErrCollectionAnchored)
+	}
+	err = b.DeleteCollection(owner, sharedTs)
+	if !errors.Is(err, backend.ErrCollectionShared) {
+		t.Fatalf("got %v, want %v", err, backend.ErrCollectionShared)
+	}
+	err = b.DeleteCollection(other, pendingTs)
+	if !errors.Is(err, backend.ErrCollectionNotFound) {
+		t.Fatalf("got %v, want %v", err, backend.ErrCollectionNotFound)
+	}
+	err = b.PutMetadata([]backend.Metadata{{
+		Digest: pending[0],
+		Owner:  owner,
+		Values: map[string]string{"filename": "draft.txt"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = b.DeleteCollection(owner, pendingTs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = b.DeleteCollection(owner, pendingTs)
+	if !errors.Is(err, backend.ErrCollectionNotFound) {
+		t.Fatalf("got %v, want %v", err, backend.ErrCollectionNotFound)
+	}
+
+	grs, err := b.Get(pending)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, gr := range grs {
+		if gr.ErrorCode != backend.ErrorNotFound {
+			t.Fatalf("%x: got error code %v, want %v", gr.Digest,
+				gr.ErrorCode, backend.ErrorNotFound)
+		}
+	}
+	get(t, b, anchored)
+	get(t, b, shared)
+
+	// The metadata of deleted digests is deleted with them.
+	mds, err := b.GetMetadata(pending[:1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mds[0] != nil {
+		t.Fatalf("got metadata %+v, want none", mds[0])
+	}
+
+	collections, err = b.GetCollections(owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(collections) != 2 || collections[0].Timestamp != anchoredTs ||
+		collections[1].Timestamp != sharedTs {
+		t.Fatalf("got collections %+v", collections)
+	}
+
+	// The anchored collection is unaffected by the deletion and stays
+	// anchored.
+	h.Advance(t)
+	h.Flush(t)
+	for _, gr := range get(t, b, anchored) {
+		requireAnchored(t, gr, gr.Tx)
+	}
+}
+
+func testSearchDigests(t *testing.T, w *Wallet, h Harness) {
+	b := h.Open(t)
+	defer b.Close()
+
+	const (
+		owner = "00000000000000aa"
+		other = "00000000000000bb"
+	)
+	putOwned := func(owner string, d [][sha256.Size]byte) int64 {
+		t.Helper()
+		ts := put(t, b, d, "")
+		if err := b.PutOwner(owner, ts, "", d); err != nil {
+			t.Fatal(err)
+		}
+		return ts
+	}
+	search := func(q backend.DigestQuery) ([][sha256.Size]byte, string) {
+		t.Helper()
+		if q.Limit == 0 {
+			q.Limit = 100
+		}
+		matches, cursor, err := b.SearchDigests(owner, q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		found := make([][sha256.Size]byte, 0, len(matches))
+		for _, m := range matches {
+			found = append(found, m.Digest)
+		}
+		return found, cursor
+	}
+
+	anchored := sorted(digests("search-anchored", 3))
+	anchoredTs := putOwned(owner, anchored)
+	h.Advance(t)
+	h.Flush(t)
+	pending := sorted(digests("search-pending", 2))
+	pendingTs := putOwned(owner, pending)
+	putOwned(other, digests("search-other", 2))
+	err := b.RenameCollection(owner, pendingTs, "invoices/2020")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = b.PutMetadata([]backend.Metadata{
+		{
+			Digest: anchored[1],
+			Owner:  owner,
+			Values: map[string]string{"externalid": "1"},
+		},
+		{
+			Digest: pending[0],
+			Owner:  owner,
+			Values: map[string]string{"externalid": "2"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Digests are ordered by collection and digest and only the digests
+	// of the owner are returned.
+	all := append(append([][sha256.Size]byte{}, anchored...), pending...)
+	found, cursor := search(backend.DigestQuery{})
+	requireDigests(t, found, all)
+	if cursor != "" {
+		t.Fatalf("got cursor %v, want none", cursor)
+	}
+	matches, _, err := b.SearchDigests(owner, backend.DigestQuery{
+		Limit: 100,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !matches[0].Anchored || matches[0].Timestamp != anchoredTs ||
+		matches[3].Anchored || matches[3].Collection != "invoices/2020" ||
+		matches[1].Metadata == nil || matches[0].Metadata != nil {
+		t.Fatalf("got matches %+v", matches)
+	}
+
+	// Filters are combined.
+	found, _ = search(backend.DigestQuery{Collection: "invoices"})
+	requireDigests(t, found, pending)
+	found, _ = search(backend.DigestQuery{Collection: "invoice"})
+	requireDigests(t, found, nil)
+	found, _ = search(backend.DigestQuery{Anchored: true})
+	requireDigests(t, found, anchored)
+	found, _ = search(backend.DigestQuery{Pending: true})
+	requireDigests(t, found, pending)
+	found, _ = search(backend.DigestQuery{From: pendingTs})
+	requireDigests(t, found, pending)
+	found, _ = search(backend.DigestQuery{To: anchoredTs})
+	requireDigests(t, found, anchored)
+	found, _ = search(backend.DigestQuery{MetadataKey: "externalid"})
+	requireDigests(t, found, [][sha256.Size]byte{anchored[1], pending[0]})
+	found, _ = search(backend.DigestQuery{
+		MetadataKey:   "externalid",
+		MetadataValue: "2",
+		Pending:       true,
+	})
+	requireDigests(t, found, pending[:1])
+
+	// Pages continue at the cursor of the previous page.
+	var paged [][sha256.Size]byte
+	cursor = ""
+	for {
+		var page [][sha256.Size]byte
+		page, cursor = search(backend.DigestQuery{
+			Cursor: cursor,
+			Limit:  2,
+		})
+		if len(page) == 0 || len(page) > 2 {
+			t.Fatalf("got page of %v digests", len(page))
+		}
+		paged = append(paged, page...)
+		if cursor == "" {
+			break
+		}
+	}
+	requireDigests(t, paged, all)
+
+	_, _, err = b.SearchDigests(owner, backend.DigestQuery{
+		Cursor: "invalid",
+		Limit:  1,
+	})
+	if !errors.Is(err, backend.ErrInvalidCursor) {
+		t.Fatalf("got %v, want %v", err, backend.ErrInvalidCursor)
+	}
+}
+
+func testSessions(t *testing.T, w *Wallet, h Harness) {
+	b := h.Open(t)
+	defer b.Close()
+
+	const never = math.MaxInt64
+	err := b.CreateSession(backend.Session{
+		ID:         "open",
+		Label:      "session",
+		MaxDigests: 5,
+		Expires:    never,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = b.GetSession("missing")
+	if !errors.Is(err, backend.ErrSessionNotFound) {
+		t.Fatalf("got %v, want %v", err, backend.ErrSessionNotFound)
+	}
+
+	// Resent digests are skipped, gaps and overflows are refused.
+	d := digests("session", 6)
+	s, err := b.AppendSession("open", 0, d[:2], never)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Digests != 2 {
+		t.Fatalf("got %v digests, want 2", s.Digests)
+	}
+	s, err = b.AppendSession("open", 1, d[1:4], never)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Digests != 4 {
+		t.Fatalf("got %v digests, want 4", s.Digests)
+	}
+	_, err = b.AppendSession("open", 5, d[5:], never)
+	if !errors.Is(err, backend.ErrSessionOffset) {
+		t.Fatalf("got %v, want %v", err, backend.ErrSessionOffset)
+	}
+	_, err = b.AppendSession("open", 4, d[4:], never)
+	if !errors.Is(err, backend.ErrSessionFull) {
+		t.Fatalf("got %v, want %v", err, backend.ErrSessionFull)
+	}
+
+	// Closing timestamps the staged digests in the current collection,
+	// digests that already exist are counted as such.
+	ts := put(t, b, d[3:4], "")
+	s, prs, err := b.CloseSession("open", never)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(prs) != 4 || s.Timestamp != ts || s.Closed == 0 ||
+		s.Accepted != 3 || s.Existing != 1 {
+		t.Fatalf("unexpected session %+v results %v", s, len(prs))
+	}
+	got, err := b.GetSession("open")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, s) {
+		t.Fatalf("got session %+v, want %+v", got, s)
+	}
+	for _, gr := range get(t, b, d[:4]) {
+		if gr.ErrorCode != backend.ErrorOK {
+			t.Fatalf("unexpected result %+v", gr)
+		}
+	}
+	_, _, err = b.CloseSession("open", never)
+	if !errors.Is(err, backend.ErrSessionClosed) {
+		t.Fatalf("got %v, want %v", err, backend.ErrSessionClosed)
+	}
+	_, err = b.AppendSession("open", 4, d[4:5], never)
+	if !errors.Is(err, backend.ErrSessionClosed) {
+		t.Fatalf("got %v, want %v", err, backend.ErrSessionClosed)
+	}
+
+	// Expired sessions are gone.
+	err = b.CreateSession(backend.Session{
+		ID:         "expired",
+		MaxDigests: 5,
+		Expires:    1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = b.AppendSession("expired", 0, d[4:5], never)
+	if !errors.Is(err, backend.ErrSessionNotFound) {
+		t.Fatalf("got %v, want %v", err, backend.ErrSessionNotFound)
+	}
+}
+
+func testTickets(t *testing.T, w *Wallet, h Harness) {
+	b := h.Open(t)
+
+	d := digests("ticket", 3)
+	for k, id := range []string{"second", "first", "third"} {
+		created := int64(k + 1)
+		if id == "first" {
+			created = 0
+		}
+		err := b.PutTicket(backend.Ticket{
+			ID:      id,
+			Label:   "async",
+			Digests: d[k : k+1],
+			Created: created,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err := b.GetTicket("missing")
+	if !errors.Is(err, backend.ErrTicketNotFound) {
+		t.Fatalf("got %v, want %v", err, backend.ErrTicketNotFound)
+	}
+
+	// Queued tickets survive a restart and are returned oldest first.
+	b.Close()
+	b = h.Open(t)
+	defer b.Close()
+	queued, err := b.QueuedTickets(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(queued) != 2 || queued[0].ID != "first" ||
+		queued[1].ID != "second" {
+		t.Fatalf("unexpected queue %+v", queued)
+	}
+	if !reflect.DeepEqual(queued[0].Digests, d[1:2]) ||
+		queued[0].Label != "async" {
+		t.Fatalf("unexpected ticket %+v", queued[0])
+	}
+
+	// Processed tickets leave the queue and keep their outcome.
+	tk := queued[0]
+	tk.Processed = 10
+	tk.Timestamp = put(t, b, tk.Digests, tk.Label)
+	tk.Results = []uint{backend.ErrorOK}
+	tk.Expires = math.MaxInt64
+	if err := b.PutTicket(tk); err != nil {
+		t.Fatal(err)
+	}
+	got, err := b.GetTicket("first")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(*got, tk) {
+		t.Fatalf("got ticket %+v, want %+v", *got, tk)
+	}
+	queued, err = b.QueuedTickets(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(queued) != 2 || queued[0].ID != "second" ||
+		queued[1].ID != "third" {
+		t.Fatalf("unexpected queue %+v", queued)
+	}
+
+	// Expired tickets are gone.
+	tk.Expires = 1
+	if err := b.PutTicket(tk); err != nil {
+		t.Fatal(err)
+	}
+	_, err = b.GetTicket("first")
+	if !errors.Is(err, backend.ErrTicketNotFound) {
+		t.Fatalf("got %v, want %v", err, backend.ErrTicketNotFound)
+	}
+}
+
+func testCrashRecovery(t *testing.T, w *Wallet, h Harness) {
+	b := h.Open(t)
+
+	// Leave a closed collection that was not anchored and a current
+	// collection behind.
+	closed := digests("closed", 5)
+	put(t, b, closed, "")
+	h.Advance(t)
+	current := digests("current", 5)
+	put(t, b, current, "")
+	b.Close()
+
+	// The closed collection is anchored when the backend comes back.
+	b = h.Open(t)
+	if w.Anchors() != 1 {
+		t.Fatalf("got %v anchors, want 1", w.Anchors())
+	}
+	grs := get(t, b, closed)
+	for _, gr := range grs {
+		requireAnchored(t, gr, grs[0].Tx)
+	}
+	for _, gr := range get(t, b, current) {
+		if gr.Tx != (chainhash.Hash{}) {
+			t.Fatalf("%x: current collection anchored", gr.Digest)
+		}
+	}
+
+	// Nothing is anchored twice after another restart.
+	b.Close()
+	b = h.Open(t)
+	defer b.Close()
+	if w.Anchors() != 1 {
+		t.Fatalf("got %v anchors, want 1", w.Anchors())
+	}
+	get(t, b, append(closed, current...))
+}
+
+func testDumpRestore(t *testing.T, w *Wallet, h Harness) {
+	b := h.Open(t)
+
+	anchored := digests("dumped", 4)
+	put(t, b, anchored, "group-1")
+	h.Advance(t)
+	h.Flush(t)
+	current := digests("dumped-current", 2)
+	put(t, b, current, "")
+	want := get(t, b, append(anchored, current...))
+
+	f, err := os.Create(filepath.Join(t.TempDir(), "dump.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	err = b.Dump(f, false)
+	b.Close()
+	if err != nil {
+		t.Fatalf("Dump: %v", err)
+	}
+	_, err = f.Seek(0, io.SeekStart)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Restore into new storage.  Restore returns once it hits the end of
+	// the dump.
+	r := h.OpenRestore(t)
+	err = r.Restore(f, false, "")
+	r.Close()
+	if err != nil && !errors.Is(err, io.EOF) {
+		t.Fatalf("Restore: %v", err)
+	}
+
+	b = h.Open(t)
+	defer b.Close()
+	got := get(t, b, append(anchored, current...))
+	for i := range got {
+		if got[i].Tx != want[i].Tx || got[i].Timestamp != want[i].Timestamp ||
+			got[i].MerkleRoot != want[i].MerkleRoot ||
+			got[i].Label != want[i].Label {
+			t.Fatalf("%x: got %+v, want %+v", got[i].Digest, got[i],
+				want[i])
+		}
+	}
+}
+
+func testFsck(t *testing.T, w *Wallet, h Harness) {
+	b := h.Open(t)
+	defer b.Close()
+
+	put(t, b, digests("fsck", 5), "")
+	h.Advance(t)
+	h.Flush(t)
+	put(t, b, digests("fsck-current", 2), "")
+
+	s := httptest.NewServer(w)
+	defer s.Close()
+	err := b.Fsck(&backend.FsckOptions{
+		URL: s.URL + "/",
+	})
+	if err != nil {
+		t.Fatalf("Fsck: %v", err)
+	}
+}
@@ -0,0 +1,376 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package testsuite
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/decred/dcrd/chaincfg/chainhash"
+	"github.com/decred/dcrd/wire"
+	"github.com/decred/dcrtime/checkpoint"
+	"github.com/decred/dcrtime/dcrtimed/dcrtimewallet"
+)
+
+// Balance is the balance that is reported by Wallet.
+var Balance = dcrtimewallet.BalanceResult{
+	Total:       100000000,
+	Spendable:   90000000,
+	Unconfirmed: 10000000,
+}
+
+// anchor is a merkle root that was anchored by Wallet.
+type anchor struct {
+	root      [sha256.Size]byte
+	prefix    []byte
+	timestamp int64
+	height    int32
+	tx        *wire.MsgTx
+	dropped   bool // Evicted from the chain and the mempool
+}
+
+// Wallet is an in memory dcrtimewallet.Wallet that anchors merkle roots
+// without a blockchain.  All anchors report the same number of
+// confirmations, which is controlled by the suite.  Every anchor is mined in
+// its own block, the block at height n holds the nth anchor and nothing else.
+//
+// Wallet also serves the subset of the dcrdata API that is used by Fsck to
+// verify anchors: GET /<tx>/out.
+type Wallet struct {
+	sync.Mutex
+
+	confirmations int32
+	anchors       map[chainhash.Hash]anchor
+	replacements  int
+	height        int32
+	subscribers   map[chan int32]struct{}
+}
+
+var _ dcrtimewallet.Wallet = (*Wallet)(nil)
+
+// NewWallet returns a Wallet without anchors.
+func NewWallet() *Wallet {
+	return &Wallet{
+		anchors:     make(map[chainhash.Hash]anchor),
+		subscribers: make(map[chan int32]struct{}),
+	}
+}
+
+// SetConfirmations sets the number of confirmations of all anchors.
+func (w *Wallet) SetConfirmations(confirmations int32) {
+	w.Lock()
+	defer w.Unlock()
+
+	w.confirmations = confirmations
+}
+
+// SetHeight sets the height of the best block and notifies the block
+// subscribers.
+func (w *Wallet) SetHeight(height int32) {
+	w.Lock()
+	defer w.Unlock()
+
+	w.height = height
+	for c := range w.subscribers {
+		// Subscribers that are behind only need the latest height.
+		select {
+		case c <- height:
+		default:
+		}
+	}
+}
+
+// Drop evicts the provided anchor from the chain and the mempool, as if it
+// was reorganized out and never broadcast again.  Lookup no longer finds it.
+func (w *Wallet) Drop(tx chainhash.Hash) {
+	w.Lock()
+	defer w.Unlock()
+
+	if a, ok := w.anchors[tx]; ok {
+		a.dropped = true
+		w.anchors[tx] = a
+	}
+}
+
+// Anchors returns the number of anchors that were constructed.
+func (w *Wallet) Anchors() int {
+	w.Lock()
+	defer w.Unlock()
+
+	return len(w.anchors)
+}
+
+// Replacements returns the number of anchors that were constructed by
+// Replace.
+func (w *Wallet) Replacements() int {
+	w.Lock()
+	defer w.Unlock()
+
+	return w.replacements
+}
+
+// Checkpoint returns the block at the provided height as a checkpoint.
+func (w *Wallet) Checkpoint(height int32) checkpoint.Checkpoint {
+	w.Lock()
+	defer w.Unlock()
+
+	header := w.header(height)
+	return checkpoint.Checkpoint{
+		Height: height,
+		Hash:   header.BlockHash(),
+	}
+}
+
+// Lookup satisfies the dcrtimewallet.Wallet interface.
+func (w *Wallet) Lookup(tx chainhash.Hash) (*dcrtimewallet.TxLookupResult, error) {
+	w.Lock()
+	defer w.Unlock()
+
+	a, ok := w.anchors[tx]
+	if !ok || a.dropped {
+		return &dcrtimewallet.TxLookupResult{Confirmations: -1}, nil
+	}
+	if w.confirmations <= 0 {
+		return &dcrtimewallet.TxLookupResult{
+			Confirmations: w.confirmations,
+		}, nil
+	}
+
+	header := w.header(a.height)
+	return &dcrtimewallet.TxLookupResult{
+		BlockHash:     header.BlockHash(),
+		Timestamp:     a.timestamp,
+		Confirmations: w.confirmations,
+		BlockHeight:   a.height,
+	}, nil
+}
+
+// Construct satisfies the dcrtimewallet.Wallet interface.  The tx hash is
+// derived from the merkle root and the number of prior anchors.
+func (w *Wallet) Construct(merkleRoot [sha256.Size]byte, prefix []byte) (*chainhash.Hash, error) {
+	w.Lock()
+	defer w.Unlock()
+
+	return w.construct(merkleRoot, prefix)
+}
+
+// Replace satisfies the dcrtimewallet.Wallet interface.  The fee is ignored.
+func (w *Wallet) Replace(merkleRoot [sha256.Size]byte, prefix []byte, bump int64) (*chainhash.Hash, error) {
+	w.Lock()
+	defer w.Unlock()
+
+	w.replacements++
+	return w.construct(merkleRoot, prefix)
+}
+
+// construct records an anchor of the merkle root.  The anchor spends an
+// output that is derived from its height so that its hash is unique.
+//
+// This function must be called with the lock held.
+func (w *Wallet) construct(merkleRoot [sha256.Size]byte, prefix []byte) (*chainhash.Hash, error) {
+	height := int32(len(w.anchors) + 1)
+	script, err := dcrtimewallet.AnchorScript(merkleRoot, prefix)
+	if err != nil {
+		return nil, err
+	}
+	var prevOut chainhash.Hash
+	binary.LittleEndian.PutUint32(prevOut[:], uint32(height))
+	mtx := wire.NewMsgTx()
+	mtx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&prevOut, 0,
+		wire.TxTreeRegular), 0, nil))
+	mtx.AddTxOut(wire.NewTxOut(0, script))
+
+	tx := mtx.TxHash()
+	w.anchors[tx] = anchor{
+		root:      merkleRoot,
+		prefix:    append([]byte(nil), prefix...),
+		timestamp: time.Now().Unix(),
+		height:    height,
+		tx:        mtx,
+	}
+
+	return &tx, nil
+}
+
+// blockTx returns the anchor that was mined at the provided height, if any.
+//
+// This function must be called with the lock held.
+func (w *Wallet) blockTx(height int32) *wire.MsgTx {
+	for _, a := range w.anchors {
+		if a.height == height && !a.dropped {
+			return a.tx
+		}
+	}
+	return nil
+}
+
+// header returns the header of the block at the provided height.  The merkle
+// root of a block with an anchor is the full hash of the anchor, which is the
+// merkle root of a single transaction.
+//
+// This function must be called with the lock held.
+func (w *Wallet) header(height int32) wire.BlockHeader {
+	var header wire.BlockHeader
+	for h := int32(0); h <= height; h++ {
+		prev := header.BlockHash()
+		header = wire.BlockHeader{
+			Version: 1,
+			Height:  uint32(h),
+		}
+		if h > 0 {
+			header.PrevBlock = prev
+		}
+		if tx := w.blockTx(h); tx != nil {
+			header.MerkleRoot = tx.TxHashFull()
+		}
+	}
+	return header
+}
+
+// tip returns the height of the newest block, which is at least the height of
+// the newest anchor.
+//
+// This function must be called with the lock held.
+func (w *Wallet) tip() int32 {
+	if height := int32(len(w.anchors)); height > w.height {
+		return height
+	}
+	return w.height
+}
+
+// GetWalletBalance satisfies the dcrtimewallet.Wallet interface.
+func (w *Wallet) GetWalletBalance() (*dcrtimewallet.BalanceResult, error) {
+	balance := Balance
+	return &balance, nil
+}
+
+// BestHeight satisfies the dcrtimewallet.Wallet interface.
+func (w *Wallet) BestHeight() (int32, error) {
+	w.Lock()
+	defer w.Unlock()
+
+	return w.height, nil
+}
+
+// NotifyBlocks satisfies the dcrtimewallet.Wallet interface.  The provided
+// function is called with the height that is set by SetHeight.
+func (w *Wallet) NotifyBlocks(ctx context.Context, f func(int32)) error {
+	c := make(chan int32, 1)
+	w.Lock()
+	w.subscribers[c] = struct{}{}
+	w.Unlock()
+	defer func() {
+		w.Lock()
+		delete(w.subscribers, c)
+		w.Unlock()
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case height := <-c:
+			f(height)
+		}
+	}
+}
+
+// Block satisfies the dcrtimewallet.Wallet interface.
+func (w *Wallet) Block(hash chainhash.Hash) (*wire.MsgBlock, error) {
+	w.Lock()
+	defer w.Unlock()
+
+	for h := int32(0); h <= w.tip(); h++ {
+		header := w.header(h)
+		if header.BlockHash() != hash {
+			continue
+		}
+		block := &wire.MsgBlock{
+			Header: header,
+		}
+		if tx := w.blockTx(h); tx != nil {
+			block.Transactions = []*wire.MsgTx{tx}
+		}
+		return block, nil
+	}
+
+	return nil, fmt.Errorf("block not found: %v", hash)
+}
+
+// Headers satisfies the dcrtimewallet.Wallet interface.
+func (w *Wallet) Headers(hash chainhash.Hash, height int32) ([]wire.BlockHeader, error) {
+	w.Lock()
+	defer w.Unlock()
+
+	if height > w.tip() {
+		return nil, fmt.Errorf("block not found: %v", height)
+	}
+	var headers []wire.BlockHeader
+	found := false
+	for h := int32(0); h <= height; h++ {
+		header := w.header(h)
+		if found {
+			headers = append(headers, header)
+		}
+		if header.BlockHash() == hash {
+			found = true
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("block not found: %v", hash)
+	}
+
+	return headers, nil
+}
+
+// Close satisfies the dcrtimewallet.Wallet interface.
+func (w *Wallet) Close() {}
+
+// ServeHTTP replies with the outputs of an anchor tx like dcrdata does.
+func (w *Wallet) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	s := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/"), "/out")
+	tx, err := chainhash.NewHashFromStr(s)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Lock()
+	a, ok := w.anchors[*tx]
+	w.Unlock()
+	if !ok {
+		http.NotFound(rw, r)
+		return
+	}
+
+	// OP_RETURN OP_DATA_N <prefix> <merkle root>
+	script, err := dcrtimewallet.AnchorScript(a.root, a.prefix)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	type scriptPubKey struct {
+		Hex  string `json:"hex"`
+		Type string `json:"type"`
+	}
+	type txOut struct {
+		ScriptPubKey scriptPubKey `json:"scriptPubKey"`
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(rw).Encode([]txOut{{
+		ScriptPubKey: scriptPubKey{
+			Hex:  hex.EncodeToString(script),
+			Type: "nulldata",
+		},
+	}})
+}
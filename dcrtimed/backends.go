@@ -0,0 +1,22 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+// Backends register themselves with the backend package when their package is
+// imported and are selected with the backend option.  Additional backends are
+// compiled in by importing them from a file with a build tag, e.g. a file
+// named backend_mystore.go that contains
+//
+//	//go:build mystore
+//
+//	package main
+//
+//	import _ "example.com/mystore"
+//
+// and building dcrtimed with -tags mystore.
+import (
+	_ "github.com/decred/dcrtime/dcrtimed/backend/filesystem"
+	_ "github.com/decred/dcrtime/dcrtimed/backend/leveldb"
+)
@@ -0,0 +1,146 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	v2 "github.com/decred/dcrtime/api/v2"
+	"github.com/decred/dcrtime/bloom"
+	"github.com/decred/dcrtime/dcrtimed/backend"
+	"github.com/decred/dcrtime/util"
+)
+
+// decodeBloom decodes the request body into b.  It responds with an error
+// and returns false if the request is invalid.
+func decodeBloom(w http.ResponseWriter, body io.Reader, b *v2.Bloom) bool {
+	decoder := json.NewDecoder(body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(b); err != nil {
+		util.RespondWithError(w, http.StatusBadRequest,
+			"Invalid request payload")
+		return false
+	}
+	if b.ServerTimestamp <= 0 {
+		util.RespondWithError(w, http.StatusBadRequest,
+			"Invalid server timestamp")
+		return false
+	}
+	return true
+}
+
+// bloomV2 returns the Bloom filter of the digests of an anchored collection
+// so that clients can rule out digests locally before verifying them.  Only
+// digests that may be revealed to the client are added to the filter.
+// Handles /v2/bloom
+func (d *DcrtimeStore) bloomV2(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	var b v2.Bloom
+	if !decodeBloom(w, r.Body, &b) {
+		return
+	}
+
+	via := logAddr(r)
+	xff := r.Header.Get(forward)
+	if xff != "" {
+		via = fmt.Sprintf("%v via %v", logAddr(r), xff)
+	}
+	log.Infof("%v Bloom %v: %v", r.URL.Path, via, b.ServerTimestamp)
+
+	trs, err := d.backend.GetTimestamps([]int64{b.ServerTimestamp})
+	if err == nil {
+		err = d.newDigestAccess(r, nil).hideTimestamps(trs)
+	}
+	if err == nil && len(trs) != 1 {
+		err = fmt.Errorf("got %v timestamp results", len(trs))
+	}
+	if err != nil {
+		// Generic internal error.
+		errorCode := time.Now().Unix()
+		log.Errorf("%v bloom error code %v: %v", logAddr(r),
+			errorCode, err)
+
+		util.RespondWithError(w, http.StatusInternalServerError,
+			fmt.Sprintf("Could not retrieve collection, contact "+
+				"administrator and provide the following "+
+				"error code: %v", errorCode))
+		return
+	}
+
+	tr := trs[0]
+	reply := v2.BloomReply{
+		ID:              b.ID,
+		ServerTimestamp: b.ServerTimestamp,
+		ServerTime:      v2.FormatTime(b.ServerTimestamp),
+		Result:          v2.ResultDoesntExistError,
+	}
+	switch tr.ErrorCode {
+	case backend.ErrorOK:
+		if tr.AnchoredTimestamp == 0 {
+			// Not anchored with enough confirmations yet, the
+			// collection may still change.
+			break
+		}
+		filter := bloom.New(len(tr.Digests),
+			bloom.DefaultFalsePositiveRate)
+		for _, digest := range tr.Digests {
+			filter.Add(digest)
+		}
+		reply.MerkleRoot = hex.EncodeToString(tr.MerkleRoot[:])
+		reply.Transaction = tr.Tx.String()
+		reply.ChainTimestamp = tr.AnchoredTimestamp
+		reply.ChainTime = v2.FormatTime(tr.AnchoredTimestamp)
+		reply.DigestCount = len(tr.Digests)
+		reply.Hashes = filter.Hashes()
+		reply.Filter = base64.StdEncoding.EncodeToString(filter.Bytes())
+		reply.Result = v2.ResultOK
+	case backend.ErrorNotFound:
+	case backend.ErrorNotAllowed:
+		reply.Result = v2.ResultDisabled
+	default:
+		// Generic internal error.
+		errorCode := time.Now().Unix()
+		log.Errorf("%v timestamp ErrorCode translation error code "+
+			"%v: %v", logAddr(r), errorCode, tr.ErrorCode)
+
+		util.RespondWithError(w, http.StatusInternalServerError,
+			fmt.Sprintf("Could not retrieve collection, contact "+
+				"administrator and provide the following "+
+				"error code: %v", errorCode))
+		return
+	}
+
+	util.RespondWithJSON(w, http.StatusOK, reply)
+}
+
+func (d *DcrtimeStore) proxyBloomV2(w http.ResponseWriter, r *http.Request) {
+	b, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		util.RespondWithError(w, http.StatusBadRequest,
+			"Unable to read request")
+		return
+	}
+
+	var bl v2.Bloom
+	if !decodeBloom(w, bytes.NewReader(b), &bl) {
+		return
+	}
+
+	d.sendToBackend(r.Context(), w, r.Method,
+		withAPIToken(v2.BloomRoute, r), r.Header.Get("Content-Type"),
+		r.RemoteAddr, bytes.NewReader(b))
+
+	log.Infof("%v Bloom %v: %v", r.URL.Path, logAddr(r),
+		bl.ServerTimestamp)
+}
@@ -0,0 +1,144 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	v2 "github.com/decred/dcrtime/api/v2"
+	"github.com/decred/dcrtime/checkpoint"
+	"github.com/decred/dcrtime/dcrtimed/backend"
+	"github.com/decred/dcrtime/dcrtimed/dcrtimewallet"
+	"github.com/decred/dcrtime/util"
+)
+
+// decodeAnchorChain decodes the request body into ac.  It responds with an
+// error and returns false if the request is invalid.
+func decodeAnchorChain(w http.ResponseWriter, body io.Reader, ac *v2.AnchorChain) bool {
+	decoder := json.NewDecoder(body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(ac); err != nil {
+		util.RespondWithError(w, http.StatusBadRequest,
+			"Invalid request payload")
+		return false
+	}
+	if ac.ServerTimestamp <= 0 {
+		util.RespondWithError(w, http.StatusBadRequest,
+			"Invalid server timestamp")
+		return false
+	}
+	return true
+}
+
+// convertAnchorChain fills the reply with the hex encoded anchor chain.
+func convertAnchorChain(ac *backend.AnchorChain, reply *v2.AnchorChainReply) error {
+	tx, err := ac.Chain.Tx.Bytes()
+	if err != nil {
+		return err
+	}
+	reply.MerkleRoot = hex.EncodeToString(ac.MerkleRoot[:])
+	reply.Transaction = ac.Tx.String()
+	reply.BlockHeight = ac.BlockHeight
+	reply.CheckpointHeight = ac.Checkpoint.Height
+	reply.CheckpointHash = ac.Checkpoint.Hash.String()
+	reply.RawTransaction = hex.EncodeToString(tx)
+	reply.TxIndex = ac.Chain.Branch.Index
+	reply.TxBranch = make([]string, 0, len(ac.Chain.Branch.Hashes))
+	for _, hash := range ac.Chain.Branch.Hashes {
+		reply.TxBranch = append(reply.TxBranch, hash.String())
+	}
+	reply.Headers = make([]string, 0, len(ac.Chain.Headers))
+	for k := range ac.Chain.Headers {
+		header, err := ac.Chain.Headers[k].Bytes()
+		if err != nil {
+			return err
+		}
+		reply.Headers = append(reply.Headers,
+			hex.EncodeToString(header))
+	}
+	return nil
+}
+
+// anchorChainV2 returns the chain that links the anchor of a collection to
+// the nearest published checkpoint of the active network.  Together with a
+// JSON proof it proves that a digest was anchored without access to the
+// blockchain.  Anchors are public, so are their chains.
+// Handles /v2/anchorchain
+func (d *DcrtimeStore) anchorChainV2(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	var ac v2.AnchorChain
+	if !decodeAnchorChain(w, r.Body, &ac) {
+		return
+	}
+
+	via := logAddr(r)
+	xff := r.Header.Get(forward)
+	if xff != "" {
+		via = fmt.Sprintf("%v via %v", logAddr(r), xff)
+	}
+	log.Infof("%v AnchorChain %v: %v", r.URL.Path, via, ac.ServerTimestamp)
+
+	reply := v2.AnchorChainReply{
+		ID:              ac.ID,
+		ServerTimestamp: ac.ServerTimestamp,
+		ServerTime:      v2.FormatTime(ac.ServerTimestamp),
+		Result:          v2.ResultOK,
+	}
+	chain, err := d.backend.GetAnchorChain(ac.ServerTimestamp,
+		checkpoint.Network(activeNetParams.Name))
+	if err == nil {
+		err = convertAnchorChain(chain, &reply)
+	}
+	switch {
+	case errors.Is(err, backend.ErrAnchorNotFound),
+		errors.Is(err, checkpoint.ErrNoCheckpoint):
+		reply.Result = v2.ResultDoesntExistError
+	case errors.Is(err, dcrtimewallet.ErrNotSupported):
+		reply.Result = v2.ResultDisabled
+	case err != nil:
+		// Generic internal error.
+		errorCode := time.Now().Unix()
+		log.Errorf("%v anchor chain error code %v: %v", logAddr(r),
+			errorCode, err)
+
+		util.RespondWithError(w, http.StatusInternalServerError,
+			fmt.Sprintf("Could not retrieve anchor chain, contact "+
+				"administrator and provide the following "+
+				"error code: %v", errorCode))
+		return
+	}
+
+	util.RespondWithJSON(w, http.StatusOK, reply)
+}
+
+func (d *DcrtimeStore) proxyAnchorChainV2(w http.ResponseWriter, r *http.Request) {
+	b, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		util.RespondWithError(w, http.StatusBadRequest,
+			"Unable to read request")
+		return
+	}
+
+	var ac v2.AnchorChain
+	if !decodeAnchorChain(w, bytes.NewReader(b), &ac) {
+		return
+	}
+
+	d.sendToBackend(r.Context(), w, r.Method,
+		withAPIToken(v2.AnchorChainRoute, r),
+		r.Header.Get("Content-Type"), r.RemoteAddr, bytes.NewReader(b))
+
+	log.Infof("%v AnchorChain %v: %v", r.URL.Path, logAddr(r),
+		ac.ServerTimestamp)
+}
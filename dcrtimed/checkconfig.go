@@ -0,0 +1,308 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/decred/dcrtime/dcrtimed/anchorer"
+)
+
+// checkTimeout is the timeout of a reachability check.
+const checkTimeout = 10 * time.Second
+
+// checkConfigMode is set when dcrtimed was started with --checkconfig.  It is
+// set before the configuration is validated so that validation errors are
+// reported as well.
+var checkConfigMode bool
+
+// configCheck is the outcome of a single check of --checkconfig.
+type configCheck struct {
+	Name   string `json:"name"`             // Option that was checked
+	Target string `json:"target,omitempty"` // File or host
+	OK     bool   `json:"ok"`               // Check passed
+	Detail string `json:"detail,omitempty"` // Additional information
+	Error  string `json:"error,omitempty"`  // Reason the check failed
+}
+
+// configReport is the report --checkconfig prints.
+type configReport struct {
+	Valid      bool          `json:"valid"` // All checks passed
+	Version    string        `json:"version"`
+	ConfigFile string        `json:"configfile,omitempty"`
+	Network    string        `json:"network,omitempty"`
+	Mode       string        `json:"mode,omitempty"` // store or proxy
+	Checks     []configCheck `json:"checks"`
+}
+
+// add records the outcome of a check.
+func (r *configReport) add(name, target, detail string, err error) {
+	c := configCheck{
+		Name:   name,
+		Target: target,
+		OK:     err == nil,
+		Detail: detail,
+	}
+	if err != nil {
+		c.Error = err.Error()
+		r.Valid = false
+	}
+	r.Checks = append(r.Checks, c)
+}
+
+// print writes the report to stdout.
+func (r *configReport) print() {
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+	fmt.Println(string(b))
+}
+
+// parseCertFile parses the PEM encoded certificates of the provided file and
+// describes their validity.  Expired certificates fail.
+func parseCertFile(filename string) (*x509.CertPool, string, error) {
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, "", err
+	}
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, b = pem.Decode(b)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, "", err
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, "", fmt.Errorf("no certificates found")
+	}
+
+	pool := x509.NewCertPool()
+	expires := certs[0].NotAfter
+	for _, cert := range certs {
+		if time.Now().After(cert.NotAfter) {
+			return nil, "", fmt.Errorf("certificate %v expired %v",
+				cert.Subject.CommonName,
+				cert.NotAfter.UTC().Format(time.RFC3339))
+		}
+		if cert.NotAfter.Before(expires) {
+			expires = cert.NotAfter
+		}
+		pool.AddCert(cert)
+	}
+	detail := fmt.Sprintf("%v certificate(s), expires %v", len(certs),
+		expires.UTC().Format(time.RFC3339))
+	return pool, detail, nil
+}
+
+// checkCert parses a certificate option and returns its certificates, nil if
+// the file does not parse.
+func (r *configReport) checkCert(name, filename string) *x509.CertPool {
+	pool, detail, err := parseCertFile(filename)
+	r.add(name, filename, detail, err)
+	return pool
+}
+
+// checkHost checks that the provided host accepts connections and completes a
+// TLS handshake with the provided configuration.  Unix sockets are only
+// connected to.
+func (r *configReport) checkHost(name, host string, tc *tls.Config) {
+	var (
+		conn net.Conn
+		err  error
+	)
+	if strings.HasPrefix(host, unixListenerPrefix) {
+		conn, err = net.DialTimeout("unix",
+			strings.TrimPrefix(host, unixListenerPrefix), checkTimeout)
+	} else {
+		dialer := &net.Dialer{Timeout: checkTimeout}
+		conn, err = tls.DialWithDialer(dialer, "tcp", host, tc)
+	}
+	if err != nil {
+		r.add(name, host, "", err)
+		return
+	}
+	conn.Close()
+	r.add(name, host, "reachable", nil)
+}
+
+// checkUpstream checks the certificate and the reachability of a storehost or
+// replicatehost.
+func (r *configReport) checkUpstream(name, host, certName, certFile string) {
+	if strings.HasPrefix(host, unixListenerPrefix) {
+		r.checkHost(name, host, nil)
+		return
+	}
+	pool := r.checkCert(certName, certFile)
+	if pool == nil {
+		r.add(name, host, "", fmt.Errorf("not checked, invalid "+
+			"certificate"))
+		return
+	}
+	r.checkHost(name, host, &tls.Config{RootCAs: pool})
+}
+
+// checkDataDir checks that the data directory is a directory or can be
+// created.
+func (r *configReport) checkDataDir(dir string) {
+	fi, err := os.Stat(dir)
+	switch {
+	case err == nil && !fi.IsDir():
+		r.add("datadir", dir, "", fmt.Errorf("not a directory"))
+	case err == nil:
+		r.add("datadir", dir, "exists", nil)
+	case os.IsNotExist(err):
+		// Find the closest parent that exists.
+		parent := filepath.Dir(dir)
+		for {
+			fi, err := os.Stat(parent)
+			if err == nil {
+				if !fi.IsDir() {
+					r.add("datadir", dir, "", fmt.Errorf(
+						"%v is not a directory", parent))
+				} else {
+					r.add("datadir", dir, "created at "+
+						"startup", nil)
+				}
+				return
+			}
+			if !os.IsNotExist(err) || parent == filepath.Dir(parent) {
+				r.add("datadir", dir, "", err)
+				return
+			}
+			parent = filepath.Dir(parent)
+		}
+	default:
+		r.add("datadir", dir, "", err)
+	}
+}
+
+// checkHTTPS checks the https certificate and key of the listeners.
+func (r *configReport) checkHTTPS(cfg *config) {
+	if len(cfg.ACMEDomains) != 0 {
+		r.add("httpscert", "", "obtained through ACME", nil)
+		return
+	}
+	certExists := fileExists(cfg.HTTPSCert)
+	keyExists := fileExists(cfg.HTTPSKey)
+	switch {
+	case !certExists && !keyExists:
+		r.add("httpscert", cfg.HTTPSCert, "generated at startup", nil)
+	case !certExists:
+		r.add("httpscert", cfg.HTTPSCert, "", fmt.Errorf("httpskey "+
+			"%v exists without certificate", cfg.HTTPSKey))
+	case !keyExists:
+		r.add("httpskey", cfg.HTTPSKey, "", fmt.Errorf("httpscert "+
+			"%v exists without key", cfg.HTTPSCert))
+	default:
+		if r.checkCert("httpscert", cfg.HTTPSCert) == nil {
+			return
+		}
+		_, err := tls.LoadX509KeyPair(cfg.HTTPSCert, cfg.HTTPSKey)
+		r.add("httpskey", cfg.HTTPSKey, "", err)
+	}
+}
+
+// checkConfig checks the loaded configuration like dcrtimed would use it
+// without starting it.  Certificates are parsed and the hosts dcrtimed
+// connects to are dialed.
+func checkConfig(cfg *config) *configReport {
+	r := configReport{
+		Valid:      true,
+		Version:    version(),
+		ConfigFile: cfg.ConfigFile,
+		Network:    activeNetParams.Name,
+		Mode:       "store",
+	}
+	detail := "valid"
+	if !fileExists(cfg.ConfigFile) {
+		detail = "configuration file not found, defaults used"
+	}
+	r.add("config", cfg.ConfigFile, detail, nil)
+
+	r.checkDataDir(cfg.DataDir)
+	r.checkHTTPS(cfg)
+	if cfg.ClientCA != "" {
+		r.checkCert("clientca", cfg.ClientCA)
+	}
+
+	switch {
+	case cfg.StoreHost != "":
+		r.Mode = "proxy"
+		r.checkUpstream("storehost", cfg.StoreHost, "storecert",
+			cfg.StoreCert)
+		if cfg.StoreFailoverHost != "" {
+			r.checkUpstream("storefailoverhost",
+				cfg.StoreFailoverHost, "storefailovercert",
+				cfg.StoreFailoverCert)
+		}
+		for k, host := range cfg.StoreFanoutHosts {
+			r.checkUpstream("storefanouthost", host,
+				"storefanoutcert", cfg.StoreFanoutCerts[k])
+		}
+
+	case cfg.DcrdHost != "":
+		pool := r.checkCert("dcrdcert", cfg.DcrdCert)
+		if pool != nil {
+			r.checkHost("dcrdhost", cfg.DcrdHost,
+				&tls.Config{RootCAs: pool})
+		}
+
+	default:
+		pool := r.checkCert("walletcert", cfg.WalletCert)
+		tc := &tls.Config{RootCAs: pool}
+		if fileExists(cfg.WalletClientCert) ||
+			fileExists(cfg.WalletClientKey) {
+			cert, err := tls.LoadX509KeyPair(cfg.WalletClientCert,
+				cfg.WalletClientKey)
+			r.add("cert", cfg.WalletClientCert, "", err)
+			if err == nil {
+				tc.Certificates = []tls.Certificate{cert}
+			}
+		}
+		if pool != nil {
+			r.checkHost("wallethost", cfg.WalletHost, tc)
+		}
+	}
+
+	if cfg.StoreHost == "" {
+		anchorers, err := anchorer.ParseAll(cfg.Anchorers)
+		if len(cfg.Anchorers) != 0 || err != nil {
+			r.add("anchorer", strings.Join(cfg.Anchorers, ","),
+				fmt.Sprintf("%v anchorer(s)", len(anchorers)), err)
+		}
+	}
+	if cfg.ReplicateHost != "" {
+		r.checkUpstream("replicatehost", cfg.ReplicateHost,
+			"replicatecert", cfg.ReplicateCert)
+	}
+	if cfg.SelfTestCert != "" && cfg.SelfTestCert != cfg.HTTPSCert {
+		r.checkCert("selftestcert", cfg.SelfTestCert)
+	}
+	if cfg.IngestCert != "" {
+		r.checkCert("ingestcert", cfg.IngestCert)
+	}
+
+	return &r
+}
@@ -0,0 +1,53 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// clientCertPrefix prefixes the identity of clients that authenticated with a
+// client certificate in logs and submission records, which sets them apart
+// from api token IDs.
+const clientCertPrefix = "cn:"
+
+// loadClientCAs returns the pool of the CA certificates in the provided PEM
+// bundle.
+func loadClientCAs(file string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates in %v", file)
+	}
+	return pool, nil
+}
+
+// clientCertCN returns the common name of the client certificate of the
+// request, or an empty string if the client did not present a certificate
+// that was issued by one of the clientca certificates.  Certificates without
+// a common name are ignored.
+func clientCertCN(r *http.Request) string {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 ||
+		len(r.TLS.VerifiedChains[0]) == 0 {
+		return ""
+	}
+	return r.TLS.VerifiedChains[0][0].Subject.CommonName
+}
+
+// clientCertIdentity returns the identity of the client certificate of the
+// request as it is logged and recorded, or an empty string if there is none.
+func clientCertIdentity(r *http.Request) string {
+	cn := clientCertCN(r)
+	if cn == "" {
+		return ""
+	}
+	return clientCertPrefix + cn
+}
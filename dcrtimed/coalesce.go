@@ -0,0 +1,199 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"time"
+
+	"github.com/decred/dcrtime/dcrtimed/backend"
+)
+
+// maxCoalesceDelay is the maximum coalescedelay, submissions must not wait
+// longer than that for others to share their write.
+const maxCoalesceDelay = time.Second
+
+// putRequest is a submission that waits to be written together with
+// concurrent submissions.
+type putRequest struct {
+	digests   [][sha256.Size]byte
+	label     string
+	algorithm string
+	reply     chan putReply
+}
+
+// putReply is the outcome of the write of a putRequest.
+type putReply struct {
+	ts  int64
+	me  []backend.PutResult
+	err error
+}
+
+// coalescer coalesces concurrent submissions into grouped backend writes.
+// The first submission of a write waits up to delay for others to arrive,
+// further submissions arrive while a write is in progress and are written
+// next.  Every write of the filesystem backend is synced, so grouped writes
+// save a sync per submission under load.
+type coalescer struct {
+	backend    backend.Backend
+	delay      time.Duration
+	maxDigests int
+	requests   chan *putRequest
+	done       chan struct{} // Closed when run returns
+}
+
+// newCoalescer returns a coalescer of the provided backend.  It writes at
+// most maxDigests digests at once unless a single submission is larger.
+func newCoalescer(b backend.Backend, delay time.Duration, maxDigests int) *coalescer {
+	return &coalescer{
+		backend:    b,
+		delay:      delay,
+		maxDigests: maxDigests,
+		requests:   make(chan *putRequest),
+		done:       make(chan struct{}),
+	}
+}
+
+// put stores the provided digests like backend.Put, together with concurrent
+// submissions.  It gives up when the provided context is done, the digests may
+// still be written in that case.  ErrTryAgainLater is returned once the
+// coalescer stopped.
+func (c *coalescer) put(ctx context.Context, digests [][sha256.Size]byte, label, algorithm string) (int64, []backend.PutResult, error) {
+	r := &putRequest{
+		digests:   digests,
+		label:     label,
+		algorithm: algorithm,
+		reply:     make(chan putReply, 1),
+	}
+	select {
+	case <-ctx.Done():
+		return 0, nil, ctx.Err()
+	case <-c.done:
+		return 0, nil, backend.ErrTryAgainLater
+	case c.requests <- r:
+	}
+	select {
+	case <-ctx.Done():
+		return 0, nil, ctx.Err()
+	case reply := <-r.reply:
+		return reply.ts, reply.me, reply.err
+	}
+}
+
+// run collects submissions and writes them until the context is done.
+func (c *coalescer) run(ctx context.Context) {
+	defer close(c.done)
+
+	for {
+		var pending []*putRequest
+		select {
+		case <-ctx.Done():
+			return
+		case r := <-c.requests:
+			pending = append(pending, r)
+		}
+
+		n := len(pending[0].digests)
+		timer := time.NewTimer(c.delay)
+	collect:
+		for n < c.maxDigests {
+			select {
+			case r := <-c.requests:
+				pending = append(pending, r)
+				n += len(r.digests)
+			case <-timer.C:
+				break collect
+			}
+		}
+		timer.Stop()
+
+		c.write(pending)
+	}
+}
+
+// coalesceGroup holds the submissions that share a group label and digest
+// algorithm and are therefore written together.
+type coalesceGroup struct {
+	label     string
+	algorithm string
+	digests   [][sha256.Size]byte
+	index     map[[sha256.Size]byte]int // Position in digests
+	owner     map[[sha256.Size]byte]*putRequest
+	requests  []*putRequest
+}
+
+// write writes the provided submissions, one backend write per group, and
+// replies to every submission with the results of its digests.  A digest that
+// a concurrent submission of the same write already stores is reported as
+// existing, as if the submissions had been written one after the other.
+// Duplicates within one submission share a result like they do in a backend
+// write.
+func (c *coalescer) write(pending []*putRequest) {
+	var groups []*coalesceGroup
+	for _, r := range pending {
+		var g *coalesceGroup
+		for _, v := range groups {
+			if v.label == r.label && v.algorithm == r.algorithm {
+				g = v
+				break
+			}
+		}
+		if g == nil {
+			g = &coalesceGroup{
+				label:     r.label,
+				algorithm: r.algorithm,
+				index:     make(map[[sha256.Size]byte]int),
+				owner:     make(map[[sha256.Size]byte]*putRequest),
+			}
+			groups = append(groups, g)
+		}
+		for _, digest := range r.digests {
+			if _, ok := g.index[digest]; ok {
+				continue
+			}
+			g.index[digest] = len(g.digests)
+			g.owner[digest] = r
+			g.digests = append(g.digests, digest)
+		}
+		g.requests = append(g.requests, r)
+	}
+
+	for _, g := range groups {
+		ts, me, err := c.backend.Put(g.digests, g.label, g.algorithm)
+		if err == nil && len(me) != len(g.digests) {
+			// The results are matched to the digests by position.
+			err = backend.ErrTryAgainLater
+			log.Errorf("Coalesce: %v results for %v digests",
+				len(me), len(g.digests))
+		}
+		for _, r := range g.requests {
+			if err != nil {
+				r.reply <- putReply{err: err}
+				continue
+			}
+			results := make([]backend.PutResult, 0, len(r.digests))
+			for _, digest := range r.digests {
+				pr := me[g.index[digest]]
+				if g.owner[digest] != r &&
+					pr.ErrorCode == backend.ErrorOK {
+					pr.ErrorCode = backend.ErrorExists
+				}
+				results = append(results, pr)
+			}
+			r.reply <- putReply{ts: ts, me: results}
+		}
+	}
+}
+
+// put stores the provided digests in the backend, together with concurrent
+// submissions if coalescing is enabled.  A coalesced submission is abandoned
+// when the provided context is done.
+func (d *DcrtimeStore) put(ctx context.Context, digests [][sha256.Size]byte, label, algorithm string) (int64, []backend.PutResult, error) {
+	if d.coalescer == nil {
+		return d.backend.Put(digests, label, algorithm)
+	}
+	return d.coalescer.put(ctx, digests, label, algorithm)
+}
@@ -0,0 +1,216 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/decred/dcrtime/dcrtimed/backend"
+	"github.com/decred/dcrtime/dcrtimed/backend/backendtest"
+	"github.com/decred/dcrtime/dcrtimed/backend/testsuite"
+)
+
+// countingBackend counts the writes of the backend it wraps.  Writes block
+// while release is not nil and not closed.
+type countingBackend struct {
+	backend.Backend
+
+	sync.Mutex
+	puts    int
+	release chan struct{}
+}
+
+// Put satisfies the backend.Backend interface.
+func (b *countingBackend) Put(digests [][sha256.Size]byte, label, algorithm string) (int64, []backend.PutResult, error) {
+	b.Lock()
+	b.puts++
+	release := b.release
+	b.Unlock()
+
+	if release != nil {
+		<-release
+	}
+	return b.Backend.Put(digests, label, algorithm)
+}
+
+// writes returns the number of backend writes.
+func (b *countingBackend) writes() int {
+	b.Lock()
+	defer b.Unlock()
+	return b.puts
+}
+
+// newTestCoalescer returns a running coalescer that writes once maxDigests
+// digests were submitted.  It is stopped when the test ends.
+func newTestCoalescer(t *testing.T, maxDigests int) (*coalescer, *countingBackend) {
+	t.Helper()
+
+	b := &countingBackend{
+		Backend: backendtest.New(testsuite.NewWallet(), 1),
+	}
+	c := newCoalescer(b, maxCoalesceDelay, maxDigests)
+	ctx, cancel := context.WithCancel(context.Background())
+	go c.run(ctx)
+	t.Cleanup(cancel)
+	return c, b
+}
+
+// TestCoalesceDuplicates verifies that concurrent submissions of the same
+// digests are written at once and that exactly one of them stores each
+// digest.
+func TestCoalesceDuplicates(t *testing.T) {
+	const submissions = 8
+	c, b := newTestCoalescer(t, submissions*3)
+
+	shared := [][sha256.Size]byte{
+		sha256.Sum256([]byte("a")),
+		sha256.Sum256([]byte("b")),
+	}
+	type result struct {
+		ts  int64
+		me  []backend.PutResult
+		err error
+	}
+	results := make([]result, submissions)
+	var wg sync.WaitGroup
+	for i := 0; i < submissions; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			digests := append([][sha256.Size]byte{
+				sha256.Sum256([]byte{byte(i)}),
+			}, shared...)
+			var r result
+			r.ts, r.me, r.err = c.put(context.Background(), digests,
+				"", "")
+			results[i] = r
+		}(i)
+	}
+	wg.Wait()
+
+	if n := b.writes(); n != 1 {
+		t.Fatalf("got %v backend writes, want 1", n)
+	}
+	owners := make(map[[sha256.Size]byte]int)
+	for i, r := range results {
+		if r.err != nil {
+			t.Fatalf("submission %v: %v", i, r.err)
+		}
+		if r.ts != results[0].ts {
+			t.Fatalf("submission %v: got timestamp %v, want %v", i,
+				r.ts, results[0].ts)
+		}
+		if len(r.me) != 1+len(shared) {
+			t.Fatalf("submission %v: got %v results, want %v", i,
+				len(r.me), 1+len(shared))
+		}
+		if r.me[0].ErrorCode != backend.ErrorOK {
+			t.Fatalf("submission %v: own digest got %v", i,
+				r.me[0].ErrorCode)
+		}
+		for k, pr := range r.me[1:] {
+			if pr.Digest != shared[k] {
+				t.Fatalf("submission %v: got digest %x, want %x",
+					i, pr.Digest, shared[k])
+			}
+			switch pr.ErrorCode {
+			case backend.ErrorOK:
+				owners[pr.Digest]++
+			case backend.ErrorExists:
+			default:
+				t.Fatalf("submission %v: got %v", i, pr.ErrorCode)
+			}
+		}
+	}
+	for _, digest := range shared {
+		if owners[digest] != 1 {
+			t.Fatalf("digest %x stored by %v submissions, want 1",
+				digest, owners[digest])
+		}
+	}
+
+	// Digests that were stored before are existing for every submission.
+	_, me, err := c.put(context.Background(), shared, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, pr := range me {
+		if pr.ErrorCode != backend.ErrorExists {
+			t.Fatalf("got %v, want %v", pr.ErrorCode,
+				backend.ErrorExists)
+		}
+	}
+}
+
+// TestCoalesceGroups verifies that submissions with different group labels
+// are written separately.
+func TestCoalesceGroups(t *testing.T) {
+	c, b := newTestCoalescer(t, 2)
+
+	digest := sha256.Sum256([]byte("a"))
+	var wg sync.WaitGroup
+	codes := make([]int, 2)
+	for i, label := range []string{"x", "y"} {
+		wg.Add(1)
+		go func(i int, label string) {
+			defer wg.Done()
+			_, me, err := c.put(context.Background(),
+				[][sha256.Size]byte{digest}, label, "")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			codes[i] = int(me[0].ErrorCode)
+		}(i, label)
+	}
+	wg.Wait()
+
+	if n := b.writes(); n != 2 {
+		t.Fatalf("got %v backend writes, want 2", n)
+	}
+	if codes[0]+codes[1] != int(backend.ErrorOK+backend.ErrorExists) {
+		t.Fatalf("got results %v, want one stored and one existing",
+			codes)
+	}
+}
+
+// TestCoalesceContext verifies that submissions give up when their context is
+// done or the coalescer stopped.
+func TestCoalesceContext(t *testing.T) {
+	b := &countingBackend{
+		Backend: backendtest.New(testsuite.NewWallet(), 1),
+		release: make(chan struct{}),
+	}
+	c := newCoalescer(b, time.Millisecond, 1)
+	runCtx, stop := context.WithCancel(context.Background())
+	go c.run(runCtx)
+	digests := [][sha256.Size]byte{sha256.Sum256([]byte("a"))}
+
+	// The write blocks, the submission gives up while waiting for it.
+	ctx, cancel := context.WithTimeout(context.Background(),
+		50*time.Millisecond)
+	defer cancel()
+	_, _, err := c.put(ctx, digests, "", "")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got error %v, want %v", err, context.DeadlineExceeded)
+	}
+	if n := b.writes(); n != 1 {
+		t.Fatalf("got %v backend writes, want 1", n)
+	}
+
+	// Submissions are refused once the coalescer stopped.
+	stop()
+	close(b.release)
+	<-c.done
+	_, _, err = c.put(context.Background(), digests, "", "")
+	if !errors.Is(err, backend.ErrTryAgainLater) {
+		t.Fatalf("got error %v, want %v", err, backend.ErrTryAgainLater)
+	}
+}
@@ -0,0 +1,499 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	v2 "github.com/decred/dcrtime/api/v2"
+	"github.com/decred/dcrtime/dcrtimed/backend"
+	"github.com/decred/dcrtime/merkle"
+	"github.com/decred/dcrtime/util"
+)
+
+// collectionOwner returns the owner of the collections of the request, which
+// is the public ID of its api token, or an empty string if the request does
+// not carry a valid api token with the timestamp scope.
+func (d *DcrtimeStore) collectionOwner(r *http.Request) string {
+	if !hasScope(d.tokenScopes(r), v2.TokenScopeTimestamp) {
+		return ""
+	}
+	return apiTokenID(r.URL.Query().Get("apitoken"))
+}
+
+// defaultCollection returns the default collection name of the api token of
+// the request.  Tokens that were provided in the server configuration have
+// none.
+func (d *DcrtimeStore) defaultCollection(r *http.Request) string {
+	apiToken := r.URL.Query().Get("apitoken")
+	if _, ok := d.apiTokens[apiToken]; ok {
+		return ""
+	}
+	t, err := d.backend.GetToken(sha256.Sum256([]byte(apiToken)))
+	if err != nil {
+		if !errors.Is(err, backend.ErrTokenNotFound) {
+			log.Errorf("defaultCollection: %v", err)
+		}
+		return ""
+	}
+	return t.Collection
+}
+
+// recordOwner records the api token of the request as the owner of the
+// digests that were accepted into the collection with the provided timestamp.
+// Owners are recorded for collections and for private digests.  Collections
+// that were not named yet are filed under the default collection name of the
+// api token.  Failures are logged only since the digests were already
+// timestamped.
+func (d *DcrtimeStore) recordOwner(r *http.Request, ts int64, me []backend.PutResult) {
+	if !d.cfg.EnableCollections && !d.cfg.PrivateDigests {
+		return
+	}
+	owner := d.collectionOwner(r)
+	if owner == "" {
+		return
+	}
+
+	var name string
+	if d.cfg.EnableCollections {
+		name = d.defaultCollection(r)
+	}
+	err := d.storeOwner(owner, name, ts, me)
+	if err != nil {
+		log.Errorf("%v recordOwner %v: %v", logAddr(r), ts, err)
+	}
+}
+
+// storeOwner stores the provided api token owner as the owner of the digests
+// that were accepted into the collection with the provided timestamp, and
+// files a collection that was not named yet under the provided name.
+func (d *DcrtimeStore) storeOwner(owner, name string, ts int64, me []backend.PutResult) error {
+	if owner == "" {
+		return nil
+	}
+
+	digests := make([][sha256.Size]byte, 0, len(me))
+	for _, v := range me {
+		if v.ErrorCode == backend.ErrorOK {
+			digests = append(digests, v.Digest)
+		}
+	}
+	if len(digests) == 0 {
+		return nil
+	}
+
+	return d.backend.PutOwner(owner, ts, name, digests)
+}
+
+// inSubtree returns true if the collection name is part of the subtree with
+// the provided root name.  Every collection is part of the subtree with an
+// empty root name.
+func inSubtree(name, root string) bool {
+	return root == "" || name == root || strings.HasPrefix(name, root+"/")
+}
+
+// decodeCollection decodes the request body into v.  It replies to the client
+// and returns false if the request is invalid.
+func decodeCollection(w http.ResponseWriter, body io.Reader, v interface{}) bool {
+	decoder := json.NewDecoder(body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		util.RespondWithError(w, http.StatusBadRequest,
+			"Invalid request payload")
+		return false
+	}
+	return true
+}
+
+// respondWithCollectionError replies to the client with the status that
+// matches the provided collection error.
+func respondWithCollectionError(w http.ResponseWriter, r *http.Request, method, action string, err error) {
+	switch {
+	case errors.Is(err, backend.ErrCollectionNotFound):
+		util.RespondWithError(w, http.StatusNotFound,
+			"Collection not found")
+	case errors.Is(err, backend.ErrCollectionAnchored):
+		util.RespondWithError(w, http.StatusConflict,
+			"Collection is anchored")
+	case errors.Is(err, backend.ErrCollectionShared):
+		util.RespondWithError(w, http.StatusConflict,
+			"Collection holds digests of other clients")
+	default:
+		errorCode := time.Now().Unix()
+		log.Errorf("%v %v error code %v: %v", logAddr(r), method,
+			errorCode, err)
+		util.RespondWithError(w, http.StatusInternalServerError,
+			fmt.Sprintf("failed to %v, "+
+				"contact administrator and provide "+
+				"the following error code: %v", action, errorCode))
+	}
+}
+
+// collectionsV2 returns the collections that the api token of the request
+// timestamped digests in.
+// Handles /v2/collections
+func (d *DcrtimeStore) collectionsV2(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	owner := d.collectionOwner(r)
+	if owner == "" {
+		util.RespondWithError(w, http.StatusUnauthorized, "not authorized")
+		return
+	}
+
+	var c v2.Collections
+	if !decodeCollection(w, r.Body, &c) {
+		return
+	}
+	if !v2.RegexpCollectionName.MatchString(c.Name) {
+		util.RespondWithError(w, http.StatusBadRequest,
+			"Invalid collection name")
+		return
+	}
+
+	collections, err := d.backend.GetCollections(owner)
+	if err != nil {
+		respondWithCollectionError(w, r, "Collections",
+			"retrieve collections", err)
+		return
+	}
+
+	reply := v2.CollectionsReply{
+		ID:          c.ID,
+		Collections: make([]v2.Collection, 0, len(collections)),
+	}
+	for _, bc := range collections {
+		if !inSubtree(bc.Name, c.Name) {
+			continue
+		}
+		reply.Collections = append(reply.Collections, v2.Collection{
+			ServerTimestamp: bc.Timestamp,
+			ServerTime:      v2.FormatTime(bc.Timestamp),
+			Name:            bc.Name,
+			DigestCount:     bc.Digests,
+			Anchored:        bc.Anchored,
+		})
+	}
+
+	log.Infof("%v Collections %v: %v", r.URL.Path, logAddr(r), owner)
+
+	util.RespondWithJSON(w, http.StatusOK, reply)
+}
+
+// collectionRenameV2 gives a collection of the api token of the request a
+// human-readable name.
+// Handles /v2/collections/rename
+func (d *DcrtimeStore) collectionRenameV2(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	owner := d.collectionOwner(r)
+	if owner == "" {
+		util.RespondWithError(w, http.StatusUnauthorized, "not authorized")
+		return
+	}
+
+	var cr v2.CollectionRename
+	if !decodeCollection(w, r.Body, &cr) {
+		return
+	}
+	if !v2.RegexpCollectionName.MatchString(cr.Name) {
+		util.RespondWithError(w, http.StatusBadRequest,
+			"Invalid collection name")
+		return
+	}
+
+	err := d.backend.RenameCollection(owner, cr.ServerTimestamp, cr.Name)
+	if err != nil {
+		respondWithCollectionError(w, r, "CollectionRename",
+			"rename collection", err)
+		return
+	}
+
+	log.Infof("%v CollectionRename %v: %v %v %q", r.URL.Path, logAddr(r),
+		owner, cr.ServerTimestamp, cr.Name)
+
+	util.RespondWithJSON(w, http.StatusOK, v2.CollectionRenameReply{
+		ID:              cr.ID,
+		ServerTimestamp: cr.ServerTimestamp,
+		Name:            cr.Name,
+	})
+}
+
+// collectionDeleteV2 deletes a collection that was not anchored yet and only
+// holds digests of the api token of the request.
+// Handles /v2/collections/delete
+func (d *DcrtimeStore) collectionDeleteV2(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	owner := d.collectionOwner(r)
+	if owner == "" {
+		util.RespondWithError(w, http.StatusUnauthorized, "not authorized")
+		return
+	}
+
+	var cd v2.CollectionDelete
+	if !decodeCollection(w, r.Body, &cd) {
+		return
+	}
+
+	err := d.backend.DeleteCollection(owner, cd.ServerTimestamp)
+	if err != nil {
+		respondWithCollectionError(w, r, "CollectionDelete",
+			"delete collection", err)
+		return
+	}
+
+	log.Infof("%v CollectionDelete %v: %v %v", r.URL.Path, logAddr(r),
+		owner, cd.ServerTimestamp)
+
+	util.RespondWithJSON(w, http.StatusOK, v2.CollectionDeleteReply{
+		ID:              cd.ID,
+		ServerTimestamp: cd.ServerTimestamp,
+	})
+}
+
+// collectionProof returns the proof of the digests the owner timestamped in
+// the collection.
+func collectionProof(bc backend.Collection, tr backend.TimestampResult, owned [][sha256.Size]byte) (v2.CollectionProof, error) {
+	cp := v2.CollectionProof{
+		ServerTimestamp: bc.Timestamp,
+		ServerTime:      v2.FormatTime(bc.Timestamp),
+		Name:            bc.Name,
+		Digests:         make([]string, 0, len(owned)),
+	}
+	for _, digest := range owned {
+		cp.Digests = append(cp.Digests, hex.EncodeToString(digest[:]))
+	}
+	if tr.ErrorCode != backend.ErrorOK || tr.AnchoredTimestamp == 0 {
+		return cp, nil
+	}
+
+	// The leaves of the merkle tree are sorted.
+	leaves := make([]*[sha256.Size]byte, 0, len(tr.Digests))
+	for k := range tr.Digests {
+		leaves = append(leaves, &tr.Digests[k])
+	}
+	sort.Slice(leaves, func(i, j int) bool {
+		return bytes.Compare(leaves[i][:], leaves[j][:]) < 0
+	})
+	matched := make([]*[sha256.Size]byte, 0, len(owned))
+	for k := range owned {
+		matched = append(matched, &owned[k])
+	}
+	mb := merkle.MultiAuthPath(leaves, matched)
+	if mb == nil {
+		return cp, fmt.Errorf("collection %v: no digests", bc.Timestamp)
+	}
+	err := merkle.VerifyInclusions(mb, matched, &tr.MerkleRoot)
+	if err != nil {
+		return cp, fmt.Errorf("collection %v: %v", bc.Timestamp, err)
+	}
+
+	branch := v2.BranchJSON{
+		NumLeaves: mb.NumLeaves,
+		Hashes:    make([]string, 0, len(mb.Hashes)),
+		Flags:     hex.EncodeToString(mb.Flags),
+	}
+	for _, hash := range mb.Hashes {
+		branch.Hashes = append(branch.Hashes, hex.EncodeToString(hash[:]))
+	}
+	cp.Anchored = true
+	cp.ChainTimestamp = tr.AnchoredTimestamp
+	cp.ChainTime = v2.FormatTime(tr.AnchoredTimestamp)
+	cp.Transaction = tr.Tx.String()
+	cp.MerkleRoot = hex.EncodeToString(tr.MerkleRoot[:])
+	cp.MerkleBranch = &branch
+
+	return cp, nil
+}
+
+// collectionReceiptV2 returns a single receipt for the digests of all
+// collections of a collection subtree of the api token of the request.
+// Handles /v2/collections/receipt
+func (d *DcrtimeStore) collectionReceiptV2(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	owner := d.collectionOwner(r)
+	if owner == "" {
+		util.RespondWithError(w, http.StatusUnauthorized, "not authorized")
+		return
+	}
+
+	var cr v2.CollectionReceipt
+	if !decodeCollection(w, r.Body, &cr) {
+		return
+	}
+	if !v2.RegexpCollectionName.MatchString(cr.Name) {
+		util.RespondWithError(w, http.StatusBadRequest,
+			"Invalid collection name")
+		return
+	}
+
+	err := func() error {
+		collections, err := d.backend.GetCollections(owner)
+		if err != nil {
+			return err
+		}
+		subtree := make([]backend.Collection, 0, len(collections))
+		timestamps := make([]int64, 0, len(collections))
+		for _, bc := range collections {
+			if inSubtree(bc.Name, cr.Name) {
+				subtree = append(subtree, bc)
+				timestamps = append(timestamps, bc.Timestamp)
+			}
+		}
+		if len(subtree) == 0 {
+			return backend.ErrCollectionNotFound
+		}
+		trs, err := d.backend.GetTimestamps(timestamps)
+		if err != nil {
+			return err
+		}
+		if len(trs) != len(subtree) {
+			return fmt.Errorf("got %v timestamps, want %v", len(trs),
+				len(subtree))
+		}
+
+		reply := v2.CollectionReceiptReply{
+			ID:          cr.ID,
+			Name:        cr.Name,
+			Collections: make([]v2.CollectionProof, 0, len(subtree)),
+		}
+		for k, bc := range subtree {
+			owned, err := d.backend.GetCollectionDigests(owner,
+				bc.Timestamp)
+			if err != nil {
+				return err
+			}
+			cp, err := collectionProof(bc, trs[k], owned)
+			if err != nil {
+				return err
+			}
+			reply.DigestCount += len(owned)
+			if cp.Anchored {
+				reply.AnchoredCount += len(owned)
+			}
+			reply.Collections = append(reply.Collections, cp)
+		}
+
+		log.Infof("%v CollectionReceipt %v: %v %q collections %v "+
+			"digests %v", r.URL.Path, logAddr(r), owner, cr.Name,
+			len(reply.Collections), reply.DigestCount)
+
+		util.RespondWithJSON(w, http.StatusOK, reply)
+		return nil
+	}()
+	if err != nil {
+		respondWithCollectionError(w, r, "CollectionReceipt",
+			"create collection receipt", err)
+	}
+}
+
+func (d *DcrtimeStore) proxyCollectionsV2(w http.ResponseWriter, r *http.Request) {
+	b, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		util.RespondWithError(w, http.StatusBadRequest,
+			"Unable to read request")
+		return
+	}
+
+	var c v2.Collections
+	if !decodeCollection(w, bytes.NewReader(b), &c) {
+		return
+	}
+
+	d.sendToBackend(r.Context(), w, r.Method,
+		withAPIToken(v2.CollectionsRoute, r), r.Header.Get("Content-Type"),
+		r.RemoteAddr, bytes.NewReader(b))
+
+	log.Infof("%v Collections %v", r.URL.Path, logAddr(r))
+}
+
+func (d *DcrtimeStore) proxyCollectionRenameV2(w http.ResponseWriter, r *http.Request) {
+	b, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		util.RespondWithError(w, http.StatusBadRequest,
+			"Unable to read request")
+		return
+	}
+
+	var cr v2.CollectionRename
+	if !decodeCollection(w, bytes.NewReader(b), &cr) {
+		return
+	}
+	if !v2.RegexpCollectionName.MatchString(cr.Name) {
+		util.RespondWithError(w, http.StatusBadRequest,
+			"Invalid collection name")
+		return
+	}
+
+	d.sendToBackend(r.Context(), w, r.Method,
+		withAPIToken(v2.CollectionRenameRoute, r),
+		r.Header.Get("Content-Type"), r.RemoteAddr, bytes.NewReader(b))
+
+	log.Infof("%v CollectionRename %v: %v %q", r.URL.Path, logAddr(r),
+		cr.ServerTimestamp, cr.Name)
+}
+
+func (d *DcrtimeStore) proxyCollectionDeleteV2(w http.ResponseWriter, r *http.Request) {
+	b, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		util.RespondWithError(w, http.StatusBadRequest,
+			"Unable to read request")
+		return
+	}
+
+	var cd v2.CollectionDelete
+	if !decodeCollection(w, bytes.NewReader(b), &cd) {
+		return
+	}
+
+	d.sendToBackend(r.Context(), w, r.Method,
+		withAPIToken(v2.CollectionDeleteRoute, r),
+		r.Header.Get("Content-Type"), r.RemoteAddr, bytes.NewReader(b))
+
+	log.Infof("%v CollectionDelete %v: %v", r.URL.Path, logAddr(r),
+		cd.ServerTimestamp)
+}
+
+func (d *DcrtimeStore) proxyCollectionReceiptV2(w http.ResponseWriter, r *http.Request) {
+	b, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		util.RespondWithError(w, http.StatusBadRequest,
+			"Unable to read request")
+		return
+	}
+
+	var cr v2.CollectionReceipt
+	if !decodeCollection(w, bytes.NewReader(b), &cr) {
+		return
+	}
+	if !v2.RegexpCollectionName.MatchString(cr.Name) {
+		util.RespondWithError(w, http.StatusBadRequest,
+			"Invalid collection name")
+		return
+	}
+
+	d.sendToBackend(r.Context(), w, r.Method,
+		withAPIToken(v2.CollectionReceiptRoute, r),
+		r.Header.Get("Content-Type"), r.RemoteAddr, bytes.NewReader(b))
+
+	log.Infof("%v CollectionReceipt %v: %q", r.URL.Path, logAddr(r),
+		cr.Name)
+}
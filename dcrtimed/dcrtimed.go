@@ -8,24 +8,28 @@ import (
 	"bytes"
 	"context"
 	"crypto/sha256"
-	"crypto/tls"
-	"crypto/x509"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/netip"
+	"net/url"
 	"os"
 	"os/signal"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	v1 "github.com/decred/dcrtime/api/v1"
 	v2 "github.com/decred/dcrtime/api/v2"
+	"github.com/decred/dcrtime/dcrtimed/anchorer"
 	"github.com/decred/dcrtime/dcrtimed/backend"
 	"github.com/decred/dcrtime/dcrtimed/backend/filesystem"
+	"github.com/decred/dcrtime/dcrtimed/backend/leveldb"
+	"github.com/decred/dcrtime/dcrtimed/backend/sidestore"
 	"github.com/decred/dcrtime/dcrtimed/dcrtimewallet"
 	"github.com/decred/dcrtime/util"
 	"github.com/gorilla/handlers"
@@ -42,68 +46,107 @@ var interruptSignals = []os.Signal{os.Interrupt}
 
 // DcrtimeStore application context.
 type DcrtimeStore struct {
-	backend    backend.Backend
-	cfg        *config
-	router     *mux.Router
-	ctx        context.Context
-	httpClient *http.Client
-	apiTokens  map[string]struct{}
-}
+	backend   backend.Backend
+	cfg       *config
+	router    *mux.Router
+	ctx       context.Context
+	upstreams []*upstream // Storehosts, primary first, proxy mode only
+	fanouts   []*upstream // Independent storehosts, proxy mode only
+	primary   *upstream   // Replicated primary, standby only
+	apiTokens map[string]struct{}
+	started   time.Time // Start of day
 
-func (d *DcrtimeStore) sendToBackend(ctx context.Context, w http.ResponseWriter, method, route, contentType, remoteAddr string, body *bytes.Reader) {
-	storeHost := fmt.Sprintf("https://%s%s", d.cfg.StoreHost, route)
-	req, err := http.NewRequestWithContext(ctx, method, storeHost, body)
-	if err != nil {
-		log.Errorf("Error generating new http request: %v", err)
-		util.RespondWithError(w, http.StatusServiceUnavailable,
-			"Server failed to generate a new http request")
-		return
-	}
+	scopeMaxDigests map[string]int32 // MaxDigests overrides by token scope
 
-	req.Header.Set("Content-Type", contentType)
-	req.Header.Set(forward, remoteAddr)
+	identityKeys []identityKey // Identity of the server, oldest first
 
-	resp, err := d.httpClient.Do(req)
-	if err != nil {
-		log.Errorf("Error posting to storehost: %v", err)
-		util.RespondWithError(w, http.StatusServiceUnavailable,
-			"Server busy, please try again later.")
-		return
-	}
+	ipPolicies     map[string]*ipPolicy // Client address policies by endpoint class
+	trustedProxies []netip.Prefix       // Reverse proxies that forward client addresses
+
+	verifyCache *verifyCache // Anchored proofs, nil if disabled
+
+	accessSecret []byte // Secret of access keys, private digests only
+
+	idempotency *idempotencyCache // Replies of retried timestamp requests
+
+	webhookMtx sync.Mutex // Serializes delivery attempts and admin updates
+
+	selfAuditMtx   sync.Mutex        // Protects the self audit outcome
+	selfAuditStats v2.SelfAuditStats // Outcome of the self audits
+
+	notifiers []notifier // Receive notifications and alerts
 
-	defer resp.Body.Close()
+	coalescer *coalescer // Groups concurrent submissions, nil if disabled
 
-	bodyBuf := new(bytes.Buffer)
-	_, err = bodyBuf.ReadFrom(resp.Body)
+	ticketC chan struct{} // Wakes the processor of asynchronous submissions
+}
+
+func (d *DcrtimeStore) sendToBackend(ctx context.Context, w http.ResponseWriter, method, route, contentType, remoteAddr string, body *bytes.Reader) {
+	resp, err := d.forward(ctx, method, route, contentType, remoteAddr,
+		body)
+	d.respondFromBackend(w, route, resp, err)
+}
+
+// submitToBackend sends a timestamp request to the storehost and all fan-out
+// storehosts and replies once a quorum accepted it.
+func (d *DcrtimeStore) submitToBackend(ctx context.Context, w http.ResponseWriter, method, route, contentType, remoteAddr string, body []byte) {
+	resp, err := d.fanout(ctx, method, route, contentType, remoteAddr,
+		body)
+	d.respondFromBackend(w, route, resp, err)
+}
+
+// respondFromBackend relays the reply of a storehost to the client.
+func (d *DcrtimeStore) respondFromBackend(w http.ResponseWriter, route string, resp *upstreamReply, err error) {
 	if err != nil {
-		log.Errorf("ReadFrom failed: %v", err)
+		var ue *unavailableError
+		if !errors.As(err, &ue) {
+			util.RespondWithError(w, http.StatusServiceUnavailable,
+				"Server busy, please try again later.")
+			return
+		}
+		retryAfter := retryAfterSeconds(ue.retryAfter)
+		w.Header().Set("Retry-After", retryAfter)
 		util.RespondWithError(w, http.StatusServiceUnavailable,
-			"Server busy, please try again later.")
+			fmt.Sprintf("Storehost unavailable, please try again in "+
+				"%v seconds.", retryAfter))
 		return
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		if resp.StatusCode == http.StatusUnauthorized {
-			util.RespondWithCopy(w, http.StatusUnauthorized, "application/json",
-				bodyBuf.Bytes())
+	if resp.statusCode != http.StatusOK &&
+		resp.statusCode != http.StatusAccepted {
+		if resp.statusCode == http.StatusServiceUnavailable {
+			if resp.retryAfter != "" {
+				w.Header().Set("Retry-After", resp.retryAfter)
+			}
+			util.RespondWithCopy(w, http.StatusServiceUnavailable,
+				resp.contentType, resp.body)
+			return
+		}
+		if resp.statusCode == http.StatusUnauthorized ||
+			resp.statusCode == http.StatusForbidden {
+			util.RespondWithCopy(w, resp.statusCode, "application/json",
+				resp.body)
 			return
 		}
 
-		e, err := getError(resp.Body)
+		e, err := getError(bytes.NewReader(resp.body))
 		if err != nil {
-			log.Errorf("Bad status posting to %v: %v", storeHost,
-				resp.Status)
+			log.Errorf("Bad status posting to %v: %v", route,
+				resp.status)
 		} else {
 			log.Errorf("Bad status posting to %v: %v\n%v",
-				storeHost, resp.Status, e)
+				route, resp.status, e)
 		}
 
 		util.RespondWithError(w, http.StatusInternalServerError,
-			bodyBuf.String())
+			string(resp.body))
 		return
 	}
-	err = util.RespondWithCopy(w, resp.StatusCode,
-		resp.Header.Get("Content-Type"), bodyBuf.Bytes())
+	if resp.signature != "" {
+		w.Header().Set(v2.SignatureHeader, resp.signature)
+	}
+	err = util.RespondWithCopy(w, resp.statusCode, resp.contentType,
+		resp.body)
 	if err != nil {
 		log.Errorf("Error responding to client: %v", err)
 	}
@@ -126,10 +169,10 @@ func (d *DcrtimeStore) proxyStatusV1(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	d.sendToBackend(r.Context(), w, r.Method, v1.StatusRoute, r.Header.Get("Content-Type"),
+	d.sendToBackend(r.Context(), w, r.Method, withAPIToken(v1.StatusRoute, r), r.Header.Get("Content-Type"),
 		r.RemoteAddr, bytes.NewReader(b))
 
-	log.Infof("%v Status %v", r.URL.Path, r.RemoteAddr)
+	log.Infof("%v Status %v", r.URL.Path, logAddr(r))
 }
 
 func (d *DcrtimeStore) proxyTimestampV1(w http.ResponseWriter, r *http.Request) {
@@ -149,11 +192,11 @@ func (d *DcrtimeStore) proxyTimestampV1(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	d.sendToBackend(r.Context(), w, r.Method, v1.TimestampRoute, r.Header.Get("Content-Type"),
-		r.RemoteAddr, bytes.NewReader(b))
+	d.submitToBackend(r.Context(), w, r.Method, withAPIToken(v1.TimestampRoute, r), r.Header.Get("Content-Type"),
+		r.RemoteAddr, b)
 
 	for _, v := range t.Digests {
-		log.Infof("%v Timestamp %v: %v", r.URL.Path, r.RemoteAddr, v)
+		log.Infof("%v Timestamp %v: %v", r.URL.Path, logAddr(r), v)
 	}
 }
 
@@ -174,26 +217,25 @@ func (d *DcrtimeStore) proxyVerifyV1(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	d.sendToBackend(r.Context(), w, r.Method, v1.VerifyRoute, r.Header.Get("Content-Type"),
+	d.sendToBackend(r.Context(), w, r.Method, withAPIToken(v1.VerifyRoute, r), r.Header.Get("Content-Type"),
 		r.RemoteAddr, bytes.NewReader(b))
 	log.Infof("%v Verify %v: Timestamps %v Digests %v",
-		r.URL.Path, r.RemoteAddr, len(v.Timestamps), len(v.Digests))
+		r.URL.Path, logAddr(r), len(v.Timestamps), len(v.Digests))
 }
 
 func (d *DcrtimeStore) proxyWalletBalanceV1(w http.ResponseWriter, r *http.Request) {
-	apiToken := r.URL.Query().Get("apitoken")
-	route := v1.WalletBalanceRoute + "?apitoken=" + apiToken
+	route := withAPIToken(v1.WalletBalanceRoute, r)
 	d.sendToBackend(r.Context(), w, r.Method, route, r.Header.Get("Content-Type"),
 		r.RemoteAddr, bytes.NewReader([]byte{}))
 
-	log.Infof("%v WalletBalance %v", r.URL.Path, r.RemoteAddr)
+	log.Infof("%v WalletBalance %v", r.URL.Path, logAddr(r))
 }
 
 func (d *DcrtimeStore) proxyLastAnchorV1(w http.ResponseWriter, r *http.Request) {
 	d.sendToBackend(r.Context(), w, r.Method, v1.LastAnchorRoute, r.Header.Get("Content-Type"),
 		r.RemoteAddr, bytes.NewReader([]byte{}))
 
-	log.Infof("%v LastAnchor %v", r.URL.Path, r.RemoteAddr)
+	log.Infof("%v LastAnchor %v", r.URL.Path, logAddr(r))
 }
 
 func (d *DcrtimeStore) proxyStatusV2(w http.ResponseWriter, r *http.Request) {
@@ -214,33 +256,50 @@ func (d *DcrtimeStore) proxyStatusV2(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	d.sendToBackend(r.Context(), w, r.Method, v2.StatusRoute, r.Header.Get("Content-Type"),
+	d.sendToBackend(r.Context(), w, r.Method, withAPIToken(v2.StatusRoute, r), r.Header.Get("Content-Type"),
 		r.RemoteAddr, bytes.NewReader(b))
-	log.Infof("%v Status %v", r.URL.Path, r.RemoteAddr)
+	log.Infof("%v Status %v", r.URL.Path, logAddr(r))
 }
 
 func (d *DcrtimeStore) proxyTimestampV2(w http.ResponseWriter, r *http.Request) {
 	r.ParseForm()
 	dig := r.Form.Get("digest")
-	route := v2.TimestampRoute + "?digest=" + dig
+	route := v2.TimestampRoute + "?digest=" + url.QueryEscape(dig)
+	if algorithm := r.Form.Get("algorithm"); algorithm != "" {
+		route += "&algorithm=" + url.QueryEscape(algorithm)
+	}
+	if pr := r.Form.Get("pendingreceipt"); pr != "" {
+		route += "&pendingreceipt=" + url.QueryEscape(pr)
+	}
+	if webhookURL := r.Form.Get("webhookurl"); webhookURL != "" {
+		route += "&webhookurl=" + url.QueryEscape(webhookURL)
+	}
+	route = withAPIToken(route, r)
 	r.Body.Close()
 
-	d.sendToBackend(r.Context(), w, http.MethodGet, route, r.Header.Get("Content-Type"),
-		r.RemoteAddr, bytes.NewReader([]byte{}))
+	d.submitToBackend(r.Context(), w, http.MethodGet, route, r.Header.Get("Content-Type"),
+		r.RemoteAddr, []byte{})
 
-	log.Infof("%v Timestamp %v", r.URL.Path, r.RemoteAddr)
+	log.Infof("%v Timestamp %v", r.URL.Path, logAddr(r))
 }
 
 func (d *DcrtimeStore) proxyVerifyV2(w http.ResponseWriter, r *http.Request) {
 	r.ParseForm()
 	dig := r.Form.Get("digest")
-	route := v2.VerifyRoute + "?digest=" + dig
+	route := v2.VerifyRoute + "?digest=" + url.QueryEscape(dig)
+	for _, format := range r.Form["proofformats"] {
+		route += "&proofformats=" + url.QueryEscape(format)
+	}
+	for _, key := range r.Form["accesskey"] {
+		route += "&accesskey=" + url.QueryEscape(key)
+	}
+	route = withAPIToken(route, r)
 	r.Body.Close()
 
 	d.sendToBackend(r.Context(), w, r.Method, route, r.Header.Get("Content-Type"),
 		r.RemoteAddr, bytes.NewReader([]byte{}))
 
-	log.Infof("%v Verify %v", r.URL.Path, r.RemoteAddr)
+	log.Infof("%v Verify %v", r.URL.Path, logAddr(r))
 }
 
 func (d *DcrtimeStore) proxyTimestampBatchV2(w http.ResponseWriter, r *http.Request) {
@@ -261,14 +320,14 @@ func (d *DcrtimeStore) proxyTimestampBatchV2(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	d.sendToBackend(r.Context(), w, r.Method, v2.TimestampBatchRoute, r.Header.Get("Content-Type"),
-		r.RemoteAddr, bytes.NewReader(b))
+	d.submitToBackend(r.Context(), w, r.Method, withAPIToken(v2.TimestampBatchRoute, r), r.Header.Get("Content-Type"),
+		r.RemoteAddr, b)
 
 	for _, v := range t.Digests {
-		log.Infof("Timestamp %v: %v", r.RemoteAddr, v)
+		log.Infof("Timestamp %v: %v", logAddr(r), v)
 	}
 
-	log.Infof("%v TimestampBatch %v", r.URL.Path, r.RemoteAddr)
+	log.Infof("%v TimestampBatch %v", r.URL.Path, logAddr(r))
 }
 
 func (d *DcrtimeStore) proxyVerifyBatchV2(w http.ResponseWriter, r *http.Request) {
@@ -289,27 +348,26 @@ func (d *DcrtimeStore) proxyVerifyBatchV2(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	d.sendToBackend(r.Context(), w, r.Method, v2.VerifyBatchRoute, r.Header.Get("Content-Type"),
+	d.sendToBackend(r.Context(), w, r.Method, withAPIToken(v2.VerifyBatchRoute, r), r.Header.Get("Content-Type"),
 		r.RemoteAddr, bytes.NewReader(b))
 
 	log.Infof("%v VerifyBatch %v: Timestamps %v Digests %v",
-		r.URL.Path, r.RemoteAddr, len(v.Timestamps), len(v.Digests))
+		r.URL.Path, logAddr(r), len(v.Timestamps), len(v.Digests))
 }
 
 func (d *DcrtimeStore) proxyWalletBalanceV2(w http.ResponseWriter, r *http.Request) {
-	apiToken := r.URL.Query().Get("apitoken")
-	route := v2.WalletBalanceRoute + "?apitoken=" + apiToken
+	route := withAPIToken(v2.WalletBalanceRoute, r)
 	d.sendToBackend(r.Context(), w, r.Method, route, r.Header.Get("Content-Type"),
 		r.RemoteAddr, bytes.NewReader([]byte{}))
 
-	log.Infof("%v WalletBalance %v", r.URL.Path, r.RemoteAddr)
+	log.Infof("%v WalletBalance %v", r.URL.Path, logAddr(r))
 }
 
 func (d *DcrtimeStore) proxyLastAnchorV2(w http.ResponseWriter, r *http.Request) {
 	d.sendToBackend(r.Context(), w, r.Method, v2.LastAnchorRoute, r.Header.Get("Content-Type"),
 		r.RemoteAddr, bytes.NewReader([]byte{}))
 
-	log.Infof("%v LastAnchor %v", r.URL.Path, r.RemoteAddr)
+	log.Infof("%v LastAnchor %v", r.URL.Path, logAddr(r))
 }
 
 func (d *DcrtimeStore) proxyLastDigestsV2Route(w http.ResponseWriter, r *http.Request) {
@@ -330,17 +388,90 @@ func (d *DcrtimeStore) proxyLastDigestsV2Route(w http.ResponseWriter, r *http.Re
 		return
 	}
 
-	if ld.N > d.cfg.MaxDigests {
+	if max := d.maxDigests(r); ld.N > max {
 		util.RespondWithError(w, http.StatusUnprocessableEntity,
-			fmt.Sprintf("Invalid number %d of digests requested. Max is: %d", ld.N, d.cfg.MaxDigests))
+			fmt.Sprintf("Invalid number %d of digests requested. Max is: %d", ld.N, max))
 		return
 	}
 
-	d.sendToBackend(r.Context(), w, r.Method, v2.VerifyBatchRoute, r.Header.Get("Content-Type"),
+	d.sendToBackend(r.Context(), w, r.Method, withAPIToken(v2.LastDigestsRoute, r), r.Header.Get("Content-Type"),
 		r.RemoteAddr, bytes.NewReader(b))
 
 	log.Infof("%v Last Digests %v: Number",
-		r.URL.Path, r.RemoteAddr, ld.N)
+		r.URL.Path, logAddr(r), ld.N)
+}
+
+func (d *DcrtimeStore) proxyLabelV2(w http.ResponseWriter, r *http.Request) {
+	b, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		util.RespondWithError(w, http.StatusBadRequest,
+			"Unable to read request")
+		return
+	}
+
+	var l v2.Label
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&l); err != nil {
+		util.RespondWithError(w, http.StatusBadRequest,
+			"Invalid request payload")
+		return
+	}
+
+	d.sendToBackend(r.Context(), w, r.Method, withAPIToken(v2.LabelRoute, r), r.Header.Get("Content-Type"),
+		r.RemoteAddr, bytes.NewReader(b))
+
+	log.Infof("%v Label %v: %v", r.URL.Path, logAddr(r), l.Label)
+}
+
+func (d *DcrtimeStore) proxyWindowV2(w http.ResponseWriter, r *http.Request) {
+	b, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		util.RespondWithError(w, http.StatusBadRequest,
+			"Unable to read request")
+		return
+	}
+
+	var wd v2.Window
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&wd); err != nil {
+		util.RespondWithError(w, http.StatusBadRequest,
+			"Invalid request payload")
+		return
+	}
+
+	d.sendToBackend(r.Context(), w, r.Method, withAPIToken(v2.WindowRoute, r), r.Header.Get("Content-Type"),
+		r.RemoteAddr, bytes.NewReader(b))
+
+	log.Infof("%v Window %v: %v", r.URL.Path, logAddr(r), wd.Label)
+}
+
+func (d *DcrtimeStore) proxyAnchorsV2(w http.ResponseWriter, r *http.Request) {
+	b, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		util.RespondWithError(w, http.StatusBadRequest,
+			"Unable to read request")
+		return
+	}
+
+	var a v2.Anchors
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&a); err != nil {
+		util.RespondWithError(w, http.StatusBadRequest,
+			"Invalid request payload")
+		return
+	}
+
+	d.sendToBackend(r.Context(), w, r.Method, withAPIToken(v2.AnchorsRoute, r), r.Header.Get("Content-Type"),
+		r.RemoteAddr, bytes.NewReader(b))
+
+	log.Infof("%v Anchors %v: %v-%v", r.URL.Path, logAddr(r),
+		a.FromHeight, a.ToHeight)
 }
 
 // version returns the supported API versions running on the server.
@@ -360,16 +491,20 @@ func (d *DcrtimeStore) version(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 	versionReply := v2.VersionReply{
-		Versions:      versions,
-		RoutePrefixes: prefixes,
+		Versions:        versions,
+		RoutePrefixes:   prefixes,
+		Algorithms:      v2.Algorithms,
+		ProofFormats:    v2.ProofFormats,
+		MaxDigests:      d.cfg.MaxDigests,
+		ScopeMaxDigests: d.scopeMaxDigests,
 	}
 
 	// Log for audit trail and reuse loop to translate MultiError to JSON
 	// Results.
-	via := r.RemoteAddr
+	via := logAddr(r)
 	xff := r.Header.Get(forward)
 	if xff != "" {
-		via = fmt.Sprintf("%v via %v", xff, r.RemoteAddr)
+		via = fmt.Sprintf("%v via %v", xff, logAddr(r))
 	}
 	log.Infof("%v Version %v", r.URL.Path, via)
 
@@ -393,10 +528,10 @@ func (d *DcrtimeStore) statusV1(w http.ResponseWriter, r *http.Request) {
 
 	// Log for audit trail and reuse loop to translate MultiError to JSON
 	// Results.
-	via := r.RemoteAddr
+	via := logAddr(r)
 	xff := r.Header.Get(forward)
 	if xff != "" {
-		via = fmt.Sprintf("%v via %v", xff, r.RemoteAddr)
+		via = fmt.Sprintf("%v via %v", xff, logAddr(r))
 	}
 	log.Infof("%v Status %v", r.URL.Path, via)
 
@@ -404,6 +539,16 @@ func (d *DcrtimeStore) statusV1(w http.ResponseWriter, r *http.Request) {
 	util.RespondWithJSON(w, http.StatusOK, v1.StatusReply(s))
 }
 
+// refuseReadOnly refuses timestamp requests while the server is read-only.
+// Verify requests are still served.
+func refuseReadOnly(w http.ResponseWriter, r *http.Request) {
+	r.Body.Close()
+
+	log.Debugf("%v refused read-only %v", r.URL.Path, logAddr(r))
+	util.RespondWithError(w, http.StatusServiceUnavailable,
+		"Server is read-only")
+}
+
 // timestampV1 takes multiple digests from a client and sends it to the backend.
 // Handles /v1/timestamp.
 func (d *DcrtimeStore) timestampV1(w http.ResponseWriter, r *http.Request) {
@@ -426,12 +571,20 @@ func (d *DcrtimeStore) timestampV1(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Charge the digests to the quota of the api token.
+	refund, ok := d.chargeQuota(w, r, len(digests))
+	if !ok {
+		return
+	}
+
 	// Push to backend
-	ts, me, err := d.backend.Put(digests)
+	ts, me, err := d.put(r.Context(), digests, "", "")
 	if err != nil {
+		refund()
+
 		// Generic internal error.
 		errorCode := time.Now().Unix()
-		log.Errorf("%v timestamp error code %v: %v", r.RemoteAddr,
+		log.Errorf("%v timestamp error code %v: %v", logAddr(r),
 			errorCode, err)
 
 		// Tell client there is a transient error.
@@ -440,9 +593,20 @@ func (d *DcrtimeStore) timestampV1(w http.ResponseWriter, r *http.Request) {
 				"Server busy, please try again later.")
 			return
 		}
+		if errors.Is(err, backend.ErrReadOnly) {
+			util.RespondWithError(w, http.StatusServiceUnavailable,
+				"Server is read-only")
+			return
+		}
+		if errors.Is(err, backend.ErrQueueFull) {
+			util.RespondWithError(w, http.StatusServiceUnavailable,
+				"Server is in maintenance and its queue is "+
+					"full, please try again later.")
+			return
+		}
 
 		// Log what went wrong
-		log.Errorf("%v timestamp error code %v: %v", r.RemoteAddr,
+		log.Errorf("%v timestamp error code %v: %v", logAddr(r),
 			errorCode, err)
 		util.RespondWithError(w, http.StatusInternalServerError,
 			fmt.Sprintf("Could not store payload, contact "+
@@ -451,12 +615,16 @@ func (d *DcrtimeStore) timestampV1(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Record the api token as owner of the accepted digests.
+	d.recordOwner(r, ts, me)
+	d.recordSubmission(d.submissionClient(r), ts, me)
+
 	// Log for audit trail and reuse loop to translate MultiError to JSON
 	// Results.
-	via := r.RemoteAddr
+	via := logAddr(r)
 	xff := r.Header.Get(forward)
 	if xff != "" {
-		via = fmt.Sprintf("%v via %v", xff, r.RemoteAddr)
+		via = fmt.Sprintf("%v via %v", xff, logAddr(r))
 	}
 	var (
 		result int
@@ -509,20 +677,27 @@ func (d *DcrtimeStore) verifyV1(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	via := r.RemoteAddr
+	via := logAddr(r)
 	xff := r.Header.Get(forward)
 	if xff != "" {
-		via = fmt.Sprintf("%v via %v", r.RemoteAddr, xff)
+		via = fmt.Sprintf("%v via %v", logAddr(r), xff)
 	}
 	log.Infof("%v Verify %v: Timestamps %v Digests %v",
 		r.URL.Path, via, len(v.Timestamps), len(digests))
 
+	// Digests that may not be revealed are hidden, merkle roots are
+	// public.
+	access := d.newDigestAccess(r, nil)
+
 	// Collect all timestamps.
 	tsr, err := d.backend.GetTimestamps(v.Timestamps)
+	if err == nil {
+		err = access.hideTimestamps(tsr)
+	}
 	if err != nil {
 		// Generic internal error.
 		errorCode := time.Now().Unix()
-		log.Errorf("%v verify error code %v: %v", r.RemoteAddr,
+		log.Errorf("%v verify error code %v: %v", logAddr(r),
 			errorCode, err)
 
 		util.RespondWithError(w, http.StatusInternalServerError,
@@ -557,7 +732,7 @@ func (d *DcrtimeStore) verifyV1(w http.ResponseWriter, r *http.Request) {
 			// Generic internal error.
 			errorCode := time.Now().Unix()
 			log.Errorf("%v timestamp ErrorCode translation error "+
-				"code %v: %v", r.RemoteAddr, errorCode, err)
+				"code %v: %v", logAddr(r), errorCode, err)
 
 			util.RespondWithError(w, http.StatusInternalServerError,
 				fmt.Sprintf("Could not retrieve timestamps, "+
@@ -579,11 +754,14 @@ func (d *DcrtimeStore) verifyV1(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Digests.
-	drs, err := d.backend.Get(digests)
+	drs, err := d.getDigests(digests)
+	if err == nil {
+		err = access.hideDigests(drs)
+	}
 	if err != nil {
 		// Generic internal error.
 		errorCode := time.Now().Unix()
-		log.Errorf("%v verify error code %v: %v", r.RemoteAddr,
+		log.Errorf("%v verify error code %v: %v", logAddr(r),
 			errorCode, err)
 
 		util.RespondWithError(w, http.StatusInternalServerError,
@@ -618,7 +796,7 @@ func (d *DcrtimeStore) verifyV1(w http.ResponseWriter, r *http.Request) {
 			// Generic internal error.
 			errorCode := time.Now().Unix()
 			log.Errorf("%v digest ErrorCode translation error "+
-				"code %v: %v", r.RemoteAddr, errorCode, err)
+				"code %v: %v", logAddr(r), errorCode, err)
 
 			util.RespondWithError(w, http.StatusInternalServerError,
 				fmt.Sprintf("Could not retrieve digests, "+
@@ -631,6 +809,19 @@ func (d *DcrtimeStore) verifyV1(w http.ResponseWriter, r *http.Request) {
 		dReply = append(dReply, vd)
 	}
 
+	if err := d.auditProofs(r, drs, nil); err != nil {
+		// Generic internal error.
+		errorCode := time.Now().Unix()
+		log.Errorf("%v proof audit error code %v: %v", logAddr(r),
+			errorCode, err)
+
+		util.RespondWithError(w, http.StatusInternalServerError,
+			fmt.Sprintf("Could not record served proofs, contact "+
+				"administrator and provide the following "+
+				"error code: %v", errorCode))
+		return
+	}
+
 	util.RespondWithJSON(w, http.StatusOK, v1.VerifyReply{
 		ID:         v.ID,
 		Timestamps: tsReply,
@@ -639,14 +830,14 @@ func (d *DcrtimeStore) verifyV1(w http.ResponseWriter, r *http.Request) {
 }
 
 func (d *DcrtimeStore) lastAnchorV1(w http.ResponseWriter, r *http.Request) {
-	log.Infof("%v LastAnchor %v", r.URL.Path, r.RemoteAddr)
+	log.Infof("%v LastAnchor %v", r.URL.Path, logAddr(r))
 
 	lastAnchorResult, err := d.backend.LastAnchor()
 	if err != nil {
 		errorCode := time.Now().Unix()
 
 		log.Errorf("%v lastAnchor error code %v: %v",
-			r.RemoteAddr, errorCode, err)
+			logAddr(r), errorCode, err)
 		util.RespondWithError(w, http.StatusInternalServerError,
 			fmt.Sprintf("failed to retrieve lastest anchor info, "+
 				"contact administrator and provide "+
@@ -663,8 +854,8 @@ func (d *DcrtimeStore) lastAnchorV1(w http.ResponseWriter, r *http.Request) {
 }
 
 func (d *DcrtimeStore) walletBalanceV1(w http.ResponseWriter, r *http.Request) {
-	if !d.isAuthorized(r) {
-		util.RespondWithError(w, http.StatusUnauthorized, "not authorized")
+	if !d.isAuthorized(r, v2.TokenScopeAdmin) {
+		d.respondNotAuthorized(w, r)
 		return
 	}
 
@@ -673,7 +864,7 @@ func (d *DcrtimeStore) walletBalanceV1(w http.ResponseWriter, r *http.Request) {
 		errorCode := time.Now().Unix()
 
 		log.Errorf("%v walletBalance error code %v: %v",
-			r.RemoteAddr, errorCode, err)
+			logAddr(r), errorCode, err)
 		util.RespondWithError(w, http.StatusInternalServerError,
 			fmt.Sprintf("failed to retrieve wallet balance, "+
 				"contact administrator and provide "+
@@ -681,7 +872,7 @@ func (d *DcrtimeStore) walletBalanceV1(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	log.Infof("%v WalletBalance %v", r.URL.Path, r.RemoteAddr)
+	log.Infof("%v WalletBalance %v", r.URL.Path, logAddr(r))
 
 	util.RespondWithJSON(w, http.StatusOK, v1.WalletBalanceReply{
 		Total:       balanceResult.Total,
@@ -707,10 +898,10 @@ func (d *DcrtimeStore) statusV2(w http.ResponseWriter, r *http.Request) {
 
 	// Log for audit trail and reuse loop to translate MultiError to JSON
 	// Results.
-	via := r.RemoteAddr
+	via := logAddr(r)
 	xff := r.Header.Get(forward)
 	if xff != "" {
-		via = fmt.Sprintf("%v via %v", xff, r.RemoteAddr)
+		via = fmt.Sprintf("%v via %v", xff, logAddr(r))
 	}
 	log.Infof("%v Status %v", r.URL.Path, via)
 
@@ -740,12 +931,57 @@ func (d *DcrtimeStore) timestampBatchV2(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	// Validate optional group label.
+	if t.Label != "" && !v2.RegexpLabel.MatchString(t.Label) {
+		util.RespondWithError(w, http.StatusBadRequest,
+			"Invalid Label")
+		return
+	}
+
+	// Validate optional digest algorithm.
+	if !v2.IsAlgorithm(t.Algorithm) {
+		util.RespondWithError(w, http.StatusBadRequest,
+			fmt.Sprintf("Unsupported algorithm. Supported: %v",
+				strings.Join(v2.Algorithms, ", ")))
+		return
+	}
+
+	// Validate optional metadata.
+	if len(t.Metadata) != 0 && len(t.Metadata) != len(t.Digests) {
+		util.RespondWithError(w, http.StatusBadRequest,
+			"Invalid Metadata array")
+		return
+	}
+	for _, md := range t.Metadata {
+		if err := validMetadata(md); err != nil {
+			util.RespondWithError(w, http.StatusBadRequest,
+				fmt.Sprintf("Invalid Metadata: %v", err))
+			return
+		}
+	}
+	if t.PrivateMetadata && d.metadataOwner(r) == "" {
+		util.RespondWithError(w, http.StatusBadRequest,
+			"Private metadata requires an api token")
+		return
+	}
+	if !d.checkWebhookURL(w, r, t.WebhookURL) {
+		return
+	}
+
+	// Charge the digests to the quota of the api token.
+	refund, ok := d.chargeQuota(w, r, len(digests))
+	if !ok {
+		return
+	}
+
 	// Push to backend
-	ts, me, err := d.backend.Put(digests)
+	ts, me, err := d.put(r.Context(), digests, t.Label, storedAlgorithm(t.Algorithm))
 	if err != nil {
+		refund()
+
 		// Generic internal error.
 		errorCode := time.Now().Unix()
-		log.Errorf("%v timestamp error code %v: %v", r.RemoteAddr,
+		log.Errorf("%v timestamp error code %v: %v", logAddr(r),
 			errorCode, err)
 
 		// Tell client there is a transient error.
@@ -754,9 +990,20 @@ func (d *DcrtimeStore) timestampBatchV2(w http.ResponseWriter, r *http.Request)
 				"Server busy, please try again later.")
 			return
 		}
+		if errors.Is(err, backend.ErrReadOnly) {
+			util.RespondWithError(w, http.StatusServiceUnavailable,
+				"Server is read-only")
+			return
+		}
+		if errors.Is(err, backend.ErrQueueFull) {
+			util.RespondWithError(w, http.StatusServiceUnavailable,
+				"Server is in maintenance and its queue is "+
+					"full, please try again later.")
+			return
+		}
 
 		// Log what went wrong
-		log.Errorf("%v timestamp error code %v: %v", r.RemoteAddr,
+		log.Errorf("%v timestamp error code %v: %v", logAddr(r),
 			errorCode, err)
 		util.RespondWithError(w, http.StatusInternalServerError,
 			fmt.Sprintf("Could not store payload, contact "+
@@ -765,12 +1012,17 @@ func (d *DcrtimeStore) timestampBatchV2(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	// Record the api token as owner of the accepted digests.
+	d.recordOwner(r, ts, me)
+	d.recordSubmission(d.submissionClient(r), ts, me)
+	d.recordMetadata(r, t.Metadata, t.PrivateMetadata, me)
+
 	// Log for audit trail and reuse loop to translate MultiError to JSON
 	// Results.
-	via := r.RemoteAddr
+	via := logAddr(r)
 	xff := r.Header.Get(forward)
 	if xff != "" {
-		via = fmt.Sprintf("%v via %v", xff, r.RemoteAddr)
+		via = fmt.Sprintf("%v via %v", xff, logAddr(r))
 	}
 	var (
 		result v2.ResultT
@@ -792,12 +1044,24 @@ func (d *DcrtimeStore) timestampBatchV2(w http.ResponseWriter, r *http.Request)
 	}
 
 	// We don't set ChainTimestamp until it is included on the chain.
-	util.RespondWithJSON(w, http.StatusOK, v2.TimestampBatchReply{
+	reply := v2.TimestampBatchReply{
 		ID:              t.ID,
 		Digests:         t.Digests,
 		ServerTimestamp: ts,
+		ServerTime:      v2.FormatTime(ts),
+		Label:           t.Label,
+		Algorithm:       t.Algorithm,
 		Results:         results,
-	})
+		AccessKeys:      d.accessKeys(me),
+		Maintenance:     d.backend.Maintenance(),
+		Duplicates:      d.duplicates(r, me),
+	}
+	if t.PendingReceipts {
+		reply.PendingReceipts = d.pendingReceipts(r, ts, t.Algorithm,
+			me)
+	}
+	reply.SubscriptionID = d.subscribeSubmission(r, t.WebhookURL, ts, me)
+	util.RespondWithJSON(w, http.StatusOK, reply)
 }
 
 // verifyBatchV2 takes multiple digests from a client and checks its status on the
@@ -822,21 +1086,33 @@ func (d *DcrtimeStore) verifyBatchV2(w http.ResponseWriter, r *http.Request) {
 			"Invalid Digests array")
 		return
 	}
+	if err := validProofFormats(v.ProofFormats); err != nil {
+		util.RespondWithError(w, http.StatusBadRequest,
+			"Invalid proof format")
+		return
+	}
 
-	via := r.RemoteAddr
+	via := logAddr(r)
 	xff := r.Header.Get(forward)
 	if xff != "" {
-		via = fmt.Sprintf("%v via %v", r.RemoteAddr, xff)
+		via = fmt.Sprintf("%v via %v", logAddr(r), xff)
 	}
 	log.Infof("%v VerifyBatch %v: Timestamps %v Digests %v",
 		r.URL.Path, via, len(v.Timestamps), len(digests))
 
+	// Digests that may not be revealed are hidden, merkle roots are
+	// public.
+	access := d.newDigestAccess(r, v.AccessKeys)
+
 	// Collect all timestamps.
 	tsr, err := d.backend.GetTimestamps(v.Timestamps)
+	if err == nil {
+		err = access.hideTimestamps(tsr)
+	}
 	if err != nil {
 		// Generic internal error.
 		errorCode := time.Now().Unix()
-		log.Errorf("%v verify error code %v: %v", r.RemoteAddr,
+		log.Errorf("%v verify error code %v: %v", logAddr(r),
 			errorCode, err)
 
 		util.RespondWithError(w, http.StatusInternalServerError,
@@ -851,12 +1127,19 @@ func (d *DcrtimeStore) verifyBatchV2(w http.ResponseWriter, r *http.Request) {
 	for _, ts := range tsr {
 		vt := v2.VerifyTimestamp{
 			ServerTimestamp: ts.Timestamp,
+			ServerTime:      v2.FormatTime(ts.Timestamp),
 			FlushTimestamp:  ts.FlushTimestamp,
+			FlushTime:       v2.FormatTime(ts.FlushTimestamp),
 			CollectionInformation: v2.CollectionInformation{
 				ChainTimestamp:   ts.AnchoredTimestamp,
+				ChainTime:        v2.FormatTime(ts.AnchoredTimestamp),
 				Confirmations:    ts.Confirmations,
 				MinConfirmations: ts.MinConfirmations,
+				ReorgTimestamp:   ts.ReorgTimestamp,
+				ReorgTime:        v2.FormatTime(ts.ReorgTimestamp),
 				Transaction:      ts.Tx.String(),
+				AnchorPrefix:     ts.AnchorPrefix,
+				Attestations:     convertAttestations(ts.Attestations),
 				MerkleRoot:       hex.EncodeToString(ts.MerkleRoot[:]),
 			},
 			Result: -1,
@@ -874,7 +1157,7 @@ func (d *DcrtimeStore) verifyBatchV2(w http.ResponseWriter, r *http.Request) {
 			// Generic internal error.
 			errorCode := time.Now().Unix()
 			log.Errorf("%v timestamp ErrorCode translation error "+
-				"code %v: %v", r.RemoteAddr, errorCode, err)
+				"code %v: %v", logAddr(r), errorCode, err)
 
 			util.RespondWithError(w, http.StatusInternalServerError,
 				fmt.Sprintf("Could not retrieve timestamps, "+
@@ -896,11 +1179,18 @@ func (d *DcrtimeStore) verifyBatchV2(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Digests.
-	drs, err := d.backend.Get(digests)
+	drs, err := d.getDigests(digests)
+	if err == nil {
+		err = access.hideDigests(drs)
+	}
+	var metadata []map[string]string
+	if err == nil {
+		metadata, err = d.visibleMetadata(r, drs)
+	}
 	if err != nil {
 		// Generic internal error.
 		errorCode := time.Now().Unix()
-		log.Errorf("%v verify error code %v: %v", r.RemoteAddr,
+		log.Errorf("%v verify error code %v: %v", logAddr(r),
 			errorCode, err)
 
 		util.RespondWithError(w, http.StatusInternalServerError,
@@ -912,33 +1202,13 @@ func (d *DcrtimeStore) verifyBatchV2(w http.ResponseWriter, r *http.Request) {
 
 	// Translate digest results.
 	dReply := make([]v2.VerifyDigest, 0, len(drs))
-	for _, dr := range drs {
-		vd := v2.VerifyDigest{
-			Digest:          hex.EncodeToString(dr.Digest[:]),
-			ServerTimestamp: dr.Timestamp,
-			FlushTimestamp:  dr.FlushTimestamp,
-			ChainInformation: v2.ChainInformation{
-				Confirmations:    dr.Confirmations,
-				MinConfirmations: dr.MinConfirmations,
-				ChainTimestamp:   dr.AnchoredTimestamp,
-				Transaction:      dr.Tx.String(),
-				MerkleRoot:       hex.EncodeToString(dr.MerkleRoot[:]),
-				MerklePath:       v2.MerkleBranch(dr.MerklePath),
-			},
-			Result: -1,
-		}
-		switch dr.ErrorCode {
-		case backend.ErrorOK:
-			vd.Result = v2.ResultOK
-		case backend.ErrorNotFound:
-			vd.Result = v2.ResultDoesntExistError
-		}
-
-		if vd.Result == -1 {
+	for k, dr := range drs {
+		vd, ok := convertVerifyDigest(dr)
+		if !ok {
 			// Generic internal error.
 			errorCode := time.Now().Unix()
 			log.Errorf("%v digest ErrorCode translation error "+
-				"code %v: %v", r.RemoteAddr, errorCode, err)
+				"code %v: %v", logAddr(r), errorCode, err)
 
 			util.RespondWithError(w, http.StatusInternalServerError,
 				fmt.Sprintf("Could not retrieve digests, "+
@@ -947,9 +1217,37 @@ func (d *DcrtimeStore) verifyBatchV2(w http.ResponseWriter, r *http.Request) {
 					errorCode))
 			return
 		}
+		vd.Proofs, err = d.encodeProofs(dr, v.ProofFormats)
+		if err != nil {
+			// Generic internal error.
+			errorCode := time.Now().Unix()
+			log.Errorf("%v proof encoding error code %v: %v",
+				logAddr(r), errorCode, err)
+
+			util.RespondWithError(w, http.StatusInternalServerError,
+				fmt.Sprintf("Could not encode proofs, "+
+					"contact administrator and provide "+
+					"the following error code: %v",
+					errorCode))
+			return
+		}
+		vd.Metadata = metadata[k]
 		dReply = append(dReply, vd)
 	}
 
+	if err := d.auditProofs(r, drs, v.ProofFormats); err != nil {
+		// Generic internal error.
+		errorCode := time.Now().Unix()
+		log.Errorf("%v proof audit error code %v: %v", logAddr(r),
+			errorCode, err)
+
+		util.RespondWithError(w, http.StatusInternalServerError,
+			fmt.Sprintf("Could not record served proofs, contact "+
+				"administrator and provide the following "+
+				"error code: %v", errorCode))
+		return
+	}
+
 	util.RespondWithJSON(w, http.StatusOK, v2.VerifyBatchReply{
 		ID:         v.ID,
 		Timestamps: tsReply,
@@ -967,8 +1265,19 @@ func (d *DcrtimeStore) timestampV2(w http.ResponseWriter, r *http.Request) {
 	id := r.Form.Get("id")
 	dig := r.Form.Get("digest")
 	t := v2.Timestamp{
-		ID:     id,
-		Digest: dig,
+		ID:         id,
+		Digest:     dig,
+		Algorithm:  r.Form.Get("algorithm"),
+		WebhookURL: r.Form.Get("webhookurl"),
+	}
+	if pr := r.Form.Get("pendingreceipt"); pr != "" {
+		var err error
+		t.PendingReceipt, err = strconv.ParseBool(pr)
+		if err != nil {
+			util.RespondWithError(w, http.StatusBadRequest,
+				"Invalid PendingReceipt")
+			return
+		}
 	}
 
 	// Validate digest. If it is invalid return failure.
@@ -979,12 +1288,31 @@ func (d *DcrtimeStore) timestampV2(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Validate optional digest algorithm.
+	if !v2.IsAlgorithm(t.Algorithm) {
+		util.RespondWithError(w, http.StatusBadRequest,
+			fmt.Sprintf("Unsupported algorithm. Supported: %v",
+				strings.Join(v2.Algorithms, ", ")))
+		return
+	}
+	if !d.checkWebhookURL(w, r, t.WebhookURL) {
+		return
+	}
+
+	// Charge the digests to the quota of the api token.
+	refund, ok := d.chargeQuota(w, r, len(digest))
+	if !ok {
+		return
+	}
+
 	// Push to backend
-	ts, me, err := d.backend.Put(digest)
+	ts, me, err := d.put(r.Context(), digest, "", storedAlgorithm(t.Algorithm))
 	if err != nil {
+		refund()
+
 		// Generic internal error.
 		errorCode := time.Now().Unix()
-		log.Errorf("%v timestamp error code %v: %v", r.RemoteAddr,
+		log.Errorf("%v timestamp error code %v: %v", logAddr(r),
 			errorCode, err)
 
 		// Tell client there is a transient error.
@@ -993,9 +1321,20 @@ func (d *DcrtimeStore) timestampV2(w http.ResponseWriter, r *http.Request) {
 				"Server busy, please try again later.")
 			return
 		}
+		if errors.Is(err, backend.ErrReadOnly) {
+			util.RespondWithError(w, http.StatusServiceUnavailable,
+				"Server is read-only")
+			return
+		}
+		if errors.Is(err, backend.ErrQueueFull) {
+			util.RespondWithError(w, http.StatusServiceUnavailable,
+				"Server is in maintenance and its queue is "+
+					"full, please try again later.")
+			return
+		}
 
 		// Log what went wrong
-		log.Errorf("%v timestamp error code %v: %v", r.RemoteAddr,
+		log.Errorf("%v timestamp error code %v: %v", logAddr(r),
 			errorCode, err)
 		util.RespondWithError(w, http.StatusInternalServerError,
 			fmt.Sprintf("Could not store payload, contact "+
@@ -1004,12 +1343,16 @@ func (d *DcrtimeStore) timestampV2(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Record the api token as owner of the accepted digests.
+	d.recordOwner(r, ts, me)
+	d.recordSubmission(d.submissionClient(r), ts, me)
+
 	// Log for audit trail and reuse loop to translate MultiError to JSON
 	// Results.
-	via := r.RemoteAddr
+	via := logAddr(r)
 	xff := r.Header.Get(forward)
 	if xff != "" {
-		via = fmt.Sprintf("%v via %v", xff, r.RemoteAddr)
+		via = fmt.Sprintf("%v via %v", xff, logAddr(r))
 	}
 	var (
 		result v2.ResultT
@@ -1027,12 +1370,30 @@ func (d *DcrtimeStore) timestampV2(w http.ResponseWriter, r *http.Request) {
 	log.Infof("%v Timestamp %v: %v %v %x",
 		r.URL.Path, via, verb, tsS, pr.Digest)
 
-	util.RespondWithJSON(w, http.StatusOK, v2.TimestampReply{
+	reply := v2.TimestampReply{
 		ID:              t.ID,
 		Digest:          t.Digest,
 		ServerTimestamp: ts,
+		ServerTime:      v2.FormatTime(ts),
+		Algorithm:       t.Algorithm,
 		Result:          result,
-	})
+		Maintenance:     d.backend.Maintenance(),
+	}
+	if keys := d.accessKeys(me[len(me)-1:]); len(keys) != 0 {
+		reply.AccessKey = keys[0]
+	}
+	if dups := d.duplicates(r, me[len(me)-1:]); len(dups) != 0 {
+		reply.Duplicate = &dups[0]
+	}
+	if t.PendingReceipt {
+		prs := d.pendingReceipts(r, ts, t.Algorithm, me[len(me)-1:])
+		if len(prs) != 0 {
+			reply.PendingReceipt = &prs[0]
+		}
+	}
+	reply.SubscriptionID = d.subscribeSubmission(r, t.WebhookURL, ts,
+		me[len(me)-1:])
+	util.RespondWithJSON(w, http.StatusOK, reply)
 }
 
 // verifyV2 takes a single digest from a client and checks its status on the
@@ -1047,9 +1408,11 @@ func (d *DcrtimeStore) verifyV2(w http.ResponseWriter, r *http.Request) {
 	timestamp := r.Form.Get("timestamp")
 	tsint, _ := strconv.ParseInt(timestamp, 10, 64)
 	v := v2.Verify{
-		ID:        id,
-		Digest:    dig,
-		Timestamp: tsint,
+		ID:           id,
+		Digest:       dig,
+		Timestamp:    tsint,
+		ProofFormats: parseProofFormats(r.Form["proofformats"]),
+		AccessKeys:   r.Form["accesskey"],
 	}
 
 	// Validate request parameters.
@@ -1072,11 +1435,16 @@ func (d *DcrtimeStore) verifyV2(w http.ResponseWriter, r *http.Request) {
 		util.RespondWithError(w, http.StatusBadRequest, "Invalid Digest")
 		return
 	}
+	if err := validProofFormats(v.ProofFormats); err != nil {
+		util.RespondWithError(w, http.StatusBadRequest,
+			"Invalid proof format")
+		return
+	}
 
-	via := r.RemoteAddr
+	via := logAddr(r)
 	xff := r.Header.Get(forward)
 	if xff != "" {
-		via = fmt.Sprintf("%v via %v", r.RemoteAddr, xff)
+		via = fmt.Sprintf("%v via %v", logAddr(r), xff)
 	}
 	log.Infof("%v Verify %v: Timestamp %v Digest %v",
 		r.URL.Path, via, v.Timestamp, v.Digest)
@@ -1086,11 +1454,19 @@ func (d *DcrtimeStore) verifyV2(w http.ResponseWriter, r *http.Request) {
 	if v.Timestamp != 0 {
 		ts = append(ts, v.Timestamp)
 	}
+
+	// Digests that may not be revealed are hidden, merkle roots are
+	// public.
+	access := d.newDigestAccess(r, v.AccessKeys)
+
 	tsr, err := d.backend.GetTimestamps(ts)
+	if err == nil {
+		err = access.hideTimestamps(tsr)
+	}
 	if err != nil {
 		// Generic internal error.
 		errorCode := time.Now().Unix()
-		log.Errorf("%v verify error code %v: %v", r.RemoteAddr,
+		log.Errorf("%v verify error code %v: %v", logAddr(r),
 			errorCode, err)
 
 		util.RespondWithError(w, http.StatusInternalServerError,
@@ -1106,12 +1482,19 @@ func (d *DcrtimeStore) verifyV2(w http.ResponseWriter, r *http.Request) {
 		ts := tsr[len(tsr)-1]
 		vt := v2.VerifyTimestamp{
 			ServerTimestamp: ts.Timestamp,
+			ServerTime:      v2.FormatTime(ts.Timestamp),
 			FlushTimestamp:  ts.FlushTimestamp,
+			FlushTime:       v2.FormatTime(ts.FlushTimestamp),
 			CollectionInformation: v2.CollectionInformation{
 				ChainTimestamp:   ts.AnchoredTimestamp,
+				ChainTime:        v2.FormatTime(ts.AnchoredTimestamp),
 				Confirmations:    ts.Confirmations,
 				MinConfirmations: ts.MinConfirmations,
+				ReorgTimestamp:   ts.ReorgTimestamp,
+				ReorgTime:        v2.FormatTime(ts.ReorgTimestamp),
 				Transaction:      ts.Tx.String(),
+				AnchorPrefix:     ts.AnchorPrefix,
+				Attestations:     convertAttestations(ts.Attestations),
 				MerkleRoot:       hex.EncodeToString(ts.MerkleRoot[:]),
 			},
 			Result: -1,
@@ -1129,7 +1512,7 @@ func (d *DcrtimeStore) verifyV2(w http.ResponseWriter, r *http.Request) {
 			// Generic internal error.
 			errorCode := time.Now().Unix()
 			log.Errorf("%v timestamp ErrorCode translation error "+
-				"code %v: %v", r.RemoteAddr, errorCode, err)
+				"code %v: %v", logAddr(r), errorCode, err)
 
 			util.RespondWithError(w, http.StatusInternalServerError,
 				fmt.Sprintf("Could not retrieve timestamps, "+
@@ -1151,11 +1534,18 @@ func (d *DcrtimeStore) verifyV2(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Digest.
-	drs, err := d.backend.Get(digest)
+	drs, err := d.getDigests(digest)
+	if err == nil {
+		err = access.hideDigests(drs)
+	}
+	var metadata []map[string]string
+	if err == nil {
+		metadata, err = d.visibleMetadata(r, drs)
+	}
 	if err != nil {
 		// Generic internal error.
 		errorCode := time.Now().Unix()
-		log.Errorf("%v verify error code %v: %v", r.RemoteAddr,
+		log.Errorf("%v verify error code %v: %v", logAddr(r),
 			errorCode, err)
 
 		util.RespondWithError(w, http.StatusInternalServerError,
@@ -1172,12 +1562,21 @@ func (d *DcrtimeStore) verifyV2(w http.ResponseWriter, r *http.Request) {
 		vd := v2.VerifyDigest{
 			Digest:          hex.EncodeToString(dr.Digest[:]),
 			ServerTimestamp: dr.Timestamp,
+			ServerTime:      v2.FormatTime(dr.Timestamp),
 			FlushTimestamp:  dr.FlushTimestamp,
+			FlushTime:       v2.FormatTime(dr.FlushTimestamp),
+			Label:           dr.Label,
+			Algorithm:       dr.Algorithm,
 			ChainInformation: v2.ChainInformation{
 				Confirmations:    dr.Confirmations,
 				MinConfirmations: dr.MinConfirmations,
+				ReorgTimestamp:   dr.ReorgTimestamp,
+				ReorgTime:        v2.FormatTime(dr.ReorgTimestamp),
 				ChainTimestamp:   dr.AnchoredTimestamp,
+				ChainTime:        v2.FormatTime(dr.AnchoredTimestamp),
 				Transaction:      dr.Tx.String(),
+				AnchorPrefix:     dr.AnchorPrefix,
+				Attestations:     convertAttestations(dr.Attestations),
 				MerkleRoot:       hex.EncodeToString(dr.MerkleRoot[:]),
 				MerklePath:       v2.MerkleBranch(dr.MerklePath),
 			},
@@ -1194,7 +1593,7 @@ func (d *DcrtimeStore) verifyV2(w http.ResponseWriter, r *http.Request) {
 			// Generic internal error.
 			errorCode := time.Now().Unix()
 			log.Errorf("%v digest ErrorCode translation error "+
-				"code %v: %v", r.RemoteAddr, errorCode, err)
+				"code %v: %v", logAddr(r), errorCode, err)
 
 			util.RespondWithError(w, http.StatusInternalServerError,
 				fmt.Sprintf("Could not retrieve digests, "+
@@ -1203,9 +1602,37 @@ func (d *DcrtimeStore) verifyV2(w http.ResponseWriter, r *http.Request) {
 					errorCode))
 			return
 		}
+		vd.Proofs, err = d.encodeProofs(dr, v.ProofFormats)
+		if err != nil {
+			// Generic internal error.
+			errorCode := time.Now().Unix()
+			log.Errorf("%v proof encoding error code %v: %v",
+				logAddr(r), errorCode, err)
+
+			util.RespondWithError(w, http.StatusInternalServerError,
+				fmt.Sprintf("Could not encode proofs, "+
+					"contact administrator and provide "+
+					"the following error code: %v",
+					errorCode))
+			return
+		}
+		vd.Metadata = metadata[len(drs)-1]
 		dReply = vd
 	}
 
+	if err := d.auditProofs(r, drs, v.ProofFormats); err != nil {
+		// Generic internal error.
+		errorCode := time.Now().Unix()
+		log.Errorf("%v proof audit error code %v: %v", logAddr(r),
+			errorCode, err)
+
+		util.RespondWithError(w, http.StatusInternalServerError,
+			fmt.Sprintf("Could not record served proofs, contact "+
+				"administrator and provide the following "+
+				"error code: %v", errorCode))
+		return
+	}
+
 	util.RespondWithJSON(w, http.StatusOK, v2.VerifyReply{
 		ID:        v.ID,
 		Timestamp: tsReply,
@@ -1214,14 +1641,14 @@ func (d *DcrtimeStore) verifyV2(w http.ResponseWriter, r *http.Request) {
 }
 
 func (d *DcrtimeStore) lastAnchorV2(w http.ResponseWriter, r *http.Request) {
-	log.Infof("%v LastAnchor %v", r.URL.Path, r.RemoteAddr)
+	log.Infof("%v LastAnchor %v", r.URL.Path, logAddr(r))
 
 	lastAnchorResult, err := d.backend.LastAnchor()
 	if err != nil {
 		errorCode := time.Now().Unix()
 
 		log.Errorf("%v lastAnchor error code %v: %v",
-			r.RemoteAddr, errorCode, err)
+			logAddr(r), errorCode, err)
 		util.RespondWithError(w, http.StatusInternalServerError,
 			fmt.Sprintf("failed to retrieve lastest anchor info, "+
 				"contact administrator and provide "+
@@ -1231,6 +1658,7 @@ func (d *DcrtimeStore) lastAnchorV2(w http.ResponseWriter, r *http.Request) {
 
 	util.RespondWithJSON(w, http.StatusOK, v2.LastAnchorReply{
 		ChainTimestamp: lastAnchorResult.ChainTimestamp,
+		ChainTime:      v2.FormatTime(lastAnchorResult.ChainTimestamp),
 		Transaction:    lastAnchorResult.Tx.String(),
 		BlockHash:      lastAnchorResult.BlockHash,
 		BlockHeight:    lastAnchorResult.BlockHeight,
@@ -1249,19 +1677,23 @@ func (d *DcrtimeStore) lastDigestsV2(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if ld.N > d.cfg.MaxDigests {
+	if max := d.maxDigests(r); ld.N > max {
 		util.RespondWithError(w, http.StatusUnprocessableEntity,
-			fmt.Sprintf("Invalid number %d of digests requested. Max is: %d", ld.N, d.cfg.MaxDigests))
+			fmt.Sprintf("Invalid number %d of digests requested. Max is: %d", ld.N, max))
 		return
 	}
 
-	log.Infof("%v LastDigests %v", r.URL.Path, r.RemoteAddr)
+	log.Infof("%v LastDigests %v", r.URL.Path, logAddr(r))
 
 	ldr, err := d.backend.LastDigests(ld.N)
+	if err == nil {
+		// Only digests that may be revealed are returned.
+		ldr, err = d.newDigestAccess(r, nil).visibleResults(ldr)
+	}
 	if err != nil {
 		errorCode := time.Now().Unix()
 		log.Errorf("%v LastDigests error code %v: %v",
-			r.RemoteAddr, errorCode, err)
+			logAddr(r), errorCode, err)
 		util.RespondWithError(w, http.StatusInternalServerError,
 			fmt.Sprintf("failed to retrieve latest %d digests info, "+
 				"contact administrator and provide "+
@@ -1274,11 +1706,17 @@ func (d *DcrtimeStore) lastDigestsV2(w http.ResponseWriter, r *http.Request) {
 		vd := v2.VerifyDigest{
 			Digest:          hex.EncodeToString(vr.Digest[:]),
 			ServerTimestamp: vr.Timestamp,
+			ServerTime:      v2.FormatTime(vr.Timestamp),
 			ChainInformation: v2.ChainInformation{
 				ChainTimestamp:   vr.AnchoredTimestamp,
+				ChainTime:        v2.FormatTime(vr.AnchoredTimestamp),
 				Confirmations:    vr.Confirmations,
 				MinConfirmations: vr.MinConfirmations,
+				ReorgTimestamp:   vr.ReorgTimestamp,
+				ReorgTime:        v2.FormatTime(vr.ReorgTimestamp),
 				Transaction:      vr.Tx.String(),
+				AnchorPrefix:     vr.AnchorPrefix,
+				Attestations:     convertAttestations(vr.Attestations),
 				MerkleRoot:       hex.EncodeToString(vr.MerkleRoot[:]),
 				MerklePath:       v2.MerkleBranch(vr.MerklePath),
 			},
@@ -1296,7 +1734,7 @@ func (d *DcrtimeStore) lastDigestsV2(w http.ResponseWriter, r *http.Request) {
 			// Generic internal error.
 			errorCode := time.Now().Unix()
 			log.Errorf("%v digest ErrorCode translation error "+
-				"code %v: %v", r.RemoteAddr, errorCode, err)
+				"code %v: %v", logAddr(r), errorCode, err)
 
 			util.RespondWithError(w, http.StatusInternalServerError,
 				fmt.Sprintf("Could not retrieve last %d digests, "+
@@ -1308,27 +1746,352 @@ func (d *DcrtimeStore) lastDigestsV2(w http.ResponseWriter, r *http.Request) {
 		vdReply = append(vdReply, vd)
 	}
 
+	if err := d.auditProofs(r, ldr, nil); err != nil {
+		// Generic internal error.
+		errorCode := time.Now().Unix()
+		log.Errorf("%v proof audit error code %v: %v", logAddr(r),
+			errorCode, err)
+
+		util.RespondWithError(w, http.StatusInternalServerError,
+			fmt.Sprintf("Could not record served proofs, contact "+
+				"administrator and provide the following "+
+				"error code: %v", errorCode))
+		return
+	}
+
 	util.RespondWithJSON(w, http.StatusOK, v2.LastDigestsReply{
 		Digests: vdReply,
 	})
 }
 
+// labelV2 returns the status of all digests that were timestamped under the
+// provided group label.
+// Handles /v2/label
+func (d *DcrtimeStore) labelV2(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	var l v2.Label
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&l); err != nil {
+		util.RespondWithError(w, http.StatusBadRequest,
+			"Invalid request payload")
+		return
+	}
+
+	if !v2.RegexpLabel.MatchString(l.Label) {
+		util.RespondWithError(w, http.StatusBadRequest,
+			"Invalid Label")
+		return
+	}
+
+	via := logAddr(r)
+	xff := r.Header.Get(forward)
+	if xff != "" {
+		via = fmt.Sprintf("%v via %v", logAddr(r), xff)
+	}
+	log.Infof("%v Label %v: %v", r.URL.Path, via, l.Label)
+
+	drs, err := d.backend.GetLabel(l.Label)
+	if err == nil {
+		// Only digests that may be revealed are returned.
+		drs, err = d.newDigestAccess(r, nil).visibleResults(drs)
+	}
+	if err != nil {
+		// Generic internal error.
+		errorCode := time.Now().Unix()
+		log.Errorf("%v label error code %v: %v", logAddr(r),
+			errorCode, err)
+
+		util.RespondWithError(w, http.StatusInternalServerError,
+			fmt.Sprintf("Could not retrieve digests, contact "+
+				"administrator and provide the following "+
+				"error code: %v", errorCode))
+		return
+	}
+
+	// Translate digest results.
+	dReply := make([]v2.VerifyDigest, 0, len(drs))
+	for _, dr := range drs {
+		vd := v2.VerifyDigest{
+			Digest:          hex.EncodeToString(dr.Digest[:]),
+			ServerTimestamp: dr.Timestamp,
+			ServerTime:      v2.FormatTime(dr.Timestamp),
+			FlushTimestamp:  dr.FlushTimestamp,
+			FlushTime:       v2.FormatTime(dr.FlushTimestamp),
+			Label:           dr.Label,
+			Algorithm:       dr.Algorithm,
+			ChainInformation: v2.ChainInformation{
+				Confirmations:    dr.Confirmations,
+				MinConfirmations: dr.MinConfirmations,
+				ReorgTimestamp:   dr.ReorgTimestamp,
+				ReorgTime:        v2.FormatTime(dr.ReorgTimestamp),
+				ChainTimestamp:   dr.AnchoredTimestamp,
+				ChainTime:        v2.FormatTime(dr.AnchoredTimestamp),
+				Transaction:      dr.Tx.String(),
+				AnchorPrefix:     dr.AnchorPrefix,
+				Attestations:     convertAttestations(dr.Attestations),
+				MerkleRoot:       hex.EncodeToString(dr.MerkleRoot[:]),
+				MerklePath:       v2.MerkleBranch(dr.MerklePath),
+			},
+			Result: -1,
+		}
+		switch dr.ErrorCode {
+		case backend.ErrorOK:
+			vd.Result = v2.ResultOK
+		case backend.ErrorNotFound:
+			vd.Result = v2.ResultDoesntExistError
+		}
+
+		if vd.Result == -1 {
+			// Generic internal error.
+			errorCode := time.Now().Unix()
+			log.Errorf("%v digest ErrorCode translation error "+
+				"code %v: %v", logAddr(r), errorCode,
+				dr.ErrorCode)
+
+			util.RespondWithError(w, http.StatusInternalServerError,
+				fmt.Sprintf("Could not retrieve digests, "+
+					"contact administrator and provide "+
+					"the following error code: %v",
+					errorCode))
+			return
+		}
+		dReply = append(dReply, vd)
+	}
+
+	if err := d.auditProofs(r, drs, nil); err != nil {
+		// Generic internal error.
+		errorCode := time.Now().Unix()
+		log.Errorf("%v proof audit error code %v: %v", logAddr(r),
+			errorCode, err)
+
+		util.RespondWithError(w, http.StatusInternalServerError,
+			fmt.Sprintf("Could not record served proofs, contact "+
+				"administrator and provide the following "+
+				"error code: %v", errorCode))
+		return
+	}
+
+	util.RespondWithJSON(w, http.StatusOK, v2.LabelReply{
+		ID:      l.ID,
+		Label:   l.Label,
+		Digests: dReply,
+	})
+}
+
+// windowV2 returns the would-be merkle root of the collection that is
+// currently accepting digests.  The merkle root is not final until the
+// collection is flushed.
+// Handles /v2/window
+func (d *DcrtimeStore) windowV2(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	var wd v2.Window
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&wd); err != nil {
+		util.RespondWithError(w, http.StatusBadRequest,
+			"Invalid request payload")
+		return
+	}
+
+	if wd.Label != "" && !v2.RegexpLabel.MatchString(wd.Label) {
+		util.RespondWithError(w, http.StatusBadRequest,
+			"Invalid Label")
+		return
+	}
+
+	via := logAddr(r)
+	xff := r.Header.Get(forward)
+	if xff != "" {
+		via = fmt.Sprintf("%v via %v", logAddr(r), xff)
+	}
+	log.Infof("%v Window %v: %v", r.URL.Path, via, wd.Label)
+
+	wr, err := d.backend.PreviewWindow(wd.Label)
+	if err != nil {
+		// Generic internal error.
+		errorCode := time.Now().Unix()
+		log.Errorf("%v window error code %v: %v", logAddr(r),
+			errorCode, err)
+
+		util.RespondWithError(w, http.StatusInternalServerError,
+			fmt.Sprintf("Could not preview window, contact "+
+				"administrator and provide the following "+
+				"error code: %v", errorCode))
+		return
+	}
+
+	reply := v2.WindowReply{
+		ID:              wd.ID,
+		Label:           wd.Label,
+		ServerTimestamp: wr.Timestamp,
+		ServerTime:      v2.FormatTime(wr.Timestamp),
+		EndTimestamp:    wr.Ends,
+		EndHeight:       wr.EndHeight,
+		DigestCount:     wr.Digests,
+	}
+	if wr.Ends != 0 {
+		reply.EndTime = v2.FormatTime(wr.Ends)
+	}
+	if wr.Digests != 0 {
+		reply.MerkleRoot = hex.EncodeToString(wr.MerkleRoot[:])
+	}
+	util.RespondWithJSON(w, http.StatusOK, reply)
+}
+
+// anchorsV2 returns all collections that were anchored between two block
+// heights.  The digests of the collections are only returned to clients with
+// an api token with the verify scope, everybody else receives digest counts.
+// Handles /v2/anchors
+func (d *DcrtimeStore) anchorsV2(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	var a v2.Anchors
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&a); err != nil {
+		util.RespondWithError(w, http.StatusBadRequest,
+			"Invalid request payload")
+		return
+	}
+
+	if a.FromHeight < 0 || a.FromHeight > a.ToHeight {
+		util.RespondWithError(w, http.StatusBadRequest,
+			"Invalid block height range")
+		return
+	}
+	if a.ToHeight-a.FromHeight >= v2.MaxAnchorsHeightRange {
+		util.RespondWithError(w, http.StatusUnprocessableEntity,
+			fmt.Sprintf("Block height range exceeds %v blocks",
+				v2.MaxAnchorsHeightRange))
+		return
+	}
+
+	// Restricted APIs already authorized the verify scope.
+	privileged := d.cfg.RestrictAPI
+	if !privileged && r.URL.Query().Get("apitoken") != "" {
+		privileged = d.isAuthorized(r, v2.TokenScopeVerify)
+	}
+
+	via := logAddr(r)
+	xff := r.Header.Get(forward)
+	if xff != "" {
+		via = fmt.Sprintf("%v via %v", logAddr(r), xff)
+	}
+	log.Infof("%v Anchors %v: %v-%v", r.URL.Path, via, a.FromHeight,
+		a.ToHeight)
+
+	ars, err := d.backend.GetAnchors(a.FromHeight, a.ToHeight)
+	if err != nil {
+		// Generic internal error.
+		errorCode := time.Now().Unix()
+		log.Errorf("%v anchors error code %v: %v", logAddr(r),
+			errorCode, err)
+
+		util.RespondWithError(w, http.StatusInternalServerError,
+			fmt.Sprintf("Could not retrieve anchors, contact "+
+				"administrator and provide the following "+
+				"error code: %v", errorCode))
+		return
+	}
+
+	reply := v2.AnchorsReply{
+		ID:         a.ID,
+		FromHeight: a.FromHeight,
+		ToHeight:   a.ToHeight,
+		Anchors:    make([]v2.Anchor, 0, len(ars)),
+	}
+	access := d.newDigestAccess(r, nil)
+	for _, ar := range ars {
+		anchor := convertAnchor(ar, privileged)
+		if privileged && access != nil {
+			// Digests that may not be revealed are hidden, the
+			// digest count remains public.
+			digests, err := access.visibleDigests(ar.Timestamp,
+				ar.Digests)
+			if err != nil {
+				// Generic internal error.
+				errorCode := time.Now().Unix()
+				log.Errorf("%v anchors error code %v: %v",
+					logAddr(r), errorCode, err)
+
+				util.RespondWithError(w,
+					http.StatusInternalServerError,
+					fmt.Sprintf("Could not retrieve anchors, "+
+						"contact administrator and "+
+						"provide the following error "+
+						"code: %v", errorCode))
+				return
+			}
+			anchor.Digests = make([]string, 0, len(digests))
+			for _, digest := range digests {
+				anchor.Digests = append(anchor.Digests,
+					hex.EncodeToString(digest[:]))
+			}
+		}
+		reply.Anchors = append(reply.Anchors, anchor)
+	}
+
+	util.RespondWithJSON(w, http.StatusOK, reply)
+}
+
+// convertAttestations converts the secondary attestations of a collection to
+// their API representation.
+func convertAttestations(as []backend.Attestation) []v2.Attestation {
+	if len(as) == 0 {
+		return nil
+	}
+	attestations := make([]v2.Attestation, 0, len(as))
+	for _, a := range as {
+		attestations = append(attestations, v2.Attestation{
+			Anchorer: a.Anchorer,
+			Proof:    hex.EncodeToString(a.Proof),
+		})
+	}
+	return attestations
+}
+
+// convertAnchor converts an anchored collection to its API representation.
+// The digests are only included if requested.
+func convertAnchor(ar backend.AnchorResult, digests bool) v2.Anchor {
+	anchor := v2.Anchor{
+		ServerTimestamp: ar.Timestamp,
+		ServerTime:      v2.FormatTime(ar.Timestamp),
+		Transaction:     ar.Tx.String(),
+		MerkleRoot:      hex.EncodeToString(ar.MerkleRoot[:]),
+		BlockHeight:     ar.BlockHeight,
+		ChainTimestamp:  ar.ChainTimestamp,
+		ChainTime:       v2.FormatTime(ar.ChainTimestamp),
+		DigestCount:     len(ar.Digests),
+	}
+	if digests {
+		anchor.Digests = make([]string, 0, len(ar.Digests))
+		for _, digest := range ar.Digests {
+			anchor.Digests = append(anchor.Digests,
+				hex.EncodeToString(digest[:]))
+		}
+	}
+	return anchor
+}
+
 // walletBalanceV2 takes an apitoken get param and returns balance information
 // of the wallet.
 func (d *DcrtimeStore) walletBalanceV2(w http.ResponseWriter, r *http.Request) {
-	if !d.isAuthorized(r) {
-		util.RespondWithError(w, http.StatusUnauthorized, "not authorized")
+	if !d.isAuthorized(r, v2.TokenScopeAdmin) {
+		d.respondNotAuthorized(w, r)
 		return
 	}
 
-	log.Infof("%v WalletBalance %v", r.URL.Path, r.RemoteAddr)
+	log.Infof("%v WalletBalance %v", r.URL.Path, logAddr(r))
 
 	balanceResult, err := d.backend.GetBalance()
 	if err != nil {
 		errorCode := time.Now().Unix()
 
 		log.Errorf("%v walletBalance error code %v: %v",
-			r.RemoteAddr, errorCode, err)
+			logAddr(r), errorCode, err)
 		util.RespondWithError(w, http.StatusInternalServerError,
 			fmt.Sprintf("failed to retrieve wallet balance, "+
 				"contact administrator and provide "+
@@ -1343,18 +2106,36 @@ func (d *DcrtimeStore) walletBalanceV2(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-// isAuthorized returns true if the first api token query parameter
-// matches any APIToken configuration value. Otherwise, it returns false.
-func (d *DcrtimeStore) isAuthorized(r *http.Request) bool {
+// isAuthorized returns true if the first api token query parameter matches
+// any APIToken configuration value, which are admin tokens, or an api token
+// in the backend that grants the provided scope. Otherwise, it returns false.
+func (d *DcrtimeStore) isAuthorized(r *http.Request, scope string) bool {
 	apiToken := r.URL.Query().Get("apitoken")
 	if _, exist := d.apiTokens[apiToken]; exist {
 		return true
 	}
+	if apiToken != "" && d.authorizeToken(apiToken, scope) {
+		return true
+	}
+	// Client certificates are an alternative to api tokens for
+	// timestamping only.
+	if scope == v2.TokenScopeTimestamp && clientCertCN(r) != "" {
+		return true
+	}
 
-	log.Errorf("isAuthorized %v: authentication failed", r.RemoteAddr)
+	log.Errorf("isAuthorized %v: authentication failed", logAddr(r))
 	return false
 }
 
+// storedAlgorithm returns the digest algorithm as it is stored in the backend.
+// SHA-256 is the default and is stored as an empty algorithm.
+func storedAlgorithm(algorithm string) string {
+	if algorithm == v2.AlgorithmSHA256 {
+		return ""
+	}
+	return algorithm
+}
+
 // convertDigests receives an array of string digests and converts it to
 // sha256, format currently being used throughout the code.
 func convertDigests(d []string) ([][sha256.Size]byte, error) {
@@ -1420,95 +2201,201 @@ func (d *DcrtimeStore) addRoute(method string, route string, handler http.Handle
 	d.router.HandleFunc(route, closedHandler).Methods(method)
 }
 
-func _main() error {
-	// Load configuration and parse command line.  This function also
-	// initializes logging and configures it accordingly.
-	loadedCfg, _, err := loadConfig()
+// newDcrtimeStore returns the application context of the provided
+// configuration.  The backend is nil in proxy mode.  The routes are not set up
+// until setupRoutes is called, which allows tests to inject an in-memory
+// backend, such as backendtest.Backend, and serve the handlers through
+// httptest.
+func newDcrtimeStore(cfg *config, b backend.Backend) *DcrtimeStore {
+	d := &DcrtimeStore{
+		backend:   b,
+		cfg:       cfg,
+		ctx:       context.Background(),
+		apiTokens: apiTokenMap(cfg),
+		started:   time.Now(),
+		notifiers: newNotifiers(cfg),
+		ticketC:   make(chan struct{}, 1),
+	}
+	d.scopeMaxDigests, _ = parseScopeMaxDigests(cfg.ScopeMaxDigests)
+	d.ipPolicies, _ = parseIPPolicies(cfg.AllowedIPs, cfg.BannedIPs)
+	d.trustedProxies, _ = parseTrustedProxies(cfg.TrustedProxies)
+	return d
+}
+
+// newWallet returns the wallet client of the configured dcrwallet, or dcrd
+// when dcrdhost is set, that anchors the digests of the storehost.
+func newWallet(cfg *config) (dcrtimewallet.Wallet, error) {
+	fees := dcrtimewallet.FeePolicy{
+		Mode:    cfg.AnchorFeeMode,
+		FeeRate: cfg.AnchorFeeRate,
+		MaxFee:  cfg.AnchorMaxFee,
+	}
+	var wallet dcrtimewallet.Wallet
+	var err error
+	if cfg.DcrdHost != "" {
+		// The anchor utxo is kept next to, not in, the data
+		// directory of the active network.
+		wallet, err = dcrtimewallet.NewDcrd(activeNetParams.Params,
+			cfg.DcrdHost,
+			cfg.DcrdUser,
+			cfg.DcrdPass,
+			cfg.DcrdCert,
+			cfg.AnchorKey,
+			cfg.AnchorOutpoint,
+			cfg.DataDir+".anchorutxo",
+			fees)
+	} else {
+		// The account was validated when the config was loaded.
+		account, _ := walletAccount(cfg.WalletAccounts)
+		wallet, err = dcrtimewallet.New(activeNetParams.Net,
+			cfg.WalletCert,
+			cfg.WalletHost,
+			cfg.WalletClientCert,
+			cfg.WalletClientKey,
+			account,
+			[]byte(cfg.WalletPassphrase),
+			[]byte(cfg.WalletAccountPass),
+			fees)
+	}
 	if err != nil {
-		return fmt.Errorf("could not load configuration file: %v", err)
+		return nil, err
 	}
-	defer func() {
-		if logRotator != nil {
-			logRotator.Close()
-		}
-	}()
-
-	var proxy bool
-	mode := "Store"
-	if loadedCfg.StoreHost != "" {
-		proxy = true
-		mode = "Proxy"
+	log.Infof("Anchor fee mode: %v", fees.Mode)
+	if fees.MaxFee > 0 {
+		log.Infof("Anchor max fee: %v atoms", fees.MaxFee)
 	}
-	log.Infof("Version : %v", version())
-	log.Infof("Mode    : %v", mode)
-	log.Infof("Network : %v", activeNetParams.Name)
-	log.Infof("Home dir: %v", loadedCfg.HomeDir)
+	if cfg.AnchorRetry > 0 {
+		log.Infof("Anchor retry: %v", cfg.AnchorRetry)
+	}
+	return wallet, nil
+}
 
-	// Sets subsystem loggers
-	dcrtimewallet.UseLogger(walletLog)
+// openBackend opens the configured backend, which anchors through the
+// provided wallet, and the keys of the storehost.  The wallet is closed if
+// the backend can not be opened.  Tests inject an in-memory wallet and a
+// backend registered with backend.Register here.
+func (d *DcrtimeStore) openBackend(wallet dcrtimewallet.Wallet) error {
+	if d.cfg.VerifyCacheSize > 0 {
+		d.verifyCache = newVerifyCache(d.cfg.VerifyCacheSize)
+	}
 
-	// Create the data directory in case it does not exist.
-	err = os.MkdirAll(loadedCfg.DataDir, 0700)
+	anchorers, err := anchorer.ParseAll(d.cfg.Anchorers)
 	if err != nil {
+		wallet.Close()
 		return err
 	}
+	for _, a := range anchorers {
+		log.Infof("Secondary anchorer: %v", a.Name())
+	}
 
-	// Generate the TLS cert and key file if both don't already
-	// exist.
-	if !fileExists(loadedCfg.HTTPSKey) &&
-		!fileExists(loadedCfg.HTTPSCert) {
-		log.Infof("Generating HTTPS keypair...")
+	// The backend enforces the highest limit, the limit of a request is
+	// enforced by the handler.
+	maxDigests := d.cfg.MaxDigests
+	for _, max := range d.scopeMaxDigests {
+		if max > maxDigests {
+			maxDigests = max
+		}
+	}
 
-		err := util.GenCertPair("dcrtimed", loadedCfg.HTTPSCert,
-			loadedCfg.HTTPSKey)
+	var encryptionKey []byte
+	if d.cfg.EncryptionKey != "" {
+		encryptionKey, err = filesystem.LoadEncryptionKey(
+			d.cfg.EncryptionKey)
 		if err != nil {
-			return fmt.Errorf("unable to create https keypair: %v",
-				err)
+			wallet.Close()
+			return err
 		}
-
-		log.Infof("HTTPS keypair created...")
 	}
 
-	// Setup application context
-	d := &DcrtimeStore{
-		cfg:       loadedCfg,
-		ctx:       context.Background(),
-		apiTokens: apiTokenMap(loadedCfg),
+	filesystem.UseLogger(fsbeLog)
+	leveldb.UseLogger(ldbeLog)
+	sidestore.UseLogger(sideLog)
+	log.Infof("Backend: %v", d.cfg.Backend)
+	b, err := backend.Open(d.cfg.Backend, backend.Config{
+		DataDir:           d.cfg.DataDir,
+		Wallet:            wallet,
+		Anchorers:         anchorers,
+		EnableCollections: d.cfg.EnableCollections,
+		Confirmations:     d.cfg.Confirmations,
+		MaxDigests:        maxDigests,
+		FastAnchors:       d.cfg.FastAnchors,
+		AnchorPrefix:      d.cfg.AnchorPrefix,
+		AnchorRetry:       d.cfg.AnchorRetry,
+		AnchorBlocks:      d.cfg.AnchorBlocks,
+		ReadOnly:          d.cfg.ReadOnly,
+		Maintenance:       d.cfg.Maintenance,
+		MaintenanceQueue:  d.cfg.MaintenanceQueue,
+		ConfirmRefresh:    d.cfg.ConfirmRefresh,
+		ConfirmWorkers:    d.cfg.ConfirmWorkers,
+		LowBalance:        d.cfg.LowBalance,
+		PauseLowBalance:   d.cfg.LowBalancePause,
+		FlushWorkers:      d.cfg.FlushWorkers,
+		EncryptionKey:     encryptionKey,
+	})
+	if err != nil {
+		wallet.Close()
+		if errors.Is(err, filesystem.ErrLocked) ||
+			errors.Is(err, leveldb.ErrLocked) {
+			return fmt.Errorf("%v, is another dcrtimed running?",
+				err)
+		}
+		return err
 	}
 
-	var certPool *x509.CertPool
-	if proxy {
-		if !fileExists(loadedCfg.StoreCert) {
-			return fmt.Errorf("unable to find store cert %v",
-				loadedCfg.StoreCert)
-		}
-		storeCert, err := os.ReadFile(loadedCfg.StoreCert)
+	// Access keys are derived from a secret that is kept next to, not in,
+	// the data directory of the active network.
+	if d.cfg.PrivateDigests {
+		d.accessSecret, err = loadAccessSecret(d.cfg.DataDir +
+			accessSecretSuffix)
 		if err != nil {
-			return fmt.Errorf("unable to read store cert %v: %v",
-				loadedCfg.StoreCert, err)
-		}
-		certPool = x509.NewCertPool()
-		if !certPool.AppendCertsFromPEM(storeCert) {
-			return fmt.Errorf("unable to load cert")
+			b.Close()
+			return err
 		}
-	} else {
-		// Setup backend.
-		filesystem.UseLogger(fsbeLog)
-		b, err := filesystem.New(loadedCfg.DataDir,
-			loadedCfg.WalletCert,
-			loadedCfg.WalletHost,
-			loadedCfg.WalletClientCert,
-			loadedCfg.WalletClientKey,
-			loadedCfg.EnableCollections,
-			loadedCfg.Confirmations,
-			loadedCfg.MaxDigests,
-			[]byte(loadedCfg.WalletPassphrase))
+	}
+
+	// The identity keys are kept next to the data directory as well.
+	d.identityKeys, err = loadIdentityKeys(d.cfg.DataDir +
+		identityKeySuffix)
+	if err != nil {
+		b.Close()
+		return err
+	}
+	log.Infof("Identity key: %x", d.activeKey().Public())
+	next := d.identityKeys[len(d.identityKeys)-1]
+	if next.notBefore > time.Now().Unix() {
+		log.Infof("Identity key %x takes over at %v",
+			next.key.Public(), v2.FormatTime(next.notBefore))
+	}
+
+	// A standby replicates the primary, streams are not bounded by a
+	// timeout.
+	if d.cfg.ReplicateHost != "" {
+		d.primary, err = newUpstream(d.cfg.ReplicateHost,
+			d.cfg.ReplicateCert, 0, false)
 		if err != nil {
+			b.Close()
 			return err
 		}
+	}
+
+	d.backend = b
 
-		d.backend = b
+	// Coalesce concurrent submissions into grouped backend writes.
+	if d.cfg.CoalesceDelay > 0 {
+		d.coalescer = newCoalescer(b, d.cfg.CoalesceDelay,
+			d.cfg.CoalesceDigests)
+		go d.coalescer.run(d.ctx)
+		log.Infof("Coalesce submissions: %v, up to %v digests",
+			d.cfg.CoalesceDelay, d.cfg.CoalesceDigests)
 	}
+	return nil
+}
+
+// setupRoutes registers the handlers of the configured api versions on a new
+// router.  The handlers of the backend are used when the backend is set and
+// the proxy handlers otherwise.
+func (d *DcrtimeStore) setupRoutes() {
+	proxy := d.backend == nil
 
 	// Setup mux
 	d.router = mux.NewRouter()
@@ -1524,22 +2411,47 @@ func _main() error {
 	var statusV2Route func(http.ResponseWriter, *http.Request)
 	var timestampBatchV2Route func(http.ResponseWriter, *http.Request)
 	var verifyBatchV2Route func(http.ResponseWriter, *http.Request)
+	var verifyStreamV2Route http.HandlerFunc
 	var timestampV2Route func(http.ResponseWriter, *http.Request)
 	var verifyV2Route func(http.ResponseWriter, *http.Request)
 	var walletBalanceV2Route http.HandlerFunc
 	var lastAnchorV2Route http.HandlerFunc
 	var lastDigestsV2Route func(http.ResponseWriter, *http.Request)
+	var labelV2Route http.HandlerFunc
+	var windowV2Route http.HandlerFunc
+	var anchorsV2Route http.HandlerFunc
+	var identityV2Route http.HandlerFunc
+	var bloomV2Route http.HandlerFunc
+	var anchorChainV2Route http.HandlerFunc
+	var timestampAggregateV2Route http.HandlerFunc
+	var timestampAsyncV2Route http.HandlerFunc
+	var ticketV2Route http.HandlerFunc
+	var sessionOpenV2Route http.HandlerFunc
+	var sessionAppendV2Route http.HandlerFunc
+	var sessionCloseV2Route http.HandlerFunc
+	var sessionStatusV2Route http.HandlerFunc
+	var adminStatusV2Route http.HandlerFunc
+	var tokensV2Route http.HandlerFunc
+	var tokenCreateV2Route http.HandlerFunc
+	var tokenRevokeV2Route http.HandlerFunc
+	var usageV2Route http.HandlerFunc
+	var webhooksV2Route http.HandlerFunc
+	var webhookRetryV2Route http.HandlerFunc
+	var webhookDeleteV2Route http.HandlerFunc
+	var webhookSubscribeV2Route http.HandlerFunc
+	var proofAuditV2Route http.HandlerFunc
+	var collectionStatsV2Route http.HandlerFunc
+	var exportV2Route http.HandlerFunc
+	var replicationV2Route http.HandlerFunc
+	var maintenanceV2Route http.HandlerFunc
+	var collectionsV2Route http.HandlerFunc
+	var searchV2Route http.HandlerFunc
+	var collectionRenameV2Route http.HandlerFunc
+	var collectionDeleteV2Route http.HandlerFunc
+	var collectionReceiptV2Route http.HandlerFunc
 
-	if certPool != nil {
+	if proxy {
 		// PROXY ENABLED
-		tlsConfig := &tls.Config{
-			RootCAs: certPool,
-		}
-		tr := &http.Transport{
-			TLSClientConfig: tlsConfig,
-		}
-		d.httpClient = &http.Client{Transport: tr}
-
 		statusV1Route = d.proxyStatusV1
 		timestampV1Route = d.proxyTimestampV1
 		verifyV1Route = d.proxyVerifyV1
@@ -1549,11 +2461,44 @@ func _main() error {
 		statusV2Route = d.proxyStatusV2
 		timestampBatchV2Route = d.proxyTimestampBatchV2
 		verifyBatchV2Route = d.proxyVerifyBatchV2
+		verifyStreamV2Route = d.proxyVerifyStreamV2
 		timestampV2Route = d.proxyTimestampV2
 		verifyV2Route = d.proxyVerifyV2
 		walletBalanceV2Route = d.proxyWalletBalanceV2
 		lastAnchorV2Route = d.proxyLastAnchorV2
 		lastDigestsV2Route = d.proxyLastDigestsV2Route
+		labelV2Route = d.proxyLabelV2
+		windowV2Route = d.proxyWindowV2
+		anchorsV2Route = d.proxyAnchorsV2
+		identityV2Route = d.proxyIdentityV2
+		bloomV2Route = d.proxyBloomV2
+		anchorChainV2Route = d.proxyAnchorChainV2
+		timestampAggregateV2Route = d.proxyTimestampAggregateV2
+		timestampAsyncV2Route = d.proxyTimestampAsyncV2
+		ticketV2Route = d.proxyTicketV2
+		sessionOpenV2Route = d.proxySessionOpenV2
+		sessionAppendV2Route = d.proxySessionAppendV2
+		sessionCloseV2Route = d.proxySessionCloseV2
+		sessionStatusV2Route = d.proxySessionStatusV2
+		adminStatusV2Route = d.proxyAdminStatusV2
+		tokensV2Route = d.proxyTokensV2
+		tokenCreateV2Route = d.proxyTokenCreateV2
+		tokenRevokeV2Route = d.proxyTokenRevokeV2
+		usageV2Route = d.proxyUsageV2
+		webhooksV2Route = d.proxyWebhooksV2
+		webhookRetryV2Route = d.proxyWebhookRetryV2
+		webhookDeleteV2Route = d.proxyWebhookDeleteV2
+		webhookSubscribeV2Route = d.proxyWebhookSubscribeV2
+		proofAuditV2Route = d.proxyProofAuditV2
+		collectionStatsV2Route = d.proxyCollectionStatsV2
+		exportV2Route = d.proxyExportV2
+		replicationV2Route = d.proxyReplicationV2
+		maintenanceV2Route = d.proxyMaintenanceV2
+		collectionsV2Route = d.proxyCollectionsV2
+		searchV2Route = d.proxySearchV2
+		collectionRenameV2Route = d.proxyCollectionRenameV2
+		collectionDeleteV2Route = d.proxyCollectionDeleteV2
+		collectionReceiptV2Route = d.proxyCollectionReceiptV2
 	} else {
 		statusV1Route = d.statusV1
 		timestampV1Route = d.timestampV1
@@ -1564,17 +2509,194 @@ func _main() error {
 		statusV2Route = d.statusV2
 		timestampBatchV2Route = d.timestampBatchV2
 		verifyBatchV2Route = d.verifyBatchV2
+		verifyStreamV2Route = d.verifyStreamV2
 		timestampV2Route = d.timestampV2
 		verifyV2Route = d.verifyV2
 		walletBalanceV2Route = d.walletBalanceV2
 		lastAnchorV2Route = d.lastAnchorV2
 		lastDigestsV2Route = d.lastDigestsV2
-	}
+		labelV2Route = d.labelV2
+		windowV2Route = d.windowV2
+		anchorsV2Route = d.anchorsV2
+		identityV2Route = d.identityV2
+		bloomV2Route = d.bloomV2
+		anchorChainV2Route = d.anchorChainV2
+		timestampAggregateV2Route = d.timestampAggregateV2
+		timestampAsyncV2Route = d.timestampAsyncV2
+		ticketV2Route = d.ticketV2
+		sessionOpenV2Route = d.sessionOpenV2
+		sessionAppendV2Route = d.sessionAppendV2
+		sessionCloseV2Route = d.sessionCloseV2
+		sessionStatusV2Route = d.sessionStatusV2
+		adminStatusV2Route = d.adminStatusV2
+		tokensV2Route = d.tokensV2
+		tokenCreateV2Route = d.tokenCreateV2
+		tokenRevokeV2Route = d.tokenRevokeV2
+		usageV2Route = d.usageV2
+		webhooksV2Route = d.webhooksV2
+		webhookRetryV2Route = d.webhookRetryV2
+		webhookDeleteV2Route = d.webhookDeleteV2
+		webhookSubscribeV2Route = d.webhookSubscribeV2
+		proofAuditV2Route = d.proofAuditV2
+		collectionStatsV2Route = d.collectionStatsV2
+		exportV2Route = d.exportV2
+		replicationV2Route = d.replicationV2
+		maintenanceV2Route = d.maintenanceV2
+		collectionsV2Route = d.collectionsV2
+		searchV2Route = d.searchV2
+		collectionRenameV2Route = d.collectionRenameV2
+		collectionDeleteV2Route = d.collectionDeleteV2
+		collectionReceiptV2Route = d.collectionReceiptV2
+
+		// Require scoped api tokens when the api is restricted.
+		if d.cfg.RestrictAPI {
+			ts := v2.TokenScopeTimestamp
+			vs := v2.TokenScopeVerify
+			statusV1Route = d.requireScope(vs, statusV1Route)
+			timestampV1Route = d.requireScope(ts, timestampV1Route)
+			verifyV1Route = d.requireScope(vs, verifyV1Route)
+
+			statusV2Route = d.requireScope(vs, statusV2Route)
+			timestampBatchV2Route = d.requireScope(ts, timestampBatchV2Route)
+			verifyBatchV2Route = d.requireScope(vs, verifyBatchV2Route)
+			verifyStreamV2Route = d.requireScope(vs, verifyStreamV2Route)
+			timestampV2Route = d.requireScope(ts, timestampV2Route)
+			verifyV2Route = d.requireScope(vs, verifyV2Route)
+			lastDigestsV2Route = d.requireScope(vs, lastDigestsV2Route)
+			labelV2Route = d.requireScope(vs, labelV2Route)
+			windowV2Route = d.requireScope(vs, windowV2Route)
+			anchorsV2Route = d.requireScope(vs, anchorsV2Route)
+			bloomV2Route = d.requireScope(vs, bloomV2Route)
+			anchorChainV2Route = d.requireScope(vs, anchorChainV2Route)
+			timestampAggregateV2Route = d.requireScope(ts,
+				timestampAggregateV2Route)
+			timestampAsyncV2Route = d.requireScope(ts,
+				timestampAsyncV2Route)
+			ticketV2Route = d.requireScope(ts, ticketV2Route)
+			sessionOpenV2Route = d.requireScope(ts, sessionOpenV2Route)
+			sessionAppendV2Route = d.requireScope(ts, sessionAppendV2Route)
+			sessionCloseV2Route = d.requireScope(ts, sessionCloseV2Route)
+			sessionStatusV2Route = d.requireScope(ts, sessionStatusV2Route)
+		}
+
+		// Sign receipts and verify replies with the identity key.
+		timestampV1Route = d.signReplies(timestampV1Route)
+		verifyV1Route = d.signReplies(verifyV1Route)
+		timestampBatchV2Route = d.signReplies(timestampBatchV2Route)
+		verifyBatchV2Route = d.signReplies(verifyBatchV2Route)
+		timestampV2Route = d.signReplies(timestampV2Route)
+		verifyV2Route = d.signReplies(verifyV2Route)
+		timestampAggregateV2Route = d.signReplies(timestampAggregateV2Route)
+		ticketV2Route = d.signReplies(ticketV2Route)
+		sessionCloseV2Route = d.signReplies(sessionCloseV2Route)
+	}
+
+	// Replay the replies of retried timestamp requests.
+	d.idempotency = newIdempotencyCache(maxIdempotentReplies)
+	timestampV1Route = d.idempotent(timestampV1Route)
+	timestampBatchV2Route = d.idempotent(timestampBatchV2Route)
+	timestampV2Route = d.idempotent(timestampV2Route)
+	timestampAggregateV2Route = d.idempotent(timestampAggregateV2Route)
+	timestampAsyncV2Route = d.idempotent(timestampAsyncV2Route)
+
+	// Refuse digests while the server is read-only.
+	if d.cfg.ReadOnly {
+		timestampV1Route = refuseReadOnly
+		timestampBatchV2Route = refuseReadOnly
+		timestampV2Route = refuseReadOnly
+		timestampAggregateV2Route = refuseReadOnly
+		timestampAsyncV2Route = refuseReadOnly
+		sessionOpenV2Route = refuseReadOnly
+		sessionAppendV2Route = refuseReadOnly
+		sessionCloseV2Route = refuseReadOnly
+	}
+
+	// Enforce the client address policies of submission and verification
+	// endpoints.
+	statusV1Route = d.requireIP(ipClassVerify, statusV1Route)
+	timestampV1Route = d.requireIP(ipClassSubmit, timestampV1Route)
+	verifyV1Route = d.requireIP(ipClassVerify, verifyV1Route)
+	statusV2Route = d.requireIP(ipClassVerify, statusV2Route)
+	timestampBatchV2Route = d.requireIP(ipClassSubmit, timestampBatchV2Route)
+	verifyBatchV2Route = d.requireIP(ipClassVerify, verifyBatchV2Route)
+	verifyStreamV2Route = d.requireIP(ipClassVerify, verifyStreamV2Route)
+	timestampV2Route = d.requireIP(ipClassSubmit, timestampV2Route)
+	verifyV2Route = d.requireIP(ipClassVerify, verifyV2Route)
+	lastDigestsV2Route = d.requireIP(ipClassVerify, lastDigestsV2Route)
+	labelV2Route = d.requireIP(ipClassVerify, labelV2Route)
+	windowV2Route = d.requireIP(ipClassVerify, windowV2Route)
+	anchorsV2Route = d.requireIP(ipClassVerify, anchorsV2Route)
+	bloomV2Route = d.requireIP(ipClassVerify, bloomV2Route)
+	anchorChainV2Route = d.requireIP(ipClassVerify, anchorChainV2Route)
+	timestampAggregateV2Route = d.requireIP(ipClassSubmit,
+		timestampAggregateV2Route)
+	timestampAsyncV2Route = d.requireIP(ipClassSubmit, timestampAsyncV2Route)
+	ticketV2Route = d.requireIP(ipClassSubmit, ticketV2Route)
+	sessionOpenV2Route = d.requireIP(ipClassSubmit, sessionOpenV2Route)
+	sessionAppendV2Route = d.requireIP(ipClassSubmit, sessionAppendV2Route)
+	sessionCloseV2Route = d.requireIP(ipClassSubmit, sessionCloseV2Route)
+	sessionStatusV2Route = d.requireIP(ipClassSubmit, sessionStatusV2Route)
+	webhookSubscribeV2Route = d.requireIP(ipClassSubmit,
+		webhookSubscribeV2Route)
+
+	// Only serve endpoints on the listeners of their role.
+	statusV1Route = requireRole(rolePublic, statusV1Route)
+	timestampV1Route = requireRole(roleSubmit, timestampV1Route)
+	verifyV1Route = requireRole(rolePublic, verifyV1Route)
+	walletBalanceV1Route = requireRole(roleAdmin, walletBalanceV1Route)
+	lastAnchorV1Route = requireRole(rolePublic, lastAnchorV1Route)
+	statusV2Route = requireRole(rolePublic, statusV2Route)
+	timestampBatchV2Route = requireRole(roleSubmit, timestampBatchV2Route)
+	verifyBatchV2Route = requireRole(rolePublic, verifyBatchV2Route)
+	verifyStreamV2Route = requireRole(rolePublic, verifyStreamV2Route)
+	timestampV2Route = requireRole(roleSubmit, timestampV2Route)
+	verifyV2Route = requireRole(rolePublic, verifyV2Route)
+	walletBalanceV2Route = requireRole(roleAdmin, walletBalanceV2Route)
+	lastAnchorV2Route = requireRole(rolePublic, lastAnchorV2Route)
+	lastDigestsV2Route = requireRole(rolePublic, lastDigestsV2Route)
+	labelV2Route = requireRole(rolePublic, labelV2Route)
+	windowV2Route = requireRole(rolePublic, windowV2Route)
+	anchorsV2Route = requireRole(rolePublic, anchorsV2Route)
+	bloomV2Route = requireRole(rolePublic, bloomV2Route)
+	anchorChainV2Route = requireRole(rolePublic, anchorChainV2Route)
+	identityV2Route = requireRole(rolePublic, identityV2Route)
+	timestampAggregateV2Route = requireRole(roleSubmit,
+		timestampAggregateV2Route)
+	timestampAsyncV2Route = requireRole(roleSubmit, timestampAsyncV2Route)
+	ticketV2Route = requireRole(roleSubmit, ticketV2Route)
+	sessionOpenV2Route = requireRole(roleSubmit, sessionOpenV2Route)
+	sessionAppendV2Route = requireRole(roleSubmit, sessionAppendV2Route)
+	sessionCloseV2Route = requireRole(roleSubmit, sessionCloseV2Route)
+	sessionStatusV2Route = requireRole(roleSubmit, sessionStatusV2Route)
+	webhookSubscribeV2Route = requireRole(roleSubmit,
+		webhookSubscribeV2Route)
+	usageV2Route = requireRole(roleSubmit, usageV2Route)
+	collectionsV2Route = requireRole(roleSubmit, collectionsV2Route)
+	searchV2Route = requireRole(roleSubmit, searchV2Route)
+	collectionRenameV2Route = requireRole(roleSubmit, collectionRenameV2Route)
+	collectionDeleteV2Route = requireRole(roleSubmit, collectionDeleteV2Route)
+	collectionReceiptV2Route = requireRole(roleSubmit,
+		collectionReceiptV2Route)
+	adminStatusV2Route = requireRole(roleAdmin, adminStatusV2Route)
+	tokensV2Route = requireRole(roleAdmin, tokensV2Route)
+	tokenCreateV2Route = requireRole(roleAdmin, tokenCreateV2Route)
+	tokenRevokeV2Route = requireRole(roleAdmin, tokenRevokeV2Route)
+	webhooksV2Route = requireRole(roleAdmin, webhooksV2Route)
+	webhookRetryV2Route = requireRole(roleAdmin, webhookRetryV2Route)
+	webhookDeleteV2Route = requireRole(roleAdmin, webhookDeleteV2Route)
+	proofAuditV2Route = requireRole(roleAdmin, proofAuditV2Route)
+	collectionStatsV2Route = requireRole(roleAdmin, collectionStatsV2Route)
+	exportV2Route = requireRole(roleAdmin, exportV2Route)
+	replicationV2Route = requireRole(roleAdmin, replicationV2Route)
+	maintenanceV2Route = requireRole(roleAdmin, maintenanceV2Route)
 
 	// Top-level route handler
 	d.addRoute(http.MethodGet, v2.VersionRoute, d.version)
+	d.addRoute(http.MethodGet, v2.HealthRoute, d.health)
+	d.addRoute(http.MethodGet, v2.ReadyRoute, d.ready)
+	d.addRoute(http.MethodGet, v2.OpenAPIRoute, d.openAPI)
 
-	versions, _ := parseAndValidateAPIVersions(loadedCfg.APIVersions)
+	versions, _ := parseAndValidateAPIVersions(d.cfg.APIVersions)
 
 	// Add handlers according to supported API versions in cfg
 	for _, v := range versions {
@@ -1591,11 +2713,50 @@ func _main() error {
 			d.addRoute(http.MethodPost, v2.StatusRoute, statusV2Route)
 			d.addRoute(http.MethodPost, v2.TimestampBatchRoute, timestampBatchV2Route)
 			d.addRoute(http.MethodPost, v2.VerifyBatchRoute, verifyBatchV2Route)
+			d.addRoute(http.MethodPost, v2.VerifyStreamRoute, verifyStreamV2Route)
 			d.addRoute(http.MethodGet, v2.WalletBalanceRoute, walletBalanceV2Route)
 			d.addRoute(http.MethodGet, v2.LastAnchorRoute, lastAnchorV2Route)
 			d.addRoute(http.MethodPost, v2.LastDigestsRoute, lastDigestsV2Route)
+			d.addRoute(http.MethodPost, v2.LabelRoute, labelV2Route)
+			d.addRoute(http.MethodPost, v2.WindowRoute, windowV2Route)
+			d.addRoute(http.MethodPost, v2.AnchorsRoute, anchorsV2Route)
+			d.addRoute(http.MethodGet, v2.IdentityRoute, identityV2Route)
+			d.addRoute(http.MethodPost, v2.BloomRoute, bloomV2Route)
+			d.addRoute(http.MethodPost, v2.AnchorChainRoute, anchorChainV2Route)
+			d.addRoute(http.MethodPost, v2.TimestampAggregateRoute, timestampAggregateV2Route)
+			d.addRoute(http.MethodPost, v2.TimestampAsyncRoute, timestampAsyncV2Route)
+			d.addRoute(http.MethodPost, v2.TicketRoute, ticketV2Route)
+			d.addRoute(http.MethodPost, v2.SessionOpenRoute, sessionOpenV2Route)
+			d.addRoute(http.MethodPost, v2.SessionAppendRoute, sessionAppendV2Route)
+			d.addRoute(http.MethodPost, v2.SessionCloseRoute, sessionCloseV2Route)
+			d.addRoute(http.MethodPost, v2.SessionStatusRoute, sessionStatusV2Route)
+			d.addRoute(http.MethodGet, v2.AdminStatusRoute, adminStatusV2Route)
+			d.addRoute(http.MethodGet, v2.TokensRoute, tokensV2Route)
+			d.addRoute(http.MethodPost, v2.TokenCreateRoute, tokenCreateV2Route)
+			d.addRoute(http.MethodPost, v2.TokenRevokeRoute, tokenRevokeV2Route)
+			d.addRoute(http.MethodPost, v2.UsageRoute, usageV2Route)
+			d.addRoute(http.MethodGet, v2.WebhooksRoute, webhooksV2Route)
+			d.addRoute(http.MethodPost, v2.WebhookRetryRoute, webhookRetryV2Route)
+			d.addRoute(http.MethodPost, v2.WebhookDeleteRoute, webhookDeleteV2Route)
+			d.addRoute(http.MethodPost, v2.WebhookSubscribeRoute, webhookSubscribeV2Route)
+			d.addRoute(http.MethodPost, v2.ProofAuditRoute, proofAuditV2Route)
+			d.addRoute(http.MethodPost, v2.CollectionStatsRoute, collectionStatsV2Route)
+			d.addRoute(http.MethodPost, v2.ExportRoute, exportV2Route)
+			d.addRoute(http.MethodPost, v2.ReplicationRoute, replicationV2Route)
+			d.addRoute(http.MethodPost, v2.MaintenanceRoute, maintenanceV2Route)
+			if proxy || d.cfg.EnableCollections {
+				d.addRoute(http.MethodPost, v2.CollectionsRoute, collectionsV2Route)
+				d.addRoute(http.MethodPost, v2.CollectionRenameRoute, collectionRenameV2Route)
+				d.addRoute(http.MethodPost, v2.CollectionDeleteRoute, collectionDeleteV2Route)
+				d.addRoute(http.MethodPost, v2.CollectionReceiptRoute, collectionReceiptV2Route)
+				d.addRoute(http.MethodPost, v2.SearchRoute, searchV2Route)
+			}
 			d.router.HandleFunc(v2.TimestampRoute, timestampV2Route).Methods(http.MethodPost, http.MethodGet)
 			d.router.HandleFunc(v2.VerifyRoute, verifyV2Route).Methods(http.MethodPost, http.MethodGet)
+			if proxy {
+				d.addRoute(http.MethodGet, v2.ProxyStatsRoute,
+					requireRole(roleAdmin, d.proxyStats))
+			}
 		}
 	}
 
@@ -1603,30 +2764,303 @@ func _main() error {
 	if trimmed := strings.TrimSuffix(v1.StatusRoute, "/"); trimmed != v1.StatusRoute {
 		d.addRoute("get", trimmed, statusV1Route)
 	}
+}
+
+// handler returns the handler of all requests, which wraps the router with
+// the CORS, client address and request logging middleware.
+func (d *DcrtimeStore) handler() http.Handler {
+	// CORS options
+	origins := handlers.AllowedOrigins([]string{"*"})
+	methods := handlers.AllowedMethods([]string{http.MethodGet, http.MethodOptions, http.MethodPost})
+	headers := handlers.AllowedHeaders([]string{"Content-Type",
+		requestIDHeader, v2.IdempotencyKeyHeader})
+	exposed := handlers.ExposedHeaders([]string{requestIDHeader,
+		v2.SignatureHeader})
+	return d.trustProxies(logRequests(d.requireIP(ipClassAll,
+		handlers.CORS(origins, methods, headers,
+			exposed)(d.router).ServeHTTP)))
+}
+
+func _main() error {
+	// Load configuration and parse command line.  This function also
+	// initializes logging and configures it accordingly.
+	loadedCfg, args, err := loadConfig()
+	if err != nil {
+		if checkConfigMode {
+			r := configReport{Version: version()}
+			r.add("config", "", "", err)
+			r.print()
+		}
+		return fmt.Errorf("could not load configuration file: %v", err)
+	}
+	defer func() {
+		if logRotator != nil {
+			logRotator.Close()
+		}
+	}()
+
+	// Report on the configuration and exit.
+	if loadedCfg.CheckConfig {
+		r := checkConfig(loadedCfg)
+		r.print()
+		if !r.Valid {
+			return fmt.Errorf("invalid configuration")
+		}
+		return nil
+	}
+
+	// Run commands against the running instance and exit.
+	if len(args) != 0 {
+		switch args[0] {
+		case "exportidentity":
+			if loadedCfg.StoreHost != "" {
+				return fmt.Errorf("exportidentity must be run " +
+					"on the storehost")
+			}
+			var filename string
+			if len(args) > 1 {
+				filename = args[1]
+			}
+			return exportIdentity(loadedCfg, filename)
+		case "rotateidentity":
+			if loadedCfg.StoreHost != "" {
+				return fmt.Errorf("rotateidentity must be run " +
+					"on the storehost")
+			}
+			overlap := defaultIdentityOverlap
+			if len(args) > 1 {
+				overlap, err = time.ParseDuration(args[1])
+				if err != nil {
+					return fmt.Errorf("invalid overlap: %v",
+						err)
+				}
+			}
+			return rotateIdentity(loadedCfg, overlap)
+		case "selftest":
+			if !hasAPIVersion(loadedCfg, v2.APIVersion) {
+				return fmt.Errorf("selftest requires api version %v",
+					v2.APIVersion)
+			}
+			if loadedCfg.SelfTestURL == "" {
+				return fmt.Errorf("selftest requires selftesturl " +
+					"when only unix sockets are listened on")
+			}
+			d := newDcrtimeStore(loadedCfg, nil)
+			log.Infof("Self testing %v", loadedCfg.SelfTestURL)
+			if err := d.runSelfTest(); err != nil {
+				return err
+			}
+			log.Infof("Self test passed")
+			return nil
+		default:
+			return fmt.Errorf("unknown command: %v", args[0])
+		}
+	}
+
+	var proxy bool
+	mode := "Store"
+	if loadedCfg.StoreHost != "" {
+		proxy = true
+		mode = "Proxy"
+	}
+	if loadedCfg.ReadOnly {
+		mode += " (read-only)"
+	}
+	if loadedCfg.Maintenance {
+		mode += " (maintenance)"
+	}
+	log.Infof("Version : %v", version())
+	log.Infof("Mode    : %v", mode)
+	log.Infof("Network : %v", activeNetParams.Name)
+	log.Infof("Home dir: %v", loadedCfg.HomeDir)
+
+	// Sets subsystem loggers
+	dcrtimewallet.UseLogger(walletLog)
+
+	// Create the data directory in case it does not exist.
+	err = os.MkdirAll(loadedCfg.DataDir, 0700)
+	if err != nil {
+		return err
+	}
+
+	// Generate the TLS cert and key file if both don't already
+	// exist.
+	if len(loadedCfg.ACMEDomains) == 0 &&
+		!fileExists(loadedCfg.HTTPSKey) &&
+		!fileExists(loadedCfg.HTTPSCert) {
+		log.Infof("Generating HTTPS keypair...")
+
+		err := util.GenCertPair("dcrtimed", loadedCfg.HTTPSCert,
+			loadedCfg.HTTPSKey)
+		if err != nil {
+			return fmt.Errorf("unable to create https keypair: %v",
+				err)
+		}
+
+		log.Infof("HTTPS keypair created...")
+	}
+
+	// Setup application context
+	d := newDcrtimeStore(loadedCfg, nil)
+
+	if proxy {
+		u, err := newUpstream(loadedCfg.StoreHost, loadedCfg.StoreCert,
+			loadedCfg.StoreTimeout, false)
+		if err != nil {
+			return err
+		}
+		d.upstreams = append(d.upstreams, u)
+		log.Infof("Storehost: %v (timeout %v)", u.host,
+			loadedCfg.StoreTimeout)
+
+		if loadedCfg.StoreFailoverHost != "" {
+			u, err := newUpstream(loadedCfg.StoreFailoverHost,
+				loadedCfg.StoreFailoverCert,
+				loadedCfg.StoreTimeout, true)
+			if err != nil {
+				return err
+			}
+			d.upstreams = append(d.upstreams, u)
+			log.Infof("Failover storehost: %v", u.host)
+		}
+
+		for k, host := range loadedCfg.StoreFanoutHosts {
+			u, err := newUpstream(host, loadedCfg.StoreFanoutCerts[k],
+				loadedCfg.StoreTimeout, false)
+			if err != nil {
+				return err
+			}
+			u.fanout = true
+			d.fanouts = append(d.fanouts, u)
+			log.Infof("Fan-out storehost: %v", u.host)
+		}
+		if len(d.fanouts) != 0 {
+			log.Infof("Storehost quorum: %v of %v",
+				loadedCfg.StoreQuorum, len(d.fanouts)+1)
+		}
+	} else {
+		// Setup backend.
+		wallet, err := newWallet(loadedCfg)
+		if err != nil {
+			return err
+		}
+
+		if err := d.openBackend(wallet); err != nil {
+			return err
+		}
+	}
+
+	d.setupRoutes()
 
 	// Pretty print web page for individual digest/timestamp
 	// d.router.HandleFunc(v1.TimestampRoute+"{id:[0-9a-zA-Z]+}",
 	//	d.getTimestamp).Methods(http.MethodGet)
 
+	// Load the https certificate, it is reloaded when it changes if
+	// httpsreload is set or obtained through acme.
+	tlsCfg, reloader, err := tlsConfig(loadedCfg)
+	if err != nil {
+		return fmt.Errorf("unable to load https certificate: %v", err)
+	}
+	certFile, keyFile := loadedCfg.HTTPSCert, loadedCfg.HTTPSKey
+	switch {
+	case len(loadedCfg.ACMEDomains) != 0:
+		certFile, keyFile = "", ""
+		d.enableACME(tlsCfg)
+	case reloader != nil:
+		certFile, keyFile = "", ""
+		go d.certWatcher(reloader, loadedCfg.HTTPSReload)
+	}
+
 	// Bind to a port and pass our router in
-	listenC := make(chan error)
-	for _, listener := range loadedCfg.Listeners {
-		listen := listener
-		go func() {
-			// CORS options
-			origins := handlers.AllowedOrigins([]string{"*"})
-			methods := handlers.AllowedMethods([]string{http.MethodGet, http.MethodOptions, http.MethodPost})
-			headers := handlers.AllowedHeaders([]string{"Content-Type"})
+	handler := d.handler()
 
+	socketMode, _ := parseUnixSocketMode(loadedCfg.UnixSocketMode)
+	listenC := make(chan error)
+	for _, l := range listeners(loadedCfg) {
+		listen := l.addr
+		h := withListener(l, handler)
+		if len(l.roles) == 0 {
 			log.Infof("Listen: %v", listen)
-			listenC <- http.ListenAndServeTLS(listen,
-				loadedCfg.HTTPSCert, loadedCfg.HTTPSKey,
-				handlers.CORS(origins, methods, headers)(d.router))
+		} else {
+			log.Infof("Listen: %v (%v)", listen,
+				strings.Join(l.roles, ", "))
+		}
+		go func() {
+			if strings.HasPrefix(listen, unixListenerPrefix) {
+				// Local clients talk plain http over unix
+				// sockets.
+				listenC <- listenUnix(strings.TrimPrefix(listen,
+					unixListenerPrefix), socketMode, h)
+				return
+			}
+			srv := &http.Server{
+				Addr:      listen,
+				TLSConfig: tlsCfg.Clone(),
+				Handler:   h,
+			}
+			listenC <- srv.ListenAndServeTLS(certFile, keyFile)
 		}()
 	}
 
+	// Check the health of the storehosts in proxy mode.
+	if d.backend == nil && loadedCfg.StoreHealthInterval > 0 {
+		go d.healthChecker()
+	}
+
+	// Opt-in announcements to the public instance directory.
+	if loadedCfg.AnnounceURL != "" {
+		go d.announcer()
+	}
+
+	// Consume timestamp requests from the ingest queue.  Requests remain
+	// queued while the server is read-only.
+	if loadedCfg.IngestURL != "" && !loadedCfg.ReadOnly {
+		go d.ingester()
+	}
+
+	// Timestamp the digests of asynchronous submissions.  They remain
+	// queued while the server is read-only.
+	if d.backend != nil && !loadedCfg.ReadOnly {
+		go d.ticketer()
+	}
+
+	// Deliver anchor webhooks.
+	if len(loadedCfg.WebhookURLs) != 0 || loadedCfg.WebhookSubscriptions {
+		go d.webhooker()
+	}
+
+	// Continuously self test the timestamping pipeline, which can not
+	// timestamp while the server is read-only.
+	if loadedCfg.SelfTestInterval != 0 && !loadedCfg.ReadOnly {
+		go d.selfTester()
+	}
+
+	// Keep the backend of a standby in sync with the primary.
+	if d.primary != nil {
+		go d.replicator()
+	}
+
+	// Continuously audit anchored digests.
+	if loadedCfg.SelfAuditInterval != 0 {
+		go d.selfAuditor()
+	}
+
+	// Alert operators when the storehost needs attention.
+	if d.backend != nil && len(d.notifiers) != 0 {
+		go d.alerter()
+	}
+
+	// Drop cached proofs that a reorganization may have invalidated.
+	if d.verifyCache != nil {
+		go d.verifyCacheWatcher()
+	}
+
 	// Tell user we are ready to go.
 	log.Infof("Start of day")
+	if loadedCfg.Systemd {
+		d.systemdReady()
+	}
 
 	// Setup OS signals
 	sigs := make(chan os.Signal, 1)
@@ -1642,6 +3076,9 @@ func _main() error {
 		}
 	}
 done:
+	if loadedCfg.Systemd {
+		systemdStopping()
+	}
 	if !proxy {
 		d.backend.Close()
 	}
@@ -0,0 +1,345 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	v1 "github.com/decred/dcrtime/api/v1"
+	v2 "github.com/decred/dcrtime/api/v2"
+	"github.com/decred/dcrtime/dcrtimed/backend"
+	"github.com/decred/dcrtime/dcrtimed/backend/backendtest"
+	"github.com/decred/dcrtime/dcrtimed/backend/testsuite"
+	"github.com/decred/dcrtime/merkle"
+)
+
+// testAdminToken is the admin api token of the test configurations.
+const testAdminToken = "admintoken"
+
+func init() {
+	// The in-memory backend is selected with backend=backendtest in
+	// tests that open the backend like dcrtimed does.
+	backend.Register("backendtest", func(cfg backend.Config) (backend.Backend, error) {
+		return backendtest.New(cfg.Wallet, cfg.Confirmations), nil
+	})
+}
+
+func TestMain(m *testing.M) {
+	// The log rotator is not initialized in tests.
+	setLogLevels("off")
+	os.Exit(m.Run())
+}
+
+// testConfig returns the configuration of a storehost that uses the in-memory
+// backend and keeps its keys in a temporary directory.
+func testConfig(t *testing.T) *config {
+	return &config{
+		DataDir:         filepath.Join(t.TempDir(), "data"),
+		Backend:         "backendtest",
+		APIVersions:     defaultAPIVersions,
+		APITokens:       []string{testAdminToken},
+		Confirmations:   1,
+		MaxDigests:      int32(defaultMaxDigests),
+		TicketExpiry:    defaultTicketExpiry,
+		SessionTimeout:  defaultSessionTimeout,
+		ConfirmWorkers:  defaultConfirmWorkers,
+		CoalesceDigests: defaultCoalesceDigests,
+	}
+}
+
+// testStore is a storehost served through httptest.
+type testStore struct {
+	*DcrtimeStore
+	b      *backendtest.Backend
+	wallet *testsuite.Wallet
+	srv    *httptest.Server
+}
+
+// newTestStore serves the handlers of the provided configuration on top of
+// the in-memory backend.
+func newTestStore(t *testing.T, cfg *config) *testStore {
+	w := testsuite.NewWallet()
+	b := backendtest.New(w, cfg.Confirmations)
+	d := newDcrtimeStore(cfg, b)
+	d.identityKeys = []identityKey{{
+		key: ed25519.NewKeyFromSeed(bytes.Repeat([]byte{1},
+			ed25519.SeedSize)),
+	}}
+	d.accessSecret = bytes.Repeat([]byte{2}, accessSecretSize)
+	d.setupRoutes()
+
+	srv := httptest.NewServer(d.handler())
+	t.Cleanup(srv.Close)
+	return &testStore{
+		DcrtimeStore: d,
+		b:            b,
+		wallet:       w,
+		srv:          srv,
+	}
+}
+
+// do sends the JSON encoded request to the route, decodes the reply into the
+// provided reply when the request succeeded and returns the status code and
+// the reply.
+func (s *testStore) do(t *testing.T, method, route string, request, reply interface{}) (int, *http.Response, []byte) {
+	t.Helper()
+
+	var body []byte
+	if request != nil {
+		var err error
+		body, err = json.Marshal(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	req, err := http.NewRequest(method, s.srv.URL+route,
+		bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := s.srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var b bytes.Buffer
+	if _, err := b.ReadFrom(resp.Body); err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode == http.StatusOK && reply != nil {
+		if err := json.Unmarshal(b.Bytes(), reply); err != nil {
+			t.Fatalf("%v: %v", route, err)
+		}
+	}
+	return resp.StatusCode, resp, b.Bytes()
+}
+
+// post sends a POST request and returns the status code.
+func (s *testStore) post(t *testing.T, route string, request, reply interface{}) int {
+	t.Helper()
+
+	code, _, _ := s.do(t, http.MethodPost, route, request, reply)
+	return code
+}
+
+// testDigests returns n distinct hex encoded digests that are derived from
+// seed.
+func testDigests(seed string, n int) []string {
+	digests := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		d := sha256.Sum256([]byte(seed + string(rune('a'+i))))
+		digests = append(digests, hex.EncodeToString(d[:]))
+	}
+	return digests
+}
+
+// timestamp timestamps the digests and requires all of them to be accepted.
+func (s *testStore) timestamp(t *testing.T, route string, digests []string) v2.TimestampBatchReply {
+	t.Helper()
+
+	var tr v2.TimestampBatchReply
+	code := s.post(t, route, v2.TimestampBatch{
+		ID:      "test",
+		Digests: digests,
+	}, &tr)
+	if code != http.StatusOK {
+		t.Fatalf("timestamp: got status %v", code)
+	}
+	for i, result := range tr.Results {
+		if result != v2.ResultOK {
+			t.Fatalf("timestamp %v: got result %v", digests[i],
+				result)
+		}
+	}
+	return tr
+}
+
+// verify returns the verify results of the digests.
+func (s *testStore) verify(t *testing.T, route string, digests []string) []v2.VerifyDigest {
+	t.Helper()
+
+	var vr v2.VerifyBatchReply
+	code := s.post(t, route, v2.VerifyBatch{
+		ID:      "test",
+		Digests: digests,
+	}, &vr)
+	if code != http.StatusOK {
+		t.Fatalf("verify: got status %v", code)
+	}
+	if len(vr.Digests) != len(digests) {
+		t.Fatalf("verify: got %v digests, want %v", len(vr.Digests),
+			len(digests))
+	}
+	return vr.Digests
+}
+
+func TestTimestampVerify(t *testing.T) {
+	s := newTestStore(t, testConfig(t))
+
+	digests := testDigests("verify", 3)
+	tr := s.timestamp(t, v2.TimestampBatchRoute, digests)
+	if tr.ServerTimestamp != s.b.Now() {
+		t.Fatalf("got collection %v, want %v", tr.ServerTimestamp,
+			s.b.Now())
+	}
+
+	// Duplicates are reported as such.
+	var dr v2.TimestampBatchReply
+	s.post(t, v2.TimestampBatchRoute, v2.TimestampBatch{
+		Digests: digests[:1],
+	}, &dr)
+	if len(dr.Results) != 1 || dr.Results[0] != v2.ResultExistsError {
+		t.Fatalf("duplicate: got results %v", dr.Results)
+	}
+
+	// Pending digests are known but not anchored.
+	unknown := testDigests("unknown", 1)
+	vds := s.verify(t, v2.VerifyBatchRoute, append(digests[:1:1],
+		unknown...))
+	if vds[0].Result != v2.ResultOK ||
+		vds[0].ServerTimestamp != tr.ServerTimestamp ||
+		vds[0].ChainInformation.ChainTimestamp != 0 {
+		t.Fatalf("pending: %+v", vds[0])
+	}
+	if vds[1].Result != v2.ResultDoesntExistError {
+		t.Fatalf("unknown: %+v", vds[1])
+	}
+
+	if err := s.b.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	s.wallet.SetConfirmations(1)
+
+	tx := s.b.Tx(tr.ServerTimestamp)
+	for _, vd := range s.verify(t, v2.VerifyBatchRoute, digests) {
+		ci := vd.ChainInformation
+		if vd.Result != v2.ResultOK || ci.ChainTimestamp == 0 {
+			t.Fatalf("anchored: %+v", vd)
+		}
+		if ci.Transaction != tx.String() {
+			t.Fatalf("got tx %v, want %v", ci.Transaction, tx)
+		}
+		root, err := merkle.VerifyAuthPath(&merkle.Branch{
+			NumLeaves: ci.MerklePath.NumLeaves,
+			Hashes:    ci.MerklePath.Hashes,
+			Flags:     ci.MerklePath.Flags,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hex.EncodeToString(root[:]) != ci.MerkleRoot {
+			t.Fatalf("got merkle root %x, want %v", root[:],
+				ci.MerkleRoot)
+		}
+	}
+}
+
+func TestTimestampVerifyV1(t *testing.T) {
+	s := newTestStore(t, testConfig(t))
+
+	digests := testDigests("v1", 2)
+	var tr v1.TimestampReply
+	code := s.post(t, v1.TimestampRoute, v1.Timestamp{
+		ID:      "test",
+		Digests: digests,
+	}, &tr)
+	if code != http.StatusOK {
+		t.Fatalf("timestamp: got status %v", code)
+	}
+	for _, result := range tr.Results {
+		if result != v1.ResultOK {
+			t.Fatalf("timestamp: got results %v", tr.Results)
+		}
+	}
+	if err := s.b.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	s.wallet.SetConfirmations(1)
+
+	// Digests timestamped through v1 are verified through v2 as well,
+	// both versions share the backend.
+	var vr v1.VerifyReply
+	code = s.post(t, v1.VerifyRoute, v1.Verify{
+		ID:      "test",
+		Digests: digests,
+	}, &vr)
+	if code != http.StatusOK {
+		t.Fatalf("verify: got status %v", code)
+	}
+	for _, vd := range vr.Digests {
+		if vd.Result != v1.ResultOK ||
+			vd.ChainInformation.ChainTimestamp == 0 {
+			t.Fatalf("v1 verify: %+v", vd)
+		}
+	}
+	for _, vd := range s.verify(t, v2.VerifyBatchRoute, digests) {
+		if vd.ChainInformation.Transaction !=
+			s.b.Tx(tr.ServerTimestamp).String() {
+			t.Fatalf("v2 verify: %+v", vd)
+		}
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.ReadOnly = true
+	s := newTestStore(t, cfg)
+
+	code := s.post(t, v2.TimestampBatchRoute, v2.TimestampBatch{
+		Digests: testDigests("readonly", 1),
+	}, nil)
+	if code != http.StatusServiceUnavailable {
+		t.Fatalf("got status %v, want %v", code,
+			http.StatusServiceUnavailable)
+	}
+}
+
+func TestOpenBackend(t *testing.T) {
+	cfg := testConfig(t)
+	d := newDcrtimeStore(cfg, nil)
+	w := testsuite.NewWallet()
+	if err := d.openBackend(w); err != nil {
+		t.Fatal(err)
+	}
+	defer d.backend.Close()
+
+	if _, ok := d.backend.(*backendtest.Backend); !ok {
+		t.Fatalf("got backend %T", d.backend)
+	}
+	if _, err := os.Stat(cfg.DataDir + identityKeySuffix); err != nil {
+		t.Fatalf("identity key: %v", err)
+	}
+
+	// The backend anchors and reports the balance through the injected
+	// wallet.
+	d.setupRoutes()
+	srv := httptest.NewServer(d.handler())
+	defer srv.Close()
+
+	resp, err := srv.Client().Get(srv.URL + v2.WalletBalanceRoute +
+		"?apitoken=" + testAdminToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var br v2.WalletBalanceReply
+	if err := json.NewDecoder(resp.Body).Decode(&br); err != nil {
+		t.Fatal(err)
+	}
+	if br.Total != testsuite.Balance.Total {
+		t.Fatalf("got balance %v, want %v", br.Total,
+			testsuite.Balance.Total)
+	}
+}
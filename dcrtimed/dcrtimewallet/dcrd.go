@@ -0,0 +1,600 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package dcrtimewallet
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/decred/dcrd/chaincfg/chainhash"
+	"github.com/decred/dcrd/chaincfg/v3"
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+	"github.com/decred/dcrd/dcrec/secp256k1/v4/ecdsa"
+	"github.com/decred/dcrd/dcrutil/v4"
+	"github.com/decred/dcrd/txscript/v4"
+	"github.com/decred/dcrd/txscript/v4/stdaddr"
+	"github.com/decred/dcrd/wire"
+)
+
+const (
+	// errRPCNoTxInfo is the dcrd JSON-RPC error code that is returned
+	// when a transaction is unknown.
+	errRPCNoTxInfo = -5
+
+	// redeemP2PKHSigScriptSize is the worst case size of a signature
+	// script that redeems a compressed P2PKH output: OP_DATA_73 <sig>
+	// OP_DATA_33 <pubkey>.
+	redeemP2PKHSigScriptSize = 1 + 73 + 1 + 33
+
+	// relayFeeRate is the minimum fee rate in atoms/kB dcrd relays
+	// transactions at by default.  It determines the dust limit of the
+	// change output.
+	relayFeeRate = 1e4
+)
+
+var (
+	_ Wallet = (*DcrdWallet)(nil)
+
+	// ErrInsufficientFunds is returned when the anchor output can't pay
+	// the fee of the next anchor transaction.
+	ErrInsufficientFunds = errors.New("insufficient funds")
+)
+
+// anchorUTXO is the output that funds the next anchor transaction.  It is
+// persisted so that anchoring continues where it left off after a restart.
+type anchorUTXO struct {
+	Hash   string `json:"hash"`   // Transaction hash
+	Index  uint32 `json:"index"`  // Output index
+	Amount int64  `json:"amount"` // Output value in atoms
+}
+
+// dcrdRPCError is an error returned by the dcrd JSON-RPC server.
+type dcrdRPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// Error satisfies the error interface.
+func (e *dcrdRPCError) Error() string {
+	return fmt.Sprintf("%v: %v", e.Code, e.Message)
+}
+
+// dcrdClient is a minimal dcrd JSON-RPC client.
+type dcrdClient struct {
+	url  string
+	user string
+	pass string
+	id   uint64
+	http *http.Client
+}
+
+// call executes the JSON-RPC method and decodes its result into result.
+func (c *dcrdClient) call(method string, result interface{}, params ...interface{}) error {
+	if params == nil {
+		params = []interface{}{}
+	}
+	b, err := json.Marshal(struct {
+		JSONRPC string        `json:"jsonrpc"`
+		ID      uint64        `json:"id"`
+		Method  string        `json:"method"`
+		Params  []interface{} `json:"params"`
+	}{
+		JSONRPC: "1.0",
+		ID:      atomic.AddUint64(&c.id, 1),
+		Method:  method,
+		Params:  params,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, c.url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(c.user, c.pass)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		return fmt.Errorf("%v: invalid dcrd credentials", method)
+	}
+
+	var reply struct {
+		Result json.RawMessage `json:"result"`
+		Error  *dcrdRPCError   `json:"error"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&reply)
+	if err != nil {
+		return fmt.Errorf("%v: %v %v", method, resp.Status, err)
+	}
+	if reply.Error != nil {
+		return reply.Error
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(reply.Result, result)
+}
+
+// DcrdWallet anchors merkle roots without dcrwallet.  It signs the anchor
+// transactions with a single secp256k1 key and publishes them through the
+// dcrd JSON-RPC server.  Every anchor spends the change output of the
+// previous anchor, the first one spends a configured funding outpoint that
+// pays to the P2PKH address of the key.
+type DcrdWallet struct {
+	sync.Mutex
+
+	dcrd      *dcrdClient
+	privKey   *secp256k1.PrivateKey
+	pkScript  []byte     // P2PKH script of the anchor key
+	fees      FeePolicy  // Fee of anchors
+	stateFile string     // Path of the persisted anchor UTXO
+	utxo      anchorUTXO // Output that funds the next anchor
+	unsaved   bool       // Anchor UTXO was not persisted
+}
+
+// loadUTXO reads the persisted anchor UTXO.
+func (d *DcrdWallet) loadUTXO() error {
+	b, err := os.ReadFile(d.stateFile)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, &d.utxo)
+}
+
+// saveUTXO atomically persists the anchor UTXO.
+func (d *DcrdWallet) saveUTXO() error {
+	b, err := json.Marshal(d.utxo)
+	if err != nil {
+		return err
+	}
+	tmp := d.stateFile + ".tmp"
+	err = os.WriteFile(tmp, b, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, d.stateFile)
+}
+
+// isDust returns true if an output with the provided value and script is too
+// small to be relayed by dcrd, i.e. spending it costs more than a third of its
+// value at the relay fee rate.
+func isDust(value int64, pkScript []byte) bool {
+	// Size of the output plus the input that redeems it: previous
+	// outpoint, tree, sequence, value in, block height, block index and
+	// the signature script.
+	size := int64(wire.NewTxOut(value, pkScript).SerializeSize() +
+		32 + 4 + 1 + 4 + 8 + 4 + 4 + 1 + redeemP2PKHSigScriptSize)
+	return value*1000/(3*size) < relayFeeRate
+}
+
+// txOut is the subset of the dcrd gettxout reply that is used.
+type txOut struct {
+	Confirmations int64   `json:"confirmations"`
+	Value         float64 `json:"value"`
+	ScriptPubKey  struct {
+		Hex string `json:"hex"`
+	} `json:"scriptPubKey"`
+}
+
+// getTxOut returns the unspent output or nil if it is spent or unknown.
+func (d *DcrdWallet) getTxOut(hash string, index uint32) (*txOut, error) {
+	var out *txOut
+	err := d.dcrd.call("gettxout", &out, hash, index, wire.TxTreeRegular,
+		true)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// feeRate returns the fee rate in atoms/kB of the next anchor.  The configured
+// fee rate is used when dcrd has no estimate.
+func (d *DcrdWallet) feeRate() int64 {
+	if d.fees.Mode != FeeModeEstimate {
+		return d.fees.FeeRate
+	}
+
+	var r struct {
+		FeeRate float64 `json:"feerate"` // DCR/kB
+	}
+	err := d.dcrd.call("estimatesmartfee", &r, estimateConfirmations,
+		"conservative")
+	if err != nil {
+		log.Warnf("Fee estimate unavailable, using %v/kB: %v",
+			dcrutil.Amount(d.fees.FeeRate), err)
+		return d.fees.FeeRate
+	}
+	rate, err := dcrutil.NewAmount(r.FeeRate)
+	if err != nil || rate <= 0 {
+		log.Warnf("Invalid fee estimate %v, using %v/kB", r.FeeRate,
+			dcrutil.Amount(d.fees.FeeRate))
+		return d.fees.FeeRate
+	}
+	return int64(rate)
+}
+
+// Lookup looks up the provided TX hash and returns a Result structure.  dcrd
+// must run with a transaction index in order to find mined anchors.
+func (d *DcrdWallet) Lookup(tx chainhash.Hash) (*TxLookupResult, error) {
+	var r struct {
+		Confirmations int64  `json:"confirmations"`
+		BlockHash     string `json:"blockhash"`
+		BlockHeight   int64  `json:"blockheight"`
+		BlockTime     int64  `json:"blocktime"`
+	}
+	err := d.dcrd.call("getrawtransaction", &r, tx.String(), 1)
+	if err != nil {
+		var e *dcrdRPCError
+		if errors.As(err, &e) && e.Code == errRPCNoTxInfo {
+			return &TxLookupResult{Confirmations: -1}, nil
+		}
+		return nil, err
+	}
+
+	// Abort early if we don't have enough confirmations.
+	if r.Confirmations <= 0 {
+		return &TxLookupResult{}, nil
+	}
+
+	block, err := chainhash.NewHashFromStr(r.BlockHash)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TxLookupResult{
+		BlockHash:     *block,
+		Timestamp:     r.BlockTime,
+		Confirmations: int32(r.Confirmations),
+		BlockHeight:   int32(r.BlockHeight),
+	}, nil
+}
+
+// Construct creates, signs and publishes an anchor tx with the provided
+// merkle root and prefix.  The change is paid back to the anchor key and funds
+// the next anchor.
+func (d *DcrdWallet) Construct(merkleRoot [sha256.Size]byte, prefix []byte) (*chainhash.Hash, error) {
+	d.Lock()
+	defer d.Unlock()
+
+	return d.construct(merkleRoot, prefix, d.feeRate())
+}
+
+// Replace publishes a new anchor tx for the merkle root of an anchor that was
+// not mined in time.  It pays the fee rate multiplied by bump.  Like every
+// anchor it spends the change of the latest anchor, when that is the stuck
+// anchor the higher fee makes it worthwhile for miners to include both.
+func (d *DcrdWallet) Replace(merkleRoot [sha256.Size]byte, prefix []byte, bump int64) (*chainhash.Hash, error) {
+	d.Lock()
+	defer d.Unlock()
+
+	return d.construct(merkleRoot, prefix, d.feeRate()*bump)
+}
+
+// construct creates, signs and publishes an anchor tx that pays the provided
+// fee rate in atoms/kB.
+//
+// This function must be called with the lock held.
+func (d *DcrdWallet) construct(merkleRoot [sha256.Size]byte, prefix []byte, feeRate int64) (*chainhash.Hash, error) {
+	// Never spend the anchor UTXO before it was persisted, a restart
+	// would otherwise spend a stale one.
+	if d.unsaved {
+		err := d.saveUTXO()
+		if err != nil {
+			return nil, fmt.Errorf("unable to save anchor utxo: %w", err)
+		}
+		d.unsaved = false
+	}
+
+	// Generate script that contains OP_RETURN followed by the prefix and
+	// the merkle root.
+	script, err := AnchorScript(merkleRoot, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	prevHash, err := chainhash.NewHashFromStr(d.utxo.Hash)
+	if err != nil {
+		return nil, err
+	}
+	tx := wire.NewMsgTx()
+	tx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(prevHash, d.utxo.Index,
+		wire.TxTreeRegular), d.utxo.Amount, nil))
+	tx.AddTxOut(wire.NewTxOut(0, script))
+	change := wire.NewTxOut(0, d.pkScript)
+	tx.AddTxOut(change)
+
+	// Pay the fee out of the change.  Anchors that are too expensive are
+	// refused so that they are retried on the next flush.
+	size := int64(tx.SerializeSize() + redeemP2PKHSigScriptSize)
+	fee := size * feeRate / 1000
+	if err := d.fees.checkFee(fee); err != nil {
+		return nil, err
+	}
+	change.Value = d.utxo.Amount - fee
+	if change.Value <= fee || isDust(change.Value, d.pkScript) {
+		return nil, fmt.Errorf("%w: %v available", ErrInsufficientFunds,
+			dcrutil.Amount(d.utxo.Amount))
+	}
+
+	// Sign the only input.
+	sigHash, err := txscript.CalcSignatureHash(d.pkScript,
+		txscript.SigHashAll, tx, 0, nil)
+	if err != nil {
+		return nil, err
+	}
+	sig := ecdsa.Sign(d.privKey, sigHash).Serialize()
+	sigScript, err := txscript.NewScriptBuilder().
+		AddData(append(sig, byte(txscript.SigHashAll))).
+		AddData(d.privKey.PubKey().SerializeCompressed()).Script()
+	if err != nil {
+		return nil, err
+	}
+	tx.TxIn[0].SignatureScript = sigScript
+
+	// Publish transaction.
+	var buf bytes.Buffer
+	buf.Grow(tx.SerializeSize())
+	err = tx.Serialize(&buf)
+	if err != nil {
+		return nil, err
+	}
+	var txid string
+	err = d.dcrd.call("sendrawtransaction", &txid,
+		hex.EncodeToString(buf.Bytes()))
+	if err != nil {
+		return nil, err
+	}
+	txHash := tx.TxHash()
+	if txid != txHash.String() {
+		return nil, fmt.Errorf("invalid tx hash: %v", txid)
+	}
+
+	// The change funds the next anchor.
+	d.utxo = anchorUTXO{
+		Hash:   txHash.String(),
+		Index:  1,
+		Amount: change.Value,
+	}
+	err = d.saveUTXO()
+	if err != nil {
+		// The anchor was published so the caller must record it.
+		// Anchoring stops until the anchor UTXO is persisted.
+		log.Errorf("Unable to save anchor utxo %v:%v: %v",
+			d.utxo.Hash, d.utxo.Index, err)
+		d.unsaved = true
+	}
+
+	return &txHash, nil
+}
+
+// GetWalletBalance returns the value of the output that funds the next
+// anchor.  It is unconfirmed until the previous anchor is mined.
+func (d *DcrdWallet) GetWalletBalance() (*BalanceResult, error) {
+	d.Lock()
+	utxo := d.utxo
+	d.Unlock()
+
+	out, err := d.getTxOut(utxo.Hash, utxo.Index)
+	if err != nil {
+		return nil, err
+	}
+	if out == nil {
+		return nil, fmt.Errorf("anchor utxo %v:%v is spent",
+			utxo.Hash, utxo.Index)
+	}
+
+	balance := &BalanceResult{
+		Total: utxo.Amount,
+	}
+	if out.Confirmations > 0 {
+		balance.Spendable = utxo.Amount
+	} else {
+		balance.Unconfirmed = utxo.Amount
+	}
+	return balance, nil
+}
+
+// BestHeight returns the height of the best block known to dcrd.
+func (d *DcrdWallet) BestHeight() (int32, error) {
+	var height int64
+	err := d.dcrd.call("getblockcount", &height)
+	if err != nil {
+		return 0, err
+	}
+	return int32(height), nil
+}
+
+// NotifyBlocks satisfies the Wallet interface.  dcrd only notifies blocks over
+// websockets, which are not used, so ErrNoNotifications is returned.
+func (d *DcrdWallet) NotifyBlocks(ctx context.Context, f func(int32)) error {
+	return ErrNoNotifications
+}
+
+// Block returns the block with the provided hash.
+func (d *DcrdWallet) Block(hash chainhash.Hash) (*wire.MsgBlock, error) {
+	var b string
+	err := d.dcrd.call("getblock", &b, hash.String(), false)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := hex.DecodeString(b)
+	if err != nil {
+		return nil, err
+	}
+	var block wire.MsgBlock
+	if err := block.FromBytes(raw); err != nil {
+		return nil, err
+	}
+	return &block, nil
+}
+
+// Headers returns the headers of the main chain blocks that follow the block
+// with the provided hash up to and including the provided height.  dcrd
+// returns at most 2000 headers per call.
+func (d *DcrdWallet) Headers(hash chainhash.Hash, height int32) ([]wire.BlockHeader, error) {
+	var stop string
+	err := d.dcrd.call("getblockhash", &stop, int64(height))
+	if err != nil {
+		return nil, err
+	}
+
+	var headers []wire.BlockHeader
+	locator := hash.String()
+	for locator != stop {
+		var r struct {
+			Headers []string `json:"headers"`
+		}
+		err := d.dcrd.call("getheaders", &r, []string{locator}, stop)
+		if err != nil {
+			return nil, err
+		}
+		if len(r.Headers) == 0 {
+			return nil, fmt.Errorf("block %v is not an ancestor of "+
+				"block %v", hash, stop)
+		}
+		for _, h := range r.Headers {
+			raw, err := hex.DecodeString(h)
+			if err != nil {
+				return nil, err
+			}
+			var header wire.BlockHeader
+			if err := header.FromBytes(raw); err != nil {
+				return nil, err
+			}
+			headers = append(headers, header)
+		}
+		locator = headers[len(headers)-1].BlockHash().String()
+	}
+
+	return headers, nil
+}
+
+// Close satisfies the Wallet interface.  There are no persistent connections
+// to dcrd.
+func (d *DcrdWallet) Close() {
+	d.dcrd.http.CloseIdleConnections()
+}
+
+// NewDcrd returns a DcrdWallet that publishes anchors through the dcrd
+// JSON-RPC server at host.  The WIF encoded anchorKey signs all anchors.  The
+// funding outpoint, formatted as hash:index, is only used when no anchor UTXO
+// has been persisted to stateFile yet.
+func NewDcrd(params *chaincfg.Params, host, user, pass, cert, anchorKey, outpoint, stateFile string, fees FeePolicy) (*DcrdWallet, error) {
+	if !ValidFeeMode(fees.Mode, true) {
+		return nil, fmt.Errorf("unsupported fee mode: %v", fees.Mode)
+	}
+
+	serverCAs := x509.NewCertPool()
+	serverCert, err := os.ReadFile(cert)
+	if err != nil {
+		return nil, err
+	}
+	if !serverCAs.AppendCertsFromPEM(serverCert) {
+		return nil, fmt.Errorf("no certificates found in %s", cert)
+	}
+
+	wif, err := dcrutil.DecodeWIF(anchorKey, params.PrivateKeyID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid anchor key: %v", err)
+	}
+	privKey := secp256k1.PrivKeyFromBytes(wif.PrivKey())
+	pkHash := stdaddr.Hash160(privKey.PubKey().SerializeCompressed())
+	addr, err := stdaddr.NewAddressPubKeyHashEcdsaSecp256k1V0(pkHash,
+		params)
+	if err != nil {
+		return nil, err
+	}
+	_, pkScript := addr.PaymentScript()
+
+	d := &DcrdWallet{
+		dcrd: &dcrdClient{
+			url:  "https://" + host,
+			user: user,
+			pass: pass,
+			http: &http.Client{
+				Transport: &http.Transport{
+					TLSClientConfig: &tls.Config{
+						RootCAs: serverCAs,
+					},
+				},
+			},
+		},
+		privKey:   privKey,
+		pkScript:  pkScript,
+		fees:      fees,
+		stateFile: filepath.Clean(stateFile),
+	}
+
+	log.Infof("Dcrd: %v", host)
+	log.Infof("Anchor address: %v", addr)
+
+	err = d.loadUTXO()
+	switch {
+	case err == nil:
+		log.Infof("Anchor utxo: %v:%v", d.utxo.Hash, d.utxo.Index)
+		return d, nil
+	case !os.IsNotExist(err):
+		return nil, err
+	}
+
+	// First start, fund anchors from the configured outpoint.
+	s := strings.Split(outpoint, ":")
+	if len(s) != 2 {
+		return nil, fmt.Errorf("invalid funding outpoint: %v", outpoint)
+	}
+	index, err := strconv.ParseUint(s[1], 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid funding outpoint index: %v", err)
+	}
+	out, err := d.getTxOut(s[0], uint32(index))
+	if err != nil {
+		return nil, err
+	}
+	if out == nil {
+		return nil, fmt.Errorf("funding outpoint %v is spent or unknown",
+			outpoint)
+	}
+	if out.ScriptPubKey.Hex != hex.EncodeToString(pkScript) {
+		return nil, fmt.Errorf("funding outpoint %v does not pay to %v",
+			outpoint, addr)
+	}
+	amount, err := dcrutil.NewAmount(out.Value)
+	if err != nil {
+		return nil, err
+	}
+	d.utxo = anchorUTXO{
+		Hash:   s[0],
+		Index:  uint32(index),
+		Amount: int64(amount),
+	}
+	err = d.saveUTXO()
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("Anchor funding utxo: %v:%v %v", d.utxo.Hash, d.utxo.Index,
+		amount)
+
+	return d, nil
+}
@@ -0,0 +1,293 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package dcrtimewallet
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/decred/dcrd/chaincfg/chainhash"
+	"github.com/decred/dcrd/chaincfg/v3"
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+	"github.com/decred/dcrd/txscript/v4"
+	"github.com/decred/dcrd/txscript/v4/stdaddr"
+	"github.com/decred/dcrd/wire"
+)
+
+// fakeDcrd is a dcrd JSON-RPC server that accepts every raw transaction.
+type fakeDcrd struct {
+	sync.Mutex
+	txs []*wire.MsgTx
+}
+
+// ServeHTTP handles sendrawtransaction calls.
+func (f *fakeDcrd) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Method string            `json:"method"`
+		Params []json.RawMessage `json:"params"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil || req.Method != "sendrawtransaction" ||
+		len(req.Params) != 1 {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	var s string
+	if err := json.Unmarshal(req.Params[0], &s); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	tx := wire.NewMsgTx()
+	if err := tx.Deserialize(bytes.NewReader(b)); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	f.Lock()
+	f.txs = append(f.txs, tx)
+	f.Unlock()
+
+	json.NewEncoder(w).Encode(struct {
+		Result string `json:"result"`
+	}{tx.TxHash().String()})
+}
+
+// published returns the transactions that were sent to the server.
+func (f *fakeDcrd) published() []*wire.MsgTx {
+	f.Lock()
+	defer f.Unlock()
+	return append([]*wire.MsgTx(nil), f.txs...)
+}
+
+// newTestDcrd returns a DcrdWallet that publishes through a fake dcrd and
+// spends an output of the provided amount.
+func newTestDcrd(t *testing.T, amount int64, stateFile string) (*DcrdWallet, *fakeDcrd) {
+	t.Helper()
+
+	privKey, err := secp256k1.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkHash := stdaddr.Hash160(privKey.PubKey().SerializeCompressed())
+	addr, err := stdaddr.NewAddressPubKeyHashEcdsaSecp256k1V0(pkHash,
+		chaincfg.SimNetParams())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, pkScript := addr.PaymentScript()
+
+	f := &fakeDcrd{}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+
+	return &DcrdWallet{
+		dcrd: &dcrdClient{
+			url:  srv.URL,
+			http: srv.Client(),
+		},
+		privKey:  privKey,
+		pkScript: pkScript,
+		fees: FeePolicy{
+			Mode:    FeeModeFixed,
+			FeeRate: 1e4,
+		},
+		stateFile: stateFile,
+		utxo: anchorUTXO{
+			Hash:   chainhash.HashH([]byte("funding")).String(),
+			Amount: amount,
+		},
+	}, f
+}
+
+// verifyAnchor verifies that the anchor spends the provided output, that its
+// signature is valid and that it pays the expected fee.
+func verifyAnchor(t *testing.T, d *DcrdWallet, tx *wire.MsgTx, prev anchorUTXO, merkleRoot [sha256.Size]byte, prefix []byte) {
+	t.Helper()
+
+	if len(tx.TxIn) != 1 || len(tx.TxOut) != 2 {
+		t.Fatalf("got %v inputs and %v outputs, want 1 and 2",
+			len(tx.TxIn), len(tx.TxOut))
+	}
+	in := tx.TxIn[0]
+	if in.PreviousOutPoint.Hash.String() != prev.Hash ||
+		in.PreviousOutPoint.Index != prev.Index {
+		t.Fatalf("got outpoint %v, want %v:%v", in.PreviousOutPoint,
+			prev.Hash, prev.Index)
+	}
+
+	vm, err := txscript.NewEngine(d.pkScript, tx, 0,
+		txscript.ScriptVerifyCleanStack|txscript.ScriptVerifySigPushOnly|
+			txscript.ScriptDiscourageUpgradableNops, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := vm.Execute(); err != nil {
+		t.Fatalf("invalid signature: %v", err)
+	}
+
+	script, err := AnchorScript(merkleRoot, prefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tx.TxOut[0].Value != 0 || !bytes.Equal(tx.TxOut[0].PkScript, script) {
+		t.Fatalf("got anchor output %x, want %x", tx.TxOut[0].PkScript,
+			script)
+	}
+	if !bytes.Equal(tx.TxOut[1].PkScript, d.pkScript) {
+		t.Fatalf("change does not pay to the anchor key")
+	}
+
+	// The fee covers the worst case signature script.
+	unsigned := tx.Copy()
+	unsigned.TxIn[0].SignatureScript = nil
+	size := int64(unsigned.SerializeSize() + redeemP2PKHSigScriptSize)
+	fee := size * d.fees.FeeRate / 1000
+	if got := prev.Amount - tx.TxOut[1].Value; got != fee {
+		t.Fatalf("got fee %v, want %v", got, fee)
+	}
+	if int64(tx.SerializeSize()) > size {
+		t.Fatalf("signed size %v exceeds estimate %v",
+			tx.SerializeSize(), size)
+	}
+}
+
+// TestConstruct verifies that anchors are signed correctly and that every
+// anchor spends the persisted change of the previous one.
+func TestConstruct(t *testing.T) {
+	stateFile := filepath.Join(t.TempDir(), "utxo.json")
+	d, f := newTestDcrd(t, 1e8, stateFile)
+	prefix := []byte("dcrtime")
+
+	for i := 0; i < 3; i++ {
+		prev := d.utxo
+		root := sha256.Sum256([]byte{byte(i)})
+		hash, err := d.Construct(root, prefix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		txs := f.published()
+		if len(txs) != i+1 {
+			t.Fatalf("got %v published anchors, want %v", len(txs),
+				i+1)
+		}
+		tx := txs[i]
+		if tx.TxHash() != *hash {
+			t.Fatalf("got hash %v, want %v", hash, tx.TxHash())
+		}
+		verifyAnchor(t, d, tx, prev, root, prefix)
+
+		// The change funds the next anchor, also after a restart.
+		want := anchorUTXO{
+			Hash:   hash.String(),
+			Index:  1,
+			Amount: tx.TxOut[1].Value,
+		}
+		if d.utxo != want {
+			t.Fatalf("got utxo %+v, want %+v", d.utxo, want)
+		}
+		d.utxo = anchorUTXO{}
+		if err := d.loadUTXO(); err != nil {
+			t.Fatal(err)
+		}
+		if d.utxo != want {
+			t.Fatalf("got persisted utxo %+v, want %+v", d.utxo, want)
+		}
+	}
+}
+
+// TestConstructDust verifies that anchors that would leave change below the
+// dust limit are refused.
+func TestConstructDust(t *testing.T) {
+	stateFile := filepath.Join(t.TempDir(), "utxo.json")
+	d, f := newTestDcrd(t, 1e8, stateFile)
+
+	// All anchors with the same prefix pay the same fee.
+	_, err := d.Construct([sha256.Size]byte{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fee := 1e8 - d.utxo.Amount
+
+	// Smallest change that is not dust.
+	change := int64(1)
+	for isDust(change, d.pkScript) {
+		change++
+	}
+	if change-1 <= fee {
+		t.Fatalf("dust limit %v does not exceed fee %v", change, fee)
+	}
+
+	d.utxo.Amount = fee + change - 1
+	_, err = d.Construct([sha256.Size]byte{}, nil)
+	if !errors.Is(err, ErrInsufficientFunds) {
+		t.Fatalf("got error %v, want %v", err, ErrInsufficientFunds)
+	}
+	if len(f.published()) != 1 {
+		t.Fatal("dust anchor was published")
+	}
+
+	d.utxo.Amount = fee + change
+	_, err = d.Construct([sha256.Size]byte{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.utxo.Amount != change {
+		t.Fatalf("got change %v, want %v", d.utxo.Amount, change)
+	}
+}
+
+// TestConstructUnsaved verifies that anchoring stops until an anchor UTXO that
+// could not be persisted is saved.
+func TestConstructUnsaved(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "state")
+	d, f := newTestDcrd(t, 1e8, filepath.Join(dir, "utxo.json"))
+
+	// The anchor is published even though its change was not saved.
+	hash, err := d.Construct(sha256.Sum256([]byte("a")), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !d.unsaved {
+		t.Fatal("utxo not marked unsaved")
+	}
+
+	_, err = d.Construct(sha256.Sum256([]byte("b")), nil)
+	if err == nil {
+		t.Fatal("anchored with an unsaved utxo")
+	}
+	if len(f.published()) != 1 {
+		t.Fatalf("got %v published anchors, want 1", len(f.published()))
+	}
+
+	if err := os.Mkdir(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	_, err = d.Construct(sha256.Sum256([]byte("b")), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	txs := f.published()
+	if len(txs) != 2 {
+		t.Fatalf("got %v published anchors, want 2", len(txs))
+	}
+	if txs[1].TxIn[0].PreviousOutPoint.Hash != *hash {
+		t.Fatalf("got outpoint %v, want %v:1",
+			txs[1].TxIn[0].PreviousOutPoint, hash)
+	}
+}
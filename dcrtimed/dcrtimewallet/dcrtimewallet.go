@@ -9,16 +9,89 @@ import (
 	"crypto/sha256"
 	"crypto/tls"
 	"crypto/x509"
+	"errors"
 	"fmt"
 	"os"
+	"sync/atomic"
 
 	pb "decred.org/dcrwallet/v3/rpc/walletrpc"
 	"github.com/decred/dcrd/chaincfg/chainhash"
 	"github.com/decred/dcrd/txscript/v4"
+	"github.com/decred/dcrd/wire"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
 )
 
+// Wallet anchors merkle roots in the Decred blockchain and looks up the
+// anchoring transactions.  It is implemented by DcrtimeWallet, which uses
+// dcrwallet, and DcrdWallet, which signs anchors itself and publishes them
+// through dcrd.
+type Wallet interface {
+	// Lookup returns the block information of the provided transaction.
+	Lookup(chainhash.Hash) (*TxLookupResult, error)
+
+	// Construct creates and publishes an anchor transaction for the
+	// provided merkle root.  The optional prefix is stored in front of
+	// the merkle root.
+	Construct([sha256.Size]byte, []byte) (*chainhash.Hash, error)
+
+	// Replace creates and publishes a new anchor transaction for the
+	// merkle root of an anchor that was not mined in time.  The fee rate
+	// of the new anchor is multiplied by the provided factor.
+	Replace([sha256.Size]byte, []byte, int64) (*chainhash.Hash, error)
+
+	// GetWalletBalance returns the balance available for anchoring.
+	GetWalletBalance() (*BalanceResult, error)
+
+	// BestHeight returns the height of the best block.
+	BestHeight() (int32, error)
+
+	// NotifyBlocks calls the provided function with the height of the
+	// best block whenever blocks are connected to the main chain.  It
+	// returns once the context is canceled or the subscription failed.
+	// ErrNoNotifications is returned if the wallet can not notify blocks.
+	NotifyBlocks(context.Context, func(int32)) error
+
+	// Block returns the block with the provided hash.
+	Block(chainhash.Hash) (*wire.MsgBlock, error)
+
+	// Headers returns the headers of the main chain blocks that follow
+	// the block with the provided hash up to and including the provided
+	// height.
+	Headers(chainhash.Hash, int32) ([]wire.BlockHeader, error)
+
+	// Close releases all resources.
+	Close()
+}
+
+var _ Wallet = (*DcrtimeWallet)(nil)
+
+// ErrNotSupported is returned when the wallet can not serve blocks and block
+// headers.
+var ErrNotSupported = errors.New("not supported by dcrwallet, use dcrd")
+
+// ErrNoNotifications is returned when the wallet can not notify blocks.
+var ErrNoNotifications = errors.New("block notifications not supported")
+
+// MaxAnchorPrefixSize is the maximum size of the prefix that identifies the
+// anchors of an operator on-chain.
+const MaxAnchorPrefixSize = 16
+
+// AnchorScript returns the null data script of an anchor transaction.  It
+// consists of OP_RETURN followed by a single data push of the prefix and the
+// merkle root, so the merkle root is always the last 32 bytes of the script.
+func AnchorScript(merkleRoot [sha256.Size]byte, prefix []byte) ([]byte, error) {
+	if len(prefix) > MaxAnchorPrefixSize {
+		return nil, fmt.Errorf("anchor prefix too long: %v > %v",
+			len(prefix), MaxAnchorPrefixSize)
+	}
+	data := make([]byte, 0, len(prefix)+len(merkleRoot))
+	data = append(data, prefix...)
+	data = append(data, merkleRoot[:]...)
+	return txscript.NewScriptBuilder().AddOp(txscript.OP_RETURN).
+		AddData(data).Script()
+}
+
 type DcrtimeWallet struct {
 	account    uint32
 	minconf    int32
@@ -26,6 +99,16 @@ type DcrtimeWallet struct {
 	wallet     pb.WalletServiceClient
 	ctx        context.Context
 	passphrase []byte
+	version    APIVersion
+	fees       FeePolicy
+
+	// accountPassphrase unlocks the account while anchors are signed.
+	// It is only set when the account is encrypted individually.
+	accountPassphrase []byte
+
+	// legacyLookup is set once the wallet rejected confirmation
+	// notifications.  Lookups fall back to GetTransaction from then on.
+	legacyLookup int32
 }
 
 type TxLookupResult struct {
@@ -43,51 +126,92 @@ type BalanceResult struct {
 	Unconfirmed int64
 }
 
-// Lookup looks up the provided TX hash and returns a Result structure.
-func (d *DcrtimeWallet) Lookup(tx chainhash.Hash) (*TxLookupResult, error) {
-	ctx, cancel := context.WithCancel(d.ctx)
-	defer cancel()
-
-	// Ask how many confirmations we got
+// confirmations returns the number of confirmations and the block hash of
+// the provided TX hash using a confirmation notification stream.
+func (d *DcrtimeWallet) confirmations(ctx context.Context, tx chainhash.Hash) (int32, []byte, error) {
 	n, err := d.wallet.ConfirmationNotifications(ctx)
 	if err != nil {
-		return nil, err
+		return 0, nil, err
 	}
 	err = n.Send(&pb.ConfirmationNotificationsRequest{
 		TxHashes:  [][]byte{tx[:]},
 		StopAfter: 0, // We only want one reply
 	})
 	if err != nil {
-		return nil, err
+		return 0, nil, err
 	}
 	r, err := n.Recv()
 	if err != nil {
-		return nil, err
+		return 0, nil, err
 	}
 	if len(r.Confirmations) != 1 {
-		return nil, fmt.Errorf("invalid reply length: %v",
+		return 0, nil, fmt.Errorf("invalid reply length: %v",
 			len(r.Confirmations))
 	}
 
 	// Sanity test confirmations reply
 	h, err := chainhash.NewHash(r.Confirmations[0].TxHash)
 	if err != nil {
-		return nil, err
+		return 0, nil, err
 	}
 	if !h.IsEqual(&tx) {
-		return nil, fmt.Errorf("invalid tx hash: %v", tx.String())
+		return 0, nil, fmt.Errorf("invalid tx hash: %v", tx.String())
+	}
+
+	return r.Confirmations[0].Confirmations, r.Confirmations[0].BlockHash,
+		nil
+}
+
+// legacyConfirmations returns the number of confirmations and the block hash
+// of the provided TX hash for wallets that do not implement confirmation
+// notifications.
+func (d *DcrtimeWallet) legacyConfirmations(ctx context.Context, tx chainhash.Hash) (int32, []byte, error) {
+	r, err := d.wallet.GetTransaction(ctx, &pb.GetTransactionRequest{
+		TransactionHash: tx[:],
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+	return r.Confirmations, r.BlockHash, nil
+}
+
+// Lookup looks up the provided TX hash and returns a Result structure.
+func (d *DcrtimeWallet) Lookup(tx chainhash.Hash) (*TxLookupResult, error) {
+	ctx, cancel := context.WithCancel(d.ctx)
+	defer cancel()
+
+	// Ask how many confirmations we got
+	var (
+		confirmations int32
+		blockHash     []byte
+		err           error
+	)
+	if atomic.LoadInt32(&d.legacyLookup) == 0 {
+		confirmations, blockHash, err = d.confirmations(ctx, tx)
+		if isUnimplemented(err) {
+			log.Warnf("Wallet API %v does not implement confirmation "+
+				"notifications, falling back to GetTransaction",
+				d.version)
+			atomic.StoreInt32(&d.legacyLookup, 1)
+		}
+	}
+	if atomic.LoadInt32(&d.legacyLookup) != 0 {
+		confirmations, blockHash, err = d.legacyConfirmations(ctx, tx)
+	}
+	if err != nil {
+		return nil, err
 	}
 
 	// Abort early if we don't have enough confirmations.
-	if r.Confirmations[0].Confirmations <= 0 {
+	if confirmations <= 0 {
 		return &TxLookupResult{
-			Confirmations: r.Confirmations[0].Confirmations,
+			Confirmations: confirmations,
 		}, nil
 	}
 
 	// Get timestamp
 	rbi, err := d.wallet.BlockInfo(ctx, &pb.BlockInfoRequest{
-		BlockHash: r.Confirmations[0].BlockHash,
+		BlockHash: blockHash,
 	})
 	if err != nil {
 		return nil, err
@@ -106,11 +230,35 @@ func (d *DcrtimeWallet) Lookup(tx chainhash.Hash) (*TxLookupResult, error) {
 	}, nil
 }
 
-// Construct creates aand submits an anchored tx with the provided merkle root.
-func (d *DcrtimeWallet) Construct(merkleRoot [sha256.Size]byte) (*chainhash.Hash, error) {
-	// Generate script that contains OP_RETURN followed by the merkle root.
-	script, err := txscript.NewScriptBuilder().AddOp(txscript.OP_RETURN).
-		AddData(merkleRoot[:]).Script()
+// feePerKb returns the fee rate of anchors in atoms/kB.  Zero lets the wallet
+// decide.
+func (d *DcrtimeWallet) feePerKb() int32 {
+	if d.fees.Mode != FeeModeFixed {
+		return 0
+	}
+	return int32(d.fees.FeeRate)
+}
+
+// Construct creates aand submits an anchored tx with the provided merkle root
+// and prefix.
+func (d *DcrtimeWallet) Construct(merkleRoot [sha256.Size]byte, prefix []byte) (*chainhash.Hash, error) {
+	return d.construct(merkleRoot, prefix, d.feePerKb())
+}
+
+// Replace creates and submits an anchored tx for the merkle root of an anchor
+// that was not mined in time.  The new anchor spends other outputs and pays
+// the configured fee rate multiplied by bump, also in the wallet fee mode.
+// Either anchor proves the merkle root once mined.
+func (d *DcrtimeWallet) Replace(merkleRoot [sha256.Size]byte, prefix []byte, bump int64) (*chainhash.Hash, error) {
+	return d.construct(merkleRoot, prefix, int32(d.fees.FeeRate*bump))
+}
+
+// construct creates and submits an anchored tx that pays the provided fee
+// rate.  Zero lets the wallet decide.
+func (d *DcrtimeWallet) construct(merkleRoot [sha256.Size]byte, prefix []byte, feePerKb int32) (*chainhash.Hash, error) {
+	// Generate script that contains OP_RETURN followed by the prefix and
+	// the merkle root.
+	script, err := AnchorScript(merkleRoot, prefix)
 	if err != nil {
 		return nil, err
 	}
@@ -119,7 +267,7 @@ func (d *DcrtimeWallet) Construct(merkleRoot [sha256.Size]byte) (*chainhash.Hash
 	constructRequest := &pb.ConstructTransactionRequest{
 		SourceAccount:            d.account,
 		RequiredConfirmations:    d.minconf,
-		FeePerKb:                 0, // let wallet decide the fee
+		FeePerKb:                 feePerKb,
 		OutputSelectionAlgorithm: pb.ConstructTransactionRequest_UNSPECIFIED,
 		NonChangeOutputs: []*pb.ConstructTransactionRequest_Output{
 			{
@@ -137,6 +285,23 @@ func (d *DcrtimeWallet) Construct(merkleRoot [sha256.Size]byte) (*chainhash.Hash
 		return nil, err
 	}
 
+	// Refuse anchors that are too expensive before they are signed so
+	// that they are retried on the next flush.
+	fee := constructResponse.TotalPreviousOutputAmount -
+		constructResponse.TotalOutputAmount
+	if err := d.fees.checkFee(fee); err != nil {
+		return nil, err
+	}
+
+	// Unlock an individually encrypted account for as long as it takes
+	// to sign the transaction.
+	if len(d.accountPassphrase) != 0 {
+		if err := d.unlockAccount(d.ctx); err != nil {
+			return nil, err
+		}
+		defer d.lockAccount(d.ctx)
+	}
+
 	// Sign request.
 	signRequest := &pb.SignTransactionRequest{
 		Passphrase:            d.passphrase,
@@ -165,6 +330,60 @@ func (d *DcrtimeWallet) Construct(merkleRoot [sha256.Size]byte) (*chainhash.Hash
 	return txHash, nil
 }
 
+// unlockAccount unlocks the individually encrypted account that funds anchors.
+func (d *DcrtimeWallet) unlockAccount(ctx context.Context) error {
+	_, err := d.wallet.UnlockAccount(ctx, &pb.UnlockAccountRequest{
+		Passphrase:    d.accountPassphrase,
+		AccountNumber: d.account,
+	})
+	if err != nil {
+		return fmt.Errorf("unlock account %v: %v", d.account, err)
+	}
+	return nil
+}
+
+// lockAccount locks the individually encrypted account that funds anchors
+// again.  Failures are logged since the anchor was signed already.
+func (d *DcrtimeWallet) lockAccount(ctx context.Context) {
+	_, err := d.wallet.LockAccount(ctx, &pb.LockAccountRequest{
+		AccountNumber: d.account,
+	})
+	if err != nil {
+		log.Errorf("Lock account %v: %v", d.account, err)
+	}
+}
+
+// selectAccount makes the named account fund anchors.  The wallet must be on
+// the provided network, so that a configuration meant for one network never
+// spends the coins of another.  The passphrase of an individually encrypted
+// account is verified right away rather than on the first flush.
+func (d *DcrtimeWallet) selectAccount(ctx context.Context, net wire.CurrencyNet, account string) error {
+	nr, err := d.wallet.Network(ctx, &pb.NetworkRequest{})
+	if err != nil {
+		return err
+	}
+	if wire.CurrencyNet(nr.ActiveNetwork) != net {
+		return fmt.Errorf("wallet is on %v, expected %v",
+			wire.CurrencyNet(nr.ActiveNetwork), net)
+	}
+
+	ar, err := d.wallet.AccountNumber(ctx, &pb.AccountNumberRequest{
+		AccountName: account,
+	})
+	if err != nil {
+		return fmt.Errorf("account %q: %v", account, err)
+	}
+	d.account = ar.AccountNumber
+
+	if len(d.accountPassphrase) != 0 {
+		if err := d.unlockAccount(ctx); err != nil {
+			return err
+		}
+		d.lockAccount(ctx)
+	}
+	return nil
+}
+
 // GetWalletBalance returns balance information from the
 // wallet account.
 func (d *DcrtimeWallet) GetWalletBalance() (*BalanceResult, error) {
@@ -187,18 +406,69 @@ func (d *DcrtimeWallet) GetWalletBalance() (*BalanceResult, error) {
 	return accountBalance, nil
 }
 
+// BestHeight returns the height of the best block known to the wallet.
+func (d *DcrtimeWallet) BestHeight() (int32, error) {
+	r, err := d.wallet.BestBlock(d.ctx, &pb.BestBlockRequest{})
+	if err != nil {
+		return 0, err
+	}
+	return int32(r.Height), nil
+}
+
+// NotifyBlocks satisfies the Wallet interface.  The transaction notifications
+// of dcrwallet include every block that is attached to the main chain,
+// notifications without attached blocks are about unmined transactions and are
+// skipped.
+func (d *DcrtimeWallet) NotifyBlocks(ctx context.Context, f func(int32)) error {
+	n, err := d.wallet.TransactionNotifications(ctx,
+		&pb.TransactionNotificationsRequest{})
+	if err != nil {
+		return err
+	}
+	for {
+		r, err := n.Recv()
+		if err != nil {
+			return err
+		}
+		if len(r.AttachedBlocks) == 0 {
+			continue
+		}
+		// Attached blocks are sorted by increasing height.
+		f(r.AttachedBlocks[len(r.AttachedBlocks)-1].Height)
+	}
+}
+
+// Block satisfies the Wallet interface.  dcrwallet only serves the
+// transactions that are relevant to the wallet, so ErrNotSupported is
+// returned.
+func (d *DcrtimeWallet) Block(hash chainhash.Hash) (*wire.MsgBlock, error) {
+	return nil, ErrNotSupported
+}
+
+// Headers satisfies the Wallet interface.  Headers are only useful together
+// with blocks, so ErrNotSupported is returned.
+func (d *DcrtimeWallet) Headers(hash chainhash.Hash, height int32) ([]wire.BlockHeader, error) {
+	return nil, ErrNotSupported
+}
+
 // Close shuts down the gRPC connection to the wallet.
 func (d *DcrtimeWallet) Close() {
 	d.conn.Close()
 }
 
-// New returns a DcrtimeWallet context.
-func New(cert, host, clientCert, clientKey string, passphrase []byte) (*DcrtimeWallet, error) {
+// New returns a DcrtimeWallet context that anchors from the named account of
+// a wallet on the provided network.  The account passphrase is only required
+// when the account is encrypted individually.
+func New(net wire.CurrencyNet, cert, host, clientCert, clientKey, account string, passphrase, accountPassphrase []byte, fees FeePolicy) (*DcrtimeWallet, error) {
+	if !ValidFeeMode(fees.Mode, false) {
+		return nil, fmt.Errorf("unsupported fee mode: %v", fees.Mode)
+	}
 	d := &DcrtimeWallet{
-		account:    0,
-		minconf:    2,
-		ctx:        context.Background(),
-		passphrase: passphrase,
+		minconf:           2,
+		ctx:               context.Background(),
+		passphrase:        passphrase,
+		accountPassphrase: accountPassphrase,
+		fees:              fees,
 	}
 
 	serverCAs := x509.NewCertPool()
@@ -227,5 +497,19 @@ func New(cert, host, clientCert, clientKey string, passphrase []byte) (*DcrtimeW
 	}
 	d.wallet = pb.NewWalletServiceClient(d.conn)
 
+	// Refuse to run against a wallet whose API we don't understand rather
+	// than failing later with opaque gRPC errors.
+	if err := d.negotiateVersion(d.ctx); err != nil {
+		d.conn.Close()
+		return nil, err
+	}
+	log.Infof("Wallet gRPC API version: %v", d.version)
+
+	if err := d.selectAccount(d.ctx, net, account); err != nil {
+		d.conn.Close()
+		return nil, err
+	}
+	log.Infof("Wallet account: %v (%v)", account, d.account)
+
 	return d, nil
 }
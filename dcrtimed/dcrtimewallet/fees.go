@@ -0,0 +1,58 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package dcrtimewallet
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/decred/dcrd/dcrutil/v4"
+)
+
+// Fee estimation modes of anchor transactions.
+const (
+	FeeModeFixed    = "fixed"    // Pay the configured fee rate
+	FeeModeWallet   = "wallet"   // Let dcrwallet choose the fee rate
+	FeeModeEstimate = "estimate" // Pay the fee rate estimated by dcrd
+)
+
+// estimateConfirmations is the number of blocks dcrd is asked to estimate a
+// fee rate for.
+const estimateConfirmations = 2
+
+// ErrFeeTooHigh is returned when an anchor transaction is refused because its
+// fee exceeds the maximum fee.
+var ErrFeeTooHigh = errors.New("fee exceeds maximum")
+
+// FeePolicy controls the fee of anchor transactions.
+type FeePolicy struct {
+	Mode    string // Fee estimation mode
+	FeeRate int64  // Fee rate in atoms/kB, fallback of FeeModeEstimate
+	MaxFee  int64  // Maximum fee in atoms of an anchor, 0 if unlimited
+}
+
+// ValidFeeMode returns true if the fee estimation mode is supported.  The
+// wallet mode is only supported by dcrwallet and the estimate mode only by
+// dcrd.
+func ValidFeeMode(mode string, dcrd bool) bool {
+	switch mode {
+	case FeeModeFixed:
+		return true
+	case FeeModeWallet:
+		return !dcrd
+	case FeeModeEstimate:
+		return dcrd
+	}
+	return false
+}
+
+// checkFee returns ErrFeeTooHigh if the fee exceeds the maximum fee.
+func (p FeePolicy) checkFee(fee int64) error {
+	if p.MaxFee > 0 && fee > p.MaxFee {
+		return fmt.Errorf("%w: %v > %v", ErrFeeTooHigh,
+			dcrutil.Amount(fee), dcrutil.Amount(p.MaxFee))
+	}
+	return nil
+}
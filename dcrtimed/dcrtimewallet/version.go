@@ -0,0 +1,69 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package dcrtimewallet
+
+import (
+	"context"
+	"fmt"
+
+	pb "decred.org/dcrwallet/v3/rpc/walletrpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// supportedAPIMajors are the dcrwallet gRPC API major versions dcrtimewallet
+// is known to work with.  The request and reply messages of all calls that
+// are used are identical across these versions.
+var supportedAPIMajors = []uint32{7, 8}
+
+// APIVersion is the semantic version of the dcrwallet gRPC API.
+type APIVersion struct {
+	Major uint32
+	Minor uint32
+	Patch uint32
+}
+
+// String returns the version as major.minor.patch.
+func (v APIVersion) String() string {
+	return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+}
+
+// isUnimplemented returns true if the error was returned by a wallet that
+// does not implement the called method.
+func isUnimplemented(err error) bool {
+	return status.Code(err) == codes.Unimplemented
+}
+
+// negotiateVersion retrieves the gRPC API version of the wallet and returns
+// an error if it is not supported.
+func (d *DcrtimeWallet) negotiateVersion(ctx context.Context) error {
+	vr, err := pb.NewVersionServiceClient(d.conn).Version(ctx,
+		&pb.VersionRequest{})
+	if isUnimplemented(err) {
+		return fmt.Errorf("wallet does not report its gRPC API version, " +
+			"dcrwallet is too old")
+	} else if err != nil {
+		return fmt.Errorf("wallet gRPC API version: %v", err)
+	}
+	d.version = APIVersion{
+		Major: vr.Major,
+		Minor: vr.Minor,
+		Patch: vr.Patch,
+	}
+
+	for _, major := range supportedAPIMajors {
+		if d.version.Major == major {
+			return nil
+		}
+	}
+	return fmt.Errorf("unsupported wallet gRPC API version %v (%v), "+
+		"supported major versions: %v", d.version, vr.VersionString,
+		supportedAPIMajors)
+}
+
+// Version returns the gRPC API version of the connected wallet.
+func (d *DcrtimeWallet) Version() APIVersion {
+	return d.version
+}
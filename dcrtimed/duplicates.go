@@ -0,0 +1,72 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+
+	"github.com/decred/dcrd/chaincfg/chainhash"
+	v2 "github.com/decred/dcrtime/api/v2"
+	"github.com/decred/dcrtime/dcrtimed/backend"
+)
+
+// newDuplicate returns the original submission of a digest as it is looked up
+// in the backend.
+func newDuplicate(gr backend.GetResult) v2.Duplicate {
+	dup := v2.Duplicate{
+		Digest:          hex.EncodeToString(gr.Digest[:]),
+		ServerTimestamp: gr.Timestamp,
+		ServerTime:      v2.FormatTime(gr.Timestamp),
+		Status:          v2.DuplicateStatusPending,
+	}
+	if gr.Tx == (chainhash.Hash{}) {
+		return dup
+	}
+	dup.Status = v2.DuplicateStatusAnchored
+	dup.Transaction = gr.Tx.String()
+	dup.Confirmations = gr.Confirmations
+	if gr.AnchoredTimestamp != 0 {
+		dup.Status = v2.DuplicateStatusConfirmed
+		dup.ChainTimestamp = gr.AnchoredTimestamp
+		dup.ChainTime = v2.FormatTime(gr.AnchoredTimestamp)
+	}
+	return dup
+}
+
+// duplicates returns the original submissions of the digests that were
+// rejected because they were timestamped before, in the order of the results.
+// Digests that may not be revealed to the client when digests are private are
+// left out.  Duplicates are informational only, so a failed lookup is logged
+// and no duplicates are returned.
+func (d *DcrtimeStore) duplicates(r *http.Request, me []backend.PutResult) []v2.Duplicate {
+	var digests [][sha256.Size]byte
+	for _, v := range me {
+		if v.ErrorCode == backend.ErrorExists {
+			digests = append(digests, v.Digest)
+		}
+	}
+	if len(digests) == 0 {
+		return nil
+	}
+
+	grs, err := d.backend.Get(digests)
+	if err == nil {
+		err = d.newDigestAccess(r, nil).hideDigests(grs)
+	}
+	if err != nil {
+		log.Warnf("%v duplicates: %v", logAddr(r), err)
+		return nil
+	}
+	dups := make([]v2.Duplicate, 0, len(grs))
+	for _, gr := range grs {
+		if gr.ErrorCode != backend.ErrorOK {
+			continue
+		}
+		dups = append(dups, newDuplicate(gr))
+	}
+	return dups
+}
@@ -0,0 +1,161 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	v2 "github.com/decred/dcrtime/api/v2"
+	"github.com/decred/dcrtime/dcrtimed/backend"
+	"github.com/decred/dcrtime/util"
+)
+
+// decodeExport decodes and validates an export request.  It replies with an
+// error and returns false if the request is invalid.
+func decodeExport(w http.ResponseWriter, body io.Reader, e *v2.Export) bool {
+	decoder := json.NewDecoder(body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(e); err != nil {
+		util.RespondWithError(w, http.StatusBadRequest,
+			"Invalid request payload")
+		return false
+	}
+	if e.FromTimestamp < 0 || e.FromTimestamp > e.ToTimestamp {
+		util.RespondWithError(w, http.StatusBadRequest,
+			"Invalid timestamp range")
+		return false
+	}
+	if e.ToTimestamp-e.FromTimestamp >= v2.MaxExportRange {
+		util.RespondWithError(w, http.StatusUnprocessableEntity,
+			fmt.Sprintf("Timestamp range exceeds %v seconds",
+				v2.MaxExportRange))
+		return false
+	}
+	switch e.Format {
+	case "", v2.ExportFormatCSV:
+	default:
+		util.RespondWithError(w, http.StatusBadRequest,
+			fmt.Sprintf("Unsupported export format: %v", e.Format))
+		return false
+	}
+	return true
+}
+
+// exportRows returns the CSV rows of a flushed collection, one per digest, in
+// the order of v2.ExportColumns.
+func exportRows(er backend.ExportRecord) [][]string {
+	ts := strconv.FormatInt(er.Timestamp, 10)
+	flushed := strconv.FormatInt(er.FlushTimestamp, 10)
+	root := hex.EncodeToString(er.MerkleRoot[:])
+	tx := er.Tx.String()
+	height := strconv.FormatInt(int64(er.BlockHeight), 10)
+	chain := strconv.FormatInt(er.ChainTimestamp, 10)
+
+	rows := make([][]string, 0, len(er.Digests))
+	for _, digest := range er.Digests {
+		rows = append(rows, []string{hex.EncodeToString(digest[:]), ts,
+			flushed, root, tx, height, chain})
+	}
+	return rows
+}
+
+// exportV2 streams every digest of the flushed collections in the requested
+// range together with its anchor.  The reply is flushed after every collection
+// so that large ranges are not held in memory.  The connection is aborted if
+// the export fails after it started so that a truncated export is not mistaken
+// for a complete one.
+// Handles /v2/admin/export
+func (d *DcrtimeStore) exportV2(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	if !d.isAuthorized(r, v2.TokenScopeAdmin) {
+		d.respondNotAuthorized(w, r)
+		return
+	}
+
+	var e v2.Export
+	if !decodeExport(w, r.Body, &e) {
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	cw := csv.NewWriter(w)
+	var started bool
+	writeHeader := func() {
+		if started {
+			return
+		}
+		started = true
+		w.Header().Set("Content-Type", v2.ExportCSVMIMEType)
+		w.Header().Set("Content-Disposition",
+			fmt.Sprintf("attachment; filename=\"dcrtime-%v-%v.csv\"",
+				e.FromTimestamp, e.ToTimestamp))
+		w.WriteHeader(http.StatusOK)
+		cw.Write(v2.ExportColumns)
+	}
+
+	var collections, digests int
+	start := time.Now()
+	err := d.backend.ExportCollections(e.FromTimestamp, e.ToTimestamp,
+		func(er backend.ExportRecord) error {
+			writeHeader()
+			if err := cw.WriteAll(exportRows(er)); err != nil {
+				return err
+			}
+			collections++
+			digests += len(er.Digests)
+			return rc.Flush()
+		})
+	if err != nil {
+		errorCode := time.Now().Unix()
+		log.Errorf("%v Export error code %v: %v", logAddr(r),
+			errorCode, err)
+		if started {
+			panic(http.ErrAbortHandler)
+		}
+		util.RespondWithError(w, http.StatusInternalServerError,
+			fmt.Sprintf("failed to export collections, "+
+				"contact administrator and provide "+
+				"the following error code: %v", errorCode))
+		return
+	}
+	writeHeader()
+	cw.Flush()
+
+	log.Infof("%v Export %v: %v-%v: Collections %v Digests %v in %v",
+		r.URL.Path, logAddr(r), e.FromTimestamp, e.ToTimestamp,
+		collections, digests, time.Since(start).Round(time.Millisecond))
+}
+
+// proxyExportV2 relays an export from the storehost while it is being
+// streamed.
+func (d *DcrtimeStore) proxyExportV2(w http.ResponseWriter, r *http.Request) {
+	b, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		util.RespondWithError(w, http.StatusBadRequest,
+			"Unable to read request")
+		return
+	}
+
+	var e v2.Export
+	if !decodeExport(w, bytes.NewReader(b), &e) {
+		return
+	}
+
+	log.Infof("%v Export %v: %v-%v", r.URL.Path, logAddr(r),
+		e.FromTimestamp, e.ToTimestamp)
+
+	d.relayStream(w, r, withAPIToken(v2.ExportRoute, r), "Export",
+		bytes.NewReader(b))
+}
@@ -0,0 +1,146 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// fanoutResult is the outcome of a request that was submitted to a single
+// storehost during a fan-out.
+type fanoutResult struct {
+	host    string
+	primary bool
+	reply   *upstreamReply
+	err     error
+}
+
+// forwardTo sends the request to a single fan-out upstream. Fan-out upstreams
+// have no failover, a failing upstream only counts against its own circuit
+// breaker.
+func (d *DcrtimeStore) forwardTo(ctx context.Context, u *upstream, method, route, contentType, remoteAddr string, body []byte) (*upstreamReply, error) {
+	if u.isOpen() {
+		return nil, errors.New("circuit breaker open")
+	}
+
+	reply, err := u.do(ctx, method, route, contentType, remoteAddr,
+		bytes.NewReader(body))
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if u.failed(d.cfg.StoreBreakerLimit, d.cfg.StoreBreakerPeriod) {
+			log.Warnf("Storehost %v circuit breaker open for %v",
+				u.host, d.cfg.StoreBreakerPeriod)
+		}
+		return nil, err
+	}
+	if reply.statusCode != http.StatusServiceUnavailable {
+		u.succeeded()
+	}
+
+	return reply, nil
+}
+
+// fanout submits the request to the storehost, or its failover, and to all
+// fan-out storehosts concurrently. The reply of the storehost is returned once
+// at least the configured quorum of storehosts accepted the request. The
+// storehost and its failover count as a single vote. When the quorum is not
+// reached the first rejection is returned, or an unavailableError if no
+// storehost rejected the request.
+func (d *DcrtimeStore) fanout(ctx context.Context, method, route, contentType, remoteAddr string, body []byte) (*upstreamReply, error) {
+	if len(d.fanouts) == 0 {
+		return d.forward(ctx, method, route, contentType, remoteAddr,
+			bytes.NewReader(body))
+	}
+
+	results := make(chan fanoutResult, len(d.fanouts)+1)
+	go func() {
+		reply, err := d.forward(ctx, method, route, contentType,
+			remoteAddr, bytes.NewReader(body))
+		results <- fanoutResult{
+			host:    d.upstreams[0].host,
+			primary: true,
+			reply:   reply,
+			err:     err,
+		}
+	}()
+	for _, u := range d.fanouts {
+		go func(u *upstream) {
+			reply, err := d.forwardTo(ctx, u, method, route,
+				contentType, remoteAddr, body)
+			results <- fanoutResult{
+				host:  u.host,
+				reply: reply,
+				err:   err,
+			}
+		}(u)
+	}
+
+	// Wait for all storehosts so that the request is stored as widely as
+	// possible.
+	var (
+		accepted  int
+		accept    *upstreamReply
+		rejection *upstreamReply
+		busy      *upstreamReply
+		err       error
+	)
+	for i := 0; i < cap(results); i++ {
+		r := <-results
+		switch {
+		case r.err != nil:
+			log.Errorf("Fan-out to storehost %v: %v", r.host, r.err)
+			err = r.err
+		case r.reply.statusCode == http.StatusOK:
+			accepted++
+			if accept == nil || r.primary {
+				accept = r.reply
+			}
+		case r.reply.statusCode == http.StatusServiceUnavailable:
+			log.Warnf("Fan-out to storehost %v: %v", r.host,
+				r.reply.status)
+			busy = r.reply
+		default:
+			log.Warnf("Fan-out to storehost %v: %v", r.host,
+				r.reply.status)
+			if rejection == nil || r.primary {
+				rejection = r.reply
+			}
+		}
+	}
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	if accepted >= d.cfg.StoreQuorum {
+		if accepted < cap(results) {
+			log.Warnf("Fan-out %v accepted by %v of %v storehosts",
+				route, accepted, cap(results))
+		}
+		return accept, nil
+	}
+	if rejection != nil {
+		return rejection, nil
+	}
+	if busy != nil && accepted == 0 {
+		return busy, nil
+	}
+
+	log.Errorf("Fan-out %v accepted by %v of %v storehosts, quorum is %v",
+		route, accepted, cap(results), d.cfg.StoreQuorum)
+	if err == nil {
+		err = errors.New("storehosts busy")
+	}
+	return nil, &unavailableError{
+		retryAfter: d.retryAfter(),
+		err: fmt.Errorf("quorum not reached (%v of %v): %v", accepted,
+			d.cfg.StoreQuorum, err),
+	}
+}
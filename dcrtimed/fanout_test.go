@@ -0,0 +1,168 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	v2 "github.com/decred/dcrtime/api/v2"
+)
+
+// slowStorehost is a storehost that does not reply until the test ends.
+type slowStorehost chan struct{}
+
+// ServeHTTP waits until the test ends or the request is aborted.
+func (s slowStorehost) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	select {
+	case <-r.Context().Done():
+	case <-s:
+	}
+}
+
+// newTestFanout returns a proxy with a storehost and fan-out storehosts that
+// run the provided handlers and require the provided quorum.
+func newTestFanout(t *testing.T, quorum int, primary http.Handler, fanouts ...http.Handler) *DcrtimeStore {
+	t.Helper()
+
+	cfg := testConfig(t)
+	cfg.StoreQuorum = quorum
+	cfg.StoreTimeout = 200 * time.Millisecond
+	cfg.StoreRetryBackoff = time.Second
+	cfg.StoreBreakerLimit = 3
+	cfg.StoreBreakerPeriod = time.Minute
+	var us []*upstream
+	for _, h := range fanouts {
+		us = append(us, newTestUpstream(t, h, cfg.StoreTimeout))
+	}
+	return newTestProxy(t, cfg, []*upstream{
+		newTestUpstream(t, primary, cfg.StoreTimeout),
+	}, us).DcrtimeStore
+}
+
+// submit fans a timestamp request out.
+func submit(d *DcrtimeStore) (*upstreamReply, error) {
+	return d.fanout(context.Background(), http.MethodPost,
+		v2.TimestampBatchRoute, "application/json", "127.0.0.1:1",
+		[]byte("{}"))
+}
+
+// TestFanoutQuorum verifies that the reply of the storehost is returned once
+// the quorum accepted the request, even if a fan-out storehost failed.
+func TestFanoutQuorum(t *testing.T) {
+	primary := &testStorehost{status: http.StatusOK, body: `"primary"`}
+	accepting := &testStorehost{status: http.StatusOK}
+	failing := &testStorehost{status: http.StatusInternalServerError}
+	d := newTestFanout(t, 2, primary, accepting, failing)
+
+	reply, err := submit(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.statusCode != http.StatusOK || string(reply.body) != `"primary"` {
+		t.Fatalf("got reply %v %s, want the storehost reply",
+			reply.statusCode, reply.body)
+	}
+	for _, s := range []*testStorehost{primary, accepting, failing} {
+		if n := len(s.requests()); n != 1 {
+			t.Fatalf("storehost got %v requests, want 1", n)
+		}
+	}
+
+	// A fan-out storehost replies for a storehost that failed.
+	primary.setStatus(http.StatusInternalServerError)
+	failing.setStatus(http.StatusOK)
+	reply, err = submit(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.statusCode != http.StatusOK || string(reply.body) != "{}" {
+		t.Fatalf("got reply %v %s, want a fan-out reply",
+			reply.statusCode, reply.body)
+	}
+}
+
+// TestFanoutQuorumMissed verifies that a request that fewer storehosts than
+// the quorum accepted fails with the rejection of a storehost or, if none
+// rejected it, as unavailable.
+func TestFanoutQuorumMissed(t *testing.T) {
+	primary := &testStorehost{status: http.StatusOK}
+	busy := &testStorehost{status: http.StatusServiceUnavailable}
+	rejecting := &testStorehost{status: http.StatusBadRequest}
+	d := newTestFanout(t, 3, primary, busy, rejecting)
+
+	reply, err := submit(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.statusCode != http.StatusBadRequest {
+		t.Fatalf("got status %v, want %v", reply.statusCode,
+			http.StatusBadRequest)
+	}
+
+	// Without a rejection the proxy is unavailable.
+	rejecting.setStatus(http.StatusServiceUnavailable)
+	_, err = submit(d)
+	var ue *unavailableError
+	if !errors.As(err, &ue) {
+		t.Fatalf("got error %v, want unavailable", err)
+	}
+	if ue.retryAfter <= 0 {
+		t.Fatalf("got retry after %v", ue.retryAfter)
+	}
+
+	// Busy storehosts are reported as busy when none accepted.
+	primary.setStatus(http.StatusServiceUnavailable)
+	reply, err = submit(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.statusCode != http.StatusServiceUnavailable {
+		t.Fatalf("got status %v, want %v", reply.statusCode,
+			http.StatusServiceUnavailable)
+	}
+}
+
+// TestFanoutSlow verifies that a fan-out storehost that times out does not
+// hold up a request the quorum accepted and counts against its circuit
+// breaker, while it does fail a request that needs its vote.
+func TestFanoutSlow(t *testing.T) {
+	primary := &testStorehost{status: http.StatusOK}
+	accepting := &testStorehost{status: http.StatusOK}
+	release := make(slowStorehost)
+	d := newTestFanout(t, 2, primary, accepting, release)
+	t.Cleanup(func() { close(release) })
+	slow := d.fanouts[1]
+
+	start := time.Now()
+	reply, err := submit(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.statusCode != http.StatusOK {
+		t.Fatalf("got status %v, want %v", reply.statusCode,
+			http.StatusOK)
+	}
+	if elapsed := time.Since(start); elapsed > 5*d.cfg.StoreTimeout {
+		t.Fatalf("fan-out took %v", elapsed)
+	}
+	slow.Lock()
+	failures := slow.failures
+	slow.Unlock()
+	if failures != 1 {
+		t.Fatalf("got %v failures, want 1", failures)
+	}
+
+	// The slow storehost can't make up for one that is down.
+	accepting.setStatus(http.StatusServiceUnavailable)
+	_, err = submit(d)
+	var ue *unavailableError
+	if !errors.As(err, &ue) {
+		t.Fatalf("got error %v, want unavailable", err)
+	}
+}
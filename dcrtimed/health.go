@@ -0,0 +1,115 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	v2 "github.com/decred/dcrtime/api/v2"
+	"github.com/decred/dcrtime/util"
+)
+
+// readyFlusherIntervals is the number of flusher intervals after which a
+// storehost whose flusher did not complete is no longer ready.
+const readyFlusherIntervals = 2
+
+// health answers liveness probes.  It does not check anything but the process
+// being able to serve requests.  Probes are frequent, so they are only logged
+// at debug level.
+// Handles /healthz
+func (d *DcrtimeStore) health(w http.ResponseWriter, r *http.Request) {
+	log.Debugf("%v Health %v", r.URL.Path, logAddr(r))
+
+	util.RespondWithJSON(w, http.StatusOK, v2.HealthReply{
+		Status: "ok",
+	})
+}
+
+// storeReadyChecks checks that the backend storage is usable, that the wallet
+// is reachable and that the flusher completed recently.
+func (d *DcrtimeStore) storeReadyChecks() []v2.ReadyCheck {
+	hr, err := d.backend.Health()
+	if err != nil {
+		return []v2.ReadyCheck{{
+			Name:  "backend",
+			Error: err.Error(),
+		}}
+	}
+
+	checks := []v2.ReadyCheck{
+		{Name: "backend", Ready: true},
+		{Name: "wallet", Ready: hr.WalletError == nil},
+		{Name: "flusher", Ready: true},
+	}
+	if hr.WalletError != nil {
+		checks[1].Error = hr.WalletError.Error()
+	}
+	late := readyFlusherIntervals * hr.FlusherInterval
+	if hr.FlusherInterval != 0 && time.Since(hr.LastFlusher) > late {
+		checks[2].Ready = false
+		checks[2].Error = fmt.Sprintf("last completed at %v",
+			v2.FormatTime(hr.LastFlusher.Unix()))
+	}
+	return checks
+}
+
+// proxyReadyChecks checks that requests can be forwarded to the storehost or
+// to the failover storehost.  Fanout storehosts are not required.
+func (d *DcrtimeStore) proxyReadyChecks() []v2.ReadyCheck {
+	check := v2.ReadyCheck{
+		Name: "storehost",
+	}
+	var errs []string
+	for _, u := range d.upstreams {
+		u.Lock()
+		healthy := u.healthy
+		u.Unlock()
+		if healthy && !u.isOpen() {
+			check.Ready = true
+			break
+		}
+		errs = append(errs, fmt.Sprintf("%v unavailable", u.host))
+	}
+	if !check.Ready {
+		check.Error = strings.Join(errs, ", ")
+	}
+	return []v2.ReadyCheck{check}
+}
+
+// readyChecks returns the readiness checks of the server mode.
+func (d *DcrtimeStore) readyChecks() []v2.ReadyCheck {
+	if d.backend == nil {
+		return d.proxyReadyChecks()
+	}
+	return d.storeReadyChecks()
+}
+
+// ready answers readiness probes.  The server is ready if it is able to
+// timestamp digests.
+// Handles /readyz
+func (d *DcrtimeStore) ready(w http.ResponseWriter, r *http.Request) {
+	checks := d.readyChecks()
+
+	reply := v2.ReadyReply{
+		Ready:  true,
+		Checks: checks,
+	}
+	status := http.StatusOK
+	for _, check := range checks {
+		if check.Ready {
+			continue
+		}
+		reply.Ready = false
+		status = http.StatusServiceUnavailable
+		log.Warnf("%v Ready %v: %v: %v", r.URL.Path, logAddr(r),
+			check.Name, check.Error)
+	}
+	log.Debugf("%v Ready %v: %v", r.URL.Path, logAddr(r), reply.Ready)
+
+	util.RespondWithJSON(w, status, reply)
+}
@@ -0,0 +1,215 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	v2 "github.com/decred/dcrtime/api/v2"
+	"github.com/decred/dcrtime/util"
+)
+
+const (
+	// idempotencyTTL is how long the reply of a request with an
+	// idempotency key is replayed.
+	idempotencyTTL = 24 * time.Hour
+
+	// maxIdempotentReplies is the number of replies that are kept.  The
+	// oldest reply is forgotten first when there are more.
+	maxIdempotentReplies = 10000
+
+	// maxIdempotencyKeyLength is the maximum length of an idempotency key.
+	maxIdempotencyKeyLength = 255
+)
+
+// idempotentReply is the reply of the first request with an idempotency key.
+// Done is closed once the request completed, the reply must not be accessed
+// before.  A zero code means that the request failed and was not recorded.
+type idempotentReply struct {
+	id      [sha256.Size]byte // Key, route and api token
+	request [sha256.Size]byte // Method, query and body
+	expires time.Time
+	done    chan struct{}
+
+	code   int
+	header http.Header
+	body   []byte
+}
+
+// idempotencyCache holds the replies of timestamp requests by idempotency key
+// so that retried requests are not submitted twice.
+type idempotencyCache struct {
+	sync.Mutex
+
+	size    int
+	entries map[[sha256.Size]byte]*list.Element
+	fifo    *list.List // Oldest first
+}
+
+// newIdempotencyCache returns an idempotency cache that holds up to size
+// replies.
+func newIdempotencyCache(size int) *idempotencyCache {
+	return &idempotencyCache{
+		size:    size,
+		entries: make(map[[sha256.Size]byte]*list.Element),
+		fifo:    list.New(),
+	}
+}
+
+// acquire returns the reply of the provided idempotency id.  A new reply is
+// returned, along with true, if there is none yet.  The caller must then
+// handle the request and complete the reply.
+func (c *idempotencyCache) acquire(id, request [sha256.Size]byte) (*idempotentReply, bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	now := time.Now()
+	for e := c.fifo.Front(); e != nil; e = c.fifo.Front() {
+		ir := e.Value.(*idempotentReply)
+		if ir.expires.After(now) && c.fifo.Len() < c.size {
+			break
+		}
+		c.fifo.Remove(e)
+		delete(c.entries, ir.id)
+	}
+
+	if e, ok := c.entries[id]; ok {
+		return e.Value.(*idempotentReply), false
+	}
+	ir := &idempotentReply{
+		id:      id,
+		request: request,
+		expires: now.Add(idempotencyTTL),
+		done:    make(chan struct{}),
+	}
+	c.entries[id] = c.fifo.PushBack(ir)
+	return ir, true
+}
+
+// complete records the reply of a request.  Only successful replies are
+// replayed, a failed request may be retried with the same key.
+func (c *idempotencyCache) complete(ir *idempotentReply, code int, header http.Header, body []byte) {
+	c.Lock()
+	defer c.Unlock()
+
+	if code == http.StatusOK || code == http.StatusAccepted {
+		ir.code = code
+		ir.header = header
+		ir.body = body
+	} else if e, ok := c.entries[ir.id]; ok && e.Value == ir {
+		c.fifo.Remove(e)
+		delete(c.entries, ir.id)
+	}
+	close(ir.done)
+}
+
+// recordingWriter sends a reply and keeps a copy of it.
+type recordingWriter struct {
+	http.ResponseWriter
+
+	code int
+	body bytes.Buffer
+}
+
+func (w *recordingWriter) WriteHeader(code int) {
+	w.code = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *recordingWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+// idempotent replays the successful reply of the provided handler to requests
+// that repeat the idempotency key, api token and body of an earlier request.
+// A request that reuses a key with a different body is refused.  A request
+// whose key is still being handled waits for the first request to complete.
+func (d *DcrtimeStore) idempotent(f http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(v2.IdempotencyKeyHeader)
+		if key == "" {
+			f(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			util.RespondWithError(w, http.StatusBadRequest,
+				"Invalid idempotency key")
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			util.RespondWithError(w, http.StatusBadRequest,
+				"Invalid request")
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		id := sha256.Sum256([]byte(r.URL.Query().Get("apitoken") + "\x00" +
+			r.URL.Path + "\x00" + key))
+		h := sha256.New()
+		h.Write([]byte(r.Method + "\x00" + r.URL.RawQuery + "\x00"))
+		h.Write(body)
+		var request [sha256.Size]byte
+		copy(request[:], h.Sum(nil))
+
+		for {
+			ir, first := d.idempotency.acquire(id, request)
+			if first {
+				rw := &recordingWriter{
+					ResponseWriter: w,
+					code:           http.StatusOK,
+				}
+				defer func() {
+					header := make(http.Header)
+					for _, k := range []string{"Content-Type",
+						v2.SignatureHeader} {
+						if v := w.Header().Get(k); v != "" {
+							header.Set(k, v)
+						}
+					}
+					d.idempotency.complete(ir, rw.code, header,
+						rw.body.Bytes())
+				}()
+				f(rw, r)
+				return
+			}
+			if ir.request != request {
+				util.RespondWithError(w,
+					http.StatusUnprocessableEntity,
+					"Idempotency key reused with a different "+
+						"request")
+				return
+			}
+
+			select {
+			case <-r.Context().Done():
+				return
+			case <-ir.done:
+			}
+			if ir.code == 0 {
+				// The first request failed, handle this one.
+				continue
+			}
+
+			log.Infof("%v Replay %v", r.URL.Path, logAddr(r))
+
+			for k, v := range ir.header {
+				w.Header()[k] = v
+			}
+			w.WriteHeader(ir.code)
+			if _, err := w.Write(ir.body); err != nil {
+				log.Errorf("Error responding to client: %v", err)
+			}
+			return
+		}
+	}
+}
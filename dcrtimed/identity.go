@@ -0,0 +1,311 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	v2 "github.com/decred/dcrtime/api/v2"
+	"github.com/decred/dcrtime/util"
+)
+
+const (
+	// identityKeySuffix is appended to the data directory to name the
+	// file that holds the identity keys.  The backend does not allow
+	// foreign files in the data directory.
+	identityKeySuffix = ".identity"
+
+	// defaultIdentityOverlap is the default time between a key rotation
+	// and the moment the new identity key takes over, during which both
+	// keys are published so that clients can update their trust bundles.
+	defaultIdentityOverlap = 7 * 24 * time.Hour
+)
+
+// identityKey is an identity key of the server and the period in which it
+// signs.  notAfter is zero while the key is not retired.
+type identityKey struct {
+	key       ed25519.PrivateKey
+	notBefore int64
+	notAfter  int64
+}
+
+// identityKeyEntry is the JSON encoding of an identity key.  The seed is hex
+// encoded.
+type identityKeyEntry struct {
+	Seed      string `json:"seed"`
+	NotBefore int64  `json:"notbefore"`
+	NotAfter  int64  `json:"notafter,omitempty"`
+}
+
+// identityKeyFile is the JSON encoding of the identity keys.
+type identityKeyFile struct {
+	Keys []identityKeyEntry `json:"keys"`
+}
+
+// newIdentityKey returns a random identity key that signs from notBefore on.
+func newIdentityKey(notBefore int64) (identityKey, error) {
+	seed := make([]byte, ed25519.SeedSize)
+	if _, err := rand.Read(seed); err != nil {
+		return identityKey{}, err
+	}
+	return identityKey{
+		key:       ed25519.NewKeyFromSeed(seed),
+		notBefore: notBefore,
+	}, nil
+}
+
+// loadIdentityKeys returns the Ed25519 identity keys that sign timestamp and
+// verify replies, oldest first.  The first key is created on first use.
+// Replacing the file invalidates the published public keys, signatures made
+// with the old keys remain valid for them.
+func loadIdentityKeys(filename string) ([]identityKey, error) {
+	b, err := os.ReadFile(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		k, err := newIdentityKey(time.Now().Unix())
+		if err != nil {
+			return nil, err
+		}
+		keys := []identityKey{k}
+		if err := writeIdentityKeys(filename, keys); err != nil {
+			return nil, err
+		}
+		return keys, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var f identityKeyFile
+	if err := json.Unmarshal(b, &f); err != nil || len(f.Keys) == 0 {
+		return nil, fmt.Errorf("invalid identity key %v", filename)
+	}
+	keys := make([]identityKey, 0, len(f.Keys))
+	for _, k := range f.Keys {
+		seed, err := hex.DecodeString(k.Seed)
+		if err != nil || len(seed) != ed25519.SeedSize {
+			return nil, fmt.Errorf("invalid identity key %v",
+				filename)
+		}
+		keys = append(keys, identityKey{
+			key:       ed25519.NewKeyFromSeed(seed),
+			notBefore: k.NotBefore,
+			notAfter:  k.NotAfter,
+		})
+	}
+	sort.SliceStable(keys, func(i, j int) bool {
+		return keys[i].notBefore < keys[j].notBefore
+	})
+	return keys, nil
+}
+
+// writeIdentityKeys replaces the identity keys file with the provided keys.
+// The file is replaced atomically so that an interrupted write does not lose
+// the keys.
+func writeIdentityKeys(filename string, keys []identityKey) error {
+	f := identityKeyFile{
+		Keys: make([]identityKeyEntry, 0, len(keys)),
+	}
+	for _, k := range keys {
+		f.Keys = append(f.Keys, identityKeyEntry{
+			Seed:      hex.EncodeToString(k.key.Seed()),
+			NotBefore: k.notBefore,
+			NotAfter:  k.notAfter,
+		})
+	}
+	b, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// The directory does not exist yet when the keys are created before
+	// the first start.
+	if err := os.MkdirAll(filepath.Dir(filename), 0700); err != nil {
+		return err
+	}
+	tmp := filename + ".tmp"
+	if err := os.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filename)
+}
+
+// rotateIdentityKeys returns the provided keys and a new identity key that
+// takes over after the overlap period that starts at now.  The keys that sign
+// until then are retired when the new key takes over.  Only one rotation may
+// be pending at a time.
+func rotateIdentityKeys(keys []identityKey, now int64, overlap time.Duration) ([]identityKey, error) {
+	if overlap < 0 {
+		return nil, fmt.Errorf("invalid overlap: %v", overlap)
+	}
+	for _, k := range keys {
+		if k.notBefore > now {
+			return nil, fmt.Errorf("key %x takes over at %v, "+
+				"rotation already pending", k.key.Public(),
+				v2.FormatTime(k.notBefore))
+		}
+	}
+
+	takeover := now + int64(overlap/time.Second)
+	k, err := newIdentityKey(takeover)
+	if err != nil {
+		return nil, err
+	}
+	rotated := make([]identityKey, 0, len(keys)+1)
+	for _, old := range keys {
+		if old.notAfter == 0 || old.notAfter > takeover {
+			old.notAfter = takeover
+		}
+		rotated = append(rotated, old)
+	}
+	return append(rotated, k), nil
+}
+
+// activeIdentityKey returns the key of the provided keys that signs at the
+// provided unix time, the newest key that took over by then.
+func activeIdentityKey(keys []identityKey, now int64) ed25519.PrivateKey {
+	active := keys[0].key
+	for _, k := range keys[1:] {
+		if k.notBefore <= now {
+			active = k.key
+		}
+	}
+	return active
+}
+
+// publicIdentityKeys returns the public keys of the identity keys.
+func publicIdentityKeys(keys []identityKey) []v2.IdentityKey {
+	pks := make([]v2.IdentityKey, 0, len(keys))
+	for _, k := range keys {
+		pks = append(pks, v2.IdentityKey{
+			PublicKey: hex.EncodeToString(k.key.Public().(ed25519.PublicKey)),
+			NotBefore: k.notBefore,
+			NotAfter:  k.notAfter,
+		})
+	}
+	return pks
+}
+
+// activeKey returns the identity key that currently signs timestamp and verify
+// replies.
+func (d *DcrtimeStore) activeKey() ed25519.PrivateKey {
+	return activeIdentityKey(d.identityKeys, time.Now().Unix())
+}
+
+// exportIdentity writes the trust bundle of the identity keys that are kept
+// next to the data directory to the provided file, or prints it if filename is
+// empty.
+func exportIdentity(cfg *config, filename string) error {
+	keys, err := loadIdentityKeys(cfg.DataDir + identityKeySuffix)
+	if err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(v2.TrustBundle{
+		Version: v2.TrustBundleVersion,
+		Keys:    publicIdentityKeys(keys),
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if filename == "" {
+		fmt.Println(string(b))
+		return nil
+	}
+	return os.WriteFile(filename, append(b, '\n'), 0644)
+}
+
+// rotateIdentity adds a new identity key that takes over once the overlap
+// period ends.  The running dcrtimed publishes the new key once it is
+// restarted.
+func rotateIdentity(cfg *config, overlap time.Duration) error {
+	filename := cfg.DataDir + identityKeySuffix
+	keys, err := loadIdentityKeys(filename)
+	if err != nil {
+		return err
+	}
+	keys, err = rotateIdentityKeys(keys, time.Now().Unix(), overlap)
+	if err != nil {
+		return err
+	}
+	if err := writeIdentityKeys(filename, keys); err != nil {
+		return err
+	}
+	k := keys[len(keys)-1]
+	log.Infof("Identity key %x takes over at %v", k.key.Public(),
+		v2.FormatTime(k.notBefore))
+	return nil
+}
+
+// signedWriter holds back a reply so that its body can be signed before it is
+// sent.
+type signedWriter struct {
+	http.ResponseWriter
+
+	code int
+	body bytes.Buffer
+}
+
+func (w *signedWriter) WriteHeader(code int) {
+	w.code = code
+}
+
+func (w *signedWriter) Write(b []byte) (int, error) {
+	return w.body.Write(b)
+}
+
+// signReplies signs the body of the successful replies of the provided
+// handler with the identity key.  The hex encoded signature is returned in the
+// signature header.
+func (d *DcrtimeStore) signReplies(f http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sw := &signedWriter{
+			ResponseWriter: w,
+			code:           http.StatusOK,
+		}
+		f(sw, r)
+
+		if sw.code == http.StatusOK {
+			sig := ed25519.Sign(d.activeKey(), sw.body.Bytes())
+			w.Header().Set(v2.SignatureHeader, hex.EncodeToString(sig))
+		}
+		w.WriteHeader(sw.code)
+		if _, err := w.Write(sw.body.Bytes()); err != nil {
+			log.Errorf("Error responding to client: %v", err)
+		}
+	}
+}
+
+// identityV2 returns the public key that verifies the signatures of timestamp
+// and verify replies and all identity keys of the server.
+// Handles /v2/identity
+func (d *DcrtimeStore) identityV2(w http.ResponseWriter, r *http.Request) {
+	log.Infof("%v Identity %v", r.URL.Path, logAddr(r))
+
+	pk := d.activeKey().Public().(ed25519.PublicKey)
+	util.RespondWithJSON(w, http.StatusOK, v2.IdentityReply{
+		PublicKey: hex.EncodeToString(pk),
+		Keys:      publicIdentityKeys(d.identityKeys),
+	})
+}
+
+// proxyIdentityV2 returns the identity of the storehost, which signs the
+// replies that are relayed.
+func (d *DcrtimeStore) proxyIdentityV2(w http.ResponseWriter, r *http.Request) {
+	d.sendToBackend(r.Context(), w, r.Method, v2.IdentityRoute,
+		r.Header.Get("Content-Type"), r.RemoteAddr,
+		bytes.NewReader([]byte{}))
+
+	log.Infof("%v Identity %v", r.URL.Path, logAddr(r))
+}
@@ -0,0 +1,187 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	v1 "github.com/decred/dcrtime/api/v1"
+	v2 "github.com/decred/dcrtime/api/v2"
+)
+
+// identity returns the public key of the identity route.
+func (s *testStore) identity(t *testing.T) ed25519.PublicKey {
+	t.Helper()
+
+	var ir v2.IdentityReply
+	code, _, _ := s.do(t, http.MethodGet, v2.IdentityRoute, nil, &ir)
+	if code != http.StatusOK {
+		t.Fatalf("identity: got status %v", code)
+	}
+	pk, err := hex.DecodeString(ir.PublicKey)
+	if err != nil || len(pk) != ed25519.PublicKeySize {
+		t.Fatalf("identity: invalid public key %q", ir.PublicKey)
+	}
+	return pk
+}
+
+func TestSignedReplies(t *testing.T) {
+	s := newTestStore(t, testConfig(t))
+	pk := s.identity(t)
+
+	digests := testDigests("signed", 2)
+	tests := []struct {
+		name    string
+		route   string
+		request interface{}
+	}{
+		{"timestamp v1", v1.TimestampRoute, v1.Timestamp{
+			Digests: digests[:1],
+		}},
+		{"verify v1", v1.VerifyRoute, v1.Verify{
+			Digests: digests[:1],
+		}},
+		{"timestamp batch", v2.TimestampBatchRoute, v2.TimestampBatch{
+			Digests: digests[1:],
+		}},
+		{"verify batch", v2.VerifyBatchRoute, v2.VerifyBatch{
+			Digests: digests,
+		}},
+	}
+	for _, test := range tests {
+		code, resp, body := s.do(t, http.MethodPost, test.route,
+			test.request, nil)
+		if code != http.StatusOK {
+			t.Fatalf("%v: got status %v", test.name, code)
+		}
+		sig, err := hex.DecodeString(resp.Header.Get(v2.SignatureHeader))
+		if err != nil {
+			t.Fatalf("%v: %v", test.name, err)
+		}
+
+		// The signature covers the exact bytes of the body.
+		if !ed25519.Verify(pk, body, sig) {
+			t.Fatalf("%v: signature does not verify", test.name)
+		}
+		err = v2.VerifyReplySignature(hex.EncodeToString(pk),
+			hex.EncodeToString(sig), body)
+		if err != nil {
+			t.Fatalf("%v: %v", test.name, err)
+		}
+
+		// Semantically equal but reencoded bodies don't verify.
+		var reply map[string]interface{}
+		if err := json.Unmarshal(body, &reply); err != nil {
+			t.Fatal(err)
+		}
+		reencoded, err := json.MarshalIndent(reply, "", " ")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Equal(reencoded, body) {
+			t.Fatalf("%v: reencoded body unchanged", test.name)
+		}
+		if ed25519.Verify(pk, reencoded, sig) {
+			t.Fatalf("%v: signature verifies reencoded body",
+				test.name)
+		}
+		tampered := bytes.Replace(body, []byte(`"id"`), []byte(`"Id"`), 1)
+		if ed25519.Verify(pk, tampered, sig) {
+			t.Fatalf("%v: signature verifies tampered body",
+				test.name)
+		}
+	}
+
+	// Failed requests are not signed.
+	code, resp, _ := s.do(t, http.MethodPost, v2.TimestampBatchRoute,
+		v2.TimestampBatch{
+			Digests: []string{"invalid"},
+		}, nil)
+	if code == http.StatusOK {
+		t.Fatalf("invalid digest: got status %v", code)
+	}
+	if sig := resp.Header.Get(v2.SignatureHeader); sig != "" {
+		t.Fatalf("error reply signed: %v", sig)
+	}
+}
+
+func TestIdentityKeys(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "data"+identityKeySuffix)
+
+	// The first key is created on first use and kept.
+	keys, err := loadIdentityKeys(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloaded, err := loadIdentityKeys(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || len(reloaded) != 1 ||
+		!keys[0].key.Equal(reloaded[0].key) ||
+		keys[0].notBefore != reloaded[0].notBefore {
+		t.Fatalf("got keys %v, reloaded %v", keys, reloaded)
+	}
+
+	// The new key of a rotation takes over after the overlap period and
+	// retires the old key.
+	now := time.Now().Unix()
+	rotated, err := rotateIdentityKeys(keys, now, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rotated) != 2 || rotated[0].notAfter != now+3600 ||
+		rotated[1].notBefore != now+3600 || rotated[1].notAfter != 0 {
+		t.Fatalf("got rotated keys %+v", rotated)
+	}
+	if !activeIdentityKey(rotated, now+3599).Equal(keys[0].key) {
+		t.Fatal("new key active before it takes over")
+	}
+	if !activeIdentityKey(rotated, now+3600).Equal(rotated[1].key) {
+		t.Fatal("new key not active once it takes over")
+	}
+	if _, err := rotateIdentityKeys(rotated, now, time.Hour); err == nil {
+		t.Fatal("expected pending rotation to fail")
+	}
+	if _, err := rotateIdentityKeys(keys, now, -time.Hour); err == nil {
+		t.Fatal("expected negative overlap to fail")
+	}
+
+	if err := writeIdentityKeys(filename, rotated); err != nil {
+		t.Fatal(err)
+	}
+	reloaded, err = loadIdentityKeys(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reloaded) != len(rotated) {
+		t.Fatalf("got %v keys, want %v", len(reloaded), len(rotated))
+	}
+	for i := range rotated {
+		if !reloaded[i].key.Equal(rotated[i].key) ||
+			reloaded[i].notBefore != rotated[i].notBefore ||
+			reloaded[i].notAfter != rotated[i].notAfter {
+			t.Fatalf("key %v: got %+v, want %+v", i, reloaded[i],
+				rotated[i])
+		}
+	}
+
+	for _, b := range []string{"", "{}", `{"keys":[{"seed":"00"}]}`} {
+		if err := os.WriteFile(filename, []byte(b), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := loadIdentityKeys(filename); err == nil {
+			t.Fatalf("%q: expected invalid identity key", b)
+		}
+	}
+}
@@ -0,0 +1,311 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	v2 "github.com/decred/dcrtime/api/v2"
+	"github.com/decred/dcrtime/dcrtimed/backend"
+)
+
+const (
+	// ingestBatch is the maximum number of messages that are pulled from
+	// the ingest consumer at once.
+	ingestBatch = 256
+
+	// ingestExpires is the time the server keeps a pull request open while
+	// it waits for messages.
+	ingestExpires = 30 * time.Second
+
+	// ingestNakDelay is the time after which a message is redelivered when
+	// it could not be stored.
+	ingestNakDelay = 10 * time.Second
+
+	// ingestMaxBackoff is the maximum time between reconnects to the NATS
+	// server or the Kafka brokers.
+	ingestMaxBackoff = time.Minute
+)
+
+// ingestResult is the outcome of storing an ingested message.
+type ingestResult int
+
+const (
+	ingestStored  ingestResult = iota // Digests stored or timestamped before
+	ingestInvalid                     // Message can never be stored
+	ingestRetry                       // Storing failed, try again later
+)
+
+// natsPullRequest requests the next messages of a JetStream pull consumer.
+type natsPullRequest struct {
+	Batch   int   `json:"batch"`
+	Expires int64 `json:"expires"` // In nanoseconds
+}
+
+// ingestGroup holds the digests of the ingested messages that share a group
+// label and digest algorithm and are therefore stored together.
+type ingestGroup struct {
+	label     string
+	algorithm string
+	digests   [][sha256.Size]byte
+	seen      map[[sha256.Size]byte]struct{}
+	msgs      []int // Indexes of the messages
+}
+
+// decodeIngestMsg decodes and validates a message of the ingest consumer.
+func decodeIngestMsg(data []byte) (*v2.TimestampBatch, [][sha256.Size]byte, error) {
+	var t v2.TimestampBatch
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&t); err != nil {
+		return nil, nil, fmt.Errorf("invalid payload: %v", err)
+	}
+	if len(t.Digests) == 0 {
+		return nil, nil, errors.New("no digests")
+	}
+	digests, err := convertDigests(t.Digests)
+	if err != nil {
+		return nil, nil, errors.New("invalid digests")
+	}
+	if t.Label != "" && !v2.RegexpLabel.MatchString(t.Label) {
+		return nil, nil, errors.New("invalid label")
+	}
+	if !v2.IsAlgorithm(t.Algorithm) {
+		return nil, nil, fmt.Errorf("unsupported algorithm %v",
+			t.Algorithm)
+	}
+	return &t, digests, nil
+}
+
+// ack acknowledges a JetStream message with the provided acknowledgement,
+// e.g. +ACK.
+func ack(nc *natsConn, m *natsMsg, a string) error {
+	if m.reply == "" {
+		return nil
+	}
+	return nc.publish(m.reply, "", []byte(a))
+}
+
+// storeIngested stores the digests of the ingested messages with the provided
+// payloads and returns the outcome of every message.  Digests that were
+// already timestamped, e.g. because a message was redelivered, count as
+// stored.
+func (d *DcrtimeStore) storeIngested(payloads [][]byte) []ingestResult {
+	results := make([]ingestResult, len(payloads))
+
+	var groups []*ingestGroup
+	for k, payload := range payloads {
+		t, digests, err := decodeIngestMsg(payload)
+		if err != nil {
+			log.Warnf("Ingest: %v", err)
+			results[k] = ingestInvalid
+			continue
+		}
+
+		var g *ingestGroup
+		for _, v := range groups {
+			if v.label == t.Label && v.algorithm == t.Algorithm {
+				g = v
+				break
+			}
+		}
+		if g == nil {
+			g = &ingestGroup{
+				label:     t.Label,
+				algorithm: t.Algorithm,
+				seen:      make(map[[sha256.Size]byte]struct{}),
+			}
+			groups = append(groups, g)
+		}
+		for _, digest := range digests {
+			if _, ok := g.seen[digest]; ok {
+				continue
+			}
+			g.seen[digest] = struct{}{}
+			g.digests = append(g.digests, digest)
+		}
+		g.msgs = append(g.msgs, k)
+	}
+
+	for _, g := range groups {
+		result := ingestStored
+		ts, me, err := d.backend.Put(g.digests, g.label,
+			storedAlgorithm(g.algorithm))
+		if err != nil {
+			if !errors.Is(err, backend.ErrTryAgainLater) &&
+				!errors.Is(err, backend.ErrQueueFull) {
+				log.Errorf("Ingest: %v", err)
+			}
+			result = ingestRetry
+		} else {
+			var accepted int
+			for _, v := range me {
+				if v.ErrorCode == backend.ErrorOK {
+					accepted++
+				}
+			}
+			log.Infof("Ingest: accepted %v rejected %v %v",
+				accepted, len(me)-accepted,
+				time.Unix(ts, 0).UTC().Format(fStr))
+			d.recordSubmission("-", ts, me)
+		}
+		for _, k := range g.msgs {
+			results[k] = result
+		}
+	}
+
+	return results
+}
+
+// ackIngested acknowledges the pulled messages according to the outcome of
+// storing them.  Messages that could not be stored are redelivered after a
+// delay and invalid messages are terminated so that they are never
+// redelivered.
+func ackIngested(nc *natsConn, msgs []*natsMsg, results []ingestResult) error {
+	nak := fmt.Sprintf("-NAK {\"delay\":%d}", ingestNakDelay.Nanoseconds())
+	for k, m := range msgs {
+		a := "+ACK"
+		switch results[k] {
+		case ingestInvalid:
+			a = "+TERM"
+		case ingestRetry:
+			a = nak
+		}
+		if err := ack(nc, m, a); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ingestNATS pulls timestamp requests from the ingest consumer until the
+// connection fails.
+func (d *DcrtimeStore) ingestNATS(u *url.URL) error {
+	nc, err := dialNATS(d.ctx, u, d.cfg.IngestCert)
+	if err != nil {
+		return err
+	}
+	defer nc.close()
+
+	var random [8]byte
+	if _, err := rand.Read(random[:]); err != nil {
+		return err
+	}
+	inbox := "_INBOX.dcrtimed." + hex.EncodeToString(random[:])
+	if err := nc.subscribe(inbox); err != nil {
+		return err
+	}
+	log.Infof("Ingesting from %v stream %v consumer %v", u.Redacted(),
+		d.cfg.IngestStream, d.cfg.IngestConsumer)
+
+	subject := fmt.Sprintf("$JS.API.CONSUMER.MSG.NEXT.%v.%v",
+		d.cfg.IngestStream, d.cfg.IngestConsumer)
+	pull, err := json.Marshal(natsPullRequest{
+		Batch:   ingestBatch,
+		Expires: ingestExpires.Nanoseconds(),
+	})
+	if err != nil {
+		return err
+	}
+	for d.ctx.Err() == nil {
+		err := nc.publish(subject, inbox, pull)
+		if err != nil {
+			return err
+		}
+
+		// Collect messages until the batch is complete or the pull
+		// request expired.
+		msgs := make([]*natsMsg, 0, ingestBatch)
+		deadline := time.Now().Add(ingestExpires + natsTimeout)
+	collect:
+		for len(msgs) < ingestBatch {
+			// The server answers an expired pull request so a
+			// timeout means the connection is stuck.
+			m, err := nc.next(deadline)
+			if err != nil {
+				return err
+			}
+			switch m.status {
+			case "":
+				msgs = append(msgs, m)
+			case "100":
+				// Idle heartbeat.
+			case "404", "408":
+				// No messages or pull request expired.
+				break collect
+			case "503":
+				return errors.New("jetstream not available")
+			default:
+				return fmt.Errorf("pull request status %v: %s",
+					m.status, m.data)
+			}
+		}
+		if len(msgs) == 0 {
+			continue
+		}
+
+		payloads := make([][]byte, 0, len(msgs))
+		for _, m := range msgs {
+			payloads = append(payloads, m.data)
+		}
+		err = ackIngested(nc, msgs, d.storeIngested(payloads))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ingester consumes timestamp requests from the configured JetStream consumer
+// or Kafka consumer group.  Messages are only acknowledged once their digests
+// are stored so requests that were not stored before a restart are
+// redelivered.  The connection is reestablished with an increasing backoff
+// when it fails.
+func (d *DcrtimeStore) ingester() {
+	u, err := url.Parse(d.cfg.IngestURL)
+	if err != nil {
+		// Validated by loadConfig.
+		log.Errorf("Ingest: %v", err)
+		return
+	}
+
+	backoff := time.Second
+	for {
+		start := time.Now()
+		var err error
+		if isKafkaURL(u) {
+			err = d.ingestKafka(u)
+		} else {
+			err = d.ingestNATS(u)
+		}
+		if d.ctx.Err() != nil {
+			return
+		}
+		log.Errorf("Ingest %v: %v", u.Redacted(), err)
+
+		// Start over once a connection was up for a while.
+		if time.Since(start) > ingestMaxBackoff {
+			backoff = time.Second
+		}
+		select {
+		case <-d.ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > ingestMaxBackoff {
+			backoff = ingestMaxBackoff
+		}
+	}
+}
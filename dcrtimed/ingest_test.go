@@ -0,0 +1,226 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	v2 "github.com/decred/dcrtime/api/v2"
+	"github.com/decred/dcrtime/dcrtimed/backend"
+	"github.com/segmentio/kafka-go"
+)
+
+// ingestPayload returns the payload of an ingested timestamp request.
+func ingestPayload(t *testing.T, label string, digests []string) []byte {
+	t.Helper()
+
+	b, err := json.Marshal(v2.TimestampBatch{
+		Label:   label,
+		Digests: digests,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// stored returns true if all digests were stored in the backend.
+func (s *testStore) stored(t *testing.T, digests []string) bool {
+	t.Helper()
+
+	converted, err := convertDigests(digests)
+	if err != nil {
+		t.Fatal(err)
+	}
+	grs, err := s.b.Get(converted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, gr := range grs {
+		if gr.ErrorCode != backend.ErrorOK {
+			return false
+		}
+	}
+	return true
+}
+
+// failingBackend refuses to store digests.
+type failingBackend struct {
+	backend.Backend
+
+	puts chan struct{}
+}
+
+func (b *failingBackend) Put([][sha256.Size]byte, string, string) (int64, []backend.PutResult, error) {
+	select {
+	case b.puts <- struct{}{}:
+	default:
+	}
+	return 0, nil, backend.ErrTryAgainLater
+}
+
+func TestStoreIngested(t *testing.T) {
+	s := newTestStore(t, testConfig(t))
+
+	digests := testDigests("ingest", 4)
+	payloads := [][]byte{
+		ingestPayload(t, "", digests[:2]),
+		ingestPayload(t, "", digests[1:3]),
+		ingestPayload(t, "label", digests[3:]),
+		[]byte(`{"digests":["invalid"]}`),
+		[]byte(`{"digests":[]}`),
+		[]byte(`{"unknown":true}`),
+		[]byte(`not json`),
+	}
+	want := []ingestResult{ingestStored, ingestStored, ingestStored,
+		ingestInvalid, ingestInvalid, ingestInvalid, ingestInvalid}
+	results := s.storeIngested(payloads)
+	if !reflect.DeepEqual(results, want) {
+		t.Fatalf("got results %v, want %v", results, want)
+	}
+	if !s.stored(t, digests) {
+		t.Fatal("digests not stored")
+	}
+
+	// Redelivered messages are stored already.
+	results = s.storeIngested(payloads[:3])
+	if !reflect.DeepEqual(results, want[:3]) {
+		t.Fatalf("redelivered: got results %v", results)
+	}
+
+	// Messages that could not be stored are retried.
+	s.backend = &failingBackend{Backend: s.b}
+	results = s.storeIngested([][]byte{
+		ingestPayload(t, "", testDigests("retry", 1)),
+		payloads[3],
+	})
+	want = []ingestResult{ingestRetry, ingestInvalid}
+	if !reflect.DeepEqual(results, want) {
+		t.Fatalf("failing backend: got results %v, want %v", results,
+			want)
+	}
+}
+
+// fakeKafka is a kafka reader that delivers the messages that are sent to it
+// and records the committed messages.
+type fakeKafka struct {
+	msgs    chan kafka.Message
+	commits chan []kafka.Message
+}
+
+func newFakeKafka() *fakeKafka {
+	return &fakeKafka{
+		msgs:    make(chan kafka.Message, ingestBatch),
+		commits: make(chan []kafka.Message, ingestBatch),
+	}
+}
+
+func (f *fakeKafka) FetchMessage(ctx context.Context) (kafka.Message, error) {
+	select {
+	case m := <-f.msgs:
+		return m, nil
+	case <-ctx.Done():
+		return kafka.Message{}, ctx.Err()
+	}
+}
+
+func (f *fakeKafka) CommitMessages(ctx context.Context, msgs ...kafka.Message) error {
+	f.commits <- msgs
+	return nil
+}
+
+func (f *fakeKafka) Close() error {
+	return nil
+}
+
+// consume runs consumeKafka until the returned function is called, which
+// returns its error.
+func (s *testStore) consume(t *testing.T, r kafkaReader) func() error {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.ctx = ctx
+	errc := make(chan error, 1)
+	go func() {
+		errc <- s.consumeKafka(r)
+	}()
+	return func() error {
+		cancel()
+		return <-errc
+	}
+}
+
+func TestConsumeKafka(t *testing.T) {
+	s := newTestStore(t, testConfig(t))
+	f := newFakeKafka()
+	stop := s.consume(t, f)
+
+	digests := testDigests("kafka", 3)
+	f.msgs <- kafka.Message{Offset: 1, Value: ingestPayload(t, "",
+		digests[:2])}
+	f.msgs <- kafka.Message{Offset: 2, Value: []byte("invalid")}
+	f.msgs <- kafka.Message{Offset: 3, Value: ingestPayload(t, "label",
+		digests[2:])}
+
+	// Messages, including invalid ones, are committed once the digests
+	// were stored.
+	var offsets []int64
+	for len(offsets) < 3 {
+		select {
+		case msgs := <-f.commits:
+			if !s.stored(t, digests) {
+				t.Fatal("committed before the digests were stored")
+			}
+			for _, m := range msgs {
+				offsets = append(offsets, m.Offset)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("got commits %v", offsets)
+		}
+	}
+	if !reflect.DeepEqual(offsets, []int64{1, 2, 3}) {
+		t.Fatalf("got offsets %v", offsets)
+	}
+
+	if err := stop(); err != nil && !errors.Is(err, context.Canceled) {
+		t.Fatal(err)
+	}
+}
+
+func TestConsumeKafkaRetry(t *testing.T) {
+	s := newTestStore(t, testConfig(t))
+	fb := &failingBackend{
+		Backend: s.b,
+		puts:    make(chan struct{}, 1),
+	}
+	s.backend = fb
+	f := newFakeKafka()
+	stop := s.consume(t, f)
+
+	f.msgs <- kafka.Message{Offset: 1, Value: ingestPayload(t, "",
+		testDigests("retry", 1))}
+	select {
+	case <-fb.puts:
+	case <-time.After(5 * time.Second):
+		t.Fatal("message not stored")
+	}
+
+	// Messages that were not stored are not committed so that they are
+	// consumed again after a restart.
+	if err := stop(); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got error %v", err)
+	}
+	select {
+	case msgs := <-f.commits:
+		t.Fatalf("committed %v", msgs)
+	default:
+	}
+}
@@ -0,0 +1,145 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/decred/dcrtime/util"
+)
+
+// Endpoint classes that ip policies apply to.  The empty class applies to all
+// endpoints.
+const (
+	ipClassAll    = ""
+	ipClassSubmit = "submit"
+	ipClassVerify = "verify"
+)
+
+// ipPolicy decides which client addresses may use a class of endpoints.
+type ipPolicy struct {
+	allowed []netip.Prefix // Empty allows every address that is not banned
+	banned  []netip.Prefix
+}
+
+// permits returns true if the provided address may use the endpoints of the
+// policy.  Banned addresses are refused even if they are allowed.
+func (p *ipPolicy) permits(addr netip.Addr) bool {
+	for _, prefix := range p.banned {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	if len(p.allowed) == 0 {
+		return true
+	}
+	for _, prefix := range p.allowed {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseIPPrefix parses a CIDR prefix or a single address, which is treated as
+// a prefix that only contains itself.  IPv4-mapped IPv6 prefixes are turned
+// into IPv4 prefixes since client addresses are unmapped.
+func parseIPPrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		if addr := prefix.Addr(); addr.Is4In6() && prefix.Bits() >= 96 {
+			prefix = netip.PrefixFrom(addr.Unmap(), prefix.Bits()-96)
+		}
+		return prefix.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// parseIPPolicies parses allowedips and bannedips entries of the form
+// [class:]prefix and returns the policy of every class that has entries.  The
+// class is submit or verify; entries without a class apply to all endpoints.
+func parseIPPolicies(allowed, banned []string) (map[string]*ipPolicy, error) {
+	policies := make(map[string]*ipPolicy)
+	parse := func(option string, values []string, ban bool) error {
+		for _, v := range values {
+			class, s := ipClassAll, v
+			for _, c := range []string{ipClassSubmit, ipClassVerify} {
+				if strings.HasPrefix(v, c+":") {
+					class, s = c, strings.TrimPrefix(v, c+":")
+					break
+				}
+			}
+			prefix, err := parseIPPrefix(s)
+			if err != nil {
+				return fmt.Errorf("invalid %v %q: %v", option, v, err)
+			}
+
+			p, ok := policies[class]
+			if !ok {
+				p = &ipPolicy{}
+				policies[class] = p
+			}
+			if ban {
+				p.banned = append(p.banned, prefix)
+			} else {
+				p.allowed = append(p.allowed, prefix)
+			}
+		}
+		return nil
+	}
+	if err := parse("allowedips", allowed, false); err != nil {
+		return nil, err
+	}
+	if err := parse("bannedips", banned, true); err != nil {
+		return nil, err
+	}
+
+	return policies, nil
+}
+
+// remoteIP returns the address of the client of the request.  Forwarded
+// addresses are only used when the request came from a trusted proxy, see
+// trustProxies.
+func remoteIP(r *http.Request) (netip.Addr, bool) {
+	return parseHostAddr(r.RemoteAddr)
+}
+
+// requireIP wraps the provided handler so that it is only invoked for clients
+// whose address is permitted by the ip policy of the provided endpoint class.
+// Unix socket clients are always permitted.  The handler is returned as is if
+// the class has no policy.
+func (d *DcrtimeStore) requireIP(class string, f http.HandlerFunc) http.HandlerFunc {
+	p, ok := d.ipPolicies[class]
+	if !ok {
+		return f
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if isUnixSocket(r) {
+			f(w, r)
+			return
+		}
+		addr, ok := remoteIP(r)
+		if !ok || !p.permits(addr) {
+			r.Body.Close()
+
+			log.Debugf("%v refused address %v", r.URL.Path, logAddr(r))
+			util.RespondWithError(w, http.StatusForbidden,
+				"Forbidden")
+			return
+		}
+		f(w, r)
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseIPPolicies(t *testing.T) {
+	tests := []struct {
+		name    string
+		allowed []string
+		banned  []string
+		valid   bool
+	}{
+		{"empty", nil, nil, true},
+		{"address", []string{"192.0.2.1"}, nil, true},
+		{"cidr", []string{"192.0.2.0/24"}, []string{"2001:db8::/32"},
+			true},
+		{"classes", []string{"submit:10.0.0.0/8"},
+			[]string{"verify:192.0.2.1"}, true},
+		{"mapped", []string{"::ffff:192.0.2.0/120"}, nil, true},
+		{"invalid address", []string{"192.0.2.256"}, nil, false},
+		{"invalid prefix length", nil, []string{"192.0.2.0/33"}, false},
+		{"unknown class", []string{"admin:192.0.2.1"}, nil, false},
+		{"hostname", nil, []string{"example.com"}, false},
+		{"blank", []string{""}, nil, false},
+	}
+	for _, test := range tests {
+		_, err := parseIPPolicies(test.allowed, test.banned)
+		if (err == nil) != test.valid {
+			t.Errorf("%v: got error %v, want valid %v", test.name,
+				err, test.valid)
+		}
+	}
+}
+
+func TestRequireIP(t *testing.T) {
+	policies, err := parseIPPolicies([]string{
+		"10.0.0.0/8",
+		"2001:db8::/32",
+		"::ffff:172.16.0.0/108",
+		"submit:10.1.0.0/16",
+		"verify:192.0.2.7",
+	}, []string{
+		"10.0.0.66",
+		"10.2.0.0/16",
+		"2001:db8:bad::/48",
+		"submit:10.1.2.0/24",
+		"verify:192.0.2.0/24",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &DcrtimeStore{ipPolicies: policies}
+
+	tests := []struct {
+		name       string
+		class      string
+		remoteAddr string
+		want       bool
+	}{
+		// Entries without a class apply to all endpoints.
+		{"allowed cidr", ipClassAll, "10.3.4.5:1234", true},
+		{"outside allowed", ipClassAll, "192.168.1.1:1234", false},
+		{"banned address", ipClassAll, "10.0.0.66:1234", false},
+		{"ban overrides allow", ipClassAll, "10.2.3.4:1234", false},
+		{"allowed ipv6", ipClassAll, "[2001:db8::1]:1234", true},
+		{"banned ipv6", ipClassAll, "[2001:db8:bad::1]:1234", false},
+
+		// IPv4-mapped IPv6 clients match IPv4 entries and the other
+		// way around.
+		{"mapped client", ipClassAll, "[::ffff:10.3.4.5]:1234", true},
+		{"mapped banned client", ipClassAll,
+			"[::ffff:10.0.0.66]:1234", false},
+		{"mapped entry", ipClassAll, "172.16.1.1:1234", true},
+		{"outside mapped entry", ipClassAll, "172.32.1.1:1234", false},
+
+		// Classes have their own policy.
+		{"submit allowed", ipClassSubmit, "10.1.3.4:1234", true},
+		{"submit not allowed", ipClassSubmit, "10.3.4.5:1234", false},
+		{"submit banned", ipClassSubmit, "10.1.2.3:1234", false},
+		{"verify ban overrides allow", ipClassVerify, "192.0.2.7:1234",
+			false},
+		{"verify not allowed", ipClassVerify, "198.51.100.1:1234",
+			false},
+
+		{"address without port", ipClassAll, "10.3.4.5", true},
+		{"invalid address", ipClassAll, "invalid", false},
+		{"unix socket", ipClassAll, unixListenerPrefix + "/tmp/sock",
+			true},
+	}
+	for _, test := range tests {
+		var called bool
+		h := d.requireIP(test.class, func(w http.ResponseWriter, r *http.Request) {
+			called = true
+		})
+		r := httptest.NewRequest(http.MethodPost, "/", nil)
+		r.RemoteAddr = test.remoteAddr
+		w := httptest.NewRecorder()
+		h(w, r)
+		if called != test.want {
+			t.Errorf("%v: got permitted %v, want %v", test.name,
+				called, test.want)
+		}
+		if !called && w.Code != http.StatusForbidden {
+			t.Errorf("%v: got status %v", test.name, w.Code)
+		}
+	}
+
+	// Classes without a policy are not filtered.
+	d = &DcrtimeStore{ipPolicies: map[string]*ipPolicy{}}
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+	r.RemoteAddr = "192.168.1.1:1234"
+	var called bool
+	d.requireIP(ipClassSubmit, func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	})(httptest.NewRecorder(), r)
+	if !called {
+		t.Fatal("no policy: refused")
+	}
+}
@@ -0,0 +1,185 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl/plain"
+)
+
+const (
+	// kafkaDefaultPort is the default port of a Kafka broker.
+	kafkaDefaultPort = "9092"
+
+	// kafkaLinger is the time that is waited for more messages before a
+	// partial batch of messages is stored.
+	kafkaLinger = 100 * time.Millisecond
+)
+
+// regexpKafkaName matches valid Kafka topic and consumer group names.
+var regexpKafkaName = regexp.MustCompile(`^[a-zA-Z0-9._-]{1,249}$`)
+
+// kafkaReader is the part of a kafka.Reader that is used to consume timestamp
+// requests.
+type kafkaReader interface {
+	FetchMessage(ctx context.Context) (kafka.Message, error)
+	CommitMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
+}
+
+// isKafkaURL returns true if the ingest URL points at Kafka brokers rather
+// than a NATS server.
+func isKafkaURL(u *url.URL) bool {
+	return u.Scheme == "kafka" || u.Scheme == "kafka+tls"
+}
+
+// newKafkaReader returns a reader of the consumer group of the topic at the
+// comma separated brokers of the kafka:// or kafka+tls:// URL.  The user
+// information of the URL is sent as SASL/PLAIN user and password.  certFile
+// optionally holds the certificate authority of the brokers.  Offsets are
+// committed explicitly.
+func newKafkaReader(u *url.URL, topic, group, certFile string) (*kafka.Reader, error) {
+	var brokers []string
+	for _, host := range strings.Split(u.Host, ",") {
+		if _, _, err := net.SplitHostPort(host); err != nil {
+			host = net.JoinHostPort(host, kafkaDefaultPort)
+		}
+		brokers = append(brokers, host)
+	}
+
+	dialer := &kafka.Dialer{
+		Timeout:   natsTimeout,
+		DualStack: true,
+		ClientID:  "dcrtimed",
+	}
+	if u.Scheme == "kafka+tls" {
+		dialer.TLS = &tls.Config{}
+		if certFile != "" {
+			cert, err := os.ReadFile(certFile)
+			if err != nil {
+				return nil, err
+			}
+			dialer.TLS.RootCAs = x509.NewCertPool()
+			if !dialer.TLS.RootCAs.AppendCertsFromPEM(cert) {
+				return nil, fmt.Errorf("unable to load cert %v",
+					certFile)
+			}
+		}
+	}
+	if u.User != nil {
+		pass, _ := u.User.Password()
+		dialer.SASLMechanism = plain.Mechanism{
+			Username: u.User.Username(),
+			Password: pass,
+		}
+	}
+
+	return kafka.NewReader(kafka.ReaderConfig{
+		Brokers:     brokers,
+		GroupID:     group,
+		Topic:       topic,
+		Dialer:      dialer,
+		MaxWait:     time.Second,
+		StartOffset: kafka.FirstOffset,
+		ErrorLogger: kafka.LoggerFunc(func(format string, args ...interface{}) {
+			log.Warnf("Ingest: "+format, args...)
+		}),
+	}), nil
+}
+
+// fetchKafka returns the next batch of messages.  It waits for the first
+// message and then collects messages until the batch is complete or no
+// message arrived for a while.
+func fetchKafka(ctx context.Context, r kafkaReader) ([]kafka.Message, error) {
+	m, err := r.FetchMessage(ctx)
+	if err != nil {
+		return nil, err
+	}
+	msgs := []kafka.Message{m}
+	for len(msgs) < ingestBatch {
+		lctx, cancel := context.WithTimeout(ctx, kafkaLinger)
+		m, err := r.FetchMessage(lctx)
+		cancel()
+		if errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, m)
+	}
+	return msgs, nil
+}
+
+// consumeKafka stores the timestamp requests of the reader until it fails.
+// Kafka does not redeliver single messages, so messages that could not be
+// stored are retried after a delay before the offsets of the batch are
+// committed.  Invalid messages are skipped.  Offsets that were not committed
+// when the daemon stops are consumed again after a restart.
+func (d *DcrtimeStore) consumeKafka(r kafkaReader) error {
+	for d.ctx.Err() == nil {
+		msgs, err := fetchKafka(d.ctx, r)
+		if err != nil {
+			return err
+		}
+
+		pending := make([][]byte, 0, len(msgs))
+		for _, m := range msgs {
+			pending = append(pending, m.Value)
+		}
+		for len(pending) != 0 {
+			var retry [][]byte
+			for k, result := range d.storeIngested(pending) {
+				if result == ingestRetry {
+					retry = append(retry, pending[k])
+				}
+			}
+			pending = retry
+			if len(pending) == 0 {
+				break
+			}
+			select {
+			case <-d.ctx.Done():
+				return d.ctx.Err()
+			case <-time.After(ingestNakDelay):
+			}
+		}
+
+		err = r.CommitMessages(d.ctx, msgs...)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ingestKafka consumes timestamp requests from the ingest consumer group until
+// the reader fails.
+func (d *DcrtimeStore) ingestKafka(u *url.URL) error {
+	r, err := newKafkaReader(u, d.cfg.IngestStream, d.cfg.IngestConsumer,
+		d.cfg.IngestCert)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	log.Infof("Ingesting from %v topic %v consumer group %v", u.Redacted(),
+		d.cfg.IngestStream, d.cfg.IngestConsumer)
+
+	return d.consumeKafka(r)
+}
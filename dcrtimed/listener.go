@@ -0,0 +1,100 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"net/http"
+)
+
+// Endpoint roles that listeners may be restricted to.  Listeners of the listen
+// option serve the endpoints of all roles.
+const (
+	rolePublic = "public" // Verification and chain information
+	roleSubmit = "submit" // Timestamping and token owner endpoints
+	roleAdmin  = "admin"  // Administrative endpoints
+)
+
+// listener is an address dcrtimed listens on.
+type listener struct {
+	addr  string
+	roles []string // Empty serves all roles
+}
+
+// serves returns true if the listener serves the endpoints of the provided
+// role.
+func (l *listener) serves(role string) bool {
+	if len(l.roles) == 0 {
+		return true
+	}
+	for _, r := range l.roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// listeners returns the addresses of the listen, publiclisten, submitlisten
+// and adminlisten options with the roles they serve.  An address that is in
+// several options serves the roles of all of them.
+func listeners(cfg *config) []listener {
+	var ls []listener
+	index := make(map[string]int)
+	add := func(addrs []string, role string) {
+		for _, addr := range addrs {
+			i, ok := index[addr]
+			if !ok {
+				index[addr] = len(ls)
+				l := listener{addr: addr}
+				if role != "" {
+					l.roles = []string{role}
+				}
+				ls = append(ls, l)
+				continue
+			}
+			if len(ls[i].roles) == 0 || role == "" {
+				ls[i].roles = nil
+				continue
+			}
+			ls[i].roles = append(ls[i].roles, role)
+		}
+	}
+	add(cfg.Listeners, "")
+	add(cfg.PublicListeners, rolePublic)
+	add(cfg.SubmitListeners, roleSubmit)
+	add(cfg.AdminListeners, roleAdmin)
+	return ls
+}
+
+// listenerKey is the context key of the listener a request was received on.
+type listenerKey struct{}
+
+// withListener returns a handler that records the listener requests were
+// received on for requireRole.
+func withListener(l listener, h http.Handler) http.Handler {
+	if len(l.roles) == 0 {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(),
+			listenerKey{}, l)))
+	})
+}
+
+// requireRole returns a handler that only serves requests that were received
+// on a listener of the provided role.  Other listeners reply as if the
+// endpoint did not exist.
+func requireRole(role string, f http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		l, ok := r.Context().Value(listenerKey{}).(listener)
+		if ok && !l.serves(role) {
+			r.Body.Close()
+			http.NotFound(w, r)
+			return
+		}
+		f(w, r)
+	}
+}
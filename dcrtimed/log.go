@@ -42,14 +42,20 @@ var (
 
 	log       = backendLog.Logger("DCRT")
 	fsbeLog   = backendLog.Logger("FSBE")
+	ldbeLog   = backendLog.Logger("LDBE")
+	sideLog   = backendLog.Logger("SIDE")
 	walletLog = backendLog.Logger("DCRW")
+	accessLog = backendLog.Logger("ACCS")
 )
 
 // subsystemLoggers maps each subsystem identifier to its associated logger.
 var subsystemLoggers = map[string]slog.Logger{
 	"DCRT": log,
 	"FSBE": fsbeLog,
+	"LDBE": ldbeLog,
+	"SIDE": sideLog,
 	"DCRW": walletLog,
+	"ACCS": accessLog,
 }
 
 // initLogRotator initializes the logging rotater to write logs to logFile and
@@ -0,0 +1,108 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"time"
+
+	v2 "github.com/decred/dcrtime/api/v2"
+	"github.com/decred/dcrtime/dcrtimed/backend"
+)
+
+// validMetadata returns an error if the provided digest metadata has an
+// invalid key or exceeds the maximum size.
+func validMetadata(md map[string]string) error {
+	var size int
+	for k, v := range md {
+		if !v2.RegexpMetadataKey.MatchString(k) {
+			return fmt.Errorf("invalid metadata key %q", k)
+		}
+		size += len(k) + len(v)
+	}
+	if size > v2.MaxMetadataSize {
+		return fmt.Errorf("metadata too large: %v > %v", size,
+			v2.MaxMetadataSize)
+	}
+	return nil
+}
+
+// metadataOwner returns the owner of the metadata of the request, which is the
+// public ID of its api token, or an empty string if the request does not carry
+// a valid api token.
+func (d *DcrtimeStore) metadataOwner(r *http.Request) string {
+	if len(d.tokenScopes(r)) == 0 {
+		return ""
+	}
+	return apiTokenID(r.URL.Query().Get("apitoken"))
+}
+
+// recordMetadata stores the metadata of the digests that were accepted, in
+// the order of the results.  Failures are logged only since the digests were
+// already timestamped.
+func (d *DcrtimeStore) recordMetadata(r *http.Request, metadata []map[string]string, private bool, me []backend.PutResult) {
+	if len(metadata) == 0 {
+		return
+	}
+
+	owner := d.metadataOwner(r)
+	now := time.Now().Unix()
+	mds := make([]backend.Metadata, 0, len(metadata))
+	for k, v := range me {
+		if v.ErrorCode != backend.ErrorOK || k >= len(metadata) ||
+			len(metadata[k]) == 0 {
+			continue
+		}
+		mds = append(mds, backend.Metadata{
+			Digest:  v.Digest,
+			Owner:   owner,
+			Private: private,
+			Created: now,
+			Values:  metadata[k],
+		})
+	}
+	if err := d.backend.PutMetadata(mds); err != nil {
+		log.Errorf("%v recordMetadata: %v", logAddr(r), err)
+	}
+}
+
+// visibleMetadata returns the metadata of the provided results that the
+// request may see, in their order.  Private metadata is only returned to the
+// api token that stored it.
+func (d *DcrtimeStore) visibleMetadata(r *http.Request, drs []backend.GetResult) ([]map[string]string, error) {
+	digests := make([][sha256.Size]byte, 0, len(drs))
+	for _, dr := range drs {
+		digests = append(digests, dr.Digest)
+	}
+	mds, err := d.backend.GetMetadata(digests)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		owner  string
+		lookup bool
+	)
+	values := make([]map[string]string, len(drs))
+	for k, md := range mds {
+		if md == nil || drs[k].ErrorCode != backend.ErrorOK {
+			continue
+		}
+		if md.Private {
+			// Only look up the api token when needed.
+			if !lookup {
+				owner = d.metadataOwner(r)
+				lookup = true
+			}
+			if owner == "" || owner != md.Owner {
+				continue
+			}
+		}
+		values[k] = md.Values
+	}
+	return values, nil
+}
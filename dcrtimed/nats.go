@@ -0,0 +1,329 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// natsDefaultPort is the default port of a NATS server.
+	natsDefaultPort = "4222"
+
+	// natsTimeout is the maximum time the NATS handshake and a single write
+	// may take.
+	natsTimeout = 10 * time.Second
+
+	// natsDefaultMaxPayload is the maximum message size of a NATS server
+	// that does not announce one.
+	natsDefaultMaxPayload = 1 << 20
+)
+
+// natsInfo is the subset of the INFO message of a NATS server that is used.
+type natsInfo struct {
+	TLSRequired bool `json:"tls_required"`
+	Headers     bool `json:"headers"`
+	MaxPayload  int  `json:"max_payload"`
+}
+
+// natsConnect is the CONNECT message that is sent to a NATS server.
+type natsConnect struct {
+	Verbose      bool   `json:"verbose"`
+	Pedantic     bool   `json:"pedantic"`
+	TLSRequired  bool   `json:"tls_required"`
+	Name         string `json:"name"`
+	Lang         string `json:"lang"`
+	Version      string `json:"version"`
+	Protocol     int    `json:"protocol"`
+	Headers      bool   `json:"headers"`
+	NoResponders bool   `json:"no_responders"`
+	AuthToken    string `json:"auth_token,omitempty"`
+	User         string `json:"user,omitempty"`
+	Pass         string `json:"pass,omitempty"`
+}
+
+// natsMsg is a message that was delivered to a subscription.  Status is the
+// status code of a message with headers, e.g. 404 when a JetStream pull
+// request found no messages, and empty for regular messages.
+type natsMsg struct {
+	subject string
+	reply   string
+	status  string
+	data    []byte
+}
+
+// natsConn is a minimal client of the NATS protocol.  It only implements what
+// is needed to pull messages from a JetStream consumer and acknowledge them.
+// A natsConn must only be used by a single goroutine.
+type natsConn struct {
+	conn       net.Conn
+	r          *bufio.Reader
+	sid        int
+	maxPayload int // Largest message the server delivers, from INFO
+}
+
+// dialNATS connects to the NATS server at the provided nats:// or tls:// URL.
+// The user information of the URL is sent as user and password or, without a
+// password, as auth token.  certFile optionally holds the certificate
+// authority of the server.
+func dialNATS(ctx context.Context, u *url.URL, certFile string) (*natsConn, error) {
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), natsDefaultPort)
+	}
+	ctx, cancel := context.WithTimeout(ctx, natsTimeout)
+	defer cancel()
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", host)
+	if err != nil {
+		return nil, err
+	}
+	nc := &natsConn{
+		conn: conn,
+		r:    bufio.NewReader(conn),
+	}
+	err = nc.handshake(ctx, u, certFile)
+	if err != nil {
+		nc.conn.Close()
+		return nil, err
+	}
+
+	return nc, nil
+}
+
+// handshake reads the INFO message of the server, upgrades the connection to
+// TLS if required and authenticates.
+func (nc *natsConn) handshake(ctx context.Context, u *url.URL, certFile string) error {
+	deadline, _ := ctx.Deadline()
+	nc.conn.SetDeadline(deadline)
+
+	line, err := nc.readLine()
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(line, "INFO ") {
+		return fmt.Errorf("unexpected greeting: %v", line)
+	}
+	var info natsInfo
+	err = json.Unmarshal([]byte(line[len("INFO "):]), &info)
+	if err != nil {
+		return fmt.Errorf("invalid INFO: %v", err)
+	}
+	if !info.Headers {
+		return errors.New("server does not support headers")
+	}
+	nc.maxPayload = info.MaxPayload
+	if nc.maxPayload <= 0 {
+		nc.maxPayload = natsDefaultMaxPayload
+	}
+
+	useTLS := u.Scheme == "tls" || info.TLSRequired
+	if useTLS {
+		config := &tls.Config{
+			ServerName: u.Hostname(),
+		}
+		if certFile != "" {
+			cert, err := os.ReadFile(certFile)
+			if err != nil {
+				return err
+			}
+			config.RootCAs = x509.NewCertPool()
+			if !config.RootCAs.AppendCertsFromPEM(cert) {
+				return fmt.Errorf("unable to load cert %v",
+					certFile)
+			}
+		}
+		tlsConn := tls.Client(nc.conn, config)
+		err := tlsConn.HandshakeContext(ctx)
+		if err != nil {
+			return err
+		}
+		nc.conn = tlsConn
+		nc.r = bufio.NewReader(tlsConn)
+		nc.conn.SetDeadline(deadline)
+	}
+
+	c := natsConnect{
+		TLSRequired:  useTLS,
+		Name:         "dcrtimed",
+		Lang:         "go",
+		Version:      version(),
+		Protocol:     1,
+		Headers:      true,
+		NoResponders: true,
+	}
+	if u.User != nil {
+		if pass, ok := u.User.Password(); ok {
+			c.User = u.User.Username()
+			c.Pass = pass
+		} else {
+			c.AuthToken = u.User.Username()
+		}
+	}
+	b, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(nc.conn, "CONNECT %s\r\nPING\r\n", b)
+	if err != nil {
+		return err
+	}
+
+	// The server replies to the PING once the connection is accepted.
+	for {
+		line, err := nc.readLine()
+		if err != nil {
+			return err
+		}
+		switch {
+		case line == "PONG":
+			nc.conn.SetDeadline(time.Time{})
+			return nil
+		case strings.HasPrefix(line, "-ERR"):
+			return fmt.Errorf("connect: %v", line)
+		}
+	}
+}
+
+// readLine reads a single protocol line without its line terminator.
+func (nc *natsConn) readLine() (string, error) {
+	line, err := nc.r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// write writes a protocol message.
+func (nc *natsConn) write(b []byte) error {
+	nc.conn.SetWriteDeadline(time.Now().Add(natsTimeout))
+	_, err := nc.conn.Write(b)
+	return err
+}
+
+// subscribe subscribes to the provided subject.
+func (nc *natsConn) subscribe(subject string) error {
+	nc.sid++
+	return nc.write([]byte(fmt.Sprintf("SUB %v %v\r\n", subject, nc.sid)))
+}
+
+// publish publishes data to the provided subject.  reply is optional.
+func (nc *natsConn) publish(subject, reply string, data []byte) error {
+	var b bytes.Buffer
+	b.WriteString("PUB " + subject + " ")
+	if reply != "" {
+		b.WriteString(reply + " ")
+	}
+	b.WriteString(strconv.Itoa(len(data)) + "\r\n")
+	b.Write(data)
+	b.WriteString("\r\n")
+	return nc.write(b.Bytes())
+}
+
+// next returns the next message that is delivered to a subscription.  Pings
+// of the server are answered while waiting.  The connection can not be used
+// anymore if no message arrived before the deadline.
+func (nc *natsConn) next(deadline time.Time) (*natsMsg, error) {
+	nc.conn.SetReadDeadline(deadline)
+	for {
+		line, err := nc.readLine()
+		if err != nil {
+			return nil, err
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "PING":
+			if err := nc.write([]byte("PONG\r\n")); err != nil {
+				return nil, err
+			}
+		case "PONG", "+OK", "INFO":
+		case "-ERR":
+			return nil, fmt.Errorf("server: %v", line)
+		case "MSG":
+			// MSG <subject> <sid> [reply] <size>
+			if len(fields) != 4 && len(fields) != 5 {
+				return nil, fmt.Errorf("invalid MSG: %v", line)
+			}
+			return nc.readMsg(fields[1:len(fields)-1], "",
+				fields[len(fields)-1])
+		case "HMSG":
+			// HMSG <subject> <sid> [reply] <header size> <size>
+			if len(fields) != 5 && len(fields) != 6 {
+				return nil, fmt.Errorf("invalid HMSG: %v", line)
+			}
+			return nc.readMsg(fields[1:len(fields)-2],
+				fields[len(fields)-2], fields[len(fields)-1])
+		default:
+			return nil, fmt.Errorf("unexpected message: %v", line)
+		}
+	}
+}
+
+// readMsg reads the payload of a MSG or HMSG.  args holds the subject, sid
+// and optional reply subject.  hdrSize is empty for messages without headers.
+// Messages that are larger than the max_payload of the server are refused
+// before their payload is allocated.
+func (nc *natsConn) readMsg(args []string, hdrSize, size string) (*natsMsg, error) {
+	n, err := strconv.Atoi(size)
+	if err != nil || n < 0 || n > nc.maxPayload {
+		return nil, fmt.Errorf("invalid message size: %v", size)
+	}
+	h := 0
+	if hdrSize != "" {
+		h, err = strconv.Atoi(hdrSize)
+		if err != nil || h < 0 || h > n {
+			return nil, fmt.Errorf("invalid header size: %v",
+				hdrSize)
+		}
+	}
+	b := make([]byte, n+2)
+	if _, err := io.ReadFull(nc.r, b); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(b[n:], []byte("\r\n")) {
+		return nil, errors.New("message size does not match payload")
+	}
+
+	m := &natsMsg{
+		subject: args[0],
+		data:    b[h:n],
+	}
+	if len(args) == 3 {
+		m.reply = args[2]
+	}
+	if h != 0 {
+		// The status code follows the version on the first header
+		// line, e.g. NATS/1.0 404 No Messages.
+		status := bytes.SplitN(b[:h], []byte("\r\n"), 2)[0]
+		if fields := strings.Fields(string(status)); len(fields) > 1 {
+			m.status = fields[1]
+		}
+	}
+
+	return m, nil
+}
+
+// close closes the connection.
+func (nc *natsConn) close() error {
+	return nc.conn.Close()
+}
@@ -0,0 +1,484 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+
+	v1 "github.com/decred/dcrtime/api/v1"
+	v2 "github.com/decred/dcrtime/api/v2"
+	"github.com/decred/dcrtime/util"
+	"github.com/gorilla/mux"
+)
+
+const (
+	// openAPIVersion is the version of the OpenAPI specification the
+	// document conforms to.
+	openAPIVersion = "3.0.3"
+
+	// Content types of request and reply bodies.
+	mimeJSON   = "application/json"
+	mimeNDJSON = "application/x-ndjson"
+	mimeForm   = "application/x-www-form-urlencoded"
+)
+
+// routeSchema describes the bodies of a route.  Request and reply are zero
+// values of the api types that are sent and returned, nil if there is no body.
+type routeSchema struct {
+	request          interface{}
+	reply            interface{}
+	requestMIME      string // Default mimeJSON
+	replyMIME        string // Default mimeJSON
+	form             bool   // Request fields may be form or query values
+	replyDescription string
+}
+
+// routeSchemas are the bodies of the api routes.  The routes and methods of
+// the OpenAPI document are taken from the router, this only adds the types.
+var routeSchemas = map[string]routeSchema{
+	v2.VersionRoute: {reply: v2.VersionReply{}},
+	v2.HealthRoute:  {reply: v2.HealthReply{}},
+	v2.ReadyRoute:   {reply: v2.ReadyReply{}},
+
+	v1.StatusRoute:        {request: v1.Status{}, reply: v1.StatusReply{}},
+	v1.TimestampRoute:     {request: v1.Timestamp{}, reply: v1.TimestampReply{}},
+	v1.VerifyRoute:        {request: v1.Verify{}, reply: v1.VerifyReply{}},
+	v1.WalletBalanceRoute: {reply: v1.WalletBalanceReply{}},
+	v1.LastAnchorRoute:    {reply: v1.LastAnchorReply{}},
+
+	v2.StatusRoute:             {request: v2.Status{}, reply: v2.StatusReply{}},
+	v2.TimestampRoute:          {request: v2.Timestamp{}, reply: v2.TimestampReply{}, form: true},
+	v2.VerifyRoute:             {request: v2.Verify{}, reply: v2.VerifyReply{}, form: true},
+	v2.TimestampBatchRoute:     {request: v2.TimestampBatch{}, reply: v2.TimestampBatchReply{}},
+	v2.TimestampAggregateRoute: {request: v2.TimestampAggregate{}, reply: v2.TimestampAggregateReply{}},
+	v2.TimestampAsyncRoute:     {request: v2.TimestampBatch{}, reply: v2.TimestampAsyncReply{}},
+	v2.TicketRoute:             {request: v2.TimestampTicket{}, reply: v2.TimestampTicketReply{}},
+	v2.SessionOpenRoute:        {request: v2.SessionOpen{}, reply: v2.SessionReply{}},
+	v2.SessionAppendRoute:      {request: v2.SessionAppend{}, reply: v2.SessionReply{}},
+	v2.SessionCloseRoute:       {request: v2.SessionClose{}, reply: v2.SessionReply{}},
+	v2.SessionStatusRoute:      {request: v2.SessionStatus{}, reply: v2.SessionReply{}},
+	v2.VerifyBatchRoute:        {request: v2.VerifyBatch{}, reply: v2.VerifyBatchReply{}},
+	v2.VerifyStreamRoute: {
+		request:     v2.VerifyStreamDigest{},
+		reply:       v2.VerifyDigest{},
+		requestMIME: mimeNDJSON,
+		replyMIME:   mimeNDJSON,
+		replyDescription: "Newline delimited results, the last line " +
+			"is a VerifyStreamError if the stream was aborted",
+	},
+	v2.WalletBalanceRoute:     {reply: v2.WalletBalanceReply{}},
+	v2.LastAnchorRoute:        {reply: v2.LastAnchorReply{}},
+	v2.LastDigestsRoute:       {request: v2.LastDigests{}, reply: v2.LastDigestsReply{}},
+	v2.LabelRoute:             {request: v2.Label{}, reply: v2.LabelReply{}},
+	v2.WindowRoute:            {request: v2.Window{}, reply: v2.WindowReply{}},
+	v2.AnchorsRoute:           {request: v2.Anchors{}, reply: v2.AnchorsReply{}},
+	v2.BloomRoute:             {request: v2.Bloom{}, reply: v2.BloomReply{}},
+	v2.AnchorChainRoute:       {request: v2.AnchorChain{}, reply: v2.AnchorChainReply{}},
+	v2.IdentityRoute:          {reply: v2.IdentityReply{}},
+	v2.OpenAPIRoute:           {replyDescription: "OpenAPI 3 document"},
+	v2.CollectionsRoute:       {request: v2.Collections{}, reply: v2.CollectionsReply{}},
+	v2.CollectionRenameRoute:  {request: v2.CollectionRename{}, reply: v2.CollectionRenameReply{}},
+	v2.CollectionDeleteRoute:  {request: v2.CollectionDelete{}, reply: v2.CollectionDeleteReply{}},
+	v2.CollectionReceiptRoute: {request: v2.CollectionReceipt{}, reply: v2.CollectionReceiptReply{}},
+	v2.SearchRoute:            {request: v2.Search{}, reply: v2.SearchReply{}},
+	v2.UsageRoute:             {request: v2.Usage{}, reply: v2.UsageReply{}},
+	v2.ProxyStatsRoute:        {reply: v2.ProxyStatsReply{}},
+	v2.AdminStatusRoute:       {reply: v2.AdminStatusReply{}},
+	v2.TokensRoute:            {reply: v2.TokensReply{}},
+	v2.TokenCreateRoute:       {request: v2.TokenCreate{}, reply: v2.TokenCreateReply{}},
+	v2.TokenRevokeRoute:       {request: v2.TokenRevoke{}, reply: v2.TokenRevokeReply{}},
+	v2.WebhooksRoute:          {reply: v2.WebhooksReply{}},
+	v2.WebhookRetryRoute:      {request: v2.WebhookRetry{}, reply: v2.WebhookRetryReply{}},
+	v2.WebhookDeleteRoute:     {request: v2.WebhookDelete{}, reply: v2.WebhookDeleteReply{}},
+	v2.WebhookSubscribeRoute:  {request: v2.WebhookSubscribe{}, reply: v2.WebhookSubscribeReply{}},
+	v2.ProofAuditRoute:        {request: v2.ProofAudit{}, reply: v2.ProofAuditReply{}},
+	v2.CollectionStatsRoute:   {request: v2.CollectionStats{}, reply: v2.CollectionStatsReply{}},
+	v2.ExportRoute: {
+		request:   v2.Export{},
+		replyMIME: v2.ExportCSVMIMEType,
+		replyDescription: "Digests as CSV with the columns " +
+			strings.Join(v2.ExportColumns, ","),
+	},
+	v2.ReplicationRoute: {
+		request:          v2.Replication{},
+		replyMIME:        mimeNDJSON,
+		replyDescription: "Newline delimited collections in an internal format",
+	},
+	v2.MaintenanceRoute: {request: v2.Maintenance{}, reply: v2.MaintenanceReply{}},
+}
+
+// openAPISchema is a JSON schema of the OpenAPI document.
+type openAPISchema struct {
+	Ref                  string                    `json:"$ref,omitempty"`
+	Type                 string                    `json:"type,omitempty"`
+	Format               string                    `json:"format,omitempty"`
+	Minimum              *int                      `json:"minimum,omitempty"`
+	Nullable             bool                      `json:"nullable,omitempty"`
+	Items                *openAPISchema            `json:"items,omitempty"`
+	Properties           map[string]*openAPISchema `json:"properties,omitempty"`
+	Required             []string                  `json:"required,omitempty"`
+	AdditionalProperties *openAPISchema            `json:"additionalProperties,omitempty"`
+}
+
+type openAPIMediaType struct {
+	Schema *openAPISchema `json:"schema,omitempty"`
+}
+
+type openAPIBody struct {
+	Description string                      `json:"description,omitempty"`
+	Required    bool                        `json:"required,omitempty"`
+	Content     map[string]openAPIMediaType `json:"content,omitempty"`
+}
+
+type openAPIParameter struct {
+	Name     string         `json:"name"`
+	In       string         `json:"in"`
+	Required bool           `json:"required,omitempty"`
+	Schema   *openAPISchema `json:"schema"`
+}
+
+type openAPIOperation struct {
+	OperationID string                 `json:"operationId"`
+	Tags        []string               `json:"tags,omitempty"`
+	Parameters  []openAPIParameter     `json:"parameters,omitempty"`
+	RequestBody *openAPIBody           `json:"requestBody,omitempty"`
+	Responses   map[string]openAPIBody `json:"responses"`
+}
+
+type openAPIInfo struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+type openAPISecurityScheme struct {
+	Type string `json:"type"`
+	In   string `json:"in"`
+	Name string `json:"name"`
+}
+
+type openAPIComponents struct {
+	Schemas         map[string]*openAPISchema        `json:"schemas"`
+	SecuritySchemes map[string]openAPISecurityScheme `json:"securitySchemes"`
+}
+
+// openAPIDocument is an OpenAPI 3 document.
+type openAPIDocument struct {
+	OpenAPI    string                                 `json:"openapi"`
+	Info       openAPIInfo                            `json:"info"`
+	Paths      map[string]map[string]openAPIOperation `json:"paths"`
+	Components openAPIComponents                      `json:"components"`
+	Security   []map[string][]string                  `json:"security"`
+}
+
+// schemaOf returns the schema of the provided type.  Structs are added to the
+// components and referenced.
+func (doc *openAPIDocument) schemaOf(t reflect.Type) *openAPISchema {
+	zero := 0
+	switch t {
+	case reflect.TypeOf(json.RawMessage{}):
+		return &openAPISchema{} // Any JSON value
+	case reflect.TypeOf([]byte{}):
+		return &openAPISchema{Type: "string", Format: "byte"}
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		s := doc.schemaOf(t.Elem())
+		if s.Ref != "" {
+			// Siblings of $ref are ignored.
+			return s
+		}
+		s.Nullable = true
+		return s
+	case reflect.Bool:
+		return &openAPISchema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32:
+		return &openAPISchema{Type: "integer", Format: "int32"}
+	case reflect.Int64:
+		return &openAPISchema{Type: "integer", Format: "int64"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &openAPISchema{Type: "integer", Format: "int32",
+			Minimum: &zero}
+	case reflect.Uint64:
+		return &openAPISchema{Type: "integer", Format: "int64",
+			Minimum: &zero}
+	case reflect.Float32:
+		return &openAPISchema{Type: "number", Format: "float"}
+	case reflect.Float64:
+		return &openAPISchema{Type: "number", Format: "double"}
+	case reflect.String:
+		return &openAPISchema{Type: "string"}
+	case reflect.Slice:
+		return &openAPISchema{Type: "array", Nullable: true,
+			Items: doc.schemaOf(t.Elem())}
+	case reflect.Array:
+		return &openAPISchema{Type: "array",
+			Items: doc.schemaOf(t.Elem())}
+	case reflect.Map:
+		return &openAPISchema{Type: "object", Nullable: true,
+			AdditionalProperties: doc.schemaOf(t.Elem())}
+	case reflect.Struct:
+		name := t.String()
+		if _, ok := doc.Components.Schemas[name]; !ok {
+			s := &openAPISchema{
+				Type:       "object",
+				Properties: make(map[string]*openAPISchema),
+			}
+			// Reserve the name before the fields are described
+			// in case the struct refers to itself.
+			doc.Components.Schemas[name] = s
+			doc.addFields(s, t)
+		}
+		return &openAPISchema{Ref: "#/components/schemas/" + name}
+	}
+	return &openAPISchema{}
+}
+
+// addFields adds the JSON encoded fields of the provided struct to its
+// schema.  The fields of embedded structs are promoted like encoding/json
+// does.
+func (doc *openAPIDocument) addFields(s *openAPISchema, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		opts := strings.Split(tag, ",")
+		name := opts[0]
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				doc.addFields(s, ft)
+				continue
+			}
+		}
+		if f.PkgPath != "" {
+			continue // Unexported
+		}
+		if name == "" {
+			name = f.Name
+		}
+
+		fs := doc.schemaOf(f.Type)
+		omitempty := false
+		for _, opt := range opts[1:] {
+			switch opt {
+			case "omitempty":
+				omitempty = true
+			case "string":
+				fs = &openAPISchema{Type: "string"}
+			}
+		}
+		s.Properties[name] = fs
+		if !omitempty {
+			s.Required = append(s.Required, name)
+		}
+	}
+}
+
+// formField is a form value of a request.
+type formField struct {
+	name   string
+	schema *openAPISchema
+}
+
+// formFields returns the form values of the provided struct, which are named
+// by the form tags of its fields.  Slices are repeated values.
+func (doc *openAPIDocument) formFields(t reflect.Type) []formField {
+	var fields []formField
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := f.Tag.Get("form")
+		if name == "" || name == "-" {
+			continue
+		}
+		fields = append(fields, formField{
+			name:   name,
+			schema: doc.schemaOf(f.Type),
+		})
+	}
+	return fields
+}
+
+// operationID returns the id of the operation of a method and route, e.g.
+// postV2TimestampBatch.
+func operationID(method, route string) string {
+	id := strings.ToLower(method)
+	for _, part := range strings.FieldsFunc(route, func(r rune) bool {
+		return r == '/' || r == '-' || r == '.' || r == '_'
+	}) {
+		id += strings.ToUpper(part[:1]) + part[1:]
+	}
+	return id
+}
+
+// routeTag returns the tag of a route, the api version it belongs to.
+func routeTag(route string) string {
+	switch {
+	case strings.HasPrefix(route, v1.RoutePrefix+"/"):
+		return "v1"
+	case strings.HasPrefix(route, v2.RoutePrefix+"/"):
+		return "v2"
+	}
+	return "server"
+}
+
+// operation returns the operation of a method and route.
+func (doc *openAPIDocument) operation(method, route string) openAPIOperation {
+	rs, ok := routeSchemas[route]
+	if !ok {
+		// Routes are also served without their trailing slash.
+		rs = routeSchemas[route+"/"]
+	}
+	op := openAPIOperation{
+		OperationID: operationID(method, route),
+		Tags:        []string{routeTag(route)},
+		Responses:   make(map[string]openAPIBody),
+	}
+
+	switch {
+	case rs.request == nil:
+	case rs.form && method == http.MethodGet:
+		// Form routes take the request fields as query values.
+		for _, f := range doc.formFields(reflect.TypeOf(rs.request)) {
+			op.Parameters = append(op.Parameters, openAPIParameter{
+				Name:   f.name,
+				In:     "query",
+				Schema: f.schema,
+			})
+		}
+	case rs.form:
+		s := &openAPISchema{
+			Type:       "object",
+			Properties: make(map[string]*openAPISchema),
+		}
+		for _, f := range doc.formFields(reflect.TypeOf(rs.request)) {
+			s.Properties[f.name] = f.schema
+		}
+		op.RequestBody = &openAPIBody{
+			Required: true,
+			Content: map[string]openAPIMediaType{
+				mimeForm: {Schema: s},
+			},
+		}
+	case method != http.MethodGet:
+		mime := mimeJSON
+		if rs.requestMIME != "" {
+			mime = rs.requestMIME
+		}
+		op.RequestBody = &openAPIBody{
+			Required: true,
+			Content: map[string]openAPIMediaType{
+				mime: {Schema: doc.schemaOf(reflect.TypeOf(rs.request))},
+			},
+		}
+	}
+
+	reply := openAPIBody{Description: "OK"}
+	if rs.replyDescription != "" {
+		reply.Description = rs.replyDescription
+	}
+	mime := mimeJSON
+	if rs.replyMIME != "" {
+		mime = rs.replyMIME
+	}
+	switch {
+	case rs.reply != nil:
+		reply.Content = map[string]openAPIMediaType{
+			mime: {Schema: doc.schemaOf(reflect.TypeOf(rs.reply))},
+		}
+	case rs.replyMIME != "":
+		reply.Content = map[string]openAPIMediaType{mime: {}}
+	default:
+		reply.Content = map[string]openAPIMediaType{
+			mimeJSON: {Schema: &openAPISchema{Type: "object"}},
+		}
+	}
+	op.Responses["200"] = reply
+	op.Responses["default"] = openAPIBody{
+		Description: "Error",
+		Content: map[string]openAPIMediaType{
+			mimeJSON: {Schema: &openAPISchema{
+				Ref: "#/components/schemas/Error",
+			}},
+		},
+	}
+	return op
+}
+
+// openAPIDocument returns the OpenAPI document of the routes of the router.
+func (d *DcrtimeStore) openAPIDocument() (*openAPIDocument, error) {
+	doc := &openAPIDocument{
+		OpenAPI: openAPIVersion,
+		Info: openAPIInfo{
+			Title:   "dcrtime",
+			Version: version(),
+		},
+		Paths: make(map[string]map[string]openAPIOperation),
+		Components: openAPIComponents{
+			Schemas: map[string]*openAPISchema{
+				"Error": {
+					Type: "object",
+					Properties: map[string]*openAPISchema{
+						"error": {Type: "string"},
+					},
+					Required: []string{"error"},
+				},
+			},
+			SecuritySchemes: map[string]openAPISecurityScheme{
+				"apitoken": {
+					Type: "apiKey",
+					In:   "query",
+					Name: "apitoken",
+				},
+			},
+		},
+		// Whether a route requires an api token depends on the
+		// route and the configuration.
+		Security: []map[string][]string{{}, {"apitoken": {}}},
+	}
+
+	err := d.router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			return nil
+		}
+		for _, method := range methods {
+			if _, ok := doc.Paths[path]; !ok {
+				doc.Paths[path] = make(map[string]openAPIOperation)
+			}
+			doc.Paths[path][strings.ToLower(method)] =
+				doc.operation(method, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// openAPI returns the OpenAPI document of the enabled API versions and routes.
+// Handles /v2/openapi.json
+func (d *DcrtimeStore) openAPI(w http.ResponseWriter, r *http.Request) {
+	doc, err := d.openAPIDocument()
+	if err != nil {
+		log.Errorf("openAPI: %v", err)
+		util.RespondWithError(w, http.StatusInternalServerError,
+			"Internal server error")
+		return
+	}
+	util.RespondWithJSON(w, http.StatusOK, doc)
+}
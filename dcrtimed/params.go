@@ -19,6 +19,7 @@ var activeNetParams = &mainNetParams
 type params struct {
 	*chaincfg.Params
 	WalletRPCServerPort string
+	DcrdRPCServerPort   string
 }
 
 // mainNetParams contains parameters specific to the main network
@@ -30,6 +31,7 @@ type params struct {
 var mainNetParams = params{
 	Params:              chaincfg.MainNetParams(),
 	WalletRPCServerPort: "9111",
+	DcrdRPCServerPort:   "9109",
 }
 
 // testNet3Params contains parameters specific to the test network (version 0)
@@ -39,6 +41,7 @@ var mainNetParams = params{
 var testNet3Params = params{
 	Params:              chaincfg.TestNet3Params(),
 	WalletRPCServerPort: "19111",
+	DcrdRPCServerPort:   "19109",
 }
 
 // simNetParams contains parameters specific to the simulation test network
@@ -46,6 +49,7 @@ var testNet3Params = params{
 var simNetParams = params{
 	Params:              chaincfg.SimNetParams(),
 	WalletRPCServerPort: "19558",
+	DcrdRPCServerPort:   "19556",
 }
 
 // netName returns the name used when referring to a decred network.  At the
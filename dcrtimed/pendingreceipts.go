@@ -0,0 +1,50 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"net/http"
+
+	v2 "github.com/decred/dcrtime/api/v2"
+	"github.com/decred/dcrtime/dcrtimed/backend"
+)
+
+// pendingReceipts returns the pending receipts of the accepted digests of the
+// provided put results, signed with the identity key, in the order of the
+// results.  The receipts commit to the collection with the provided timestamp
+// and to the end of its window.  The digests were stored already, so a window
+// end that can not be looked up is logged and left out of the receipts.
+func (d *DcrtimeStore) pendingReceipts(r *http.Request, ts int64, algorithm string, me []backend.PutResult) []v2.PendingReceipt {
+	ends, endHeight, err := d.backend.WindowEnd(ts)
+	if err != nil {
+		log.Warnf("%v pending receipts: %v", logAddr(r), err)
+		ends, endHeight = 0, 0
+	}
+
+	key := d.activeKey()
+	pk := hex.EncodeToString(key.Public().(ed25519.PublicKey))
+	var prs []v2.PendingReceipt
+	for _, v := range me {
+		if v.ErrorCode != backend.ErrorOK {
+			continue
+		}
+		pr := v2.PendingReceipt{
+			Digest:          hex.EncodeToString(v.Digest[:]),
+			Algorithm:       algorithm,
+			ServerTimestamp: ts,
+			ServerTime:      v2.FormatTime(ts),
+			EndTimestamp:    ends,
+			EndTime:         v2.FormatTime(ends),
+			EndHeight:       endHeight,
+			PublicKey:       pk,
+		}
+		pr.Signature = hex.EncodeToString(ed25519.Sign(key,
+			pr.Message()))
+		prs = append(prs, pr)
+	}
+	return prs
+}
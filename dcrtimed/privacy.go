@@ -0,0 +1,232 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	v2 "github.com/decred/dcrtime/api/v2"
+	"github.com/decred/dcrtime/dcrtimed/backend"
+)
+
+const (
+	// accessSecretSuffix is appended to the data directory to name the
+	// file that holds the secret access keys are derived from.  The
+	// backend does not allow foreign files in the data directory.
+	accessSecretSuffix = ".accesskey"
+
+	// accessSecretSize is the number of random bytes of the access key
+	// secret.
+	accessSecretSize = 32
+)
+
+// loadAccessSecret returns the secret access keys are derived from.  It is
+// created on first use.  Replacing it invalidates all access keys that were
+// handed out.
+func loadAccessSecret(filename string) ([]byte, error) {
+	secret, err := os.ReadFile(filename)
+	if err == nil {
+		if len(secret) != accessSecretSize {
+			return nil, fmt.Errorf("invalid access key secret %v",
+				filename)
+		}
+		return secret, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	secret = make([]byte, accessSecretSize)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filename, secret, 0600); err != nil {
+		return nil, err
+	}
+	return secret, nil
+}
+
+// accessKey returns the access key of a digest, which allows its holder to
+// verify the digest when digests are private.
+func (d *DcrtimeStore) accessKey(digest [sha256.Size]byte) string {
+	mac := hmac.New(sha256.New, d.accessSecret)
+	mac.Write(digest[:])
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// accessKeys returns the access keys of the digests that were accepted, in the
+// order of the results.  Digests that already existed were timestamped by
+// somebody else and don't get an access key.  It returns nil if digests are
+// public.
+func (d *DcrtimeStore) accessKeys(me []backend.PutResult) []string {
+	if !d.cfg.PrivateDigests {
+		return nil
+	}
+	keys := make([]string, 0, len(me))
+	for _, v := range me {
+		if v.ErrorCode != backend.ErrorOK {
+			keys = append(keys, "")
+			continue
+		}
+		keys = append(keys, d.accessKey(v.Digest))
+	}
+	return keys
+}
+
+// digestAccess decides which digests a request may see when digests are
+// private.  A digest is visible to the api token that timestamped it and to
+// holders of its access key.  A nil digestAccess sees all digests.
+type digestAccess struct {
+	d     *DcrtimeStore
+	owner string                                   // Api token ID, if any
+	keys  map[string]struct{}                      // Provided access keys
+	owned map[int64]map[[sha256.Size]byte]struct{} // [timestamp]digests
+}
+
+// newDigestAccess returns the digest access of the request with the provided
+// access keys.  It returns nil if digests are public or the request carries an
+// api token with the admin scope.
+func (d *DcrtimeStore) newDigestAccess(r *http.Request, keys []string) *digestAccess {
+	if !d.cfg.PrivateDigests {
+		return nil
+	}
+	if hasScope(d.tokenScopes(r), v2.TokenScopeAdmin) {
+		return nil
+	}
+
+	a := &digestAccess{
+		d:     d,
+		owner: d.collectionOwner(r),
+		keys:  make(map[string]struct{}, len(keys)),
+		owned: make(map[int64]map[[sha256.Size]byte]struct{}),
+	}
+	for _, key := range keys {
+		a.addKey(key)
+	}
+	return a
+}
+
+// addKey adds an access key that was provided by the client.
+func (a *digestAccess) addKey(key string) {
+	if a == nil || key == "" {
+		return
+	}
+	a.keys[strings.ToLower(key)] = struct{}{}
+}
+
+// visible returns true if the digest that was timestamped in the collection
+// with the provided timestamp may be revealed.
+func (a *digestAccess) visible(ts int64, digest [sha256.Size]byte) (bool, error) {
+	if a == nil {
+		return true, nil
+	}
+	if _, ok := a.keys[a.d.accessKey(digest)]; ok {
+		return true, nil
+	}
+	if a.owner == "" {
+		return false, nil
+	}
+
+	owned, ok := a.owned[ts]
+	if !ok {
+		digests, err := a.d.backend.GetCollectionDigests(a.owner, ts)
+		if err != nil && !errors.Is(err, backend.ErrCollectionNotFound) {
+			return false, err
+		}
+		owned = make(map[[sha256.Size]byte]struct{}, len(digests))
+		for _, digest := range digests {
+			owned[digest] = struct{}{}
+		}
+		a.owned[ts] = owned
+	}
+	_, ok = owned[digest]
+	return ok, nil
+}
+
+// hideDigests reports digests that may not be revealed as not found so that
+// their existence is not disclosed either.
+func (a *digestAccess) hideDigests(drs []backend.GetResult) error {
+	if a == nil {
+		return nil
+	}
+	for k, dr := range drs {
+		if dr.ErrorCode != backend.ErrorOK {
+			continue
+		}
+		ok, err := a.visible(dr.Timestamp, dr.Digest)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			drs[k] = backend.GetResult{
+				Digest:    dr.Digest,
+				ErrorCode: backend.ErrorNotFound,
+			}
+		}
+	}
+	return nil
+}
+
+// visibleResults returns the digest results that may be revealed.
+func (a *digestAccess) visibleResults(drs []backend.GetResult) ([]backend.GetResult, error) {
+	if a == nil {
+		return drs, nil
+	}
+	visible := drs[:0]
+	for _, dr := range drs {
+		ok, err := a.visible(dr.Timestamp, dr.Digest)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			visible = append(visible, dr)
+		}
+	}
+	return visible, nil
+}
+
+// visibleDigests returns the digests of the collection with the provided
+// timestamp that may be revealed.
+func (a *digestAccess) visibleDigests(ts int64, digests [][sha256.Size]byte) ([][sha256.Size]byte, error) {
+	if a == nil {
+		return digests, nil
+	}
+	visible := make([][sha256.Size]byte, 0, len(digests))
+	for _, digest := range digests {
+		ok, err := a.visible(ts, digest)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			visible = append(visible, digest)
+		}
+	}
+	return visible, nil
+}
+
+// hideTimestamps removes the digests that may not be revealed from the
+// provided collections.  The merkle roots remain public.
+func (a *digestAccess) hideTimestamps(trs []backend.TimestampResult) error {
+	if a == nil {
+		return nil
+	}
+	for k := range trs {
+		tr := &trs[k]
+		digests, err := a.visibleDigests(tr.Timestamp, tr.Digests)
+		if err != nil {
+			return err
+		}
+		tr.Digests = digests
+	}
+	return nil
+}
@@ -0,0 +1,196 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"testing"
+
+	v2 "github.com/decred/dcrtime/api/v2"
+)
+
+// privateStore returns a storehost with private digests and the digests that
+// were timestamped with the returned api token, which are anchored, along
+// with the timestamp reply.
+func privateStore(t *testing.T) (*testStore, string, []string, v2.TimestampBatchReply) {
+	t.Helper()
+
+	cfg := testConfig(t)
+	cfg.PrivateDigests = true
+	s := newTestStore(t, cfg)
+
+	owner := s.createToken(t, v2.TokenScopeTimestamp,
+		v2.TokenScopeVerify).Token
+	digests := testDigests("private", 2)
+	var tr v2.TimestampBatchReply
+	code := s.post(t, withToken(v2.TimestampBatchRoute, owner),
+		v2.TimestampBatch{
+			Digests: digests,
+		}, &tr)
+	if code != http.StatusOK {
+		t.Fatalf("timestamp: got status %v", code)
+	}
+	if len(tr.AccessKeys) != len(digests) {
+		t.Fatalf("got access keys %v", tr.AccessKeys)
+	}
+	if err := s.b.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	s.wallet.SetConfirmations(1)
+
+	return s, owner, digests, tr
+}
+
+// verifyPrivate verifies the digest and the collection it was timestamped in
+// with the provided api token and access keys.  It returns whether the digest
+// was found and whether it is listed in the collection.
+func (s *testStore) verifyPrivate(t *testing.T, apiToken, digest string, ts int64, keys []string) (bool, bool) {
+	t.Helper()
+
+	var vr v2.VerifyBatchReply
+	code := s.post(t, withToken(v2.VerifyBatchRoute, apiToken),
+		v2.VerifyBatch{
+			Digests:    []string{digest},
+			Timestamps: []int64{ts},
+			AccessKeys: keys,
+		}, &vr)
+	if code != http.StatusOK {
+		t.Fatalf("verify: got status %v", code)
+	}
+	if len(vr.Digests) != 1 || len(vr.Timestamps) != 1 {
+		t.Fatalf("verify: got %+v", vr)
+	}
+
+	vd := vr.Digests[0]
+	found := vd.Result == v2.ResultOK
+	var zero [32]byte
+	if !found && (vd.Result != v2.ResultDoesntExistError ||
+		vd.ServerTimestamp != 0 ||
+		vd.ChainInformation.ChainTimestamp != 0 ||
+		vd.ChainInformation.MerkleRoot != hex.EncodeToString(zero[:])) {
+		t.Fatalf("hidden digest revealed %+v", vd)
+	}
+
+	// The collection remains public, its digests don't.
+	vt := vr.Timestamps[0]
+	if vt.Result != v2.ResultOK {
+		t.Fatalf("collection: got result %v", vt.Result)
+	}
+	var listed bool
+	for _, d := range vt.CollectionInformation.Digests {
+		if d == digest {
+			listed = true
+		}
+	}
+	return found, listed
+}
+
+func TestPrivateVerify(t *testing.T) {
+	s, owner, digests, tr := privateStore(t)
+	other := s.createToken(t, v2.TokenScopeTimestamp,
+		v2.TokenScopeVerify).Token
+	ts, keys := tr.ServerTimestamp, tr.AccessKeys
+
+	tests := []struct {
+		name     string
+		apiToken string
+		keys     []string
+		want     bool
+	}{
+		{"anonymous", "", nil, false},
+		{"other token", other, nil, false},
+		{"unknown token", "unknown", nil, false},
+		{"access key of other digest", "", keys[1:], false},
+		{"malformed access key", "", []string{"zz"}, false},
+		{"access key", "", keys[:1], true},
+		{"upper case access key", "", []string{
+			strings.ToUpper(keys[0])}, true},
+		{"other token with access key", other, keys[:1], true},
+		{"owner", owner, nil, true},
+		{"admin", testAdminToken, nil, true},
+	}
+	for _, test := range tests {
+		found, listed := s.verifyPrivate(t, test.apiToken, digests[0],
+			ts, test.keys)
+		if found != test.want || listed != test.want {
+			t.Errorf("%v: got found %v listed %v, want %v",
+				test.name, found, listed, test.want)
+		}
+	}
+}
+
+func TestPrivateDuplicates(t *testing.T) {
+	s, owner, digests, ptr := privateStore(t)
+	other := s.createToken(t, v2.TokenScopeTimestamp).Token
+
+	tests := []struct {
+		name     string
+		apiToken string
+		want     bool
+	}{
+		{"anonymous", "", false},
+		{"other token", other, false},
+		{"owner", owner, true},
+		{"admin", testAdminToken, true},
+	}
+	for _, test := range tests {
+		var tr v2.TimestampBatchReply
+		code := s.post(t, withToken(v2.TimestampBatchRoute,
+			test.apiToken), v2.TimestampBatch{
+			Digests: digests[:1],
+		}, &tr)
+		if code != http.StatusOK {
+			t.Fatalf("%v: got status %v", test.name, code)
+		}
+		if len(tr.Results) != 1 ||
+			tr.Results[0] != v2.ResultExistsError {
+			t.Fatalf("%v: got results %v", test.name, tr.Results)
+		}
+
+		// Duplicates do not hand out access keys.
+		if len(tr.AccessKeys) != 1 || tr.AccessKeys[0] != "" {
+			t.Fatalf("%v: got access keys %v", test.name,
+				tr.AccessKeys)
+		}
+
+		got := len(tr.Duplicates) != 0
+		if got != test.want {
+			t.Errorf("%v: got duplicates %+v, want %v", test.name,
+				tr.Duplicates, test.want)
+			continue
+		}
+		if got && (tr.Duplicates[0].Digest != digests[0] ||
+			tr.Duplicates[0].ServerTimestamp != ptr.ServerTimestamp) {
+			t.Errorf("%v: got duplicate %+v", test.name,
+				tr.Duplicates[0])
+		}
+	}
+}
+
+func TestPrivateLastDigests(t *testing.T) {
+	s, owner, digests, _ := privateStore(t)
+
+	for _, test := range []struct {
+		name     string
+		apiToken string
+		want     int
+	}{
+		{"anonymous", "", 0},
+		{"owner", owner, len(digests)},
+	} {
+		var ldr v2.LastDigestsReply
+		code := s.post(t, withToken(v2.LastDigestsRoute, test.apiToken),
+			v2.LastDigests{N: 10}, &ldr)
+		if code != http.StatusOK {
+			t.Fatalf("%v: got status %v", test.name, code)
+		}
+		if len(ldr.Digests) != test.want {
+			t.Errorf("%v: got %v digests, want %v", test.name,
+				len(ldr.Digests), test.want)
+		}
+	}
+}
@@ -0,0 +1,283 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	v2 "github.com/decred/dcrtime/api/v2"
+	"github.com/decred/dcrtime/checkpoint"
+	"github.com/decred/dcrtime/dcrtimed/backend"
+	"github.com/decred/dcrtime/dcrtimed/dcrtimewallet"
+	"github.com/decred/dcrtime/merkle"
+)
+
+const (
+	// otsMagic is the header of an OpenTimestamps proof file.
+	otsMagic = "\x00OpenTimestamps\x00\x00Proof\x00\xbf\x89\xe2\xe8\x84\xe8\x92\x94"
+
+	// otsVersion is the major version of the OpenTimestamps proof format.
+	otsVersion = 1
+
+	// OpenTimestamps operations.
+	otsOpSHA256      = 0x08
+	otsOpAppend      = 0xf0
+	otsOpPrepend     = 0xf1
+	otsOpAttestation = 0x00
+
+	// chainpointContext is the JSON-LD context of a Chainpoint v3 proof.
+	chainpointContext = "https://w3id.org/chainpoint/v3"
+
+	// chainpointAnchorType is the anchor type of Decred transactions in
+	// Chainpoint proofs.
+	chainpointAnchorType = "dcr"
+)
+
+// otsDecredTag is the tag of the OpenTimestamps attestation that the result
+// of the operations is the merkle root stored in a Decred transaction.  The
+// payload is the transaction hash.  OpenTimestamps clients don't know Decred
+// and report it as an unknown attestation.
+var otsDecredTag = [8]byte{0x6f, 0x8e, 0x0d, 0xc7, 0x3d, 0x52, 0xa1, 0x19}
+
+// parseProofFormats returns the proof formats of a form value, which may be
+// repeated and comma separated.
+func parseProofFormats(values []string) []string {
+	var formats []string
+	for _, value := range values {
+		for _, format := range strings.Split(value, ",") {
+			if format = strings.TrimSpace(format); format != "" {
+				formats = append(formats, format)
+			}
+		}
+	}
+	return formats
+}
+
+// validProofFormats returns an error if one of the provided proof formats is
+// not supported.
+func validProofFormats(formats []string) error {
+	for _, format := range formats {
+		if !v2.IsProofFormat(format) {
+			return fmt.Errorf("invalid proof format: %v", format)
+		}
+	}
+	return nil
+}
+
+// appendOTSBytes appends a length prefixed byte string.
+func appendOTSBytes(b, v []byte) []byte {
+	b = binary.AppendUvarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+// encodeOTS returns the OpenTimestamps proof of a SHA-256 digest.  The
+// operations lead from the digest to the merkle root, the attestation names
+// the transaction the merkle root is stored in.
+func encodeOTS(dr backend.GetResult, steps []merkle.Step) ([]byte, error) {
+	tx, err := hex.DecodeString(dr.Tx.String())
+	if err != nil {
+		return nil, err
+	}
+
+	b := make([]byte, 0, len(otsMagic)+2+len(dr.Digest)+
+		len(steps)*(len(dr.Digest)+3)+len(otsDecredTag)+1+len(tx))
+	b = append(b, otsMagic...)
+	b = binary.AppendUvarint(b, otsVersion)
+	b = append(b, otsOpSHA256)
+	b = append(b, dr.Digest[:]...)
+	for _, step := range steps {
+		if step.Left {
+			b = append(b, otsOpPrepend)
+		} else {
+			b = append(b, otsOpAppend)
+		}
+		b = appendOTSBytes(b, step.Hash[:])
+		b = append(b, otsOpSHA256)
+	}
+	b = append(b, otsOpAttestation)
+	b = append(b, otsDecredTag[:]...)
+	return appendOTSBytes(b, tx), nil
+}
+
+// chainpointOp is a single operation of a Chainpoint branch.  L and R are hex
+// encoded values that are prepended or appended to the current hash.
+type chainpointOp struct {
+	L  string `json:"l,omitempty"`
+	R  string `json:"r,omitempty"`
+	Op string `json:"op,omitempty"`
+}
+
+// chainpointAnchor names the transaction a Chainpoint branch ends in.
+type chainpointAnchor struct {
+	Type     string `json:"type"`
+	AnchorID string `json:"anchor_id"`
+}
+
+// chainpointBranch is a list of operations that ends in anchors.
+type chainpointBranch struct {
+	Label   string             `json:"label"`
+	Ops     []chainpointOp     `json:"ops"`
+	Anchors []chainpointAnchor `json:"anchors"`
+}
+
+// chainpointProof is a Chainpoint v3 proof.
+type chainpointProof struct {
+	Context             string             `json:"@context"`
+	Type                string             `json:"type"`
+	Hash                string             `json:"hash"`
+	HashSubmittedNodeAt string             `json:"hash_submitted_node_at"`
+	Branches            []chainpointBranch `json:"branches"`
+}
+
+// encodeChainpoint returns the Chainpoint proof of a digest.
+func encodeChainpoint(dr backend.GetResult, steps []merkle.Step) ([]byte, error) {
+	ops := make([]chainpointOp, 0, 2*len(steps))
+	for _, step := range steps {
+		if step.Left {
+			ops = append(ops, chainpointOp{
+				L: hex.EncodeToString(step.Hash[:]),
+			})
+		} else {
+			ops = append(ops, chainpointOp{
+				R: hex.EncodeToString(step.Hash[:]),
+			})
+		}
+		ops = append(ops, chainpointOp{Op: "sha-256"})
+	}
+
+	return json.Marshal(chainpointProof{
+		Context:             chainpointContext,
+		Type:                "Chainpoint",
+		Hash:                hex.EncodeToString(dr.Digest[:]),
+		HashSubmittedNodeAt: v2.FormatTime(dr.Timestamp),
+		Branches: []chainpointBranch{{
+			Label: "dcr_anchor_branch",
+			Ops:   ops,
+			Anchors: []chainpointAnchor{{
+				Type:     chainpointAnchorType,
+				AnchorID: dr.Tx.String(),
+			}},
+		}},
+	})
+}
+
+// encodeProofJSON returns the self-contained JSON proof of a digest.  It
+// names the nearest checkpoint of the active network above the anchor, if one
+// was published already.
+func encodeProofJSON(dr backend.GetResult) *v2.ProofJSON {
+	branch := v2.BranchJSON{
+		NumLeaves: dr.MerklePath.NumLeaves,
+		Hashes:    make([]string, 0, len(dr.MerklePath.Hashes)),
+		Flags:     hex.EncodeToString(dr.MerklePath.Flags),
+	}
+	for _, hash := range dr.MerklePath.Hashes {
+		branch.Hashes = append(branch.Hashes, hex.EncodeToString(hash[:]))
+	}
+	proof := &v2.ProofJSON{
+		Digest:         hex.EncodeToString(dr.Digest[:]),
+		Algorithm:      dr.Algorithm,
+		MerkleRoot:     hex.EncodeToString(dr.MerkleRoot[:]),
+		MerkleBranch:   branch,
+		Transaction:    dr.Tx.String(),
+		ChainTimestamp: dr.AnchoredTimestamp,
+		BlockHeight:    dr.BlockHeight,
+	}
+
+	// Records that predate the block height can not be placed.
+	if dr.BlockHeight == 0 {
+		return proof
+	}
+	cp, err := checkpoint.Nearest(checkpoint.Network(activeNetParams.Name),
+		dr.BlockHeight)
+	if err == nil {
+		proof.CheckpointHeight = cp.Height
+		proof.CheckpointHash = cp.Hash.String()
+	}
+	return proof
+}
+
+// encodeProofFull returns the JSON proof of a digest together with the block
+// its anchor was mined in.  It returns nil if the anchor block is not known,
+// e.g. because the anchor lost confirmations or the wallet can not serve
+// blocks.
+func (d *DcrtimeStore) encodeProofFull(dr backend.GetResult) (*v2.ProofFull, error) {
+	ab, err := d.backend.GetAnchorBlock(dr.Timestamp)
+	if errors.Is(err, backend.ErrAnchorNotFound) ||
+		errors.Is(err, dcrtimewallet.ErrNotSupported) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	tx, err := ab.Chain.Tx.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	header := ab.Chain.Headers[0]
+	rawHeader, err := header.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	proof := &v2.ProofFull{
+		ProofJSON:      *encodeProofJSON(dr),
+		RawTransaction: hex.EncodeToString(tx),
+		TxIndex:        ab.Chain.Branch.Index,
+		TxBranch:       make([]string, 0, len(ab.Chain.Branch.Hashes)),
+		BlockHash:      header.BlockHash().String(),
+		BlockHeader:    hex.EncodeToString(rawHeader),
+	}
+	for _, hash := range ab.Chain.Branch.Hashes {
+		proof.TxBranch = append(proof.TxBranch, hash.String())
+	}
+	return proof, nil
+}
+
+// encodeProofs returns the proofs of a digest in the provided formats.  It
+// returns nil if the digest was not anchored yet.  The formats must have been
+// validated.
+func (d *DcrtimeStore) encodeProofs(dr backend.GetResult, formats []string) (*v2.Proofs, error) {
+	if len(formats) == 0 || dr.ErrorCode != backend.ErrorOK ||
+		dr.AnchoredTimestamp == 0 {
+		return nil, nil
+	}
+
+	// There is nothing to prove without a merkle branch.
+	steps, err := merkle.Path(&dr.MerklePath, &dr.Digest)
+	if err == merkle.ErrEmpty {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("digest %x: %v", dr.Digest, err)
+	}
+
+	var proofs v2.Proofs
+	for _, format := range formats {
+		switch format {
+		case v2.ProofFormatJSON:
+			proofs.JSON = encodeProofJSON(dr)
+		case v2.ProofFormatOTS:
+			// The OpenTimestamps header names the hash of the
+			// file, which is only SHA-256 for SHA-256 digests.
+			if dr.Algorithm != "" &&
+				dr.Algorithm != v2.AlgorithmSHA256 {
+				continue
+			}
+			proofs.OTS, err = encodeOTS(dr, steps)
+		case v2.ProofFormatChainpoint:
+			proofs.Chainpoint, err = encodeChainpoint(dr, steps)
+		case v2.ProofFormatFull:
+			proofs.Full, err = d.encodeProofFull(dr)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &proofs, nil
+}
@@ -0,0 +1,506 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	v2 "github.com/decred/dcrtime/api/v2"
+	"github.com/decred/dcrtime/util"
+)
+
+// routeStats tracks the latency of the requests that were forwarded to a
+// single upstream route.
+type routeStats struct {
+	requests uint64
+	errors   uint64
+	timeouts uint64
+	total    time.Duration
+	max      time.Duration
+	last     time.Duration
+}
+
+// upstream is a storehost that requests are forwarded to while running in
+// proxy mode.
+type upstream struct {
+	sync.Mutex
+
+	host     string       // Storehost ip:port
+	failover bool         // Set if this is the failover storehost
+	client   *http.Client // Client that trusts the storehost cert
+
+	downUntil time.Time              // Skip storehost until this time
+	stats     map[string]*routeStats // [route]stats
+
+	failures  int       // Consecutive failed requests and health checks
+	openUntil time.Time // Circuit breaker is open until this time
+	healthy   bool      // Result of the last health check
+	fanout    bool      // Independent storehost that only receives timestamps
+	lastCheck time.Time // Time of the last health check
+}
+
+// upstreamReply is the cooked reply of an upstream storehost.
+type upstreamReply struct {
+	statusCode  int
+	status      string
+	contentType string
+	retryAfter  string // Retry-After header, if any
+	signature   string // Signature header, if any
+	body        []byte
+}
+
+// unavailableError is returned by forward when no storehost was able to answer
+// the request.  retryAfter is a hint for when clients should try again.
+type unavailableError struct {
+	retryAfter time.Duration
+	err        error
+}
+
+// Error satisfies the error interface.
+func (e *unavailableError) Error() string {
+	return fmt.Sprintf("storehost unavailable: %v", e.err)
+}
+
+// Unwrap returns the last error that was returned by a storehost.
+func (e *unavailableError) Unwrap() error {
+	return e.err
+}
+
+// retryAfterSeconds returns the provided duration as a Retry-After header
+// value.  It is rounded up to at least one second.
+func retryAfterSeconds(d time.Duration) string {
+	secs := int64((d + time.Second - 1) / time.Second)
+	if secs < 1 {
+		secs = 1
+	}
+	return strconv.FormatInt(secs, 10)
+}
+
+// newUpstream returns an upstream for the provided storehost. The storehost
+// certificate is loaded from certFile and all requests are aborted once the
+// provided timeout expires.
+func newUpstream(host, certFile string, timeout time.Duration, failover bool) (*upstream, error) {
+	if !fileExists(certFile) {
+		return nil, fmt.Errorf("unable to find store cert %v", certFile)
+	}
+	storeCert, err := os.ReadFile(certFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read store cert %v: %v",
+			certFile, err)
+	}
+	certPool := x509.NewCertPool()
+	if !certPool.AppendCertsFromPEM(storeCert) {
+		return nil, fmt.Errorf("unable to load cert %v", certFile)
+	}
+
+	tr := &http.Transport{
+		TLSClientConfig: &tls.Config{
+			RootCAs: certPool,
+		},
+	}
+	return &upstream{
+		host:     host,
+		failover: failover,
+		client: &http.Client{
+			Transport: tr,
+			Timeout:   timeout,
+		},
+		stats:   make(map[string]*routeStats),
+		healthy: true,
+	}, nil
+}
+
+// isDown returns true if the upstream is currently being skipped because a
+// previous request failed.
+func (u *upstream) isDown() bool {
+	u.Lock()
+	defer u.Unlock()
+
+	return time.Now().Before(u.downUntil)
+}
+
+// isOpen returns true if the circuit breaker of the upstream is open.  No
+// requests are forwarded to the upstream while it is open.
+func (u *upstream) isOpen() bool {
+	u.Lock()
+	defer u.Unlock()
+
+	return time.Now().Before(u.openUntil)
+}
+
+// succeeded resets the failure count of the upstream and closes its circuit
+// breaker.
+func (u *upstream) succeeded() {
+	u.Lock()
+	defer u.Unlock()
+
+	u.failures = 0
+	u.downUntil = time.Time{}
+	u.openUntil = time.Time{}
+}
+
+// failed records a failure of the upstream and opens its circuit breaker for
+// the provided period once threshold consecutive failures occurred.  It
+// returns true if the circuit breaker was opened.
+func (u *upstream) failed(threshold int, period time.Duration) bool {
+	u.Lock()
+	defer u.Unlock()
+
+	u.failures++
+	if u.failures < threshold || time.Now().Before(u.openUntil) {
+		return false
+	}
+	u.openUntil = time.Now().Add(period)
+	return true
+}
+
+// check verifies that the upstream answers its version route.
+func (u *upstream) check(ctx context.Context) error {
+	url := fmt.Sprintf("https://%s%s", u.host, v2.VersionRoute)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%v", resp.Status)
+	}
+	return nil
+}
+
+// markDown skips the upstream for the provided period.
+func (u *upstream) markDown(period time.Duration) {
+	u.Lock()
+	defer u.Unlock()
+
+	u.downUntil = time.Now().Add(period)
+}
+
+// record updates the latency statistics of the provided route.
+func (u *upstream) record(route string, latency time.Duration, err error) {
+	// Never key statistics on the query string, it may contain api
+	// tokens.
+	if i := strings.Index(route, "?"); i != -1 {
+		route = route[:i]
+	}
+
+	u.Lock()
+	defer u.Unlock()
+
+	rs, ok := u.stats[route]
+	if !ok {
+		rs = &routeStats{}
+		u.stats[route] = rs
+	}
+	rs.requests++
+	rs.total += latency
+	rs.last = latency
+	if latency > rs.max {
+		rs.max = latency
+	}
+	if err == nil {
+		return
+	}
+	rs.errors++
+	var ne net.Error
+	if errors.Is(err, context.DeadlineExceeded) ||
+		(errors.As(err, &ne) && ne.Timeout()) {
+		rs.timeouts++
+	}
+}
+
+// do forwards a request to the upstream and returns its reply. Transport
+// failures, including timeouts, are returned as errors while non 200 replies
+// are returned to the caller as is.
+func (u *upstream) do(ctx context.Context, method, route, contentType, remoteAddr string, body io.Reader) (*upstreamReply, error) {
+	storeHost := fmt.Sprintf("https://%s%s", u.host, route)
+	req, err := http.NewRequestWithContext(ctx, method, storeHost, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set(forward, remoteAddr)
+	if id := requestID(ctx); id != "" {
+		req.Header.Set(requestIDHeader, id)
+	}
+
+	start := time.Now()
+	reply, err := func() (*upstreamReply, error) {
+		resp, err := u.client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+
+		bodyBuf := new(bytes.Buffer)
+		_, err = bodyBuf.ReadFrom(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+
+		return &upstreamReply{
+			statusCode:  resp.StatusCode,
+			status:      resp.Status,
+			contentType: resp.Header.Get("Content-Type"),
+			retryAfter:  resp.Header.Get("Retry-After"),
+			signature:   resp.Header.Get(v2.SignatureHeader),
+			body:        bodyBuf.Bytes(),
+		}, nil
+	}()
+	u.record(route, time.Since(start), err)
+
+	return reply, err
+}
+
+// upstreamsByPreference returns the upstreams in the order they should be
+// tried. Upstreams that recently failed are moved to the end of the list so
+// that they are only used as a last resort. Upstreams with an open circuit
+// breaker are not returned.
+func (d *DcrtimeStore) upstreamsByPreference() []*upstream {
+	available := make([]*upstream, 0, len(d.upstreams))
+	var down []*upstream
+	for _, u := range d.upstreams {
+		if u.isOpen() {
+			continue
+		}
+		if u.isDown() {
+			down = append(down, u)
+			continue
+		}
+		available = append(available, u)
+	}
+	return append(available, down...)
+}
+
+// retryAfter returns how long clients should wait before retrying a request
+// that no upstream was able to answer.  This is the time until the first
+// circuit breaker closes or, if none is open, the retry backoff.
+func (d *DcrtimeStore) retryAfter() time.Duration {
+	var wait time.Duration
+	for _, u := range d.upstreams {
+		u.Lock()
+		until := time.Until(u.openUntil)
+		u.Unlock()
+		if until <= 0 {
+			return d.cfg.StoreRetryBackoff
+		}
+		if wait == 0 || until < wait {
+			wait = until
+		}
+	}
+	if wait == 0 {
+		wait = d.cfg.StoreRetryBackoff
+	}
+	return wait
+}
+
+// forward sends the request to the preferred upstream and fails over to the
+// next upstream when a transport error occurs or the upstream is busy. The
+// failing upstream is skipped for the configured failover period and its
+// circuit breaker opens after too many consecutive failures. When no upstream
+// answered, all upstreams are retried with exponential backoff. An
+// unavailableError is returned once all retries are exhausted, unless an
+// upstream replied that it is busy, in which case that reply is returned.
+func (d *DcrtimeStore) forward(ctx context.Context, method, route, contentType, remoteAddr string, body *bytes.Reader) (*upstreamReply, error) {
+	var (
+		err     error
+		busy    *upstreamReply
+		backoff = d.cfg.StoreRetryBackoff
+	)
+	for attempt := 0; attempt <= d.cfg.StoreRetries; attempt++ {
+		if attempt > 0 {
+			log.Debugf("Retrying %v in %v (attempt %v)", route,
+				backoff, attempt)
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+		}
+
+		upstreams := d.upstreamsByPreference()
+		if len(upstreams) == 0 {
+			err = errors.New("circuit breaker open")
+			break
+		}
+		for _, u := range upstreams {
+			_, err = body.Seek(0, io.SeekStart)
+			if err != nil {
+				return nil, err
+			}
+
+			var reply *upstreamReply
+			reply, err = u.do(ctx, method, route, contentType,
+				remoteAddr, body)
+			if err == nil &&
+				reply.statusCode != http.StatusServiceUnavailable {
+				u.succeeded()
+				return reply, nil
+			}
+
+			// Don't penalize the upstream when the client went
+			// away.
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+
+			// A busy upstream is alive, try the next one.
+			if err == nil {
+				log.Debugf("Storehost %v busy: %v", u.host,
+					reply.status)
+				busy = reply
+				err = fmt.Errorf("storehost %v busy", u.host)
+				continue
+			}
+
+			log.Errorf("Error posting to storehost %v: %v", u.host, err)
+			if len(d.upstreams) > 1 {
+				log.Warnf("Storehost %v marked down for %v", u.host,
+					d.cfg.StoreFailoverPeriod)
+				u.markDown(d.cfg.StoreFailoverPeriod)
+			}
+			if u.failed(d.cfg.StoreBreakerLimit,
+				d.cfg.StoreBreakerPeriod) {
+				log.Warnf("Storehost %v circuit breaker open for %v",
+					u.host, d.cfg.StoreBreakerPeriod)
+			}
+		}
+	}
+
+	if busy != nil {
+		return busy, nil
+	}
+	return nil, &unavailableError{
+		retryAfter: d.retryAfter(),
+		err:        err,
+	}
+}
+
+// checkUpstream runs a health check against the upstream.  A healthy
+// upstream is immediately used again, even if its circuit breaker was open.
+func (d *DcrtimeStore) checkUpstream(u *upstream) {
+	ctx, cancel := context.WithTimeout(d.ctx, d.cfg.StoreTimeout)
+	defer cancel()
+
+	err := u.check(ctx)
+
+	u.Lock()
+	wasHealthy := u.healthy
+	u.healthy = err == nil
+	u.lastCheck = time.Now()
+	u.Unlock()
+
+	if err != nil {
+		log.Warnf("Health check of storehost %v failed: %v", u.host,
+			err)
+		if u.failed(d.cfg.StoreBreakerLimit,
+			d.cfg.StoreBreakerPeriod) {
+			log.Warnf("Storehost %v circuit breaker open for %v",
+				u.host, d.cfg.StoreBreakerPeriod)
+		}
+		return
+	}
+	if !wasHealthy {
+		log.Infof("Storehost %v is healthy again", u.host)
+	}
+	u.succeeded()
+}
+
+// healthChecker checks the health of all upstreams right away and then once
+// every health check interval.
+func (d *DcrtimeStore) healthChecker() {
+	ticker := time.NewTicker(d.cfg.StoreHealthInterval)
+	defer ticker.Stop()
+	for {
+		for _, u := range d.upstreams {
+			d.checkUpstream(u)
+		}
+		for _, u := range d.fanouts {
+			d.checkUpstream(u)
+		}
+
+		select {
+		case <-d.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// proxyStats returns the latency statistics of all upstream storehosts.
+// Handles /v2/proxy/stats
+func (d *DcrtimeStore) proxyStats(w http.ResponseWriter, r *http.Request) {
+	reply := v2.ProxyStatsReply{
+		Timeout:   d.cfg.StoreTimeout.Milliseconds(),
+		Quorum:    d.cfg.StoreQuorum,
+		Upstreams: make([]v2.UpstreamStats, 0, len(d.upstreams)+len(d.fanouts)),
+	}
+	upstreams := append(append([]*upstream{}, d.upstreams...), d.fanouts...)
+	for _, u := range upstreams {
+		u.Lock()
+		us := v2.UpstreamStats{
+			Host:     u.host,
+			Failover: u.failover,
+			Fanout:   u.fanout,
+			Routes:   make([]v2.UpstreamRouteStats, 0, len(u.stats)),
+		}
+		if time.Now().Before(u.downUntil) {
+			us.DownUntil = u.downUntil.Unix()
+			us.DownUntilTime = v2.FormatTime(us.DownUntil)
+		}
+		if time.Now().Before(u.openUntil) {
+			us.OpenUntil = u.openUntil.Unix()
+			us.OpenUntilTime = v2.FormatTime(us.OpenUntil)
+		}
+		us.Failures = u.failures
+		us.Healthy = u.healthy
+		if !u.lastCheck.IsZero() {
+			us.LastCheck = u.lastCheck.Unix()
+			us.LastCheckTime = v2.FormatTime(us.LastCheck)
+		}
+		for route, rs := range u.stats {
+			us.Routes = append(us.Routes, v2.UpstreamRouteStats{
+				Route:       route,
+				Requests:    rs.requests,
+				Errors:      rs.errors,
+				Timeouts:    rs.timeouts,
+				AvgLatency:  (rs.total / time.Duration(rs.requests)).Milliseconds(),
+				MaxLatency:  rs.max.Milliseconds(),
+				LastLatency: rs.last.Milliseconds(),
+			})
+		}
+		u.Unlock()
+
+		sort.Slice(us.Routes, func(i, j int) bool {
+			return us.Routes[i].Route < us.Routes[j].Route
+		})
+		reply.Upstreams = append(reply.Upstreams, us)
+	}
+
+	log.Infof("%v ProxyStats %v", r.URL.Path, logAddr(r))
+
+	util.RespondWithJSON(w, http.StatusOK, reply)
+}
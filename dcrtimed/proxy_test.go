@@ -0,0 +1,270 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	v2 "github.com/decred/dcrtime/api/v2"
+)
+
+// testStorehost is a storehost that records the routes it was asked for and
+// replies with a fixed status code and body.  The body defaults to an empty
+// JSON object.  A storehost that is down drops the connection instead.
+type testStorehost struct {
+	sync.Mutex
+	status int
+	body   string
+	down   bool
+	routes []string
+}
+
+// ServeHTTP records the route and replies with the status code of the
+// storehost.
+func (s *testStorehost) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	s.routes = append(s.routes, r.URL.Path)
+	status, body, down := s.status, s.body, s.down
+	s.Unlock()
+
+	if down {
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err == nil {
+			conn.Close()
+		}
+		return
+	}
+
+	if body == "" {
+		body = "{}"
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write([]byte(body))
+}
+
+// setStatus sets the status code the storehost replies with.
+func (s *testStorehost) setStatus(status int) {
+	s.Lock()
+	defer s.Unlock()
+	s.status = status
+}
+
+// setDown sets whether the storehost drops connections.
+func (s *testStorehost) setDown(down bool) {
+	s.Lock()
+	defer s.Unlock()
+	s.down = down
+}
+
+// requests returns the routes the storehost was asked for.
+func (s *testStorehost) requests() []string {
+	s.Lock()
+	defer s.Unlock()
+	return append([]string(nil), s.routes...)
+}
+
+// newTestUpstream serves the provided handler over TLS and returns an upstream
+// that forwards to it.
+func newTestUpstream(t *testing.T, h http.Handler, timeout time.Duration) *upstream {
+	t.Helper()
+
+	srv := httptest.NewTLSServer(h)
+	t.Cleanup(srv.Close)
+
+	certFile := filepath.Join(t.TempDir(), "store.cert")
+	cert := pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: srv.Certificate().Raw,
+	})
+	if err := os.WriteFile(certFile, cert, 0600); err != nil {
+		t.Fatal(err)
+	}
+	u, err := newUpstream(srv.Listener.Addr().String(), certFile, timeout,
+		false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+// newTestProxy serves the handlers of a proxy that forwards to the provided
+// upstreams and fans timestamps out to the provided fan-out upstreams.
+func newTestProxy(t *testing.T, cfg *config, upstreams, fanouts []*upstream) *testStore {
+	t.Helper()
+
+	d := newDcrtimeStore(cfg, nil)
+	d.upstreams = upstreams
+	for _, u := range fanouts {
+		u.fanout = true
+	}
+	d.fanouts = fanouts
+	d.setupRoutes()
+
+	srv := httptest.NewServer(d.handler())
+	t.Cleanup(srv.Close)
+	return &testStore{
+		DcrtimeStore: d,
+		srv:          srv,
+	}
+}
+
+// TestProxyMaxDigests verifies that a proxy applies the maxdigests overrides
+// before forwarding last digests requests.
+func TestProxyMaxDigests(t *testing.T) {
+	store := &testStorehost{status: http.StatusOK}
+	cfg := testConfig(t)
+	cfg.ScopeMaxDigests = []string{
+		anonymousScope + ":2",
+		v2.TokenScopeVerify + ":50",
+	}
+	s := newTestProxy(t, cfg, []*upstream{
+		newTestUpstream(t, store, time.Second),
+	}, nil)
+
+	tests := []struct {
+		name     string
+		apiToken string
+		n        int32
+		want     int
+	}{
+		{"anonymous", "", 2, http.StatusOK},
+		{"anonymous over", "", 3, http.StatusUnprocessableEntity},
+
+		// The storehost enforces the limit of the token.
+		{"token", "unknown", 50, http.StatusOK},
+		{"token over", "unknown", 51, http.StatusUnprocessableEntity},
+	}
+	forwarded := 0
+	for _, test := range tests {
+		code := s.post(t, withToken(v2.LastDigestsRoute, test.apiToken),
+			v2.LastDigests{N: test.n}, nil)
+		if code != test.want {
+			t.Errorf("%v: got status %v, want %v", test.name, code,
+				test.want)
+		}
+		if code == http.StatusOK {
+			forwarded++
+		}
+	}
+
+	routes := store.requests()
+	if len(routes) != forwarded {
+		t.Fatalf("got %v forwarded requests, want %v", len(routes),
+			forwarded)
+	}
+	for _, route := range routes {
+		if route != v2.LastDigestsRoute {
+			t.Fatalf("got route %v, want %v", route,
+				v2.LastDigestsRoute)
+		}
+	}
+}
+
+// breakerState returns the consecutive failures of the upstream and whether
+// its circuit breaker is open.
+func breakerState(u *upstream) (int, bool) {
+	u.Lock()
+	failures := u.failures
+	u.Unlock()
+	return failures, u.isOpen()
+}
+
+// expireBreaker lets the open period of the circuit breaker of the upstream
+// end, which half-opens it.
+func expireBreaker(u *upstream) {
+	u.Lock()
+	defer u.Unlock()
+	u.openUntil = time.Now()
+}
+
+// TestProxyBreaker verifies that the circuit breaker of a failing storehost
+// opens after the configured number of failures, lets a single request
+// through once its period ended and closes when that request succeeds.
+func TestProxyBreaker(t *testing.T) {
+	store := &testStorehost{status: http.StatusOK, down: true}
+	cfg := testConfig(t)
+	cfg.StoreTimeout = time.Second
+	cfg.StoreRetryBackoff = time.Second
+	cfg.StoreBreakerLimit = 2
+	cfg.StoreBreakerPeriod = time.Minute
+	u := newTestUpstream(t, store, cfg.StoreTimeout)
+	s := newTestProxy(t, cfg, []*upstream{u}, nil)
+
+	forward := func() error {
+		_, err := s.forward(context.Background(), http.MethodPost,
+			v2.LastDigestsRoute, "application/json", "127.0.0.1:1",
+			bytes.NewReader([]byte("{}")))
+		return err
+	}
+	check := func(name string, failures int, open bool, requests int) {
+		t.Helper()
+		f, o := breakerState(u)
+		if f != failures || o != open {
+			t.Fatalf("%v: got %v failures open %v, want %v "+
+				"failures open %v", name, f, o, failures, open)
+		}
+		if n := len(store.requests()); n != requests {
+			t.Fatalf("%v: got %v requests, want %v", name, n,
+				requests)
+		}
+	}
+
+	// Closed: failures are counted until the limit is reached.
+	if err := forward(); err == nil {
+		t.Fatal("expected error")
+	}
+	check("first failure", 1, false, 1)
+	if err := forward(); err == nil {
+		t.Fatal("expected error")
+	}
+	check("second failure", 2, true, 2)
+
+	// Open: requests are refused without reaching the storehost.
+	err := forward()
+	var ue *unavailableError
+	if !errors.As(err, &ue) {
+		t.Fatalf("got error %v, want unavailable", err)
+	}
+	if ue.retryAfter <= 0 || ue.retryAfter > cfg.StoreBreakerPeriod {
+		t.Fatalf("got retry after %v, want up to %v", ue.retryAfter,
+			cfg.StoreBreakerPeriod)
+	}
+	check("open", 2, true, 2)
+
+	// Half-open: a single failure opens the breaker again.
+	expireBreaker(u)
+	if err := forward(); err == nil {
+		t.Fatal("expected error")
+	}
+	check("half-open failure", 3, true, 3)
+
+	// Half-open: a success closes the breaker.
+	expireBreaker(u)
+	store.setDown(false)
+	if err := forward(); err != nil {
+		t.Fatal(err)
+	}
+	check("half-open success", 0, false, 4)
+
+	// A successful health check closes an open breaker right away.
+	store.setDown(true)
+	forward()
+	forward()
+	check("reopened", 2, true, 6)
+	store.setDown(false)
+	s.checkUpstream(u)
+	check("health check", 0, false, 7)
+}
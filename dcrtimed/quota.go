@@ -0,0 +1,197 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	v2 "github.com/decred/dcrtime/api/v2"
+	"github.com/decred/dcrtime/dcrtimed/backend"
+	"github.com/decred/dcrtime/util"
+)
+
+// convertUsage converts the usage counters of a backend api token to their API
+// representation as of the provided timestamp.  Counters of a day or month
+// that ended are reported as zero.
+func convertUsage(t backend.APIToken, now int64) v2.TokenUsage {
+	t.RollUsage(now)
+	return v2.TokenUsage{
+		DayTimestamp:     t.Day,
+		DayTime:          v2.FormatTime(t.Day),
+		DayDigests:       t.DayDigests,
+		MonthTimestamp:   t.Month,
+		MonthTime:        v2.FormatTime(t.Month),
+		MonthDigests:     t.MonthDigests,
+		LastMonthDigests: t.LastMonthDigests,
+		TotalDigests:     t.Digests,
+	}
+}
+
+// chargeQuota charges the provided number of digests to the api token of the
+// request.  Requests without an api token and requests with a token that was
+// provided in the server configuration are not metered.  It replies to the
+// client and returns false if the quota of the token is exceeded.  Otherwise
+// it returns a function that refunds the digests, which is called when they
+// could not be stored.
+func (d *DcrtimeStore) chargeQuota(w http.ResponseWriter, r *http.Request, digests int) (func(), bool) {
+	refund := func() {}
+
+	apiToken := r.URL.Query().Get("apitoken")
+	if _, ok := d.apiTokens[apiToken]; apiToken == "" || ok {
+		return refund, true
+	}
+
+	hash := sha256.Sum256([]byte(apiToken))
+	t, err := d.backend.ChargeToken(hash, int64(digests), time.Now().Unix())
+	switch {
+	case err == nil:
+	case errors.Is(err, backend.ErrTokenNotFound):
+		// Unknown tokens are refused by requireScope when the api is
+		// restricted, they are not metered otherwise.
+		return refund, true
+	case errors.Is(err, backend.ErrQuotaExceeded):
+		log.Infof("%v chargeQuota: token %v quota exceeded", logAddr(r),
+			apiTokenID(apiToken))
+		util.RespondWithError(w, http.StatusTooManyRequests,
+			"Digest quota of the api token exceeded")
+		return nil, false
+	default:
+		errorCode := time.Now().Unix()
+		log.Errorf("%v chargeQuota error code %v: %v", logAddr(r),
+			errorCode, err)
+		util.RespondWithError(w, http.StatusInternalServerError,
+			fmt.Sprintf("failed to charge quota, "+
+				"contact administrator and provide "+
+				"the following error code: %v", errorCode))
+		return nil, false
+	}
+
+	refund = func() {
+		_, err := d.backend.ChargeToken(hash, -int64(digests),
+			time.Now().Unix())
+		if err != nil {
+			log.Errorf("%v refund quota of token %v: %v", logAddr(r),
+				t.ID, err)
+		}
+	}
+	return refund, true
+}
+
+// usageV2 returns the digest quotas and usage counters of the api token of the
+// request, or of the requested token for admin tokens.
+// Handles /v2/usage
+func (d *DcrtimeStore) usageV2(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	var u v2.Usage
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&u); err != nil {
+		util.RespondWithError(w, http.StatusBadRequest,
+			"Invalid request payload")
+		return
+	}
+
+	apiToken := r.URL.Query().Get("apitoken")
+	var (
+		t   *backend.APIToken
+		err error
+	)
+	switch {
+	case u.TokenID != "":
+		if !d.isAuthorized(r, v2.TokenScopeAdmin) {
+			d.respondNotAuthorized(w, r)
+			return
+		}
+		var tokens []backend.APIToken
+		tokens, err = d.backend.GetTokens()
+		if err != nil {
+			break
+		}
+		err = backend.ErrTokenNotFound
+		for k := range tokens {
+			if tokens[k].ID == u.TokenID {
+				t, err = &tokens[k], nil
+				break
+			}
+		}
+	case apiToken == "":
+		util.RespondWithError(w, http.StatusUnauthorized,
+			"not authorized")
+		return
+	default:
+		if _, ok := d.apiTokens[apiToken]; ok {
+			util.RespondWithError(w, http.StatusBadRequest,
+				"Usage is not recorded for api tokens of the "+
+					"server configuration")
+			return
+		}
+		t, err = d.backend.GetToken(sha256.Sum256([]byte(apiToken)))
+		if errors.Is(err, backend.ErrTokenNotFound) {
+			util.RespondWithError(w, http.StatusUnauthorized,
+				"not authorized")
+			return
+		}
+	}
+	if errors.Is(err, backend.ErrTokenNotFound) {
+		util.RespondWithError(w, http.StatusNotFound,
+			"Token not found")
+		return
+	}
+	if err != nil {
+		errorCode := time.Now().Unix()
+		log.Errorf("%v Usage error code %v: %v", logAddr(r),
+			errorCode, err)
+		util.RespondWithError(w, http.StatusInternalServerError,
+			fmt.Sprintf("failed to retrieve usage, "+
+				"contact administrator and provide "+
+				"the following error code: %v", errorCode))
+		return
+	}
+
+	log.Debugf("%v Usage %v: %v", r.URL.Path, logAddr(r), t.ID)
+
+	util.RespondWithJSON(w, http.StatusOK, v2.UsageReply{
+		ID:           u.ID,
+		TokenID:      t.ID,
+		DailyQuota:   t.DailyQuota,
+		MonthlyQuota: t.MonthlyQuota,
+		Usage:        convertUsage(*t, time.Now().Unix()),
+	})
+}
+
+// proxyUsageV2 forwards usage requests to the storehost, which meters the api
+// tokens.
+func (d *DcrtimeStore) proxyUsageV2(w http.ResponseWriter, r *http.Request) {
+	b, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		util.RespondWithError(w, http.StatusBadRequest,
+			"Unable to read request")
+		return
+	}
+
+	var u v2.Usage
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&u); err != nil {
+		util.RespondWithError(w, http.StatusBadRequest,
+			"Invalid request payload")
+		return
+	}
+
+	d.sendToBackend(r.Context(), w, r.Method,
+		withAPIToken(v2.UsageRoute, r), r.Header.Get("Content-Type"),
+		r.RemoteAddr, bytes.NewReader(b))
+
+	log.Infof("%v Usage %v", r.URL.Path, logAddr(r))
+}
@@ -0,0 +1,210 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	v2 "github.com/decred/dcrtime/api/v2"
+	"github.com/decred/dcrtime/dcrtimed/backend"
+	"github.com/decred/dcrtime/util"
+)
+
+// decodeReplication decodes and validates a replication request.  It replies
+// with an error and returns false if the request is invalid.
+func decodeReplication(w http.ResponseWriter, body io.Reader, rp *v2.Replication) bool {
+	decoder := json.NewDecoder(body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(rp); err != nil {
+		util.RespondWithError(w, http.StatusBadRequest,
+			"Invalid request payload")
+		return false
+	}
+	if rp.Since < 0 {
+		util.RespondWithError(w, http.StatusBadRequest,
+			"Invalid timestamp")
+		return false
+	}
+	return true
+}
+
+// replicationV2 streams every collection at or after the requested timestamp
+// to a standby server, one JSON object per line.  Like an export the reply is
+// flushed after every collection and the connection is aborted if the stream
+// fails after it started.
+// Handles /v2/admin/replication
+func (d *DcrtimeStore) replicationV2(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	if !d.isAuthorized(r, v2.TokenScopeAdmin) {
+		d.respondNotAuthorized(w, r)
+		return
+	}
+
+	var rp v2.Replication
+	if !decodeReplication(w, r.Body, &rp) {
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	e := json.NewEncoder(w)
+	var started bool
+	writeHeader := func() {
+		if started {
+			return
+		}
+		started = true
+		w.Header().Set("Content-Type", v2.ReplicationMIMEType)
+		w.WriteHeader(http.StatusOK)
+	}
+
+	var collections int
+	start := time.Now()
+	err := d.backend.ReplicateCollections(rp.Since,
+		func(rr backend.ReplicationRecord) error {
+			writeHeader()
+			if err := e.Encode(rr); err != nil {
+				return err
+			}
+			collections++
+			return rc.Flush()
+		})
+	if err != nil {
+		errorCode := time.Now().Unix()
+		log.Errorf("%v Replication error code %v: %v", logAddr(r),
+			errorCode, err)
+		if started {
+			panic(http.ErrAbortHandler)
+		}
+		util.RespondWithError(w, http.StatusInternalServerError,
+			fmt.Sprintf("failed to replicate collections, "+
+				"contact administrator and provide "+
+				"the following error code: %v", errorCode))
+		return
+	}
+	writeHeader()
+
+	log.Debugf("%v Replication %v: since %v: Collections %v in %v",
+		r.URL.Path, logAddr(r), rp.Since, collections,
+		time.Since(start).Round(time.Millisecond))
+}
+
+// proxyReplicationV2 relays a replication stream from the storehost.
+func (d *DcrtimeStore) proxyReplicationV2(w http.ResponseWriter, r *http.Request) {
+	b, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		util.RespondWithError(w, http.StatusBadRequest,
+			"Unable to read request")
+		return
+	}
+
+	var rp v2.Replication
+	if !decodeReplication(w, bytes.NewReader(b), &rp) {
+		return
+	}
+
+	d.relayStream(w, r, withAPIToken(v2.ReplicationRoute, r),
+		"Replication", bytes.NewReader(b))
+}
+
+// replicate applies the collections of the primary at or after the provided
+// timestamp to the backend.  It returns the timestamp the next replication
+// starts at, which is the first collection that may still change, and the
+// number of applied collections.  Collections change until they are flushed
+// and their anchor is confirmed.  The returned timestamp accounts for the
+// collections that were applied before an error.
+func (d *DcrtimeStore) replicate(since int64) (int64, int, error) {
+	b, err := json.Marshal(v2.Replication{Since: since})
+	if err != nil {
+		return since, 0, err
+	}
+	req, err := http.NewRequestWithContext(d.ctx, http.MethodPost,
+		fmt.Sprintf("https://%s%s?apitoken=%s", d.primary.host,
+			v2.ReplicationRoute, url.QueryEscape(d.cfg.ReplicateToken)),
+		bytes.NewReader(b))
+	if err != nil {
+		return since, 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := d.primary.client.Do(req)
+	if err != nil {
+		return since, 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return since, 0, fmt.Errorf("primary replied %v", resp.Status)
+	}
+
+	next, applied := since, 0
+	changing := false
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var rr backend.ReplicationRecord
+		err := decoder.Decode(&rr)
+		if errors.Is(err, io.EOF) {
+			return next, applied, nil
+		} else if err != nil {
+			return next, applied, err
+		}
+		if rr.Timestamp < since {
+			return next, applied, fmt.Errorf("unexpected "+
+				"collection %v", rr.Timestamp)
+		}
+		err = d.backend.ApplyReplication(rr)
+		if err != nil {
+			return next, applied, err
+		}
+		applied++
+
+		if changing {
+			continue
+		}
+		if rr.Flush == nil || rr.Flush.ChainTimestamp == 0 {
+			changing = true
+			next = rr.Timestamp
+			continue
+		}
+		next = rr.Timestamp + 1
+	}
+}
+
+// replicator keeps the backend of a standby server in sync with the primary.
+// All collections are replicated once at startup, after that only the
+// collections that may still change are.  Failures are logged and retried at
+// the next interval.
+func (d *DcrtimeStore) replicator() {
+	log.Infof("Replicating %v every %v", d.primary.host,
+		d.cfg.ReplicateInterval)
+
+	ticker := time.NewTicker(d.cfg.ReplicateInterval)
+	defer ticker.Stop()
+	var since int64
+	for {
+		start := time.Now()
+		next, applied, err := d.replicate(since)
+		if err != nil {
+			log.Errorf("Replication from %v: %v", d.primary.host, err)
+		}
+		if applied != 0 {
+			log.Debugf("Replicated %v collections since %v in %v",
+				applied, since, time.Since(start))
+		}
+		since = next
+
+		select {
+		case <-d.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,129 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"regexp"
+	"time"
+)
+
+const (
+	// requestIDHeader carries the ID of a request.  An incoming ID is
+	// honored so that requests can be traced across a proxy and its
+	// storehosts, and from the client.
+	requestIDHeader = "X-Request-ID"
+
+	// requestIDSize is the number of random bytes of a generated request
+	// ID.
+	requestIDSize = 8
+)
+
+// regexpRequestID is the valid text representation of an incoming request ID.
+// Anything else is replaced so that clients can not inject into log lines.
+var regexpRequestID = regexp.MustCompile("^[A-Za-z0-9._:-]{1,64}$")
+
+// requestIDKey is the context key of the request ID.
+type requestIDKey struct{}
+
+// requestID returns the ID of the request the provided context belongs to or
+// an empty string if there is none.
+func requestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// logAddr returns the remote address of the request followed by its ID for
+// use in log lines.
+func logAddr(r *http.Request) string {
+	id := requestID(r.Context())
+	if id == "" {
+		return r.RemoteAddr
+	}
+	return r.RemoteAddr + " [" + id + "]"
+}
+
+// tokenIdentity returns the public ID of the api token of the request, the
+// identity of its client certificate if it does not carry one, or - if it
+// carries neither.  The token is not validated.
+func tokenIdentity(r *http.Request) string {
+	apiToken := r.URL.Query().Get("apitoken")
+	if apiToken == "" {
+		if id := clientCertIdentity(r); id != "" {
+			return id
+		}
+		return "-"
+	}
+	return apiTokenID(apiToken)
+}
+
+// statusRecorder records the status code and size of a reply.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	size   int64
+}
+
+func (sr *statusRecorder) WriteHeader(status int) {
+	if sr.status == 0 {
+		sr.status = status
+	}
+	sr.ResponseWriter.WriteHeader(status)
+}
+
+func (sr *statusRecorder) Write(b []byte) (int, error) {
+	if sr.status == 0 {
+		sr.status = http.StatusOK
+	}
+	n, err := sr.ResponseWriter.Write(b)
+	sr.size += int64(n)
+	return n, err
+}
+
+// Unwrap returns the wrapped writer so that http.ResponseController is able
+// to flush streamed replies.
+func (sr *statusRecorder) Unwrap() http.ResponseWriter {
+	return sr.ResponseWriter
+}
+
+// logRequests assigns every request an ID, returns it in the reply and makes
+// it available to the handlers through the request context.  Once a request
+// was handled a line is written to the access log.
+func logRequests(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		id := r.Header.Get(requestIDHeader)
+		if !regexpRequestID.MatchString(id) {
+			var b [requestIDSize]byte
+			if _, err := rand.Read(b[:]); err != nil {
+				log.Errorf("Request ID: %v", err)
+			}
+			id = hex.EncodeToString(b[:])
+		}
+		w.Header().Set(requestIDHeader, id)
+		r = r.WithContext(context.WithValue(r.Context(), requestIDKey{},
+			id))
+
+		sr := &statusRecorder{ResponseWriter: w}
+		h.ServeHTTP(sr, r)
+		if sr.status == 0 {
+			sr.status = http.StatusOK
+		}
+
+		forwarded := r.Header.Get(forward)
+		if forwarded == "" {
+			forwarded = "-"
+		}
+		accessLog.Infof("id=%v method=%v path=%q status=%v bytes=%v "+
+			"latency=%v remote=%v forwarded=%q token=%v", id, r.Method,
+			r.URL.Path, sr.status, sr.size,
+			time.Since(start).Round(time.Microsecond), r.RemoteAddr,
+			forwarded, tokenIdentity(r))
+	})
+}
@@ -0,0 +1,149 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	v2 "github.com/decred/dcrtime/api/v2"
+	"github.com/decred/dcrtime/dcrtimed/backend"
+	"github.com/decred/dcrtime/util"
+)
+
+// digestQuery validates a search request and returns its backend query.
+func digestQuery(s v2.Search) (backend.DigestQuery, error) {
+	q := backend.DigestQuery{
+		Collection:    s.Name,
+		From:          s.From,
+		To:            s.To,
+		MetadataKey:   s.MetadataKey,
+		MetadataValue: s.MetadataValue,
+		Cursor:        s.Cursor,
+		Limit:         s.Limit,
+	}
+	if !v2.RegexpCollectionName.MatchString(s.Name) {
+		return q, errors.New("invalid collection name")
+	}
+	if s.From < 0 || s.To < 0 || (s.To != 0 && s.To < s.From) {
+		return q, errors.New("invalid time range")
+	}
+	switch s.Status {
+	case "":
+	case v2.SearchStatusAnchored:
+		q.Anchored = true
+	case v2.SearchStatusPending:
+		q.Pending = true
+	default:
+		return q, errors.New("invalid status")
+	}
+	if (s.MetadataKey != "" || s.MetadataValue != "") &&
+		!v2.RegexpMetadataKey.MatchString(s.MetadataKey) {
+		return q, errors.New("invalid metadata key")
+	}
+	if s.Limit < 0 || s.Limit > v2.MaxSearchResults {
+		return q, fmt.Errorf("invalid limit, max is %v",
+			v2.MaxSearchResults)
+	}
+	if q.Limit == 0 {
+		q.Limit = v2.MaxSearchResults
+	}
+	return q, nil
+}
+
+// searchV2 returns the digests that the api token of the request timestamped
+// and that match the search filters, one page at a time.  Private metadata is
+// only returned if it was stored by the same api token.
+// Handles /v2/search
+func (d *DcrtimeStore) searchV2(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	owner := d.collectionOwner(r)
+	if owner == "" {
+		util.RespondWithError(w, http.StatusUnauthorized, "not authorized")
+		return
+	}
+
+	var s v2.Search
+	if !decodeCollection(w, r.Body, &s) {
+		return
+	}
+	q, err := digestQuery(s)
+	if err != nil {
+		util.RespondWithError(w, http.StatusBadRequest,
+			fmt.Sprintf("Invalid search: %v", err))
+		return
+	}
+
+	matches, next, err := d.backend.SearchDigests(owner, q)
+	if err != nil {
+		if errors.Is(err, backend.ErrInvalidCursor) {
+			util.RespondWithError(w, http.StatusBadRequest,
+				"Invalid cursor")
+			return
+		}
+
+		errorCode := time.Now().Unix()
+		log.Errorf("%v Search error code %v: %v", logAddr(r),
+			errorCode, err)
+		util.RespondWithError(w, http.StatusInternalServerError,
+			fmt.Sprintf("failed to search digests, "+
+				"contact administrator and provide "+
+				"the following error code: %v", errorCode))
+		return
+	}
+
+	reply := v2.SearchReply{
+		ID:         s.ID,
+		Digests:    make([]v2.SearchDigest, 0, len(matches)),
+		NextCursor: next,
+	}
+	for _, m := range matches {
+		sd := v2.SearchDigest{
+			Digest:          hex.EncodeToString(m.Digest[:]),
+			ServerTimestamp: m.Timestamp,
+			ServerTime:      v2.FormatTime(m.Timestamp),
+			Name:            m.Collection,
+			Anchored:        m.Anchored,
+		}
+		if m.Metadata != nil && (!m.Metadata.Private ||
+			m.Metadata.Owner == owner) {
+			sd.Metadata = m.Metadata.Values
+		}
+		reply.Digests = append(reply.Digests, sd)
+	}
+
+	log.Infof("%v Search %v: %v digests", r.URL.Path, logAddr(r),
+		len(reply.Digests))
+
+	util.RespondWithJSON(w, http.StatusOK, reply)
+}
+
+// proxySearchV2 forwards a search to the storehost.
+func (d *DcrtimeStore) proxySearchV2(w http.ResponseWriter, r *http.Request) {
+	b, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		util.RespondWithError(w, http.StatusBadRequest,
+			"Unable to read request")
+		return
+	}
+
+	var s v2.Search
+	if !decodeCollection(w, bytes.NewReader(b), &s) {
+		return
+	}
+
+	d.sendToBackend(r.Context(), w, r.Method,
+		withAPIToken(v2.SearchRoute, r), r.Header.Get("Content-Type"),
+		r.RemoteAddr, bytes.NewReader(b))
+
+	log.Infof("%v Search %v", r.URL.Path, logAddr(r))
+}
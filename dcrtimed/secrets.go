@@ -0,0 +1,120 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/decred/dcrtime/dcrtimed/secrets"
+)
+
+const (
+	// secretsPassEnv is the environment variable that holds the passphrase
+	// of the secrets file when secretspassfile is not set.
+	secretsPassEnv = "DCRTIMED_SECRETS_PASSPHRASE"
+)
+
+// readSecretFile returns the contents of a file that holds a single secret
+// without surrounding white space.
+func readSecretFile(filename string) ([]byte, error) {
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.TrimSpace(b), nil
+}
+
+// secretsConfig returns the configuration of the secret stores.  Settings that
+// are not in the configuration are taken from the environment variables the
+// Vault and AWS command line tools use.
+func secretsConfig(cfg *config) (*secrets.Config, error) {
+	sc := secrets.Config{
+		VaultAddr:       cfg.VaultAddr,
+		VaultToken:      os.Getenv("VAULT_TOKEN"),
+		AWSRegion:       cfg.AWSRegion,
+		AWSAccessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		AWSSecretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		AWSSessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		File:            cfg.SecretsFile,
+	}
+	if sc.VaultAddr == "" {
+		sc.VaultAddr = os.Getenv("VAULT_ADDR")
+	}
+	if cfg.VaultTokenFile != "" {
+		token, err := readSecretFile(cfg.VaultTokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("vaulttokenfile: %v", err)
+		}
+		sc.VaultToken = string(token)
+	}
+	if sc.AWSRegion == "" {
+		sc.AWSRegion = os.Getenv("AWS_REGION")
+	}
+	if sc.AWSRegion == "" {
+		sc.AWSRegion = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if cfg.SecretsFile != "" {
+		if cfg.SecretsPassFile != "" {
+			pass, err := readSecretFile(cfg.SecretsPassFile)
+			if err != nil {
+				return nil, fmt.Errorf("secretspassfile: %v", err)
+			}
+			sc.Passphrase = pass
+		} else {
+			sc.Passphrase = []byte(os.Getenv(secretsPassEnv))
+		}
+		if len(sc.Passphrase) == 0 {
+			return nil, fmt.Errorf("secretsfile requires secretspassfile "+
+				"or %v", secretsPassEnv)
+		}
+	}
+	return &sc, nil
+}
+
+// secretOption is an option that may reference a secret.
+type secretOption struct {
+	name  string
+	value *string
+}
+
+// resolveSecrets replaces the secret options that reference a secret store
+// with the secrets, see the secrets package.  The stores are only accessed if
+// an option references them.
+func resolveSecrets(cfg *config) error {
+	options := []secretOption{
+		{"walletpassphrase", &cfg.WalletPassphrase},
+		{"walletaccountpassphrase", &cfg.WalletAccountPass},
+		{"replicatetoken", &cfg.ReplicateToken},
+		{"alerts.matrixtoken", &cfg.Alerts.MatrixToken},
+		{"alerts.smtppass", &cfg.Alerts.SMTPPass},
+	}
+	for i := range cfg.APITokens {
+		options = append(options, secretOption{"apitoken",
+			&cfg.APITokens[i]})
+	}
+
+	var r *secrets.Resolver
+	for _, o := range options {
+		if !secrets.IsReference(strings.TrimSpace(*o.value)) {
+			continue
+		}
+		if r == nil {
+			sc, err := secretsConfig(cfg)
+			if err != nil {
+				return err
+			}
+			r = secrets.New(*sc)
+		}
+		secret, err := r.Resolve(strings.TrimSpace(*o.value))
+		if err != nil {
+			return fmt.Errorf("%v: %v", o.name, err)
+		}
+		*o.value = secret
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package secrets
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const (
+	// awsService is the signing name of AWS Secrets Manager.
+	awsService = "secretsmanager"
+
+	// awsTarget is the operation that reads a secret.
+	awsTarget = "secretsmanager.GetSecretValue"
+)
+
+// awsSecret is the reply of GetSecretValue.  Binary secrets are not supported.
+type awsSecret struct {
+	SecretString *string `json:"SecretString"`
+}
+
+// aws returns the AWS Secrets Manager secret that the provided reference, the
+// secret id or ARN optionally followed by #<field>, names.  With a field the
+// secret must be a JSON object and the field is returned.
+func (r *Resolver) aws(ref string) (string, error) {
+	id, name := splitField(ref)
+	if id == "" {
+		return "", fmt.Errorf("want %v<id>[#<field>]", PrefixAWS)
+	}
+	if r.cfg.AWSRegion == "" {
+		return "", fmt.Errorf("no aws region configured")
+	}
+	if r.cfg.AWSAccessKey == "" || r.cfg.AWSSecretKey == "" {
+		return "", fmt.Errorf("no aws credentials configured")
+	}
+
+	key := PrefixAWS + id
+	object, ok := r.objects[key]
+	if !ok {
+		body, err := json.Marshal(struct {
+			SecretID string `json:"SecretId"`
+		}{id})
+		if err != nil {
+			return "", err
+		}
+		url := r.cfg.AWSEndpoint
+		if url == "" {
+			url = "https://" + awsService + "." + r.cfg.AWSRegion +
+				".amazonaws.com/"
+		}
+		req, err := http.NewRequest(http.MethodPost, url,
+			bytes.NewReader(body))
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+		req.Header.Set("X-Amz-Target", awsTarget)
+		r.signAWS(req, body)
+
+		var reply awsSecret
+		if err := r.do(req, &reply); err != nil {
+			return "", err
+		}
+		if reply.SecretString == nil {
+			return "", fmt.Errorf("binary secrets are not supported")
+		}
+		object = []byte(*reply.SecretString)
+		r.objects[key] = object
+	}
+
+	if name == "" {
+		return string(object), nil
+	}
+	return field(object, name)
+}
+
+// hmacSHA256 returns the HMAC-SHA256 of data with the provided key.
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// awsSigningKey derives the signature version 4 signing key of a day, region
+// and service from the secret access key.
+func awsSigningKey(secret, date, region, service string) []byte {
+	k := hmacSHA256([]byte("AWS4"+secret), date)
+	k = hmacSHA256(k, region)
+	k = hmacSHA256(k, service)
+	return hmacSHA256(k, "aws4_request")
+}
+
+// signAWS signs the provided request with AWS signature version 4.  Only the
+// headers the request needs are signed.
+func (r *Resolver) signAWS(req *http.Request, body []byte) {
+	now := r.now().UTC()
+	date := now.Format("20060102")
+	stamp := now.Format("20060102T150405Z")
+	payload := sha256.Sum256(body)
+
+	req.Header.Set("X-Amz-Date", stamp)
+	if r.cfg.AWSSessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", r.cfg.AWSSessionToken)
+	}
+
+	// The signed headers must be sorted by their lower case names.
+	headers := []string{"content-type", "host", "x-amz-date"}
+	if r.cfg.AWSSessionToken != "" {
+		headers = append(headers, "x-amz-security-token")
+	}
+	headers = append(headers, "x-amz-target")
+	var canonical strings.Builder
+	for _, h := range headers {
+		v := req.Header.Get(h)
+		if h == "host" {
+			v = req.URL.Host
+		}
+		canonical.WriteString(h + ":" + strings.TrimSpace(v) + "\n")
+	}
+	signed := strings.Join(headers, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	request := strings.Join([]string{
+		req.Method,
+		path,
+		"", // Query
+		canonical.String(),
+		signed,
+		hex.EncodeToString(payload[:]),
+	}, "\n")
+	requestHash := sha256.Sum256([]byte(request))
+
+	scope := date + "/" + r.cfg.AWSRegion + "/" + awsService + "/aws4_request"
+	toSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		stamp,
+		scope,
+		hex.EncodeToString(requestHash[:]),
+	}, "\n")
+
+	k := awsSigningKey(r.cfg.AWSSecretKey, date, r.cfg.AWSRegion, awsService)
+	signature := hex.EncodeToString(hmacSHA256(k, toSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 "+
+		"Credential=%v/%v, SignedHeaders=%v, Signature=%v",
+		r.cfg.AWSAccessKey, scope, signed, signature))
+}
@@ -0,0 +1,136 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	// fileVersion is the version of the encrypted secrets file.
+	fileVersion = 1
+
+	// Parameters of the scrypt key derivation of version 1 secrets
+	// files.
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+
+	saltSize = 32
+	keySize  = 32
+)
+
+var (
+	// ErrPassphrase is returned when the secrets file can not be
+	// decrypted with the provided passphrase.
+	ErrPassphrase = errors.New("invalid passphrase or corrupt secrets file")
+)
+
+// secretsFile is the encrypted secrets file.  The secrets are a JSON object of
+// names and values, encrypted with AES-GCM and a key that is derived from the
+// passphrase with scrypt.
+type secretsFile struct {
+	Version uint   `json:"version"`
+	Salt    []byte `json:"salt"`
+	Nonce   []byte `json:"nonce"`
+	Secrets []byte `json:"secrets"`
+}
+
+// fileAEAD returns the AES-GCM cipher of the provided secrets file.
+func fileAEAD(sf *secretsFile, passphrase []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key(passphrase, sf.Salt, scryptN, scryptR, scryptP,
+		keySize)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// ReadFile decrypts the encrypted secrets file with the provided passphrase
+// and returns its secrets by name.
+func ReadFile(filename string, passphrase []byte) (map[string]string, error) {
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var sf secretsFile
+	if err := json.Unmarshal(b, &sf); err != nil {
+		return nil, fmt.Errorf("invalid secrets file: %v", err)
+	}
+	if sf.Version != fileVersion {
+		return nil, fmt.Errorf("unsupported secrets file version %v",
+			sf.Version)
+	}
+	aead, err := fileAEAD(&sf, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	if len(sf.Nonce) != aead.NonceSize() {
+		return nil, ErrPassphrase
+	}
+	payload, err := aead.Open(nil, sf.Nonce, sf.Secrets, nil)
+	if err != nil {
+		return nil, ErrPassphrase
+	}
+	secrets := make(map[string]string)
+	if err := json.Unmarshal(payload, &secrets); err != nil {
+		return nil, fmt.Errorf("invalid secrets: %v", err)
+	}
+	return secrets, nil
+}
+
+// WriteFile encrypts the provided secrets with the passphrase and writes them
+// to the secrets file, replacing it.  A new salt and nonce are used every
+// time.
+func WriteFile(filename string, passphrase []byte, secrets map[string]string) error {
+	if len(passphrase) == 0 {
+		return errors.New("empty passphrase")
+	}
+	sf := secretsFile{
+		Version: fileVersion,
+		Salt:    make([]byte, saltSize),
+	}
+	if _, err := rand.Read(sf.Salt); err != nil {
+		return err
+	}
+	aead, err := fileAEAD(&sf, passphrase)
+	if err != nil {
+		return err
+	}
+	sf.Nonce = make([]byte, aead.NonceSize())
+	if _, err := rand.Read(sf.Nonce); err != nil {
+		return err
+	}
+	payload, err := json.Marshal(secrets)
+	if err != nil {
+		return err
+	}
+	sf.Secrets = aead.Seal(nil, sf.Nonce, payload, nil)
+
+	b, err := json.MarshalIndent(sf, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Write a temporary file first so that an interrupted write does not
+	// destroy the secrets.
+	tmp := filename + ".tmp"
+	if err := os.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filename)
+}
@@ -0,0 +1,189 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+// Package secrets resolves configuration values that reference secrets kept
+// outside of the configuration file.  A value is a reference if it starts with
+// one of the following prefixes, all other values are returned as is:
+//
+//	vault:<path>#<field>    field of a HashiCorp Vault KV secret
+//	awssm:<id>[#<field>]    AWS Secrets Manager secret, or a field of its
+//	                        JSON object
+//	file:<name>             entry of the local encrypted secrets file
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// PrefixVault references a field of a HashiCorp Vault secret.
+	PrefixVault = "vault:"
+
+	// PrefixAWS references an AWS Secrets Manager secret.
+	PrefixAWS = "awssm:"
+
+	// PrefixFile references an entry of the encrypted secrets file.
+	PrefixFile = "file:"
+
+	// DefaultTimeout is the default timeout of a secret lookup.
+	DefaultTimeout = 30 * time.Second
+)
+
+// Config configures the secret stores references are resolved from.  Stores
+// that are not configured fail the references to them.
+type Config struct {
+	VaultAddr  string // Address of the Vault server
+	VaultToken string // Vault token
+
+	AWSRegion       string // AWS region of Secrets Manager
+	AWSAccessKey    string // AWS access key id
+	AWSSecretKey    string // AWS secret access key
+	AWSSessionToken string // AWS session token, optional
+	AWSEndpoint     string // Overrides the regional endpoint, optional
+
+	File       string // Encrypted secrets file
+	Passphrase []byte // Passphrase of the encrypted secrets file
+
+	Client  *http.Client  // Defaults to http.DefaultClient
+	Timeout time.Duration // Defaults to DefaultTimeout
+}
+
+// Resolver resolves secret references.  Each secret is looked up once.
+type Resolver struct {
+	cfg     Config
+	file    map[string]string // Decrypted secrets file, loaded on use
+	cache   map[string]string // Resolved references
+	objects map[string][]byte // Secret objects by store and path
+	now     func() time.Time  // Signing time of AWS requests
+}
+
+// New returns a resolver of the provided configuration.
+func New(cfg Config) *Resolver {
+	if cfg.Client == nil {
+		cfg.Client = http.DefaultClient
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = DefaultTimeout
+	}
+	return &Resolver{
+		cfg:     cfg,
+		cache:   make(map[string]string),
+		objects: make(map[string][]byte),
+		now:     time.Now,
+	}
+}
+
+// IsReference returns true if the provided value references a secret.
+func IsReference(value string) bool {
+	return strings.HasPrefix(value, PrefixVault) ||
+		strings.HasPrefix(value, PrefixAWS) ||
+		strings.HasPrefix(value, PrefixFile)
+}
+
+// splitField splits a reference into the secret and the field of the secret.
+func splitField(ref string) (string, string) {
+	i := strings.LastIndex(ref, "#")
+	if i < 0 {
+		return ref, ""
+	}
+	return ref[:i], ref[i+1:]
+}
+
+// Resolve returns the secret the provided value references, or the value if
+// it is not a reference.
+func (r *Resolver) Resolve(value string) (string, error) {
+	if !IsReference(value) {
+		return value, nil
+	}
+	if secret, ok := r.cache[value]; ok {
+		return secret, nil
+	}
+
+	var (
+		secret string
+		err    error
+	)
+	switch {
+	case strings.HasPrefix(value, PrefixVault):
+		secret, err = r.vault(strings.TrimPrefix(value, PrefixVault))
+	case strings.HasPrefix(value, PrefixAWS):
+		secret, err = r.aws(strings.TrimPrefix(value, PrefixAWS))
+	case strings.HasPrefix(value, PrefixFile):
+		secret, err = r.fileSecret(strings.TrimPrefix(value, PrefixFile))
+	}
+	if err != nil {
+		return "", fmt.Errorf("%v: %v", value, err)
+	}
+	if secret == "" {
+		return "", fmt.Errorf("%v: empty secret", value)
+	}
+	r.cache[value] = secret
+	return secret, nil
+}
+
+// fileSecret returns the provided entry of the encrypted secrets file.
+func (r *Resolver) fileSecret(name string) (string, error) {
+	if r.cfg.File == "" {
+		return "", fmt.Errorf("no secrets file configured")
+	}
+	if r.file == nil {
+		file, err := ReadFile(r.cfg.File, r.cfg.Passphrase)
+		if err != nil {
+			return "", err
+		}
+		r.file = file
+	}
+	secret, ok := r.file[name]
+	if !ok {
+		return "", fmt.Errorf("not in secrets file %v", r.cfg.File)
+	}
+	return secret, nil
+}
+
+// field returns the provided string field of a JSON object.
+func field(object []byte, name string) (string, error) {
+	var fields map[string]interface{}
+	if err := json.Unmarshal(object, &fields); err != nil {
+		return "", fmt.Errorf("secret is not a JSON object: %v", err)
+	}
+	v, ok := fields[name]
+	if !ok {
+		return "", fmt.Errorf("field %v not found", name)
+	}
+	s, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("field %v is not a string", name)
+	}
+	return s, nil
+}
+
+// do sends the provided request and decodes the JSON reply into v.
+func (r *Resolver) do(req *http.Request, v interface{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), r.cfg.Timeout)
+	defer cancel()
+	resp, err := r.cfg.Client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Errors  []string `json:"errors"`  // Vault
+			Message string   `json:"message"` // AWS
+		}
+		json.NewDecoder(resp.Body).Decode(&e)
+		msg := strings.Join(e.Errors, ", ")
+		if e.Message != "" {
+			msg = e.Message
+		}
+		return fmt.Errorf("%v %v", resp.Status, msg)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
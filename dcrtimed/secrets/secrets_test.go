@@ -0,0 +1,213 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package secrets
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestResolveLiteral(t *testing.T) {
+	r := New(Config{})
+	for _, v := range []string{"", "passphrase", "vault", "file;x"} {
+		s, err := r.Resolve(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s != v {
+			t.Fatalf("got %q want %q", s, v)
+		}
+	}
+
+	// References to stores that are not configured fail.
+	for _, v := range []string{"vault:secret/data/x#y", "awssm:x",
+		"file:x"} {
+		if _, err := r.Resolve(v); err == nil {
+			t.Fatalf("%v: expected error", v)
+		}
+	}
+}
+
+func TestVault(t *testing.T) {
+	requests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("X-Vault-Token") != "token" {
+			w.WriteHeader(http.StatusForbidden)
+			io.WriteString(w, `{"errors":["permission denied"]}`)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/secret/data/dcrtimed":
+			io.WriteString(w, `{"data":{"data":{"walletpassphrase":`+
+				`"wallet","apitoken":"token"},"metadata":{}}}`)
+		case "/v1/kv/dcrtimed":
+			io.WriteString(w, `{"data":{"walletpassphrase":"v1"}}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			io.WriteString(w, `{"errors":[]}`)
+		}
+	}))
+	defer ts.Close()
+
+	r := New(Config{
+		VaultAddr:  ts.URL + "/",
+		VaultToken: "token",
+	})
+	tests := []struct {
+		ref  string
+		want string
+	}{
+		{"vault:secret/data/dcrtimed#walletpassphrase", "wallet"},
+		{"vault:secret/data/dcrtimed#apitoken", "token"},
+		{"vault:/kv/dcrtimed#walletpassphrase", "v1"},
+	}
+	for _, test := range tests {
+		s, err := r.Resolve(test.ref)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s != test.want {
+			t.Fatalf("%v: got %q want %q", test.ref, s, test.want)
+		}
+	}
+	if requests != 2 {
+		t.Fatalf("got %v requests want 2", requests)
+	}
+
+	for _, ref := range []string{"vault:secret/data/dcrtimed#missing",
+		"vault:secret/data/other#walletpassphrase",
+		"vault:secret/data/dcrtimed"} {
+		if _, err := r.Resolve(ref); err == nil {
+			t.Fatalf("%v: expected error", ref)
+		}
+	}
+
+	r = New(Config{VaultAddr: ts.URL, VaultToken: "wrong"})
+	_, err := r.Resolve("vault:secret/data/dcrtimed#apitoken")
+	if err == nil || !strings.Contains(err.Error(), "permission denied") {
+		t.Fatalf("got %v want permission denied", err)
+	}
+}
+
+func TestAWSSigningKey(t *testing.T) {
+	// Example of the AWS signature version 4 documentation.
+	key := awsSigningKey("wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+		"20120215", "us-east-1", "iam")
+	want := "f4780e2d9f65fa895f9c67b32ce1baf0b0d8a43505a000a1a9e090d414db404d"
+	if hex.EncodeToString(key) != want {
+		t.Fatalf("got %x want %v", key, want)
+	}
+}
+
+func TestAWS(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=AKID/") ||
+			!strings.Contains(auth, "/us-east-1/secretsmanager/") ||
+			!strings.Contains(auth, "SignedHeaders=content-type;"+
+				"host;x-amz-date;x-amz-security-token;x-amz-target,") {
+			w.WriteHeader(http.StatusForbidden)
+			io.WriteString(w, `{"message":"bad signature"}`)
+			return
+		}
+		if r.Header.Get("X-Amz-Target") != awsTarget ||
+			r.Header.Get("X-Amz-Security-Token") != "session" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var req struct {
+			SecretID string `json:"SecretId"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		switch req.SecretID {
+		case "dcrtimed/wallet":
+			io.WriteString(w, `{"SecretString":"wallet"}`)
+		case "dcrtimed":
+			io.WriteString(w, `{"SecretString":"{\"apitoken\":`+
+				`\"token\"}"}`)
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, `{"message":"not found"}`)
+		}
+	}))
+	defer ts.Close()
+
+	r := New(Config{
+		AWSRegion:       "us-east-1",
+		AWSAccessKey:    "AKID",
+		AWSSecretKey:    "secret",
+		AWSSessionToken: "session",
+		AWSEndpoint:     ts.URL,
+	})
+	s, err := r.Resolve("awssm:dcrtimed/wallet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s != "wallet" {
+		t.Fatalf("got %q want wallet", s)
+	}
+	s, err = r.Resolve("awssm:dcrtimed#apitoken")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s != "token" {
+		t.Fatalf("got %q want token", s)
+	}
+	_, err = r.Resolve("awssm:missing")
+	if err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Fatalf("got %v want not found", err)
+	}
+}
+
+func TestFile(t *testing.T) {
+	dir, err := os.MkdirTemp("", "secrets.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "secrets.json")
+	err = WriteFile(filename, []byte("pass"), map[string]string{
+		"walletpassphrase": "wallet",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), "wallet") {
+		t.Fatalf("secrets stored in plaintext: %s", b)
+	}
+
+	r := New(Config{File: filename, Passphrase: []byte("pass")})
+	s, err := r.Resolve("file:walletpassphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s != "wallet" {
+		t.Fatalf("got %q want wallet", s)
+	}
+	if _, err := r.Resolve("file:apitoken"); err == nil {
+		t.Fatalf("expected missing secret to fail")
+	}
+
+	_, err = ReadFile(filename, []byte("wrong"))
+	if err != ErrPassphrase {
+		t.Fatalf("got %v want %v", err, ErrPassphrase)
+	}
+}
@@ -0,0 +1,64 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package secrets
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// vaultReply is the reply of a Vault secret read.  Version 2 of the KV
+// secrets engine nests the secret in data.data next to data.metadata,
+// version 1 returns it in data.
+type vaultReply struct {
+	Data json.RawMessage `json:"data"`
+}
+
+// vault returns the field of a Vault secret that the provided reference, the
+// API path of the secret followed by #<field>, names.  Secrets of version 2
+// KV engines are read from <mount>/data/<path>.
+func (r *Resolver) vault(ref string) (string, error) {
+	path, name := splitField(ref)
+	if path == "" || name == "" {
+		return "", fmt.Errorf("want %v<path>#<field>", PrefixVault)
+	}
+	if r.cfg.VaultAddr == "" {
+		return "", fmt.Errorf("no vault address configured")
+	}
+	if r.cfg.VaultToken == "" {
+		return "", fmt.Errorf("no vault token configured")
+	}
+
+	key := PrefixVault + path
+	object, ok := r.objects[key]
+	if !ok {
+		url := strings.TrimSuffix(r.cfg.VaultAddr, "/") + "/v1/" +
+			strings.TrimPrefix(path, "/")
+		req, err := http.NewRequest(http.MethodGet, url, nil)
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("X-Vault-Token", r.cfg.VaultToken)
+		var reply vaultReply
+		if err := r.do(req, &reply); err != nil {
+			return "", err
+		}
+		object = reply.Data
+
+		var kv2 struct {
+			Data     json.RawMessage `json:"data"`
+			Metadata json.RawMessage `json:"metadata"`
+		}
+		err = json.Unmarshal(object, &kv2)
+		if err == nil && kv2.Data != nil && kv2.Metadata != nil {
+			object = kv2.Data
+		}
+		r.objects[key] = object
+	}
+
+	return field(object, name)
+}
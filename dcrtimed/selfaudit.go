@@ -0,0 +1,179 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	v2 "github.com/decred/dcrtime/api/v2"
+	"github.com/decred/dcrtime/dcrtimed/backend"
+	"github.com/decred/dcrtime/merkle"
+)
+
+// selfAuditError is returned when a sampled digest failed a self audit.
+type selfAuditError struct {
+	digest string
+	err    error
+}
+
+func (e *selfAuditError) Error() string {
+	return fmt.Sprintf("digest %v: %v", e.digest, e.err)
+}
+
+func (e *selfAuditError) Unwrap() error {
+	return e.err
+}
+
+// auditSample verifies a sampled digest.  The merkle path that was derived
+// from the stored digests must lead to the anchored merkle root.  Once the
+// anchor was confirmed, the proof that is served for the digest must match it
+// and the anchor must still have enough confirmations.  It returns false if
+// the digest was not anchored with enough confirmations yet.
+func auditSample(s backend.AuditResult, gr backend.GetResult) (bool, error) {
+	if err := merkle.VerifyInclusion(&s.MerklePath, &s.Digest,
+		&s.MerkleRoot); err != nil {
+		return true, fmt.Errorf("stored digests do not lead to merkle "+
+			"root %x: %v", s.MerkleRoot, err)
+	}
+	if s.ChainTimestamp == 0 && s.Confirmations < gr.MinConfirmations {
+		return false, nil
+	}
+	if gr.ErrorCode != backend.ErrorOK {
+		return true, fmt.Errorf("not found: error code %v", gr.ErrorCode)
+	}
+	if gr.MerkleRoot != s.MerkleRoot || gr.Tx != s.Tx {
+		return true, fmt.Errorf("served proof of merkle root %x tx %v, "+
+			"anchored %x tx %v", gr.MerkleRoot, gr.Tx, s.MerkleRoot,
+			s.Tx)
+	}
+	root, err := merkle.VerifyAuthPath(&gr.MerklePath)
+	if err != nil || *root != s.MerkleRoot {
+		return true, fmt.Errorf("served merkle path does not lead to "+
+			"merkle root %x", s.MerkleRoot)
+	}
+	if s.Confirmations < gr.MinConfirmations {
+		return true, fmt.Errorf("anchor %v has %v confirmations, want %v",
+			s.Tx, s.Confirmations, gr.MinConfirmations)
+	}
+
+	return true, nil
+}
+
+// selfAudit samples anchored digests and verifies their merkle paths and
+// anchors.  It returns the number of digests that were audited and the
+// digests that failed.  An error is returned if the audit could not be
+// completed, e.g. because the wallet is unreachable.
+func (d *DcrtimeStore) selfAudit() (int, []error, error) {
+	samples, err := d.backend.SampleAnchored(d.cfg.SelfAuditSamples)
+	if err != nil {
+		return 0, nil, err
+	}
+	digests := make([][sha256.Size]byte, 0, len(samples))
+	for _, s := range samples {
+		digests = append(digests, s.Digest)
+	}
+	grs, err := d.backend.Get(digests)
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(grs) != len(samples) {
+		return 0, nil, fmt.Errorf("got %v results for %v digests",
+			len(grs), len(samples))
+	}
+
+	var (
+		audited  int
+		failures []error
+	)
+	for k, s := range samples {
+		anchored, err := auditSample(s, grs[k])
+		if err != nil {
+			failures = append(failures, &selfAuditError{
+				digest: hex.EncodeToString(s.Digest[:]),
+				err:    err,
+			})
+		}
+		if anchored {
+			audited++
+		}
+	}
+
+	return audited, failures, nil
+}
+
+// runSelfAudit runs a single self audit, records its outcome for the admin
+// status and notifies the sinks of every failure.  It returns false if the
+// self audit failed.
+func (d *DcrtimeStore) runSelfAudit() bool {
+	audited, failures, err := d.selfAudit()
+	if err != nil {
+		failures = append(failures, err)
+	}
+
+	now := time.Now().Unix()
+	d.selfAuditMtx.Lock()
+	d.selfAuditStats.Audits++
+	d.selfAuditStats.Samples += uint64(audited)
+	d.selfAuditStats.Failures += uint64(len(failures))
+	d.selfAuditStats.LastTimestamp = now
+	d.selfAuditStats.LastTime = v2.FormatTime(now)
+	if len(failures) != 0 {
+		d.selfAuditStats.LastError = failures[len(failures)-1].Error()
+	}
+	d.selfAuditMtx.Unlock()
+
+	for _, err := range failures {
+		var sae *selfAuditError
+		var digest string
+		if errors.As(err, &sae) {
+			digest = sae.digest
+		}
+		log.Errorf("Self audit failed: %v", err)
+		d.notify(v2.NotificationSelfAuditFailed, digest, err.Error())
+	}
+	if len(failures) == 0 {
+		log.Infof("Self audit: %v anchored digests verified", audited)
+	}
+
+	return len(failures) == 0
+}
+
+// selfAuditStatus returns the outcome of the self audits since the start of
+// day.
+func (d *DcrtimeStore) selfAuditStatus() v2.SelfAuditStats {
+	d.selfAuditMtx.Lock()
+	defer d.selfAuditMtx.Unlock()
+
+	return d.selfAuditStats
+}
+
+// selfAuditor runs a self audit every self audit interval.  A recovery is
+// notified once a self audit passes again after a failure.
+func (d *DcrtimeStore) selfAuditor() {
+	log.Infof("Self auditing %v anchored digests every %v",
+		d.cfg.SelfAuditSamples, d.cfg.SelfAuditInterval)
+
+	ticker := time.NewTicker(d.cfg.SelfAuditInterval)
+	defer ticker.Stop()
+	var failed bool
+	for {
+		select {
+		case <-d.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		passed := d.runSelfAudit()
+		if passed && failed {
+			d.notify(v2.NotificationSelfAuditRecovered, "",
+				"self audit passed")
+		}
+		failed = !passed
+	}
+}
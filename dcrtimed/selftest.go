@@ -0,0 +1,273 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	v2 "github.com/decred/dcrtime/api/v2"
+	"github.com/decred/dcrtime/merkle"
+)
+
+const (
+	// selfTestPoll is the time between verify requests while a self test
+	// waits for its canary digest to be anchored.
+	selfTestPoll = time.Minute
+
+	// selfTestRequestTimeout is the maximum time a single request of a self
+	// test may take.
+	selfTestRequestTimeout = 30 * time.Second
+)
+
+// selfTestError is returned when a self test failed.  Digest is the canary
+// digest or empty if it could not be submitted.
+type selfTestError struct {
+	digest string
+	err    error
+}
+
+func (e *selfTestError) Error() string {
+	if e.digest == "" {
+		return e.err.Error()
+	}
+	return fmt.Sprintf("canary %v: %v", e.digest, e.err)
+}
+
+func (e *selfTestError) Unwrap() error {
+	return e.err
+}
+
+// selfTestClient returns a client that trusts the certificate of the self
+// tested instance.  The system roots are trusted when there is no certificate
+// file, e.g. when the certificate is obtained through acme.
+func selfTestClient(certFile string) (*http.Client, error) {
+	if certFile == "" {
+		return &http.Client{Timeout: selfTestRequestTimeout}, nil
+	}
+	cert, err := os.ReadFile(certFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read self test cert %v: %v",
+			certFile, err)
+	}
+	certPool := x509.NewCertPool()
+	if !certPool.AppendCertsFromPEM(cert) {
+		return nil, fmt.Errorf("unable to load cert %v", certFile)
+	}
+
+	return &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{
+				RootCAs: certPool,
+			},
+		},
+		Timeout: selfTestRequestTimeout,
+	}, nil
+}
+
+// selfTestPost posts the JSON encoded request to the provided route of the
+// self tested instance and decodes the reply.  The first configured api token
+// is sent along so that the self test passes when the api is restricted.
+func (d *DcrtimeStore) selfTestPost(ctx context.Context, client *http.Client, route string, request, reply interface{}) error {
+	b, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	u := d.cfg.SelfTestURL + route
+	if len(d.cfg.APITokens) != 0 {
+		u += "?apitoken=" + url.QueryEscape(d.cfg.APITokens[0])
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u,
+		bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%v: %v", route, resp.Status)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(reply)
+}
+
+// verifyCanary verifies the proof of an anchored canary digest.  It returns
+// false if the digest is not anchored with enough confirmations yet.
+func verifyCanary(digest [sha256.Size]byte, vd v2.VerifyDigest) (bool, error) {
+	if vd.Result != v2.ResultOK {
+		return false, fmt.Errorf("verify result %v", v2.Result[vd.Result])
+	}
+	ci := vd.ChainInformation
+	if ci.ChainTimestamp == 0 || (ci.Confirmations != nil &&
+		*ci.Confirmations < ci.MinConfirmations) {
+		return false, nil
+	}
+
+	// Verify that the canary is included in the anchored merkle root.
+	merkleRoot, err := hex.DecodeString(ci.MerkleRoot)
+	if err != nil || len(merkleRoot) != sha256.Size {
+		return false, fmt.Errorf("invalid merkle root: %v",
+			ci.MerkleRoot)
+	}
+	var root [sha256.Size]byte
+	copy(root[:], merkleRoot)
+	branch := merkle.Branch(ci.MerklePath)
+	if err := merkle.VerifyInclusion(&branch, &digest, &root); err != nil {
+		return false, fmt.Errorf("invalid proof: %v", err)
+	}
+	if ci.Transaction == "" {
+		return false, errors.New("missing anchor transaction")
+	}
+
+	return true, nil
+}
+
+// selfTest timestamps a random canary digest through the self test URL, waits
+// for the next flush and the required confirmations and verifies the returned
+// proof.  It exercises the entire pipeline, including the storehost in proxy
+// mode and the wallet.
+func (d *DcrtimeStore) selfTest() error {
+	ctx, cancel := context.WithTimeout(d.ctx, d.cfg.SelfTestTimeout)
+	defer cancel()
+
+	client, err := selfTestClient(d.cfg.SelfTestCert)
+	if err != nil {
+		return &selfTestError{err: err}
+	}
+
+	var random [32]byte
+	if _, err := rand.Read(random[:]); err != nil {
+		return &selfTestError{err: err}
+	}
+	digest := sha256.Sum256(random[:])
+	canary := hex.EncodeToString(digest[:])
+
+	var tr v2.TimestampBatchReply
+	err = d.selfTestPost(ctx, client, v2.TimestampBatchRoute,
+		v2.TimestampBatch{
+			ID:      "selftest",
+			Label:   d.cfg.SelfTestLabel,
+			Digests: []string{canary},
+		}, &tr)
+	if err != nil {
+		return &selfTestError{err: fmt.Errorf("timestamp: %v", err)}
+	}
+	if len(tr.Results) != 1 || tr.Results[0] != v2.ResultOK {
+		return &selfTestError{
+			digest: canary,
+			err:    fmt.Errorf("timestamp rejected: %v", tr.Results),
+		}
+	}
+	log.Infof("Self test: timestamped canary %v at %v", canary,
+		time.Unix(tr.ServerTimestamp, 0).UTC().Format(fStr))
+
+	// The canary is not anchored before maintenance ends.
+	if tr.Maintenance {
+		log.Infof("Self test: skipped, instance is in maintenance")
+		return nil
+	}
+
+	ticker := time.NewTicker(selfTestPoll)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			err := ctx.Err()
+			if errors.Is(err, context.DeadlineExceeded) {
+				err = fmt.Errorf("not anchored within %v",
+					d.cfg.SelfTestTimeout)
+			}
+			return &selfTestError{digest: canary, err: err}
+		case <-ticker.C:
+		}
+
+		var vr v2.VerifyBatchReply
+		err := d.selfTestPost(ctx, client, v2.VerifyBatchRoute,
+			v2.VerifyBatch{
+				ID:         "selftest",
+				Digests:    []string{canary},
+				AccessKeys: tr.AccessKeys,
+			}, &vr)
+		if err != nil {
+			// The instance may be restarting, keep trying until the
+			// self test times out.
+			log.Warnf("Self test: verify: %v", err)
+			continue
+		}
+		if len(vr.Digests) != 1 || vr.Digests[0].Digest != canary {
+			return &selfTestError{
+				digest: canary,
+				err:    errors.New("verify returned other digests"),
+			}
+		}
+		anchored, err := verifyCanary(digest, vr.Digests[0])
+		if err != nil {
+			return &selfTestError{digest: canary, err: err}
+		}
+		if anchored {
+			log.Infof("Self test: canary %v anchored in %v", canary,
+				vr.Digests[0].ChainInformation.Transaction)
+			return nil
+		}
+	}
+}
+
+// runSelfTest runs a single self test and notifies the sinks if it failed.
+// It returns the error of the failed self test.
+func (d *DcrtimeStore) runSelfTest() error {
+	err := d.selfTest()
+	if err != nil {
+		var ste *selfTestError
+		var digest string
+		if errors.As(err, &ste) {
+			digest = ste.digest
+		}
+		log.Errorf("Self test failed: %v", err)
+		d.notify(v2.NotificationSelfTestFailed, digest, err.Error())
+	}
+	return err
+}
+
+// selfTester runs a self test every self test interval.  A recovery is
+// notified once a self test passes again after a failure.  Self tests do not
+// overlap, an interval that elapses while a self test is running is skipped.
+func (d *DcrtimeStore) selfTester() {
+	log.Infof("Self testing %v every %v", d.cfg.SelfTestURL,
+		d.cfg.SelfTestInterval)
+
+	ticker := time.NewTicker(d.cfg.SelfTestInterval)
+	defer ticker.Stop()
+	var failed bool
+	for {
+		select {
+		case <-d.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		err := d.runSelfTest()
+		if err == nil && failed {
+			d.notify(v2.NotificationSelfTestRecovered, "",
+				"self test passed")
+		}
+		failed = err != nil
+	}
+}
@@ -0,0 +1,358 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	v2 "github.com/decred/dcrtime/api/v2"
+	"github.com/decred/dcrtime/dcrtimed/backend"
+	"github.com/decred/dcrtime/util"
+)
+
+// sessionIDSize is the number of random bytes of a submission session ID.
+const sessionIDSize = 16
+
+// decodeSession decodes the request body into v.  It replies to the client and
+// returns false if the request is invalid.
+func decodeSession(w http.ResponseWriter, body io.Reader, v interface{}) bool {
+	decoder := json.NewDecoder(body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		util.RespondWithError(w, http.StatusBadRequest,
+			"Invalid request payload")
+		return false
+	}
+	return true
+}
+
+// validSessionID replies to the client and returns false if the session ID is
+// invalid.
+func validSessionID(w http.ResponseWriter, id string) bool {
+	if !v2.RegexpSessionID.MatchString(id) {
+		util.RespondWithError(w, http.StatusBadRequest,
+			"Invalid session ID")
+		return false
+	}
+	return true
+}
+
+// convertSession converts a backend session to its API representation.
+func (d *DcrtimeStore) convertSession(id string, s *backend.Session) v2.SessionReply {
+	reply := v2.SessionReply{
+		ID:               id,
+		SessionID:        s.ID,
+		Label:            s.Label,
+		Algorithm:        s.Algorithm,
+		DigestCount:      s.Digests,
+		MaxDigests:       s.MaxDigests,
+		Closed:           s.Closed != 0,
+		AcceptedCount:    s.Accepted,
+		ExistingCount:    s.Existing,
+		ExpiresTimestamp: s.Expires,
+		ExpiresTime:      v2.FormatTime(s.Expires),
+		Maintenance:      d.backend.Maintenance(),
+	}
+	if s.Timestamp != 0 {
+		reply.ServerTimestamp = s.Timestamp
+		reply.ServerTime = v2.FormatTime(s.Timestamp)
+	}
+	return reply
+}
+
+// respondWithSessionError replies to the client with the status that matches
+// the provided session error.
+func respondWithSessionError(w http.ResponseWriter, r *http.Request, method, action string, err error) {
+	switch {
+	case errors.Is(err, backend.ErrSessionNotFound):
+		util.RespondWithError(w, http.StatusNotFound,
+			"Session not found")
+	case errors.Is(err, backend.ErrSessionClosed):
+		util.RespondWithError(w, http.StatusConflict,
+			"Session is closed")
+	case errors.Is(err, backend.ErrSessionOffset):
+		util.RespondWithError(w, http.StatusConflict,
+			"Offset is beyond the staged digests")
+	case errors.Is(err, backend.ErrSessionFull):
+		util.RespondWithError(w, http.StatusRequestEntityTooLarge,
+			"Session is full")
+	case errors.Is(err, backend.ErrTryAgainLater):
+		util.RespondWithError(w, http.StatusServiceUnavailable,
+			"Server busy, please try again later.")
+	case errors.Is(err, backend.ErrReadOnly):
+		util.RespondWithError(w, http.StatusServiceUnavailable,
+			"Server is read-only")
+	case errors.Is(err, backend.ErrQueueFull):
+		util.RespondWithError(w, http.StatusServiceUnavailable,
+			"Server is in maintenance and its queue is full, "+
+				"please try again later.")
+	default:
+		errorCode := time.Now().Unix()
+		log.Errorf("%v %v error code %v: %v", logAddr(r), method,
+			errorCode, err)
+		util.RespondWithError(w, http.StatusInternalServerError,
+			fmt.Sprintf("failed to %v, "+
+				"contact administrator and provide "+
+				"the following error code: %v", action, errorCode))
+	}
+}
+
+// getSession returns the submission session with the provided ID.  Sessions
+// that were opened with an api token are only available to that token.
+func (d *DcrtimeStore) getSession(r *http.Request, id string) (*backend.Session, error) {
+	s, err := d.backend.GetSession(id)
+	if err != nil {
+		return nil, err
+	}
+	if s.Owner != "" && s.Owner != d.collectionOwner(r) {
+		return nil, backend.ErrSessionNotFound
+	}
+	return s, nil
+}
+
+// sessionExpires returns the expiration of a session that is used now.
+func (d *DcrtimeStore) sessionExpires() int64 {
+	return time.Now().Add(d.cfg.SessionTimeout).Unix()
+}
+
+// sessionOpenV2 opens a submission session.
+// Handles /v2/sessions/open
+func (d *DcrtimeStore) sessionOpenV2(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	var so v2.SessionOpen
+	if !decodeSession(w, r.Body, &so) {
+		return
+	}
+	if so.Label != "" && !v2.RegexpLabel.MatchString(so.Label) {
+		util.RespondWithError(w, http.StatusBadRequest,
+			"Invalid Label")
+		return
+	}
+	if !v2.IsAlgorithm(so.Algorithm) {
+		util.RespondWithError(w, http.StatusBadRequest,
+			fmt.Sprintf("Unsupported algorithm. Supported: %v",
+				strings.Join(v2.Algorithms, ", ")))
+		return
+	}
+
+	// Access keys are not returned for session digests, only the api
+	// token that timestamped them can see private digests.
+	owner := d.collectionOwner(r)
+	if d.cfg.PrivateDigests && owner == "" {
+		util.RespondWithError(w, http.StatusUnauthorized,
+			"not authorized")
+		return
+	}
+
+	var b [sessionIDSize]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		respondWithSessionError(w, r, "SessionOpen", "open session",
+			err)
+		return
+	}
+	now := time.Now().Unix()
+	s := backend.Session{
+		ID:         hex.EncodeToString(b[:]),
+		Owner:      owner,
+		Label:      so.Label,
+		Algorithm:  storedAlgorithm(so.Algorithm),
+		MaxDigests: d.cfg.SessionMaxDigests,
+		Created:    now,
+		Expires:    d.sessionExpires(),
+	}
+	if err := d.backend.CreateSession(s); err != nil {
+		respondWithSessionError(w, r, "SessionOpen", "open session",
+			err)
+		return
+	}
+
+	log.Infof("%v SessionOpen %v: %v", r.URL.Path, logAddr(r), s.ID)
+
+	util.RespondWithJSON(w, http.StatusOK, d.convertSession(so.ID, &s))
+}
+
+// sessionAppendV2 stages digests in an open submission session.
+// Handles /v2/sessions/append
+func (d *DcrtimeStore) sessionAppendV2(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	var sa v2.SessionAppend
+	if !decodeSession(w, r.Body, &sa) {
+		return
+	}
+	if !validSessionID(w, sa.SessionID) {
+		return
+	}
+	if sa.Offset < 0 {
+		util.RespondWithError(w, http.StatusBadRequest,
+			"Invalid offset")
+		return
+	}
+	if len(sa.Digests) > v2.MaxSessionAppendDigests {
+		util.RespondWithError(w, http.StatusBadRequest,
+			fmt.Sprintf("Too many digests, at most %v are allowed",
+				v2.MaxSessionAppendDigests))
+		return
+	}
+	digests, err := convertDigests(sa.Digests)
+	if err != nil {
+		util.RespondWithError(w, http.StatusBadRequest,
+			"Invalid Digests array")
+		return
+	}
+
+	if _, err := d.getSession(r, sa.SessionID); err != nil {
+		respondWithSessionError(w, r, "SessionAppend",
+			"append to session", err)
+		return
+	}
+	s, err := d.backend.AppendSession(sa.SessionID, sa.Offset, digests,
+		d.sessionExpires())
+	if err != nil {
+		respondWithSessionError(w, r, "SessionAppend",
+			"append to session", err)
+		return
+	}
+
+	log.Debugf("%v SessionAppend %v: %v offset %v digests %v", r.URL.Path,
+		logAddr(r), s.ID, sa.Offset, len(digests))
+
+	util.RespondWithJSON(w, http.StatusOK, d.convertSession(sa.ID, s))
+}
+
+// sessionCloseV2 closes a submission session, which timestamps all of its
+// digests in the current collection.
+// Handles /v2/sessions/close
+func (d *DcrtimeStore) sessionCloseV2(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	var sc v2.SessionClose
+	if !decodeSession(w, r.Body, &sc) {
+		return
+	}
+	if !validSessionID(w, sc.SessionID) {
+		return
+	}
+
+	s, err := d.getSession(r, sc.SessionID)
+	if err != nil {
+		respondWithSessionError(w, r, "SessionClose", "close session",
+			err)
+		return
+	}
+	if s.Closed != 0 {
+		respondWithSessionError(w, r, "SessionClose", "close session",
+			backend.ErrSessionClosed)
+		return
+	}
+
+	// Charge the staged digests to the quota of the api token.
+	refund, ok := d.chargeQuota(w, r, int(s.Digests))
+	if !ok {
+		return
+	}
+	s, me, err := d.backend.CloseSession(sc.SessionID, d.sessionExpires())
+	if err != nil {
+		refund()
+		respondWithSessionError(w, r, "SessionClose", "close session",
+			err)
+		return
+	}
+
+	// Record the api token as owner of the accepted digests.
+	if len(me) != 0 {
+		d.recordOwner(r, s.Timestamp, me)
+		d.recordSubmission(d.submissionClient(r), s.Timestamp, me)
+	}
+
+	via := logAddr(r)
+	xff := r.Header.Get(forward)
+	if xff != "" {
+		via = fmt.Sprintf("%v via %v", xff, logAddr(r))
+	}
+	log.Infof("%v SessionClose %v: %v %v accepted %v existing %v",
+		r.URL.Path, via, s.ID, time.Unix(s.Timestamp, 0).UTC().Format(fStr),
+		s.Accepted, s.Existing)
+
+	util.RespondWithJSON(w, http.StatusOK, d.convertSession(sc.ID, s))
+}
+
+// sessionStatusV2 returns the status of a submission session.  Clients use it
+// to resume appending after a reconnect and to learn the outcome of a close
+// whose reply was lost.
+// Handles /v2/sessions/status
+func (d *DcrtimeStore) sessionStatusV2(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	var ss v2.SessionStatus
+	if !decodeSession(w, r.Body, &ss) {
+		return
+	}
+	if !validSessionID(w, ss.SessionID) {
+		return
+	}
+
+	s, err := d.getSession(r, ss.SessionID)
+	if err != nil {
+		respondWithSessionError(w, r, "SessionStatus",
+			"retrieve session", err)
+		return
+	}
+
+	log.Debugf("%v SessionStatus %v: %v", r.URL.Path, logAddr(r), s.ID)
+
+	util.RespondWithJSON(w, http.StatusOK, d.convertSession(ss.ID, s))
+}
+
+// proxySession forwards a session request to the storehost.  Sessions are
+// stored by the storehost, they are not submitted to fanout storehosts.
+func (d *DcrtimeStore) proxySession(w http.ResponseWriter, r *http.Request, route, method string, v interface{}) {
+	b, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		util.RespondWithError(w, http.StatusBadRequest,
+			"Unable to read request")
+		return
+	}
+
+	if !decodeSession(w, bytes.NewReader(b), v) {
+		return
+	}
+
+	d.sendToBackend(r.Context(), w, r.Method, withAPIToken(route, r),
+		r.Header.Get("Content-Type"), r.RemoteAddr, bytes.NewReader(b))
+
+	log.Infof("%v %v %v", r.URL.Path, method, logAddr(r))
+}
+
+func (d *DcrtimeStore) proxySessionOpenV2(w http.ResponseWriter, r *http.Request) {
+	var so v2.SessionOpen
+	d.proxySession(w, r, v2.SessionOpenRoute, "SessionOpen", &so)
+}
+
+func (d *DcrtimeStore) proxySessionAppendV2(w http.ResponseWriter, r *http.Request) {
+	var sa v2.SessionAppend
+	d.proxySession(w, r, v2.SessionAppendRoute, "SessionAppend", &sa)
+}
+
+func (d *DcrtimeStore) proxySessionCloseV2(w http.ResponseWriter, r *http.Request) {
+	var sc v2.SessionClose
+	d.proxySession(w, r, v2.SessionCloseRoute, "SessionClose", &sc)
+}
+
+func (d *DcrtimeStore) proxySessionStatusV2(w http.ResponseWriter, r *http.Request) {
+	var ss v2.SessionStatus
+	d.proxySession(w, r, v2.SessionStatusRoute, "SessionStatus", &ss)
+}
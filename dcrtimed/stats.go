@@ -0,0 +1,160 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	v2 "github.com/decred/dcrtime/api/v2"
+	"github.com/decred/dcrtime/dcrtimed/backend"
+	"github.com/decred/dcrtime/util"
+)
+
+// submissionClient returns the identity submissions of the request are
+// counted under: the public ID of its api token, or - if the request does not
+// carry a valid api token.
+func (d *DcrtimeStore) submissionClient(r *http.Request) string {
+	if len(d.tokenScopes(r)) == 0 {
+		if id := clientCertIdentity(r); id != "" {
+			return id
+		}
+		return "-"
+	}
+	return apiTokenID(r.URL.Query().Get("apitoken"))
+}
+
+// recordSubmission counts the digests the client submitted to the collection
+// with the provided timestamp, and those among them that already existed.
+// Failures are logged only since the digests were already timestamped.
+func (d *DcrtimeStore) recordSubmission(client string, ts int64, me []backend.PutResult) {
+	var duplicates int
+	for _, v := range me {
+		if v.ErrorCode == backend.ErrorExists {
+			duplicates++
+		}
+	}
+
+	err := d.backend.PutSubmission(ts, client, len(me), duplicates)
+	if err != nil {
+		log.Errorf("recordSubmission %v: %v", ts, err)
+	}
+}
+
+// decodeCollectionStats decodes the request body into cs and validates the
+// timestamp range.  It responds with an error and returns false if the
+// request is invalid.
+func decodeCollectionStats(w http.ResponseWriter, body io.Reader, cs *v2.CollectionStats) bool {
+	decoder := json.NewDecoder(body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(cs); err != nil {
+		util.RespondWithError(w, http.StatusBadRequest,
+			"Invalid request payload")
+		return false
+	}
+	if cs.FromTimestamp < 0 || cs.FromTimestamp > cs.ToTimestamp {
+		util.RespondWithError(w, http.StatusBadRequest,
+			"Invalid timestamp range")
+		return false
+	}
+	if cs.ToTimestamp-cs.FromTimestamp >= v2.MaxCollectionStatsRange {
+		util.RespondWithError(w, http.StatusUnprocessableEntity,
+			fmt.Sprintf("Timestamp range exceeds %v seconds",
+				v2.MaxCollectionStatsRange))
+		return false
+	}
+	return true
+}
+
+// convertCollectionStats converts backend collection statistics to their API
+// representation.
+func convertCollectionStats(cs backend.CollectionStats) v2.CollectionStat {
+	return v2.CollectionStat{
+		ServerTimestamp: cs.Timestamp,
+		ServerTime:      v2.FormatTime(cs.Timestamp),
+		DigestCount:     cs.Digests,
+		Submitted:       cs.Submitted,
+		Duplicates:      cs.Duplicates,
+		Clients:         cs.Clients,
+		MerkleRoot:      hex.EncodeToString(cs.MerkleRoot[:]),
+		Transaction:     cs.Tx.String(),
+		Confirmed:       cs.BlockHeight != 0,
+		BlockHeight:     cs.BlockHeight,
+		ChainTimestamp:  cs.ChainTimestamp,
+		ChainTime:       v2.FormatTime(cs.ChainTimestamp),
+	}
+}
+
+// collectionStatsV2 returns the submission and anchor statistics of all
+// flushed collections in a timestamp range, e.g. for transparency reports.
+// Handles /v2/admin/collectionstats
+func (d *DcrtimeStore) collectionStatsV2(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	if !d.isAuthorized(r, v2.TokenScopeAdmin) {
+		d.respondNotAuthorized(w, r)
+		return
+	}
+
+	var cs v2.CollectionStats
+	if !decodeCollectionStats(w, r.Body, &cs) {
+		return
+	}
+
+	log.Infof("%v CollectionStats %v: %v-%v", r.URL.Path, logAddr(r),
+		cs.FromTimestamp, cs.ToTimestamp)
+
+	stats, err := d.backend.GetCollectionStats(cs.FromTimestamp,
+		cs.ToTimestamp)
+	if err != nil {
+		errorCode := time.Now().Unix()
+		log.Errorf("%v CollectionStats error code %v: %v", logAddr(r),
+			errorCode, err)
+		util.RespondWithError(w, http.StatusInternalServerError,
+			fmt.Sprintf("failed to retrieve collection statistics, "+
+				"contact administrator and provide "+
+				"the following error code: %v", errorCode))
+		return
+	}
+
+	reply := v2.CollectionStatsReply{
+		FromTimestamp: cs.FromTimestamp,
+		ToTimestamp:   cs.ToTimestamp,
+		Collections:   make([]v2.CollectionStat, 0, len(stats)),
+	}
+	for _, s := range stats {
+		reply.Collections = append(reply.Collections,
+			convertCollectionStats(s))
+	}
+
+	util.RespondWithJSON(w, http.StatusOK, reply)
+}
+
+func (d *DcrtimeStore) proxyCollectionStatsV2(w http.ResponseWriter, r *http.Request) {
+	b, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		util.RespondWithError(w, http.StatusBadRequest,
+			"Unable to read request")
+		return
+	}
+
+	var cs v2.CollectionStats
+	if !decodeCollectionStats(w, bytes.NewReader(b), &cs) {
+		return
+	}
+
+	d.sendToBackend(r.Context(), w, r.Method,
+		withAPIToken(v2.CollectionStatsRoute, r),
+		r.Header.Get("Content-Type"), r.RemoteAddr, bytes.NewReader(b))
+
+	log.Infof("%v CollectionStats %v: %v-%v", r.URL.Path, logAddr(r),
+		cs.FromTimestamp, cs.ToTimestamp)
+}
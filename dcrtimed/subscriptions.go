@@ -0,0 +1,383 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/decred/dcrd/chaincfg/chainhash"
+	v2 "github.com/decred/dcrtime/api/v2"
+	"github.com/decred/dcrtime/dcrtimed/backend"
+	"github.com/decred/dcrtime/util"
+)
+
+const (
+	// subscriptionIDSize is the size of a random webhook subscription ID.
+	subscriptionIDSize = 16
+
+	// maxWebhookURLLength is the maximum length of a webhook URL.
+	maxWebhookURLLength = 2048
+
+	// maxOwnerSubscriptions is the maximum number of pending webhook
+	// subscriptions of an api token.
+	maxOwnerSubscriptions = 1000
+)
+
+// errTooManySubscriptions is returned when an api token has too many pending
+// webhook subscriptions.
+var errTooManySubscriptions = errors.New("too many webhook subscriptions")
+
+// validWebhookURL returns true if the provided URL may receive webhooks.
+func validWebhookURL(webhookURL string) bool {
+	if len(webhookURL) > maxWebhookURLLength {
+		return false
+	}
+	u, err := url.Parse(webhookURL)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") &&
+		u.Host != ""
+}
+
+// subscriptionDeliveryID returns the ID of the webhook delivery of the
+// provided event of a subscription.  IDs sort by collection timestamp and the
+// event that a collection is included in a block sorts before the event that
+// it has enough confirmations.
+func subscriptionDeliveryID(sub backend.Subscription, event string) string {
+	var id [16]byte
+	binary.BigEndian.PutUint64(id[:8], uint64(sub.Timestamp))
+	if event == v2.WebhookEventConfirmed {
+		id[8] = 1
+	}
+	hash := sha256.Sum256([]byte(sub.ID))
+	copy(id[9:], hash[:7])
+	return hex.EncodeToString(id[:])
+}
+
+// checkWebhookURL validates the optional webhook URL of a submission.  It
+// replies to the client and returns false if it may not be used.
+func (d *DcrtimeStore) checkWebhookURL(w http.ResponseWriter, r *http.Request, webhookURL string) bool {
+	switch {
+	case webhookURL == "":
+		return true
+	case !d.cfg.WebhookSubscriptions:
+		util.RespondWithError(w, http.StatusBadRequest,
+			"Webhook subscriptions are disabled")
+		return false
+	case d.collectionOwner(r) == "":
+		util.RespondWithError(w, http.StatusBadRequest,
+			"Webhook subscriptions require an api token")
+		return false
+	case !validWebhookURL(webhookURL):
+		util.RespondWithError(w, http.StatusBadRequest,
+			"Invalid webhook URL")
+		return false
+	}
+	return true
+}
+
+// subscribe registers the provided webhook URL for the anchor of the
+// collection with the provided timestamp on behalf of the provided api token
+// owner and returns the ID of the subscription.
+func (d *DcrtimeStore) subscribe(owner, webhookURL string, ts int64, digests []string) (string, error) {
+	subs, err := d.backend.GetSubscriptions()
+	if err != nil {
+		return "", err
+	}
+	var pending int
+	for _, sub := range subs {
+		if sub.Owner == owner {
+			pending++
+		}
+	}
+	if pending >= maxOwnerSubscriptions {
+		return "", errTooManySubscriptions
+	}
+
+	var b [subscriptionIDSize]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	sub := backend.Subscription{
+		ID:        hex.EncodeToString(b[:]),
+		Owner:     owner,
+		URL:       webhookURL,
+		Timestamp: ts,
+		Digests:   digests,
+		Created:   time.Now().Unix(),
+	}
+	if err := d.backend.PutSubscription(sub, nil); err != nil {
+		return "", err
+	}
+	return sub.ID, nil
+}
+
+// subscribeSubmission registers the webhook URL of a submission for the
+// anchor of its accepted digests and returns the ID of the subscription.  The
+// digests were stored already, so failures are logged only.
+func (d *DcrtimeStore) subscribeSubmission(r *http.Request, webhookURL string, ts int64, me []backend.PutResult) string {
+	if webhookURL == "" {
+		return ""
+	}
+	var digests []string
+	for _, v := range me {
+		if v.ErrorCode == backend.ErrorOK {
+			digests = append(digests, hex.EncodeToString(v.Digest[:]))
+		}
+	}
+	if len(digests) == 0 {
+		return ""
+	}
+	id, err := d.subscribe(d.collectionOwner(r), webhookURL, ts,
+		digests)
+	if err != nil {
+		log.Errorf("%v webhook subscription: %v", logAddr(r), err)
+		return ""
+	}
+	log.Debugf("%v webhook subscription %v: %v digests of %v",
+		logAddr(r), id, len(digests), ts)
+	return id
+}
+
+// newSubscriptionDelivery returns the webhook delivery of the provided event of
+// a subscription.
+func newSubscriptionDelivery(sub backend.Subscription, event string, anchor v2.Anchor, confirmations *int32, now int64) (backend.Delivery, error) {
+	id := subscriptionDeliveryID(sub, event)
+	payload, err := json.Marshal(v2.Webhook{
+		ID:             id,
+		Event:          event,
+		Network:        netName(activeNetParams),
+		Anchor:         anchor,
+		SubscriptionID: sub.ID,
+		Confirmations:  confirmations,
+	})
+	if err != nil {
+		return backend.Delivery{}, err
+	}
+	return backend.Delivery{
+		ID:          id,
+		URL:         sub.URL,
+		Payload:     payload,
+		Created:     now,
+		NextAttempt: now,
+	}, nil
+}
+
+// enqueueSubscriptions enqueues the webhooks of the subscriptions whose anchor
+// was included in a block or has enough confirmations since the last call.
+// Subscriptions are removed once their last webhook was enqueued.  A
+// subscription and its webhooks are stored atomically, so no webhook is
+// skipped or enqueued twice across restarts.
+func (d *DcrtimeStore) enqueueSubscriptions() error {
+	subs, err := d.backend.GetSubscriptions()
+	if err != nil || len(subs) == 0 {
+		return err
+	}
+
+	// Look up every collection once.
+	seen := make(map[int64]struct{}, len(subs))
+	timestamps := make([]int64, 0, len(subs))
+	for _, sub := range subs {
+		if _, ok := seen[sub.Timestamp]; ok {
+			continue
+		}
+		seen[sub.Timestamp] = struct{}{}
+		timestamps = append(timestamps, sub.Timestamp)
+	}
+	trs, err := d.backend.GetTimestamps(timestamps)
+	if err != nil {
+		return err
+	}
+	collections := make(map[int64]backend.TimestampResult, len(trs))
+	for _, tr := range trs {
+		collections[tr.Timestamp] = tr
+	}
+
+	now := time.Now().Unix()
+	var enqueued int
+	for _, sub := range subs {
+		tr, ok := collections[sub.Timestamp]
+		if !ok || tr.ErrorCode != backend.ErrorOK ||
+			tr.Tx == (chainhash.Hash{}) {
+			continue
+		}
+		confirmed := tr.AnchoredTimestamp != 0
+		included := confirmed ||
+			(tr.Confirmations != nil && *tr.Confirmations > 0)
+		if !included || (sub.Included && !confirmed) {
+			continue
+		}
+
+		anchor := v2.Anchor{
+			ServerTimestamp: tr.Timestamp,
+			ServerTime:      v2.FormatTime(tr.Timestamp),
+			Transaction:     tr.Tx.String(),
+			MerkleRoot:      hex.EncodeToString(tr.MerkleRoot[:]),
+			ChainTimestamp:  tr.AnchoredTimestamp,
+			ChainTime:       v2.FormatTime(tr.AnchoredTimestamp),
+			DigestCount:     len(tr.Digests),
+			Digests:         sub.Digests,
+		}
+		var deliveries []backend.Delivery
+		if !sub.Included {
+			wd, err := newSubscriptionDelivery(sub,
+				v2.WebhookEventIncluded, anchor,
+				tr.Confirmations, now)
+			if err != nil {
+				return err
+			}
+			deliveries = append(deliveries, wd)
+			sub.Included = true
+		}
+		if !confirmed {
+			err := d.backend.PutSubscription(sub, deliveries)
+			if err != nil {
+				return err
+			}
+			enqueued += len(deliveries)
+			continue
+		}
+
+		ac, err := d.backend.GetAnchorBlock(sub.Timestamp)
+		if err != nil {
+			return fmt.Errorf("anchor block %v: %v", sub.Timestamp,
+				err)
+		}
+		anchor.BlockHeight = ac.BlockHeight
+		confirmations := d.cfg.Confirmations
+		wd, err := newSubscriptionDelivery(sub, v2.WebhookEventConfirmed,
+			anchor, &confirmations, now)
+		if err != nil {
+			return err
+		}
+		deliveries = append(deliveries, wd)
+		err = d.backend.DeleteSubscription(sub.ID, deliveries)
+		if errors.Is(err, backend.ErrSubscriptionNotFound) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		enqueued += len(deliveries)
+	}
+
+	if enqueued != 0 {
+		log.Infof("Webhooks: enqueued %v subscription deliveries",
+			enqueued)
+	}
+
+	return nil
+}
+
+// decodeWebhookSubscribe decodes and validates a webhook subscription request.
+// It replies with an error and returns false if the request is invalid.
+func decodeWebhookSubscribe(w http.ResponseWriter, body io.Reader, ws *v2.WebhookSubscribe) bool {
+	decoder := json.NewDecoder(body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(ws); err != nil {
+		util.RespondWithError(w, http.StatusBadRequest,
+			"Invalid request payload")
+		return false
+	}
+	if ws.ServerTimestamp <= 0 {
+		util.RespondWithError(w, http.StatusBadRequest,
+			"Invalid timestamp")
+		return false
+	}
+	if !validWebhookURL(ws.URL) {
+		util.RespondWithError(w, http.StatusBadRequest,
+			"Invalid webhook URL")
+		return false
+	}
+	return true
+}
+
+// webhookSubscribeV2 registers a webhook URL for the anchor of a collection.
+// Handles /v2/webhooks/subscribe
+func (d *DcrtimeStore) webhookSubscribeV2(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	if !d.cfg.WebhookSubscriptions {
+		util.RespondWithError(w, http.StatusBadRequest,
+			"Webhook subscriptions are disabled")
+		return
+	}
+	if d.collectionOwner(r) == "" {
+		util.RespondWithError(w, http.StatusUnauthorized,
+			"not authorized")
+		return
+	}
+
+	var ws v2.WebhookSubscribe
+	if !decodeWebhookSubscribe(w, r.Body, &ws) {
+		return
+	}
+
+	trs, err := d.backend.GetTimestamps([]int64{ws.ServerTimestamp})
+	var id string
+	if err == nil && trs[0].ErrorCode == backend.ErrorOK {
+		id, err = d.subscribe(d.collectionOwner(r), ws.URL,
+			ws.ServerTimestamp, nil)
+	}
+	switch {
+	case errors.Is(err, errTooManySubscriptions):
+		util.RespondWithError(w, http.StatusTooManyRequests,
+			fmt.Sprintf("Too many webhook subscriptions, at most "+
+				"%v may be pending", maxOwnerSubscriptions))
+		return
+	case err != nil:
+		errorCode := time.Now().Unix()
+		log.Errorf("%v WebhookSubscribe error code %v: %v", logAddr(r),
+			errorCode, err)
+		util.RespondWithError(w, http.StatusInternalServerError,
+			fmt.Sprintf("failed to subscribe webhook, contact "+
+				"administrator and provide the following "+
+				"error code: %v", errorCode))
+		return
+	case id == "":
+		util.RespondWithError(w, http.StatusNotFound,
+			"Collection not found")
+		return
+	}
+
+	log.Infof("%v WebhookSubscribe %v: %v collection %v", r.URL.Path,
+		logAddr(r), id, ws.ServerTimestamp)
+
+	util.RespondWithJSON(w, http.StatusOK, v2.WebhookSubscribeReply{
+		ID:             ws.ID,
+		SubscriptionID: id,
+	})
+}
+
+// proxyWebhookSubscribeV2 relays a webhook subscription to the storehost.
+func (d *DcrtimeStore) proxyWebhookSubscribeV2(w http.ResponseWriter, r *http.Request) {
+	b, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		util.RespondWithError(w, http.StatusBadRequest,
+			"Unable to read request")
+		return
+	}
+
+	var ws v2.WebhookSubscribe
+	if !decodeWebhookSubscribe(w, bytes.NewReader(b), &ws) {
+		return
+	}
+
+	d.sendToBackend(r.Context(), w, r.Method,
+		withAPIToken(v2.WebhookSubscribeRoute, r),
+		r.Header.Get("Content-Type"), r.RemoteAddr, bytes.NewReader(b))
+
+	log.Infof("%v WebhookSubscribe %v: collection %v", r.URL.Path,
+		logAddr(r), ws.ServerTimestamp)
+}
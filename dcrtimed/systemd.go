@@ -0,0 +1,133 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sdNotify sends the provided state to the service manager over the socket in
+// NOTIFY_SOCKET, see sd_notify(3).  It returns false if there is no such
+// socket, e.g. because the daemon was not started by systemd.
+func sdNotify(state string) (bool, error) {
+	addr := os.Getenv("NOTIFY_SOCKET")
+	if addr == "" {
+		return false, nil
+	}
+	// Abstract namespace sockets start with a NUL byte.
+	if strings.HasPrefix(addr, "@") {
+		addr = "\x00" + addr[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil,
+		&net.UnixAddr{Name: addr, Net: "unixgram"})
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// sdWatchdogInterval returns the watchdog timeout that the service manager
+// expects pings within, or 0 if the watchdog is not enabled for this process.
+func sdWatchdogInterval() (time.Duration, error) {
+	usec := os.Getenv("WATCHDOG_USEC")
+	if usec == "" {
+		return 0, nil
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" &&
+		pid != strconv.Itoa(os.Getpid()) {
+		return 0, nil
+	}
+	us, err := strconv.ParseInt(usec, 10, 64)
+	if err != nil || us <= 0 {
+		return 0, fmt.Errorf("invalid WATCHDOG_USEC: %v", usec)
+	}
+	return time.Duration(us) * time.Microsecond, nil
+}
+
+// systemdReady tells the service manager that the daemon finished starting up
+// and starts the watchdog when it is enabled in the unit.
+func (d *DcrtimeStore) systemdReady() {
+	ok, err := sdNotify("READY=1\nSTATUS=Serving requests")
+	if err != nil {
+		log.Errorf("systemd: %v", err)
+		return
+	}
+	if !ok {
+		log.Warnf("systemd: NOTIFY_SOCKET not set, not started by systemd")
+		return
+	}
+
+	interval, err := sdWatchdogInterval()
+	if err != nil {
+		log.Errorf("systemd: %v", err)
+		return
+	}
+	if interval == 0 {
+		log.Infof("systemd: notified, watchdog disabled")
+		return
+	}
+	go d.watchdog(interval)
+}
+
+// watchdog pings the service manager twice per watchdog interval as long as
+// the daemon is ready.  Pings are withheld while a readiness check fails, e.g.
+// when the wallet is unreachable, so that systemd restarts the daemon once the
+// failure outlasts the watchdog interval.
+func (d *DcrtimeStore) watchdog(interval time.Duration) {
+	log.Infof("systemd: watchdog every %v", interval/2)
+
+	ticker := time.NewTicker(interval / 2)
+	defer ticker.Stop()
+	healthy := true
+	for {
+		var failed []string
+		for _, check := range d.readyChecks() {
+			if !check.Ready {
+				failed = append(failed, fmt.Sprintf("%v: %v",
+					check.Name, check.Error))
+			}
+		}
+
+		state := "WATCHDOG=1\nSTATUS=Serving requests"
+		if len(failed) != 0 {
+			reason := strings.Replace(strings.Join(failed, ", "),
+				"\n", " ", -1)
+			log.Warnf("systemd: withholding watchdog ping: %v",
+				reason)
+			state = "STATUS=Not ready, " + reason
+			healthy = false
+		} else if !healthy {
+			log.Infof("systemd: ready again, resuming watchdog pings")
+			healthy = true
+		}
+		if _, err := sdNotify(state); err != nil {
+			log.Errorf("systemd: %v", err)
+		}
+
+		select {
+		case <-d.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// systemdStopping tells the service manager that the daemon is shutting down.
+func systemdStopping() {
+	if _, err := sdNotify("STOPPING=1"); err != nil {
+		log.Errorf("systemd: %v", err)
+	}
+}
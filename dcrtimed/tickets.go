@@ -0,0 +1,353 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	v2 "github.com/decred/dcrtime/api/v2"
+	"github.com/decred/dcrtime/dcrtimed/backend"
+	"github.com/decred/dcrtime/util"
+)
+
+const (
+	// ticketIDSize is the number of random bytes of a ticket ID.
+	ticketIDSize = 16
+
+	// ticketBatch is the maximum number of queued tickets that are
+	// processed at once.
+	ticketBatch = 256
+
+	// ticketRetryInterval is the interval at which queued tickets are
+	// processed when no submission wakes the processor, e.g. to retry
+	// tickets that failed.
+	ticketRetryInterval = 5 * time.Second
+)
+
+// validTicket replies to the client and returns false if the ticket ID is
+// invalid.
+func validTicket(w http.ResponseWriter, id string) bool {
+	if !v2.RegexpTicket.MatchString(id) {
+		util.RespondWithError(w, http.StatusBadRequest,
+			"Invalid ticket")
+		return false
+	}
+	return true
+}
+
+// respondWithTicketError replies to the client with the status that matches
+// the provided ticket error.
+func respondWithTicketError(w http.ResponseWriter, r *http.Request, method, action string, err error) {
+	if errors.Is(err, backend.ErrTicketNotFound) {
+		util.RespondWithError(w, http.StatusNotFound,
+			"Ticket not found")
+		return
+	}
+	respondWithSessionError(w, r, method, action, err)
+}
+
+// convertTicket converts a backend ticket to its API representation.
+func (d *DcrtimeStore) convertTicket(id string, t *backend.Ticket) v2.TimestampTicketReply {
+	reply := v2.TimestampTicketReply{
+		ID:          id,
+		Ticket:      t.ID,
+		Status:      v2.TicketStatusQueued,
+		Label:       t.Label,
+		Algorithm:   t.Algorithm,
+		Digests:     make([]string, 0, len(t.Digests)),
+		Maintenance: d.backend.Maintenance(),
+	}
+	for _, digest := range t.Digests {
+		reply.Digests = append(reply.Digests, hex.EncodeToString(digest[:]))
+	}
+	if t.Processed == 0 {
+		return reply
+	}
+
+	me := make([]backend.PutResult, 0, len(t.Digests))
+	reply.Results = make([]v2.ResultT, 0, len(t.Results))
+	for i, code := range t.Results {
+		me = append(me, backend.PutResult{
+			Digest:    t.Digests[i],
+			ErrorCode: code,
+		})
+		if code == backend.ErrorOK {
+			reply.Results = append(reply.Results, v2.ResultOK)
+		} else {
+			reply.Results = append(reply.Results,
+				v2.ResultExistsError)
+		}
+	}
+	reply.Status = v2.TicketStatusDone
+	reply.ServerTimestamp = t.Timestamp
+	reply.ServerTime = v2.FormatTime(t.Timestamp)
+	reply.AccessKeys = d.accessKeys(me)
+	reply.SubscriptionID = t.Subscription
+	reply.ExpiresTimestamp = t.Expires
+	reply.ExpiresTime = v2.FormatTime(t.Expires)
+	return reply
+}
+
+// wakeTicketer makes the ticket processor look for queued tickets.
+func (d *DcrtimeStore) wakeTicketer() {
+	select {
+	case d.ticketC <- struct{}{}:
+	default:
+	}
+}
+
+// timestampAsyncV2 queues a batch of digests to be timestamped and replies
+// with a ticket right away.  The digests are durably queued before the reply
+// is sent and timestamped by the ticket processor.
+// Handles /v2/timestamp/async
+func (d *DcrtimeStore) timestampAsyncV2(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	var t v2.TimestampBatch
+	if !decodeSession(w, r.Body, &t) {
+		return
+	}
+
+	// Validate all digests.  If one is invalid return failure.
+	digests, err := convertDigests(t.Digests)
+	if err != nil {
+		util.RespondWithError(w, http.StatusBadRequest,
+			"Invalid Digests array")
+		return
+	}
+	if t.Label != "" && !v2.RegexpLabel.MatchString(t.Label) {
+		util.RespondWithError(w, http.StatusBadRequest,
+			"Invalid Label")
+		return
+	}
+	if !v2.IsAlgorithm(t.Algorithm) {
+		util.RespondWithError(w, http.StatusBadRequest,
+			fmt.Sprintf("Unsupported algorithm. Supported: %v",
+				strings.Join(v2.Algorithms, ", ")))
+		return
+	}
+
+	// Metadata and pending receipts are produced while the digests are
+	// stored, they are only available to synchronous submissions.
+	if len(t.Metadata) != 0 || t.PrivateMetadata {
+		util.RespondWithError(w, http.StatusBadRequest,
+			"Metadata is not supported by asynchronous submissions")
+		return
+	}
+	if t.PendingReceipts {
+		util.RespondWithError(w, http.StatusBadRequest,
+			"Pending receipts are not supported by asynchronous "+
+				"submissions")
+		return
+	}
+	if !d.checkWebhookURL(w, r, t.WebhookURL) {
+		return
+	}
+
+	// Charge the digests to the quota of the api token.
+	refund, ok := d.chargeQuota(w, r, len(digests))
+	if !ok {
+		return
+	}
+
+	var b [ticketIDSize]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		refund()
+		respondWithTicketError(w, r, "TimestampAsync",
+			"queue submission", err)
+		return
+	}
+	tk := backend.Ticket{
+		ID:         hex.EncodeToString(b[:]),
+		Owner:      d.collectionOwner(r),
+		Client:     d.submissionClient(r),
+		Label:      t.Label,
+		Algorithm:  storedAlgorithm(t.Algorithm),
+		Digests:    digests,
+		WebhookURL: t.WebhookURL,
+		Created:    time.Now().Unix(),
+	}
+	if d.cfg.EnableCollections && tk.Owner != "" {
+		tk.Collection = d.defaultCollection(r)
+	}
+	if err := d.backend.PutTicket(tk); err != nil {
+		refund()
+		respondWithTicketError(w, r, "TimestampAsync",
+			"queue submission", err)
+		return
+	}
+	d.wakeTicketer()
+
+	via := logAddr(r)
+	xff := r.Header.Get(forward)
+	if xff != "" {
+		via = fmt.Sprintf("%v via %v", xff, logAddr(r))
+	}
+	log.Infof("%v TimestampAsync %v: %v queued %v digests", r.URL.Path,
+		via, tk.ID, len(digests))
+
+	util.RespondWithJSON(w, http.StatusAccepted, v2.TimestampAsyncReply{
+		ID:          t.ID,
+		Ticket:      tk.ID,
+		Status:      v2.TicketStatusQueued,
+		DigestCount: len(digests),
+	})
+}
+
+// ticketV2 returns the status of an asynchronous submission.  Tickets that
+// were queued with an api token are only available to that token.
+// Handles /v2/timestamp/ticket
+func (d *DcrtimeStore) ticketV2(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	var tt v2.TimestampTicket
+	if !decodeSession(w, r.Body, &tt) {
+		return
+	}
+	if !validTicket(w, tt.Ticket) {
+		return
+	}
+
+	tk, err := d.backend.GetTicket(tt.Ticket)
+	if err == nil && tk.Owner != "" && tk.Owner != d.collectionOwner(r) {
+		err = backend.ErrTicketNotFound
+	}
+	if err != nil {
+		respondWithTicketError(w, r, "TimestampTicket",
+			"retrieve ticket", err)
+		return
+	}
+
+	log.Debugf("%v TimestampTicket %v: %v", r.URL.Path, logAddr(r), tk.ID)
+
+	util.RespondWithJSON(w, http.StatusOK, d.convertTicket(tt.ID, tk))
+}
+
+// processTicket timestamps the digests of a queued ticket and records the
+// outcome in the ticket.  The ticket remains queued if that fails, a retry
+// then reports the digests that were stored already as existing.
+func (d *DcrtimeStore) processTicket(tk backend.Ticket) error {
+	ts, me, err := d.put(d.ctx, tk.Digests, tk.Label, tk.Algorithm)
+	if err != nil {
+		return err
+	}
+
+	// The digests were stored, failures below are logged only like
+	// they are for synchronous submissions.
+	if d.cfg.EnableCollections || d.cfg.PrivateDigests {
+		err := d.storeOwner(tk.Owner, tk.Collection, ts, me)
+		if err != nil {
+			log.Errorf("Ticket %v owner %v: %v", tk.ID, ts, err)
+		}
+	}
+	d.recordSubmission(tk.Client, ts, me)
+
+	var accepted []string
+	for _, v := range me {
+		if v.ErrorCode == backend.ErrorOK {
+			accepted = append(accepted,
+				hex.EncodeToString(v.Digest[:]))
+		}
+	}
+	if tk.WebhookURL != "" && len(accepted) != 0 {
+		id, err := d.subscribe(tk.Owner, tk.WebhookURL, ts, accepted)
+		if err != nil {
+			log.Errorf("Ticket %v webhook subscription: %v", tk.ID,
+				err)
+		}
+		tk.Subscription = id
+	}
+
+	now := time.Now()
+	tk.Processed = now.Unix()
+	tk.Timestamp = ts
+	tk.Results = make([]uint, 0, len(me))
+	for _, v := range me {
+		tk.Results = append(tk.Results, v.ErrorCode)
+	}
+	tk.Expires = now.Add(d.cfg.TicketExpiry).Unix()
+	if err := d.backend.PutTicket(tk); err != nil {
+		return err
+	}
+
+	log.Infof("Ticket %v: %v accepted %v of %v digests", tk.ID,
+		time.Unix(ts, 0).UTC().Format(fStr), len(accepted),
+		len(tk.Digests))
+	return nil
+}
+
+// processTickets processes the queued tickets until none are left.  It
+// returns early if a ticket fails, failed tickets are retried by the next
+// call.
+func (d *DcrtimeStore) processTickets() {
+	for d.ctx.Err() == nil {
+		tickets, err := d.backend.QueuedTickets(ticketBatch)
+		if err != nil {
+			log.Errorf("Tickets: %v", err)
+			return
+		}
+		if len(tickets) == 0 {
+			return
+		}
+
+		// Tickets are processed concurrently, which lets the
+		// coalescer group their digests into shared writes.
+		var (
+			wg     sync.WaitGroup
+			mtx    sync.Mutex
+			failed int
+		)
+		for _, tk := range tickets {
+			wg.Add(1)
+			go func(tk backend.Ticket) {
+				defer wg.Done()
+				if err := d.processTicket(tk); err != nil {
+					log.Errorf("Ticket %v: %v", tk.ID, err)
+					mtx.Lock()
+					failed++
+					mtx.Unlock()
+				}
+			}(tk)
+		}
+		wg.Wait()
+		if failed != 0 {
+			return
+		}
+	}
+}
+
+// ticketer timestamps the digests of asynchronous submissions.  It is woken
+// by new submissions and looks for queued tickets periodically, which picks up
+// tickets that were queued before a restart or failed.
+func (d *DcrtimeStore) ticketer() {
+	ticker := time.NewTicker(ticketRetryInterval)
+	defer ticker.Stop()
+	for {
+		d.processTickets()
+		select {
+		case <-d.ctx.Done():
+			return
+		case <-d.ticketC:
+		case <-ticker.C:
+		}
+	}
+}
+
+func (d *DcrtimeStore) proxyTimestampAsyncV2(w http.ResponseWriter, r *http.Request) {
+	var t v2.TimestampBatch
+	d.proxySession(w, r, v2.TimestampAsyncRoute, "TimestampAsync", &t)
+}
+
+func (d *DcrtimeStore) proxyTicketV2(w http.ResponseWriter, r *http.Request) {
+	var tt v2.TimestampTicket
+	d.proxySession(w, r, v2.TicketRoute, "TimestampTicket", &tt)
+}
@@ -0,0 +1,189 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tlsVersions maps the supported tlsminversion values to their TLS version.
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// parseTLSVersion returns the TLS version of the provided tlsminversion.
+func parseTLSVersion(version string) (uint16, error) {
+	v, ok := tlsVersions[version]
+	if !ok {
+		return 0, fmt.Errorf("unsupported TLS version %v, supported: "+
+			"1.0, 1.1, 1.2, 1.3", version)
+	}
+	return v, nil
+}
+
+// parseCipherSuites returns the IDs of the provided cipher suite names.  Only
+// cipher suites without known security issues are allowed.
+func parseCipherSuites(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	suites := make(map[string]uint16)
+	for _, s := range tls.CipherSuites() {
+		suites[s.Name] = s.ID
+	}
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		id, ok := suites[strings.TrimSpace(name)]
+		if !ok {
+			return nil, fmt.Errorf("unsupported cipher suite %v", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// certReloader serves the https certificate and reloads it when the
+// certificate or key file changes, e.g. when certbot renews it.
+type certReloader struct {
+	sync.RWMutex
+
+	certFile string
+	keyFile  string
+
+	cert    *tls.Certificate
+	certMod time.Time // Modification time of the loaded certificate file
+	keyMod  time.Time // Modification time of the loaded key file
+}
+
+// newCertReloader returns a certReloader with the certificate of the provided
+// files loaded.
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	c := &certReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+	if _, err := c.reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// getCertificate returns the loaded certificate.  It satisfies the
+// GetCertificate callback of tls.Config.
+func (c *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.RLock()
+	defer c.RUnlock()
+	return c.cert, nil
+}
+
+// reload loads the certificate when the modification time of the certificate
+// or key file differs from the loaded one.  It returns true if a new
+// certificate was loaded.  The loaded certificate is kept when the files can
+// not be loaded, e.g. because only one of them was replaced yet.
+func (c *certReloader) reload() (bool, error) {
+	// Stat follows symlinks, which certbot replaces on renewal.
+	cfi, err := os.Stat(c.certFile)
+	if err != nil {
+		return false, err
+	}
+	kfi, err := os.Stat(c.keyFile)
+	if err != nil {
+		return false, err
+	}
+
+	c.RLock()
+	unchanged := cfi.ModTime().Equal(c.certMod) &&
+		kfi.ModTime().Equal(c.keyMod)
+	c.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return false, err
+	}
+
+	c.Lock()
+	c.cert = &cert
+	c.certMod = cfi.ModTime()
+	c.keyMod = kfi.ModTime()
+	c.Unlock()
+
+	return true, nil
+}
+
+// certWatcher checks the https certificate for changes every interval.
+func (d *DcrtimeStore) certWatcher(c *certReloader, interval time.Duration) {
+	log.Infof("Reloading https certificate on change, checked every %v",
+		interval)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		reloaded, err := c.reload()
+		if err != nil {
+			log.Errorf("Reload https certificate: %v", err)
+			continue
+		}
+		if reloaded {
+			log.Infof("Reloaded https certificate %v", c.certFile)
+		}
+	}
+}
+
+// tlsConfig returns the TLS configuration of the listeners.  The certificate
+// is served by the returned certReloader when httpsreload is enabled,
+// otherwise it is nil and the listeners load the certificate once.
+func tlsConfig(cfg *config) (*tls.Config, *certReloader, error) {
+	minVersion, err := parseTLSVersion(cfg.TLSMinVersion)
+	if err != nil {
+		return nil, nil, err
+	}
+	suites, err := parseCipherSuites(cfg.TLSCipherSuites)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tlsCfg := &tls.Config{
+		MinVersion:   minVersion,
+		CipherSuites: suites,
+	}
+	if cfg.ClientCA != "" {
+		pool, err := loadClientCAs(cfg.ClientCA)
+		if err != nil {
+			return nil, nil, err
+		}
+		// Clients without a certificate are still served, they
+		// authenticate with api tokens instead.
+		tlsCfg.ClientCAs = pool
+		tlsCfg.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	if cfg.HTTPSReload == 0 {
+		return tlsCfg, nil, nil
+	}
+
+	reloader, err := newCertReloader(cfg.HTTPSCert, cfg.HTTPSKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	tlsCfg.GetCertificate = reloader.getCertificate
+	return tlsCfg, reloader, nil
+}
@@ -0,0 +1,460 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	v2 "github.com/decred/dcrtime/api/v2"
+	"github.com/decred/dcrtime/dcrtimed/backend"
+	"github.com/decred/dcrtime/util"
+)
+
+const (
+	// apiTokenSize is the number of random bytes of a created api token.
+	apiTokenSize = 32
+
+	// apiTokenIDSize is the number of bytes of the token digest that make
+	// up the public token ID.
+	apiTokenIDSize = 8
+
+	// anonymousScope is the scopemaxdigests scope of requests without a
+	// valid api token.
+	anonymousScope = "anonymous"
+)
+
+// hasScope returns true if the provided scopes grant the requested scope.
+// The admin scope grants every scope.
+func hasScope(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope || s == v2.TokenScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// apiTokenID returns the public ID of the provided api token.
+func apiTokenID(apiToken string) string {
+	hash := sha256.Sum256([]byte(apiToken))
+	return hex.EncodeToString(hash[:apiTokenIDSize])
+}
+
+// validScopes returns an error if the provided scopes are empty or contain an
+// unknown or duplicate scope.
+func validScopes(scopes []string) error {
+	if len(scopes) == 0 {
+		return fmt.Errorf("no scopes")
+	}
+	seen := make(map[string]struct{}, len(scopes))
+	for _, scope := range scopes {
+		known := false
+		for _, s := range v2.TokenScopes {
+			if scope == s {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return fmt.Errorf("invalid scope: %v", scope)
+		}
+		if _, ok := seen[scope]; ok {
+			return fmt.Errorf("duplicate scope: %v", scope)
+		}
+		seen[scope] = struct{}{}
+	}
+	return nil
+}
+
+// convertToken converts a backend api token to its API representation.
+func convertToken(t backend.APIToken) v2.Token {
+	return v2.Token{
+		ID:                t.ID,
+		Description:       t.Description,
+		Scopes:            t.Scopes,
+		CreatedTimestamp:  t.Created,
+		CreatedTime:       v2.FormatTime(t.Created),
+		ExpiresTimestamp:  t.Expires,
+		ExpiresTime:       v2.FormatTime(t.Expires),
+		Uses:              t.Uses,
+		LastUsedTimestamp: t.LastUsed,
+		LastUsedTime:      v2.FormatTime(t.LastUsed),
+		Collection:        t.Collection,
+		DailyQuota:        t.DailyQuota,
+		MonthlyQuota:      t.MonthlyQuota,
+		Usage:             convertUsage(t, time.Now().Unix()),
+	}
+}
+
+// withAPIToken appends the apitoken query parameter of the request, if any, to
+// the provided route so that the storehost is able to authorize the forwarded
+// request.
+func withAPIToken(route string, r *http.Request) string {
+	apiToken := r.URL.Query().Get("apitoken")
+	if apiToken == "" {
+		return route
+	}
+	sep := "?"
+	if strings.Contains(route, "?") {
+		sep = "&"
+	}
+	return route + sep + "apitoken=" + url.QueryEscape(apiToken)
+}
+
+// respondNotAuthorized replies to a request that isAuthorized refused.  A
+// request with a valid api token or client certificate that does not grant
+// the scope is forbidden, other requests are not authorized.
+func (d *DcrtimeStore) respondNotAuthorized(w http.ResponseWriter, r *http.Request) {
+	if d.tokenScopes(r) != nil || clientCertCN(r) != "" {
+		util.RespondWithError(w, http.StatusForbidden, "forbidden")
+		return
+	}
+	util.RespondWithError(w, http.StatusUnauthorized, "not authorized")
+}
+
+// requireScope wraps the provided handler so that it is only invoked for
+// requests that carry an api token with the provided scope.
+func (d *DcrtimeStore) requireScope(scope string, f http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !d.isAuthorized(r, scope) {
+			d.respondNotAuthorized(w, r)
+			return
+		}
+		f(w, r)
+	}
+}
+
+// tokenScopes returns the scopes granted by the api token of the request, or
+// nil if the request does not carry a valid api token.  The admin scope grants
+// every scope.  Unlike authorizeToken it does not count as a use of the token.
+func (d *DcrtimeStore) tokenScopes(r *http.Request) []string {
+	apiToken := r.URL.Query().Get("apitoken")
+	if apiToken == "" {
+		return nil
+	}
+	if _, ok := d.apiTokens[apiToken]; ok {
+		return v2.TokenScopes
+	}
+
+	t, err := d.backend.GetToken(sha256.Sum256([]byte(apiToken)))
+	if err != nil {
+		if !errors.Is(err, backend.ErrTokenNotFound) {
+			log.Errorf("tokenScopes: %v", err)
+		}
+		return nil
+	}
+	if t.Expires != 0 && time.Now().Unix() >= t.Expires {
+		return nil
+	}
+	if hasScope(t.Scopes, v2.TokenScopeAdmin) {
+		return v2.TokenScopes
+	}
+	return t.Scopes
+}
+
+// maxDigests returns the maximum number of digests the request may query.  A
+// request with an api token gets the highest limit of its scopes, scopes that
+// are not overridden are limited to maxdigests.  Requests without a valid api
+// token get the anonymous limit.  A proxy can't look up the api tokens of its
+// storehost, requests with an api token get the highest limit of all scopes
+// and the storehost enforces the limit of the token.
+func (d *DcrtimeStore) maxDigests(r *http.Request) int32 {
+	limit := func(scope string) int32 {
+		if max, ok := d.scopeMaxDigests[scope]; ok {
+			return max
+		}
+		return d.cfg.MaxDigests
+	}
+
+	var scopes []string
+	if d.backend == nil {
+		if r.URL.Query().Get("apitoken") != "" {
+			scopes = v2.TokenScopes
+		}
+	} else {
+		scopes = d.tokenScopes(r)
+	}
+	if len(scopes) == 0 {
+		return limit(anonymousScope)
+	}
+	var max int32
+	for _, scope := range scopes {
+		if l := limit(scope); l > max {
+			max = l
+		}
+	}
+	return max
+}
+
+// authorizeToken returns true if the api token is stored in the backend, has
+// not expired and grants the requested scope. The usage counter of the token
+// is incremented when it is authorized.
+func (d *DcrtimeStore) authorizeToken(apiToken, scope string) bool {
+	hash := sha256.Sum256([]byte(apiToken))
+	t, err := d.backend.GetToken(hash)
+	if err != nil {
+		if !errors.Is(err, backend.ErrTokenNotFound) {
+			log.Errorf("authorizeToken: %v", err)
+		}
+		return false
+	}
+
+	now := time.Now().Unix()
+	if t.Expires != 0 && now >= t.Expires {
+		log.Debugf("authorizeToken: token %v expired", t.ID)
+		return false
+	}
+	if !hasScope(t.Scopes, scope) {
+		log.Debugf("authorizeToken: token %v lacks scope %v", t.ID,
+			scope)
+		return false
+	}
+
+	err = d.backend.UseToken(hash, now)
+	if err != nil {
+		log.Errorf("authorizeToken: token %v: %v", t.ID, err)
+	}
+
+	return true
+}
+
+// tokensV2 returns all api tokens that were created through the admin API.
+// Handles /v2/admin/tokens
+func (d *DcrtimeStore) tokensV2(w http.ResponseWriter, r *http.Request) {
+	if !d.isAuthorized(r, v2.TokenScopeAdmin) {
+		d.respondNotAuthorized(w, r)
+		return
+	}
+
+	tokens, err := d.backend.GetTokens()
+	if err != nil {
+		errorCode := time.Now().Unix()
+		log.Errorf("%v Tokens error code %v: %v", logAddr(r),
+			errorCode, err)
+		util.RespondWithError(w, http.StatusInternalServerError,
+			fmt.Sprintf("failed to retrieve api tokens, "+
+				"contact administrator and provide "+
+				"the following error code: %v", errorCode))
+		return
+	}
+	sort.Slice(tokens, func(i, j int) bool {
+		if tokens[i].Created != tokens[j].Created {
+			return tokens[i].Created < tokens[j].Created
+		}
+		return tokens[i].ID < tokens[j].ID
+	})
+
+	reply := v2.TokensReply{
+		Tokens: make([]v2.Token, 0, len(tokens)),
+	}
+	for _, t := range tokens {
+		reply.Tokens = append(reply.Tokens, convertToken(t))
+	}
+
+	log.Infof("%v Tokens %v", r.URL.Path, logAddr(r))
+
+	util.RespondWithJSON(w, http.StatusOK, reply)
+}
+
+// tokenCreateV2 creates an api token with the requested scopes. The token is
+// only returned in this reply.
+// Handles /v2/admin/tokens/create
+func (d *DcrtimeStore) tokenCreateV2(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	if !d.isAuthorized(r, v2.TokenScopeAdmin) {
+		d.respondNotAuthorized(w, r)
+		return
+	}
+
+	var tc v2.TokenCreate
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&tc); err != nil {
+		util.RespondWithError(w, http.StatusBadRequest,
+			"Invalid request payload")
+		return
+	}
+	if err := validScopes(tc.Scopes); err != nil {
+		util.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !v2.RegexpCollectionName.MatchString(tc.Collection) {
+		util.RespondWithError(w, http.StatusBadRequest,
+			"Invalid collection name")
+		return
+	}
+	if tc.DailyQuota < 0 || tc.MonthlyQuota < 0 {
+		util.RespondWithError(w, http.StatusBadRequest,
+			"Quotas may not be negative")
+		return
+	}
+	now := time.Now().Unix()
+	if tc.ExpiresTimestamp != 0 && tc.ExpiresTimestamp <= now {
+		util.RespondWithError(w, http.StatusBadRequest,
+			"Expiration is in the past")
+		return
+	}
+
+	var b [apiTokenSize]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		errorCode := time.Now().Unix()
+		log.Errorf("%v TokenCreate error code %v: %v", logAddr(r),
+			errorCode, err)
+		util.RespondWithError(w, http.StatusInternalServerError,
+			fmt.Sprintf("failed to create api token, "+
+				"contact administrator and provide "+
+				"the following error code: %v", errorCode))
+		return
+	}
+	apiToken := hex.EncodeToString(b[:])
+	hash := sha256.Sum256([]byte(apiToken))
+	t := backend.APIToken{
+		ID:          apiTokenID(apiToken),
+		Description: tc.Description,
+		Scopes:      tc.Scopes,
+		Created:     now,
+		Expires:     tc.ExpiresTimestamp,
+		Collection:  tc.Collection,
+
+		DailyQuota:   tc.DailyQuota,
+		MonthlyQuota: tc.MonthlyQuota,
+	}
+	if err := d.backend.PutToken(hash, t); err != nil {
+		errorCode := time.Now().Unix()
+		log.Errorf("%v TokenCreate error code %v: %v", logAddr(r),
+			errorCode, err)
+		util.RespondWithError(w, http.StatusInternalServerError,
+			fmt.Sprintf("failed to create api token, "+
+				"contact administrator and provide "+
+				"the following error code: %v", errorCode))
+		return
+	}
+
+	log.Infof("%v TokenCreate %v: %v %v", r.URL.Path, logAddr(r), t.ID,
+		strings.Join(t.Scopes, ","))
+
+	util.RespondWithJSON(w, http.StatusOK, v2.TokenCreateReply{
+		Token:     apiToken,
+		TokenInfo: convertToken(t),
+	})
+}
+
+// tokenRevokeV2 revokes the api token with the provided ID.
+// Handles /v2/admin/tokens/revoke
+func (d *DcrtimeStore) tokenRevokeV2(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	if !d.isAuthorized(r, v2.TokenScopeAdmin) {
+		d.respondNotAuthorized(w, r)
+		return
+	}
+
+	var tr v2.TokenRevoke
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&tr); err != nil {
+		util.RespondWithError(w, http.StatusBadRequest,
+			"Invalid request payload")
+		return
+	}
+	if !v2.RegexpTokenID.MatchString(tr.ID) {
+		util.RespondWithError(w, http.StatusBadRequest,
+			"Invalid token ID")
+		return
+	}
+
+	err := d.backend.DeleteToken(tr.ID)
+	if errors.Is(err, backend.ErrTokenNotFound) {
+		util.RespondWithError(w, http.StatusNotFound,
+			"Token not found")
+		return
+	} else if err != nil {
+		errorCode := time.Now().Unix()
+		log.Errorf("%v TokenRevoke error code %v: %v", logAddr(r),
+			errorCode, err)
+		util.RespondWithError(w, http.StatusInternalServerError,
+			fmt.Sprintf("failed to revoke api token, "+
+				"contact administrator and provide "+
+				"the following error code: %v", errorCode))
+		return
+	}
+
+	log.Infof("%v TokenRevoke %v: %v", r.URL.Path, logAddr(r), tr.ID)
+
+	util.RespondWithJSON(w, http.StatusOK, v2.TokenRevokeReply{
+		ID: tr.ID,
+	})
+}
+
+func (d *DcrtimeStore) proxyTokensV2(w http.ResponseWriter, r *http.Request) {
+	d.sendToBackend(r.Context(), w, r.Method, withAPIToken(v2.TokensRoute, r),
+		r.Header.Get("Content-Type"), r.RemoteAddr,
+		bytes.NewReader([]byte{}))
+
+	log.Infof("%v Tokens %v", r.URL.Path, logAddr(r))
+}
+
+func (d *DcrtimeStore) proxyTokenCreateV2(w http.ResponseWriter, r *http.Request) {
+	b, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		util.RespondWithError(w, http.StatusBadRequest,
+			"Unable to read request")
+		return
+	}
+
+	var tc v2.TokenCreate
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&tc); err != nil {
+		util.RespondWithError(w, http.StatusBadRequest,
+			"Invalid request payload")
+		return
+	}
+
+	d.sendToBackend(r.Context(), w, r.Method,
+		withAPIToken(v2.TokenCreateRoute, r), r.Header.Get("Content-Type"),
+		r.RemoteAddr, bytes.NewReader(b))
+
+	log.Infof("%v TokenCreate %v", r.URL.Path, logAddr(r))
+}
+
+func (d *DcrtimeStore) proxyTokenRevokeV2(w http.ResponseWriter, r *http.Request) {
+	b, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		util.RespondWithError(w, http.StatusBadRequest,
+			"Unable to read request")
+		return
+	}
+
+	var tr v2.TokenRevoke
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&tr); err != nil {
+		util.RespondWithError(w, http.StatusBadRequest,
+			"Invalid request payload")
+		return
+	}
+
+	d.sendToBackend(r.Context(), w, r.Method,
+		withAPIToken(v2.TokenRevokeRoute, r), r.Header.Get("Content-Type"),
+		r.RemoteAddr, bytes.NewReader(b))
+
+	log.Infof("%v TokenRevoke %v: %v", r.URL.Path, logAddr(r), tr.ID)
+}
@@ -0,0 +1,293 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/sha256"
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	v2 "github.com/decred/dcrtime/api/v2"
+	"github.com/decred/dcrtime/dcrtimed/backend"
+)
+
+// withToken appends the api token to the route.
+func withToken(route, apiToken string) string {
+	if apiToken == "" {
+		return route
+	}
+	return route + "?apitoken=" + url.QueryEscape(apiToken)
+}
+
+// createToken creates an api token with the provided scopes through the admin
+// API and returns it.
+func (s *testStore) createToken(t *testing.T, scopes ...string) v2.TokenCreateReply {
+	t.Helper()
+
+	var tcr v2.TokenCreateReply
+	code := s.post(t, withToken(v2.TokenCreateRoute, testAdminToken),
+		v2.TokenCreate{
+			Description: "test",
+			Scopes:      scopes,
+		}, &tcr)
+	if code != http.StatusOK {
+		t.Fatalf("token create: got status %v", code)
+	}
+	return tcr
+}
+
+func TestTokenScopes(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.RestrictAPI = true
+	s := newTestStore(t, cfg)
+
+	verify := s.createToken(t, v2.TokenScopeVerify).Token
+	timestamp := s.createToken(t, v2.TokenScopeTimestamp).Token
+	admin := s.createToken(t, v2.TokenScopeAdmin).Token
+
+	tests := []struct {
+		name     string
+		route    string
+		apiToken string
+		want     int
+	}{
+		{"no token", v2.VerifyBatchRoute, "", http.StatusUnauthorized},
+		{"unknown token", v2.VerifyBatchRoute, "unknown",
+			http.StatusUnauthorized},
+		{"verify scope", v2.VerifyBatchRoute, verify, http.StatusOK},
+		{"timestamp scope", v2.TimestampBatchRoute, timestamp,
+			http.StatusOK},
+		{"wrong scope", v2.TimestampBatchRoute, verify,
+			http.StatusForbidden},
+		{"wrong scope verify", v2.VerifyBatchRoute, timestamp,
+			http.StatusForbidden},
+		{"admin scope", v2.TimestampBatchRoute, admin, http.StatusOK},
+		{"config admin token", v2.VerifyBatchRoute, testAdminToken,
+			http.StatusOK},
+	}
+	for _, test := range tests {
+		code := s.post(t, withToken(test.route, test.apiToken),
+			v2.TimestampBatch{
+				Digests: testDigests(test.name, 1),
+			}, nil)
+		if code != test.want {
+			t.Errorf("%v: got status %v, want %v", test.name, code,
+				test.want)
+		}
+	}
+}
+
+func TestTokenExpired(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.RestrictAPI = true
+	s := newTestStore(t, cfg)
+
+	// The admin API refuses to create expired tokens, store them directly.
+	put := func(apiToken string, expires int64) {
+		t.Helper()
+		err := s.b.PutToken(sha256.Sum256([]byte(apiToken)),
+			backend.APIToken{
+				ID:      apiTokenID(apiToken),
+				Scopes:  []string{v2.TokenScopeVerify},
+				Created: time.Now().Unix() - 7200,
+				Expires: expires,
+			})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	now := time.Now().Unix()
+	put("expired", now-3600)
+	put("valid", now+3600)
+
+	tests := []struct {
+		apiToken string
+		want     int
+	}{
+		{"expired", http.StatusUnauthorized},
+		{"valid", http.StatusOK},
+	}
+	for _, test := range tests {
+		code := s.post(t, withToken(v2.VerifyBatchRoute, test.apiToken),
+			v2.VerifyBatch{
+				Digests: testDigests("expired", 1),
+			}, nil)
+		if code != test.want {
+			t.Errorf("%v: got status %v, want %v", test.apiToken,
+				code, test.want)
+		}
+	}
+
+	// Expired tokens do not raise the digest limit either.
+	req, err := http.NewRequest(http.MethodGet,
+		withToken(v2.LastDigestsRoute, "expired"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if scopes := s.tokenScopes(req); scopes != nil {
+		t.Fatalf("expired token got scopes %v", scopes)
+	}
+}
+
+func TestTokenRevoked(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.RestrictAPI = true
+	s := newTestStore(t, cfg)
+
+	tcr := s.createToken(t, v2.TokenScopeVerify)
+	verify := func() int {
+		t.Helper()
+		return s.post(t, withToken(v2.VerifyBatchRoute, tcr.Token),
+			v2.VerifyBatch{
+				Digests: testDigests("revoked", 1),
+			}, nil)
+	}
+	if code := verify(); code != http.StatusOK {
+		t.Fatalf("before revoke: got status %v", code)
+	}
+
+	code := s.post(t, withToken(v2.TokenRevokeRoute, testAdminToken),
+		v2.TokenRevoke{ID: tcr.TokenInfo.ID}, nil)
+	if code != http.StatusOK {
+		t.Fatalf("revoke: got status %v", code)
+	}
+	if code := verify(); code != http.StatusUnauthorized {
+		t.Fatalf("after revoke: got status %v, want %v", code,
+			http.StatusUnauthorized)
+	}
+
+	// The token is gone from the listing.
+	var tr v2.TokensReply
+	code, _, _ = s.do(t, http.MethodGet, withToken(v2.TokensRoute,
+		testAdminToken), nil, &tr)
+	if code != http.StatusOK {
+		t.Fatalf("tokens: got status %v", code)
+	}
+	for _, token := range tr.Tokens {
+		if token.ID == tcr.TokenInfo.ID {
+			t.Fatalf("revoked token %v listed", token.ID)
+		}
+	}
+}
+
+func TestTokenAdminOnly(t *testing.T) {
+	s := newTestStore(t, testConfig(t))
+
+	verify := s.createToken(t, v2.TokenScopeVerify, v2.TokenScopeTimestamp)
+	admin := s.createToken(t, v2.TokenScopeAdmin).Token
+
+	tests := []struct {
+		name     string
+		apiToken string
+		want     int
+	}{
+		{"no token", "", http.StatusUnauthorized},
+		{"unknown token", "unknown", http.StatusUnauthorized},
+		{"non-admin token", verify.Token, http.StatusForbidden},
+		{"admin token", admin, http.StatusOK},
+		{"config admin token", testAdminToken, http.StatusOK},
+	}
+	for _, test := range tests {
+		code, _, _ := s.do(t, http.MethodGet,
+			withToken(v2.TokensRoute, test.apiToken), nil, nil)
+		if code != test.want {
+			t.Errorf("%v: tokens: got status %v, want %v",
+				test.name, code, test.want)
+		}
+
+		code = s.post(t, withToken(v2.TokenCreateRoute, test.apiToken),
+			v2.TokenCreate{
+				Scopes: []string{v2.TokenScopeVerify},
+			}, nil)
+		if code != test.want {
+			t.Errorf("%v: create: got status %v, want %v",
+				test.name, code, test.want)
+		}
+
+		// Only admins get to learn whether the id exists.
+		want := test.want
+		if want == http.StatusOK {
+			want = http.StatusNotFound
+		}
+		code = s.post(t, withToken(v2.TokenRevokeRoute, test.apiToken),
+			v2.TokenRevoke{ID: apiTokenID("unknown")}, nil)
+		if code != want {
+			t.Errorf("%v: revoke: got status %v, want %v",
+				test.name, code, want)
+		}
+	}
+
+	// A token can not revoke itself without the admin scope.
+	code := s.post(t, withToken(v2.TokenRevokeRoute, verify.Token),
+		v2.TokenRevoke{ID: verify.TokenInfo.ID}, nil)
+	if code != http.StatusForbidden {
+		t.Fatalf("self revoke: got status %v, want %v", code,
+			http.StatusForbidden)
+	}
+}
+
+func TestTokenMaxDigests(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.ScopeMaxDigests = []string{
+		anonymousScope + ":2",
+		v2.TokenScopeVerify + ":5",
+	}
+	s := newTestStore(t, cfg)
+
+	verify := s.createToken(t, v2.TokenScopeVerify).Token
+	timestamp := s.createToken(t, v2.TokenScopeTimestamp).Token
+
+	tests := []struct {
+		name     string
+		apiToken string
+		n        int32
+		want     int
+	}{
+		{"anonymous", "", 2, http.StatusOK},
+		{"anonymous over", "", 3, http.StatusUnprocessableEntity},
+		{"verify", verify, 5, http.StatusOK},
+		{"verify over", verify, 6, http.StatusUnprocessableEntity},
+		{"unknown token", "unknown", 3,
+			http.StatusUnprocessableEntity},
+
+		// Scopes without an override are limited to maxdigests.
+		{"timestamp", timestamp, cfg.MaxDigests, http.StatusOK},
+		{"timestamp over", timestamp, cfg.MaxDigests + 1,
+			http.StatusUnprocessableEntity},
+
+		// Admin tokens get the highest limit of all scopes.
+		{"admin", testAdminToken, cfg.MaxDigests, http.StatusOK},
+	}
+	for _, test := range tests {
+		code := s.post(t, withToken(v2.LastDigestsRoute, test.apiToken),
+			v2.LastDigests{N: test.n}, nil)
+		if code != test.want {
+			t.Errorf("%v: got status %v, want %v", test.name, code,
+				test.want)
+		}
+	}
+
+	// The limits are advertised by the version route.
+	var vr v2.VersionReply
+	code, _, _ := s.do(t, http.MethodGet, v2.VersionRoute, nil, &vr)
+	if code != http.StatusOK {
+		t.Fatalf("version: got status %v", code)
+	}
+	if vr.MaxDigests != cfg.MaxDigests {
+		t.Fatalf("got maxdigests %v, want %v", vr.MaxDigests,
+			cfg.MaxDigests)
+	}
+	want := map[string]int32{
+		anonymousScope:      2,
+		v2.TokenScopeVerify: 5,
+	}
+	if !reflect.DeepEqual(vr.ScopeMaxDigests, want) {
+		t.Fatalf("got scopemaxdigests %v, want %v", vr.ScopeMaxDigests,
+			want)
+	}
+}
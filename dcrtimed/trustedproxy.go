@@ -0,0 +1,112 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// realIP is the header that carries the client address when a reverse proxy
+// does not set forward.
+const realIP = "X-Real-IP"
+
+// parseTrustedProxies parses the addresses and CIDR prefixes of trusted
+// reverse proxies.
+func parseTrustedProxies(values []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(values))
+	for _, v := range values {
+		prefix, err := parseIPPrefix(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trustedproxies %q: %v", v,
+				err)
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	return prefixes, nil
+}
+
+// parseHostAddr parses an address that is optionally followed by a port.
+func parseHostAddr(s string) (netip.Addr, bool) {
+	if ap, err := netip.ParseAddrPort(s); err == nil {
+		return ap.Addr().Unmap(), true
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap(), true
+}
+
+// isTrustedProxy returns true if the provided address is a trusted reverse
+// proxy.
+func (d *DcrtimeStore) isTrustedProxy(addr netip.Addr) bool {
+	for _, prefix := range d.trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedClient returns the client address that a request from a trusted
+// proxy was forwarded for and the forward addresses in front of it, which were
+// claimed by untrusted hops.  Forward addresses are walked from the closest hop
+// and trusted proxies are skipped.  False is returned if there is no valid
+// client address.
+func (d *DcrtimeStore) forwardedClient(r *http.Request) (string, []string, bool) {
+	var hops []string
+	for _, v := range r.Header.Values(forward) {
+		for _, hop := range strings.Split(v, ",") {
+			hops = append(hops, strings.TrimSpace(hop))
+		}
+	}
+	if len(hops) == 0 {
+		hop := strings.TrimSpace(r.Header.Get(realIP))
+		if _, ok := parseHostAddr(hop); !ok {
+			return "", nil, false
+		}
+		return hop, nil, true
+	}
+
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, ok := parseHostAddr(hops[i])
+		if !ok {
+			return "", nil, false
+		}
+		if i > 0 && d.isTrustedProxy(addr) {
+			continue
+		}
+		return hops[i], hops[:i], true
+	}
+	return "", nil, false
+}
+
+// trustProxies replaces the remote address of requests from trusted reverse
+// proxies with the client address they were forwarded for, so that logs and
+// ip policies apply to the client.  The forward addresses of untrusted hops
+// are kept.  Requests from other peers are passed on as is.
+func (d *DcrtimeStore) trustProxies(h http.Handler) http.Handler {
+	if len(d.trustedProxies) == 0 {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		peer, ok := parseHostAddr(r.RemoteAddr)
+		if ok && d.isTrustedProxy(peer) {
+			if client, hops, ok := d.forwardedClient(r); ok {
+				r.RemoteAddr = client
+				r.Header.Del(realIP)
+				r.Header.Del(forward)
+				if len(hops) != 0 {
+					r.Header.Set(forward,
+						strings.Join(hops, ", "))
+				}
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,201 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// trustedStore returns a storehost that trusts the reverse proxies at
+// 10.0.0.0/24 and 2001:db8::1.
+func trustedStore(t *testing.T) *DcrtimeStore {
+	t.Helper()
+
+	proxies, err := parseTrustedProxies([]string{
+		"10.0.0.0/24",
+		"2001:db8::1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &DcrtimeStore{trustedProxies: proxies}
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	for _, v := range []string{"10.0.0.1", "10.0.0.0/8", "2001:db8::/32",
+		"::ffff:10.0.0.1"} {
+		if _, err := parseTrustedProxies([]string{v}); err != nil {
+			t.Errorf("%v: %v", v, err)
+		}
+	}
+	for _, v := range []string{"", "proxy", "10.0.0.0/33", "10.0.0.1:80"} {
+		if _, err := parseTrustedProxies([]string{v}); err == nil {
+			t.Errorf("%v: expected error", v)
+		}
+	}
+}
+
+func TestForwardedClient(t *testing.T) {
+	d := trustedStore(t)
+
+	tests := []struct {
+		name   string
+		xff    []string
+		realIP string
+		client string
+		hops   []string
+		ok     bool
+	}{
+		{"no headers", nil, "", "", nil, false},
+		{"real ip", nil, "192.0.2.1", "192.0.2.1", nil, true},
+		{"real ip with port", nil, "192.0.2.1:1234", "192.0.2.1:1234",
+			nil, true},
+		{"invalid real ip", nil, "unknown", "", nil, false},
+		{"forward wins over real ip", []string{"192.0.2.1"},
+			"198.51.100.1", "192.0.2.1", nil, true},
+		{"single hop", []string{"192.0.2.1"}, "", "192.0.2.1", nil,
+			true},
+
+		// The leftmost addresses were provided by the client and
+		// are not trusted.
+		{"spoofed leftmost", []string{"10.0.0.5, 192.0.2.1"}, "",
+			"192.0.2.1", []string{"10.0.0.5"}, true},
+		{"spoofed chain", []string{"198.51.100.9, 203.0.113.4, 192.0.2.1"},
+			"", "192.0.2.1", []string{"198.51.100.9", "203.0.113.4"},
+			true},
+
+		// Trusted hops are skipped from the right.
+		{"trusted hops", []string{"192.0.2.1, 10.0.0.2, 10.0.0.3"}, "",
+			"192.0.2.1", []string{}, true},
+		{"trusted ipv6 hop", []string{"192.0.2.1, 2001:db8::1"}, "",
+			"192.0.2.1", []string{}, true},
+		{"trusted mapped hop", []string{"192.0.2.1, ::ffff:10.0.0.2"},
+			"", "192.0.2.1", []string{}, true},
+		{"spoofed behind trusted hops", []string{
+			"203.0.113.4, 192.0.2.1, 10.0.0.2"}, "", "192.0.2.1",
+			[]string{"203.0.113.4"}, true},
+		{"multiple headers", []string{"203.0.113.4", "192.0.2.1",
+			"10.0.0.2"}, "", "192.0.2.1", []string{"203.0.113.4"},
+			true},
+		{"only trusted hops", []string{"10.0.0.2, 10.0.0.3"}, "",
+			"10.0.0.2", []string{}, true},
+		{"hop with port", []string{"192.0.2.1:443, 10.0.0.2"}, "",
+			"192.0.2.1:443", []string{}, true},
+
+		// Invalid hops are not skipped, the client is unknown.
+		{"invalid closest hop", []string{"192.0.2.1, unknown"}, "", "",
+			nil, false},
+		{"invalid hop behind trusted", []string{"unknown, 10.0.0.2"},
+			"", "", nil, false},
+		{"empty hop", []string{"192.0.2.1, "}, "", "", nil, false},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		for _, v := range test.xff {
+			r.Header.Add(forward, v)
+		}
+		if test.realIP != "" {
+			r.Header.Set(realIP, test.realIP)
+		}
+		client, hops, ok := d.forwardedClient(r)
+		if ok != test.ok || client != test.client {
+			t.Errorf("%v: got client %q %v, want %q %v", test.name,
+				client, ok, test.client, test.ok)
+			continue
+		}
+		if len(hops) != len(test.hops) ||
+			(len(hops) != 0 && !reflect.DeepEqual(hops, test.hops)) {
+			t.Errorf("%v: got hops %q, want %q", test.name, hops,
+				test.hops)
+		}
+	}
+}
+
+func TestTrustProxies(t *testing.T) {
+	d := trustedStore(t)
+	var err error
+	d.ipPolicies, err = parseIPPolicies(nil, []string{"192.0.2.66"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type seen struct {
+		remoteAddr string
+		xff        string
+		realIP     string
+	}
+	var got seen
+	h := d.trustProxies(d.requireIP(ipClassAll,
+		func(w http.ResponseWriter, r *http.Request) {
+			got = seen{
+				remoteAddr: r.RemoteAddr,
+				xff:        r.Header.Get(forward),
+				realIP:     r.Header.Get(realIP),
+			}
+		}))
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		xff        string
+		realIP     string
+		code       int
+		want       seen
+	}{
+		{"untrusted peer", "203.0.113.4:1234", "192.0.2.1", "",
+			http.StatusOK, seen{"203.0.113.4:1234", "192.0.2.1", ""}},
+		{"untrusted peer claims banned", "203.0.113.4:1234",
+			"192.0.2.66", "", http.StatusOK,
+			seen{"203.0.113.4:1234", "192.0.2.66", ""}},
+		{"trusted proxy", "10.0.0.1:1234", "192.0.2.1", "",
+			http.StatusOK, seen{"192.0.2.1", "", ""}},
+		{"trusted proxy real ip", "10.0.0.1:1234", "", "192.0.2.1",
+			http.StatusOK, seen{"192.0.2.1", "", ""}},
+		{"trusted proxy keeps untrusted hops", "10.0.0.1:1234",
+			"198.51.100.9, 203.0.113.4, 192.0.2.1", "",
+			http.StatusOK, seen{"192.0.2.1",
+				"198.51.100.9, 203.0.113.4", ""}},
+		{"trusted proxy without client", "10.0.0.1:1234", "", "",
+			http.StatusOK, seen{"10.0.0.1:1234", "", ""}},
+
+		// Policies apply to the forwarded client, spoofed hops don't
+		// get a banned client in.
+		{"banned client", "10.0.0.1:1234", "192.0.2.66", "",
+			http.StatusForbidden, seen{}},
+		{"banned client spoofs leftmost", "10.0.0.1:1234",
+			"192.0.2.1, 192.0.2.66", "", http.StatusForbidden,
+			seen{}},
+		{"banned client real ip", "10.0.0.1:1234", "", "192.0.2.66",
+			http.StatusForbidden, seen{}},
+		{"spoofed banned leftmost", "10.0.0.1:1234",
+			"192.0.2.66, 192.0.2.1", "", http.StatusOK,
+			seen{"192.0.2.1", "192.0.2.66", ""}},
+	}
+	for _, test := range tests {
+		got = seen{}
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = test.remoteAddr
+		if test.xff != "" {
+			r.Header.Set(forward, test.xff)
+		}
+		if test.realIP != "" {
+			r.Header.Set(realIP, test.realIP)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != test.code {
+			t.Errorf("%v: got status %v, want %v", test.name,
+				w.Code, test.code)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("%v: got %+v, want %+v", test.name, got,
+				test.want)
+		}
+	}
+}
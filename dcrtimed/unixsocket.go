@@ -0,0 +1,53 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// isUnixSocket returns true if the request was received on a unix socket
+// listener.  Access to those is controlled by the file mode of the socket.
+func isUnixSocket(r *http.Request) bool {
+	return strings.HasPrefix(r.RemoteAddr, unixListenerPrefix)
+}
+
+// listenUnix serves plain http on the unix socket at the provided path.  A
+// stale socket that was left behind by an earlier run is replaced.  The remote
+// address of requests is the listener so that they can be told apart in logs.
+func listenUnix(path string, mode os.FileMode, h http.Handler) error {
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return fmt.Errorf("%v exists and is not a socket", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		l.Close()
+		return err
+	}
+
+	srv := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.RemoteAddr = unixListenerPrefix + path
+			h.ServeHTTP(w, r)
+		}),
+	}
+	return srv.Serve(l)
+}
@@ -0,0 +1,191 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"sync"
+	"time"
+
+	"github.com/decred/dcrtime/dcrtimed/backend"
+)
+
+const (
+	// verifyCacheInterval is the interval at which the backend is checked
+	// for reorganizations that invalidate cached proofs.
+	verifyCacheInterval = time.Minute
+
+	// verifyCacheReorgAge is the age up to which proofs are dropped from
+	// the verify cache when the backend reports a reorganization.
+	verifyCacheReorgAge = 24 * time.Hour
+)
+
+// verifyCache is a fixed size LRU cache of completed timestamp proofs. Only
+// anchored digests are cached since their proofs never change, unless a
+// reorganization deeper than the required confirmations occurs.
+type verifyCache struct {
+	sync.Mutex
+
+	size    int
+	entries map[[sha256.Size]byte]*list.Element
+	lru     *list.List // Most recently used first
+	hits    uint64
+	misses  uint64
+}
+
+// newVerifyCache returns a verify cache that holds up to size proofs.
+func newVerifyCache(size int) *verifyCache {
+	return &verifyCache{
+		size:    size,
+		entries: make(map[[sha256.Size]byte]*list.Element, size),
+		lru:     list.New(),
+	}
+}
+
+// isComplete returns true if the provided result is a proof that will never
+// change and may therefore be cached.
+func isComplete(gr backend.GetResult) bool {
+	return gr.ErrorCode == backend.ErrorOK && gr.AnchoredTimestamp != 0 &&
+		gr.Confirmations == nil
+}
+
+// get returns the cached proof of the provided digest.
+func (c *verifyCache) get(digest [sha256.Size]byte) (backend.GetResult, bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	e, ok := c.entries[digest]
+	if !ok {
+		c.misses++
+		return backend.GetResult{}, false
+	}
+	c.hits++
+	c.lru.MoveToFront(e)
+	return e.Value.(backend.GetResult), true
+}
+
+// put caches the provided proof if it is complete. The least recently used
+// proof is evicted when the cache is full.
+func (c *verifyCache) put(gr backend.GetResult) {
+	if !isComplete(gr) {
+		return
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	if e, ok := c.entries[gr.Digest]; ok {
+		c.lru.MoveToFront(e)
+		return
+	}
+	c.entries[gr.Digest] = c.lru.PushFront(gr)
+	if c.lru.Len() > c.size {
+		e := c.lru.Back()
+		c.lru.Remove(e)
+		delete(c.entries, e.Value.(backend.GetResult).Digest)
+	}
+}
+
+// invalidate removes the proofs that were anchored at or after the provided
+// time and returns how many were removed.
+func (c *verifyCache) invalidate(since int64) int {
+	c.Lock()
+	defer c.Unlock()
+
+	removed := 0
+	for e := c.lru.Front(); e != nil; {
+		next := e.Next()
+		gr := e.Value.(backend.GetResult)
+		if gr.AnchoredTimestamp >= since {
+			c.lru.Remove(e)
+			delete(c.entries, gr.Digest)
+			removed++
+		}
+		e = next
+	}
+	return removed
+}
+
+// stats returns the number of cached proofs, hits and misses.
+func (c *verifyCache) stats() (int, uint64, uint64) {
+	c.Lock()
+	defer c.Unlock()
+
+	return c.lru.Len(), c.hits, c.misses
+}
+
+// getDigests returns the timestamp information of the provided digests. Proofs
+// of anchored digests are served from the verify cache, if enabled, and only
+// the remaining digests are looked up in the backend.
+func (d *DcrtimeStore) getDigests(digests [][sha256.Size]byte) ([]backend.GetResult, error) {
+	if d.verifyCache == nil {
+		return d.backend.Get(digests)
+	}
+
+	results := make([]backend.GetResult, len(digests))
+	missing := make([][sha256.Size]byte, 0, len(digests))
+	index := make([]int, 0, len(digests))
+	for k, digest := range digests {
+		gr, ok := d.verifyCache.get(digest)
+		if ok {
+			results[k] = gr
+			continue
+		}
+		missing = append(missing, digest)
+		index = append(index, k)
+	}
+	if len(missing) == 0 {
+		return results, nil
+	}
+
+	grs, err := d.backend.Get(missing)
+	if err != nil {
+		return nil, err
+	}
+	for k, gr := range grs {
+		results[index[k]] = gr
+		d.verifyCache.put(gr)
+	}
+
+	return results, nil
+}
+
+// checkVerifyCache drops the recently anchored proofs from the verify cache
+// when the backend reported a reorganization since the provided time of the
+// last one, which is updated.
+func (d *DcrtimeStore) checkVerifyCache(lastReorg *int64) {
+	sr, err := d.backend.Status()
+	if err != nil {
+		log.Errorf("Verify cache: status: %v", err)
+		return
+	}
+	if sr.LastReorg <= *lastReorg {
+		return
+	}
+	*lastReorg = sr.LastReorg
+
+	since := time.Unix(sr.LastReorg, 0).Add(-verifyCacheReorgAge).Unix()
+	removed := d.verifyCache.invalidate(since)
+	log.Infof("Verify cache: dropped %v proofs after reorg at %v", removed,
+		time.Unix(sr.LastReorg, 0).UTC())
+}
+
+// verifyCacheWatcher checks the backend for reorganizations every verify
+// cache interval.
+func (d *DcrtimeStore) verifyCacheWatcher() {
+	ticker := time.NewTicker(verifyCacheInterval)
+	defer ticker.Stop()
+	var lastReorg int64
+	for {
+		d.checkVerifyCache(&lastReorg)
+
+		select {
+		case <-d.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
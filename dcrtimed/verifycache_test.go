@@ -0,0 +1,170 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/sha256"
+	"testing"
+	"time"
+
+	"github.com/decred/dcrtime/dcrtimed/backend"
+	"github.com/decred/dcrtime/dcrtimed/backend/backendtest"
+	"github.com/decred/dcrtime/dcrtimed/backend/testsuite"
+)
+
+// anchoredResult returns the complete proof of the digest derived from seed.
+func anchoredResult(seed string, anchored int64) backend.GetResult {
+	return backend.GetResult{
+		Digest:            sha256.Sum256([]byte(seed)),
+		ErrorCode:         backend.ErrorOK,
+		AnchoredTimestamp: anchored,
+	}
+}
+
+// cached returns whether the proof of the digest derived from seed is cached.
+func cached(c *verifyCache, seed string) bool {
+	_, ok := c.get(sha256.Sum256([]byte(seed)))
+	return ok
+}
+
+// TestVerifyCacheEviction verifies that the least recently used proof is
+// evicted and that proofs that may still change are not cached.
+func TestVerifyCacheEviction(t *testing.T) {
+	c := newVerifyCache(2)
+	c.put(anchoredResult("a", 1))
+	c.put(anchoredResult("b", 1))
+
+	// Using a makes b the least recently used proof.
+	if !cached(c, "a") {
+		t.Fatal("a not cached")
+	}
+	c.put(anchoredResult("c", 1))
+	if cached(c, "b") {
+		t.Fatal("b not evicted")
+	}
+	if !cached(c, "a") || !cached(c, "c") {
+		t.Fatal("a or c evicted")
+	}
+
+	// Putting a cached proof again only refreshes it.
+	c.put(anchoredResult("a", 1))
+	c.put(anchoredResult("d", 1))
+	if cached(c, "c") {
+		t.Fatal("c not evicted")
+	}
+	if !cached(c, "a") || !cached(c, "d") {
+		t.Fatal("a or d evicted")
+	}
+
+	confirmations := int32(1)
+	incomplete := []backend.GetResult{
+		anchoredResult("pending", 0),
+		{
+			Digest:            sha256.Sum256([]byte("unconfirmed")),
+			ErrorCode:         backend.ErrorOK,
+			AnchoredTimestamp: 1,
+			Confirmations:     &confirmations,
+		},
+		{
+			Digest:    sha256.Sum256([]byte("missing")),
+			ErrorCode: backend.ErrorNotFound,
+		},
+	}
+	for _, gr := range incomplete {
+		c.put(gr)
+	}
+	for _, seed := range []string{"pending", "unconfirmed", "missing"} {
+		if cached(c, seed) {
+			t.Fatalf("%v cached", seed)
+		}
+	}
+
+	entries, hits, misses := c.stats()
+	if entries != 2 || hits != 5 || misses != 5 {
+		t.Fatalf("got %v entries %v hits %v misses, want 2 5 5",
+			entries, hits, misses)
+	}
+}
+
+// TestVerifyCacheInvalidate verifies that only the proofs anchored since the
+// provided time are removed.
+func TestVerifyCacheInvalidate(t *testing.T) {
+	c := newVerifyCache(10)
+	c.put(anchoredResult("old", 100))
+	c.put(anchoredResult("since", 200))
+	c.put(anchoredResult("new", 300))
+
+	if n := c.invalidate(200); n != 2 {
+		t.Fatalf("got %v removed proofs, want 2", n)
+	}
+	if cached(c, "since") || cached(c, "new") {
+		t.Fatal("recent proof not removed")
+	}
+	if !cached(c, "old") {
+		t.Fatal("old proof removed")
+	}
+
+	// The removed proofs no longer count against the size.
+	if entries, _, _ := c.stats(); entries != 1 {
+		t.Fatalf("got %v entries, want 1", entries)
+	}
+}
+
+// reorgBackend reports the provided time of the last reorg.
+type reorgBackend struct {
+	backend.Backend
+
+	lastReorg int64
+}
+
+// Status satisfies the backend.Backend interface.
+func (b *reorgBackend) Status() (*backend.StatusResult, error) {
+	sr, err := b.Backend.Status()
+	if err != nil {
+		return nil, err
+	}
+	sr.LastReorg = b.lastReorg
+	return sr, nil
+}
+
+// TestVerifyCacheReorg verifies that the recently anchored proofs are dropped
+// once the backend reports a reorganization.
+func TestVerifyCacheReorg(t *testing.T) {
+	b := &reorgBackend{
+		Backend: backendtest.New(testsuite.NewWallet(), 1),
+	}
+	d := newDcrtimeStore(testConfig(t), b)
+	d.verifyCache = newVerifyCache(10)
+
+	now := time.Now()
+	old := now.Add(-2 * verifyCacheReorgAge).Unix()
+	d.verifyCache.put(anchoredResult("old", old))
+	d.verifyCache.put(anchoredResult("recent", now.Add(-time.Hour).Unix()))
+
+	var lastReorg int64
+	d.checkVerifyCache(&lastReorg)
+	if !cached(d.verifyCache, "old") || !cached(d.verifyCache, "recent") {
+		t.Fatal("proof removed without reorg")
+	}
+
+	b.lastReorg = now.Unix()
+	d.checkVerifyCache(&lastReorg)
+	if lastReorg != b.lastReorg {
+		t.Fatalf("got last reorg %v, want %v", lastReorg, b.lastReorg)
+	}
+	if cached(d.verifyCache, "recent") {
+		t.Fatal("recent proof not removed")
+	}
+	if !cached(d.verifyCache, "old") {
+		t.Fatal("old proof removed")
+	}
+
+	// Proofs cached after the reorg are kept until the next one.
+	d.verifyCache.put(anchoredResult("recent", now.Unix()))
+	d.checkVerifyCache(&lastReorg)
+	if !cached(d.verifyCache, "recent") {
+		t.Fatal("proof removed without new reorg")
+	}
+}
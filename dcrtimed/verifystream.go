@@ -0,0 +1,411 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	v2 "github.com/decred/dcrtime/api/v2"
+	"github.com/decred/dcrtime/dcrtimed/backend"
+	"github.com/decred/dcrtime/util"
+)
+
+// verifyStreamChunk is the maximum number of digests of a verify stream that
+// are looked up in the backend at once.  It is also the number of parsed
+// digests that are buffered while a chunk is being looked up, which bounds the
+// memory of a stream regardless of its length.  A client that stops reading
+// results is therefore no longer read from either.
+const verifyStreamChunk = 256
+
+// streamDigest is a single parsed line of a verify stream.
+type streamDigest struct {
+	digest    [sha256.Size]byte
+	accessKey string
+	err       error
+}
+
+// readStream parses the newline delimited digests of a verify stream and
+// sends them on the returned channel, which is closed at the end of the
+// stream.  Parsing stops after the first error, which is sent as the last
+// value.
+func readStream(ctx context.Context, r io.Reader) <-chan streamDigest {
+	c := make(chan streamDigest, verifyStreamChunk)
+	go func() {
+		defer close(c)
+
+		send := func(sd streamDigest) bool {
+			select {
+			case c <- sd:
+				return sd.err == nil
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		scanner := bufio.NewScanner(r)
+		for line := 1; scanner.Scan(); line++ {
+			b := bytes.TrimSpace(scanner.Bytes())
+			if len(b) == 0 {
+				continue
+			}
+
+			var vsd v2.VerifyStreamDigest
+			decoder := json.NewDecoder(bytes.NewReader(b))
+			decoder.DisallowUnknownFields()
+			if err := decoder.Decode(&vsd); err != nil {
+				send(streamDigest{
+					err: fmt.Errorf("invalid line %v", line),
+				})
+				return
+			}
+			digests, err := convertDigests([]string{vsd.Digest})
+			if err != nil {
+				send(streamDigest{
+					err: fmt.Errorf("invalid digest on line %v",
+						line),
+				})
+				return
+			}
+			if !send(streamDigest{
+				digest:    digests[0],
+				accessKey: vsd.AccessKey,
+			}) {
+				return
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			send(streamDigest{
+				err: fmt.Errorf("unable to read request: %v", err),
+			})
+		}
+	}()
+
+	return c
+}
+
+// nextChunk waits for the next digest of the stream and returns it together
+// with the digests that are already buffered, up to verifyStreamChunk.  It
+// does not wait for a full chunk so that a slow client still gets its results
+// right away.  An empty chunk is returned at the end of the stream.  Digests
+// that preceded an error are returned together with the error.  The access
+// keys of the digests are added to the provided digest access.
+func nextChunk(ctx context.Context, c <-chan streamDigest, chunk [][sha256.Size]byte, access *digestAccess) ([][sha256.Size]byte, error) {
+	chunk = chunk[:0]
+
+	select {
+	case sd, ok := <-c:
+		if !ok {
+			return chunk, nil
+		}
+		if sd.err != nil {
+			return nil, sd.err
+		}
+		chunk = append(chunk, sd.digest)
+		access.addKey(sd.accessKey)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	for len(chunk) < verifyStreamChunk {
+		select {
+		case sd, ok := <-c:
+			if !ok {
+				return chunk, nil
+			}
+			if sd.err != nil {
+				return chunk, sd.err
+			}
+			chunk = append(chunk, sd.digest)
+			access.addKey(sd.accessKey)
+		default:
+			return chunk, nil
+		}
+	}
+
+	return chunk, nil
+}
+
+// convertVerifyDigest translates a backend result to its v2 representation.
+// It returns false if the backend error code is unknown.
+func convertVerifyDigest(dr backend.GetResult) (v2.VerifyDigest, bool) {
+	vd := v2.VerifyDigest{
+		Digest:          hex.EncodeToString(dr.Digest[:]),
+		ServerTimestamp: dr.Timestamp,
+		ServerTime:      v2.FormatTime(dr.Timestamp),
+		FlushTimestamp:  dr.FlushTimestamp,
+		FlushTime:       v2.FormatTime(dr.FlushTimestamp),
+		Label:           dr.Label,
+		Algorithm:       dr.Algorithm,
+		ChainInformation: v2.ChainInformation{
+			Confirmations:    dr.Confirmations,
+			MinConfirmations: dr.MinConfirmations,
+			ReorgTimestamp:   dr.ReorgTimestamp,
+			ReorgTime:        v2.FormatTime(dr.ReorgTimestamp),
+			ChainTimestamp:   dr.AnchoredTimestamp,
+			ChainTime:        v2.FormatTime(dr.AnchoredTimestamp),
+			Transaction:      dr.Tx.String(),
+			AnchorPrefix:     dr.AnchorPrefix,
+			Attestations:     convertAttestations(dr.Attestations),
+			MerkleRoot:       hex.EncodeToString(dr.MerkleRoot[:]),
+			MerklePath:       v2.MerkleBranch(dr.MerklePath),
+		},
+	}
+	switch dr.ErrorCode {
+	case backend.ErrorOK:
+		vd.Result = v2.ResultOK
+	case backend.ErrorNotFound:
+		vd.Result = v2.ResultDoesntExistError
+	default:
+		return vd, false
+	}
+
+	return vd, true
+}
+
+// enableFullDuplex allows the handler to keep reading the request body after
+// it started writing the reply.  HTTP/2 requests are always full duplex.
+func enableFullDuplex(w http.ResponseWriter, r *http.Request) error {
+	err := http.NewResponseController(w).EnableFullDuplex()
+	if err != nil && r.ProtoMajor < 2 {
+		return err
+	}
+	return nil
+}
+
+// verifyStreamV2 verifies a stream of newline delimited digests of unbounded
+// length.  The results are streamed back in the order of the digests while
+// the request is still being read.  Digests are looked up in chunks so that
+// other requests are served in between and the stream only reads ahead a
+// single chunk.
+// Handles /v2/verify/stream
+func (d *DcrtimeStore) verifyStreamV2(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	if err := enableFullDuplex(w, r); err != nil {
+		log.Errorf("%v VerifyStream %v: %v", r.URL.Path, logAddr(r),
+			err)
+		util.RespondWithError(w, http.StatusHTTPVersionNotSupported,
+			"Streaming is not supported over this connection")
+		return
+	}
+
+	via := logAddr(r)
+	xff := r.Header.Get(forward)
+	if xff != "" {
+		via = fmt.Sprintf("%v via %v", logAddr(r), xff)
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	c := readStream(ctx, r.Body)
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	encoder := json.NewEncoder(w)
+
+	var count int
+	chunk := make([][sha256.Size]byte, 0, verifyStreamChunk)
+	access := d.newDigestAccess(r, nil)
+	start := time.Now()
+	defer func() {
+		log.Infof("%v VerifyStream %v: Digests %v in %v", r.URL.Path,
+			via, count, time.Since(start).Round(time.Millisecond))
+	}()
+	for {
+		var streamErr error
+		chunk, streamErr = nextChunk(ctx, c, chunk, access)
+		if errors.Is(streamErr, context.Canceled) {
+			return
+		}
+		if len(chunk) == 0 {
+			if streamErr != nil {
+				encoder.Encode(v2.VerifyStreamError{
+					Error: streamErr.Error(),
+				})
+			}
+			return
+		}
+
+		drs, err := d.getDigests(chunk)
+		if err == nil {
+			err = access.hideDigests(drs)
+		}
+		var metadata []map[string]string
+		if err == nil {
+			metadata, err = d.visibleMetadata(r, drs)
+		}
+		if err != nil {
+			// Generic internal error.
+			errorCode := time.Now().Unix()
+			log.Errorf("%v verify stream get error code %v: %v",
+				logAddr(r), errorCode, err)
+			encoder.Encode(v2.VerifyStreamError{
+				Error: fmt.Sprintf("Could not retrieve digests, "+
+					"contact administrator and provide the "+
+					"following error code: %v", errorCode),
+			})
+			return
+		}
+		if err := d.auditProofs(r, drs, nil); err != nil {
+			// Generic internal error.
+			errorCode := time.Now().Unix()
+			log.Errorf("%v verify stream audit error code %v: %v",
+				logAddr(r), errorCode, err)
+			encoder.Encode(v2.VerifyStreamError{
+				Error: fmt.Sprintf("Could not record served "+
+					"proofs, contact administrator and "+
+					"provide the following error code: %v",
+					errorCode),
+			})
+			return
+		}
+		for k, dr := range drs {
+			vd, ok := convertVerifyDigest(dr)
+			if !ok {
+				// Generic internal error.
+				errorCode := time.Now().Unix()
+				log.Errorf("%v digest ErrorCode translation "+
+					"error code %v: %v", logAddr(r),
+					errorCode, dr.ErrorCode)
+				encoder.Encode(v2.VerifyStreamError{
+					Error: fmt.Sprintf("Could not retrieve "+
+						"digests, contact administrator "+
+						"and provide the following error "+
+						"code: %v", errorCode),
+				})
+				return
+			}
+			vd.Metadata = metadata[k]
+			if err := encoder.Encode(vd); err != nil {
+				// Client went away.
+				return
+			}
+		}
+		count += len(drs)
+
+		if streamErr != nil {
+			encoder.Encode(v2.VerifyStreamError{
+				Error: streamErr.Error(),
+			})
+			return
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// proxyVerifyStreamV2 relays a verify stream to the storehost.  The store
+// timeout does not apply since a stream may take arbitrarily long.
+func (d *DcrtimeStore) proxyVerifyStreamV2(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	if err := enableFullDuplex(w, r); err != nil {
+		log.Errorf("%v VerifyStream %v: %v", r.URL.Path, logAddr(r),
+			err)
+		util.RespondWithError(w, http.StatusHTTPVersionNotSupported,
+			"Streaming is not supported over this connection")
+		return
+	}
+
+	d.relayStream(w, r, withAPIToken(v2.VerifyStreamRoute, r),
+		"VerifyStream", r.Body)
+}
+
+// relayStream sends a request with the provided body to the preferred
+// storehost and relays its reply while it is being streamed.  A stream can not
+// be replayed so it is neither retried nor failed over once it started.  The
+// connection is aborted if the storehost fails mid stream so that the client
+// does not mistake a truncated reply for a complete one.
+func (d *DcrtimeStore) relayStream(w http.ResponseWriter, r *http.Request, route, name string, body io.Reader) {
+	upstreams := d.upstreamsByPreference()
+	if len(upstreams) == 0 {
+		d.respondFromBackend(w, route, nil, &unavailableError{
+			retryAfter: d.retryAfter(),
+			err:        errors.New("no storehost available"),
+		})
+		return
+	}
+	u := upstreams[0]
+
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodPost,
+		fmt.Sprintf("https://%s%s", u.host, route), body)
+	if err != nil {
+		d.respondFromBackend(w, route, nil, err)
+		return
+	}
+	req.Header.Set("Content-Type", r.Header.Get("Content-Type"))
+	req.Header.Set(forward, r.RemoteAddr)
+	req.Header.Set(requestIDHeader, requestID(r.Context()))
+
+	client := &http.Client{Transport: u.client.Transport}
+	resp, err := client.Do(req)
+	if err != nil {
+		if r.Context().Err() == nil && u.failed(d.cfg.StoreBreakerLimit,
+			d.cfg.StoreBreakerPeriod) {
+			log.Warnf("Storehost %v circuit breaker open for %v",
+				u.host, d.cfg.StoreBreakerPeriod)
+		}
+		log.Errorf("%v %v %v: storehost %v: %v", r.URL.Path, name,
+			logAddr(r), u.host, err)
+		d.respondFromBackend(w, route, nil, &unavailableError{
+			retryAfter: d.retryAfter(),
+			err:        err,
+		})
+		return
+	}
+	defer resp.Body.Close()
+	u.succeeded()
+
+	if resp.StatusCode != http.StatusOK {
+		body, err := io.ReadAll(resp.Body)
+		d.respondFromBackend(w, route, &upstreamReply{
+			statusCode:  resp.StatusCode,
+			status:      resp.Status,
+			contentType: resp.Header.Get("Content-Type"),
+			retryAfter:  resp.Header.Get("Retry-After"),
+			body:        body,
+		}, err)
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", resp.Header.Get("Content-Type"))
+	if cd := resp.Header.Get("Content-Disposition"); cd != "" {
+		w.Header().Set("Content-Disposition", cd)
+	}
+	w.WriteHeader(http.StatusOK)
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := resp.Body.Read(buf)
+		if n > 0 {
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				return
+			}
+			if werr := rc.Flush(); werr != nil {
+				return
+			}
+		}
+		if err != nil {
+			if err != io.EOF {
+				log.Errorf("%v %v %v: storehost %v: %v",
+					r.URL.Path, name, logAddr(r), u.host, err)
+				panic(http.ErrAbortHandler)
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,506 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"time"
+
+	v2 "github.com/decred/dcrtime/api/v2"
+	"github.com/decred/dcrtime/dcrtimed/backend"
+	"github.com/decred/dcrtime/util"
+)
+
+const (
+	// webhookPoll is the time between checks for new anchors and due
+	// webhook deliveries.
+	webhookPoll = time.Minute
+
+	// webhookMinBackoff is the time after which a failed webhook delivery
+	// is attempted again for the first time.  It doubles with every
+	// failed attempt.
+	webhookMinBackoff = time.Minute
+
+	// webhookMaxBackoff is the maximum time between two attempts of a
+	// webhook delivery.
+	webhookMaxBackoff = 6 * time.Hour
+
+	// webhookTimeout is the maximum time a single delivery attempt may
+	// take.
+	webhookTimeout = 30 * time.Second
+
+	// webhookDeliveryHeader carries the delivery ID of a webhook so that
+	// receivers are able to detect duplicates without decoding it.
+	webhookDeliveryHeader = "X-Dcrtime-Delivery"
+)
+
+// deliveryID returns the ID of the webhook delivery of the collection with the
+// provided timestamp to the provided URL.  IDs sort by collection timestamp so
+// that the deliveries of a receiver are attempted in the order of the anchors.
+func deliveryID(timestamp int64, webhookURL string) string {
+	var id [16]byte
+	binary.BigEndian.PutUint64(id[:8], uint64(timestamp))
+	hash := sha256.Sum256([]byte(webhookURL))
+	copy(id[8:], hash[:8])
+	return hex.EncodeToString(id[:])
+}
+
+// webhookBackoff returns the time until the next attempt of a webhook delivery
+// that failed the provided number of times.
+func webhookBackoff(attempts int) time.Duration {
+	backoff := webhookMinBackoff
+	for i := 1; i < attempts && backoff < webhookMaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > webhookMaxBackoff {
+		backoff = webhookMaxBackoff
+	}
+	return backoff
+}
+
+// convertDelivery converts a backend webhook delivery to its API
+// representation.
+func convertDelivery(wd backend.Delivery) v2.WebhookDelivery {
+	return v2.WebhookDelivery{
+		ID:                   wd.ID,
+		URL:                  wd.URL,
+		CreatedTimestamp:     wd.Created,
+		CreatedTime:          v2.FormatTime(wd.Created),
+		Attempts:             wd.Attempts,
+		NextAttemptTimestamp: wd.NextAttempt,
+		NextAttemptTime:      v2.FormatTime(wd.NextAttempt),
+		LastError:            wd.LastError,
+		Dead:                 wd.Dead,
+		Payload:              json.RawMessage(wd.Payload),
+	}
+}
+
+// enqueueWebhooks stores a webhook delivery for every receiver and every
+// collection that was anchored with enough confirmations since the last call.
+// The deliveries are stored together with the block height they cover so
+// that no anchor is skipped or enqueued twice across restarts.  The very first
+// call only records the current block height, past anchors are not delivered.
+func (d *DcrtimeStore) enqueueWebhooks() error {
+	height, err := d.backend.GetDeliveryHeight()
+	if err != nil {
+		return err
+	}
+	from := height + 1
+	if height < 0 {
+		from = 0
+	}
+	anchors, err := d.backend.GetAnchors(from, math.MaxInt32)
+	if err != nil {
+		return err
+	}
+	if len(anchors) == 0 {
+		if height < 0 {
+			return d.backend.PutDeliveries(nil, 0)
+		}
+		return nil
+	}
+
+	// Anchors are ordered by block height.
+	to := anchors[len(anchors)-1].BlockHeight
+	if height < 0 {
+		log.Infof("Webhooks: delivering anchors after block %v", to)
+		return d.backend.PutDeliveries(nil, to)
+	}
+
+	now := time.Now().Unix()
+	network := netName(activeNetParams)
+	deliveries := make([]backend.Delivery, 0,
+		len(anchors)*len(d.cfg.WebhookURLs))
+	for _, ar := range anchors {
+		anchor := convertAnchor(ar, true)
+		for _, webhookURL := range d.cfg.WebhookURLs {
+			id := deliveryID(ar.Timestamp, webhookURL)
+			payload, err := json.Marshal(v2.Webhook{
+				ID:      id,
+				Event:   v2.WebhookEventAnchored,
+				Network: network,
+				Anchor:  anchor,
+			})
+			if err != nil {
+				return err
+			}
+			deliveries = append(deliveries, backend.Delivery{
+				ID:          id,
+				URL:         webhookURL,
+				Payload:     payload,
+				Created:     now,
+				NextAttempt: now,
+			})
+		}
+	}
+	err = d.backend.PutDeliveries(deliveries, to)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Webhooks: enqueued %v deliveries for blocks %v-%v",
+		len(deliveries), from, to)
+
+	return nil
+}
+
+// postWebhook posts a webhook delivery to its receiver.  The payload is signed
+// with the identity key.  Any reply other than 2xx is a failure.
+func (d *DcrtimeStore) postWebhook(client *http.Client, wd *backend.Delivery) error {
+	ctx, cancel := context.WithTimeout(d.ctx, webhookTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, wd.URL,
+		bytes.NewReader(wd.Payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(webhookDeliveryHeader, wd.ID)
+	req.Header.Set(v2.SignatureHeader, hex.EncodeToString(ed25519.Sign(
+		d.activeKey(), wd.Payload)))
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("receiver replied %v", resp.Status)
+	}
+	return nil
+}
+
+// attemptDelivery attempts the webhook delivery with the provided ID if it is
+// due.  A delivery that was accepted is removed, a failed delivery is
+// rescheduled with an exponential backoff or moved to the dead-letter queue
+// once it exhausted its attempts.  It returns true if the delivery is no
+// longer pending.
+func (d *DcrtimeStore) attemptDelivery(client *http.Client, id string) (bool, error) {
+	d.webhookMtx.Lock()
+	defer d.webhookMtx.Unlock()
+
+	// The delivery may have been changed through the admin API.
+	wd, err := d.backend.GetDelivery(id)
+	if errors.Is(err, backend.ErrDeliveryNotFound) {
+		return true, nil
+	} else if err != nil {
+		return false, err
+	}
+	now := time.Now()
+	if wd.Dead {
+		return true, nil
+	}
+	if now.Unix() < wd.NextAttempt {
+		return false, nil
+	}
+
+	err = d.postWebhook(client, wd)
+	if err == nil {
+		log.Debugf("Webhook %v delivered to %v", wd.ID, wd.URL)
+		return true, d.backend.DeleteDelivery(wd.ID)
+	}
+	if d.ctx.Err() != nil {
+		// Shutting down, not the fault of the receiver.
+		return false, nil
+	}
+
+	wd.Attempts++
+	wd.LastError = err.Error()
+	wd.NextAttempt = now.Add(webhookBackoff(wd.Attempts)).Unix()
+	if wd.Attempts >= d.cfg.WebhookMaxAttempts {
+		wd.Dead = true
+		log.Errorf("Webhook %v to %v failed %v times, moved to "+
+			"dead-letter queue: %v", wd.ID, wd.URL, wd.Attempts, err)
+	} else {
+		log.Warnf("Webhook %v to %v failed, attempt %v at %v: %v",
+			wd.ID, wd.URL, wd.Attempts+1,
+			time.Unix(wd.NextAttempt, 0).UTC().Format(fStr), err)
+	}
+	err = d.backend.UpdateDelivery(*wd)
+	if errors.Is(err, backend.ErrDeliveryNotFound) {
+		return true, nil
+	}
+	return wd.Dead, err
+}
+
+// deliverWebhooks attempts all due webhook deliveries.  The deliveries of a
+// receiver are attempted in order and a receiver is skipped for the rest of
+// the round once one of its deliveries is not accepted, so that later anchors
+// never overtake earlier ones.  Dead deliveries do not hold up the receiver.
+func (d *DcrtimeStore) deliverWebhooks(client *http.Client) error {
+	deliveries, err := d.backend.GetDeliveries()
+	if err != nil {
+		return err
+	}
+
+	blocked := make(map[string]struct{})
+	for _, wd := range deliveries {
+		if d.ctx.Err() != nil {
+			return nil
+		}
+		if wd.Dead {
+			continue
+		}
+		if _, ok := blocked[wd.URL]; ok {
+			continue
+		}
+		done, err := d.attemptDelivery(client, wd.ID)
+		if err != nil {
+			return err
+		}
+		if !done {
+			blocked[wd.URL] = struct{}{}
+		}
+	}
+
+	return nil
+}
+
+// webhooker enqueues a webhook for every anchor that reaches the required
+// confirmations and for the anchors of webhook subscriptions and delivers them
+// at least once.  Delivery state is stored in the backend so that pending
+// webhooks survive restarts.
+func (d *DcrtimeStore) webhooker() {
+	if len(d.cfg.WebhookURLs) != 0 {
+		log.Infof("Delivering anchor webhooks to %v receivers",
+			len(d.cfg.WebhookURLs))
+	}
+	if d.cfg.WebhookSubscriptions {
+		log.Infof("Delivering webhooks of subscriptions")
+	}
+
+	client := &http.Client{
+		Timeout: webhookTimeout,
+	}
+	ticker := time.NewTicker(webhookPoll)
+	defer ticker.Stop()
+	for {
+		if len(d.cfg.WebhookURLs) != 0 {
+			if err := d.enqueueWebhooks(); err != nil {
+				log.Errorf("Webhooks: enqueue: %v", err)
+			}
+		}
+		if d.cfg.WebhookSubscriptions {
+			err := d.enqueueSubscriptions()
+			if err != nil {
+				log.Errorf("Webhooks: subscriptions: %v", err)
+			}
+		}
+		if err := d.deliverWebhooks(client); err != nil {
+			log.Errorf("Webhooks: deliver: %v", err)
+		}
+
+		select {
+		case <-d.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// webhooksV2 returns all pending and dead webhook deliveries.
+// Handles /v2/admin/webhooks
+func (d *DcrtimeStore) webhooksV2(w http.ResponseWriter, r *http.Request) {
+	if !d.isAuthorized(r, v2.TokenScopeAdmin) {
+		d.respondNotAuthorized(w, r)
+		return
+	}
+
+	deliveries, err := d.backend.GetDeliveries()
+	if err != nil {
+		errorCode := time.Now().Unix()
+		log.Errorf("%v Webhooks error code %v: %v", logAddr(r),
+			errorCode, err)
+		util.RespondWithError(w, http.StatusInternalServerError,
+			fmt.Sprintf("failed to retrieve webhook deliveries, "+
+				"contact administrator and provide "+
+				"the following error code: %v", errorCode))
+		return
+	}
+
+	reply := v2.WebhooksReply{
+		Deliveries: make([]v2.WebhookDelivery, 0, len(deliveries)),
+	}
+	for _, wd := range deliveries {
+		reply.Deliveries = append(reply.Deliveries, convertDelivery(wd))
+	}
+
+	log.Infof("%v Webhooks %v", r.URL.Path, logAddr(r))
+
+	util.RespondWithJSON(w, http.StatusOK, reply)
+}
+
+// decodeDeliveryID decodes the request body into v and validates the
+// delivery ID it carries.  It replies to the client and returns false if the
+// request is invalid.
+func decodeDeliveryID(w http.ResponseWriter, body io.Reader, v interface{}, id *string) bool {
+	decoder := json.NewDecoder(body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		util.RespondWithError(w, http.StatusBadRequest,
+			"Invalid request payload")
+		return false
+	}
+	if !v2.RegexpDeliveryID.MatchString(*id) {
+		util.RespondWithError(w, http.StatusBadRequest,
+			"Invalid delivery ID")
+		return false
+	}
+	return true
+}
+
+// webhookRetryV2 resets the attempts of a webhook delivery and schedules it
+// right away.  Dead deliveries are taken out of the dead-letter queue.
+// Handles /v2/admin/webhooks/retry
+func (d *DcrtimeStore) webhookRetryV2(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	if !d.isAuthorized(r, v2.TokenScopeAdmin) {
+		d.respondNotAuthorized(w, r)
+		return
+	}
+
+	var wr v2.WebhookRetry
+	if !decodeDeliveryID(w, r.Body, &wr, &wr.ID) {
+		return
+	}
+
+	err := func() error {
+		d.webhookMtx.Lock()
+		defer d.webhookMtx.Unlock()
+
+		wd, err := d.backend.GetDelivery(wr.ID)
+		if err != nil {
+			return err
+		}
+		wd.Attempts = 0
+		wd.Dead = false
+		wd.NextAttempt = time.Now().Unix()
+		return d.backend.UpdateDelivery(*wd)
+	}()
+	if errors.Is(err, backend.ErrDeliveryNotFound) {
+		util.RespondWithError(w, http.StatusNotFound,
+			"Delivery not found")
+		return
+	} else if err != nil {
+		errorCode := time.Now().Unix()
+		log.Errorf("%v WebhookRetry error code %v: %v", logAddr(r),
+			errorCode, err)
+		util.RespondWithError(w, http.StatusInternalServerError,
+			fmt.Sprintf("failed to retry webhook delivery, "+
+				"contact administrator and provide "+
+				"the following error code: %v", errorCode))
+		return
+	}
+
+	log.Infof("%v WebhookRetry %v: %v", r.URL.Path, logAddr(r), wr.ID)
+
+	util.RespondWithJSON(w, http.StatusOK, v2.WebhookRetryReply{
+		ID: wr.ID,
+	})
+}
+
+// webhookDeleteV2 discards a webhook delivery, e.g. a dead delivery that will
+// never be accepted.
+// Handles /v2/admin/webhooks/delete
+func (d *DcrtimeStore) webhookDeleteV2(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	if !d.isAuthorized(r, v2.TokenScopeAdmin) {
+		d.respondNotAuthorized(w, r)
+		return
+	}
+
+	var wd v2.WebhookDelete
+	if !decodeDeliveryID(w, r.Body, &wd, &wd.ID) {
+		return
+	}
+
+	d.webhookMtx.Lock()
+	err := d.backend.DeleteDelivery(wd.ID)
+	d.webhookMtx.Unlock()
+	if errors.Is(err, backend.ErrDeliveryNotFound) {
+		util.RespondWithError(w, http.StatusNotFound,
+			"Delivery not found")
+		return
+	} else if err != nil {
+		errorCode := time.Now().Unix()
+		log.Errorf("%v WebhookDelete error code %v: %v", logAddr(r),
+			errorCode, err)
+		util.RespondWithError(w, http.StatusInternalServerError,
+			fmt.Sprintf("failed to delete webhook delivery, "+
+				"contact administrator and provide "+
+				"the following error code: %v", errorCode))
+		return
+	}
+
+	log.Infof("%v WebhookDelete %v: %v", r.URL.Path, logAddr(r), wd.ID)
+
+	util.RespondWithJSON(w, http.StatusOK, v2.WebhookDeleteReply{
+		ID: wd.ID,
+	})
+}
+
+func (d *DcrtimeStore) proxyWebhooksV2(w http.ResponseWriter, r *http.Request) {
+	d.sendToBackend(r.Context(), w, r.Method,
+		withAPIToken(v2.WebhooksRoute, r), r.Header.Get("Content-Type"),
+		r.RemoteAddr, bytes.NewReader([]byte{}))
+
+	log.Infof("%v Webhooks %v", r.URL.Path, logAddr(r))
+}
+
+func (d *DcrtimeStore) proxyWebhookRetryV2(w http.ResponseWriter, r *http.Request) {
+	b, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		util.RespondWithError(w, http.StatusBadRequest,
+			"Unable to read request")
+		return
+	}
+
+	var wr v2.WebhookRetry
+	if !decodeDeliveryID(w, bytes.NewReader(b), &wr, &wr.ID) {
+		return
+	}
+
+	d.sendToBackend(r.Context(), w, r.Method,
+		withAPIToken(v2.WebhookRetryRoute, r), r.Header.Get("Content-Type"),
+		r.RemoteAddr, bytes.NewReader(b))
+
+	log.Infof("%v WebhookRetry %v: %v", r.URL.Path, logAddr(r), wr.ID)
+}
+
+func (d *DcrtimeStore) proxyWebhookDeleteV2(w http.ResponseWriter, r *http.Request) {
+	b, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		util.RespondWithError(w, http.StatusBadRequest,
+			"Unable to read request")
+		return
+	}
+
+	var wd v2.WebhookDelete
+	if !decodeDeliveryID(w, bytes.NewReader(b), &wd, &wd.ID) {
+		return
+	}
+
+	d.sendToBackend(r.Context(), w, r.Method,
+		withAPIToken(v2.WebhookDeleteRoute, r), r.Header.Get("Content-Type"),
+		r.RemoteAddr, bytes.NewReader(b))
+
+	log.Infof("%v WebhookDelete %v: %v", r.URL.Path, logAddr(r), wd.ID)
+}
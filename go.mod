@@ -8,17 +8,23 @@ require (
 	github.com/decred/dcrd/certgen v1.1.2
 	github.com/decred/dcrd/chaincfg/chainhash v1.0.4
 	github.com/decred/dcrd/chaincfg/v3 v3.2.0
+	github.com/decred/dcrd/dcrec/secp256k1/v4 v4.2.0
 	github.com/decred/dcrd/dcrutil/v4 v4.0.1
 	github.com/decred/dcrd/txscript/v4 v4.1.0
 	github.com/decred/dcrd/wire v1.6.0
 	github.com/decred/dcrdata/api/types/v5 v5.0.1
 	github.com/decred/dcrtime/api/v2 v2.1.0
 	github.com/decred/slog v1.2.0
+	github.com/fsnotify/fsnotify v1.4.9
 	github.com/gorilla/handlers v1.5.1
 	github.com/gorilla/mux v1.8.0
 	github.com/jessevdk/go-flags v1.5.0
 	github.com/jrick/logrotate v1.0.0
 	github.com/robfig/cron v1.2.0
+	github.com/segmentio/kafka-go v0.4.47
 	github.com/syndtr/goleveldb v1.0.1-0.20210819022825-2ae1ddf74ef7
+	golang.org/x/crypto v0.14.0
 	google.golang.org/grpc v1.45.0
 )
+
+replace github.com/decred/dcrtime/api/v2 => ./api/v2
@@ -218,6 +218,13 @@ type Branch struct {
 
 // AuthPath returns a Merkle tree authentication path.
 func AuthPath(leaves []*[sha256.Size]byte, hash *[sha256.Size]byte) *Branch {
+	return MultiAuthPath(leaves, []*[sha256.Size]byte{hash})
+}
+
+// MultiAuthPath returns a single Merkle tree authentication path for all
+// provided hashes.  It is smaller than the separate paths of the hashes since
+// shared nodes are only included once.
+func MultiAuthPath(leaves []*[sha256.Size]byte, hashes []*[sha256.Size]byte) *Branch {
 	numLeaves := uint32(len(leaves))
 	if numLeaves == 0 {
 		return nil
@@ -228,12 +235,18 @@ func AuthPath(leaves []*[sha256.Size]byte, hash *[sha256.Size]byte) *Branch {
 		allHashes:   leaves,
 	}
 
+	matched := make(map[[sha256.Size]byte]struct{}, len(hashes))
+	for _, hash := range hashes {
+		matched[*hash] = struct{}{}
+	}
 	for _, v := range ap.allHashes {
-		if v != nil && *v == *hash {
-			ap.matchedBits = append(ap.matchedBits, 0x01)
-		} else {
-			ap.matchedBits = append(ap.matchedBits, 0x00)
+		bit := byte(0x00)
+		if v != nil {
+			if _, ok := matched[*v]; ok {
+				bit = 0x01
+			}
 		}
+		ap.matchedBits = append(ap.matchedBits, bit)
 	}
 
 	// Calculate the number of merkle branches (height) in the tree.
@@ -277,9 +290,15 @@ type merkleBranch struct {
 
 // extract recurses over the merkleBranch and returns the merkle root.
 func (m *merkleBranch) extract(height, pos uint32) (*[sha256.Size]byte, error) {
+	if m.bitsUsed >= uint32(len(m.bits)) {
+		return nil, fmt.Errorf("not enough flag bits")
+	}
 	parentOfMatch := m.bits[m.bitsUsed]
 	m.bitsUsed++
 	if height == 0 || parentOfMatch == 0 {
+		if m.hashUsed >= uint32(len(m.inHashes)) {
+			return nil, fmt.Errorf("not enough hashes")
+		}
 		hash := m.inHashes[m.hashUsed]
 		m.hashUsed++
 		if height == 0 && parentOfMatch == 1 {
@@ -309,8 +328,15 @@ func (m *merkleBranch) extract(height, pos uint32) (*[sha256.Size]byte, error) {
 
 // VerifyAuthPath takes a Branch and ensures that it is a valid tree.
 func VerifyAuthPath(mb *Branch) (*[sha256.Size]byte, error) {
+	merkleRoot, _, err := verifyAuthPath(mb)
+	return merkleRoot, err
+}
+
+// verifyAuthPath ensures that the Branch is a valid tree and returns its
+// merkle root and the leaves it authenticates.
+func verifyAuthPath(mb *Branch) (*[sha256.Size]byte, [][sha256.Size]byte, error) {
 	if mb.NumLeaves == 0 || len(mb.Hashes) == 0 {
-		return nil, ErrEmpty
+		return nil, nil, ErrEmpty
 	}
 
 	m := &merkleBranch{
@@ -322,18 +348,18 @@ func VerifyAuthPath(mb *Branch) (*[sha256.Size]byte, error) {
 	height := uint32(math.Ceil(math.Log2(float64(mb.NumLeaves))))
 	merkleRoot, err := m.extract(height, 0)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	// Validate that we consumed all bits and bobs.
 	flagByte := int(m.bitsUsed / 8)
 	if flagByte+1 < len(mb.Flags) && mb.Flags[flagByte] > 1<<m.bitsUsed%8 {
-		return nil, fmt.Errorf("did not consume all flag bits")
+		return nil, nil, fmt.Errorf("did not consume all flag bits")
 	}
 
 	if m.hashUsed != uint32(len(mb.Hashes)) {
-		return nil, fmt.Errorf("did not consume all hashes")
+		return nil, nil, fmt.Errorf("did not consume all hashes")
 	}
 
-	return merkleRoot, nil
+	return merkleRoot, m.hashes, nil
 }
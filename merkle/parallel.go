@@ -0,0 +1,110 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package merkle
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"sync"
+)
+
+// minParallelLeaves is the minimum number of leaves hashed by a single
+// goroutine of ParallelRoot.  Smaller sub-trees are not worth a goroutine.
+const minParallelLeaves = 1 << 12
+
+// ParallelRoot returns the merkle root of the provided leaves, which is
+// identical to the root Tree and Stream calculate, on up to workers
+// goroutines.  The leaves must be distinct and sorted in ascending order, like
+// the leaves of a Stream, and are not modified.  ErrUnsorted is returned
+// otherwise.
+//
+// Every goroutine streams a sub-tree of the leaves whose size is a power of
+// two, so that the sub-trees are nodes of the complete tree, and the roots of
+// the sub-trees are combined at the end.  Like a Stream it does not keep the
+// inner nodes of the tree in memory.
+func ParallelRoot(leaves []*[sha256.Size]byte, workers int) (*[sha256.Size]byte, error) {
+	n := len(leaves)
+	if n == 0 {
+		return nil, nil
+	}
+	if workers < 1 {
+		workers = 1
+	}
+	size := nextPowerOfTwo((n + workers - 1) / workers)
+	if size < minParallelLeaves {
+		size = minParallelLeaves
+	}
+	if size >= n {
+		return streamRoot(leaves, 0)
+	}
+
+	// The height of the sub-trees in the complete tree.
+	height := uint32(0)
+	for 1<<height < size {
+		height++
+	}
+
+	roots := make([]*[sha256.Size]byte, (n+size-1)/size)
+	errs := make([]error, len(roots))
+	var wg sync.WaitGroup
+	for i := range roots {
+		start := i * size
+		end := start + size
+		if end > n {
+			end = n
+		}
+		if start > 0 && bytes.Compare(leaves[start-1][:],
+			leaves[start][:]) >= 0 {
+			return nil, ErrUnsorted
+		}
+
+		wg.Add(1)
+		go func(i int, leaves []*[sha256.Size]byte) {
+			defer wg.Done()
+			roots[i], errs[i] = streamRoot(leaves, height)
+		}(i, leaves[start:end])
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Combine the roots of the sub-trees.  A node without a right sibling
+	// is hashed with itself, exactly like Tree does.
+	for len(roots) > 1 {
+		parents := make([]*[sha256.Size]byte, 0, (len(roots)+1)/2)
+		for i := 0; i < len(roots); i += 2 {
+			right := roots[i]
+			if i+1 < len(roots) {
+				right = roots[i+1]
+			}
+			parents = append(parents, concatDigests(roots[i], right))
+		}
+		roots = parents
+	}
+
+	return roots[0], nil
+}
+
+// streamRoot returns the root of the sub-tree of the provided leaves at the
+// provided height of the complete tree.  The sub-tree of the last leaves of
+// the tree may be lower than that, its root is then hashed with itself up to
+// the height like Tree does with a node that has no right sibling.  A height
+// of zero returns the root of the leaves.
+func streamRoot(leaves []*[sha256.Size]byte, height uint32) (*[sha256.Size]byte, error) {
+	s := NewStream()
+	for _, leaf := range leaves {
+		if err := s.Add(leaf); err != nil {
+			return nil, err
+		}
+	}
+	root := s.Root()
+	for h := s.height(); h < height; h++ {
+		root = concatDigests(root, root)
+	}
+	return root, nil
+}
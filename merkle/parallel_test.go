@@ -0,0 +1,49 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package merkle
+
+import (
+	"crypto/sha256"
+	"testing"
+)
+
+func TestParallelRoot(t *testing.T) {
+	counts := []int{1, 2, 3, minParallelLeaves - 1, minParallelLeaves,
+		minParallelLeaves + 1, 2*minParallelLeaves + 1,
+		3*minParallelLeaves + 5, 5*minParallelLeaves - 7}
+	for _, count := range counts {
+		leaves := streamLeaves(count)
+		want := Root(append([]*[sha256.Size]byte{}, leaves...))
+		for _, workers := range []int{0, 1, 2, 3, 8} {
+			root, err := ParallelRoot(leaves, workers)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *root != *want {
+				t.Fatalf("count %v workers %v: got root %x, "+
+					"want %x", count, workers, *root, *want)
+			}
+		}
+	}
+
+	root, err := ParallelRoot(nil, 4)
+	if err != nil || root != nil {
+		t.Fatalf("empty: got %v %v", root, err)
+	}
+}
+
+func TestParallelRootUnsorted(t *testing.T) {
+	leaves := streamLeaves(3 * minParallelLeaves)
+
+	// Swap leaves within a sub-tree and across sub-trees.
+	for _, i := range []int{10, minParallelLeaves - 1} {
+		unsorted := append([]*[sha256.Size]byte{}, leaves...)
+		unsorted[i], unsorted[i+1] = unsorted[i+1], unsorted[i]
+		_, err := ParallelRoot(unsorted, 3)
+		if err != ErrUnsorted {
+			t.Fatalf("swap %v: got %v, want ErrUnsorted", i, err)
+		}
+	}
+}
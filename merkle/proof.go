@@ -0,0 +1,226 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package merkle
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// branchVersion is the version of the binary Branch encoding.
+const branchVersion = 1
+
+var (
+	// ErrNotIncluded is returned when a Branch does not authenticate the
+	// provided leaf.
+	ErrNotIncluded = errors.New("leaf not included in merkle branch")
+
+	// ErrRootMismatch is returned when a Branch does not lead to the
+	// provided merkle root.
+	ErrRootMismatch = errors.New("merkle root mismatch")
+)
+
+// EncodeBranch returns the compact binary encoding of a Branch:
+//
+//	version (1 byte) | leaves (uint32 LE) | hash count (uvarint) | hashes |
+//	flags length (uvarint) | flags
+func EncodeBranch(mb *Branch) []byte {
+	b := make([]byte, 0, 1+4+2*binary.MaxVarintLen64+
+		len(mb.Hashes)*sha256.Size+len(mb.Flags))
+	b = append(b, branchVersion)
+	b = binary.LittleEndian.AppendUint32(b, mb.NumLeaves)
+	b = binary.AppendUvarint(b, uint64(len(mb.Hashes)))
+	for _, hash := range mb.Hashes {
+		b = append(b, hash[:]...)
+	}
+	b = binary.AppendUvarint(b, uint64(len(mb.Flags)))
+	b = append(b, mb.Flags...)
+	return b
+}
+
+// DecodeBranch decodes a Branch that was encoded with EncodeBranch.  The
+// Branch is not verified.
+func DecodeBranch(b []byte) (*Branch, error) {
+	if len(b) < 5 {
+		return nil, fmt.Errorf("branch too short")
+	}
+	if b[0] != branchVersion {
+		return nil, fmt.Errorf("unsupported branch version %v", b[0])
+	}
+	mb := &Branch{
+		NumLeaves: binary.LittleEndian.Uint32(b[1:5]),
+	}
+	b = b[5:]
+
+	count, n := binary.Uvarint(b)
+	if n <= 0 || count > uint64(len(b)-n)/sha256.Size {
+		return nil, fmt.Errorf("invalid hash count")
+	}
+	b = b[n:]
+	mb.Hashes = make([][sha256.Size]byte, count)
+	for i := range mb.Hashes {
+		copy(mb.Hashes[i][:], b)
+		b = b[sha256.Size:]
+	}
+
+	flags, n := binary.Uvarint(b)
+	if n <= 0 || flags != uint64(len(b)-n) {
+		return nil, fmt.Errorf("invalid flags length")
+	}
+	mb.Flags = append([]byte{}, b[n:]...)
+
+	return mb, nil
+}
+
+// BranchJSON is the JSON representation of a Branch.  Hashes and flags are
+// hex encoded.
+type BranchJSON struct {
+	NumLeaves uint32   `json:"numleaves"`
+	Hashes    []string `json:"hashes"`
+	Flags     string   `json:"flags"`
+}
+
+// EncodeBranchJSON returns the JSON encoding of a Branch.
+func EncodeBranchJSON(mb *Branch) ([]byte, error) {
+	bj := BranchJSON{
+		NumLeaves: mb.NumLeaves,
+		Hashes:    make([]string, 0, len(mb.Hashes)),
+		Flags:     hex.EncodeToString(mb.Flags),
+	}
+	for _, hash := range mb.Hashes {
+		bj.Hashes = append(bj.Hashes, hex.EncodeToString(hash[:]))
+	}
+	return json.Marshal(bj)
+}
+
+// DecodeBranchJSON decodes a Branch that was encoded with EncodeBranchJSON.
+// The Branch is not verified.
+func DecodeBranchJSON(b []byte) (*Branch, error) {
+	var bj BranchJSON
+	if err := json.Unmarshal(b, &bj); err != nil {
+		return nil, err
+	}
+
+	flags, err := hex.DecodeString(bj.Flags)
+	if err != nil {
+		return nil, fmt.Errorf("invalid flags: %v", err)
+	}
+	mb := &Branch{
+		NumLeaves: bj.NumLeaves,
+		Hashes:    make([][sha256.Size]byte, len(bj.Hashes)),
+		Flags:     flags,
+	}
+	for i, h := range bj.Hashes {
+		hash, err := hex.DecodeString(h)
+		if err != nil || len(hash) != sha256.Size {
+			return nil, fmt.Errorf("invalid hash %v", i)
+		}
+		copy(mb.Hashes[i][:], hash)
+	}
+
+	return mb, nil
+}
+
+// VerifyInclusion verifies that the Branch is valid, that it authenticates
+// exactly the provided leaf and that it leads to the provided merkle root.
+func VerifyInclusion(mb *Branch, leaf, root *[sha256.Size]byte) error {
+	merkleRoot, leaves, err := verifyAuthPath(mb)
+	if err != nil {
+		return err
+	}
+	if len(leaves) != 1 || leaves[0] != *leaf {
+		return ErrNotIncluded
+	}
+	if *merkleRoot != *root {
+		return ErrRootMismatch
+	}
+	return nil
+}
+
+// VerifyInclusions verifies that the Branch is valid, that it authenticates
+// exactly the provided leaves, in tree order, and that it leads to the
+// provided merkle root.
+func VerifyInclusions(mb *Branch, leaves []*[sha256.Size]byte, root *[sha256.Size]byte) error {
+	merkleRoot, included, err := verifyAuthPath(mb)
+	if err != nil {
+		return err
+	}
+	if len(included) != len(leaves) {
+		return ErrNotIncluded
+	}
+	for i := range included {
+		if included[i] != *leaves[i] {
+			return ErrNotIncluded
+		}
+	}
+	if *merkleRoot != *root {
+		return ErrRootMismatch
+	}
+	return nil
+}
+
+// Step is a single step of the path from a leaf to the merkle root.  The next
+// node is the digest of the concatenation of the current node and Hash, or of
+// Hash and the current node if Left is set.
+type Step struct {
+	Hash [sha256.Size]byte
+	Left bool
+}
+
+// path recurses over a verified merkleBranch and appends the steps from the
+// matched leaf to the root.  It returns the hash of the node and whether the
+// matched leaf is part of its sub-tree.
+func (m *merkleBranch) path(height, pos uint32, steps *[]Step) (*[sha256.Size]byte, bool) {
+	parentOfMatch := m.bits[m.bitsUsed]
+	m.bitsUsed++
+	if height == 0 || parentOfMatch == 0 {
+		hash := m.inHashes[m.hashUsed]
+		m.hashUsed++
+		return &hash, height == 0 && parentOfMatch == 1
+	}
+
+	left, leftMatch := m.path(height-1, pos*2, steps)
+	right, rightMatch := left, false
+	if pos*2+1 < calcTreeWidth(m.numLeaves, height-1) {
+		right, rightMatch = m.path(height-1, pos*2+1, steps)
+	}
+	switch {
+	case leftMatch:
+		*steps = append(*steps, Step{Hash: *right})
+	case rightMatch:
+		*steps = append(*steps, Step{Hash: *left, Left: true})
+	}
+
+	return concatDigests(left, right), leftMatch || rightMatch
+}
+
+// Path returns the steps from the provided leaf to the merkle root of a Branch
+// that authenticates exactly that leaf.  This is the form other proof formats
+// express inclusion in.
+func Path(mb *Branch, leaf *[sha256.Size]byte) ([]Step, error) {
+	_, leaves, err := verifyAuthPath(mb)
+	if err != nil {
+		return nil, err
+	}
+	if len(leaves) != 1 || leaves[0] != *leaf {
+		return nil, ErrNotIncluded
+	}
+
+	m := &merkleBranch{
+		bits:      bytes2bits(mb.Flags),
+		inHashes:  mb.Hashes,
+		numLeaves: mb.NumLeaves,
+	}
+	height := uint32(math.Ceil(math.Log2(float64(mb.NumLeaves))))
+	steps := make([]Step, 0, height)
+	m.path(height, 0, &steps)
+
+	return steps, nil
+}
@@ -0,0 +1,157 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package merkle
+
+import (
+	"crypto/sha256"
+	"reflect"
+	"testing"
+)
+
+func TestEncodeBranch(t *testing.T) {
+	for count := 1; count < 20; count++ {
+		leaves := streamLeaves(count)
+		root := Root(append([]*[sha256.Size]byte{}, leaves...))
+		for _, leaf := range leaves {
+			mb := AuthPath(leaves, leaf)
+
+			b, err := DecodeBranch(EncodeBranch(mb))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(b, mb) {
+				t.Fatalf("binary: got %v, want %v", b, mb)
+			}
+
+			j, err := EncodeBranchJSON(mb)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err = DecodeBranchJSON(j)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(b, mb) {
+				t.Fatalf("json: got %v, want %v", b, mb)
+			}
+
+			if err := VerifyInclusion(b, leaf, root); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestDecodeBranchInvalid(t *testing.T) {
+	leaves := streamLeaves(5)
+	b := EncodeBranch(AuthPath(leaves, leaves[2]))
+
+	tests := [][]byte{
+		nil,
+		b[:4],
+		append([]byte{0x02}, b[1:]...),
+		b[:len(b)-1],
+		append(append([]byte{}, b...), 0x00),
+	}
+	for k, test := range tests {
+		if _, err := DecodeBranch(test); err == nil {
+			t.Fatalf("%v: expected error", k)
+		}
+	}
+
+	jsonTests := []string{
+		`{"numleaves":1,"hashes":["00"],"flags":"01"}`,
+		`{"numleaves":1,"hashes":[],"flags":"zz"}`,
+		`[]`,
+	}
+	for k, test := range jsonTests {
+		if _, err := DecodeBranchJSON([]byte(test)); err == nil {
+			t.Fatalf("json %v: expected error", k)
+		}
+	}
+}
+
+func TestVerifyInclusionInvalid(t *testing.T) {
+	leaves := streamLeaves(5)
+	root := Root(append([]*[sha256.Size]byte{}, leaves...))
+	mb := AuthPath(leaves, leaves[2])
+
+	if err := VerifyInclusion(mb, leaves[3], root); err != ErrNotIncluded {
+		t.Fatalf("got %v, want %v", err, ErrNotIncluded)
+	}
+	if err := VerifyInclusion(mb, leaves[2], leaves[0]); err != ErrRootMismatch {
+		t.Fatalf("got %v, want %v", err, ErrRootMismatch)
+	}
+
+	// Truncated branches must fail instead of panicking.
+	truncated := *mb
+	truncated.Hashes = mb.Hashes[:1]
+	if err := VerifyInclusion(&truncated, leaves[2], root); err == nil {
+		t.Fatalf("expected error")
+	}
+	truncated = *mb
+	truncated.Flags = nil
+	if err := VerifyInclusion(&truncated, leaves[2], root); err == nil {
+		t.Fatalf("expected error")
+	}
+}
+
+func TestPath(t *testing.T) {
+	for count := 1; count < 20; count++ {
+		leaves := streamLeaves(count)
+		root := Root(append([]*[sha256.Size]byte{}, leaves...))
+		for _, leaf := range leaves {
+			steps, err := Path(AuthPath(leaves, leaf), leaf)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			node := leaf
+			for _, step := range steps {
+				hash := step.Hash
+				if step.Left {
+					node = concatDigests(&hash, node)
+				} else {
+					node = concatDigests(node, &hash)
+				}
+			}
+			if *node != *root {
+				t.Fatalf("%v leaves: path does not lead to root",
+					count)
+			}
+		}
+	}
+
+	leaves := streamLeaves(4)
+	_, err := Path(AuthPath(leaves, leaves[1]), leaves[2])
+	if err != ErrNotIncluded {
+		t.Fatalf("got %v, want %v", err, ErrNotIncluded)
+	}
+}
+
+func TestMultiAuthPath(t *testing.T) {
+	for count := 1; count < 20; count++ {
+		leaves := streamLeaves(count)
+		root := Root(append([]*[sha256.Size]byte{}, leaves...))
+		for start := 0; start < count; start++ {
+			// Every third leaf from start.
+			var matched []*[sha256.Size]byte
+			for i := start; i < count; i += 3 {
+				matched = append(matched, leaves[i])
+			}
+			mb := MultiAuthPath(leaves, matched)
+			if err := VerifyInclusions(mb, matched, root); err != nil {
+				t.Fatalf("%v leaves from %v: %v", count, start, err)
+			}
+			if len(matched) > 1 {
+				err := VerifyInclusions(mb, matched[1:], root)
+				if err != ErrNotIncluded {
+					t.Fatalf("got %v, want %v", err,
+						ErrNotIncluded)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,282 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package merkle
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+)
+
+// defaultChunkHeight is the height of the sub-trees that are built in memory
+// while the auth paths of a Stream are calculated.  A Stream keeps one hash
+// for every 2^defaultChunkHeight leaves in memory.
+const defaultChunkHeight = 10
+
+var (
+	// ErrUnsorted is returned when the leaves of a Stream are not added in
+	// strictly ascending order.
+	ErrUnsorted = errors.New("leaves not sorted or duplicate")
+
+	// ErrLeavesChanged is returned when the leaves that are provided to
+	// calculate the auth paths of a Stream differ from the added leaves.
+	ErrLeavesChanged = errors.New("leaves changed")
+)
+
+// Stream incrementally builds the merkle tree of a large number of leaves
+// without keeping the tree in memory.  The leaves must be distinct and added
+// in ascending order, which is the order Tree sorts them in, so that the root
+// and the auth paths are identical to those of Tree and AuthPath.
+//
+// The root is calculated in a single pass with memory that is logarithmic in
+// the number of leaves.  The auth paths of all leaves are calculated in a
+// second pass over the same leaves that only keeps the upper levels of the
+// tree and a single sub-tree of the leaves in memory.
+type Stream struct {
+	chunkHeight uint32
+	numLeaves   uint32
+	last        *[sha256.Size]byte     // Last added leaf
+	pending     []*[sha256.Size]byte   // Unpaired complete node per level
+	upper       [][]*[sha256.Size]byte // Nodes at and above chunkHeight
+	root        *[sha256.Size]byte
+}
+
+// NewStream returns an empty Stream.
+func NewStream() *Stream {
+	return &Stream{
+		chunkHeight: defaultChunkHeight,
+	}
+}
+
+// record keeps a node of the upper levels of the tree.
+func (s *Stream) record(height uint32, node *[sha256.Size]byte) {
+	if height < s.chunkHeight {
+		return
+	}
+	h := height - s.chunkHeight
+	for uint32(len(s.upper)) <= h {
+		s.upper = append(s.upper, nil)
+	}
+	s.upper[h] = append(s.upper[h], node)
+}
+
+// Add adds the next leaf.  It returns ErrUnsorted if the leaf does not sort
+// after the previous one.  Leaves can not be added once the root was calculated.
+func (s *Stream) Add(leaf *[sha256.Size]byte) error {
+	if s.root != nil {
+		return fmt.Errorf("root already calculated")
+	}
+	if s.last != nil && bytes.Compare(s.last[:], leaf[:]) >= 0 {
+		return ErrUnsorted
+	}
+	l := *leaf
+	s.last = &l
+	s.numLeaves++
+
+	// Combine the new node with the unpaired node of its level until it is
+	// the unpaired node of a level itself.
+	node := s.last
+	height := uint32(0)
+	s.record(height, node)
+	for ; height < uint32(len(s.pending)) && s.pending[height] != nil; height++ {
+		node = concatDigests(s.pending[height], node)
+		s.pending[height] = nil
+		s.record(height+1, node)
+	}
+	if height == uint32(len(s.pending)) {
+		s.pending = append(s.pending, nil)
+	}
+	s.pending[height] = node
+
+	return nil
+}
+
+// NumLeaves returns the number of added leaves.
+func (s *Stream) NumLeaves() uint32 {
+	return s.numLeaves
+}
+
+// height returns the height of the tree.
+func (s *Stream) height() uint32 {
+	height := uint32(0)
+	for calcTreeWidth(s.numLeaves, height) > 1 {
+		height++
+	}
+	return height
+}
+
+// Root returns the merkle root of the added leaves or nil if no leaves were
+// added.  No leaves can be added afterwards.
+func (s *Stream) Root() *[sha256.Size]byte {
+	if s.root != nil || s.numLeaves == 0 {
+		return s.root
+	}
+
+	// Complete the right edge of the tree.  A node without a right sibling
+	// is hashed with itself, exactly like Tree does.
+	height := s.height()
+	var carry *[sha256.Size]byte
+	for h := uint32(0); h < height; h++ {
+		var left *[sha256.Size]byte
+		if h < uint32(len(s.pending)) {
+			left = s.pending[h]
+		}
+		switch {
+		case left != nil && carry != nil:
+			carry = concatDigests(left, carry)
+		case left != nil:
+			carry = concatDigests(left, left)
+		case carry != nil:
+			carry = concatDigests(carry, carry)
+		}
+		if carry != nil {
+			s.record(h+1, carry)
+		}
+	}
+	if carry == nil {
+		s.root = s.pending[height]
+	} else {
+		s.root = carry
+	}
+
+	return s.root
+}
+
+// branch returns the auth path of the leaf at the provided position.  The
+// hashes of the siblings on the path from the leaf to the root are provided
+// by sibling, which returns nil if a node has no right sibling.
+func (s *Stream) branch(pos uint32, leaf *[sha256.Size]byte, sibling func(height, pos uint32) *[sha256.Size]byte) *Branch {
+	var (
+		flags  []byte
+		hashes [][sha256.Size]byte
+		visit  func(height uint32)
+	)
+	visit = func(height uint32) {
+		flags = append(flags, 0x01)
+		if height == 0 {
+			hashes = append(hashes, *leaf)
+			return
+		}
+
+		// Siblings are visited in the same depth-first order as
+		// AuthPath does.
+		child := pos >> (height - 1)
+		if child&1 == 1 {
+			flags = append(flags, 0x00)
+			hashes = append(hashes, *sibling(height-1, child^1))
+			visit(height - 1)
+			return
+		}
+		visit(height - 1)
+		if right := sibling(height-1, child^1); right != nil {
+			flags = append(flags, 0x00)
+			hashes = append(hashes, *right)
+		}
+	}
+	visit(s.height())
+
+	b := &Branch{
+		NumLeaves: s.numLeaves,
+		Hashes:    hashes,
+		Flags:     make([]byte, (len(flags)+7)/8),
+	}
+	for i, flag := range flags {
+		b.Flags[i/8] |= flag << (uint(i) % 8)
+	}
+	return b
+}
+
+// AuthPaths calculates the auth path of every added leaf.  The leaves must be
+// provided again, in the same order, by next which returns nil once all
+// leaves were provided.  f is called with every leaf and its auth path in
+// order.  ErrLeavesChanged is returned if the provided leaves differ from the
+// added leaves.
+func (s *Stream) AuthPaths(next func() (*[sha256.Size]byte, error), f func(leaf *[sha256.Size]byte, b *Branch) error) error {
+	if s.Root() == nil {
+		return ErrEmpty
+	}
+
+	height := s.height()
+	top := s.chunkHeight
+	if height < top {
+		top = height
+	}
+	chunkSize := uint32(1) << s.chunkHeight
+	chunk := make([]*[sha256.Size]byte, 0, chunkSize)
+	var offset uint32
+	for offset < s.numLeaves {
+		// Read the leaves of the next sub-tree.
+		chunk = chunk[:0]
+		for uint32(len(chunk)) < chunkSize && offset+uint32(len(chunk)) < s.numLeaves {
+			leaf, err := next()
+			if err != nil {
+				return err
+			}
+			if leaf == nil {
+				return ErrLeavesChanged
+			}
+			l := *leaf
+			chunk = append(chunk, &l)
+		}
+
+		// Build the sub-tree up to the chunk height, or the root if
+		// the tree is lower.  levels[h] holds the nodes at height h.
+		levels := [][]*[sha256.Size]byte{chunk}
+		for h := uint32(1); h <= top; h++ {
+			below := levels[h-1]
+			level := make([]*[sha256.Size]byte, 0, (len(below)+1)/2)
+			for i := 0; i < len(below); i += 2 {
+				right := below[i]
+				if i+1 < len(below) {
+					right = below[i+1]
+				}
+				level = append(level, concatDigests(below[i], right))
+			}
+			levels = append(levels, level)
+		}
+
+		// The top of the sub-tree must match the node of the first
+		// pass.
+		want := s.root
+		if top < height {
+			want = s.upper[0][offset>>s.chunkHeight]
+		}
+		if *levels[top][0] != *want {
+			return ErrLeavesChanged
+		}
+
+		sibling := func(h, pos uint32) *[sha256.Size]byte {
+			if h >= s.chunkHeight {
+				level := s.upper[h-s.chunkHeight]
+				if pos >= uint32(len(level)) {
+					return nil
+				}
+				return level[pos]
+			}
+			local := pos - (offset >> h)
+			if local >= uint32(len(levels[h])) {
+				return nil
+			}
+			return levels[h][local]
+		}
+		for i, leaf := range chunk {
+			err := f(leaf, s.branch(offset+uint32(i), leaf, sibling))
+			if err != nil {
+				return err
+			}
+		}
+		offset += uint32(len(chunk))
+	}
+
+	leaf, err := next()
+	if err != nil {
+		return err
+	}
+	if leaf != nil {
+		return ErrLeavesChanged
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package merkle
+
+import (
+	"crypto/sha256"
+	"reflect"
+	"testing"
+)
+
+// streamLeaves returns count distinct leaves in the order Tree sorts them.
+func streamLeaves(count int) []*[sha256.Size]byte {
+	hashes := make([]*[sha256.Size]byte, 0, count)
+	for i := 0; i < count; i++ {
+		hash := sha256.Sum256([]byte{byte(i), byte(i >> 8)})
+		hashes = append(hashes, &hash)
+	}
+	return Tree(hashes)[:count]
+}
+
+// leafIterator returns a function that returns the provided leaves one at a
+// time and nil once all leaves were returned.
+func leafIterator(leaves []*[sha256.Size]byte) func() (*[sha256.Size]byte, error) {
+	return func() (*[sha256.Size]byte, error) {
+		if len(leaves) == 0 {
+			return nil, nil
+		}
+		leaf := leaves[0]
+		leaves = leaves[1:]
+		return leaf, nil
+	}
+}
+
+func TestStream(t *testing.T) {
+	for _, chunkHeight := range []uint32{0, 1, 2, 3, defaultChunkHeight} {
+		for count := 1; count < 70; count++ {
+			leaves := streamLeaves(count)
+			s := NewStream()
+			s.chunkHeight = chunkHeight
+			for _, leaf := range leaves {
+				if err := s.Add(leaf); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			want := Root(append([]*[sha256.Size]byte{}, leaves...))
+			if *s.Root() != *want {
+				t.Fatalf("chunk height %v count %v: got root %x, "+
+					"want %x", chunkHeight, count, *s.Root(),
+					*want)
+			}
+
+			i := 0
+			err := s.AuthPaths(leafIterator(leaves),
+				func(leaf *[sha256.Size]byte, b *Branch) error {
+					if *leaf != *leaves[i] {
+						t.Fatalf("got leaf %x, want %x",
+							*leaf, *leaves[i])
+					}
+					want := AuthPath(leaves, leaves[i])
+					if !reflect.DeepEqual(b, want) {
+						t.Fatalf("chunk height %v count %v "+
+							"leaf %v: got %v, want %v",
+							chunkHeight, count, i, b, want)
+					}
+					i++
+					return nil
+				})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if i != count {
+				t.Fatalf("got %v auth paths, want %v", i, count)
+			}
+		}
+	}
+}
+
+func TestStreamInvalid(t *testing.T) {
+	s := NewStream()
+	if s.Root() != nil {
+		t.Fatalf("expected nil root")
+	}
+	err := s.AuthPaths(leafIterator(nil),
+		func(*[sha256.Size]byte, *Branch) error { return nil })
+	if err != ErrEmpty {
+		t.Fatalf("got %v, want %v", err, ErrEmpty)
+	}
+
+	leaves := streamLeaves(5)
+	for _, leaf := range leaves {
+		if err := s.Add(leaf); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Add(leaves[4]); err != ErrUnsorted {
+		t.Fatalf("got %v, want %v", err, ErrUnsorted)
+	}
+	if err := s.Add(leaves[0]); err != ErrUnsorted {
+		t.Fatalf("got %v, want %v", err, ErrUnsorted)
+	}
+
+	noop := func(*[sha256.Size]byte, *Branch) error { return nil }
+	changed := append([]*[sha256.Size]byte{}, leaves...)
+	changed[2] = leaves[3]
+	tests := [][]*[sha256.Size]byte{
+		leaves[:4],
+		append(leaves, leaves[0]),
+		changed,
+	}
+	for k, test := range tests {
+		err := s.AuthPaths(leafIterator(test), noop)
+		if err != ErrLeavesChanged {
+			t.Fatalf("%v: got %v, want %v", k, err, ErrLeavesChanged)
+		}
+	}
+}
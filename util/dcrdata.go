@@ -19,12 +19,16 @@ func extractNullDataMerkleRootV0(script []byte) []byte {
 	// Thus, it can either be a single OP_RETURN or an OP_RETURN followed by a
 	// canonical data push up to MaxDataCarrierSizeV0 bytes.
 	//
-	// When it houses a Merkle root, there will be a single push of 32 bytes.
-	if len(script) == 34 &&
+	// When it houses a Merkle root, there will be a single push of 32 bytes,
+	// optionally preceded by the anchor prefix of the operator in the same
+	// push.  The Merkle root is always the last 32 bytes.
+	if len(script) >= 34 &&
 		script[0] == txscript.OP_RETURN &&
-		script[1] == txscript.OP_DATA_32 {
+		script[1] >= txscript.OP_DATA_32 &&
+		script[1] <= txscript.OP_DATA_75 &&
+		int(script[1]) == len(script)-2 {
 
-		return script[2:34]
+		return script[len(script)-32:]
 	}
 
 	return nil
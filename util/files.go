@@ -9,13 +9,19 @@ import (
 
 // DigestFile returns the SHA256 of a file.
 func DigestFile(filename string) (string, error) {
-	h := sha256.New()
 	f, err := os.Open(filename)
 	if err != nil {
 		return "", err
 	}
 	defer f.Close()
-	if _, err = io.Copy(h, f); err != nil {
+
+	return DigestReader(f)
+}
+
+// DigestReader returns the SHA256 of everything that is read from r.
+func DigestReader(r io.Reader) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
 		return "", err
 	}
 